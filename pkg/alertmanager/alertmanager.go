@@ -114,6 +114,14 @@ type Alertmanager struct {
 	configHashMetric prometheus.Gauge
 
 	rateLimitedNotifications *prometheus.CounterVec
+
+	// Rate limiters of the notifications, by integration. They are shared by all the receivers and the
+	// test receiver API, and kept across config reloads.
+	notificationLimitersMtx sync.Mutex
+	notificationLimiters    map[string]*rate.Limiter
+
+	// Tracks the outcome of the notifications sent by each receiver integration.
+	receiversHealth *receiversHealth
 }
 
 var (
@@ -171,6 +179,7 @@ func New(cfg *Config, reg *prometheus.Registry) (*Alertmanager, error) {
 	}
 
 	am.registry = reg
+	am.receiversHealth = newReceiversHealth()
 
 	// We currently have 3 operational modes:
 	// 1) Alertmanager clustering with upstream Gossip
@@ -285,6 +294,8 @@ func New(cfg *Config, reg *prometheus.Registry) (*Alertmanager, error) {
 		am.mux.Handle(a, http.NotFoundHandler())
 	}
 
+	am.mux.HandleFunc(path.Join(am.cfg.ExternalURL.Path, receiversHealthPath), am.receiversHealthHandler)
+
 	am.dispatcherMetrics = dispatch.NewDispatcherMetrics(true, am.registry)
 
 	//TODO: From this point onward, the alertmanager _might_ receive requests - we need to make sure we've settled and are ready.
@@ -352,7 +363,7 @@ func (am *Alertmanager) ApplyConfig(userID string, conf *config.Config, rawCfg s
 	// Create a firewall binded to the per-tenant config.
 	firewallDialer := util_net.NewFirewallDialer(newFirewallDialerConfigProvider(userID, am.cfg.Limits))
 
-	integrationsMap, err := buildIntegrationsMap(conf.Receivers, tmpl, firewallDialer, am.logger, func(integrationName string, notifier notify.Notifier) notify.Notifier {
+	integrationsMap, err := buildIntegrationsMap(conf.Receivers, tmpl, firewallDialer, am.logger, func(receiverName, integrationName string, index int, notifier notify.Notifier) notify.Notifier {
 		if am.cfg.Limits != nil {
 			rl := &tenantRateLimits{
 				tenant:      userID,
@@ -360,14 +371,18 @@ func (am *Alertmanager) ApplyConfig(userID string, conf *config.Config, rawCfg s
 				integration: integrationName,
 			}
 
-			return newRateLimitedNotifier(notifier, rl, 10*time.Second, am.rateLimitedNotifications.WithLabelValues(integrationName))
+			notifier = newRateLimitedNotifierWithLimiter(notifier, am.getNotificationLimiter(integrationName, rl), rl, 10*time.Second, am.rateLimitedNotifications.WithLabelValues(integrationName))
 		}
-		return notifier
+
+		// Track the health outside of the rate limiter, so that rate limited notifications are reported too.
+		return newHealthTrackingNotifier(notifier, am.receiversHealth, receiverName, integrationName, index)
 	})
 	if err != nil {
 		return nil
 	}
 
+	am.receiversHealth.setIntegrations(integrationsMap)
+
 	muteTimes := make(map[string][]timeinterval.TimeInterval, len(conf.MuteTimeIntervals))
 	for _, ti := range conf.MuteTimeIntervals {
 		muteTimes[ti.Name] = ti.TimeIntervals
@@ -455,9 +470,29 @@ func (am *Alertmanager) getFullState() (*clusterpb.FullState, error) {
 	return nil, errors.New("ring-based sharding not enabled")
 }
 
+// notifierWrapperFunc wraps the notifier of the integration at the given index of a receiver.
+type notifierWrapperFunc func(receiverName, integrationName string, index int, notifier notify.Notifier) notify.Notifier
+
+// getNotificationLimiter returns the rate limiter of the notifications sent by the given integration.
+func (am *Alertmanager) getNotificationLimiter(integration string, limits rateLimits) *rate.Limiter {
+	am.notificationLimitersMtx.Lock()
+	defer am.notificationLimitersMtx.Unlock()
+
+	if am.notificationLimiters == nil {
+		am.notificationLimiters = map[string]*rate.Limiter{}
+	}
+
+	limiter, ok := am.notificationLimiters[integration]
+	if !ok {
+		limiter = rate.NewLimiter(limits.RateLimit(), limits.Burst())
+		am.notificationLimiters[integration] = limiter
+	}
+	return limiter
+}
+
 // buildIntegrationsMap builds a map of name to the list of integration notifiers off of a
 // list of receiver config.
-func buildIntegrationsMap(nc []*config.Receiver, tmpl *template.Template, firewallDialer *util_net.FirewallDialer, logger log.Logger, notifierWrapper func(string, notify.Notifier) notify.Notifier) (map[string][]notify.Integration, error) {
+func buildIntegrationsMap(nc []*config.Receiver, tmpl *template.Template, firewallDialer *util_net.FirewallDialer, logger log.Logger, notifierWrapper notifierWrapperFunc) (map[string][]notify.Integration, error) {
 	integrationsMap := make(map[string][]notify.Integration, len(nc))
 	for _, rcv := range nc {
 		integrations, err := buildReceiverIntegrations(rcv, tmpl, firewallDialer, logger, notifierWrapper)
@@ -472,7 +507,7 @@ func buildIntegrationsMap(nc []*config.Receiver, tmpl *template.Template, firewa
 // buildReceiverIntegrations builds a list of integration notifiers off of a
 // receiver config.
 // Taken from https://github.com/prometheus/alertmanager/blob/94d875f1227b29abece661db1a68c001122d1da5/cmd/alertmanager/main.go#L112-L159.
-func buildReceiverIntegrations(nc *config.Receiver, tmpl *template.Template, firewallDialer *util_net.FirewallDialer, logger log.Logger, wrapper func(string, notify.Notifier) notify.Notifier) ([]notify.Integration, error) {
+func buildReceiverIntegrations(nc *config.Receiver, tmpl *template.Template, firewallDialer *util_net.FirewallDialer, logger log.Logger, wrapper notifierWrapperFunc) ([]notify.Integration, error) {
 	var (
 		errs         types.MultiError
 		integrations []notify.Integration
@@ -482,7 +517,7 @@ func buildReceiverIntegrations(nc *config.Receiver, tmpl *template.Template, fir
 				errs.Add(err)
 				return
 			}
-			n = wrapper(name, n)
+			n = wrapper(nc.Name, name, i, n)
 			integrations = append(integrations, notify.NewIntegration(n, rs, name, i))
 		}
 	)
@@ -2,11 +2,13 @@ package alertmanager
 
 import (
 	"net/http"
+	"sort"
 	"text/template"
 
 	"github.com/go-kit/log/level"
 	"github.com/grafana/dskit/services"
 
+	"github.com/cortexproject/cortex/pkg/alertmanager/merger"
 	util_log "github.com/cortexproject/cortex/pkg/util/log"
 )
 
@@ -51,6 +53,29 @@ var (
                 <p>No peers</p>
                 {{ end }}
             {{ end }}
+            <h2>Receivers</h2>
+            <p>Notifications sent by the receivers of the tenants running on this instance.</p>
+            {{ range .Tenants }}
+                <h3>Tenant {{ html .UserID }}</h3>
+                <table border="1" cellpadding="5" style="border-collapse: collapse">
+                    <tr><th>Receiver</th><th>Integration</th><th>Last attempt</th><th>Successes</th><th>Failures</th><th>Last error</th><th>Last error at</th></tr>
+                    {{ range $receiver := .Receivers }}
+                    {{ range .Integrations }}
+                    <tr>
+                        <td>{{ html $receiver.Name }}</td>
+                        <td>{{ .Name }}[{{ .Index }}]</td>
+                        <td>{{ if .LastAttempt.IsZero }}never{{ else }}{{ .LastAttempt }}{{ end }}</td>
+                        <td>{{ .SuccessCount }}</td>
+                        <td>{{ .FailureCount }}</td>
+                        <td>{{ html .LastError }}</td>
+                        <td>{{ if not .LastErrorAt.IsZero }}{{ .LastErrorAt }}{{ end }}</td>
+                    </tr>
+                    {{ end }}
+                    {{ end }}
+                </table>
+            {{ else }}
+                <p>No tenants</p>
+            {{ end }}
         </body>
     </html>`))
 )
@@ -94,6 +119,11 @@ type StatusHandler struct {
 	am *MultitenantAlertmanager
 }
 
+type tenantReceiversHealth struct {
+	UserID    string
+	Receivers []merger.ReceiverHealth
+}
+
 // ServeHTTP serves the status of the alertmanager.
 func (s StatusHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	var clusterInfo map[string]interface{}
@@ -102,11 +132,33 @@ func (s StatusHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	}
 	err := statusTemplate.Execute(w, struct {
 		ClusterInfo map[string]interface{}
+		Tenants     []tenantReceiversHealth
 	}{
 		ClusterInfo: clusterInfo,
+		Tenants:     s.am.getTenantsReceiversHealth(),
 	})
 	if err != nil {
 		level.Error(util_log.Logger).Log("msg", "unable to serve alertmanager status page", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// getTenantsReceiversHealth returns the health of the receivers of each tenant running on this
+// instance, ordered by tenant.
+func (am *MultitenantAlertmanager) getTenantsReceiversHealth() []tenantReceiversHealth {
+	am.alertmanagersMtx.Lock()
+	tenants := make([]tenantReceiversHealth, 0, len(am.alertmanagers))
+	for userID, userAM := range am.alertmanagers {
+		tenants = append(tenants, tenantReceiversHealth{
+			UserID:    userID,
+			Receivers: userAM.receiversHealth.receivers(),
+		})
+	}
+	am.alertmanagersMtx.Unlock()
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].UserID < tenants[j].UserID
+	})
+
+	return tenants
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/alertmanager/cluster"
+	"github.com/prometheus/alertmanager/notify"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
@@ -54,6 +55,11 @@ func TestMultitenantAlertmanager_GetStatusHandler(t *testing.T) {
 		peer = peer2
 	}
 
+	health := newReceiversHealth()
+	health.setIntegrations(map[string][]notify.Integration{
+		"team-a": {notify.NewIntegration(nil, nil, "webhook", 0)},
+	})
+
 	for _, tt := range []struct {
 		am        *MultitenantAlertmanager
 		content   string
@@ -69,6 +75,16 @@ func TestMultitenantAlertmanager_GetStatusHandler(t *testing.T) {
 			content:   "Members",
 			nocontent: "No peers",
 		},
+		{
+			am:        &MultitenantAlertmanager{peer: nil},
+			content:   "No tenants",
+			nocontent: "Tenant user-1",
+		},
+		{
+			am:        &MultitenantAlertmanager{peer: nil, alertmanagers: map[string]*Alertmanager{"user-1": {receiversHealth: health}}},
+			content:   "<td>team-a</td>",
+			nocontent: "No tenants",
+		},
 	} {
 		req := httptest.NewRequest("GET", "http://alertmanager.cortex/status", nil)
 		w := httptest.NewRecorder()
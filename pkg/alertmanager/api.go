@@ -102,6 +102,57 @@ func (am *MultitenantAlertmanager) SetUserConfig(w http.ResponseWriter, r *http.
 		return
 	}
 
+	cfg := &UserConfig{}
+	if !am.readUserConfigRequest(w, r, logger, userID, cfg) {
+		return
+	}
+
+	cfgDesc := alertspb.ToProto(cfg.AlertmanagerConfig, cfg.TemplateFiles, userID)
+	if err := validateUserConfig(logger, cfgDesc, am.limits, userID); err != nil {
+		level.Warn(logger).Log("msg", errValidatingConfig, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errValidatingConfig, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	err = am.store.SetAlertConfig(r.Context(), cfgDesc)
+	if err != nil {
+		level.Error(logger).Log("msg", errStoringConfiguration, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errStoringConfiguration, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ValidateUserConfig validates the Alertmanager configuration in the request body, the same way
+// SetUserConfig does, without storing it.
+func (am *MultitenantAlertmanager) ValidateUserConfig(w http.ResponseWriter, r *http.Request) {
+	logger := util_log.WithContext(r.Context(), am.logger)
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		level.Error(logger).Log("msg", errNoOrgID, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errNoOrgID, err.Error()), http.StatusUnauthorized)
+		return
+	}
+
+	cfg := &UserConfig{}
+	if !am.readUserConfigRequest(w, r, logger, userID, cfg) {
+		return
+	}
+
+	cfgDesc := alertspb.ToProto(cfg.AlertmanagerConfig, cfg.TemplateFiles, userID)
+	if err := validateUserConfig(logger, cfgDesc, am.limits, userID); err != nil {
+		level.Debug(logger).Log("msg", errValidatingConfig, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errValidatingConfig, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// readUserConfigRequest reads the YAML request body into out, enforcing the max configuration size
+// of the tenant. If the request can't be read, it writes the error response and returns false.
+func (am *MultitenantAlertmanager) readUserConfigRequest(w http.ResponseWriter, r *http.Request, logger log.Logger, userID string, out interface{}) bool {
 	var input io.Reader
 	maxConfigSize := am.limits.AlertmanagerMaxConfigSize(userID)
 	if maxConfigSize > 0 {
@@ -116,39 +167,24 @@ func (am *MultitenantAlertmanager) SetUserConfig(w http.ResponseWriter, r *http.
 	if err != nil {
 		level.Error(logger).Log("msg", errReadingConfiguration, "err", err.Error())
 		http.Error(w, fmt.Sprintf("%s: %s", errReadingConfiguration, err.Error()), http.StatusBadRequest)
-		return
+		return false
 	}
 
 	if maxConfigSize > 0 && len(payload) > maxConfigSize {
 		msg := fmt.Sprintf(errConfigurationTooBig, maxConfigSize)
 		level.Warn(logger).Log("msg", msg)
 		http.Error(w, msg, http.StatusBadRequest)
-		return
+		return false
 	}
 
-	cfg := &UserConfig{}
-	err = yaml.Unmarshal(payload, cfg)
+	err = yaml.Unmarshal(payload, out)
 	if err != nil {
 		level.Error(logger).Log("msg", errMarshallingYAML, "err", err.Error())
 		http.Error(w, fmt.Sprintf("%s: %s", errMarshallingYAML, err.Error()), http.StatusBadRequest)
-		return
-	}
-
-	cfgDesc := alertspb.ToProto(cfg.AlertmanagerConfig, cfg.TemplateFiles, userID)
-	if err := validateUserConfig(logger, cfgDesc, am.limits, userID); err != nil {
-		level.Warn(logger).Log("msg", errValidatingConfig, "err", err.Error())
-		http.Error(w, fmt.Sprintf("%s: %s", errValidatingConfig, err.Error()), http.StatusBadRequest)
-		return
+		return false
 	}
 
-	err = am.store.SetAlertConfig(r.Context(), cfgDesc)
-	if err != nil {
-		level.Error(logger).Log("msg", errStoringConfiguration, "err", err.Error())
-		http.Error(w, fmt.Sprintf("%s: %s", errStoringConfiguration, err.Error()), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusCreated)
+	return true
 }
 
 // DeleteUserConfig is exposed via user-visible API (if enabled, uses DELETE method), but also as an internal endpoint using POST method.
@@ -219,6 +255,21 @@ func validateUserConfig(logger log.Logger, cfg alertspb.AlertConfigDesc, limits
 		}
 	}
 
+	if _, err := buildUserTemplate(logger, cfg, amCfg); err != nil {
+		return err
+	}
+
+	// Note: Not validating the MultitenantAlertmanager.transformConfig function as that
+	// that function shouldn't break configuration. Only way it can fail is if the base
+	// autoWebhookURL itself is broken. In that case, I would argue, we should accept the config
+	// not reject it.
+
+	return nil
+}
+
+// buildUserTemplate parses the templates referenced by the Alertmanager config, reading them from
+// the template files of the user config.
+func buildUserTemplate(logger log.Logger, cfg alertspb.AlertConfigDesc, amCfg *config.Config) (*template.Template, error) {
 	// Create templates on disk in a temporary directory.
 	// Note: This means the validation will succeed if we can write to tmp but
 	// not to configured data dir, and on the flipside, it'll fail if we can't write
@@ -226,7 +277,7 @@ func validateUserConfig(logger log.Logger, cfg alertspb.AlertConfigDesc, limits
 	// we see this in the wild.
 	userTempDir, err := ioutil.TempDir("", "validate-config-"+cfg.User)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer os.RemoveAll(userTempDir)
 
@@ -234,12 +285,12 @@ func validateUserConfig(logger log.Logger, cfg alertspb.AlertConfigDesc, limits
 		templateFilepath, err := safeTemplateFilepath(userTempDir, tmpl.Filename)
 		if err != nil {
 			level.Error(logger).Log("msg", "unable to create template file path", "err", err, "user", cfg.User)
-			return err
+			return nil, err
 		}
 
 		if _, err = storeTemplateFile(templateFilepath, tmpl.Body); err != nil {
 			level.Error(logger).Log("msg", "unable to store template file", "err", err, "user", cfg.User)
-			return fmt.Errorf("unable to store template file '%s'", tmpl.Filename)
+			return nil, fmt.Errorf("unable to store template file '%s'", tmpl.Filename)
 		}
 	}
 
@@ -248,17 +299,7 @@ func validateUserConfig(logger log.Logger, cfg alertspb.AlertConfigDesc, limits
 		templateFiles[i] = filepath.Join(userTempDir, t)
 	}
 
-	_, err = template.FromGlobs(templateFiles...)
-	if err != nil {
-		return err
-	}
-
-	// Note: Not validating the MultitenantAlertmanager.transformConfig function as that
-	// that function shouldn't break configuration. Only way it can fail is if the base
-	// autoWebhookURL itself is broken. In that case, I would argue, we should accept the config
-	// not reject it.
-
-	return nil
+	return template.FromGlobs(templateFiles...)
 }
 
 func (am *MultitenantAlertmanager) ListAllConfigs(w http.ResponseWriter, r *http.Request) {
@@ -583,6 +583,97 @@ template_files:
 	}
 }
 
+func TestMultitenantAlertmanager_ValidateUserConfig(t *testing.T) {
+	testCases := []struct {
+		name          string
+		cfg           string
+		maxConfigSize int
+		err           error
+	}{
+		{
+			name: "Should pass on a valid config",
+			cfg: `
+alertmanager_config: |
+  route:
+    receiver: 'default-receiver'
+  receivers:
+    - name: default-receiver
+template_files:
+  "good.tpl": "good-templ"
+`,
+		},
+		{
+			name: "Should return error if the config is empty",
+			cfg: `
+template_files:
+  "good.tpl": "good-templ"
+`,
+			err: fmt.Errorf("error validating Alertmanager config: configuration provided is empty, if you'd like to remove your configuration please use the delete configuration endpoint"),
+		},
+		{
+			name: "Should return error if the template is invalid",
+			cfg: `
+alertmanager_config: |
+  route:
+    receiver: 'default-receiver'
+  receivers:
+    - name: default-receiver
+  templates:
+    - "bad.tpl"
+template_files:
+  "bad.tpl": "{{ invalid Go template }}"
+`,
+			err: fmt.Errorf(`error validating Alertmanager config: template: bad.tpl:1: function "invalid" not defined`),
+		},
+		{
+			name: "Should return error if the config is too big",
+			cfg: `
+alertmanager_config: |
+  route:
+    receiver: 'default-receiver'
+  receivers:
+    - name: default-receiver
+`,
+			maxConfigSize: 10,
+			err:           fmt.Errorf("Alertmanager configuration is too big, limit: 10 bytes"),
+		},
+	}
+
+	limits := &mockAlertManagerLimits{}
+	store := prepareInMemoryAlertStore()
+	am := &MultitenantAlertmanager{
+		store:  store,
+		logger: util_log.Logger,
+		limits: limits,
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits.maxConfigSize = tc.maxConfigSize
+
+			req := httptest.NewRequest(http.MethodPost, "http://alertmanager/api/v1/alerts/validate", bytes.NewReader([]byte(tc.cfg)))
+			ctx := user.InjectOrgID(req.Context(), "testing")
+			w := httptest.NewRecorder()
+			am.ValidateUserConfig(w, req.WithContext(ctx))
+			resp := w.Result()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			if tc.err == nil {
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				require.Equal(t, "", string(body))
+			} else {
+				require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				require.Equal(t, tc.err.Error()+"\n", string(body))
+			}
+
+			// The config should never be stored.
+			_, err = store.GetAlertConfig(context.Background(), "testing")
+			require.Equal(t, alertspb.ErrNotFound, err)
+		})
+	}
+}
+
 func TestMultitenantAlertmanager_DeleteUserConfig(t *testing.T) {
 	storage := objstore.NewInMemBucket()
 	alertStore := bucketclient.NewBucketAlertStore(storage, nil, log.NewNopLogger())
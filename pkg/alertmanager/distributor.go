@@ -69,7 +69,8 @@ func (d *Distributor) running(ctx context.Context) error {
 func (d *Distributor) IsPathSupported(p string) bool {
 	// API can be found at https://petstore.swagger.io/?url=https://raw.githubusercontent.com/prometheus/alertmanager/master/api/v2/openapi.yaml.
 	isQuorumReadPath, _ := d.isQuorumReadPath(p)
-	return d.isQuorumWritePath(p) || d.isUnaryWritePath(p) || d.isUnaryDeletePath(p) || d.isUnaryReadPath(p) || isQuorumReadPath
+	isAllReadPath, _ := d.isAllReadPath(p)
+	return d.isQuorumWritePath(p) || d.isUnaryWritePath(p) || d.isUnaryDeletePath(p) || d.isUnaryReadPath(p) || isQuorumReadPath || isAllReadPath
 }
 
 func (d *Distributor) isQuorumWritePath(p string) bool {
@@ -109,6 +110,15 @@ func (d *Distributor) isQuorumReadPath(p string) (bool, merger.Merger) {
 	return false, nil
 }
 
+// isAllReadPath returns whether the given route is read from all the replicas of the tenant,
+// because each replica only holds part of the data (eg. the notifications it has sent).
+func (d *Distributor) isAllReadPath(p string) (bool, merger.Merger) {
+	if strings.HasSuffix(p, "/v1/receivers/health") {
+		return true, merger.ReceiversHealth{}
+	}
+	return false, nil
+}
+
 func (d *Distributor) isUnaryReadPath(p string) bool {
 	return strings.HasSuffix(p, "/status") ||
 		strings.HasSuffix(p, "/receivers")
@@ -151,6 +161,10 @@ func (d *Distributor) DistributeRequest(w http.ResponseWriter, r *http.Request)
 			d.doQuorum(userID, w, r, logger, m)
 			return
 		}
+		if ok, m := d.isAllReadPath(r.URL.Path); ok {
+			d.doAll(userID, w, r, logger, m)
+			return
+		}
 		if d.isUnaryReadPath(r.URL.Path) {
 			d.doUnary(userID, w, r, logger)
 			return
@@ -224,6 +238,63 @@ func (d *Distributor) doQuorum(userID string, w http.ResponseWriter, r *http.Req
 	}
 }
 
+// doAll sends the request to all the replicas of the tenant and merges their responses, waiting for
+// all of them. The replicas failing to respond are skipped, so the request fails only if all of them do.
+func (d *Distributor) doAll(userID string, w http.ResponseWriter, r *http.Request, logger log.Logger, m merger.Merger) {
+	replicationSet, err := d.alertmanagerRing.Get(shardByUser(userID), RingOp, nil, nil, nil)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to get replication set from the ring", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	req := &httpgrpc.HTTPRequest{
+		Method:  r.Method,
+		Url:     r.RequestURI,
+		Headers: httpToHttpgrpcHeaders(r.Header),
+	}
+
+	sp, ctx := opentracing.StartSpanFromContext(r.Context(), "Distributor.doAll")
+	defer sp.Finish()
+
+	var (
+		wg           sync.WaitGroup
+		responsesMtx sync.Mutex
+		responses    []*httpgrpc.HTTPResponse
+		lastErr      error
+	)
+
+	for _, am := range replicationSet.Instances {
+		wg.Add(1)
+		go func(am ring.InstanceDesc) {
+			defer wg.Done()
+
+			resp, err := d.doRequest(ctx, am, req)
+			if err == nil && resp.Code/100 != 2 {
+				err = httpgrpc.ErrorFromHTTPResponse(resp)
+			}
+
+			responsesMtx.Lock()
+			defer responsesMtx.Unlock()
+
+			if err != nil {
+				level.Warn(logger).Log("msg", "failed to process the request to an alertmanager replica, skipping it", "alertmanager", am.Addr, "err", err)
+				lastErr = err
+				return
+			}
+			responses = append(responses, resp)
+		}(am)
+	}
+	wg.Wait()
+
+	if len(responses) == 0 {
+		respondFromError(lastErr, w, logger)
+		return
+	}
+
+	respondFromMultipleHTTPGRPCResponses(w, logger, responses, m)
+}
+
 func (d *Distributor) doUnary(userID string, w http.ResponseWriter, r *http.Request, logger log.Logger) {
 	key := shardByUser(userID)
 	replicationSet, err := d.alertmanagerRing.Get(key, RingOp, nil, nil, nil)
@@ -130,6 +130,36 @@ func TestDistributor_DistributeRequest(t *testing.T) {
 			expectedTotalCalls: 3,
 			route:              "/v1/silences",
 			responseBody:       []byte(`{"status":"success","data":[]}`),
+		}, {
+			name:               "Read /v1/receivers/health is sent to 3 AMs",
+			numAM:              5,
+			numHappyAM:         5,
+			replicationFactor:  3,
+			isRead:             true,
+			expStatusCode:      http.StatusOK,
+			expectedTotalCalls: 3,
+			route:              "/v1/receivers/health",
+			responseBody:       []byte(`{"status":"success","data":[]}`),
+		}, {
+			name:               "Read /v1/receivers/health succeeds if less than quorum AM available",
+			numAM:              3,
+			numHappyAM:         1,
+			replicationFactor:  3,
+			isRead:             true,
+			expStatusCode:      http.StatusOK,
+			expectedTotalCalls: 3,
+			route:              "/v1/receivers/health",
+			responseBody:       []byte(`{"status":"success","data":[]}`),
+		}, {
+			name:               "Read /v1/receivers/health fails if no AM available",
+			numAM:              3,
+			numHappyAM:         0,
+			replicationFactor:  3,
+			isRead:             true,
+			expStatusCode:      http.StatusInternalServerError,
+			expectedTotalCalls: 3,
+			route:              "/v1/receivers/health",
+			responseBody:       []byte(`{"status":"success","data":[]}`),
 		}, {
 			name:               "Read /v2/silences is sent to 3 AMs",
 			numAM:              5,
@@ -290,6 +320,7 @@ func TestDistributor_IsPathSupported(t *testing.T) {
 		"/alertmanager/api/v1/silence/really":   true,
 		"/alertmanager/api/v1/status":           true,
 		"/alertmanager/api/v1/receivers":        true,
+		"/alertmanager/api/v1/receivers/health": true,
 		"/alertmanager/api/v1/other":            false,
 		"/alertmanager/api/v2/alerts":           true,
 		"/alertmanager/api/v2/alerts/groups":    true,
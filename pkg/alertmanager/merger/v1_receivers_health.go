@@ -0,0 +1,131 @@
+package merger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ReceiverHealth is the health of the notifications sent by a receiver, as returned by the
+// GET /api/v1/receivers/health endpoint.
+type ReceiverHealth struct {
+	Name         string              `json:"name"`
+	Integrations []IntegrationHealth `json:"integrations"`
+}
+
+// IntegrationHealth is the health of the notifications sent by a single integration of a receiver.
+type IntegrationHealth struct {
+	// Name is the integration type (eg. "slack", "pagerduty").
+	Name string `json:"name"`
+	// Index is the position of the integration within the receiver integrations of the same type.
+	Index int `json:"index"`
+
+	// LastAttempt is the time of the last notification attempt. Zero if never attempted.
+	LastAttempt time.Time `json:"lastAttempt"`
+	// LastError is the error of the last failed notification attempt. Empty if no attempt failed.
+	LastError string `json:"lastError"`
+	// LastErrorAt is the time of the last failed notification attempt. Zero if no attempt failed.
+	LastErrorAt time.Time `json:"lastErrorAt"`
+
+	SuccessCount uint64 `json:"successCount"`
+	FailureCount uint64 `json:"failureCount"`
+}
+
+// ReceiversHealth implements the Merger interface for GET /api/v1/receivers/health. It returns the
+// union of receivers and integrations over all the responses. Since each replica tracks only the
+// notifications it has sent, the counters are summed while the last attempt and last error are taken
+// from the replica which most recently attempted or failed.
+type ReceiversHealth struct{}
+
+func (ReceiversHealth) MergeResponses(in [][]byte) ([]byte, error) {
+	type bodyType struct {
+		Status string           `json:"status"`
+		Data   []ReceiverHealth `json:"data"`
+	}
+
+	receivers := make([]ReceiverHealth, 0)
+	for _, body := range in {
+		parsed := bodyType{}
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return nil, err
+		}
+		if parsed.Status != statusSuccess {
+			return nil, fmt.Errorf("unable to merge response of status: %s", parsed.Status)
+		}
+		receivers = append(receivers, parsed.Data...)
+	}
+
+	body := bodyType{
+		Status: statusSuccess,
+		Data:   mergeReceiversHealth(receivers),
+	}
+
+	return json.Marshal(body)
+}
+
+func mergeReceiversHealth(in []ReceiverHealth) []ReceiverHealth {
+	type integrationKey struct {
+		name  string
+		index int
+	}
+
+	merged := map[string]map[integrationKey]IntegrationHealth{}
+	for _, receiver := range in {
+		integrations, ok := merged[receiver.Name]
+		if !ok {
+			integrations = map[integrationKey]IntegrationHealth{}
+			merged[receiver.Name] = integrations
+		}
+
+		for _, integration := range receiver.Integrations {
+			key := integrationKey{name: integration.Name, index: integration.Index}
+			existing, ok := integrations[key]
+			if !ok {
+				integrations[key] = integration
+				continue
+			}
+
+			existing.SuccessCount += integration.SuccessCount
+			existing.FailureCount += integration.FailureCount
+			if integration.LastAttempt.After(existing.LastAttempt) {
+				existing.LastAttempt = integration.LastAttempt
+			}
+			if integration.LastErrorAt.After(existing.LastErrorAt) {
+				existing.LastError = integration.LastError
+				existing.LastErrorAt = integration.LastErrorAt
+			}
+			integrations[key] = existing
+		}
+	}
+
+	result := make([]ReceiverHealth, 0, len(merged))
+	for name, integrations := range merged {
+		receiver := ReceiverHealth{
+			Name:         name,
+			Integrations: make([]IntegrationHealth, 0, len(integrations)),
+		}
+		for _, integration := range integrations {
+			receiver.Integrations = append(receiver.Integrations, integration)
+		}
+		SortIntegrationsHealth(receiver.Integrations)
+		result = append(result, receiver)
+	}
+
+	// Mimic the Alertmanager which returns receivers ordered by name.
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// SortIntegrationsHealth sorts the integrations by name and index.
+func SortIntegrationsHealth(integrations []IntegrationHealth) {
+	sort.Slice(integrations, func(i, j int) bool {
+		if integrations[i].Name != integrations[j].Name {
+			return integrations[i].Name < integrations[j].Name
+		}
+		return integrations[i].Index < integrations[j].Index
+	})
+}
@@ -0,0 +1,97 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiversHealth(t *testing.T) {
+
+	// This test is to check the parsing round-trip is working as expected, the merging logic is
+	// tested in TestMergeReceiversHealth.
+
+	in := [][]byte{
+		[]byte(`{"status":"success","data":[` +
+			`{"name":"team-a","integrations":[` +
+			`{"name":"slack","index":0,"lastAttempt":"2021-04-21T09:47:32Z","lastError":"",` +
+			`"lastErrorAt":"0001-01-01T00:00:00Z","successCount":3,"failureCount":0}]}]}`),
+		[]byte(`{"status":"success","data":[` +
+			`{"name":"team-a","integrations":[` +
+			`{"name":"slack","index":0,"lastAttempt":"2021-04-21T09:48:32Z","lastError":"unexpected status code 500",` +
+			`"lastErrorAt":"2021-04-21T09:48:32Z","successCount":1,"failureCount":2}]}]}`),
+		[]byte(`{"status":"success","data":[]}`),
+	}
+
+	expected := []byte(`{"status":"success","data":[` +
+		`{"name":"team-a","integrations":[` +
+		`{"name":"slack","index":0,"lastAttempt":"2021-04-21T09:48:32Z","lastError":"unexpected status code 500",` +
+		`"lastErrorAt":"2021-04-21T09:48:32Z","successCount":4,"failureCount":2}]}]}`)
+
+	out, err := ReceiversHealth{}.MergeResponses(in)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(out))
+}
+
+func TestReceiversHealth_ShouldFailOnUnsuccessfulResponses(t *testing.T) {
+	in := [][]byte{
+		[]byte(`{"status":"success","data":[]}`),
+		[]byte(`{"status":"error","data":[]}`),
+	}
+
+	_, err := ReceiversHealth{}.MergeResponses(in)
+	require.EqualError(t, err, "unable to merge response of status: error")
+}
+
+func TestMergeReceiversHealth(t *testing.T) {
+	var (
+		t1 = time.Unix(100, 0).UTC()
+		t2 = time.Unix(200, 0).UTC()
+		t3 = time.Unix(300, 0).UTC()
+	)
+
+	cases := map[string]struct {
+		in       []ReceiverHealth
+		expected []ReceiverHealth
+	}{
+		"no receivers": {
+			in:       []ReceiverHealth{},
+			expected: []ReceiverHealth{},
+		},
+		"distinct receivers are returned sorted by name": {
+			in: []ReceiverHealth{
+				{Name: "b", Integrations: []IntegrationHealth{{Name: "webhook", SuccessCount: 1}}},
+				{Name: "a", Integrations: []IntegrationHealth{{Name: "webhook", Index: 1}, {Name: "email"}, {Name: "webhook"}}},
+			},
+			expected: []ReceiverHealth{
+				{Name: "a", Integrations: []IntegrationHealth{{Name: "email"}, {Name: "webhook"}, {Name: "webhook", Index: 1}}},
+				{Name: "b", Integrations: []IntegrationHealth{{Name: "webhook", SuccessCount: 1}}},
+			},
+		},
+		"same integration on multiple replicas is merged": {
+			in: []ReceiverHealth{
+				{Name: "a", Integrations: []IntegrationHealth{
+					{Name: "slack", LastAttempt: t3, LastError: "first", LastErrorAt: t1, SuccessCount: 2, FailureCount: 1},
+				}},
+				{Name: "a", Integrations: []IntegrationHealth{
+					{Name: "slack", LastAttempt: t2, LastError: "second", LastErrorAt: t2, SuccessCount: 1, FailureCount: 1},
+				}},
+				{Name: "a", Integrations: []IntegrationHealth{
+					{Name: "slack"},
+				}},
+			},
+			expected: []ReceiverHealth{
+				{Name: "a", Integrations: []IntegrationHealth{
+					{Name: "slack", LastAttempt: t3, LastError: "second", LastErrorAt: t2, SuccessCount: 3, FailureCount: 2},
+				}},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expected, mergeReceiversHealth(c.in))
+		})
+	}
+}
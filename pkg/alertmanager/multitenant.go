@@ -169,6 +169,7 @@ func (cfg *MultitenantAlertmanagerConfig) Validate(storageCfg alertstore.Config)
 type multitenantAlertmanagerMetrics struct {
 	lastReloadSuccessful          *prometheus.GaugeVec
 	lastReloadSuccessfulTimestamp *prometheus.GaugeVec
+	testReceiverNotifications     *prometheus.CounterVec
 }
 
 func newMultitenantAlertmanagerMetrics(reg prometheus.Registerer) *multitenantAlertmanagerMetrics {
@@ -186,6 +187,12 @@ func newMultitenantAlertmanagerMetrics(reg prometheus.Registerer) *multitenantAl
 		Help:      "Timestamp of the last successful configuration reload.",
 	}, []string{"user"})
 
+	m.testReceiverNotifications = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "alertmanager_test_receiver_notifications_total",
+		Help:      "Total number of test notifications sent via the test receiver API, by integration and outcome.",
+	}, []string{"integration", "outcome"})
+
 	return m
 }
 
@@ -280,6 +287,11 @@ type MultitenantAlertmanager struct {
 
 	limits Limits
 
+	// Rate limiters of the notifications sent via the test receiver API, by tenant and integration,
+	// for the tenants whose Alertmanager is not running on this instance.
+	testReceiverLimitersMtx sync.Mutex
+	testReceiverLimiters    map[string]*testReceiverLimiter
+
 	registry          prometheus.Registerer
 	ringCheckErrors   prometheus.Counter
 	tenantsOwned      prometheus.Gauge
@@ -29,11 +29,17 @@ type rateLimitedNotifier struct {
 }
 
 func newRateLimitedNotifier(upstream notify.Notifier, limits rateLimits, recheckInterval time.Duration, counter prometheus.Counter) *rateLimitedNotifier {
+	return newRateLimitedNotifierWithLimiter(upstream, rate.NewLimiter(limits.RateLimit(), limits.Burst()), limits, recheckInterval, counter)
+}
+
+// newRateLimitedNotifierWithLimiter is like newRateLimitedNotifier but uses the provided limiter,
+// which can be shared between notifiers.
+func newRateLimitedNotifierWithLimiter(upstream notify.Notifier, limiter *rate.Limiter, limits rateLimits, recheckInterval time.Duration, counter prometheus.Counter) *rateLimitedNotifier {
 	return &rateLimitedNotifier{
 		upstream:        upstream,
 		counter:         counter,
 		limits:          limits,
-		limiter:         rate.NewLimiter(limits.RateLimit(), limits.Burst()),
+		limiter:         limiter,
 		recheckInterval: recheckInterval,
 	}
 }
@@ -0,0 +1,352 @@
+package alertmanager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/alertmanager/alertspb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+	util_net "github.com/cortexproject/cortex/pkg/util/net"
+)
+
+const (
+	errTestingReceiver = "unable to test the Alertmanager receiver"
+
+	// testReceiverTimeout is the max time allowed to each integration to deliver the test notification.
+	testReceiverTimeout = 30 * time.Second
+
+	testReceiverStatusSuccess = "success"
+	testReceiverStatusFailed  = "failed"
+)
+
+// TestReceiverRequest is the request body of the test receiver API. Either the name of a receiver
+// of the stored config or a receiver definition must be provided. A receiver definition is tested
+// using the global settings and templates of the stored config, if any.
+type TestReceiverRequest struct {
+	ReceiverName string            `yaml:"receiver_name"`
+	Receiver     yaml.MapSlice     `yaml:"receiver"`
+	Labels       map[string]string `yaml:"labels"`
+	Annotations  map[string]string `yaml:"annotations"`
+}
+
+// TestReceiverResponse is the response of the test receiver API.
+type TestReceiverResponse struct {
+	// Status is "success" if the test notification has been delivered by all integrations, "failed" otherwise.
+	Status   string               `json:"status"`
+	Receiver string               `json:"receiver"`
+	Results  []TestReceiverResult `json:"results"`
+}
+
+// TestReceiverResult is the delivery result of the test notification sent by an integration.
+type TestReceiverResult struct {
+	Integration string `json:"integration"`
+	Index       int    `json:"index"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	Duration    string `json:"duration"`
+}
+
+// TestUserReceiver sends a synthetic alert to the receiver in the request, through the same integrations used
+// to send the notifications, and returns the delivery result of each integration. The notifications are subject
+// to the tenant receivers firewall and notification rate limits.
+func (am *MultitenantAlertmanager) TestUserReceiver(w http.ResponseWriter, r *http.Request) {
+	logger := util_log.WithContext(r.Context(), am.logger)
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		level.Error(logger).Log("msg", errNoOrgID, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errNoOrgID, err.Error()), http.StatusUnauthorized)
+		return
+	}
+
+	req := &TestReceiverRequest{}
+	if !am.readUserConfigRequest(w, r, logger, userID, req) {
+		return
+	}
+
+	if (req.ReceiverName == "") == (len(req.Receiver) == 0) {
+		http.Error(w, fmt.Sprintf("%s: either the receiver name or the receiver definition must be provided", errTestingReceiver), http.StatusBadRequest)
+		return
+	}
+
+	storedCfg, err := am.store.GetAlertConfig(r.Context(), userID)
+	if err == alertspb.ErrNotFound {
+		if req.ReceiverName != "" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		storedCfg = alertspb.AlertConfigDesc{User: userID}
+	} else if err != nil {
+		level.Error(logger).Log("msg", errReadingConfiguration, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errReadingConfiguration, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	receiverName := req.ReceiverName
+	cfgDesc := storedCfg
+	if len(req.Receiver) > 0 {
+		receiverName, cfgDesc.RawConfig, err = buildTestReceiverConfig(storedCfg.RawConfig, req.Receiver)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", errTestingReceiver, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		// The stored config has already been validated, while the receiver definition has not.
+		if err := validateUserConfig(logger, cfgDesc, am.limits, userID); err != nil {
+			level.Debug(logger).Log("msg", errValidatingConfig, "err", err.Error())
+			http.Error(w, fmt.Sprintf("%s: %s", errValidatingConfig, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, err := am.testUserReceiver(r.Context(), logger, userID, cfgDesc, receiverName, req.Labels, req.Annotations)
+	if err != nil {
+		level.Warn(logger).Log("msg", errTestingReceiver, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errTestingReceiver, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	util.WriteJSONResponse(w, resp)
+}
+
+// buildTestReceiverConfig builds an Alertmanager config containing only the input receiver, and the
+// global settings and templates of the input config. Returns the name of the receiver and the config.
+func buildTestReceiverConfig(rawCfg string, receiver yaml.MapSlice) (string, string, error) {
+	var receiverName string
+	for _, item := range receiver {
+		if item.Key == "name" {
+			receiverName, _ = item.Value.(string)
+		}
+	}
+	if receiverName == "" {
+		return "", "", fmt.Errorf("missing name in receiver")
+	}
+
+	stored := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(rawCfg), &stored); err != nil {
+		return "", "", err
+	}
+
+	cfg := yaml.MapSlice{}
+	for _, item := range stored {
+		if item.Key == "global" || item.Key == "templates" {
+			cfg = append(cfg, item)
+		}
+	}
+	cfg = append(cfg,
+		yaml.MapItem{Key: "route", Value: yaml.MapSlice{{Key: "receiver", Value: receiverName}}},
+		yaml.MapItem{Key: "receivers", Value: []yaml.MapSlice{receiver}},
+	)
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", "", err
+	}
+	return receiverName, string(out), nil
+}
+
+func (am *MultitenantAlertmanager) testUserReceiver(ctx context.Context, logger log.Logger, userID string, cfgDesc alertspb.AlertConfigDesc, receiverName string, lbls, annotations map[string]string) (*TestReceiverResponse, error) {
+	amCfg, err := config.Load(cfgDesc.RawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var receiver *config.Receiver
+	for _, rcv := range amCfg.Receivers {
+		if rcv.Name == receiverName {
+			receiver = rcv
+		}
+	}
+	if receiver == nil {
+		return nil, fmt.Errorf("receiver %q not found", receiverName)
+	}
+
+	tmpl, err := buildUserTemplate(logger, cfgDesc, amCfg)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.ExternalURL = am.cfg.ExternalURL.URL
+
+	// Build the integrations the same way the tenant Alertmanager does, so that the test notifications
+	// go through the receivers firewall and are subject to the notification rate limits.
+	firewallDialer := util_net.NewFirewallDialer(newFirewallDialerConfigProvider(userID, am.limits))
+	integrations, err := buildReceiverIntegrations(receiver, tmpl, firewallDialer, logger, func(_, integrationName string, _ int, notifier notify.Notifier) notify.Notifier {
+		rl := &tenantRateLimits{
+			tenant:      userID,
+			limits:      am.limits,
+			integration: integrationName,
+		}
+
+		return newRateLimitedNotifierWithLimiter(notifier, am.getTestReceiverLimiter(userID, integrationName, rl), rl, 10*time.Second, am.multitenantMetrics.testReceiverNotifications.WithLabelValues(integrationName, "rate_limited"))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(integrations) == 0 {
+		return nil, fmt.Errorf("receiver %q has no integrations", receiverName)
+	}
+
+	alert, err := newTestReceiverAlert(lbls, annotations, am.cfg.ExternalURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	groupLabels := model.LabelSet{model.AlertNameLabel: alert.Labels[model.AlertNameLabel]}
+	ctx = notify.WithReceiverName(ctx, receiverName)
+	ctx = notify.WithGroupKey(ctx, fmt.Sprintf("{}/test_receiver:%s", groupLabels))
+	ctx = notify.WithGroupLabels(ctx, groupLabels)
+	ctx = notify.WithNow(ctx, alert.StartsAt)
+
+	resp := &TestReceiverResponse{
+		Status:   testReceiverStatusSuccess,
+		Receiver: receiverName,
+		Results:  make([]TestReceiverResult, len(integrations)),
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range integrations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp.Results[i] = notifyTestReceiverIntegration(ctx, &integrations[i], alert)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range resp.Results {
+		if result.Status != testReceiverStatusSuccess {
+			resp.Status = testReceiverStatusFailed
+		}
+		if result.Error != errRateLimited.Error() {
+			am.multitenantMetrics.testReceiverNotifications.WithLabelValues(result.Integration, result.Status).Inc()
+		}
+	}
+
+	level.Info(logger).Log("msg", "sent test notification", "receiver", receiverName, "status", resp.Status)
+	return resp, nil
+}
+
+func notifyTestReceiverIntegration(ctx context.Context, integration *notify.Integration, alert *types.Alert) TestReceiverResult {
+	ctx, cancel := context.WithTimeout(ctx, testReceiverTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := integration.Notify(ctx, alert)
+
+	result := TestReceiverResult{
+		Integration: integration.Name(),
+		Index:       integration.Index(),
+		Status:      testReceiverStatusSuccess,
+		Duration:    time.Since(start).String(),
+	}
+	if err != nil {
+		result.Status = testReceiverStatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// newTestReceiverAlert returns the synthetic firing alert sent by the test receiver API. The input labels
+// and annotations override the default ones.
+func newTestReceiverAlert(lbls, annotations map[string]string, generatorURL string) (*types.Alert, error) {
+	now := time.Now()
+	alert := &types.Alert{
+		Alert: model.Alert{
+			Labels: model.LabelSet{
+				model.AlertNameLabel: "TestAlert",
+			},
+			Annotations: model.LabelSet{
+				"summary":     "Test alert",
+				"description": "This is a test alert sent to verify the receiver configuration.",
+			},
+			StartsAt:     now,
+			EndsAt:       now.Add(5 * time.Minute),
+			GeneratorURL: generatorURL,
+		},
+		UpdatedAt: now,
+	}
+
+	for name, value := range lbls {
+		alert.Labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+	for name, value := range annotations {
+		alert.Annotations[model.LabelName(name)] = model.LabelValue(value)
+	}
+
+	if err := alert.Validate(); err != nil {
+		return nil, err
+	}
+	return alert, nil
+}
+
+type testReceiverLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// isRefilled returns whether the limiter has been idle long enough to refill its burst, in which case
+// it's equivalent to a new limiter.
+func (l *testReceiverLimiter) isRefilled(now time.Time) bool {
+	limit := l.limiter.Limit()
+	if limit == rate.Inf {
+		return true
+	}
+	if limit <= 0 {
+		return false
+	}
+
+	refill := time.Duration(float64(l.limiter.Burst()) / float64(limit) * float64(time.Second))
+	return now.Sub(l.lastUsed) >= refill
+}
+
+// getTestReceiverLimiter returns the rate limiter of the test notifications sent by the given tenant integration.
+// The limiter is shared across requests, so that the tenant can't exceed the notification rate limits. If the
+// tenant Alertmanager is running on this instance, its limiter is used, so that the test notifications count
+// towards the same limits as the other notifications of the tenant.
+func (am *MultitenantAlertmanager) getTestReceiverLimiter(userID, integration string, limits rateLimits) *rate.Limiter {
+	am.alertmanagersMtx.Lock()
+	userAM, ok := am.alertmanagers[userID]
+	am.alertmanagersMtx.Unlock()
+
+	if ok {
+		return userAM.getNotificationLimiter(integration, limits)
+	}
+
+	am.testReceiverLimitersMtx.Lock()
+	defer am.testReceiverLimitersMtx.Unlock()
+
+	if am.testReceiverLimiters == nil {
+		am.testReceiverLimiters = map[string]*testReceiverLimiter{}
+	}
+
+	// Remove the idle limiters, so that the limiters of the tenants which don't use the
+	// test receiver API anymore don't pile up.
+	now := time.Now()
+	for key, l := range am.testReceiverLimiters {
+		if l.isRefilled(now) {
+			delete(am.testReceiverLimiters, key)
+		}
+	}
+
+	key := userID + "/" + integration
+	l, ok := am.testReceiverLimiters[key]
+	if !ok {
+		l = &testReceiverLimiter{limiter: rate.NewLimiter(limits.RateLimit(), limits.Burst())}
+		am.testReceiverLimiters[key] = l
+	}
+	l.lastUsed = now
+	return l.limiter
+}
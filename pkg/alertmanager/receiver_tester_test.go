@@ -0,0 +1,283 @@
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/alertmanager/alertspb"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+func TestMultitenantAlertmanager_TestUserReceiver(t *testing.T) {
+	tests := map[string]struct {
+		body              string
+		storedConfig      string
+		limits            mockAlertManagerLimits
+		serverStatusCode  int
+		expectedStatus    int
+		expectedBody      string
+		expectedResult    string
+		expectedError     string
+		expectedRequests  int64
+		expectedAlertname string
+	}{
+		"should send the test notification to a receiver definition": {
+			body: `
+receiver:
+  name: test
+  webhook_configs:
+    - url: {{URL}}
+labels:
+  alertname: CustomTestAlert
+`,
+			limits:            mockAlertManagerLimits{emailNotificationRateLimit: rate.Inf},
+			expectedStatus:    http.StatusOK,
+			expectedResult:    testReceiverStatusSuccess,
+			expectedRequests:  1,
+			expectedAlertname: "CustomTestAlert",
+		},
+		"should send the test notification to a receiver of the stored config": {
+			body: `
+receiver_name: stored
+`,
+			storedConfig:      "route:\n  receiver: stored\nreceivers:\n  - name: stored\n    webhook_configs:\n      - url: {{URL}}\n",
+			limits:            mockAlertManagerLimits{emailNotificationRateLimit: rate.Inf},
+			expectedStatus:    http.StatusOK,
+			expectedResult:    testReceiverStatusSuccess,
+			expectedRequests:  1,
+			expectedAlertname: "TestAlert",
+		},
+		"should report the delivery failure": {
+			body: `
+receiver:
+  name: test
+  webhook_configs:
+    - url: {{URL}}
+`,
+			limits:           mockAlertManagerLimits{emailNotificationRateLimit: rate.Inf},
+			serverStatusCode: http.StatusInternalServerError,
+			expectedStatus:   http.StatusOK,
+			expectedResult:   testReceiverStatusFailed,
+			expectedError:    "unexpected status code 500",
+			expectedRequests: 1,
+		},
+		"should honor the receivers firewall": {
+			body: `
+receiver:
+  name: test
+  webhook_configs:
+    - url: {{URL}}
+`,
+			limits:           mockAlertManagerLimits{emailNotificationRateLimit: rate.Inf, receiversBlockPrivateAddresses: true},
+			expectedStatus:   http.StatusOK,
+			expectedResult:   testReceiverStatusFailed,
+			expectedError:    "blocked address",
+			expectedRequests: 0,
+		},
+		"should honor the notification rate limits": {
+			body: `
+receiver:
+  name: test
+  webhook_configs:
+    - url: {{URL}}
+`,
+			limits:           mockAlertManagerLimits{emailNotificationRateLimit: 0},
+			expectedStatus:   http.StatusOK,
+			expectedResult:   testReceiverStatusFailed,
+			expectedError:    errRateLimited.Error(),
+			expectedRequests: 0,
+		},
+		"should return error if neither the receiver name nor the receiver definition is provided": {
+			body:           "labels:\n  foo: bar\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager receiver: either the receiver name or the receiver definition must be provided\n",
+		},
+		"should return error if both the receiver name and the receiver definition are provided": {
+			body:           "receiver_name: test\nreceiver:\n  name: test\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager receiver: either the receiver name or the receiver definition must be provided\n",
+		},
+		"should return 404 if the receiver name is provided but no config is stored": {
+			body:           "receiver_name: test\n",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "alertmanager storage object not found\n",
+		},
+		"should return error if the receiver is not in the stored config": {
+			body:           "receiver_name: missing\n",
+			storedConfig:   "route:\n  receiver: stored\nreceivers:\n  - name: stored\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager receiver: receiver \"missing\" not found\n",
+		},
+		"should return error if the receiver has no integrations": {
+			body:           "receiver_name: stored\n",
+			storedConfig:   "route:\n  receiver: stored\nreceivers:\n  - name: stored\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager receiver: receiver \"stored\" has no integrations\n",
+		},
+		"should return error if the receiver definition has no name": {
+			body: `
+receiver:
+  webhook_configs:
+    - url: {{URL}}
+`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager receiver: missing name in receiver\n",
+		},
+		"should return error if the receiver definition is not allowed": {
+			body: `
+receiver:
+  name: test
+  webhook_configs:
+    - url: {{URL}}
+      http_config:
+        bearer_token_file: /etc/passwd
+`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "error validating Alertmanager config: " + errPasswordFileNotAllowed.Error() + "\n",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			requests := atomic.NewInt64(0)
+			receivedAlertname := atomic.NewString("")
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Inc()
+
+				msg := webhook.Message{}
+				if err := json.NewDecoder(r.Body).Decode(&msg); err == nil && len(msg.Alerts) > 0 {
+					receivedAlertname.Store(msg.Alerts[0].Labels["alertname"])
+				}
+
+				if testData.serverStatusCode != 0 {
+					w.WriteHeader(testData.serverStatusCode)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			store := prepareInMemoryAlertStore()
+			if testData.storedConfig != "" {
+				require.NoError(t, store.SetAlertConfig(context.Background(), alertspb.AlertConfigDesc{
+					User:      "user-1",
+					RawConfig: strings.ReplaceAll(testData.storedConfig, "{{URL}}", server.URL),
+				}))
+			}
+
+			limits := testData.limits
+			am := &MultitenantAlertmanager{
+				cfg:                &MultitenantAlertmanagerConfig{ExternalURL: flagext.URLValue{URL: &url.URL{Scheme: "http", Host: "alertmanager"}}},
+				store:              store,
+				logger:             util_log.Logger,
+				limits:             &limits,
+				multitenantMetrics: newMultitenantAlertmanagerMetrics(nil),
+			}
+
+			body := strings.ReplaceAll(testData.body, "{{URL}}", server.URL)
+			req := httptest.NewRequest(http.MethodPost, "http://alertmanager/api/v1/alerts/test_receiver", bytes.NewReader([]byte(body)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+			w := httptest.NewRecorder()
+			am.TestUserReceiver(w, req)
+
+			require.Equal(t, testData.expectedStatus, w.Code, w.Body.String())
+			if testData.expectedBody != "" {
+				assert.Equal(t, testData.expectedBody, w.Body.String())
+			}
+			if testData.expectedResult == "" {
+				return
+			}
+
+			resp := TestReceiverResponse{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, testData.expectedResult, resp.Status)
+			require.Len(t, resp.Results, 1)
+			assert.Equal(t, "webhook", resp.Results[0].Integration)
+			assert.Equal(t, testData.expectedResult, resp.Results[0].Status)
+			assert.Contains(t, resp.Results[0].Error, testData.expectedError)
+			assert.Equal(t, testData.expectedRequests, requests.Load())
+			if testData.expectedAlertname != "" {
+				assert.Equal(t, testData.expectedAlertname, receivedAlertname.Load())
+			}
+		})
+	}
+}
+
+func TestMultitenantAlertmanager_TestUserReceiver_ShouldShareRateLimitsAcrossRequests(t *testing.T) {
+	requests := atomic.NewInt64(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Inc()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	am := &MultitenantAlertmanager{
+		cfg:                &MultitenantAlertmanagerConfig{ExternalURL: flagext.URLValue{URL: &url.URL{Scheme: "http", Host: "alertmanager"}}},
+		store:              prepareInMemoryAlertStore(),
+		logger:             util_log.Logger,
+		limits:             &mockAlertManagerLimits{emailNotificationRateLimit: rate.Every(time.Hour), emailNotificationBurst: 1},
+		multitenantMetrics: newMultitenantAlertmanagerMetrics(reg),
+	}
+
+	body := fmt.Sprintf("receiver:\n  name: test\n  webhook_configs:\n    - url: %s\n", server.URL)
+	for _, expected := range []string{testReceiverStatusSuccess, testReceiverStatusFailed} {
+		req := httptest.NewRequest(http.MethodPost, "http://alertmanager/api/v1/alerts/test_receiver", strings.NewReader(body))
+		req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+		w := httptest.NewRecorder()
+		am.TestUserReceiver(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := TestReceiverResponse{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, expected, resp.Status)
+	}
+
+	// Only the first notification should have been delivered.
+	assert.Equal(t, int64(1), requests.Load())
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_alertmanager_test_receiver_notifications_total Total number of test notifications sent via the test receiver API, by integration and outcome.
+		# TYPE cortex_alertmanager_test_receiver_notifications_total counter
+		cortex_alertmanager_test_receiver_notifications_total{integration="webhook",outcome="rate_limited"} 1
+		cortex_alertmanager_test_receiver_notifications_total{integration="webhook",outcome="success"} 1
+	`), "cortex_alertmanager_test_receiver_notifications_total"))
+}
+
+func TestMultitenantAlertmanager_GetTestReceiverLimiter(t *testing.T) {
+	userAM := &Alertmanager{}
+	am := &MultitenantAlertmanager{alertmanagers: map[string]*Alertmanager{"user-1": userAM}}
+
+	// The limiter of the tenant Alertmanager running on this instance should be shared.
+	limits := &tenantRateLimits{tenant: "user-1", limits: &mockAlertManagerLimits{emailNotificationRateLimit: rate.Every(time.Hour), emailNotificationBurst: 1}, integration: "webhook"}
+	assert.Same(t, userAM.getNotificationLimiter("webhook", limits), am.getTestReceiverLimiter("user-1", "webhook", limits))
+	assert.Empty(t, am.testReceiverLimiters)
+
+	// The limiter of the other tenants should be kept until it has refilled.
+	limiter := am.getTestReceiverLimiter("user-2", "webhook", limits)
+	assert.Same(t, limiter, am.getTestReceiverLimiter("user-2", "webhook", limits))
+	assert.Len(t, am.testReceiverLimiters, 1)
+
+	am.testReceiverLimiters["user-2/webhook"].lastUsed = time.Now().Add(-2 * time.Hour)
+	assert.NotSame(t, limiter, am.getTestReceiverLimiter("user-2", "webhook", limits))
+	assert.Len(t, am.testReceiverLimiters, 1)
+}
@@ -0,0 +1,147 @@
+package alertmanager
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/types"
+
+	"github.com/cortexproject/cortex/pkg/alertmanager/merger"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// receiversHealthPath is the path, relative to the Alertmanager external URL, of the endpoint
+// returning the health of the tenant receivers.
+const receiversHealthPath = "/api/v1/receivers/health"
+
+type integrationKey struct {
+	receiver    string
+	integration string
+	index       int
+}
+
+// receiversHealth tracks the outcome of the notifications attempted by each receiver integration.
+// The tracked state survives configuration reloads for integrations which are still configured.
+type receiversHealth struct {
+	mtx          sync.Mutex
+	integrations map[integrationKey]*merger.IntegrationHealth
+}
+
+func newReceiversHealth() *receiversHealth {
+	return &receiversHealth{
+		integrations: map[integrationKey]*merger.IntegrationHealth{},
+	}
+}
+
+// setIntegrations replaces the tracked integrations with the configured ones, preserving the state
+// of the integrations which were already tracked.
+func (h *receiversHealth) setIntegrations(integrationsMap map[string][]notify.Integration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	integrations := make(map[integrationKey]*merger.IntegrationHealth, len(h.integrations))
+	for receiver, receiverIntegrations := range integrationsMap {
+		for _, integration := range receiverIntegrations {
+			key := integrationKey{receiver: receiver, integration: integration.Name(), index: integration.Index()}
+			if existing, ok := h.integrations[key]; ok {
+				integrations[key] = existing
+				continue
+			}
+			integrations[key] = &merger.IntegrationHealth{Name: integration.Name(), Index: integration.Index()}
+		}
+	}
+
+	h.integrations = integrations
+}
+
+// observe records the outcome of a notification attempt.
+func (h *receiversHealth) observe(key integrationKey, attemptedAt time.Time, err error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	// The integration may have been removed by a configuration reload while notifying.
+	integration, ok := h.integrations[key]
+	if !ok {
+		return
+	}
+
+	integration.LastAttempt = attemptedAt
+	if err != nil {
+		integration.FailureCount++
+		integration.LastError = err.Error()
+		integration.LastErrorAt = attemptedAt
+	} else {
+		integration.SuccessCount++
+	}
+}
+
+// receivers returns a snapshot of the health of the tracked receivers, ordered by name.
+func (h *receiversHealth) receivers() []merger.ReceiverHealth {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	byName := map[string]*merger.ReceiverHealth{}
+	for key, integration := range h.integrations {
+		receiver, ok := byName[key.receiver]
+		if !ok {
+			receiver = &merger.ReceiverHealth{Name: key.receiver, Integrations: []merger.IntegrationHealth{}}
+			byName[key.receiver] = receiver
+		}
+		receiver.Integrations = append(receiver.Integrations, *integration)
+	}
+
+	result := make([]merger.ReceiverHealth, 0, len(byName))
+	for _, receiver := range byName {
+		merger.SortIntegrationsHealth(receiver.Integrations)
+		result = append(result, *receiver)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// healthTrackingNotifier is a notify.Notifier which records the outcome of each notification attempt.
+type healthTrackingNotifier struct {
+	upstream notify.Notifier
+	health   *receiversHealth
+	key      integrationKey
+}
+
+func newHealthTrackingNotifier(upstream notify.Notifier, health *receiversHealth, receiver, integration string, index int) *healthTrackingNotifier {
+	return &healthTrackingNotifier{
+		upstream: upstream,
+		health:   health,
+		key:      integrationKey{receiver: receiver, integration: integration, index: index},
+	}
+}
+
+func (n *healthTrackingNotifier) Notify(ctx context.Context, alerts ...*types.Alert) (bool, error) {
+	attemptedAt := time.Now()
+	retry, err := n.upstream.Notify(ctx, alerts...)
+	n.health.observe(n.key, attemptedAt, err)
+	return retry, err
+}
+
+// receiversHealthHandler serves the health of the tenant receivers, in the same format as the
+// Alertmanager v1 API.
+func (am *Alertmanager) receiversHealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	util.WriteJSONResponse(w, struct {
+		Status string                  `json:"status"`
+		Data   []merger.ReceiverHealth `json:"data"`
+	}{
+		Status: "success",
+		Data:   am.receiversHealth.receivers(),
+	})
+}
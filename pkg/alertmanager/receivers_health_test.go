@@ -0,0 +1,191 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/alertmanager/merger"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestReceiversHealth(t *testing.T) {
+	health := newReceiversHealth()
+
+	failing := errors.New("connection refused")
+	upstream := &mockFailingNotifier{}
+
+	health.setIntegrations(map[string][]notify.Integration{
+		"team-a": {
+			notify.NewIntegration(upstream, nil, "webhook", 0),
+			notify.NewIntegration(upstream, nil, "webhook", 1),
+		},
+		"team-b": {
+			notify.NewIntegration(upstream, nil, "email", 0),
+		},
+	})
+
+	webhook0 := newHealthTrackingNotifier(upstream, health, "team-a", "webhook", 0)
+	webhook1 := newHealthTrackingNotifier(upstream, health, "team-a", "webhook", 1)
+
+	_, err := webhook0.Notify(context.Background(), &types.Alert{})
+	require.NoError(t, err)
+	_, err = webhook0.Notify(context.Background(), &types.Alert{})
+	require.NoError(t, err)
+
+	upstream.err = failing
+	_, err = webhook1.Notify(context.Background(), &types.Alert{})
+	require.Equal(t, failing, err)
+
+	receivers := health.receivers()
+	require.Len(t, receivers, 2)
+	assert.Equal(t, "team-a", receivers[0].Name)
+	require.Len(t, receivers[0].Integrations, 2)
+	assert.Equal(t, uint64(2), receivers[0].Integrations[0].SuccessCount)
+	assert.Equal(t, uint64(0), receivers[0].Integrations[0].FailureCount)
+	assert.Empty(t, receivers[0].Integrations[0].LastError)
+	assert.False(t, receivers[0].Integrations[0].LastAttempt.IsZero())
+	assert.Equal(t, 1, receivers[0].Integrations[1].Index)
+	assert.Equal(t, uint64(0), receivers[0].Integrations[1].SuccessCount)
+	assert.Equal(t, uint64(1), receivers[0].Integrations[1].FailureCount)
+	assert.Equal(t, failing.Error(), receivers[0].Integrations[1].LastError)
+	assert.Equal(t, receivers[0].Integrations[1].LastAttempt, receivers[0].Integrations[1].LastErrorAt)
+	assert.Equal(t, []merger.ReceiverHealth{{Name: "team-b", Integrations: []merger.IntegrationHealth{{Name: "email"}}}}, receivers[1:])
+
+	// A configuration reload should preserve the state of the integrations which are still configured.
+	health.setIntegrations(map[string][]notify.Integration{
+		"team-a": {
+			notify.NewIntegration(upstream, nil, "webhook", 0),
+		},
+	})
+
+	receivers = health.receivers()
+	require.Len(t, receivers, 1)
+	require.Len(t, receivers[0].Integrations, 1)
+	assert.Equal(t, uint64(2), receivers[0].Integrations[0].SuccessCount)
+
+	// Notifications sent by integrations which are not configured anymore should be ignored.
+	_, err = webhook1.Notify(context.Background(), &types.Alert{})
+	require.Equal(t, failing, err)
+	assert.Equal(t, receivers, health.receivers())
+}
+
+func TestAlertmanager_ReceiversHealthHandler(t *testing.T) {
+	tests := map[string]struct {
+		rateLimit             rate.Limit
+		expectSuccess         bool
+		expectedErrorContains string
+	}{
+		"successful notifications": {
+			rateLimit:     rate.Inf,
+			expectSuccess: true,
+		},
+		"rate limited notifications": {
+			rateLimit:             0,
+			expectedErrorContains: errRateLimited.Error(),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			received := atomic.NewInt64(0)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				received.Inc()
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			am, err := New(&Config{
+				UserID:        "user-1",
+				Logger:        log.NewNopLogger(),
+				Limits:        &mockAlertManagerLimits{emailNotificationRateLimit: testData.rateLimit},
+				TenantDataDir: t.TempDir(),
+				ExternalURL:   &url.URL{Path: "/am"},
+			}, prometheus.NewPedanticRegistry())
+			require.NoError(t, err)
+			defer am.StopAndWait()
+
+			cfgRaw := fmt.Sprintf(`receivers:
+- name: 'team-a'
+  webhook_configs:
+  - url: '%s'
+
+route:
+  group_wait: 0s
+  receiver: 'team-a'`, server.URL)
+
+			cfg, err := config.Load(cfgRaw)
+			require.NoError(t, err)
+			require.NoError(t, am.ApplyConfig("user-1", cfg, cfgRaw))
+
+			now := time.Now()
+			require.NoError(t, am.alerts.Put(&types.Alert{
+				Alert: model.Alert{
+					Labels:   model.LabelSet{"alertname": "test"},
+					StartsAt: now,
+					EndsAt:   now.Add(5 * time.Minute),
+				},
+				UpdatedAt: now,
+			}))
+
+			getReceivers := func() []merger.ReceiverHealth {
+				w := httptest.NewRecorder()
+				am.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/am/api/v1/receivers/health", nil))
+				require.Equal(t, http.StatusOK, w.Code)
+
+				body := struct {
+					Status string                  `json:"status"`
+					Data   []merger.ReceiverHealth `json:"data"`
+				}{}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				require.Equal(t, "success", body.Status)
+				return body.Data
+			}
+
+			// Wait until the notification has been attempted.
+			test.Poll(t, 5*time.Second, true, func() interface{} {
+				receivers := getReceivers()
+				return len(receivers) == 1 && len(receivers[0].Integrations) == 1 && !receivers[0].Integrations[0].LastAttempt.IsZero()
+			})
+
+			integration := getReceivers()[0].Integrations[0]
+			assert.Equal(t, "webhook", integration.Name)
+			if testData.expectSuccess {
+				assert.Equal(t, uint64(1), integration.SuccessCount)
+				assert.Equal(t, uint64(0), integration.FailureCount)
+				assert.Empty(t, integration.LastError)
+				assert.Equal(t, int64(1), received.Load())
+			} else {
+				assert.Equal(t, uint64(0), integration.SuccessCount)
+				assert.Equal(t, uint64(1), integration.FailureCount)
+				assert.Contains(t, integration.LastError, testData.expectedErrorContains)
+				assert.Equal(t, int64(0), received.Load())
+			}
+		})
+	}
+}
+
+type mockFailingNotifier struct {
+	err error
+}
+
+func (m *mockFailingNotifier) Notify(_ context.Context, _ ...*types.Alert) (bool, error) {
+	return false, m.err
+}
@@ -0,0 +1,350 @@
+package alertmanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/alertmanager/dispatch"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/alertmanager/alertspb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+const (
+	errTestingRoute = "unable to test the Alertmanager route"
+)
+
+// TestRouteRequest is the request body of the test route API. When no Alertmanager config is
+// provided, the route is tested against the config currently stored for the tenant.
+type TestRouteRequest struct {
+	UserConfig  `yaml:",inline"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// TestRouteResponse is the response of the test route API.
+type TestRouteResponse struct {
+	// Receivers is the list of distinct receivers the alert would be notified to.
+	Receivers []string `json:"receivers"`
+	// Routes is the list of routes matching the alert.
+	Routes []TestRouteMatch `json:"routes"`
+}
+
+// TestRouteMatch describes a route matching the alert.
+type TestRouteMatch struct {
+	// Path is the list of matchers of each route, from the root route to the matching one.
+	Path           []string          `json:"path"`
+	Receiver       string            `json:"receiver"`
+	GroupBy        []string          `json:"groupBy"`
+	GroupKey       string            `json:"groupKey"`
+	GroupLabels    map[string]string `json:"groupLabels"`
+	GroupWait      string            `json:"groupWait"`
+	GroupInterval  string            `json:"groupInterval"`
+	RepeatInterval string            `json:"repeatInterval"`
+
+	// Notifications are the notifications which would be sent by each integration of the receiver.
+	Notifications []TestRouteNotification `json:"notifications"`
+}
+
+// TestRouteNotification is the notification which would be sent by an integration of a receiver.
+type TestRouteNotification struct {
+	Integration string `json:"integration"`
+	Index       int    `json:"index"`
+	// Rendered contains the rendered value of each templated field of the integration config.
+	Rendered map[string]string `json:"rendered"`
+	// Errors contains the error of each templated field which failed to render.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// TestUserConfigRoute returns the routes, receivers, grouping keys and rendered notification templates
+// which would apply to an alert with the given labels, without storing the config or sending any notification.
+func (am *MultitenantAlertmanager) TestUserConfigRoute(w http.ResponseWriter, r *http.Request) {
+	logger := util_log.WithContext(r.Context(), am.logger)
+	userID, err := tenant.TenantID(r.Context())
+	if err != nil {
+		level.Error(logger).Log("msg", errNoOrgID, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errNoOrgID, err.Error()), http.StatusUnauthorized)
+		return
+	}
+
+	req := &TestRouteRequest{}
+	if !am.readUserConfigRequest(w, r, logger, userID, req) {
+		return
+	}
+
+	if len(req.Labels) == 0 {
+		http.Error(w, fmt.Sprintf("%s: no labels provided", errTestingRoute), http.StatusBadRequest)
+		return
+	}
+
+	var cfgDesc alertspb.AlertConfigDesc
+	if req.AlertmanagerConfig == "" && len(req.TemplateFiles) == 0 {
+		cfgDesc, err = am.store.GetAlertConfig(r.Context(), userID)
+		if err == alertspb.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			level.Error(logger).Log("msg", errReadingConfiguration, "err", err.Error())
+			http.Error(w, fmt.Sprintf("%s: %s", errReadingConfiguration, err.Error()), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		cfgDesc = alertspb.ToProto(req.AlertmanagerConfig, req.TemplateFiles, userID)
+		if err := validateUserConfig(logger, cfgDesc, am.limits, userID); err != nil {
+			level.Debug(logger).Log("msg", errValidatingConfig, "err", err.Error())
+			http.Error(w, fmt.Sprintf("%s: %s", errValidatingConfig, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, err := testUserConfigRoute(logger, cfgDesc, am.cfg.ExternalURL.URL, req.Labels, req.Annotations)
+	if err != nil {
+		level.Warn(logger).Log("msg", errTestingRoute, "err", err.Error())
+		http.Error(w, fmt.Sprintf("%s: %s", errTestingRoute, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	util.WriteJSONResponse(w, resp)
+}
+
+func testUserConfigRoute(logger log.Logger, cfgDesc alertspb.AlertConfigDesc, externalURL *url.URL, lbls, annotations map[string]string) (*TestRouteResponse, error) {
+	amCfg, err := config.Load(cfgDesc.RawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := buildUserTemplate(logger, cfgDesc, amCfg)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.ExternalURL = externalURL
+
+	now := time.Now()
+	alert := &types.Alert{
+		Alert: model.Alert{
+			Labels:      toLabelSet(lbls),
+			Annotations: toLabelSet(annotations),
+			StartsAt:    now,
+		},
+		UpdatedAt: now,
+	}
+	if err := alert.Validate(); err != nil {
+		return nil, err
+	}
+
+	receivers := make(map[string]*config.Receiver, len(amCfg.Receivers))
+	for _, rcv := range amCfg.Receivers {
+		receivers[rcv.Name] = rcv
+	}
+
+	root := dispatch.NewRoute(amCfg.Route, nil)
+	resp := &TestRouteResponse{
+		Receivers: []string{},
+		Routes:    []TestRouteMatch{},
+	}
+
+	for _, route := range root.Match(alert.Labels) {
+		groupLabels := getGroupLabels(alert, route)
+
+		match := TestRouteMatch{
+			Path:           routePath(root, route),
+			Receiver:       route.RouteOpts.Receiver,
+			GroupBy:        routeGroupBy(route),
+			GroupKey:       fmt.Sprintf("%s:%s", route.Key(), groupLabels),
+			GroupLabels:    fromLabelSet(groupLabels),
+			GroupWait:      model.Duration(route.RouteOpts.GroupWait).String(),
+			GroupInterval:  model.Duration(route.RouteOpts.GroupInterval).String(),
+			RepeatInterval: model.Duration(route.RouteOpts.RepeatInterval).String(),
+			Notifications:  []TestRouteNotification{},
+		}
+
+		if rcv, ok := receivers[route.RouteOpts.Receiver]; ok {
+			data := tmpl.Data(rcv.Name, groupLabels, alert)
+			match.Notifications = renderReceiverNotifications(tmpl, data, rcv)
+		}
+
+		if !util.StringsContain(resp.Receivers, match.Receiver) {
+			resp.Receivers = append(resp.Receivers, match.Receiver)
+		}
+		resp.Routes = append(resp.Routes, match)
+	}
+
+	sort.Strings(resp.Receivers)
+	return resp, nil
+}
+
+// getGroupLabels returns the labels of the alert used to group it on the route.
+// Taken from https://github.com/prometheus/alertmanager/blob/e35efbddb66a/dispatch/dispatch.go#L360-L369.
+func getGroupLabels(alert *types.Alert, route *dispatch.Route) model.LabelSet {
+	groupLabels := model.LabelSet{}
+	for ln, lv := range alert.Labels {
+		if _, ok := route.RouteOpts.GroupBy[ln]; ok || route.RouteOpts.GroupByAll {
+			groupLabels[ln] = lv
+		}
+	}
+
+	return groupLabels
+}
+
+// routePath returns the matchers of each route from the root to the target route.
+func routePath(root, target *dispatch.Route) []string {
+	if root == target {
+		return []string{root.Matchers.String()}
+	}
+
+	for _, child := range root.Routes {
+		if path := routePath(child, target); path != nil {
+			return append([]string{root.Matchers.String()}, path...)
+		}
+	}
+
+	return nil
+}
+
+func routeGroupBy(route *dispatch.Route) []string {
+	if route.RouteOpts.GroupByAll {
+		return []string{"..."}
+	}
+
+	groupBy := make([]string, 0, len(route.RouteOpts.GroupBy))
+	for ln := range route.RouteOpts.GroupBy {
+		groupBy = append(groupBy, string(ln))
+	}
+	sort.Strings(groupBy)
+	return groupBy
+}
+
+// renderReceiverNotifications renders the templated fields of each integration of the receiver.
+func renderReceiverNotifications(tmpl *template.Template, data *template.Data, rcv *config.Receiver) []TestRouteNotification {
+	var notifications []TestRouteNotification
+
+	add := func(name string, configs interface{}) {
+		v := reflect.ValueOf(configs)
+		for i := 0; i < v.Len(); i++ {
+			n := TestRouteNotification{
+				Integration: name,
+				Index:       i,
+				Rendered:    map[string]string{},
+			}
+			renderTemplatedFields(tmpl, data, v.Index(i), "", &n)
+			notifications = append(notifications, n)
+		}
+	}
+
+	// Keep the integrations in sync with buildReceiverIntegrations().
+	add("webhook", rcv.WebhookConfigs)
+	add("email", rcv.EmailConfigs)
+	add("pagerduty", rcv.PagerdutyConfigs)
+	add("opsgenie", rcv.OpsGenieConfigs)
+	add("wechat", rcv.WechatConfigs)
+	add("slack", rcv.SlackConfigs)
+	add("victorops", rcv.VictorOpsConfigs)
+	add("pushover", rcv.PushoverConfigs)
+	add("sns", rcv.SNSConfigs)
+
+	if notifications == nil {
+		return []TestRouteNotification{}
+	}
+	return notifications
+}
+
+// renderTemplatedFields recursively renders the string fields of the integration config containing
+// a template, naming each field by its YAML path. Secrets are never rendered because they're not
+// plain strings.
+func renderTemplatedFields(tmpl *template.Template, data *template.Data, v reflect.Value, path string, out *TestRouteNotification) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			renderTemplatedFields(tmpl, data, v.Elem(), path, out)
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" || !field.IsExported() {
+				continue
+			}
+			// The HTTP client config is not templated.
+			if name == "http_config" {
+				continue
+			}
+			renderTemplatedFields(tmpl, data, v.Field(i), joinFieldPath(path, name), out)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			renderTemplatedFields(tmpl, data, v.Index(i), fmt.Sprintf("%s[%d]", path, i), out)
+		}
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			renderTemplatedFields(tmpl, data, v.MapIndex(key), joinFieldPath(path, key.String()), out)
+		}
+
+	case reflect.String:
+		// Only render plain strings, to not render secrets.
+		if v.Type() != reflect.TypeOf("") || !strings.Contains(v.String(), "{{") {
+			return
+		}
+
+		var (
+			rendered string
+			err      error
+		)
+		if strings.HasSuffix(path, "html") {
+			rendered, err = tmpl.ExecuteHTMLString(v.String(), data)
+		} else {
+			rendered, err = tmpl.ExecuteTextString(v.String(), data)
+		}
+
+		if err != nil {
+			if out.Errors == nil {
+				out.Errors = map[string]string{}
+			}
+			out.Errors[path] = err.Error()
+			return
+		}
+		out.Rendered[path] = rendered
+	}
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func toLabelSet(in map[string]string) model.LabelSet {
+	out := make(model.LabelSet, len(in))
+	for name, value := range in {
+		out[model.LabelName(name)] = model.LabelValue(value)
+	}
+	return out
+}
+
+func fromLabelSet(in model.LabelSet) map[string]string {
+	out := make(map[string]string, len(in))
+	for name, value := range in {
+		out[string(name)] = string(value)
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/alertmanager/alertspb"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+func TestMultitenantAlertmanager_TestUserConfigRoute(t *testing.T) {
+	const alertmanagerConfig = `
+  route:
+    receiver: default
+    group_by: [alertname]
+    routes:
+      - receiver: team-a
+        matchers: ['team="a"']
+        group_by: [alertname, cluster]
+        continue: true
+        routes:
+          - receiver: team-a-critical
+            matchers: ['severity="critical"']
+            group_wait: 1m
+      - receiver: audit
+        matchers: ['team=~".+"']
+        group_by: ['...']
+  receivers:
+    - name: default
+    - name: team-a
+      slack_configs:
+        - api_url: http://slack.example.com
+          channel: '#team-a'
+          title: '{{ template "custom.title" . }}'
+    - name: team-a-critical
+      webhook_configs:
+        - url: http://webhook.example.com
+      email_configs:
+        - to: team-a@example.com
+          from: alertmanager@example.com
+          smarthost: smtp.example.com:25
+          headers:
+            Subject: '[{{ .Status }}] {{ .CommonLabels.alertname }}'
+          html: '<b>{{ .CommonAnnotations.summary }}</b>'
+          text: '{{ .CommonAnnotations.summary | undefinedFunction }}'
+    - name: audit
+  templates:
+    - custom.tpl
+`
+
+	const templateFiles = `
+template_files:
+  custom.tpl: '{{ define "custom.title" }}{{ .Receiver }}: {{ .CommonLabels.alertname }} on {{ .CommonLabels.cluster }}{{ end }}'
+`
+
+	tests := map[string]struct {
+		body           string
+		storedConfig   *alertspb.AlertConfigDesc
+		expectedStatus int
+		expectedBody   string
+		check          func(t *testing.T, resp TestRouteResponse)
+	}{
+		"should return the root route if no child route matches": {
+			body: "alertmanager_config: |" + alertmanagerConfig + templateFiles + `
+labels:
+  alertname: HighLatency
+  cluster: prod
+`,
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, resp TestRouteResponse) {
+				assert.Equal(t, []string{"default"}, resp.Receivers)
+				require.Len(t, resp.Routes, 1)
+				assert.Equal(t, []string{"{}"}, resp.Routes[0].Path)
+				assert.Equal(t, []string{"alertname"}, resp.Routes[0].GroupBy)
+				assert.Equal(t, `{}:{alertname="HighLatency"}`, resp.Routes[0].GroupKey)
+				assert.Equal(t, map[string]string{"alertname": "HighLatency"}, resp.Routes[0].GroupLabels)
+				assert.Equal(t, "30s", resp.Routes[0].GroupWait)
+				assert.Equal(t, "5m", resp.Routes[0].GroupInterval)
+				assert.Equal(t, "4h", resp.Routes[0].RepeatInterval)
+				assert.Empty(t, resp.Routes[0].Notifications)
+			},
+		},
+		"should return all matching routes and render the notification templates": {
+			body: "alertmanager_config: |" + alertmanagerConfig + templateFiles + `
+labels:
+  alertname: HighLatency
+  cluster: prod
+  team: a
+  severity: critical
+annotations:
+  summary: Latency is high
+`,
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, resp TestRouteResponse) {
+				assert.Equal(t, []string{"audit", "team-a-critical"}, resp.Receivers)
+				require.Len(t, resp.Routes, 2)
+
+				critical := resp.Routes[0]
+				assert.Equal(t, []string{"{}", `{team="a"}`, `{severity="critical"}`}, critical.Path)
+				assert.Equal(t, "team-a-critical", critical.Receiver)
+				assert.Equal(t, []string{"alertname", "cluster"}, critical.GroupBy)
+				assert.Equal(t, `{}/{team="a"}/{severity="critical"}:{alertname="HighLatency", cluster="prod"}`, critical.GroupKey)
+				assert.Equal(t, "1m", critical.GroupWait)
+
+				require.Len(t, critical.Notifications, 2)
+				assert.Equal(t, "webhook", critical.Notifications[0].Integration)
+				assert.Empty(t, critical.Notifications[0].Rendered)
+				assert.Empty(t, critical.Notifications[0].Errors)
+
+				email := critical.Notifications[1]
+				assert.Equal(t, "email", email.Integration)
+				assert.Equal(t, 0, email.Index)
+				assert.Equal(t, "[firing] HighLatency", email.Rendered["headers.Subject"])
+				assert.Equal(t, "<b>Latency is high</b>", email.Rendered["html"])
+				assert.Contains(t, email.Errors["text"], `function "undefinedFunction" not defined`)
+
+				audit := resp.Routes[1]
+				assert.Equal(t, []string{"{}", `{team=~".+"}`}, audit.Path)
+				assert.Equal(t, []string{"..."}, audit.GroupBy)
+				assert.Len(t, audit.GroupLabels, 4)
+			},
+		},
+		"should render templates defined in the template files": {
+			body: "alertmanager_config: |" + alertmanagerConfig + templateFiles + `
+labels:
+  alertname: HighLatency
+  cluster: prod
+  team: a
+`,
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, resp TestRouteResponse) {
+				assert.Equal(t, []string{"audit", "team-a"}, resp.Receivers)
+				require.Len(t, resp.Routes, 2)
+				require.Len(t, resp.Routes[0].Notifications, 1)
+
+				slack := resp.Routes[0].Notifications[0]
+				assert.Equal(t, "slack", slack.Integration)
+				assert.Equal(t, "team-a: HighLatency on prod", slack.Rendered["title"])
+				assert.Contains(t, slack.Rendered, "text")
+			},
+		},
+		"should test the route against the stored config if no config is provided": {
+			body: `
+labels:
+  alertname: HighLatency
+`,
+			storedConfig:   &alertspb.AlertConfigDesc{User: "user-1", RawConfig: "route:\n  receiver: stored\nreceivers:\n  - name: stored\n"},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, resp TestRouteResponse) {
+				assert.Equal(t, []string{"stored"}, resp.Receivers)
+			},
+		},
+		"should return 404 if no config is provided and no config is stored": {
+			body: `
+labels:
+  alertname: HighLatency
+`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "alertmanager storage object not found\n",
+		},
+		"should return error if no labels are provided": {
+			body:           "alertmanager_config: |" + alertmanagerConfig + templateFiles,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager route: no labels provided\n",
+		},
+		"should return error if the labels are invalid": {
+			body: "alertmanager_config: |" + alertmanagerConfig + templateFiles + `
+labels:
+  0invalid: value
+`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unable to test the Alertmanager route: invalid label set: invalid name \"0invalid\"\n",
+		},
+		"should return error if the config is invalid": {
+			body: `
+alertmanager_config: |
+  route:
+    receiver: missing
+labels:
+  alertname: HighLatency
+`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "error validating Alertmanager config: undefined receiver \"missing\" used in route\n",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			store := prepareInMemoryAlertStore()
+			if testData.storedConfig != nil {
+				require.NoError(t, store.SetAlertConfig(context.Background(), *testData.storedConfig))
+			}
+
+			am := &MultitenantAlertmanager{
+				cfg:    &MultitenantAlertmanagerConfig{ExternalURL: flagext.URLValue{URL: &url.URL{Scheme: "http", Host: "alertmanager"}}},
+				store:  store,
+				logger: util_log.Logger,
+				limits: &mockAlertManagerLimits{},
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "http://alertmanager/api/v1/alerts/test_route", bytes.NewReader([]byte(testData.body)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+			w := httptest.NewRecorder()
+			am.TestUserConfigRoute(w, req)
+
+			require.Equal(t, testData.expectedStatus, w.Code, w.Body.String())
+			if testData.expectedBody != "" {
+				assert.Equal(t, testData.expectedBody, w.Body.String())
+			}
+
+			if testData.check != nil {
+				resp := TestRouteResponse{}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				testData.check(t, resp)
+			}
+		})
+	}
+}
@@ -188,6 +188,9 @@ func (a *API) RegisterAlertmanager(am *alertmanager.MultitenantAlertmanager, tar
 		a.RegisterRoute("/api/v1/alerts", http.HandlerFunc(am.GetUserConfig), true, "GET")
 		a.RegisterRoute("/api/v1/alerts", http.HandlerFunc(am.SetUserConfig), true, "POST")
 		a.RegisterRoute("/api/v1/alerts", http.HandlerFunc(am.DeleteUserConfig), true, "DELETE")
+		a.RegisterRoute("/api/v1/alerts/validate", http.HandlerFunc(am.ValidateUserConfig), true, "POST")
+		a.RegisterRoute("/api/v1/alerts/test_route", http.HandlerFunc(am.TestUserConfigRoute), true, "POST")
+		a.RegisterRoute("/api/v1/alerts/test_receiver", http.HandlerFunc(am.TestUserReceiver), true, "POST")
 	}
 
 	// If the target is Alertmanager, enable the legacy behaviour. Otherwise only enable
@@ -219,10 +222,18 @@ func (a *API) RegisterRuntimeConfig(runtimeConfigHandler http.HandlerFunc) {
 }
 
 // RegisterDistributor registers the endpoints associated with the distributor.
-func (a *API) RegisterDistributor(d *distributor.Distributor, pushConfig distributor.Config) {
+func (a *API) RegisterDistributor(d *distributor.Distributor, pushConfig distributor.Config) error {
+	graphiteTemplates, err := push.ParseGraphiteTemplates(pushConfig.GraphiteTemplates)
+	if err != nil {
+		return err
+	}
+
 	distributorpb.RegisterDistributorServer(a.server.GRPC, d)
 
 	a.RegisterRoute("/api/v1/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)), true, "POST")
+	a.RegisterRoute("/otlp/v1/metrics", push.OTLPHandler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)), true, "POST")
+	a.RegisterRoute("/api/v1/push/influx/write", push.InfluxHandler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)), true, "POST")
+	a.RegisterRoute("/api/v1/push/graphite", push.GraphiteHandler(pushConfig.MaxRecvMsgSize, a.sourceIPs, graphiteTemplates, a.cfg.wrapDistributorPush(d)), true, "POST")
 
 	a.indexPage.AddLink(SectionAdminEndpoints, "/distributor/ring", "Distributor Ring Status")
 	a.indexPage.AddLink(SectionAdminEndpoints, "/distributor/all_user_stats", "Usage Statistics")
@@ -236,6 +247,25 @@ func (a *API) RegisterDistributor(d *distributor.Distributor, pushConfig distrib
 	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/push"), push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)), true, "POST")
 	a.RegisterRoute("/all_user_stats", http.HandlerFunc(d.AllUserStatsHandler), false, "GET")
 	a.RegisterRoute("/ha-tracker", d.HATracker, false, "GET")
+
+	return nil
+}
+
+// NewGraphiteListener makes a listener for the Graphite metrics sent over raw TCP and UDP connections,
+// which pushes them to the distributor like the Graphite endpoint does, to the input tenant if not empty.
+func (a *API) NewGraphiteListener(d *distributor.Distributor, pushConfig distributor.Config, tenantID string, reg prometheus.Registerer) (*push.GraphiteListener, error) {
+	graphiteTemplates, err := push.ParseGraphiteTemplates(pushConfig.GraphiteTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	return push.NewGraphiteListener(push.GraphiteListenerConfig{
+		PlaintextListenAddress: pushConfig.GraphitePlaintextListenAddress,
+		PickleListenAddress:    pushConfig.GraphitePickleListenAddress,
+		TenantID:               tenantID,
+		MaxRecvMsgSize:         pushConfig.MaxRecvMsgSize,
+		Templates:              graphiteTemplates,
+	}, a.cfg.wrapDistributorPush(d), a.logger, reg), nil
 }
 
 // Ingester is defined as an interface to allow for alternative implementations
@@ -279,6 +309,19 @@ func (a *API) RegisterChunksPurger(store *purger.DeleteStore, deleteRequestCance
 	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/admin/tsdb/cancel_delete_request"), http.HandlerFunc(deleteRequestHandler.CancelDeleteRequestHandler), true, "PUT", "POST")
 }
 
+// RegisterBlocksPurger registers the endpoints associated with the series deletion for the blocks storage.
+// They mirror the endpoints exposed by the chunks storage purger.
+func (a *API) RegisterBlocksPurger(api *purger.BlocksPurgerAPI) {
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/admin/tsdb/delete_series"), http.HandlerFunc(api.AddDeleteRequestHandler), true, "PUT", "POST")
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/admin/tsdb/delete_series"), http.HandlerFunc(api.GetAllDeleteRequestsHandler), true, "GET")
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/admin/tsdb/cancel_delete_request"), http.HandlerFunc(api.CancelDeleteRequestHandler), true, "PUT", "POST")
+
+	// Legacy Routes
+	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/admin/tsdb/delete_series"), http.HandlerFunc(api.AddDeleteRequestHandler), true, "PUT", "POST")
+	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/admin/tsdb/delete_series"), http.HandlerFunc(api.GetAllDeleteRequestsHandler), true, "GET")
+	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/admin/tsdb/cancel_delete_request"), http.HandlerFunc(api.CancelDeleteRequestHandler), true, "PUT", "POST")
+}
+
 func (a *API) RegisterTenantDeletion(api *purger.TenantDeletionAPI) {
 	a.RegisterRoute("/purger/delete_tenant", http.HandlerFunc(api.DeleteTenant), true, "POST")
 	a.RegisterRoute("/purger/delete_tenant_status", http.HandlerFunc(api.DeleteTenantStatus), true, "GET")
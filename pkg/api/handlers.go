@@ -229,7 +229,8 @@ func NewQuerierHandler(
 		InflightRequests: inflightRequests,
 	}
 	cacheGenHeaderMiddleware := getHTTPCacheGenNumberHeaderSetterMiddleware(tombstonesLoader)
-	middlewares := middleware.Merge(inst, cacheGenHeaderMiddleware)
+	maxSourceResolutionMiddleware := getMaxSourceResolutionMiddleware()
+	middlewares := middleware.Merge(inst, cacheGenHeaderMiddleware, maxSourceResolutionMiddleware)
 	router.Use(middlewares.Wrap)
 
 	// Define the prefixes for all routes
@@ -6,6 +6,7 @@ import (
 	"github.com/weaveworks/common/middleware"
 
 	"github.com/cortexproject/cortex/pkg/chunk/purger"
+	"github.com/cortexproject/cortex/pkg/querier/downsampling"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	"github.com/cortexproject/cortex/pkg/tenant"
 )
@@ -27,3 +28,25 @@ func getHTTPCacheGenNumberHeaderSetterMiddleware(cacheGenNumbersLoader *purger.T
 		})
 	})
 }
+
+// middleware for injecting the max source resolution requested via the max_source_resolution parameter
+// into the request context, so that the querier only queries blocks up to the requested resolution.
+func getMaxSourceResolutionMiddleware() middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.FormValue(downsampling.MaxSourceResolutionParam)
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			resolution, err := downsampling.Parse(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(downsampling.InjectIntoContext(r.Context(), resolution)))
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cortexproject/cortex/pkg/querier/downsampling"
+)
+
+func TestMaxSourceResolutionMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		url                string
+		expectedStatusCode int
+		expectedResolution *downsampling.MaxSourceResolution
+	}{
+		"no parameter": {
+			url:                "/api/v1/query_range?query=up",
+			expectedStatusCode: http.StatusOK,
+		},
+		"auto": {
+			url:                "/api/v1/query_range?query=up&max_source_resolution=auto",
+			expectedStatusCode: http.StatusOK,
+			expectedResolution: &downsampling.MaxSourceResolution{Auto: true},
+		},
+		"duration": {
+			url:                "/api/v1/query_range?query=up&max_source_resolution=5m",
+			expectedStatusCode: http.StatusOK,
+			expectedResolution: &downsampling.MaxSourceResolution{Millis: 300000},
+		},
+		"invalid": {
+			url:                "/api/v1/query_range?query=up&max_source_resolution=foo",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var actual *downsampling.MaxSourceResolution
+
+			handler := getMaxSourceResolutionMiddleware().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if resolution, ok := downsampling.FromContext(r.Context()); ok {
+					actual = &resolution
+				}
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", testData.url, nil))
+
+			assert.Equal(t, testData.expectedStatusCode, rec.Code)
+			assert.Equal(t, testData.expectedResolution, actual)
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package purger
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// BlocksPurgerAPI provides the delete series API for the blocks storage. Delete requests are
+// stored as tombstones in the tenant's bucket location, applied at query time by the querier
+// and physically enforced by the compactor rewriting the affected blocks.
+type BlocksPurgerAPI struct {
+	bucketClient              objstore.Bucket
+	logger                    log.Logger
+	cfgProvider               bucket.TenantConfigProvider
+	deleteRequestCancelPeriod time.Duration
+	metrics                   *deleteRequestHandlerMetrics
+}
+
+// NewBlocksPurgerAPI makes a new BlocksPurgerAPI.
+func NewBlocksPurgerAPI(storageCfg cortex_tsdb.BlocksStorageConfig, cfgProvider bucket.TenantConfigProvider, deleteRequestCancelPeriod time.Duration, logger log.Logger, reg prometheus.Registerer) (*BlocksPurgerAPI, error) {
+	bucketClient, err := createBucketClient(storageCfg, logger, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlocksPurgerAPI(bucketClient, cfgProvider, deleteRequestCancelPeriod, logger, reg), nil
+}
+
+func newBlocksPurgerAPI(bkt objstore.Bucket, cfgProvider bucket.TenantConfigProvider, deleteRequestCancelPeriod time.Duration, logger log.Logger, reg prometheus.Registerer) *BlocksPurgerAPI {
+	return &BlocksPurgerAPI{
+		bucketClient:              bkt,
+		cfgProvider:               cfgProvider,
+		deleteRequestCancelPeriod: deleteRequestCancelPeriod,
+		logger:                    logger,
+		metrics:                   newDeleteRequestHandlerMetrics(reg),
+	}
+}
+
+// AddDeleteRequestHandler handles the addition of a new delete request.
+func (api *BlocksPurgerAPI) AddDeleteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := tenant.TenantID(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	match := params["match[]"]
+	if len(match) == 0 {
+		http.Error(w, "selectors not set", http.StatusBadRequest)
+		return
+	}
+
+	startParam := params.Get("start")
+	startTime := int64(0)
+	if startParam != "" {
+		startTime, err = util.ParseTime(startParam)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	endParam := params.Get("end")
+	endTime := int64(model.Now())
+
+	if endParam != "" {
+		endTime, err = util.ParseTime(endParam)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if endTime > int64(model.Now()) {
+			http.Error(w, "deletes in future not allowed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if startTime > endTime {
+		http.Error(w, "start time can't be greater than end time", http.StatusBadRequest)
+		return
+	}
+
+	requestID := getTombstoneRequestID(startTime, endTime, match)
+
+	// If the same request already exists, we don't overwrite it, so that its cancellation
+	// period and state are preserved. Cancelled requests can be submitted again.
+	existing, err := cortex_tsdb.ReadTombstone(ctx, api.bucketClient, userID, api.cfgProvider, requestID)
+	if err != nil && !errors.Is(err, cortex_tsdb.ErrTombstoneNotFound) {
+		level.Error(api.logger).Log("msg", "error reading tombstone", "user", userID, "request_id", requestID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && existing.State != cortex_tsdb.TombstoneCancelled {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	tombstone, err := cortex_tsdb.NewTombstone(requestID, time.Now().UnixNano()/int64(time.Millisecond), startTime, endTime, match)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := cortex_tsdb.WriteTombstone(ctx, api.bucketClient, userID, api.cfgProvider, tombstone); err != nil {
+		level.Error(api.logger).Log("msg", "error adding delete request to the bucket", "user", userID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	level.Info(api.logger).Log("msg", "delete request for blocks storage created", "user", userID, "request_id", requestID)
+
+	api.metrics.deleteRequestsReceivedTotal.WithLabelValues(userID).Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAllDeleteRequestsHandler returns all the delete requests of the tenant with their status.
+func (api *BlocksPurgerAPI) GetAllDeleteRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := tenant.TenantID(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tombstones, err := cortex_tsdb.ListTombstones(ctx, api.bucketClient, userID, api.cfgProvider)
+	if err != nil {
+		level.Error(api.logger).Log("msg", "error getting delete requests from the bucket", "user", userID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].RequestCreatedAt < tombstones[j].RequestCreatedAt
+	})
+
+	util.WriteJSONResponse(w, tombstones)
+}
+
+// CancelDeleteRequestHandler handles the cancellation of a delete request.
+func (api *BlocksPurgerAPI) CancelDeleteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := tenant.TenantID(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestID := r.URL.Query().Get("request_id")
+	if requestID == "" {
+		http.Error(w, "request_id not set", http.StatusBadRequest)
+		return
+	}
+
+	tombstone, err := cortex_tsdb.ReadTombstone(ctx, api.bucketClient, userID, api.cfgProvider, requestID)
+	if errors.Is(err, cortex_tsdb.ErrTombstoneNotFound) {
+		http.Error(w, "could not find delete request with given id", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		level.Error(api.logger).Log("msg", "error getting delete request from the bucket", "user", userID, "request_id", requestID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if tombstone.State != cortex_tsdb.TombstonePending {
+		http.Error(w, "deletion of request which is in process or already processed is not allowed", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if util.TimeFromMillis(tombstone.RequestCreatedAt).Add(api.deleteRequestCancelPeriod).Before(now) {
+		http.Error(w, fmt.Sprintf("deletion of request past the deadline of %s since its creation is not allowed", api.deleteRequestCancelPeriod.String()), http.StatusBadRequest)
+		return
+	}
+
+	tombstone.State = cortex_tsdb.TombstoneCancelled
+	tombstone.StateCreatedAt = now.UnixNano() / int64(time.Millisecond)
+
+	if err := cortex_tsdb.WriteTombstone(ctx, api.bucketClient, userID, api.cfgProvider, tombstone); err != nil {
+		level.Error(api.logger).Log("msg", "error cancelling the delete request", "user", userID, "request_id", requestID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getTombstoneRequestID returns a deterministic ID for a delete request, so that
+// submitting the same request multiple times doesn't create duplicated tombstones.
+func getTombstoneRequestID(startTime, endTime int64, selectors []string) string {
+	sorted := append([]string(nil), selectors...)
+	sort.Strings(sorted)
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strconv.FormatInt(startTime, 10)))
+	_, _ = h.Write([]byte(separator))
+	_, _ = h.Write([]byte(strconv.FormatInt(endTime, 10)))
+	_, _ = h.Write([]byte(separator))
+	_, _ = h.Write([]byte(strings.Join(sorted, separator)))
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// BlocksDeleteStore loads the delete requests of the blocks storage from the bucket, so
+// that they can be applied at query time through the TombstonesLoader.
+type BlocksDeleteStore struct {
+	bucketClient objstore.Bucket
+}
+
+// NewBlocksDeleteStore makes a new BlocksDeleteStore.
+func NewBlocksDeleteStore(storageCfg cortex_tsdb.BlocksStorageConfig, logger log.Logger, reg prometheus.Registerer) (*BlocksDeleteStore, error) {
+	bucketClient, err := createBucketClient(storageCfg, logger, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlocksDeleteStore{bucketClient: bucketClient}, nil
+}
+
+// GetPendingDeleteRequestsForUser returns the delete requests which should be applied at query
+// time. Processed requests are returned too, because the blocks rewritten by the compactor
+// replace the original ones only once they're deleted from the storage.
+func (s *BlocksDeleteStore) GetPendingDeleteRequestsForUser(ctx context.Context, userID string) ([]DeleteRequest, error) {
+	tombstones, err := cortex_tsdb.ListTombstones(ctx, s.bucketClient, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]DeleteRequest, 0, len(tombstones))
+	for _, t := range tombstones {
+		if t.State == cortex_tsdb.TombstoneCancelled {
+			continue
+		}
+
+		requests = append(requests, DeleteRequest{
+			RequestID: t.RequestID,
+			UserID:    userID,
+			StartTime: model.Time(t.StartTime),
+			EndTime:   model.Time(t.EndTime),
+			Selectors: t.Selectors,
+			Status:    tombstoneStateToDeleteRequestStatus(t.State),
+			CreatedAt: model.Time(t.RequestCreatedAt),
+		})
+	}
+
+	return requests, nil
+}
+
+// getCacheGenerationNumbers returns the most recent tombstone state change as cache generation
+// number, so that caches get invalidated whenever a delete request is added or cancelled.
+func (s *BlocksDeleteStore) getCacheGenerationNumbers(ctx context.Context, userID string) (*cacheGenNumbers, error) {
+	tombstones, err := cortex_tsdb.ListTombstones(ctx, s.bucketClient, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := int64(0)
+	for _, t := range tombstones {
+		if t.StateCreatedAt > latest {
+			latest = t.StateCreatedAt
+		}
+	}
+
+	if latest == 0 {
+		return &cacheGenNumbers{}, nil
+	}
+
+	genNumber := strconv.FormatInt(latest, 10)
+	return &cacheGenNumbers{store: genNumber, results: genNumber}, nil
+}
+
+func tombstoneStateToDeleteRequestStatus(state cortex_tsdb.TombstoneState) DeleteRequestStatus {
+	if state == cortex_tsdb.TombstoneProcessed {
+		return StatusProcessed
+	}
+	return StatusReceived
+}
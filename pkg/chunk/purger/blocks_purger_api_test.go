@@ -0,0 +1,159 @@
+package purger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/weaveworks/common/user"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+func TestBlocksPurgerAPI_AddGetCancelDeleteRequest(t *testing.T) {
+	const username = "user"
+
+	bkt := objstore.NewInMemBucket()
+	api := newBlocksPurgerAPI(bkt, nil, time.Hour, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	ctx := user.InjectOrgID(context.Background(), username)
+
+	// Missing tenant.
+	{
+		resp := httptest.NewRecorder()
+		api.AddDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?match[]=series_1", nil))
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+	}
+
+	// Invalid requests.
+	for _, params := range []url.Values{
+		{},
+		{"match[]": {"{job="}},
+		{"match[]": {"series_1"}, "start": {"20"}, "end": {"10"}},
+		{"match[]": {"series_1"}, "end": {"99999999999"}},
+	} {
+		resp := httptest.NewRecorder()
+		api.AddDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?"+params.Encode(), nil).WithContext(ctx))
+		require.Equal(t, http.StatusBadRequest, resp.Code, params.Encode())
+	}
+
+	// Valid request, submitted twice.
+	params := url.Values{"match[]": {"series_1"}, "start": {"10"}, "end": {"20"}}
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		api.AddDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?"+params.Encode(), nil).WithContext(ctx))
+		require.Equal(t, http.StatusNoContent, resp.Code)
+	}
+
+	tombstones := getDeleteRequests(ctx, t, api)
+	require.Len(t, tombstones, 1)
+	assert.Equal(t, cortex_tsdb.TombstonePending, tombstones[0].State)
+	assert.Equal(t, int64(10000), tombstones[0].StartTime)
+	assert.Equal(t, int64(20000), tombstones[0].EndTime)
+	assert.Equal(t, []string{"series_1"}, tombstones[0].Selectors)
+
+	// Cancel a non existing request.
+	{
+		resp := httptest.NewRecorder()
+		api.CancelDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?request_id=unknown", nil).WithContext(ctx))
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+	}
+
+	// Cancel the request.
+	{
+		resp := httptest.NewRecorder()
+		api.CancelDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?request_id="+tombstones[0].RequestID, nil).WithContext(ctx))
+		require.Equal(t, http.StatusNoContent, resp.Code)
+	}
+
+	tombstones = getDeleteRequests(ctx, t, api)
+	require.Len(t, tombstones, 1)
+	assert.Equal(t, cortex_tsdb.TombstoneCancelled, tombstones[0].State)
+
+	// A cancelled request can't be cancelled again.
+	{
+		resp := httptest.NewRecorder()
+		api.CancelDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?request_id="+tombstones[0].RequestID, nil).WithContext(ctx))
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+	}
+}
+
+func TestBlocksPurgerAPI_CancelDeleteRequestPastCancelPeriod(t *testing.T) {
+	const username = "user"
+
+	bkt := objstore.NewInMemBucket()
+	api := newBlocksPurgerAPI(bkt, nil, time.Hour, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	ctx := user.InjectOrgID(context.Background(), username)
+
+	createdAt := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+	tombstone, err := cortex_tsdb.NewTombstone("request-1", createdAt, 10, 20, []string{"series_1"})
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, username, nil, tombstone))
+
+	resp := httptest.NewRecorder()
+	api.CancelDeleteRequestHandler(resp, httptest.NewRequest(http.MethodPost, "/?request_id=request-1", nil).WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestBlocksDeleteStore(t *testing.T) {
+	const username = "user"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	store := &BlocksDeleteStore{bucketClient: bkt}
+
+	// No tombstones.
+	requests, err := store.GetPendingDeleteRequestsForUser(ctx, username)
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+
+	gen, err := store.getCacheGenerationNumbers(ctx, username)
+	require.NoError(t, err)
+	assert.Equal(t, &cacheGenNumbers{}, gen)
+
+	// Write tombstones in all states.
+	for _, data := range []struct {
+		id    string
+		state cortex_tsdb.TombstoneState
+	}{
+		{id: "request-1", state: cortex_tsdb.TombstonePending},
+		{id: "request-2", state: cortex_tsdb.TombstoneProcessed},
+		{id: "request-3", state: cortex_tsdb.TombstoneCancelled},
+	} {
+		tombstone, err := cortex_tsdb.NewTombstone(data.id, 1000, 10, 20, []string{"series_1"})
+		require.NoError(t, err)
+		tombstone.State = data.state
+		tombstone.StateCreatedAt = 2000
+		require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, username, nil, tombstone))
+	}
+
+	requests, err = store.GetPendingDeleteRequestsForUser(ctx, username)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []DeleteRequest{
+		{RequestID: "request-1", UserID: username, StartTime: 10, EndTime: 20, Selectors: []string{"series_1"}, Status: StatusReceived, CreatedAt: model.Time(1000)},
+		{RequestID: "request-2", UserID: username, StartTime: 10, EndTime: 20, Selectors: []string{"series_1"}, Status: StatusProcessed, CreatedAt: model.Time(1000)},
+	}, requests)
+
+	gen, err = store.getCacheGenerationNumbers(ctx, username)
+	require.NoError(t, err)
+	assert.Equal(t, &cacheGenNumbers{store: "2000", results: "2000"}, gen)
+}
+
+func getDeleteRequests(ctx context.Context, t *testing.T, api *BlocksPurgerAPI) []*cortex_tsdb.Tombstone {
+	resp := httptest.NewRecorder()
+	api.GetAllDeleteRequestsHandler(resp, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var tombstones []*cortex_tsdb.Tombstone
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tombstones))
+	return tombstones
+}
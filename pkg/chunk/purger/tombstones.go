@@ -48,6 +48,39 @@ type TombstonesSet struct {
 	oldestTombstoneStart, newestTombstoneEnd model.Time // Used as optimization to find whether we want to iterate over tombstones or not
 }
 
+// NewTombstonesSet makes a new TombstonesSet from the input delete requests. The
+// matchers of the delete requests are expected to be already parsed.
+func NewTombstonesSet(deleteRequests []DeleteRequest) *TombstonesSet {
+	set := &TombstonesSet{}
+	for _, req := range deleteRequests {
+		set.add(req)
+	}
+	return set
+}
+
+// Merge returns a new TombstonesSet containing the tombstones of both sets.
+func (ts *TombstonesSet) Merge(other *TombstonesSet) *TombstonesSet {
+	merged := &TombstonesSet{}
+	for _, set := range []*TombstonesSet{ts, other} {
+		for _, req := range set.tombstones {
+			merged.add(req)
+		}
+	}
+	return merged
+}
+
+func (ts *TombstonesSet) add(req DeleteRequest) {
+	if len(ts.tombstones) == 0 || req.StartTime < ts.oldestTombstoneStart {
+		ts.oldestTombstoneStart = req.StartTime
+	}
+
+	if len(ts.tombstones) == 0 || req.EndTime > ts.newestTombstoneEnd {
+		ts.newestTombstoneEnd = req.EndTime
+	}
+
+	ts.tombstones = append(ts.tombstones, req)
+}
+
 // Used for easier injection of mocks.
 type DeleteStoreAPI interface {
 	getCacheGenerationNumbers(ctx context.Context, user string) (*cacheGenNumbers, error)
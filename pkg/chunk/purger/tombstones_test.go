@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -134,6 +135,32 @@ func TestTombstonesLoader(t *testing.T) {
 	}
 }
 
+func TestTombstonesSet_Merge(t *testing.T) {
+	fooMatchers, err := parser.ParseMetricSelector("foo")
+	require.NoError(t, err)
+	barMatchers, err := parser.ParseMetricSelector("bar")
+	require.NoError(t, err)
+
+	first := NewTombstonesSet([]DeleteRequest{
+		{StartTime: modelTimeDay, EndTime: modelTimeDay * 2, Matchers: [][]*labels.Matcher{fooMatchers}},
+	})
+	second := NewTombstonesSet([]DeleteRequest{
+		{StartTime: 0, EndTime: modelTimeDay, Matchers: [][]*labels.Matcher{barMatchers}},
+	})
+
+	merged := first.Merge(second)
+	require.Equal(t, 2, merged.Len())
+	assert.True(t, merged.HasTombstonesForInterval(0, modelTimeDay/2))
+	assert.True(t, merged.HasTombstonesForInterval(modelTimeDay*2, modelTimeDay*3))
+	assert.False(t, merged.HasTombstonesForInterval(modelTimeDay*3, modelTimeDay*4))
+
+	assert.Equal(t, []model.Interval{{Start: modelTimeDay, End: modelTimeDay * 2}}, merged.GetDeletedIntervals(labels.FromStrings(labels.MetricName, "foo"), 0, modelTimeDay*3))
+	assert.Equal(t, []model.Interval{{Start: 0, End: modelTimeDay}}, merged.GetDeletedIntervals(labels.FromStrings(labels.MetricName, "bar"), 0, modelTimeDay*3))
+
+	// Merging with an empty set.
+	assert.Equal(t, first, first.Merge(NewTombstonesSet(nil)))
+}
+
 func TestTombstonesLoader_GetCacheGenNumber(t *testing.T) {
 	s := &store{
 		numbers: map[string]*cacheGenNumbers{
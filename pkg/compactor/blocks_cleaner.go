@@ -17,6 +17,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 
+	"github.com/cortexproject/cortex/pkg/storage/auditlog"
 	"github.com/cortexproject/cortex/pkg/storage/bucket"
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
@@ -30,6 +31,9 @@ type BlocksCleanerConfig struct {
 	CleanupConcurrency                 int
 	BlockDeletionMarksMigrationEnabled bool          // TODO Discuss whether we should remove it in Cortex 1.8.0 and document that upgrading to 1.7.0 before 1.8.0 is required.
 	TenantCleanupDelay                 time.Duration // Delay before removing tenant deletion mark and "debug".
+	QueryAuditLogRetentionPeriod       time.Duration // Retention period of the query audit log, 0 to disable.
+	QueryAuditLogBucket                objstore.Bucket
+	StoreGatewayLoads                  func(ctx context.Context) (map[string]int64, error) // Reads the store-gateways load to store in the bucket index, nil to disable.
 }
 
 type BlocksCleaner struct {
@@ -139,7 +143,12 @@ func (c *BlocksCleaner) runCleanup(ctx context.Context, firstRun bool) {
 	level.Info(c.logger).Log("msg", "started blocks cleanup and maintenance")
 	c.runsStarted.Inc()
 
-	if err := c.cleanUsers(ctx, firstRun); err == nil {
+	err := c.cleanUsers(ctx, firstRun)
+	if err == nil {
+		err = c.cleanQueryAuditLog(ctx)
+	}
+
+	if err == nil {
 		level.Info(c.logger).Log("msg", "successfully completed blocks cleanup and maintenance")
 		c.runsCompleted.Inc()
 		c.runsLastSuccess.SetToCurrentTime()
@@ -183,6 +192,19 @@ func (c *BlocksCleaner) cleanUsers(ctx context.Context, firstRun bool) error {
 	})
 }
 
+// cleanQueryAuditLog deletes the expired query audit log files of the owned tenants, if enabled.
+func (c *BlocksCleaner) cleanQueryAuditLog(ctx context.Context) error {
+	if c.cfg.QueryAuditLogRetentionPeriod <= 0 || c.cfg.QueryAuditLogBucket == nil {
+		return nil
+	}
+
+	deleted, err := auditlog.DeleteExpiredRecords(ctx, c.cfg.QueryAuditLogBucket, c.cfg.QueryAuditLogRetentionPeriod, time.Now(), c.usersScanner.IsOwned, c.logger)
+	if deleted > 0 {
+		level.Info(c.logger).Log("msg", "deleted expired query audit log files", "files", deleted)
+	}
+	return errors.Wrap(err, "failed to delete expired query audit log files")
+}
+
 // Remove blocks and remaining data for tenant marked for deletion.
 func (c *BlocksCleaner) deleteUserMarkedForDeletion(ctx context.Context, userID string) error {
 	userLogger := util_log.WithUserID(userID, c.logger)
@@ -361,6 +383,17 @@ func (c *BlocksCleaner) cleanUser(ctx context.Context, userID string, firstRun b
 		c.cleanUserPartialBlocks(ctx, partials, idx, userBucket, userLogger)
 	}
 
+	// Snapshot the load published by the store-gateways, so that queriers and store-gateways using
+	// the balanced sharding strategy place the blocks based on the same loads. On failure, the
+	// previous snapshot is kept.
+	if c.cfg.StoreGatewayLoads != nil {
+		if loads, err := c.cfg.StoreGatewayLoads(ctx); err != nil {
+			level.Warn(userLogger).Log("msg", "failed to read the store-gateways load, the previous one is kept in the bucket index", "err", err)
+		} else {
+			idx.StoreGatewayLoads = loads
+		}
+	}
+
 	// Upload the updated index to the storage.
 	if err := bucketindex.WriteIndex(ctx, c.bucketClient, userID, c.cfgProvider, idx); err != nil {
 		return err
@@ -26,6 +26,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	cortex_testutil "github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 type testBlocksCleanerOptions struct {
@@ -341,6 +342,49 @@ func TestBlocksCleaner_ShouldRebuildBucketIndexOnCorruptedOne(t *testing.T) {
 	assert.ElementsMatch(t, []ulid.ULID{block3}, idx.BlockDeletionMarks.GetULIDs())
 }
 
+func TestBlocksCleaner_ShouldStoreTheStoreGatewaysLoadInTheBucketIndex(t *testing.T) {
+	const userID = "user-1"
+
+	bucketClient, _ := cortex_testutil.PrepareFilesystemBucket(t)
+	bucketClient = bucketindex.BucketWithGlobalMarkers(bucketClient)
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	block1 := createTSDBBlock(t, bucketClient, userID, 10, 20, nil)
+
+	loads := map[string]int64{"127.0.0.1": 100, "127.0.0.2": 200}
+	var loadsErr error
+
+	cfg := BlocksCleanerConfig{
+		DeletionDelay:      time.Hour,
+		CleanupInterval:    time.Minute,
+		CleanupConcurrency: 1,
+		StoreGatewayLoads: func(context.Context) (map[string]int64, error) {
+			return loads, loadsErr
+		},
+	}
+
+	scanner := tsdb.NewUsersScanner(bucketClient, tsdb.AllUsers, logger)
+	cleaner := NewBlocksCleaner(cfg, bucketClient, scanner, newMockConfigProvider(), logger, nil)
+
+	require.NoError(t, cleaner.cleanUser(ctx, userID, false))
+
+	idx, err := bucketindex.ReadIndex(ctx, bucketClient, userID, nil, logger)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ulid.ULID{block1}, idx.Blocks.GetULIDs())
+	assert.Equal(t, loads, idx.StoreGatewayLoads)
+
+	// On failure, the previous loads are kept.
+	loadsErr = errors.New("failed to read the loads")
+	loads = nil
+
+	require.NoError(t, cleaner.cleanUser(ctx, userID, false))
+
+	idx, err = bucketindex.ReadIndex(ctx, bucketClient, userID, nil, logger)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"127.0.0.1": 100, "127.0.0.2": 200}, idx.StoreGatewayLoads)
+}
+
 func TestBlocksCleaner_ShouldRemoveMetricsForTenantsNotBelongingAnymoreToTheShard(t *testing.T) {
 	bucketClient, _ := cortex_testutil.PrepareFilesystemBucket(t)
 	bucketClient = bucketindex.BucketWithGlobalMarkers(bucketClient)
@@ -410,6 +454,43 @@ func TestBlocksCleaner_ShouldRemoveMetricsForTenantsNotBelongingAnymoreToTheShar
 	))
 }
 
+func TestBlocksCleaner_ShouldDeleteExpiredQueryAuditLogFiles(t *testing.T) {
+	bucketClient, _ := cortex_testutil.PrepareFilesystemBucket(t)
+	auditBucket := objstore.NewInMemBucket()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	expired := path.Join("user-1", now.Add(-72*time.Hour).Format("2006-01-02/15"), "01.jsonl")
+	notExpired := path.Join("user-1", now.Format("2006-01-02/15"), "01.jsonl")
+	notOwned := path.Join("user-2", now.Add(-72*time.Hour).Format("2006-01-02/15"), "01.jsonl")
+	for _, name := range []string{expired, notExpired, notOwned} {
+		require.NoError(t, auditBucket.Upload(ctx, name, strings.NewReader("{}\n")))
+	}
+
+	cfg := BlocksCleanerConfig{
+		DeletionDelay:                time.Hour,
+		CleanupInterval:              time.Minute,
+		CleanupConcurrency:           1,
+		QueryAuditLogRetentionPeriod: 24 * time.Hour,
+		QueryAuditLogBucket:          auditBucket,
+	}
+
+	logger := log.NewNopLogger()
+	isOwned := func(userID string) (bool, error) {
+		return userID == "user-1", nil
+	}
+	scanner := tsdb.NewUsersScanner(bucketClient, isOwned, logger)
+	cleaner := NewBlocksCleaner(cfg, bucketClient, scanner, newMockConfigProvider(), logger, nil)
+
+	cleaner.runCleanup(ctx, true)
+
+	for name, expectExists := range map[string]bool{expired: false, notExpired: true, notOwned: true} {
+		exists, err := auditBucket.Exists(ctx, name)
+		require.NoError(t, err)
+		assert.Equal(t, expectExists, exists, name)
+	}
+}
+
 func TestBlocksCleaner_ListBlocksOutsideRetentionPeriod(t *testing.T) {
 	bucketClient, _ := cortex_testutil.PrepareFilesystemBucket(t)
 	bucketClient = bucketindex.BucketWithGlobalMarkers(bucketClient)
@@ -655,11 +736,19 @@ func (m *mockBucketFailure) Delete(ctx context.Context, name string) error {
 
 type mockConfigProvider struct {
 	userRetentionPeriods map[string]time.Duration
+	userSplitShards      map[string]int
+	userRetentionRules   map[string][]validation.RetentionRule
+	userDownsampling     map[string]bool
+	userTenantShardSize  map[string]int
 }
 
 func newMockConfigProvider() *mockConfigProvider {
 	return &mockConfigProvider{
 		userRetentionPeriods: make(map[string]time.Duration),
+		userSplitShards:      make(map[string]int),
+		userRetentionRules:   make(map[string][]validation.RetentionRule),
+		userDownsampling:     make(map[string]bool),
+		userTenantShardSize:  make(map[string]int),
 	}
 }
 
@@ -670,6 +759,22 @@ func (m *mockConfigProvider) CompactorBlocksRetentionPeriod(user string) time.Du
 	return 0
 }
 
+func (m *mockConfigProvider) CompactorSplitShards(user string) int {
+	return m.userSplitShards[user]
+}
+
+func (m *mockConfigProvider) CompactorTenantShardSize(user string) int {
+	return m.userTenantShardSize[user]
+}
+
+func (m *mockConfigProvider) CompactorRetentionRules(user string) []validation.RetentionRule {
+	return m.userRetentionRules[user]
+}
+
+func (m *mockConfigProvider) CompactorDownsamplingEnabled(user string) bool {
+	return m.userDownsampling[user]
+}
+
 func (m *mockConfigProvider) S3SSEType(user string) string {
 	return ""
 }
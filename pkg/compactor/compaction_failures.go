@@ -0,0 +1,266 @@
+package compactor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/errutil"
+)
+
+const (
+	// compactionFailuresNoCompactReason is the reason of the no-compact marks of the blocks
+	// whose compaction has failed too many times in a row.
+	compactionFailuresNoCompactReason = metadata.NoCompactReason("compaction-failures")
+)
+
+// compactionFailures tracks the failed compactions of the blocks of a user during a compaction
+// attempt. The blocks whose compaction has failed in too many consecutive attempts are marked for
+// no-compaction, so that they don't block the compaction of the other blocks of the user.
+type compactionFailures struct {
+	bkt                      objstore.Bucket
+	logger                   log.Logger
+	maxFailures              int
+	blocksMarkedForNoCompact prometheus.Counter
+
+	// Number of consecutive failures of the compaction plans in the previous attempts,
+	// by the key of the blocks planned for compaction.
+	previous map[string]int
+
+	mtx sync.Mutex
+	// Groups returned by the last Groups call of the grouper, by group key.
+	groups map[string]*compact.Group
+	// Groups whose compaction has failed in this attempt, by the key of the blocks of the group.
+	failed map[string]struct{}
+	// Blocks planned for compaction, by the key of the blocks of the group.
+	plans map[string][]ulid.ULID
+	// Number of consecutive failures of the compaction plans, including this attempt.
+	current map[string]int
+	errs    []error
+}
+
+func newCompactionFailures(bkt objstore.Bucket, logger log.Logger, maxFailures int, previous map[string]int, blocksMarkedForNoCompact prometheus.Counter) *compactionFailures {
+	return &compactionFailures{
+		bkt:                      bkt,
+		logger:                   logger,
+		maxFailures:              maxFailures,
+		blocksMarkedForNoCompact: blocksMarkedForNoCompact,
+		previous:                 previous,
+		groups:                   map[string]*compact.Group{},
+		failed:                   map[string]struct{}{},
+		plans:                    map[string][]ulid.ULID{},
+		current:                  map[string]int{},
+	}
+}
+
+// wrapPlanner returns a compact.Planner keeping track of the blocks planned for compaction
+// in each group, which are the ones marked for no-compaction if their compaction fails.
+func (f *compactionFailures) wrapPlanner(planner compact.Planner) compact.Planner {
+	return &failuresTrackingPlanner{Planner: planner, failures: f}
+}
+
+// wrapGrouper returns a compact.Grouper keeping track of the groups to compact, and excluding
+// the ones whose compaction has failed in this attempt.
+func (f *compactionFailures) wrapGrouper(grouper compact.Grouper) compact.Grouper {
+	return &failuresTrackingGrouper{Grouper: grouper, failures: f}
+}
+
+// compact runs the compaction of the bucket compactor. The bucket compactor stops at the first
+// failed group, so the compaction is run again, excluding the failed groups, until it succeeds
+// or fails for a reason other than the compaction of some groups.
+func (f *compactionFailures) compact(ctx context.Context, compactor *compact.BucketCompactor) error {
+	for {
+		err := compactor.Compact(ctx)
+		if err == nil || !f.handleCompactionError(ctx, err) {
+			return err
+		}
+	}
+}
+
+// handleCompactionError records the compaction failures of the groups reported by the error
+// returned by the bucket compactor. It returns false if the error is not only made of groups
+// compaction failures, or the failures can't be handled.
+func (f *compactionFailures) handleCompactionError(ctx context.Context, err error) bool {
+	groupErrs, ok := err.(errutil.NonNilMultiError)
+	if !ok {
+		return false
+	}
+
+	groups := make([]*compact.Group, 0, len(groupErrs))
+	for _, groupErr := range groupErrs {
+		g := f.failedGroup(groupErr)
+		if g == nil {
+			return false
+		}
+		groups = append(groups, g)
+	}
+
+	for i, g := range groups {
+		if !f.handleGroupFailure(ctx, g, groupErrs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// failedGroup returns the group whose compaction failure is reported by the input error, which
+// the bucket compactor prefixes with the group key, or nil if the group is unknown.
+func (f *compactionFailures) failedGroup(err error) *compact.Group {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for key, g := range f.groups {
+		if strings.HasPrefix(err.Error(), fmt.Sprintf("group %s: ", key)) {
+			return g
+		}
+	}
+	return nil
+}
+
+// handleGroupFailure records the compaction failure of a group, which is excluded from the
+// next compaction runs of this attempt in order to continue the compaction of the other groups
+// of the user. It returns false if the compaction has been interrupted.
+func (f *compactionFailures) handleGroupFailure(ctx context.Context, g *compact.Group, err error) bool {
+	// The compaction has been interrupted (ie. compactor shutdown has been triggered).
+	if ctx.Err() != nil {
+		return false
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.failed[blocksKey(g.IDs())] = struct{}{}
+	plan := f.plans[blocksKey(g.IDs())]
+
+	// Retriable errors, like the object storage ones, are not caused by the blocks.
+	if compact.IsRetryError(err) || len(plan) == 0 || f.maxFailures <= 0 {
+		f.errs = append(f.errs, err)
+		return true
+	}
+
+	key := blocksKey(plan)
+	failures := f.previous[key] + 1
+	f.current[key] = failures
+
+	if failures < f.maxFailures {
+		f.errs = append(f.errs, err)
+		return true
+	}
+
+	level.Warn(f.logger).Log("msg", "marking blocks for no-compaction because their compaction failed too many times in a row", "group", g.Key(), "blocks", key, "failures", failures, "err", err)
+
+	details := fmt.Sprintf("compaction failed %d times in a row: %s", failures, err.Error())
+	for _, id := range plan {
+		if markErr := block.MarkForNoCompact(ctx, f.logger, f.bkt, id, compactionFailuresNoCompactReason, details, f.blocksMarkedForNoCompact); markErr != nil {
+			f.errs = append(f.errs, errors.Wrapf(markErr, "mark block %s for no-compaction", id.String()))
+			return true
+		}
+	}
+
+	// The blocks won't be compacted anymore, so we don't need to track them.
+	delete(f.current, key)
+	return true
+}
+
+// failures returns the number of consecutive failures of the compaction plans, including this attempt.
+func (f *compactionFailures) failures() map[string]int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.current
+}
+
+// err returns the compaction failures not resulting in the blocks being marked for no-compaction.
+func (f *compactionFailures) err() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return tsdb_errors.NewMulti(f.errs...).Err()
+}
+
+func (f *compactionFailures) setPlan(group, plan []*metadata.Meta) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.plans[blocksKey(metasIDs(group))] = metasIDs(plan)
+}
+
+// setGroups keeps track of the groups to compact, and returns the ones whose compaction
+// hasn't failed in this attempt.
+func (f *compactionFailures) setGroups(groups []*compact.Group) []*compact.Group {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.groups = make(map[string]*compact.Group, len(groups))
+	filtered := make([]*compact.Group, 0, len(groups))
+	for _, g := range groups {
+		if _, failed := f.failed[blocksKey(g.IDs())]; failed {
+			continue
+		}
+
+		f.groups[g.Key()] = g
+		filtered = append(filtered, g)
+	}
+	return filtered
+}
+
+type failuresTrackingGrouper struct {
+	compact.Grouper
+
+	failures *compactionFailures
+}
+
+// Groups implements compact.Grouper.
+func (g *failuresTrackingGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) ([]*compact.Group, error) {
+	groups, err := g.Grouper.Groups(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return g.failures.setGroups(groups), nil
+}
+
+type failuresTrackingPlanner struct {
+	compact.Planner
+
+	failures *compactionFailures
+}
+
+// Plan implements compact.Planner.
+func (p *failuresTrackingPlanner) Plan(ctx context.Context, metasByMinTime []*metadata.Meta) ([]*metadata.Meta, error) {
+	plan, err := p.Planner.Plan(ctx, metasByMinTime)
+	if err == nil && len(plan) > 0 {
+		p.failures.setPlan(metasByMinTime, plan)
+	}
+	return plan, err
+}
+
+func metasIDs(metas []*metadata.Meta) []ulid.ULID {
+	ids := make([]ulid.ULID, 0, len(metas))
+	for _, meta := range metas {
+		ids = append(ids, meta.ULID)
+	}
+	return ids
+}
+
+// blocksKey returns a key uniquely identifying the input set of blocks.
+func blocksKey(ids []ulid.ULID) string {
+	sorted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		sorted = append(sorted, id.String())
+	}
+	sort.Strings(sorted)
+
+	return strings.Join(sorted, ",")
+}
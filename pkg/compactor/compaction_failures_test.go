@@ -0,0 +1,246 @@
+package compactor
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/errutil"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestCompactionFailures_ShouldMarkBlocksForNoCompactionAfterMaxFailures(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+	block2 := createTSDBBlock(t, bkt, userID, 20, 30, map[string]string{"__org_id__": userID})
+	block3 := createTSDBBlock(t, bkt, userID, 30, 40, map[string]string{"__org_id__": userID})
+
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	group, err := compact.NewGroup(log.NewNopLogger(), userBucket, "group", labels.FromMap(map[string]string{"__org_id__": userID}), 0, false, false,
+		prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), "")
+	require.NoError(t, err)
+
+	groupMetas := []*metadata.Meta{metas[block1], metas[block2], metas[block3]}
+	for _, meta := range groupMetas {
+		require.NoError(t, group.AppendMeta(meta))
+	}
+
+	markedForNoCompact := prometheus.NewCounter(prometheus.CounterOpts{})
+	compactionErr := errors.New("corrupted block")
+
+	var previous map[string]int
+	for attempt := 1; attempt <= 3; attempt++ {
+		failures := newCompactionFailures(userBucket, log.NewNopLogger(), 3, previous, markedForNoCompact)
+
+		// Only the first two blocks are planned for compaction.
+		_, err := failures.wrapPlanner(&mockPlanner{plan: groupMetas[:2]}).Plan(ctx, groupMetas)
+		require.NoError(t, err)
+
+		// The failure should be handled, to continue the compaction of the other groups.
+		assert.True(t, failures.handleGroupFailure(ctx, group, compactionErr))
+		previous = failures.failures()
+
+		if attempt < 3 {
+			assert.Equal(t, map[string]int{blocksKey([]ulid.ULID{block1, block2}): attempt}, previous)
+			assert.Error(t, failures.err())
+			continue
+		}
+
+		// The failure shouldn't be reported anymore once the blocks have been marked for no-compaction.
+		assert.Empty(t, previous)
+		assert.NoError(t, failures.err())
+	}
+
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(markedForNoCompact))
+	for blockID, expected := range map[ulid.ULID]bool{block1: true, block2: true, block3: false} {
+		exists, err := bkt.Exists(ctx, path.Join(userID, blockID.String(), metadata.NoCompactMarkFilename))
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists, blockID.String())
+	}
+
+	// Blocks should not be marked for no-compaction if the compaction failed before planning.
+	failures := newCompactionFailures(userBucket, log.NewNopLogger(), 1, nil, markedForNoCompact)
+	assert.True(t, failures.handleGroupFailure(ctx, group, compactionErr))
+	assert.Error(t, failures.err())
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(markedForNoCompact))
+}
+
+func TestCompactionFailures_ShouldExcludeTheFailedGroupsFromTheNextCompactionRuns(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	meta := func(minT, maxT int64) *metadata.Meta {
+		id := ulid.MustNew(uint64(minT), nil)
+		return &metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: minT, MaxTime: maxT, Compaction: tsdb.BlockMetaCompaction{Sources: []ulid.ULID{id}}},
+			Thanos:    metadata.Thanos{Labels: map[string]string{"__org_id__": userID}},
+		}
+	}
+	block1, block2 := meta(10, 20), meta(20, 30)
+	blocks := map[ulid.ULID]*metadata.Meta{block1.ULID: block1, block2.ULID: block2}
+
+	failures := newCompactionFailures(objstore.NewInMemBucket(), log.NewNopLogger(), 3, nil, prometheus.NewCounter(prometheus.CounterOpts{}))
+	grouper := failures.wrapGrouper(&mockGrouper{})
+
+	groups, err := grouper.Groups(blocks)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+
+	// Errors not reporting the failure of known groups are not handled.
+	assert.False(t, failures.handleCompactionError(ctx, errors.New("sync")))
+	assert.False(t, failures.handleCompactionError(ctx, errutil.NonNilMultiError{errors.New("group unknown: corrupted block")}))
+
+	compactionErr := errors.Wrapf(errors.New("corrupted block"), "group %s", groups[0].Key())
+	assert.True(t, failures.handleCompactionError(ctx, errutil.NonNilMultiError{compactionErr}))
+	assert.EqualError(t, failures.err(), compactionErr.Error())
+
+	// The failed group is not compacted again in this attempt.
+	groups, err = grouper.Groups(blocks)
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+
+	// The failure is handled only once the compaction has not been interrupted.
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	failures = newCompactionFailures(objstore.NewInMemBucket(), log.NewNopLogger(), 3, nil, prometheus.NewCounter(prometheus.CounterOpts{}))
+	groups, err = failures.wrapGrouper(&mockGrouper{}).Groups(blocks)
+	require.NoError(t, err)
+	compactionErr = errors.Wrapf(errors.New("corrupted block"), "group %s", groups[0].Key())
+	assert.False(t, failures.handleCompactionError(cancelledCtx, errutil.NonNilMultiError{compactionErr}))
+}
+
+func TestCompactor_PendingBlocksRewrite(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	now := time.Now()
+
+	cfg := prepareConfig()
+	cfg.SeriesDeletionEnabled = true
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+	c.bucketClient = bkt
+
+	cfgProvider := newMockConfigProvider()
+	c.cfgProvider = cfgProvider
+
+	oldMeta := func(minT, maxT int64) *metadata.Meta {
+		id := ulid.MustNew(uint64(minT), nil)
+		return &metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: minT, MaxTime: maxT, Compaction: tsdb.BlockMetaCompaction{Sources: []ulid.ULID{id}}},
+			Thanos:    metadata.Thanos{Labels: map[string]string{"__org_id__": userID}},
+		}
+	}
+
+	deleted := oldMeta(10, 20)
+	expired := oldMeta(20, 30)
+	recent := oldMeta(util.TimeToMillis(now.Add(-10*time.Minute)), util.TimeToMillis(now))
+
+	tombstone, err := cortex_tsdb.NewTombstone("request", 0, 10, 15, []string{`{series_id="0"}`})
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, userID, nil, tombstone))
+
+	cancelled, err := cortex_tsdb.NewTombstone("cancelled", 0, 20, 30, []string{`{series_id="0"}`})
+	require.NoError(t, err)
+	cancelled.State = cortex_tsdb.TombstoneCancelled
+	require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, userID, nil, cancelled))
+
+	// Without retention rules, only the blocks overlapping a pending tombstone are going to be rewritten.
+	pending, err := c.pendingBlocksRewrite(ctx, userID, userBucket, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.True(t, pending(deleted))
+	assert.False(t, pending(expired))
+	assert.False(t, pending(recent))
+
+	// Blocks past the period of a rule not applied yet are going to be rewritten.
+	rule := validation.RetentionRule{Selector: `{series_id="1"}`, Period: model.Duration(24 * time.Hour)}
+	cfgProvider.userRetentionRules[userID] = []validation.RetentionRule{rule}
+
+	pending, err = c.pendingBlocksRewrite(ctx, userID, userBucket, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.True(t, pending(deleted))
+	assert.True(t, pending(expired))
+	assert.False(t, pending(recent))
+
+	// Blocks which the rule has already been applied to are not.
+	state := &retentionState{Groups: map[string]map[string][]string{}}
+	state.setAppliedSelectors(expired, []string{rule.Selector})
+	require.NoError(t, writeRetentionState(ctx, userBucket, state))
+
+	pending, err = c.pendingBlocksRewrite(ctx, userID, userBucket, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.False(t, pending(expired))
+
+	// Excluded blocks should not be grouped for compaction.
+	grouper := &excludeBlocksGrouper{Grouper: &mockGrouper{}, exclude: pending}
+	groups, err := grouper.Groups(map[ulid.ULID]*metadata.Meta{deleted.ULID: deleted, expired.ULID: expired, recent.ULID: recent})
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.ElementsMatch(t, []ulid.ULID{expired.ULID, recent.ULID}, groups[0].IDs())
+}
+
+type mockPlanner struct {
+	plan []*metadata.Meta
+}
+
+func (p *mockPlanner) Plan(_ context.Context, _ []*metadata.Meta) ([]*metadata.Meta, error) {
+	return p.plan, nil
+}
+
+// mockGrouper returns all the blocks in a single group.
+type mockGrouper struct{}
+
+func (g *mockGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) ([]*compact.Group, error) {
+	var lset labels.Labels
+	for _, meta := range blocks {
+		lset = labels.FromMap(meta.Thanos.Labels)
+	}
+
+	group, err := compact.NewGroup(log.NewNopLogger(), nil, "group", lset, 0, false, false,
+		prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, meta := range blocks {
+		if err := group.AppendMeta(meta); err != nil {
+			return nil, err
+		}
+	}
+	return []*compact.Group{group}, nil
+}
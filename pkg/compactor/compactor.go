@@ -19,6 +19,7 @@ import (
 	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/ring"
 	"github.com/grafana/dskit/services"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -34,6 +35,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	"github.com/cortexproject/cortex/pkg/util"
 	util_log "github.com/cortexproject/cortex/pkg/util/log"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 const (
@@ -42,8 +44,12 @@ const (
 )
 
 var (
-	errInvalidBlockRanges = "compactor block range periods should be divisible by the previous one, but %s is not divisible by %s"
-	RingOp                = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
+	errInvalidBlockRanges        = "compactor block range periods should be divisible by the previous one, but %s is not divisible by %s"
+	errInvalidCompactionStrategy = "unsupported compaction strategy %s (supported values: %s)"
+	errInvalidShardingStrategy   = "unsupported sharding strategy %s (supported values: %s)"
+	RingOp                       = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
+
+	supportedShardingStrategies = []string{util.ShardingStrategyDefault, util.ShardingStrategyShuffle}
 
 	DefaultBlocksGrouperFactory = func(ctx context.Context, cfg Config, bkt objstore.Bucket, logger log.Logger, reg prometheus.Registerer, blocksMarkedForDeletion prometheus.Counter, garbageCollectedBlocks prometheus.Counter) compact.Grouper {
 		return compact.NewDefaultGrouper(
@@ -98,6 +104,7 @@ type Config struct {
 	CompactionInterval    time.Duration            `yaml:"compaction_interval"`
 	CompactionRetries     int                      `yaml:"compaction_retries"`
 	CompactionConcurrency int                      `yaml:"compaction_concurrency"`
+	MaxCompactionFailures int                      `yaml:"max_compaction_failures"`
 	CleanupInterval       time.Duration            `yaml:"cleanup_interval"`
 	CleanupConcurrency    int                      `yaml:"cleanup_concurrency"`
 	DeletionDelay         time.Duration            `yaml:"deletion_delay"`
@@ -106,12 +113,16 @@ type Config struct {
 	// Whether the migration of block deletion marks to the global markers location is enabled.
 	BlockDeletionMarksMigrationEnabled bool `yaml:"block_deletion_marks_migration_enabled"`
 
+	// Compaction strategy.
+	CompactionStrategy string `yaml:"compaction_strategy"`
+
 	EnabledTenants  flagext.StringSliceCSV `yaml:"enabled_tenants"`
 	DisabledTenants flagext.StringSliceCSV `yaml:"disabled_tenants"`
 
 	// Compactors sharding.
-	ShardingEnabled bool       `yaml:"sharding_enabled"`
-	ShardingRing    RingConfig `yaml:"sharding_ring"`
+	ShardingEnabled  bool       `yaml:"sharding_enabled"`
+	ShardingStrategy string     `yaml:"sharding_strategy"`
+	ShardingRing     RingConfig `yaml:"sharding_ring"`
 
 	// No need to add options to customize the retry backoff,
 	// given the defaults should be fine, but allow to override
@@ -119,6 +130,22 @@ type Config struct {
 	retryMinBackoff time.Duration `yaml:"-"`
 	retryMaxBackoff time.Duration `yaml:"-"`
 
+	// Series deletion, configured from the purger config.
+	SeriesDeletionEnabled     bool          `yaml:"-"`
+	DeleteRequestCancelPeriod time.Duration `yaml:"-"`
+
+	// How long the samples may only be in the ingesters, configured from the querier and ingesters config.
+	// Delete requests are applied once their time range is older than it.
+	IngestersRetentionPeriod time.Duration `yaml:"-"`
+
+	// Query audit log retention, configured from the query audit log config. 0 to disable.
+	QueryAuditLogRetentionPeriod time.Duration `yaml:"-"`
+	QueryAuditLogStorage         bucket.Config `yaml:"-"`
+
+	// Reads the load published by the store-gateways, which is stored in the bucket index when the
+	// store-gateways use the balanced sharding strategy. Nil otherwise.
+	StoreGatewayLoads func(ctx context.Context) (map[string]int64, error) `yaml:"-"`
+
 	// Allow downstream projects to customise the blocks compactor.
 	BlocksGrouperFactory   BlocksGrouperFactory   `yaml:"-"`
 	BlocksCompactorFactory BlocksCompactorFactory `yaml:"-"`
@@ -140,15 +167,18 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.CompactionInterval, "compactor.compaction-interval", time.Hour, "The frequency at which the compaction runs")
 	f.IntVar(&cfg.CompactionRetries, "compactor.compaction-retries", 3, "How many times to retry a failed compaction within a single compaction run.")
 	f.IntVar(&cfg.CompactionConcurrency, "compactor.compaction-concurrency", 1, "Max number of concurrent compactions running.")
+	f.IntVar(&cfg.MaxCompactionFailures, "compactor.max-compaction-failures", 5, "Number of consecutive compaction attempts of the same blocks failing with a non-retriable error after which the blocks are marked for no-compaction, so that they don't block the compaction of the other blocks of the tenant. Failed compactions of a tenant's blocks don't interrupt the compaction of its other blocks. 0 to never mark blocks for no-compaction.")
 	f.DurationVar(&cfg.CleanupInterval, "compactor.cleanup-interval", 15*time.Minute, "How frequently compactor should run blocks cleanup and maintenance, as well as update the bucket index.")
 	f.IntVar(&cfg.CleanupConcurrency, "compactor.cleanup-concurrency", 20, "Max number of tenants for which blocks cleanup and maintenance should run concurrently.")
 	f.BoolVar(&cfg.ShardingEnabled, "compactor.sharding-enabled", false, "Shard tenants across multiple compactor instances. Sharding is required if you run multiple compactor instances, in order to coordinate compactions and avoid race conditions leading to the same tenant blocks simultaneously compacted by different instances.")
+	f.StringVar(&cfg.ShardingStrategy, "compactor.sharding-strategy", util.ShardingStrategyDefault, fmt.Sprintf("The sharding strategy to use. Supported values are: %s. The shuffle-sharding strategy shards each tenant across -compactor.tenant-shard-size compactor instances, and shards the tenant's compaction jobs across the instances of its shard, so that they can compact the same tenant concurrently.", strings.Join(supportedShardingStrategies, ", ")))
 	f.DurationVar(&cfg.DeletionDelay, "compactor.deletion-delay", 12*time.Hour, "Time before a block marked for deletion is deleted from bucket. "+
 		"If not 0, blocks will be marked for deletion and compactor component will permanently delete blocks marked for deletion from the bucket. "+
 		"If 0, blocks will be deleted straight away. Note that deleting blocks immediately can cause query failures.")
 	f.DurationVar(&cfg.TenantCleanupDelay, "compactor.tenant-cleanup-delay", 6*time.Hour, "For tenants marked for deletion, this is time between deleting of last block, and doing final cleanup (marker files, debug files) of the tenant.")
 	f.BoolVar(&cfg.BlockDeletionMarksMigrationEnabled, "compactor.block-deletion-marks-migration-enabled", true, "When enabled, at compactor startup the bucket will be scanned and all found deletion marks inside the block location will be copied to the markers global location too. This option can (and should) be safely disabled as soon as the compactor has successfully run at least once.")
 
+	f.StringVar(&cfg.CompactionStrategy, "compactor.compaction-strategy", CompactionStrategyDefault, fmt.Sprintf("The compaction strategy to use. Supported values are: %s. The split-and-merge strategy splits each block time range into -compactor.split-shards shards by series hash, and compacts each shard independently. When sharding is enabled, split-and-merge compaction jobs are sharded across compactor instances instead of tenants.", strings.Join(supportedCompactionStrategies, ", ")))
 	f.Var(&cfg.EnabledTenants, "compactor.enabled-tenants", "Comma separated list of tenants that can be compacted. If specified, only these tenants will be compacted by compactor, otherwise all tenants can be compacted. Subject to sharding.")
 	f.Var(&cfg.DisabledTenants, "compactor.disabled-tenants", "Comma separated list of tenants that cannot be compacted by this compactor. If specified, and compactor would normally pick given tenant for compaction (via -compactor.enabled-tenants or sharding), it will be ignored instead.")
 }
@@ -161,6 +191,14 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if !util.StringsContain(supportedCompactionStrategies, cfg.CompactionStrategy) {
+		return errors.Errorf(errInvalidCompactionStrategy, cfg.CompactionStrategy, strings.Join(supportedCompactionStrategies, ", "))
+	}
+
+	if cfg.ShardingEnabled && !util.StringsContain(supportedShardingStrategies, cfg.ShardingStrategy) {
+		return errors.Errorf(errInvalidShardingStrategy, cfg.ShardingStrategy, strings.Join(supportedShardingStrategies, ", "))
+	}
+
 	return nil
 }
 
@@ -168,6 +206,10 @@ func (cfg *Config) Validate() error {
 type ConfigProvider interface {
 	bucket.TenantConfigProvider
 	CompactorBlocksRetentionPeriod(user string) time.Duration
+	CompactorSplitShards(user string) int
+	CompactorRetentionRules(user string) []validation.RetentionRule
+	CompactorDownsamplingEnabled(user string) bool
+	CompactorTenantShardSize(user string) int
 }
 
 // Compactor is a multi-tenant TSDB blocks compactor based on Thanos.
@@ -201,6 +243,10 @@ type Compactor struct {
 	// Client used to run operations on the bucket storing blocks.
 	bucketClient objstore.Bucket
 
+	// Number of consecutive compaction failures of the same blocks, by user and blocks.
+	// Users are compacted sequentially, so it doesn't need to be synchronized.
+	compactionFailures map[string]map[string]int
+
 	// Ring used for sharding compactions.
 	ringLifecycler         *ring.Lifecycler
 	ring                   *ring.Ring
@@ -218,7 +264,13 @@ type Compactor struct {
 	compactionRunFailedTenants     prometheus.Gauge
 	compactionRunInterval          prometheus.Gauge
 	blocksMarkedForDeletion        prometheus.Counter
+	blocksMarkedForNoCompact       prometheus.Counter
 	garbageCollectedBlocks         prometheus.Counter
+	blocksMarkedForSeriesDeletion  prometheus.Counter
+	blocksMarkedForRetentionRules  prometheus.Counter
+	deleteRequestsProcessed        prometheus.Counter
+	blocksSplit                    prometheus.Counter
+	blocksDownsampled              *prometheus.CounterVec
 
 	// TSDB syncer metrics
 	syncerMetrics *syncerMetrics
@@ -270,6 +322,7 @@ func newCompactor(
 		blocksGrouperFactory:   blocksGrouperFactory,
 		blocksCompactorFactory: blocksCompactorFactory,
 		allowedTenants:         util.NewAllowedTenants(compactorCfg.EnabledTenants, compactorCfg.DisabledTenants),
+		compactionFailures:     map[string]map[string]int{},
 
 		compactionRunsStarted: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
 			Name: "cortex_compactor_runs_started_total",
@@ -312,10 +365,36 @@ func newCompactor(
 			Help:        blocksMarkedForDeletionHelp,
 			ConstLabels: prometheus.Labels{"reason": "compaction"},
 		}),
+		blocksMarkedForNoCompact: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_compactor_blocks_marked_for_no_compaction_total",
+			Help: "Total number of blocks marked for no-compaction because their compaction failed too many times in a row.",
+		}),
 		garbageCollectedBlocks: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
 			Name: "cortex_compactor_garbage_collected_blocks_total",
 			Help: "Total number of blocks marked for deletion by compactor.",
 		}),
+		blocksMarkedForSeriesDeletion: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name:        blocksMarkedForDeletionName,
+			Help:        blocksMarkedForDeletionHelp,
+			ConstLabels: prometheus.Labels{"reason": "series-deletion"},
+		}),
+		blocksMarkedForRetentionRules: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name:        blocksMarkedForDeletionName,
+			Help:        blocksMarkedForDeletionHelp,
+			ConstLabels: prometheus.Labels{"reason": "retention-rules"},
+		}),
+		deleteRequestsProcessed: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_compactor_delete_requests_processed_total",
+			Help: "Total number of series delete requests applied to the blocks by the compactor.",
+		}),
+		blocksSplit: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_compactor_blocks_split_total",
+			Help: "Total number of blocks split into shards by the split-and-merge compactor.",
+		}),
+		blocksDownsampled: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_compactor_blocks_downsampled_total",
+			Help: "Total number of downsampled blocks uploaded by the compactor.",
+		}, []string{"resolution"}),
 	}
 
 	if len(compactorCfg.EnabledTenants) > 0 {
@@ -355,6 +434,15 @@ func (c *Compactor) starting(ctx context.Context) error {
 	// Create the users scanner.
 	c.usersScanner = cortex_tsdb.NewUsersScanner(c.bucketClient, c.ownUser, c.parentLogger)
 
+	// Create the query audit log bucket client, if its retention is enabled.
+	var queryAuditLogBucket objstore.Bucket
+	if c.compactorCfg.QueryAuditLogRetentionPeriod > 0 {
+		queryAuditLogBucket, err = bucket.NewClient(ctx, c.compactorCfg.QueryAuditLogStorage, "compactor-query-audit-log", c.logger, c.registerer)
+		if err != nil {
+			return errors.Wrap(err, "failed to create query audit log bucket client")
+		}
+	}
+
 	// Create the blocks cleaner (service).
 	c.blocksCleaner = NewBlocksCleaner(BlocksCleanerConfig{
 		DeletionDelay:                      c.compactorCfg.DeletionDelay,
@@ -362,6 +450,9 @@ func (c *Compactor) starting(ctx context.Context) error {
 		CleanupConcurrency:                 c.compactorCfg.CleanupConcurrency,
 		BlockDeletionMarksMigrationEnabled: c.compactorCfg.BlockDeletionMarksMigrationEnabled,
 		TenantCleanupDelay:                 c.compactorCfg.TenantCleanupDelay,
+		QueryAuditLogRetentionPeriod:       c.compactorCfg.QueryAuditLogRetentionPeriod,
+		QueryAuditLogBucket:                queryAuditLogBucket,
+		StoreGatewayLoads:                  c.compactorCfg.StoreGatewayLoads,
 	}, c.bucketClient, c.usersScanner, c.cfgProvider, c.parentLogger, c.registerer)
 
 	// Initialize the compactors ring if sharding is enabled.
@@ -507,7 +598,7 @@ func (c *Compactor) compactUsers(ctx context.Context) {
 		}
 
 		// Ensure the user ID belongs to our shard.
-		if owned, err := c.ownUser(userID); err != nil {
+		if owned, err := c.ownUserForCompaction(userID); err != nil {
 			c.compactionRunSkippedTenants.Inc()
 			level.Warn(c.logger).Log("msg", "unable to check if user is owned by this shard", "user", userID, "err", err)
 			continue
@@ -542,6 +633,13 @@ func (c *Compactor) compactUsers(ctx context.Context) {
 		level.Info(c.logger).Log("msg", "successfully compacted user blocks", "user", userID)
 	}
 
+	// Forget the compaction failures of the users not owned anymore.
+	for userID := range c.compactionFailures {
+		if _, owned := ownedUsers[userID]; !owned {
+			delete(c.compactionFailures, userID)
+		}
+	}
+
 	// Delete local files for unowned tenants, if there are any. This cleans up
 	// leftover local files for tenants that belong to different compactors now,
 	// or have been deleted completely.
@@ -612,6 +710,9 @@ func (c *Compactor) compactUser(ctx context.Context, userID string) error {
 		0,
 		c.compactorCfg.MetaSyncConcurrency)
 
+	// Gathers the blocks marked for no-compaction, which are excluded from the compaction groups.
+	noCompactMarkFilter := compact.NewGatherNoCompactionMarkFilter(ulogger, bucket, c.compactorCfg.MetaSyncConcurrency)
+
 	fetcher, err := block.NewMetaFetcher(
 		ulogger,
 		c.compactorCfg.MetaSyncConcurrency,
@@ -626,6 +727,7 @@ func (c *Compactor) compactUser(ctx context.Context, userID string) error {
 			block.NewConsistencyDelayMetaFilter(ulogger, c.compactorCfg.ConsistencyDelay, reg),
 			ignoreDeletionMarkFilter,
 			deduplicateBlocksFilter,
+			noCompactMarkFilter,
 		},
 		nil,
 	)
@@ -633,6 +735,26 @@ func (c *Compactor) compactUser(ctx context.Context, userID string) error {
 		return err
 	}
 
+	// Blocks are rewritten by a single compactor per user, even when the user's
+	// compaction jobs are run by multiple compactors. The other compactors don't
+	// compact or split the blocks waiting to be rewritten, otherwise the same blocks
+	// could be compacted and rewritten concurrently.
+	pendingRewrite := func(*metadata.Meta) bool { return false }
+
+	if owned, err := c.ownUserForBlocksRewrite(userID); err != nil {
+		return errors.Wrap(err, "failed to check user ownership")
+	} else if owned {
+		if err := c.applyTombstones(ctx, userID, bucket, fetcher, ulogger); err != nil {
+			return errors.Wrap(err, "series deletion")
+		}
+
+		if err := c.applyRetentionRules(ctx, userID, bucket, fetcher, ulogger); err != nil {
+			return errors.Wrap(err, "retention rules")
+		}
+	} else if pendingRewrite, err = c.pendingBlocksRewrite(ctx, userID, bucket, ulogger); err != nil {
+		return errors.Wrap(err, "failed to check blocks pending rewrite")
+	}
+
 	syncer, err := compact.NewMetaSyncer(
 		ulogger,
 		reg,
@@ -648,11 +770,49 @@ func (c *Compactor) compactUser(ctx context.Context, userID string) error {
 		return errors.Wrap(err, "failed to create syncer")
 	}
 
+	ownJob := func(jobKey string) (bool, error) {
+		return c.ownJob(userID, jobKey)
+	}
+
+	var grouper compact.Grouper
+	planner := c.blocksPlanner
+
+	switch {
+	case c.compactorCfg.CompactionStrategy == CompactionStrategySplitAndMerge:
+		shards := c.cfgProvider.CompactorSplitShards(userID)
+
+		if err := c.splitBlocks(ctx, userID, bucket, fetcher, shards, pendingRewrite, ulogger); err != nil {
+			return errors.Wrap(err, "split blocks")
+		}
+
+		grouper = c.blocksGrouperFactory(ctx, c.compactorCfg, bucket, ulogger, reg, c.blocksMarkedForDeletion, c.garbageCollectedBlocks)
+		grouper = newSplitAndMergeGrouper(userID, shards, grouper, ownJob)
+
+	case c.isShuffleShardingEnabled():
+		// Compaction jobs are planned by the grouper, so that they can be sharded
+		// across the compactors of the tenant's shard.
+		grouper = newShuffleShardingGrouper(userID, bucket, c.compactorCfg.BlockRanges.ToMilliseconds(), ulogger, reg, c.blocksMarkedForDeletion, c.garbageCollectedBlocks, ownJob)
+		planner = &shuffleShardingPlanner{}
+
+	default:
+		grouper = c.blocksGrouperFactory(ctx, c.compactorCfg, bucket, ulogger, reg, c.blocksMarkedForDeletion, c.garbageCollectedBlocks)
+	}
+
+	// Blocks which repeatedly fail to be compacted are marked for no-compaction, while
+	// the compaction of the other groups continues when a group fails.
+	failures := newCompactionFailures(bucket, ulogger, c.compactorCfg.MaxCompactionFailures, c.compactionFailures[userID], c.blocksMarkedForNoCompact)
+	grouper = &excludeBlocksGrouper{Grouper: grouper, exclude: func(meta *metadata.Meta) bool {
+		_, noCompact := noCompactMarkFilter.NoCompactMarkedBlocks()[meta.ULID]
+		return noCompact || pendingRewrite(meta)
+	}}
+	grouper = failures.wrapGrouper(grouper)
+	planner = failures.wrapPlanner(planner)
+
 	compactor, err := compact.NewBucketCompactor(
 		ulogger,
 		syncer,
-		c.blocksGrouperFactory(ctx, c.compactorCfg, bucket, ulogger, reg, c.blocksMarkedForDeletion, c.garbageCollectedBlocks),
-		c.blocksPlanner,
+		grouper,
+		planner,
 		c.blocksCompactor,
 		path.Join(c.compactorCfg.DataDir, "compact"),
 		bucket,
@@ -663,10 +823,19 @@ func (c *Compactor) compactUser(ctx context.Context, userID string) error {
 		return errors.Wrap(err, "failed to create bucket compactor")
 	}
 
-	if err := compactor.Compact(ctx); err != nil {
+	err = failures.compact(ctx, compactor)
+	c.compactionFailures[userID] = failures.failures()
+	if err != nil {
+		return errors.Wrap(err, "compaction")
+	}
+	if err := failures.err(); err != nil {
 		return errors.Wrap(err, "compaction")
 	}
 
+	if err := c.downsampleBlocks(ctx, userID, bucket, fetcher, ulogger); err != nil {
+		return errors.Wrap(err, "downsampling")
+	}
+
 	return nil
 }
 
@@ -709,18 +878,122 @@ func (c *Compactor) ownUser(userID string) (bool, error) {
 		return false, nil
 	}
 
+	return c.ownKey(c.ring, userID)
+}
+
+// ownUserForCompaction returns whether this compactor should run the compaction of the user.
+// With the shuffle-sharding strategy, every allowed user is compacted by all the compactors
+// of its shard, each one running only the jobs it owns. With the split-and-merge strategy,
+// compaction jobs are sharded instead of users, so every allowed user is compacted by all
+// compactors, each one running only the jobs it owns.
+func (c *Compactor) ownUserForCompaction(userID string) (bool, error) {
+	if c.isShuffleShardingEnabled() {
+		if !c.allowedTenants.IsAllowed(userID) {
+			return false, nil
+		}
+
+		return c.ring.ShuffleShard(userID, c.cfgProvider.CompactorTenantShardSize(userID)).HasInstance(c.ringLifecycler.ID), nil
+	}
+
+	if c.compactorCfg.CompactionStrategy == CompactionStrategySplitAndMerge {
+		return c.allowedTenants.IsAllowed(userID), nil
+	}
+
+	return c.ownUser(userID)
+}
+
+// ownUserForBlocksRewrite returns whether this compactor should rewrite the blocks of the user
+// to apply series deletion and retention rules. Blocks rewrites are run by a single compactor
+// among the ones running the compaction of the user.
+func (c *Compactor) ownUserForBlocksRewrite(userID string) (bool, error) {
+	if c.isShuffleShardingEnabled() {
+		return c.ownJob(userID, userID)
+	}
+
+	return c.ownUser(userID)
+}
+
+// pendingBlocksRewrite returns a function telling whether a block is going to be rewritten by the
+// compactor applying the user's series deletions and retention rules.
+func (c *Compactor) pendingBlocksRewrite(ctx context.Context, userID string, userBucket objstore.Bucket, logger log.Logger) (func(*metadata.Meta) bool, error) {
+	var tombstones []*cortex_tsdb.Tombstone
+	if c.compactorCfg.SeriesDeletionEnabled {
+		all, err := cortex_tsdb.ListTombstones(ctx, c.bucketClient, userID, c.cfgProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list tombstones")
+		}
+
+		// Pending tombstones can't be applied before the cancellation period, so even the
+		// ones not ready to be applied yet won't be applied while the blocks get compacted.
+		for _, t := range all {
+			if t.State == cortex_tsdb.TombstonePending {
+				tombstones = append(tombstones, t)
+			}
+		}
+	}
+
+	rules := c.cfgProvider.CompactorRetentionRules(userID)
+	state := &retentionState{}
+	if len(rules) > 0 {
+		var err error
+		if state, err = readRetentionState(ctx, userBucket, logger); err != nil {
+			return nil, errors.Wrap(err, "failed to read retention state")
+		}
+	}
+
+	return func(meta *metadata.Meta) bool {
+		for _, t := range tombstones {
+			// Block max time is exclusive.
+			if t.Overlaps(meta.MinTime, meta.MaxTime-1) {
+				return true
+			}
+		}
+
+		if len(rules) == 0 {
+			return false
+		}
+
+		// Rules expiring before the next compaction run are considered too, so that
+		// blocks compacted by this compactor don't get rewritten in the meanwhile.
+		deadline := time.Now().Add(c.compactorCfg.CompactionInterval)
+		applied := state.appliedSelectors(meta)
+
+		for _, rule := range rules {
+			if meta.MaxTime <= util.TimeToMillis(deadline.Add(-time.Duration(rule.Period))) && !util.StringsContain(applied, rule.Selector) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// ownJob returns whether this compactor owns the compaction job of the user with the given key.
+// With the shuffle-sharding strategy, jobs are sharded across the compactors of the user's shard.
+func (c *Compactor) ownJob(userID, jobKey string) (bool, error) {
+	if c.isShuffleShardingEnabled() {
+		return c.ownKey(c.ring.ShuffleShard(userID, c.cfgProvider.CompactorTenantShardSize(userID)), jobKey)
+	}
+
+	return c.ownKey(c.ring, jobKey)
+}
+
+func (c *Compactor) isShuffleShardingEnabled() bool {
+	return c.compactorCfg.ShardingEnabled && c.compactorCfg.ShardingStrategy == util.ShardingStrategyShuffle
+}
+
+func (c *Compactor) ownKey(r ring.ReadRing, key string) (bool, error) {
 	// Always owned if sharding is disabled.
 	if !c.compactorCfg.ShardingEnabled {
 		return true, nil
 	}
 
-	// Hash the user ID.
+	// Hash the key.
 	hasher := fnv.New32a()
-	_, _ = hasher.Write([]byte(userID))
-	userHash := hasher.Sum32()
+	_, _ = hasher.Write([]byte(key))
+	keyHash := hasher.Sum32()
 
-	// Check whether this compactor instance owns the user.
-	rs, err := c.ring.Get(userHash, RingOp, nil, nil, nil)
+	// Check whether this compactor instance owns the key.
+	rs, err := r.Get(keyHash, RingOp, nil, nil, nil)
 	if err != nil {
 		return false, err
 	}
@@ -765,3 +1038,23 @@ func (c *Compactor) listTenantsWithMetaSyncDirectories() map[string]struct{} {
 
 	return result
 }
+
+// excludeBlocksGrouper is a compact.Grouper excluding some blocks from the groups returned
+// by the wrapped grouper.
+type excludeBlocksGrouper struct {
+	compact.Grouper
+
+	exclude func(meta *metadata.Meta) bool
+}
+
+// Groups implements compact.Grouper.
+func (g *excludeBlocksGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) ([]*compact.Group, error) {
+	filtered := make(map[ulid.ULID]*metadata.Meta, len(blocks))
+	for id, meta := range blocks {
+		if !g.exclude(meta) {
+			filtered[id] = meta
+		}
+	}
+
+	return g.Grouper.Groups(filtered)
+}
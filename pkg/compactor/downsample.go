@@ -0,0 +1,156 @@
+package compactor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+// downsampleBlocks downsamples the tenant's raw blocks to 5m resolution and the 5m blocks to 1h
+// resolution, like the Thanos compactor does. A block is downsampled only once it spans the whole
+// downsampling range (or the largest compaction range, if shorter), so that each time range is
+// downsampled once. Original blocks are kept: they're only deleted by the retention.
+func (c *Compactor) downsampleBlocks(ctx context.Context, userID string, userBucket objstore.Bucket, fetcher block.MetadataFetcher, logger log.Logger) error {
+	if !c.cfgProvider.CompactorDownsamplingEnabled(userID) {
+		return nil
+	}
+
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch blocks metadata")
+	}
+
+	// Collect the source blocks which have already been downsampled at each resolution.
+	sources5m := map[ulid.ULID]struct{}{}
+	sources1h := map[ulid.ULID]struct{}{}
+
+	for _, meta := range metas {
+		switch meta.Thanos.Downsample.Resolution {
+		case downsample.ResLevel1:
+			for _, id := range meta.Compaction.Sources {
+				sources5m[id] = struct{}{}
+			}
+		case downsample.ResLevel2:
+			for _, id := range meta.Compaction.Sources {
+				sources1h[id] = struct{}{}
+			}
+		}
+	}
+
+	workDir := filepath.Join(c.compactorCfg.DataDir, "downsample", userID)
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			level.Warn(logger).Log("msg", "failed to remove downsampling working directory", "dir", workDir, "err", err)
+		}
+	}()
+
+	var largestRange int64
+	if ranges := c.compactorCfg.BlockRanges.ToMilliseconds(); len(ranges) > 0 {
+		largestRange = ranges[len(ranges)-1]
+	}
+
+	for _, meta := range metas {
+		var (
+			resolution int64
+			minRange   int64
+			sources    map[ulid.ULID]struct{}
+		)
+
+		switch meta.Thanos.Downsample.Resolution {
+		case downsample.ResLevel0:
+			resolution, minRange, sources = downsample.ResLevel1, downsample.DownsampleRange0, sources5m
+		case downsample.ResLevel1:
+			resolution, minRange, sources = downsample.ResLevel2, downsample.DownsampleRange1, sources1h
+		default:
+			continue
+		}
+
+		// Skip the block if all its sources have already been downsampled.
+		missing := false
+		for _, id := range meta.Compaction.Sources {
+			if _, ok := sources[id]; !ok {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+
+		if largestRange > 0 && largestRange < minRange {
+			minRange = largestRange
+		}
+		if meta.MaxTime-meta.MinTime < minRange {
+			continue
+		}
+
+		// With the split-and-merge strategy or shuffle sharding, multiple compactors process
+		// the same tenant, so the downsampling of each block must be run by a single compactor.
+		if c.compactorCfg.CompactionStrategy == CompactionStrategySplitAndMerge || c.isShuffleShardingEnabled() {
+			if owned, err := c.ownJob(userID, downsampleJobKey(userID, meta.ULID)); err != nil {
+				return err
+			} else if !owned {
+				continue
+			}
+		}
+
+		if err := c.downsampleBlock(ctx, userBucket, workDir, meta, resolution, logger); err != nil {
+			return errors.Wrapf(err, "failed to downsample block %s", meta.ULID.String())
+		}
+	}
+
+	return nil
+}
+
+func (c *Compactor) downsampleBlock(ctx context.Context, userBucket objstore.Bucket, workDir string, meta *metadata.Meta, resolution int64, logger log.Logger) error {
+	blockDir := filepath.Join(workDir, meta.ULID.String())
+	if err := os.RemoveAll(blockDir); err != nil {
+		return errors.Wrap(err, "failed to clean block directory")
+	}
+
+	if err := block.Download(ctx, logger, userBucket, meta.ULID, blockDir); err != nil {
+		return errors.Wrap(err, "failed to download block")
+	}
+
+	b, err := tsdb.OpenBlock(logger, blockDir, downsample.NewPool())
+	if err != nil {
+		return errors.Wrap(err, "failed to open block")
+	}
+
+	id, err := downsample.Downsample(logger, meta, b, workDir, resolution)
+	if closeErr := b.Close(); closeErr != nil {
+		level.Warn(logger).Log("msg", "failed to close block", "block", meta.ULID.String(), "err", closeErr)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to downsample block")
+	}
+
+	resDir := filepath.Join(workDir, id.String())
+	if err := block.VerifyIndex(logger, filepath.Join(resDir, block.IndexFilename), meta.MinTime, meta.MaxTime); err != nil {
+		return errors.Wrap(err, "downsampled block index is invalid")
+	}
+
+	if err := block.Upload(ctx, logger, userBucket, resDir, metadata.NoneFunc); err != nil {
+		return errors.Wrap(err, "failed to upload downsampled block")
+	}
+
+	c.blocksDownsampled.WithLabelValues(strconv.FormatInt(resolution, 10)).Inc()
+	level.Info(logger).Log("msg", "uploaded downsampled block", "block", meta.ULID.String(), "resolution", resolution, "result_block", id.String())
+
+	return os.RemoveAll(resDir)
+}
+
+func downsampleJobKey(userID string, blockID ulid.ULID) string {
+	return userID + "/downsample/" + blockID.String()
+}
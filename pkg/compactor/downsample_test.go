@@ -0,0 +1,167 @@
+package compactor
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+func TestCompactor_DownsampleBlocks(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	// The first block spans the largest compaction range, while the second one doesn't.
+	block1 := createTSDBBlock(t, bkt, userID, 10, 30, map[string]string{"__org_id__": userID})
+	block2 := createTSDBBlock(t, bkt, userID, 30, 35, map[string]string{"__org_id__": userID})
+
+	cfg := prepareConfig()
+	cfg.BlockRanges = cortex_tsdb.DurationList{10 * time.Millisecond}
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+
+	cfgProvider := newMockConfigProvider()
+	c.cfgProvider = cfgProvider
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+
+	// Downsampling is disabled by default.
+	require.NoError(t, c.downsampleBlocks(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+	assert.Equal(t, map[int64][]ulid.ULID{
+		downsample.ResLevel0: {block1, block2},
+	}, blocksByResolution(ctx, t, fetcher))
+
+	cfgProvider.userDownsampling[userID] = true
+
+	// The first run should downsample the raw block to 5m resolution.
+	require.NoError(t, c.downsampleBlocks(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+	actual := blocksByResolution(ctx, t, fetcher)
+	require.Len(t, actual[downsample.ResLevel1], 1)
+	assert.Len(t, actual[downsample.ResLevel2], 0)
+	assert.Equal(t, float64(1), prom_testutil.ToFloat64(c.blocksDownsampled.WithLabelValues("300000")))
+
+	// The second run should downsample the 5m block to 1h resolution only.
+	require.NoError(t, c.downsampleBlocks(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+	actual = blocksByResolution(ctx, t, fetcher)
+	assert.Len(t, actual[downsample.ResLevel0], 2)
+	assert.Len(t, actual[downsample.ResLevel1], 1)
+	assert.Len(t, actual[downsample.ResLevel2], 1)
+	assert.Equal(t, float64(1), prom_testutil.ToFloat64(c.blocksDownsampled.WithLabelValues("300000")))
+	assert.Equal(t, float64(1), prom_testutil.ToFloat64(c.blocksDownsampled.WithLabelValues("3600000")))
+
+	// Running again should be a no-op, because all the blocks have already been downsampled.
+	require.NoError(t, c.downsampleBlocks(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+	assert.Equal(t, actual, blocksByResolution(ctx, t, fetcher))
+
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	for _, meta := range metas {
+		if meta.Thanos.Downsample.Resolution == downsample.ResLevel0 {
+			continue
+		}
+
+		assert.Equal(t, int64(10), meta.MinTime)
+		assert.Equal(t, int64(30), meta.MaxTime)
+		assert.Equal(t, []ulid.ULID{block1}, meta.Compaction.Sources)
+		assert.Equal(t, userID, meta.Thanos.Labels["__org_id__"])
+	}
+}
+
+func TestCompactor_DownsampleBlocks_ShouldDownsampleEachShardOfSplitBlocks(t *testing.T) {
+	const (
+		userID = "user-1"
+		shards = 3
+	)
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	// The two series of the block belong to different shards, while one of the shards is empty.
+	createTSDBBlock(t, bkt, userID, 10, 30, map[string]string{"__org_id__": userID})
+
+	cfg := prepareConfig()
+	cfg.BlockRanges = cortex_tsdb.DurationList{10 * time.Millisecond}
+	cfg.CompactionStrategy = CompactionStrategySplitAndMerge
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+
+	var err error
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	cfgProvider := newMockConfigProvider()
+	cfgProvider.userDownsampling[userID] = true
+	c.cfgProvider = cfgProvider
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, []block.MetadataFilter{
+		block.NewIgnoreDeletionMarkFilter(log.NewNopLogger(), userBucket, 0, 1),
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, c.splitBlocks(ctx, userID, userBucket, fetcher, shards, func(*metadata.Meta) bool { return false }, log.NewNopLogger()))
+	require.Len(t, blocksByResolution(ctx, t, fetcher)[downsample.ResLevel0], 2)
+
+	// Downsample one of the split blocks, like another compactor owning its downsampling would do.
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	for _, meta := range metas {
+		if meta.Thanos.Labels[cortex_tsdb.CompactorShardIDExternalLabel] == "1_of_3" {
+			require.NoError(t, c.downsampleBlock(ctx, userBucket, t.TempDir(), meta, downsample.ResLevel1, log.NewNopLogger()))
+		}
+	}
+
+	// The other split block should be downsampled too, even if it has been split from the same block.
+	require.NoError(t, c.downsampleBlocks(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+	require.NoError(t, c.downsampleBlocks(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	metas, _, err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	shardsByResolution := map[int64][]string{}
+	for _, meta := range metas {
+		res := meta.Thanos.Downsample.Resolution
+		shardsByResolution[res] = append(shardsByResolution[res], meta.Thanos.Labels[cortex_tsdb.CompactorShardIDExternalLabel])
+	}
+
+	assert.ElementsMatch(t, []string{"1_of_3", "2_of_3"}, shardsByResolution[downsample.ResLevel0])
+	assert.ElementsMatch(t, []string{"1_of_3", "2_of_3"}, shardsByResolution[downsample.ResLevel1])
+	assert.ElementsMatch(t, []string{"1_of_3", "2_of_3"}, shardsByResolution[downsample.ResLevel2])
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.blocksDownsampled.WithLabelValues("300000")))
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.blocksDownsampled.WithLabelValues("3600000")))
+}
+
+func blocksByResolution(ctx context.Context, t *testing.T, fetcher block.MetadataFetcher) map[int64][]ulid.ULID {
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	res := map[int64][]ulid.ULID{}
+	for id, meta := range metas {
+		res[meta.Thanos.Downsample.Resolution] = append(res[meta.Thanos.Downsample.Resolution], id)
+	}
+
+	for _, ids := range res {
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
+	}
+
+	return res
+}
@@ -0,0 +1,230 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/runutil"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// RetentionStateFilename is the name of the file, stored within the tenant location, keeping
+// track of the retention rules whose series have already been deleted from the blocks.
+const RetentionStateFilename = "retention-state.json"
+
+// retentionState keeps track of the retention rules applied to the tenant's blocks. Rules are
+// tracked by source block within each compaction group, so that the state is carried over when
+// blocks are compacted together: a block whose sources have all had a rule applied in the same
+// compaction group doesn't contain any series matching the rule.
+type retentionState struct {
+	// Selectors of the applied rules by compaction group key and source block ID.
+	Groups map[string]map[string][]string `json:"groups"`
+}
+
+// appliedSelectors returns the selectors of the rules applied to all the sources of the block.
+func (s *retentionState) appliedSelectors(meta *metadata.Meta) []string {
+	sources := s.Groups[compact.DefaultGroupKey(meta.Thanos)]
+	if len(sources) == 0 || len(meta.Compaction.Sources) == 0 {
+		return nil
+	}
+
+	applied := append([]string(nil), sources[meta.Compaction.Sources[0].String()]...)
+	for _, id := range meta.Compaction.Sources[1:] {
+		var common []string
+		for _, selector := range sources[id.String()] {
+			if util.StringsContain(applied, selector) {
+				common = append(common, selector)
+			}
+		}
+		applied = common
+	}
+
+	return applied
+}
+
+// setAppliedSelectors records the selectors of the rules applied to all the sources of the block.
+func (s *retentionState) setAppliedSelectors(meta *metadata.Meta, selectors []string) {
+	groupKey := compact.DefaultGroupKey(meta.Thanos)
+	if s.Groups[groupKey] == nil {
+		s.Groups[groupKey] = map[string][]string{}
+	}
+
+	for _, id := range meta.Compaction.Sources {
+		s.Groups[groupKey][id.String()] = selectors
+	}
+}
+
+// prune removes the state of the sources not belonging to any of the input blocks
+// and the selectors not belonging to any of the input rules.
+func (s *retentionState) prune(metas map[ulid.ULID]*metadata.Meta, rules []validation.RetentionRule) {
+	live := map[string]map[string]struct{}{}
+	for _, meta := range metas {
+		groupKey := compact.DefaultGroupKey(meta.Thanos)
+		if live[groupKey] == nil {
+			live[groupKey] = map[string]struct{}{}
+		}
+		for _, id := range meta.Compaction.Sources {
+			live[groupKey][id.String()] = struct{}{}
+		}
+	}
+
+	for groupKey, sources := range s.Groups {
+		for id, selectors := range sources {
+			if _, ok := live[groupKey][id]; !ok {
+				delete(sources, id)
+				continue
+			}
+
+			var kept []string
+			for _, selector := range selectors {
+				for _, rule := range rules {
+					if rule.Selector == selector {
+						kept = append(kept, selector)
+						break
+					}
+				}
+			}
+
+			if len(kept) == 0 {
+				delete(sources, id)
+			} else {
+				sources[id] = kept
+			}
+		}
+
+		if len(sources) == 0 {
+			delete(s.Groups, groupKey)
+		}
+	}
+}
+
+// applyRetentionRules deletes the series matching the tenant's retention rules from the blocks
+// whose whole time range is older than the rule's period. Each block containing such series is
+// rewritten without them, and the original block is marked for deletion. Until then, queriers
+// hide the expired samples at query time.
+func (c *Compactor) applyRetentionRules(ctx context.Context, userID string, userBucket objstore.Bucket, fetcher block.MetadataFetcher, logger log.Logger) (returnErr error) {
+	rules := c.cfgProvider.CompactorRetentionRules(userID)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch blocks metadata")
+	}
+
+	state, err := readRetentionState(ctx, userBucket, logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to read retention state")
+	}
+
+	// Store the state even if a failure occurred, to not rewrite the same blocks again.
+	defer func() {
+		state.prune(metas, rules)
+
+		if err := writeRetentionState(ctx, userBucket, state); err != nil && returnErr == nil {
+			returnErr = errors.Wrap(err, "failed to write retention state")
+		}
+	}()
+
+	workDir := filepath.Join(c.compactorCfg.DataDir, "retention", userID)
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			level.Warn(logger).Log("msg", "failed to remove retention rules working directory", "dir", workDir, "err", err)
+		}
+	}()
+
+	now := time.Now()
+
+	for _, meta := range metas {
+		// Only apply rules to blocks fully past the rule's period, so that each block
+		// gets rewritten at most once per rule.
+		var expired []validation.RetentionRule
+		for _, rule := range rules {
+			if meta.MaxTime <= util.TimeToMillis(now.Add(-time.Duration(rule.Period))) {
+				expired = append(expired, rule)
+			}
+		}
+
+		if len(expired) == 0 {
+			continue
+		}
+
+		applied := state.appliedSelectors(meta)
+
+		var deletions []seriesDeletion
+		for _, rule := range expired {
+			if util.StringsContain(applied, rule.Selector) {
+				continue
+			}
+
+			matchers, err := rule.Matchers()
+			if err != nil {
+				return errors.Wrapf(err, "invalid retention rule selector %s", rule.Selector)
+			}
+
+			deletions = append(deletions, seriesDeletion{minT: meta.MinTime, maxT: meta.MaxTime, matchers: [][]*labels.Matcher{matchers}})
+			applied = append(applied, rule.Selector)
+		}
+
+		if len(deletions) == 0 {
+			continue
+		}
+
+		// The rewritten block keeps the sources of the original one, so the state applies to both.
+		if _, _, err := c.rewriteBlock(ctx, userBucket, workDir, meta, deletions, "retention rules", c.blocksMarkedForRetentionRules, logger); err != nil {
+			return errors.Wrapf(err, "failed to apply retention rules to block %s", meta.ULID.String())
+		}
+
+		state.setAppliedSelectors(meta, applied)
+	}
+
+	return nil
+}
+
+func readRetentionState(ctx context.Context, userBucket objstore.Bucket, logger log.Logger) (*retentionState, error) {
+	state := &retentionState{Groups: map[string]map[string][]string{}}
+
+	r, err := userBucket.Get(ctx, RetentionStateFilename)
+	if userBucket.IsObjNotFoundErr(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithLogOnErr(logger, r, "close retention state reader")
+
+	if err := json.NewDecoder(r).Decode(state); err != nil {
+		return nil, errors.Wrap(err, "failed to decode retention state")
+	}
+
+	if state.Groups == nil {
+		state.Groups = map[string]map[string][]string{}
+	}
+
+	return state, nil
+}
+
+func writeRetentionState(ctx context.Context, userBucket objstore.Bucket, state *retentionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return userBucket.Upload(ctx, RetentionStateFilename, bytes.NewReader(data))
+}
@@ -0,0 +1,137 @@
+package compactor
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestCompactor_ApplyRetentionRules(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	now := time.Now()
+
+	// Each block contains two series: series_id="0" and series_id="1".
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+	block2 := createTSDBBlock(t, bkt, userID, 20, 30, map[string]string{"__org_id__": userID})
+	block3 := createTSDBBlock(t, bkt, userID, util.TimeToMillis(now.Add(-30*time.Minute)), util.TimeToMillis(now.Add(-20*time.Minute)), map[string]string{"__org_id__": userID})
+
+	cfg := prepareConfig()
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+
+	cfgProvider := newMockConfigProvider()
+	c.cfgProvider = cfgProvider
+
+	var err error
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, []block.MetadataFilter{
+		block.NewIgnoreDeletionMarkFilter(log.NewNopLogger(), userBucket, 0, 1),
+	}, nil)
+	require.NoError(t, err)
+
+	unknownRule := validation.RetentionRule{Selector: `{series_id="unknown"}`, Period: model.Duration(time.Hour)}
+	seriesRule := validation.RetentionRule{Selector: `{series_id="0"}`, Period: model.Duration(time.Hour)}
+
+	// A rule not matching any series shouldn't rewrite any block, but only mark expired blocks as checked.
+	cfgProvider.userRetentionRules[userID] = []validation.RetentionRule{unknownRule}
+	require.NoError(t, c.applyRetentionRules(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	assert.Equal(t, float64(0), prom_testutil.ToFloat64(c.blocksMarkedForRetentionRules))
+
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	assertRetentionState(t, userBucket, metas[block1], []string{unknownRule.Selector})
+	assertRetentionState(t, userBucket, metas[block2], []string{unknownRule.Selector})
+	assertRetentionState(t, userBucket, metas[block3], nil)
+
+	// A rule matching series should rewrite the expired blocks only.
+	cfgProvider.userRetentionRules[userID] = []validation.RetentionRule{unknownRule, seriesRule}
+	require.NoError(t, c.applyRetentionRules(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.blocksMarkedForRetentionRules))
+	for blockID, expected := range map[ulid.ULID]bool{block1: true, block2: true, block3: false} {
+		exists, err := bkt.Exists(ctx, path.Join(userID, blockID.String(), metadata.DeletionMarkFilename))
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists, blockID.String())
+	}
+
+	metas, _, err = fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	require.Len(t, metas, 3)
+
+	for id, meta := range metas {
+		if id == block3 {
+			assert.Equal(t, uint64(2), meta.Stats.NumSeries)
+			continue
+		}
+
+		assert.Equal(t, uint64(1), meta.Stats.NumSeries)
+		assertRetentionState(t, userBucket, meta, []string{unknownRule.Selector, seriesRule.Selector})
+	}
+
+	// Running again should be a no-op, because the rules have already been applied.
+	require.NoError(t, c.applyRetentionRules(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.blocksMarkedForRetentionRules))
+}
+
+func TestRetentionState_ShouldCarryTheAppliedRulesOverToCompactedBlocks(t *testing.T) {
+	var (
+		block1  = ulid.MustNew(1, nil)
+		block2  = ulid.MustNew(2, nil)
+		block3  = ulid.MustNew(3, nil)
+		shard1  = map[string]string{"__compactor_shard_id__": "1_of_2"}
+		newMeta = func(id ulid.ULID, lbls map[string]string, sources ...ulid.ULID) *metadata.Meta {
+			meta := mockSplitBlockMeta(id, lbls)
+			meta.Compaction.Sources = sources
+			return meta
+		}
+		ruleA = validation.RetentionRule{Selector: `{series_id="a"}`}
+		ruleB = validation.RetentionRule{Selector: `{series_id="b"}`}
+	)
+
+	state := &retentionState{Groups: map[string]map[string][]string{}}
+	state.setAppliedSelectors(newMeta(block1, nil, block1), []string{ruleA.Selector, ruleB.Selector})
+	state.setAppliedSelectors(newMeta(block2, nil, block2), []string{ruleA.Selector})
+
+	// A block compacted from the sources keeps the rules applied to all of them.
+	compacted := newMeta(block3, nil, block1, block2)
+	assert.Equal(t, []string{ruleA.Selector}, state.appliedSelectors(compacted))
+
+	// Blocks of another compaction group don't share the state.
+	assert.Empty(t, state.appliedSelectors(newMeta(block3, shard1, block1, block2)))
+
+	// The state of sources not belonging to any block and of removed rules is pruned.
+	state.prune(map[ulid.ULID]*metadata.Meta{block3: newMeta(block3, nil, block1)}, []validation.RetentionRule{ruleB})
+	assert.Equal(t, map[string]map[string][]string{
+		compact.DefaultGroupKey(metadata.Thanos{}): {block1.String(): {ruleB.Selector}},
+	}, state.Groups)
+}
+
+func assertRetentionState(t *testing.T, bkt objstore.Bucket, meta *metadata.Meta, expected []string) {
+	state, err := readRetentionState(context.Background(), bkt, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Equal(t, expected, state.appliedSelectors(meta), meta.ULID.String())
+}
@@ -0,0 +1,273 @@
+package compactor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+)
+
+// shuffleShardingGrouper is a compact.Grouper planning the compaction jobs of a tenant: each
+// returned group is made of the blocks to compact together within a time range. Only the groups
+// owned by this compactor are returned, so that the compactors of the tenant's shard can run
+// different compaction jobs of the same tenant concurrently.
+type shuffleShardingGrouper struct {
+	userID string
+	bkt    objstore.Bucket
+	ranges []int64
+	logger log.Logger
+	ownJob func(jobKey string) (bool, error)
+
+	compactions              *prometheus.CounterVec
+	compactionRunsStarted    *prometheus.CounterVec
+	compactionRunsCompleted  *prometheus.CounterVec
+	compactionFailures       *prometheus.CounterVec
+	verticalCompactions      *prometheus.CounterVec
+	garbageCollectedBlocks   prometheus.Counter
+	blocksMarkedForDeletion  prometheus.Counter
+	blocksMarkedForNoCompact prometheus.Counter
+}
+
+func newShuffleShardingGrouper(
+	userID string,
+	bkt objstore.Bucket,
+	ranges []int64,
+	logger log.Logger,
+	reg prometheus.Registerer,
+	blocksMarkedForDeletion prometheus.Counter,
+	garbageCollectedBlocks prometheus.Counter,
+	ownJob func(jobKey string) (bool, error),
+) *shuffleShardingGrouper {
+	return &shuffleShardingGrouper{
+		userID: userID,
+		bkt:    bkt,
+		ranges: ranges,
+		logger: logger,
+		ownJob: ownJob,
+		compactions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compactions_total",
+			Help: "Total number of group compaction attempts that resulted in a new block.",
+		}, []string{"group"}),
+		compactionRunsStarted: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compaction_runs_started_total",
+			Help: "Total number of group compaction attempts.",
+		}, []string{"group"}),
+		compactionRunsCompleted: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compaction_runs_completed_total",
+			Help: "Total number of group completed compaction runs. This also includes compactor group runs that resulted with no compaction.",
+		}, []string{"group"}),
+		compactionFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_compactions_failures_total",
+			Help: "Total number of failed group compactions.",
+		}, []string{"group"}),
+		verticalCompactions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_group_vertical_compactions_total",
+			Help: "Total number of group compaction attempts that resulted in a new block based on overlapping blocks.",
+		}, []string{"group"}),
+		garbageCollectedBlocks:   garbageCollectedBlocks,
+		blocksMarkedForDeletion:  blocksMarkedForDeletion,
+		blocksMarkedForNoCompact: prometheus.NewCounter(prometheus.CounterOpts{}),
+	}
+}
+
+// Groups implements compact.Grouper.
+func (g *shuffleShardingGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) ([]*compact.Group, error) {
+	// Blocks with different resolution or external labels are never compacted together.
+	mainGroups := map[string][]*metadata.Meta{}
+	for _, meta := range blocks {
+		key := compact.DefaultGroupKey(meta.Thanos)
+		mainGroups[key] = append(mainGroups[key], meta)
+	}
+
+	mainKeys := make([]string, 0, len(mainGroups))
+	for key := range mainGroups {
+		mainKeys = append(mainKeys, key)
+	}
+	sort.Strings(mainKeys)
+
+	var out []*compact.Group
+	for _, mainKey := range mainKeys {
+		for _, job := range groupBlocksByCompactableRanges(mainGroups[mainKey], g.ranges) {
+			groupKey := fmt.Sprintf("%s-%d-%d", mainKey, job.rangeStart, job.rangeEnd)
+
+			if owned, err := g.ownJob(compactionJobKey(g.userID, groupKey)); err != nil {
+				return nil, err
+			} else if !owned {
+				continue
+			}
+
+			first := job.blocks[0]
+			group, err := compact.NewGroup(
+				log.With(g.logger, "groupKey", groupKey),
+				g.bkt,
+				groupKey,
+				labels.FromMap(first.Thanos.Labels),
+				first.Thanos.Downsample.Resolution,
+				false, // Do not accept malformed indexes
+				true,  // Enable vertical compaction
+				g.compactions.WithLabelValues(groupKey),
+				g.compactionRunsStarted.WithLabelValues(groupKey),
+				g.compactionRunsCompleted.WithLabelValues(groupKey),
+				g.compactionFailures.WithLabelValues(groupKey),
+				g.verticalCompactions.WithLabelValues(groupKey),
+				g.garbageCollectedBlocks,
+				g.blocksMarkedForDeletion,
+				g.blocksMarkedForNoCompact,
+				metadata.NoneFunc,
+			)
+			if err != nil {
+				return nil, errors.Wrap(err, "create compaction group")
+			}
+
+			for _, meta := range job.blocks {
+				if err := group.AppendMeta(meta); err != nil {
+					return nil, errors.Wrap(err, "add block to compaction group")
+				}
+			}
+
+			out = append(out, group)
+		}
+	}
+
+	return out, nil
+}
+
+func compactionJobKey(userID, groupKey string) string {
+	return userID + "/compact/" + groupKey
+}
+
+// shuffleShardingPlanner is a compact.Planner compacting all the blocks of each group together,
+// because the compaction jobs have already been planned by the shuffleShardingGrouper.
+type shuffleShardingPlanner struct{}
+
+// Plan implements compact.Planner.
+func (p *shuffleShardingPlanner) Plan(_ context.Context, metasByMinTime []*metadata.Meta) ([]*metadata.Meta, error) {
+	return metasByMinTime, nil
+}
+
+// blocksGroup is a set of blocks to compact together, within an aligned time range.
+type blocksGroup struct {
+	rangeStart int64 // Included.
+	rangeEnd   int64 // Excluded.
+	blocks     []*metadata.Meta
+}
+
+func (g blocksGroup) minTime() int64 {
+	return g.blocks[0].MinTime
+}
+
+func (g blocksGroup) maxTime() int64 {
+	max := g.blocks[0].MaxTime
+	for _, b := range g.blocks[1:] {
+		if b.MaxTime > max {
+			max = b.MaxTime
+		}
+	}
+	return max
+}
+
+func (g blocksGroup) overlaps(other blocksGroup) bool {
+	return g.rangeStart < other.rangeEnd && other.rangeStart < g.rangeEnd
+}
+
+// groupBlocksByCompactableRanges returns the groups of blocks to compact, like the TSDB planner
+// would do one step at a time: smaller ranges are compacted first, and the most recent blocks
+// are not compacted until their range is complete.
+func groupBlocksByCompactableRanges(blocks []*metadata.Meta, ranges []int64) []blocksGroup {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].MinTime < blocks[j].MinTime
+	})
+
+	var groups []blocksGroup
+	for _, tr := range ranges {
+	nextGroup:
+		for _, group := range groupBlocksByRange(blocks, tr) {
+			// A single block doesn't need to be compacted.
+			if len(group.blocks) < 2 {
+				continue
+			}
+
+			// Smaller ranges must be compacted first.
+			for _, other := range groups {
+				if group.overlaps(other) {
+					continue nextGroup
+				}
+			}
+
+			groups = append(groups, group)
+		}
+	}
+
+	// Do not compact the most recent blocks prematurely: a group is valid only if its range
+	// ends before the most recent block starts, or if its blocks fully cover the range.
+	highestMinTime := blocks[len(blocks)-1].MinTime
+	for i := 0; i < len(groups); {
+		group := groups[i]
+		if group.rangeEnd <= highestMinTime || group.maxTime()-group.minTime() == group.rangeEnd-group.rangeStart {
+			i++
+			continue
+		}
+
+		groups = append(groups[:i], groups[i+1:]...)
+	}
+
+	return groups
+}
+
+// groupBlocksByRange groups the blocks, sorted by min time, by the aligned time range of size tr
+// they fall into. Blocks spanning multiple ranges are skipped.
+func groupBlocksByRange(blocks []*metadata.Meta, tr int64) []blocksGroup {
+	var groups []blocksGroup
+
+	for i := 0; i < len(blocks); {
+		group := blocksGroup{rangeStart: rangeStart(blocks[i].MinTime, tr)}
+		group.rangeEnd = group.rangeStart + tr
+
+		// Skip the block if it doesn't fit the range.
+		if blocks[i].MaxTime > group.rangeEnd {
+			i++
+			continue
+		}
+
+		for ; i < len(blocks); i++ {
+			// The block belongs to the next range.
+			if blocks[i].MinTime >= group.rangeEnd {
+				break
+			}
+
+			// The block starts within this range but spans multiple ranges.
+			if blocks[i].MaxTime > group.rangeEnd {
+				continue
+			}
+
+			group.blocks = append(group.blocks, blocks[i])
+		}
+
+		if len(group.blocks) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// rangeStart returns the start of the aligned time range of size tr containing t.
+func rangeStart(t, tr int64) int64 {
+	if t >= 0 {
+		return tr * (t / tr)
+	}
+	return tr * ((t - tr + 1) / tr)
+}
@@ -0,0 +1,172 @@
+package compactor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+)
+
+func TestShuffleShardingGrouper_Groups(t *testing.T) {
+	const userID = "user-1"
+
+	var (
+		block1 = ulid.MustNew(1, nil)
+		block2 = ulid.MustNew(2, nil)
+		block3 = ulid.MustNew(3, nil)
+		block4 = ulid.MustNew(4, nil)
+	)
+
+	blocks := map[ulid.ULID]*metadata.Meta{
+		block1: mockShuffleShardingBlockMeta(block1, 0, 10),
+		block2: mockShuffleShardingBlockMeta(block2, 10, 20),
+		block3: mockShuffleShardingBlockMeta(block3, 20, 30),
+		block4: mockShuffleShardingBlockMeta(block4, 30, 40),
+	}
+
+	tests := map[string]struct {
+		ownJob         func(jobKey string) (bool, error)
+		expectedBlocks [][]ulid.ULID
+	}{
+		"should return all groups if all jobs are owned": {
+			ownJob:         func(string) (bool, error) { return true, nil },
+			expectedBlocks: [][]ulid.ULID{{block1, block2}, {block3, block4}},
+		},
+		"should only return the owned groups": {
+			ownJob: func(jobKey string) (bool, error) {
+				return jobKey == compactionJobKey(userID, fmt.Sprintf("%s-20-40", compact.DefaultGroupKey(blocks[block3].Thanos))), nil
+			},
+			expectedBlocks: [][]ulid.ULID{{block3, block4}},
+		},
+		"should return no groups if no job is owned": {
+			ownJob:         func(string) (bool, error) { return false, nil },
+			expectedBlocks: [][]ulid.ULID{},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			grouper := newShuffleShardingGrouper(userID, objstore.NewInMemBucket(), []int64{20, 40}, log.NewNopLogger(), prometheus.NewPedanticRegistry(),
+				prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}), testData.ownJob)
+
+			groups, err := grouper.Groups(blocks)
+			require.NoError(t, err)
+
+			actual := make([][]ulid.ULID, 0, len(groups))
+			for _, group := range groups {
+				actual = append(actual, group.IDs())
+			}
+
+			assert.ElementsMatch(t, testData.expectedBlocks, actual)
+		})
+	}
+}
+
+func TestGroupBlocksByCompactableRanges(t *testing.T) {
+	tests := map[string]struct {
+		blocks   []*metadata.Meta
+		ranges   []int64
+		expected []blocksGroup
+	}{
+		"no input blocks": {
+			blocks:   nil,
+			ranges:   []int64{20},
+			expected: nil,
+		},
+		"only 1 block in input": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 20}},
+			},
+			ranges:   []int64{20},
+			expected: nil,
+		},
+		"only 1 block per range": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 15}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 40, MaxTime: 60}},
+			},
+			ranges:   []int64{20},
+			expected: nil,
+		},
+		"input blocks fully covering a range": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 10}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 20}},
+			},
+			ranges: []int64{20, 40},
+			expected: []blocksGroup{
+				{rangeStart: 0, rangeEnd: 20, blocks: []*metadata.Meta{
+					{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 10}},
+					{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 20}},
+				}},
+			},
+		},
+		"the most recent blocks are not compacted until their range is complete": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 10}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 15}},
+			},
+			ranges:   []int64{20},
+			expected: nil,
+		},
+		"smaller ranges are compacted first": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 10}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 20, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 40, MaxTime: 50}},
+			},
+			ranges: []int64{20, 40},
+			expected: []blocksGroup{
+				{rangeStart: 0, rangeEnd: 20, blocks: []*metadata.Meta{
+					{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 10}},
+					{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 20}},
+				}},
+			},
+		},
+		"blocks already compacted by the smaller range are compacted by the larger range": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 20}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 20, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 40, MaxTime: 60}},
+			},
+			ranges: []int64{20, 40},
+			expected: []blocksGroup{
+				{rangeStart: 0, rangeEnd: 40, blocks: []*metadata.Meta{
+					{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 20}},
+					{BlockMeta: tsdb.BlockMeta{MinTime: 20, MaxTime: 40}},
+				}},
+			},
+		},
+		"blocks spanning multiple ranges are skipped": {
+			blocks: []*metadata.Meta{
+				{BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: 10}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 10, MaxTime: 30}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 30, MaxTime: 40}},
+				{BlockMeta: tsdb.BlockMeta{MinTime: 40, MaxTime: 50}},
+			},
+			ranges:   []int64{20},
+			expected: nil,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.ElementsMatch(t, testData.expected, groupBlocksByCompactableRanges(testData.blocks, testData.ranges))
+		})
+	}
+}
+
+func mockShuffleShardingBlockMeta(id ulid.ULID, minTime, maxTime int64) *metadata.Meta {
+	return &metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: minTime, MaxTime: maxTime, Version: metadata.TSDBVersion1},
+	}
+}
@@ -0,0 +1,327 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+const (
+	// CompactionStrategyDefault is the Thanos compaction strategy: all the blocks of a
+	// tenant within a time range are compacted together by a single compactor.
+	CompactionStrategyDefault = "default"
+
+	// CompactionStrategySplitAndMerge splits each block into shards by series hash, and then
+	// compacts the blocks of each shard independently. Compaction jobs can be run by different
+	// compactors, even for the same tenant.
+	CompactionStrategySplitAndMerge = "split-and-merge"
+)
+
+var supportedCompactionStrategies = []string{CompactionStrategyDefault, CompactionStrategySplitAndMerge}
+
+// splitBlocks splits each block of the tenant not split yet into the given number of shards.
+// The shard ID is stored in the external labels of the resulting blocks, so that blocks of
+// different shards are compacted independently. Excluded blocks are not split.
+func (c *Compactor) splitBlocks(ctx context.Context, userID string, userBucket objstore.Bucket, fetcher block.MetadataFetcher, shards int, exclude func(*metadata.Meta) bool, logger log.Logger) error {
+	if shards <= 0 {
+		return nil
+	}
+
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch blocks metadata")
+	}
+
+	workDir := filepath.Join(c.compactorCfg.DataDir, "split", userID)
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			level.Warn(logger).Log("msg", "failed to remove split working directory", "dir", workDir, "err", err)
+		}
+	}()
+
+	for _, meta := range metas {
+		// Blocks split with a different number of shards are split again, otherwise
+		// they would never be compacted with the blocks of the current shards.
+		if isSplitWithShards(meta, shards) || exclude(meta) {
+			continue
+		}
+
+		if owned, err := c.ownJob(userID, splitJobKey(userID, meta.ULID)); err != nil {
+			return err
+		} else if !owned {
+			continue
+		}
+
+		if err := c.splitBlock(ctx, userBucket, workDir, meta, shards, logger); err != nil {
+			return errors.Wrapf(err, "failed to split block %s", meta.ULID.String())
+		}
+	}
+
+	return nil
+}
+
+func (c *Compactor) splitBlock(ctx context.Context, userBucket objstore.Bucket, workDir string, meta *metadata.Meta, shards int, logger log.Logger) error {
+	blockDir := filepath.Join(workDir, meta.ULID.String())
+	if err := os.RemoveAll(blockDir); err != nil {
+		return errors.Wrap(err, "failed to clean block directory")
+	}
+
+	if err := block.Download(ctx, logger, userBucket, meta.ULID, blockDir); err != nil {
+		return errors.Wrap(err, "failed to download block")
+	}
+
+	b, err := tsdb.OpenBlock(logger, blockDir, downsample.NewPool())
+	if err != nil {
+		return errors.Wrap(err, "failed to open block")
+	}
+	defer func() {
+		if err := b.Close(); err != nil {
+			level.Warn(logger).Log("msg", "failed to close block", "block", meta.ULID.String(), "err", err)
+		}
+	}()
+
+	for i := 0; i < shards; i++ {
+		shard := astmapper.ShardAnnotation{Shard: i, Of: shards}
+
+		// The shard block ID is deterministic, so that shards already uploaded by a
+		// previous attempt to split the same block are not uploaded again.
+		shardID := splitBlockID(meta.ULID, shard)
+		if exists, err := userBucket.Exists(ctx, path.Join(shardID.String(), metadata.MetaFilename)); err != nil {
+			return errors.Wrapf(err, "failed to check if shard %s exists", shard.String())
+		} else if exists {
+			level.Info(logger).Log("msg", "split block already uploaded", "block", meta.ULID.String(), "shard", shard.String(), "result_block", shardID.String())
+			continue
+		}
+
+		writtenID, err := c.blocksCompactor.Write(workDir, &shardedBlockReader{BlockReader: b, shard: shard}, meta.MinTime, meta.MaxTime, &meta.BlockMeta)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write shard %s", shard.String())
+		}
+
+		// An empty ULID is returned when no series belong to the shard.
+		if writtenID == (ulid.ULID{}) {
+			continue
+		}
+
+		shardDir := filepath.Join(workDir, shardID.String())
+		if err := os.Rename(filepath.Join(workDir, writtenID.String()), shardDir); err != nil {
+			return errors.Wrapf(err, "failed to rename shard %s", shard.String())
+		}
+
+		shardMeta, err := metadata.ReadFromDir(shardDir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read metadata of shard %s", shard.String())
+		}
+
+		lbls := make(map[string]string, len(meta.Thanos.Labels)+1)
+		for name, value := range meta.Thanos.Labels {
+			lbls[name] = value
+		}
+		lbls[cortex_tsdb.CompactorShardIDExternalLabel] = shard.String()
+
+		// Keep the compaction level of the original block, so that the split blocks are compacted
+		// exactly like the original one would have been. The sources are replaced with IDs unique to
+		// the shard: blocks of different shards contain different series, so they must never have
+		// sources in common, otherwise they would be considered duplicates of each other.
+		shardMeta.ULID = shardID
+		shardMeta.Compaction.Level = meta.Compaction.Level
+		shardMeta.Compaction.Sources = splitSourceIDs(meta.Compaction.Sources, shard)
+		shardMeta.Thanos = metadata.Thanos{
+			Labels:       lbls,
+			Downsample:   meta.Thanos.Downsample,
+			Source:       metadata.CompactorSource,
+			SegmentFiles: block.GetSegmentFiles(shardDir),
+		}
+
+		if err := shardMeta.WriteToDir(logger, shardDir); err != nil {
+			return errors.Wrapf(err, "failed to write metadata of shard %s", shard.String())
+		}
+
+		if err := block.Upload(ctx, logger, userBucket, shardDir, metadata.NoneFunc); err != nil {
+			return errors.Wrapf(err, "failed to upload shard %s", shard.String())
+		}
+
+		level.Info(logger).Log("msg", "uploaded split block", "block", meta.ULID.String(), "shard", shard.String(), "result_block", shardID.String())
+	}
+
+	if err := block.MarkForDeletion(ctx, logger, userBucket, meta.ULID, "source of split blocks", c.blocksMarkedForDeletion); err != nil {
+		return errors.Wrap(err, "failed to mark the split block for deletion")
+	}
+
+	c.blocksSplit.Inc()
+	return nil
+}
+
+// splitAndMergeGrouper wraps a grouper to only return the compaction groups owned by the compactor.
+// When splitting is enabled, blocks not split yet are excluded, so that they're not compacted
+// together with the split ones.
+type splitAndMergeGrouper struct {
+	userID  string
+	shards  int
+	grouper compact.Grouper
+	ownJob  func(jobKey string) (bool, error)
+}
+
+func newSplitAndMergeGrouper(userID string, shards int, grouper compact.Grouper, ownJob func(jobKey string) (bool, error)) *splitAndMergeGrouper {
+	return &splitAndMergeGrouper{
+		userID:  userID,
+		shards:  shards,
+		grouper: grouper,
+		ownJob:  ownJob,
+	}
+}
+
+func (g *splitAndMergeGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) ([]*compact.Group, error) {
+	if g.shards > 0 {
+		split := make(map[ulid.ULID]*metadata.Meta, len(blocks))
+		for id, meta := range blocks {
+			if isSplitWithShards(meta, g.shards) {
+				split[id] = meta
+			}
+		}
+		blocks = split
+	}
+
+	groups, err := g.grouper.Groups(blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make([]*compact.Group, 0, len(groups))
+	for _, group := range groups {
+		ok, err := g.ownJob(mergeJobKey(g.userID, group.Key()))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			owned = append(owned, group)
+		}
+	}
+
+	return owned, nil
+}
+
+// isSplitWithShards returns whether the block has been split into the given number of shards.
+func isSplitWithShards(meta *metadata.Meta, shards int) bool {
+	value, ok := meta.Thanos.Labels[cortex_tsdb.CompactorShardIDExternalLabel]
+	if !ok {
+		return false
+	}
+
+	shard, err := astmapper.ParseShard(value)
+	return err == nil && shard.Of == shards
+}
+
+// splitBlockID returns the ID of the block containing the given shard of the input block.
+// The ID keeps the timestamp of the input block, while the entropy is derived from the
+// input block ID and the shard.
+func splitBlockID(blockID ulid.ULID, shard astmapper.ShardAnnotation) ulid.ULID {
+	entropy := sha256.Sum256([]byte(blockID.String() + "/" + shard.String()))
+
+	id, err := ulid.New(blockID.Time(), bytes.NewReader(entropy[:]))
+	if err != nil {
+		// Can't happen, because the timestamp is taken from a valid ULID and the entropy is enough.
+		panic(err)
+	}
+
+	return id
+}
+
+// splitSourceIDs returns the sources of the block containing the given shard of a block with the
+// input sources. Each source ID keeps the timestamp of the input source, while the entropy is
+// derived from the input source and the shard, so that the sources of different shards differ.
+func splitSourceIDs(sources []ulid.ULID, shard astmapper.ShardAnnotation) []ulid.ULID {
+	ids := make([]ulid.ULID, 0, len(sources))
+	for _, source := range sources {
+		entropy := sha256.Sum256([]byte("source/" + source.String() + "/" + shard.String()))
+
+		id, err := ulid.New(source.Time(), bytes.NewReader(entropy[:]))
+		if err != nil {
+			// Can't happen, because the timestamp is taken from a valid ULID and the entropy is enough.
+			panic(err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func splitJobKey(userID string, blockID ulid.ULID) string {
+	return userID + "/split/" + blockID.String()
+}
+
+func mergeJobKey(userID, groupKey string) string {
+	return userID + "/merge/" + groupKey
+}
+
+// shardedBlockReader is a tsdb.BlockReader only exposing the series belonging to a shard.
+type shardedBlockReader struct {
+	tsdb.BlockReader
+	shard astmapper.ShardAnnotation
+}
+
+func (r *shardedBlockReader) Index() (tsdb.IndexReader, error) {
+	indexr, err := r.BlockReader.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	return &shardedIndexReader{IndexReader: indexr, shard: r.shard}, nil
+}
+
+// shardedIndexReader is a tsdb.IndexReader whose postings only include the series belonging to
+// a shard. Symbols are not filtered, so the written index may contain a few unused symbols.
+type shardedIndexReader struct {
+	tsdb.IndexReader
+	shard astmapper.ShardAnnotation
+}
+
+func (r *shardedIndexReader) Postings(name string, values ...string) (index.Postings, error) {
+	p, err := r.IndexReader.Postings(name, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		refs []uint64
+		lbls labels.Labels
+		chks []chunks.Meta
+	)
+
+	for p.Next() {
+		if err := r.IndexReader.Series(p.At(), &lbls, &chks); err != nil {
+			return nil, err
+		}
+
+		if sharding.IsSeriesInShard(r.shard, lbls) {
+			refs = append(refs, p.At())
+		}
+	}
+
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	return index.NewListPostings(refs), nil
+}
@@ -0,0 +1,297 @@
+package compactor
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/extprom"
+
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+func TestCompactor_SplitBlocks(t *testing.T) {
+	const (
+		userID = "user-1"
+		shards = 2
+	)
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	// Each block contains two series: series_id="0" and series_id="1".
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+	block2 := createTSDBBlock(t, bkt, userID, 20, 30, map[string]string{"__org_id__": userID, cortex_tsdb.CompactorShardIDExternalLabel: "0_of_2"})
+	block3 := createTSDBBlock(t, bkt, userID, 30, 40, map[string]string{"__org_id__": userID, cortex_tsdb.CompactorShardIDExternalLabel: "1_of_4"})
+
+	cfg := prepareConfig()
+	cfg.CompactionStrategy = CompactionStrategySplitAndMerge
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+
+	var err error
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+
+	originalMetas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, c.splitBlocks(ctx, userID, userBucket, fetcher, shards, func(*metadata.Meta) bool { return false }, log.NewNopLogger()))
+
+	// The block not split yet and the block split with a different number of shards
+	// should have been marked for deletion.
+	for blockID, expected := range map[ulid.ULID]bool{block1: true, block2: false, block3: true} {
+		exists, err := bkt.Exists(ctx, path.Join(userID, blockID.String(), metadata.DeletionMarkFilename))
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists, blockID.String())
+	}
+
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	// Each series should belong to exactly one split block, matching its shard.
+	numSeries := map[ulid.ULID]uint64{}
+	for id, meta := range metas {
+		if id == block1 || id == block2 || id == block3 {
+			continue
+		}
+
+		shard, err := astmapper.ParseShard(meta.Thanos.Labels[cortex_tsdb.CompactorShardIDExternalLabel])
+		require.NoError(t, err)
+		assert.Equal(t, shards, shard.Of)
+
+		var source *metadata.Meta
+		for _, sourceID := range []ulid.ULID{block1, block3} {
+			if id == splitBlockID(sourceID, shard) {
+				source = originalMetas[sourceID]
+			}
+		}
+		require.NotNil(t, source, "unexpected split block %s", id.String())
+
+		assert.Equal(t, source.MinTime, meta.MinTime)
+		assert.Equal(t, source.MaxTime, meta.MaxTime)
+		assert.Equal(t, userID, meta.Thanos.Labels["__org_id__"])
+		assert.Equal(t, source.Compaction.Level, meta.Compaction.Level)
+		assert.Equal(t, splitSourceIDs(source.Compaction.Sources, shard), meta.Compaction.Sources)
+
+		for _, lbls := range readBlockSeries(ctx, t, userBucket, id) {
+			assert.True(t, sharding.IsSeriesInShard(shard, lbls), lbls.String())
+		}
+
+		numSeries[source.ULID] += meta.Stats.NumSeries
+	}
+
+	assert.Equal(t, map[ulid.ULID]uint64{block1: 2, block3: 2}, numSeries)
+
+	// The split blocks should have no sources in common, neither with each other nor with the original blocks.
+	sources := map[ulid.ULID]ulid.ULID{}
+	for id, meta := range metas {
+		for _, source := range meta.Compaction.Sources {
+			other, ok := sources[source]
+			assert.False(t, ok, "source %s of block %s is also a source of block %s", source.String(), id.String(), other.String())
+			sources[source] = id
+		}
+	}
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.blocksSplit))
+}
+
+func TestCompactor_SplitBlocks_ShouldNotUploadAgainTheShardsAlreadyUploaded(t *testing.T) {
+	const (
+		userID = "user-1"
+		shards = 2
+	)
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+
+	cfg := prepareConfig()
+	cfg.CompactionStrategy = CompactionStrategySplitAndMerge
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+
+	var err error
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, c.splitBlocks(ctx, userID, userBucket, fetcher, shards, func(*metadata.Meta) bool { return false }, log.NewNopLogger()))
+
+	metasBefore, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+
+	uploaded := map[string][]byte{}
+	for name, content := range bkt.Objects() {
+		uploaded[name] = content
+	}
+
+	// Split the same block again, like after a failure occurred before marking it for deletion.
+	require.NoError(t, c.splitBlocks(ctx, userID, userBucket, fetcher, shards, func(*metadata.Meta) bool { return false }, log.NewNopLogger()))
+
+	// The same shards should have been kept, without uploading new blocks.
+	for _, shard := range []astmapper.ShardAnnotation{{Shard: 0, Of: shards}, {Shard: 1, Of: shards}} {
+		metaPath := path.Join(userID, splitBlockID(block1, shard).String(), metadata.MetaFilename)
+		assert.Equal(t, uploaded[metaPath], bkt.Objects()[metaPath])
+	}
+
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(metasBefore), len(metas))
+
+	exists, err := bkt.Exists(ctx, path.Join(userID, block1.String(), metadata.DeletionMarkFilename))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestSplitSourceIDs_ShouldNotMakeTheSplitBlocksDuplicates(t *testing.T) {
+	var (
+		source = ulid.MustNew(1, nil)
+		shard0 = ulid.MustNew(2, nil)
+		shard1 = ulid.MustNew(3, nil)
+		dup    = ulid.MustNew(4, nil)
+	)
+
+	withSources := func(meta *metadata.Meta, sources ...ulid.ULID) *metadata.Meta {
+		meta.Compaction.Sources = sources
+		return meta
+	}
+
+	shard0Sources := splitSourceIDs([]ulid.ULID{source}, astmapper.ShardAnnotation{Shard: 0, Of: 2})
+	shard1Sources := splitSourceIDs([]ulid.ULID{source}, astmapper.ShardAnnotation{Shard: 1, Of: 2})
+
+	metas := map[ulid.ULID]*metadata.Meta{
+		shard0: withSources(mockSplitBlockMeta(shard0, map[string]string{cortex_tsdb.CompactorShardIDExternalLabel: "0_of_2"}), shard0Sources...),
+		shard1: withSources(mockSplitBlockMeta(shard1, map[string]string{cortex_tsdb.CompactorShardIDExternalLabel: "1_of_2"}), shard1Sources...),
+		dup:    withSources(mockSplitBlockMeta(dup, map[string]string{cortex_tsdb.CompactorShardIDExternalLabel: "1_of_2"}), shard1Sources...),
+	}
+
+	synced := extprom.NewTxGaugeVec(nil, prometheus.GaugeOpts{Name: "synced"}, []string{"state"}, []string{"duplicate"})
+
+	filter := block.NewDeduplicateFilter()
+	require.NoError(t, filter.Filter(context.Background(), metas, synced))
+
+	// Blocks of different shards split from the same block are not duplicates of each other,
+	// while blocks of the same shard with the same sources are.
+	assert.Len(t, metas, 2)
+	assert.Contains(t, metas, shard0)
+	assert.Len(t, filter.DuplicateIDs(), 1)
+}
+
+func TestSplitAndMergeGrouper_Groups(t *testing.T) {
+	const userID = "user-1"
+
+	var (
+		block1 = ulid.MustNew(1, nil)
+		block2 = ulid.MustNew(2, nil)
+		block3 = ulid.MustNew(3, nil)
+		block4 = ulid.MustNew(4, nil)
+	)
+
+	blocks := map[ulid.ULID]*metadata.Meta{
+		block1: mockSplitBlockMeta(block1, nil),
+		block2: mockSplitBlockMeta(block2, map[string]string{cortex_tsdb.CompactorShardIDExternalLabel: "0_of_2"}),
+		block3: mockSplitBlockMeta(block3, map[string]string{cortex_tsdb.CompactorShardIDExternalLabel: "1_of_2"}),
+		block4: mockSplitBlockMeta(block4, map[string]string{cortex_tsdb.CompactorShardIDExternalLabel: "1_of_2"}),
+	}
+
+	tests := map[string]struct {
+		shards         int
+		ownJob         func(jobKey string) (bool, error)
+		expectedBlocks [][]ulid.ULID
+	}{
+		"should group all blocks if splitting is disabled": {
+			shards:         0,
+			ownJob:         func(string) (bool, error) { return true, nil },
+			expectedBlocks: [][]ulid.ULID{{block1}, {block2}, {block3, block4}},
+		},
+		"should exclude blocks not split yet if splitting is enabled": {
+			shards:         2,
+			ownJob:         func(string) (bool, error) { return true, nil },
+			expectedBlocks: [][]ulid.ULID{{block2}, {block3, block4}},
+		},
+		"should only return the owned groups": {
+			shards: 2,
+			ownJob: func(jobKey string) (bool, error) {
+				return jobKey == mergeJobKey(userID, compact.DefaultGroupKey(blocks[block3].Thanos)), nil
+			},
+			expectedBlocks: [][]ulid.ULID{{block3, block4}},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			defaultGrouper := DefaultBlocksGrouperFactory(context.Background(), Config{}, objstore.NewInMemBucket(), log.NewNopLogger(), nil, prometheus.NewCounter(prometheus.CounterOpts{}), prometheus.NewCounter(prometheus.CounterOpts{}))
+			grouper := newSplitAndMergeGrouper(userID, testData.shards, defaultGrouper, testData.ownJob)
+
+			groups, err := grouper.Groups(blocks)
+			require.NoError(t, err)
+
+			actual := make([][]ulid.ULID, 0, len(groups))
+			for _, group := range groups {
+				actual = append(actual, group.IDs())
+			}
+
+			assert.ElementsMatch(t, testData.expectedBlocks, actual)
+		})
+	}
+}
+
+func mockSplitBlockMeta(id ulid.ULID, lbls map[string]string) *metadata.Meta {
+	return &metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: 10, MaxTime: 20, Version: metadata.TSDBVersion1},
+		Thanos:    metadata.Thanos{Labels: lbls},
+	}
+}
+
+func readBlockSeries(ctx context.Context, t *testing.T, bkt objstore.Bucket, blockID ulid.ULID) []labels.Labels {
+	dir := t.TempDir()
+	require.NoError(t, block.Download(ctx, log.NewNopLogger(), bkt, blockID, path.Join(dir, blockID.String())))
+
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), path.Join(dir, blockID.String()), nil)
+	require.NoError(t, err)
+	defer b.Close() //nolint:errcheck
+
+	indexr, err := b.Index()
+	require.NoError(t, err)
+	defer indexr.Close() //nolint:errcheck
+
+	name, value := index.AllPostingsKey()
+	postings, err := indexr.Postings(name, value)
+	require.NoError(t, err)
+
+	var res []labels.Labels
+	for postings.Next() {
+		var lbls labels.Labels
+		var chks []chunks.Meta
+		require.NoError(t, indexr.Series(postings.At(), &lbls, &chks))
+		res = append(res, lbls)
+	}
+	require.NoError(t, postings.Err())
+
+	return res
+}
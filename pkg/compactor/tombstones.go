@@ -0,0 +1,217 @@
+package compactor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// applyTombstones physically deletes the series matching the tenant's pending delete requests
+// which are past the cancellation period. Each block containing deleted series is rewritten
+// without them, and the original block is marked for deletion. Delete requests are marked as
+// processed once all the blocks have been loaded and rewritten.
+func (c *Compactor) applyTombstones(ctx context.Context, userID string, userBucket objstore.Bucket, fetcher block.MetadataFetcher, logger log.Logger) error {
+	if !c.compactorCfg.SeriesDeletionEnabled {
+		return nil
+	}
+
+	tombstones, err := cortex_tsdb.ListTombstones(ctx, c.bucketClient, userID, c.cfgProvider)
+	if err != nil {
+		return errors.Wrap(err, "failed to list tombstones")
+	}
+
+	// Only apply delete requests which can't be cancelled anymore and whose time range
+	// is old enough to not be in the ingesters anymore, otherwise the deleted series
+	// could be shipped again once the tombstone has been deleted.
+	ready := make([]*cortex_tsdb.Tombstone, 0, len(tombstones))
+	for _, t := range tombstones {
+		if t.State == cortex_tsdb.TombstoneProcessed {
+			c.deleteProcessedTombstone(ctx, userID, t, logger)
+			continue
+		}
+		if t.State != cortex_tsdb.TombstonePending {
+			continue
+		}
+		if time.Since(util.TimeFromMillis(t.RequestCreatedAt)) < c.compactorCfg.DeleteRequestCancelPeriod {
+			continue
+		}
+		if time.Since(util.TimeFromMillis(t.EndTime)) < c.compactorCfg.IngestersRetentionPeriod {
+			continue
+		}
+
+		ready = append(ready, t)
+	}
+
+	if len(ready) == 0 {
+		return nil
+	}
+
+	metas, partial, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch blocks metadata")
+	}
+
+	workDir := filepath.Join(c.compactorCfg.DataDir, "tombstones", userID)
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			level.Warn(logger).Log("msg", "failed to remove series deletion working directory", "dir", workDir, "err", err)
+		}
+	}()
+
+	for _, meta := range metas {
+		var overlapping []*cortex_tsdb.Tombstone
+		for _, t := range ready {
+			// Block max time is exclusive.
+			if t.Overlaps(meta.MinTime, meta.MaxTime-1) {
+				overlapping = append(overlapping, t)
+			}
+		}
+
+		if len(overlapping) == 0 {
+			continue
+		}
+
+		deletions := make([]seriesDeletion, 0, len(overlapping))
+		for _, t := range overlapping {
+			deletions = append(deletions, seriesDeletion{minT: t.StartTime, maxT: t.EndTime, matchers: t.Matchers})
+		}
+
+		if _, _, err := c.rewriteBlock(ctx, userBucket, workDir, meta, deletions, "series deletion", c.blocksMarkedForSeriesDeletion, logger); err != nil {
+			return errors.Wrapf(err, "failed to apply tombstones to block %s", meta.ULID.String())
+		}
+	}
+
+	// The blocks which failed to load may contain deleted series too. Once processed, the tombstones
+	// get deleted and the store-gateways stop filtering out the deleted series, so the delete requests
+	// are kept pending until all the blocks have been loaded.
+	if len(partial) > 0 {
+		level.Warn(logger).Log("msg", "some blocks failed to load, delete requests will be processed in the next cycle", "partial_blocks", len(partial))
+		return nil
+	}
+
+	now := util.TimeToMillis(time.Now())
+	for _, t := range ready {
+		t.State = cortex_tsdb.TombstoneProcessed
+		t.StateCreatedAt = now
+
+		if err := cortex_tsdb.WriteTombstone(ctx, c.bucketClient, userID, c.cfgProvider, t); err != nil {
+			return errors.Wrapf(err, "failed to mark delete request %s as processed", t.RequestID)
+		}
+
+		c.deleteRequestsProcessed.Inc()
+		level.Info(logger).Log("msg", "processed delete request", "request_id", t.RequestID)
+	}
+
+	return nil
+}
+
+// deleteProcessedTombstone deletes a processed tombstone once the blocks it has been applied
+// to are deleted from the bucket. Until then, the store-gateways keep filtering out the
+// deleted series from the original blocks.
+func (c *Compactor) deleteProcessedTombstone(ctx context.Context, userID string, t *cortex_tsdb.Tombstone, logger log.Logger) {
+	if time.Since(util.TimeFromMillis(t.StateCreatedAt)) < c.compactorCfg.DeletionDelay {
+		return
+	}
+
+	if err := cortex_tsdb.DeleteTombstone(ctx, c.bucketClient, userID, c.cfgProvider, t.RequestID); err != nil {
+		level.Warn(logger).Log("msg", "failed to delete processed tombstone", "request_id", t.RequestID, "err", err)
+		return
+	}
+
+	level.Info(logger).Log("msg", "deleted processed tombstone", "request_id", t.RequestID)
+}
+
+// seriesDeletion deletes the samples within the time range [minT, maxT] of the series
+// matching any of the matchers sets.
+type seriesDeletion struct {
+	minT, maxT int64
+	matchers   [][]*labels.Matcher
+}
+
+// rewriteBlock rewrites the input block without the samples matching the input deletions,
+// and marks the original block for deletion. The block is left untouched if it doesn't
+// contain any deleted sample. Returns the ID of the new block (empty if all the series have
+// been deleted) and whether the block has been rewritten.
+func (c *Compactor) rewriteBlock(ctx context.Context, userBucket objstore.Bucket, workDir string, meta *metadata.Meta, deletions []seriesDeletion, reason string, blocksMarkedForDeletion prometheus.Counter, logger log.Logger) (ulid.ULID, bool, error) {
+	blockDir := filepath.Join(workDir, meta.ULID.String())
+	if err := os.RemoveAll(blockDir); err != nil {
+		return ulid.ULID{}, false, errors.Wrap(err, "failed to clean block directory")
+	}
+
+	if err := block.Download(ctx, logger, userBucket, meta.ULID, blockDir); err != nil {
+		return ulid.ULID{}, false, errors.Wrap(err, "failed to download block")
+	}
+
+	b, err := tsdb.OpenBlock(logger, blockDir, downsample.NewPool())
+	if err != nil {
+		return ulid.ULID{}, false, errors.Wrap(err, "failed to open block")
+	}
+
+	for _, d := range deletions {
+		for _, matchers := range d.matchers {
+			if err := b.Delete(d.minT, d.maxT, matchers...); err != nil {
+				_ = b.Close()
+				return ulid.ULID{}, false, errors.Wrap(err, "failed to delete series from block")
+			}
+		}
+	}
+
+	numTombstones := b.Meta().Stats.NumTombstones
+	if err := b.Close(); err != nil {
+		return ulid.ULID{}, false, errors.Wrap(err, "failed to close block")
+	}
+
+	if numTombstones == 0 {
+		level.Debug(logger).Log("msg", "block contains no deleted series", "block", meta.ULID.String(), "reason", reason)
+		return ulid.ULID{}, false, nil
+	}
+
+	// Compacting a single block with tombstones writes a new block without the deleted series.
+	newID, err := c.blocksCompactor.Compact(workDir, []string{blockDir}, nil)
+	if err != nil {
+		return ulid.ULID{}, false, errors.Wrap(err, "failed to rewrite block")
+	}
+
+	// An empty ULID is returned when all the series have been deleted.
+	if newID != (ulid.ULID{}) {
+		newDir := filepath.Join(workDir, newID.String())
+
+		thanosMeta := meta.Thanos
+		thanosMeta.Source = metadata.CompactorSource
+		thanosMeta.Files = nil
+
+		// Keep the original compaction details, so that the rewritten block is
+		// compacted exactly like the original one would have been.
+		if _, err := metadata.InjectThanos(logger, newDir, thanosMeta, &meta.BlockMeta); err != nil {
+			return ulid.ULID{}, false, errors.Wrap(err, "failed to inject Thanos metadata to the rewritten block")
+		}
+
+		if err := block.Upload(ctx, logger, userBucket, newDir, metadata.NoneFunc); err != nil {
+			return ulid.ULID{}, false, errors.Wrap(err, "failed to upload the rewritten block")
+		}
+	}
+
+	if err := block.MarkForDeletion(ctx, logger, userBucket, meta.ULID, fmt.Sprintf("%s: replaced by %s", reason, newID.String()), blocksMarkedForDeletion); err != nil {
+		return ulid.ULID{}, false, errors.Wrap(err, "failed to mark the original block for deletion")
+	}
+
+	level.Info(logger).Log("msg", "rewritten block without deleted series", "block", meta.ULID.String(), "new_block", newID.String(), "reason", reason)
+	return newID, true, nil
+}
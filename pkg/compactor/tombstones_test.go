@@ -0,0 +1,238 @@
+package compactor
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+func TestCompactor_ApplyTombstones(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	// Each block contains two series: series_id="0" and series_id="1".
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+	block2 := createTSDBBlock(t, bkt, userID, 20, 30, map[string]string{"__org_id__": userID})
+	block3 := createTSDBBlock(t, bkt, userID, 30, 40, map[string]string{"__org_id__": userID})
+
+	cfg := prepareConfig()
+	cfg.SeriesDeletionEnabled = true
+	cfg.DeleteRequestCancelPeriod = time.Hour
+	cfg.IngestersRetentionPeriod = time.Hour
+
+	requestCreatedAt := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+
+	// Deletes a series from block1 and all series from block2. Overlaps block3 without matching any sample.
+	ready1, err := cortex_tsdb.NewTombstone("ready-1", requestCreatedAt, 10, 25, []string{`{series_id="0"}`})
+	require.NoError(t, err)
+	ready2, err := cortex_tsdb.NewTombstone("ready-2", requestCreatedAt, 20, 35, []string{`{series_id="1"}`, `{series_id="unknown"}`})
+	require.NoError(t, err)
+
+	// Still within the cancellation period.
+	notReady, err := cortex_tsdb.NewTombstone("not-ready", time.Now().UnixNano()/int64(time.Millisecond), 0, 100, []string{`{series_id=~".+"}`})
+	require.NoError(t, err)
+
+	// Cancelled.
+	cancelled, err := cortex_tsdb.NewTombstone("cancelled", requestCreatedAt, 0, 100, []string{`{series_id=~".+"}`})
+	require.NoError(t, err)
+	cancelled.State = cortex_tsdb.TombstoneCancelled
+
+	// Deletes samples which may still be in the ingesters.
+	recentData, err := cortex_tsdb.NewTombstone("recent-data", requestCreatedAt, 0, time.Now().UnixNano()/int64(time.Millisecond), []string{`{series_id=~".+"}`})
+	require.NoError(t, err)
+
+	// Processed before the deletion delay, so the original blocks have been deleted.
+	oldProcessed, err := cortex_tsdb.NewTombstone("old-processed", requestCreatedAt, 0, 5, []string{`{series_id=~".+"}`})
+	require.NoError(t, err)
+	oldProcessed.State = cortex_tsdb.TombstoneProcessed
+	oldProcessed.StateCreatedAt = time.Now().Add(-cfg.DeletionDelay-time.Hour).UnixNano() / int64(time.Millisecond)
+
+	for _, tombstone := range []*cortex_tsdb.Tombstone{ready1, ready2, notReady, cancelled, recentData, oldProcessed} {
+		require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, userID, nil, tombstone))
+	}
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+	c.bucketClient = bkt
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, c.applyTombstones(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	// Blocks containing deleted series should have been marked for deletion.
+	for blockID, expected := range map[ulid.ULID]bool{block1: true, block2: true, block3: false} {
+		exists, err := bkt.Exists(ctx, path.Join(userID, blockID.String(), metadata.DeletionMarkFilename))
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists, blockID.String())
+	}
+
+	// Only one new block should have been uploaded, because all series of block2 have been deleted.
+	metas, _, err := fetcher.Fetch(ctx)
+	require.NoError(t, err)
+	require.Len(t, metas, 4)
+
+	var rewritten *metadata.Meta
+	for id, meta := range metas {
+		if id != block1 && id != block2 && id != block3 {
+			rewritten = meta
+		}
+	}
+
+	require.NotNil(t, rewritten)
+	assert.Equal(t, int64(10), rewritten.MinTime)
+	assert.Equal(t, int64(20), rewritten.MaxTime)
+	assert.Equal(t, uint64(1), rewritten.Stats.NumSeries)
+	assert.Equal(t, map[string]string{"__org_id__": userID}, rewritten.Thanos.Labels)
+	assert.Equal(t, []ulid.ULID{block1}, rewritten.Compaction.Sources)
+
+	// Check the state of the delete requests.
+	for requestID, expected := range map[string]cortex_tsdb.TombstoneState{
+		"ready-1":     cortex_tsdb.TombstoneProcessed,
+		"ready-2":     cortex_tsdb.TombstoneProcessed,
+		"not-ready":   cortex_tsdb.TombstonePending,
+		"cancelled":   cortex_tsdb.TombstoneCancelled,
+		"recent-data": cortex_tsdb.TombstonePending,
+	} {
+		tombstone, err := cortex_tsdb.ReadTombstone(ctx, bkt, userID, nil, requestID)
+		require.NoError(t, err)
+		assert.Equal(t, expected, tombstone.State, requestID)
+	}
+
+	// Tombstones processed before the deletion delay should have been deleted.
+	_, err = cortex_tsdb.ReadTombstone(ctx, bkt, userID, nil, "old-processed")
+	assert.Equal(t, cortex_tsdb.ErrTombstoneNotFound, err)
+
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.deleteRequestsProcessed))
+	assert.Equal(t, float64(2), prom_testutil.ToFloat64(c.blocksMarkedForSeriesDeletion))
+}
+
+func TestCompactor_ApplyTombstones_ShouldNotProcessDeleteRequestsIfSomeBlocksFailedToLoad(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+
+	// A block without meta.json, which fails to load.
+	partialBlock := ulid.MustNew(uint64(time.Now().UnixNano()/int64(time.Millisecond)), nil)
+	require.NoError(t, bkt.Upload(ctx, path.Join(userID, partialBlock.String(), "index"), strings.NewReader("index")))
+
+	cfg := prepareConfig()
+	cfg.SeriesDeletionEnabled = true
+	cfg.DeleteRequestCancelPeriod = time.Hour
+	cfg.IngestersRetentionPeriod = time.Hour
+
+	requestCreatedAt := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+	tombstone, err := cortex_tsdb.NewTombstone("ready", requestCreatedAt, 10, 25, []string{`{series_id="0"}`})
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, userID, nil, tombstone))
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+	c.bucketClient = bkt
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, c.applyTombstones(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	// The loaded block should have been rewritten anyway.
+	exists, err := bkt.Exists(ctx, path.Join(userID, block1.String(), metadata.DeletionMarkFilename))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The delete request should be processed in the next cycle.
+	actual, err := cortex_tsdb.ReadTombstone(ctx, bkt, userID, nil, "ready")
+	require.NoError(t, err)
+	assert.Equal(t, cortex_tsdb.TombstonePending, actual.State)
+	assert.Equal(t, float64(0), prom_testutil.ToFloat64(c.deleteRequestsProcessed))
+
+	// Once the block has been loaded, the delete request should be processed.
+	require.NoError(t, bkt.Delete(ctx, path.Join(userID, partialBlock.String(), "index")))
+	require.NoError(t, c.applyTombstones(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	actual, err = cortex_tsdb.ReadTombstone(ctx, bkt, userID, nil, "ready")
+	require.NoError(t, err)
+	assert.Equal(t, cortex_tsdb.TombstoneProcessed, actual.State)
+	assert.Equal(t, float64(1), prom_testutil.ToFloat64(c.deleteRequestsProcessed))
+}
+
+func TestCompactor_ApplyTombstones_ShouldWaitForTheIngestersRetentionPeriod(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	block1 := createTSDBBlock(t, bkt, userID, 10, 20, map[string]string{"__org_id__": userID})
+
+	// The cancellation period is shorter than the ingesters retention period.
+	cfg := prepareConfig()
+	cfg.SeriesDeletionEnabled = true
+	cfg.DeleteRequestCancelPeriod = time.Hour
+	cfg.IngestersRetentionPeriod = 4 * time.Hour
+
+	// The delete request can't be cancelled anymore, but its time range may still be in the ingesters.
+	requestCreatedAt := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+	endTime := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+	tombstone, err := cortex_tsdb.NewTombstone("ready", requestCreatedAt, 10, endTime, []string{`{series_id="0"}`})
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bkt, userID, nil, tombstone))
+
+	c, _, _, _, _ := prepare(t, cfg, bkt)
+	c.bucketClient = bkt
+	c.blocksCompactor, err = tsdb.NewLeveledCompactor(ctx, nil, log.NewNopLogger(), cfg.BlockRanges.ToMilliseconds(), downsample.NewPool(), nil)
+	require.NoError(t, err)
+
+	userBucket := bucket.NewUserBucketClient(userID, bkt, nil)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, userBucket, "", nil, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, c.applyTombstones(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	// The block should not have been rewritten, and the delete request should still be pending.
+	exists, err := bkt.Exists(ctx, path.Join(userID, block1.String(), metadata.DeletionMarkFilename))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	actual, err := cortex_tsdb.ReadTombstone(ctx, bkt, userID, nil, "ready")
+	require.NoError(t, err)
+	assert.Equal(t, cortex_tsdb.TombstonePending, actual.State)
+	assert.Equal(t, float64(0), prom_testutil.ToFloat64(c.deleteRequestsProcessed))
+
+	// Once the time range is older than the ingesters retention period, the delete request should be processed.
+	c.compactorCfg.IngestersRetentionPeriod = time.Hour
+	require.NoError(t, c.applyTombstones(ctx, userID, userBucket, fetcher, log.NewNopLogger()))
+
+	exists, err = bkt.Exists(ctx, path.Join(userID, block1.String(), metadata.DeletionMarkFilename))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	actual, err = cortex_tsdb.ReadTombstone(ctx, bkt, userID, nil, "ready")
+	require.NoError(t, err)
+	assert.Equal(t, cortex_tsdb.TombstoneProcessed, actual.State)
+	assert.Equal(t, float64(1), prom_testutil.ToFloat64(c.deleteRequestsProcessed))
+}
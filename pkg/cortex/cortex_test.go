@@ -151,6 +151,25 @@ func TestConfigValidation(t *testing.T) {
 			},
 			expectedError: errInvalidHTTPPrefix,
 		},
+		{
+			name: "should fail validation if federated rule groups are enabled but tenant federation is disabled",
+			getTestConfig: func() *Config {
+				configuration := newDefaultConfig()
+				configuration.LimitsConfig.RulerTenantFederation = true
+				return configuration
+			},
+			expectedError: errRulerTenantFederationDisabled,
+		},
+		{
+			name: "should pass validation if federated rule groups and tenant federation are enabled",
+			getTestConfig: func() *Config {
+				configuration := newDefaultConfig()
+				configuration.LimitsConfig.RulerTenantFederation = true
+				configuration.TenantFederation.Enabled = true
+				return configuration
+			},
+			expectedError: nil,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			err := tc.getTestConfig().Validate(nil)
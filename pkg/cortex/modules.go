@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/kv"
 	"github.com/grafana/dskit/kv/codec"
 	"github.com/grafana/dskit/kv/memberlist"
 	"github.com/grafana/dskit/modules"
@@ -38,6 +39,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/distributor"
 	"github.com/cortexproject/cortex/pkg/flusher"
 	"github.com/cortexproject/cortex/pkg/frontend"
+	"github.com/cortexproject/cortex/pkg/frontend/audit"
 	"github.com/cortexproject/cortex/pkg/frontend/transport"
 	"github.com/cortexproject/cortex/pkg/ingester"
 	"github.com/cortexproject/cortex/pkg/querier"
@@ -46,7 +48,10 @@ import (
 	querier_worker "github.com/cortexproject/cortex/pkg/querier/worker"
 	"github.com/cortexproject/cortex/pkg/ruler"
 	"github.com/cortexproject/cortex/pkg/scheduler"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
 	"github.com/cortexproject/cortex/pkg/storegateway"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/fakeauth"
 	util_log "github.com/cortexproject/cortex/pkg/util/log"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
@@ -69,6 +74,7 @@ const (
 	StoreQueryable           string = "store-queryable"
 	QueryFrontend            string = "query-frontend"
 	QueryFrontendTripperware string = "query-frontend-tripperware"
+	QueryAuditLog            string = "query-audit-log"
 	Store                    string = "store"
 	DeleteRequestsStore      string = "delete-requests-store"
 	TableManager             string = "table-manager"
@@ -80,6 +86,7 @@ const (
 	StoreGateway             string = "store-gateway"
 	MemberlistKV             string = "memberlist-kv"
 	ChunksPurger             string = "chunks-purger"
+	BlocksPurger             string = "blocks-purger"
 	TenantDeletion           string = "tenant-deletion"
 	Purger                   string = "purger"
 	QueryScheduler           string = "query-scheduler"
@@ -152,7 +159,7 @@ func (t *Cortex) initRuntimeConfig() (services.Service, error) {
 		// no need to initialize module if load path is empty
 		return nil, nil
 	}
-	t.Cfg.RuntimeConfig.Loader = loadRuntimeConfig
+	t.Cfg.RuntimeConfig.Loader = runtimeConfigLoader(t.Cfg.TenantFederation.Enabled)
 
 	// make sure to set default limits before we start loading configuration into memory
 	validation.SetDefaultLimitsForYAMLUnmarshalling(t.Cfg.LimitsConfig)
@@ -211,9 +218,27 @@ func (t *Cortex) initDistributorService() (serv services.Service, err error) {
 }
 
 func (t *Cortex) initDistributor() (serv services.Service, err error) {
-	t.API.RegisterDistributor(t.Distributor, t.Cfg.Distributor)
+	if err := t.API.RegisterDistributor(t.Distributor, t.Cfg.Distributor); err != nil {
+		return nil, err
+	}
 
-	return nil, nil
+	if t.Cfg.Distributor.GraphitePlaintextListenAddress == "" && t.Cfg.Distributor.GraphitePickleListenAddress == "" {
+		return nil, nil
+	}
+
+	// Graphite connections can't be authenticated, so when auth is disabled
+	// the metrics are pushed to the same tenant as the HTTP requests.
+	graphiteTenantID := t.Cfg.Distributor.GraphiteTenantID
+	if graphiteTenantID == "" && !t.Cfg.AuthEnabled {
+		graphiteTenantID = fakeauth.TenantID
+	}
+
+	listener, err := t.API.NewGraphiteListener(t.Distributor, t.Cfg.Distributor, graphiteTenantID, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	return listener, nil
 }
 
 // initQueryable instantiates the queryable and promQL engine used to service queries to
@@ -479,6 +504,18 @@ func (t *Cortex) initChunkStore() (serv services.Service, err error) {
 }
 
 func (t *Cortex) initDeleteRequestsStore() (serv services.Service, err error) {
+	if t.Cfg.Storage.Engine == storage.StorageEngineBlocks && t.Cfg.PurgerConfig.Enable {
+		var deleteStore *purger.BlocksDeleteStore
+		deleteStore, err = purger.NewBlocksDeleteStore(t.Cfg.BlocksStorage, util_log.Logger, prometheus.WrapRegistererWith(
+			prometheus.Labels{"component": DeleteRequestsStore}, prometheus.DefaultRegisterer))
+		if err != nil {
+			return
+		}
+
+		t.TombstonesLoader = purger.NewTombstonesLoader(deleteStore, prometheus.DefaultRegisterer)
+		return
+	}
+
 	if t.Cfg.Storage.Engine != storage.StorageEngineChunks || !t.Cfg.PurgerConfig.Enable {
 		// until we need to explicitly enable delete series support we need to do create TombstonesLoader without DeleteStore which acts as noop
 		t.TombstonesLoader = purger.NewTombstonesLoader(nil, nil)
@@ -507,10 +544,15 @@ func (t *Cortex) initDeleteRequestsStore() (serv services.Service, err error) {
 // initQueryFrontendTripperware instantiates the tripperware used by the query frontend
 // to optimize Prometheus query requests.
 func (t *Cortex) initQueryFrontendTripperware() (serv services.Service, err error) {
-	// Load the schema only if sharded queries is set.
+	shardingSchema := t.Cfg.Schema
+
+	// Load the schema only if sharded queries is set. The blocks storage has no schema
+	// and shards series by hashing their labels, so we build the sharding config from
+	// the configured number of shards instead.
 	if t.Cfg.QueryRange.ShardedQueries {
-		err := t.Cfg.Schema.Load()
-		if err != nil {
+		if t.Cfg.Storage.Engine == storage.StorageEngineBlocks {
+			shardingSchema = chunk.SchemaConfig{Configs: queryrange.BlocksShardingConfigs(t.Cfg.QueryRange.QueryShardingTotalShards)}
+		} else if err := t.Cfg.Schema.Load(); err != nil {
 			return nil, err
 		}
 	}
@@ -521,7 +563,7 @@ func (t *Cortex) initQueryFrontendTripperware() (serv services.Service, err erro
 		t.Overrides,
 		queryrange.PrometheusCodec,
 		queryrange.PrometheusResponseExtractor{},
-		t.Cfg.Schema,
+		shardingSchema,
 		promql.EngineOpts{
 			Logger:           util_log.Logger,
 			Reg:              prometheus.DefaultRegisterer,
@@ -561,7 +603,7 @@ func (t *Cortex) initQueryFrontend() (serv services.Service, err error) {
 	// Wrap roundtripper into Tripperware.
 	roundTripper = t.QueryFrontendTripperware(roundTripper)
 
-	handler := transport.NewHandler(t.Cfg.Frontend.Handler, roundTripper, util_log.Logger, prometheus.DefaultRegisterer)
+	handler := transport.NewHandler(t.Cfg.Frontend.Handler, roundTripper, t.QueryAuditLog, util_log.Logger, prometheus.DefaultRegisterer)
 	t.API.RegisterQueryFrontendHandler(handler)
 
 	if frontendV1 != nil {
@@ -578,6 +620,20 @@ func (t *Cortex) initQueryFrontend() (serv services.Service, err error) {
 	return nil, nil
 }
 
+func (t *Cortex) initQueryAuditLog() (services.Service, error) {
+	if !t.Cfg.QueryAuditLog.Enabled {
+		return nil, nil
+	}
+
+	bucketClient, err := bucket.NewClient(context.Background(), t.Cfg.QueryAuditLog.Config, "query-audit-log", util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the query audit log bucket client")
+	}
+
+	t.QueryAuditLog = audit.NewLogger(t.Cfg.QueryAuditLog, bucketClient, util_log.Logger, prometheus.DefaultRegisterer)
+	return t.QueryAuditLog, nil
+}
+
 func (t *Cortex) initTableManager() (services.Service, error) {
 	if t.Cfg.Storage.Engine == storage.StorageEngineBlocks {
 		return nil, nil // table manager isn't used in v2
@@ -662,7 +718,22 @@ func (t *Cortex) initRuler() (serv services.Service, err error) {
 	// TODO: Consider wrapping logger to differentiate from querier module logger
 	queryable, _, engine := querier.New(t.Cfg.Querier, t.Overrides, t.Distributor, t.StoreQueryables, t.TombstonesLoader, rulerRegisterer, util_log.Logger)
 
-	managerFactory := ruler.DefaultTenantManagerFactory(t.Cfg.Ruler, t.Distributor, queryable, engine, t.Overrides, prometheus.DefaultRegisterer)
+	if t.Cfg.TenantFederation.Enabled {
+		// Federated rule groups query several tenants. Single tenant queries bypass the merge queryable,
+		// so that their series don't get the tenant ID label.
+		queryable = querier.NewSampleAndChunkQueryable(tenantfederation.NewQueryable(queryable, true))
+	}
+
+	var remoteQuerier *ruler.RemoteQuerier
+	if t.Cfg.Ruler.Frontend.Address != "" {
+		t.Cfg.Ruler.Frontend.PrometheusHTTPPrefix = t.Cfg.API.PrometheusHTTPPrefix
+		remoteQuerier, err = ruler.NewRemoteQuerier(t.Cfg.Ruler.Frontend, prometheus.DefaultRegisterer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	managerFactory := ruler.DefaultTenantManagerFactory(t.Cfg.Ruler, t.Distributor, queryable, engine, remoteQuerier, t.Overrides, prometheus.DefaultRegisterer)
 	manager, err := ruler.NewDefaultMultiTenantManager(t.Cfg.Ruler, managerFactory, prometheus.DefaultRegisterer, util_log.Logger)
 	if err != nil {
 		return nil, err
@@ -731,6 +802,39 @@ func (t *Cortex) initAlertManager() (serv services.Service, err error) {
 func (t *Cortex) initCompactor() (serv services.Service, err error) {
 	t.Cfg.Compactor.ShardingRing.ListenPort = t.Cfg.Server.GRPCListenPort
 
+	t.Cfg.Compactor.DeleteRequestCancelPeriod = t.Cfg.PurgerConfig.DeleteRequestCancelPeriod
+	t.Cfg.Compactor.SeriesDeletionEnabled = t.Cfg.PurgerConfig.Enable
+
+	// Samples newer than the query ingesters within period, or not shipped by the ingesters yet, may still be
+	// only in the ingesters, so the delete requests covering them can't be applied to the blocks yet.
+	t.Cfg.Compactor.IngestersRetentionPeriod = t.Cfg.BlocksStorage.TSDB.MaxUnshippedSamplesAge()
+	if t.Cfg.Querier.QueryIngestersWithin > t.Cfg.Compactor.IngestersRetentionPeriod {
+		t.Cfg.Compactor.IngestersRetentionPeriod = t.Cfg.Querier.QueryIngestersWithin
+	}
+
+	if t.Cfg.QueryAuditLog.Enabled {
+		t.Cfg.Compactor.QueryAuditLogRetentionPeriod = t.Cfg.QueryAuditLog.RetentionPeriod
+		t.Cfg.Compactor.QueryAuditLogStorage = t.Cfg.QueryAuditLog.Config
+	}
+
+	// The balanced sharding strategy places the blocks based on the store-gateways load stored in the bucket
+	// index, so that store-gateways and queriers compute the placement from the same loads.
+	if t.Cfg.StoreGateway.ShardingEnabled && t.Cfg.StoreGateway.ShardingStrategy == util.ShardingStrategyBalanced {
+		loadsClient, err := kv.NewClient(
+			t.Cfg.StoreGateway.ShardingRing.KVStore,
+			storegateway.GetLoadDescCodec(),
+			kv.RegistererWithKVName(prometheus.WrapRegistererWithPrefix("cortex_", prometheus.DefaultRegisterer), "compactor-store-gateway-load"),
+			util_log.Logger,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "create store-gateway load KV store client")
+		}
+
+		t.Cfg.Compactor.StoreGatewayLoads = func(ctx context.Context) (map[string]int64, error) {
+			return storegateway.ReadLoads(ctx, loadsClient)
+		}
+	}
+
 	t.Compactor, err = compactor.NewCompactor(t.Cfg.Compactor, t.Cfg.BlocksStorage, t.Overrides, util_log.Logger, prometheus.DefaultRegisterer)
 	if err != nil {
 		return
@@ -750,6 +854,7 @@ func (t *Cortex) initStoreGateway() (serv services.Service, err error) {
 	}
 
 	t.Cfg.StoreGateway.ShardingRing.ListenPort = t.Cfg.Server.GRPCListenPort
+	t.Cfg.BlocksStorage.BucketStore.SeriesDeletionEnabled = t.Cfg.PurgerConfig.Enable
 
 	t.StoreGateway, err = storegateway.NewStoreGateway(t.Cfg.StoreGateway, t.Cfg.BlocksStorage, t.Overrides, t.Cfg.Server.LogLevel, util_log.Logger, prometheus.DefaultRegisterer)
 	if err != nil {
@@ -767,6 +872,8 @@ func (t *Cortex) initMemberlistKV() (services.Service, error) {
 	t.Cfg.MemberlistKV.MetricsRegisterer = reg
 	t.Cfg.MemberlistKV.Codecs = []codec.Codec{
 		ring.GetCodec(),
+		distributor.GetReplicaDescCodec(),
+		storegateway.GetLoadDescCodec(),
 	}
 	dnsProviderReg := prometheus.WrapRegistererWithPrefix(
 		"cortex_",
@@ -781,6 +888,7 @@ func (t *Cortex) initMemberlistKV() (services.Service, error) {
 
 	// Update the config.
 	t.Cfg.Distributor.DistributorRing.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
+	t.Cfg.Distributor.HATrackerConfig.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
 	t.Cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
 	t.Cfg.StoreGateway.ShardingRing.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
 	t.Cfg.Compactor.ShardingRing.KVStore.MemberlistKV = t.MemberlistKV.GetMemberlistKV
@@ -810,6 +918,20 @@ func (t *Cortex) initChunksPurger() (services.Service, error) {
 	return t.Purger, nil
 }
 
+func (t *Cortex) initBlocksPurger() (services.Service, error) {
+	if t.Cfg.Storage.Engine != storage.StorageEngineBlocks || !t.Cfg.PurgerConfig.Enable {
+		return nil, nil
+	}
+
+	blocksPurgerAPI, err := purger.NewBlocksPurgerAPI(t.Cfg.BlocksStorage, t.Overrides, t.Cfg.PurgerConfig.DeleteRequestCancelPeriod, util_log.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	t.API.RegisterBlocksPurger(blocksPurgerAPI)
+	return nil, nil
+}
+
 func (t *Cortex) initTenantDeletionAPI() (services.Service, error) {
 	if t.Cfg.Storage.Engine != storage.StorageEngineBlocks {
 		return nil, nil
@@ -858,6 +980,7 @@ func (t *Cortex) setupModuleManager() error {
 	mm.RegisterModule(Querier, t.initQuerier)
 	mm.RegisterModule(StoreQueryable, t.initStoreQueryables, modules.UserInvisibleModule)
 	mm.RegisterModule(QueryFrontendTripperware, t.initQueryFrontendTripperware, modules.UserInvisibleModule)
+	mm.RegisterModule(QueryAuditLog, t.initQueryAuditLog, modules.UserInvisibleModule)
 	mm.RegisterModule(QueryFrontend, t.initQueryFrontend)
 	mm.RegisterModule(TableManager, t.initTableManager)
 	mm.RegisterModule(RulerStorage, t.initRulerStorage, modules.UserInvisibleModule)
@@ -867,6 +990,7 @@ func (t *Cortex) setupModuleManager() error {
 	mm.RegisterModule(Compactor, t.initCompactor)
 	mm.RegisterModule(StoreGateway, t.initStoreGateway)
 	mm.RegisterModule(ChunksPurger, t.initChunksPurger, modules.UserInvisibleModule)
+	mm.RegisterModule(BlocksPurger, t.initBlocksPurger, modules.UserInvisibleModule)
 	mm.RegisterModule(TenantDeletion, t.initTenantDeletionAPI, modules.UserInvisibleModule)
 	mm.RegisterModule(Purger, nil)
 	mm.RegisterModule(QueryScheduler, t.initQueryScheduler)
@@ -891,7 +1015,7 @@ func (t *Cortex) setupModuleManager() error {
 		Querier:                  {TenantFederation},
 		StoreQueryable:           {Overrides, Store, MemberlistKV},
 		QueryFrontendTripperware: {API, Overrides, DeleteRequestsStore},
-		QueryFrontend:            {QueryFrontendTripperware},
+		QueryFrontend:            {QueryFrontendTripperware, QueryAuditLog},
 		QueryScheduler:           {API, Overrides},
 		TableManager:             {API},
 		Ruler:                    {DistributorService, Store, StoreQueryable, RulerStorage},
@@ -901,8 +1025,9 @@ func (t *Cortex) setupModuleManager() error {
 		Compactor:                {API, MemberlistKV, Overrides},
 		StoreGateway:             {API, Overrides, MemberlistKV},
 		ChunksPurger:             {Store, DeleteRequestsStore, API},
+		BlocksPurger:             {Store, API, Overrides},
 		TenantDeletion:           {Store, API, Overrides},
-		Purger:                   {ChunksPurger, TenantDeletion},
+		Purger:                   {ChunksPurger, BlocksPurger, TenantDeletion},
 		TenantFederation:         {Queryable},
 		All:                      {QueryFrontend, Querier, Ingester, Distributor, TableManager, Purger, StoreGateway, Ruler},
 	}
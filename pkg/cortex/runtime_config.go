@@ -2,6 +2,7 @@ package cortex
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
@@ -77,6 +78,24 @@ func loadRuntimeConfig(r io.Reader) (interface{}, error) {
 	return overrides, nil
 }
 
+// runtimeConfigLoader returns a runtimeconfig.Loader rejecting the per-tenant limits enabling the
+// federated rule groups when tenant federation is disabled.
+func runtimeConfigLoader(tenantFederationEnabled bool) runtimeconfig.Loader {
+	return func(r io.Reader) (interface{}, error) {
+		cfg, err := loadRuntimeConfig(r)
+		if err != nil || tenantFederationEnabled {
+			return cfg, err
+		}
+
+		for userID, limits := range cfg.(*runtimeConfigValues).TenantLimits {
+			if limits != nil && limits.RulerTenantFederation {
+				return nil, fmt.Errorf("invalid overrides of tenant %s: %w", userID, errRulerTenantFederationDisabled)
+			}
+		}
+		return cfg, nil
+	}
+}
+
 func multiClientRuntimeConfigChannel(manager *runtimeconfig.Manager) func() <-chan kv.MultiRuntimeConfig {
 	if manager == nil {
 		return nil
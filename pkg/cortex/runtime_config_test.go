@@ -1,6 +1,7 @@
 package cortex
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -111,3 +112,19 @@ overrides:
 		assert.Nil(t, actual)
 	}
 }
+
+func TestRuntimeConfigLoader_ShouldRejectTenantFederationOverridesIfTenantFederationIsDisabled(t *testing.T) {
+	const config = `
+overrides:
+  '1234':
+    ruler_tenant_federation_enabled: true
+`
+
+	actual, err := runtimeConfigLoader(false)(strings.NewReader(config))
+	assert.True(t, errors.Is(err, errRulerTenantFederationDisabled))
+	assert.Nil(t, actual)
+
+	actual, err = runtimeConfigLoader(true)(strings.NewReader(config))
+	require.NoError(t, err)
+	assert.True(t, actual.(*runtimeConfigValues).TenantLimits["1234"].RulerTenantFederation)
+}
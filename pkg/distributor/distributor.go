@@ -11,6 +11,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/limiter"
 	"github.com/grafana/dskit/ring"
 	ring_client "github.com/grafana/dskit/ring/client"
@@ -36,6 +37,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/extract"
 	util_log "github.com/cortexproject/cortex/pkg/util/log"
 	util_math "github.com/cortexproject/cortex/pkg/util/math"
+	"github.com/cortexproject/cortex/pkg/util/push"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
@@ -58,6 +60,10 @@ const (
 	typeMetadata = "metadata"
 
 	instanceIngestionRateTickInterval = time.Second
+
+	// How frequently the cost attribution values not seen within the idle timeout are purged.
+	costAttributionPurgeInterval    = 3 * time.Minute
+	costAttributionPurgeIdleTimeout = 15 * time.Minute
 )
 
 // Distributor is a storage.SampleAppender and a client.Querier which
@@ -88,6 +94,8 @@ type Distributor struct {
 
 	activeUsers *util.ActiveUsersCleanupService
 
+	costAttribution *util.CostAttribution
+
 	ingestionRate        *util_math.EwmaRate
 	inflightPushRequests atomic.Int64
 
@@ -108,6 +116,7 @@ type Distributor struct {
 	ingesterQueryFailures            *prometheus.CounterVec
 	replicationFactor                prometheus.Gauge
 	latestSeenSampleTimestampPerUser *prometheus.GaugeVec
+	discardedSamplesPerAttribution   *prometheus.CounterVec
 }
 
 // Config contains the configuration required to
@@ -125,6 +134,11 @@ type Config struct {
 	ShardByAllLabels bool   `yaml:"shard_by_all_labels"`
 	ExtendWrites     bool   `yaml:"extend_writes"`
 
+	GraphiteTemplates              flagext.StringSliceCSV `yaml:"graphite_templates"`
+	GraphitePlaintextListenAddress string                 `yaml:"graphite_plaintext_listen_address"`
+	GraphitePickleListenAddress    string                 `yaml:"graphite_pickle_listen_address"`
+	GraphiteTenantID               string                 `yaml:"graphite_tenant_id"`
+
 	// Distributors ring
 	DistributorRing RingConfig `yaml:"ring"`
 
@@ -158,6 +172,10 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.ExtraQueryDelay, "distributor.extra-query-delay", 0, "Time to wait before sending more than the minimum successful query requests.")
 	f.BoolVar(&cfg.ShardByAllLabels, "distributor.shard-by-all-labels", false, "Distribute samples based on all labels, as opposed to solely by user and metric name.")
 	f.StringVar(&cfg.ShardingStrategy, "distributor.sharding-strategy", util.ShardingStrategyDefault, fmt.Sprintf("The sharding strategy to use. Supported values are: %s.", strings.Join(supportedShardingStrategies, ", ")))
+	f.Var(&cfg.GraphiteTemplates, "distributor.graphite-templates", "Comma separated list of templates used to map the Graphite metric paths pushed to the Graphite endpoint to metric names and labels, in the \"[filter] template\" format. The filter is a dot separated list of glob patterns matched against the leading path nodes, and the template a dot separated list of label names each path node is assigned to, where \"measurement\" nodes are joined with underscores to build the metric name, \"measurement*\" joins all the remaining nodes and empty parts skip a node. The first matching template is used. Paths not matching any template are converted to metric names replacing dots with underscores.")
+	f.StringVar(&cfg.GraphitePlaintextListenAddress, "distributor.graphite-plaintext-listen-address", "", "Address to listen on for Graphite metrics sent in the plaintext protocol over raw TCP and UDP connections, eg. \":2003\". These connections are not authenticated, see -distributor.graphite-tenant-id. Disabled if empty.")
+	f.StringVar(&cfg.GraphitePickleListenAddress, "distributor.graphite-pickle-listen-address", "", "Address to listen on for Graphite metrics sent in the pickle protocol over raw TCP connections, eg. \":2004\". These connections are not authenticated, see -distributor.graphite-tenant-id. Disabled if empty.")
+	f.StringVar(&cfg.GraphiteTenantID, "distributor.graphite-tenant-id", "", "Tenant the Graphite metrics received over raw TCP and UDP connections are pushed to. If empty, the tenant is the first node of each metric path, which is removed from the path before applying the templates, unless auth is disabled, in which case the metrics are pushed to the default tenant.")
 	f.BoolVar(&cfg.ExtendWrites, "distributor.extend-writes", true, "Try writing to an additional ingester in the presence of an ingester not in the ACTIVE state. It is useful to disable this along with -ingester.unregister-on-shutdown=false in order to not spread samples to extra ingesters during rolling restarts with consistent naming.")
 
 	f.Float64Var(&cfg.InstanceLimits.MaxIngestionRate, "distributor.instance-limits.max-ingestion-rate", 0, "Max ingestion rate (samples/sec) that this distributor will accept. This limit is per-distributor, not per-tenant. Additional push requests will be rejected. Current ingestion rate is computed as exponentially weighted moving average, updated every second. 0 = unlimited.")
@@ -174,6 +192,16 @@ func (cfg *Config) Validate(limits validation.Limits) error {
 		return errInvalidTenantShardSize
 	}
 
+	if _, err := push.ParseGraphiteTemplates(cfg.GraphiteTemplates); err != nil {
+		return err
+	}
+
+	if cfg.GraphiteTenantID != "" {
+		if err := tenant.ValidTenantID(cfg.GraphiteTenantID); err != nil {
+			return errors.Wrap(err, "invalid Graphite tenant ID")
+		}
+	}
+
 	return cfg.HATrackerConfig.Validate()
 }
 
@@ -320,6 +348,10 @@ func New(cfg Config, clientConfig ingester_client.Config, limits *validation.Ove
 			Name: "cortex_distributor_latest_seen_sample_timestamp_seconds",
 			Help: "Unix timestamp of latest received sample per user.",
 		}, []string{"user"}),
+		discardedSamplesPerAttribution: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_distributor_attributed_discarded_samples_total",
+			Help: "The total number of samples that were discarded per user and cost attribution label value.",
+		}, []string{"user", "attribution"}),
 	}
 
 	promauto.With(reg).NewGauge(prometheus.GaugeOpts{
@@ -348,6 +380,7 @@ func New(cfg Config, clientConfig ingester_client.Config, limits *validation.Ove
 
 	d.replicationFactor.Set(float64(ingestersRing.ReplicationFactor()))
 	d.activeUsers = util.NewActiveUsersCleanupWithDefaultValues(d.cleanupInactiveUser)
+	d.costAttribution = util.NewCostAttribution(limits)
 
 	subservices = append(subservices, d.ingesterPool, d.activeUsers)
 	d.subservices, err = services.NewManager(subservices...)
@@ -374,6 +407,9 @@ func (d *Distributor) running(ctx context.Context) error {
 	ingestionRateTicker := time.NewTicker(instanceIngestionRateTickInterval)
 	defer ingestionRateTicker.Stop()
 
+	costAttributionPurgeTicker := time.NewTicker(costAttributionPurgeInterval)
+	defer costAttributionPurgeTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -382,6 +418,9 @@ func (d *Distributor) running(ctx context.Context) error {
 		case <-ingestionRateTicker.C:
 			d.ingestionRate.Tick()
 
+		case <-costAttributionPurgeTicker.C:
+			d.purgeInactiveCostAttributions(time.Now().Add(-costAttributionPurgeIdleTimeout))
+
 		case err := <-d.subservicesWatcher.Chan():
 			return errors.Wrap(err, "distributor subservice failed")
 		}
@@ -406,9 +445,53 @@ func (d *Distributor) cleanupInactiveUser(userID string) {
 		level.Warn(d.log).Log("msg", "failed to remove cortex_distributor_deduped_samples_total metric for user", "user", userID, "err", err)
 	}
 
+	if err := util.DeleteMatchingLabels(d.discardedSamplesPerAttribution, map[string]string{"user": userID}); err != nil {
+		level.Warn(d.log).Log("msg", "failed to remove cortex_distributor_attributed_discarded_samples_total metric for user", "user", userID, "err", err)
+	}
+	d.costAttribution.DeleteUser(userID)
+
 	validation.DeletePerUserValidationMetrics(userID, d.log)
 }
 
+func (d *Distributor) purgeInactiveCostAttributions(deadline time.Time) {
+	for userID, attributions := range d.costAttribution.PurgeInactive(deadline) {
+		for _, attribution := range attributions {
+			d.discardedSamplesPerAttribution.DeleteLabelValues(userID, attribution)
+		}
+	}
+}
+
+// discardedSamplesTracker counts the discarded samples by value of the tenant's cost attribution label.
+// Series must be added before the request slice is reused, because reusing it clears the labels.
+type discardedSamplesTracker struct {
+	label  string
+	counts map[string]int
+}
+
+func (t *discardedSamplesTracker) add(lbls []cortexpb.LabelAdapter, samples int) {
+	if t.label == "" || samples == 0 {
+		return
+	}
+	if t.counts == nil {
+		t.counts = map[string]int{}
+	}
+	t.counts[cortexpb.FromLabelAdaptersToLabels(lbls).Get(t.label)] += samples
+}
+
+func (t *discardedSamplesTracker) addAll(series []cortexpb.PreallocTimeseries) {
+	for _, ts := range series {
+		t.add(ts.Labels, len(ts.Samples))
+	}
+}
+
+func (d *Distributor) updateDiscardedSamplesPerAttribution(userID string, t *discardedSamplesTracker, now time.Time) {
+	for value, count := range t.counts {
+		attribution := d.costAttribution.Attribute(userID, value, now)
+		d.discardedSamplesPerAttribution.WithLabelValues(userID, attribution).Add(float64(count))
+	}
+	t.counts = nil
+}
+
 // Called after distributor is asked to stop via StopAsync.
 func (d *Distributor) stopping(_ error) error {
 	return services.StopManagerAndAwaitStopped(context.Background(), d.subservices)
@@ -494,6 +577,94 @@ func (d *Distributor) checkSample(ctx context.Context, userID, cluster, replica
 	return true, nil
 }
 
+type haReplicaKey struct {
+	cluster, replica string
+}
+
+type haReplicaCheck struct {
+	removeReplicaLabel bool
+	err                error
+}
+
+// dedupeSeries checks the HA cluster and replica of each series in the input slice, and returns the slice
+// of the series which should be accepted, reusing the input slice. The replica label is removed from the
+// accepted series having both HA labels, while the rejected series are returned to the pool. The returned
+// rejectErr is the reason of the rejection of some series (too many HA clusters takes precedence over
+// deduplication), while err is a non-nil error if the request should be failed without being processed,
+// in which case the input slice is left unchanged.
+func (d *Distributor) dedupeSeries(ctx context.Context, userID string, timeseries []cortexpb.PreallocTimeseries, discarded *discardedSamplesTracker) (accepted []cortexpb.PreallocTimeseries, rejectErr error, err error) {
+	replicaLabel, clusterLabel := d.limits.HAReplicaLabel(userID), d.limits.HAClusterLabel(userID)
+
+	// Check each cluster/replica pair only once per request. No series is modified until
+	// all pairs have been checked, so that the request can still be failed as a whole.
+	checks := map[haReplicaKey]haReplicaCheck{}
+	for _, ts := range timeseries {
+		cluster, replica := findHALabels(replicaLabel, clusterLabel, ts.Labels)
+		key := haReplicaKey{cluster: cluster, replica: replica}
+		if _, ok := checks[key]; ok {
+			continue
+		}
+
+		removeReplicaLabel, err := d.checkSample(ctx, userID, cluster, replica)
+		if err != nil && !errors.Is(err, replicasNotMatchError{}) && !errors.Is(err, tooManyClustersError{}) {
+			return timeseries, nil, err
+		}
+		checks[key] = haReplicaCheck{removeReplicaLabel: removeReplicaLabel, err: err}
+	}
+
+	var (
+		nonHASamples           int
+		tooManyClustersSamples int
+		dedupedSamples         = map[string]int{}
+	)
+
+	accepted = timeseries[:0]
+	for _, ts := range timeseries {
+		cluster, replica := findHALabels(replicaLabel, clusterLabel, ts.Labels)
+		check := checks[haReplicaKey{cluster: cluster, replica: replica}]
+
+		switch {
+		case check.err == nil:
+			// If we found both the cluster and replica labels, we only want to include the cluster label when
+			// storing series in Cortex.
+			if check.removeReplicaLabel {
+				removeLabel(replicaLabel, &ts.Labels)
+			} else {
+				nonHASamples += len(ts.Samples)
+			}
+
+			accepted = append(accepted, ts)
+			continue
+
+		case errors.Is(check.err, tooManyClustersError{}):
+			discarded.add(ts.Labels, len(ts.Samples))
+			tooManyClustersSamples += len(ts.Samples)
+			rejectErr = check.err
+
+		default:
+			// These samples have been deduped.
+			dedupedSamples[cluster] += len(ts.Samples)
+			if rejectErr == nil {
+				rejectErr = check.err
+			}
+		}
+
+		cortexpb.ReuseTimeseries(ts.TimeSeries)
+	}
+
+	if nonHASamples > 0 {
+		d.nonHASamples.WithLabelValues(userID).Add(float64(nonHASamples))
+	}
+	if tooManyClustersSamples > 0 {
+		validation.DiscardedSamples.WithLabelValues(validation.TooManyHAClusters, userID).Add(float64(tooManyClustersSamples))
+	}
+	for cluster, samples := range dedupedSamples {
+		d.dedupedSamples.WithLabelValues(userID, cluster).Add(float64(samples))
+	}
+
+	return accepted, rejectErr, nil
+}
+
 // Validates a single series from a write request. Will remove labels if
 // any are configured to be dropped for the user ID.
 // Returns the validated series with it's labels/samples, and any error.
@@ -566,6 +737,11 @@ func (d *Distributor) Push(ctx context.Context, req *cortexpb.WriteRequest) (*co
 	now := time.Now()
 	d.activeUsers.UpdateUserTimestamp(userID, now)
 
+	discarded := discardedSamplesTracker{label: d.costAttribution.Label(userID)}
+	defer func() {
+		d.updateDiscardedSamplesPerAttribution(userID, &discarded, now)
+	}()
+
 	source := util.GetSourceIPsFromOutgoingCtx(ctx)
 
 	var firstPartialErr error
@@ -593,10 +769,36 @@ func (d *Distributor) Push(ctx context.Context, req *cortexpb.WriteRequest) (*co
 	validatedSamples := 0
 	validatedExemplars := 0
 
-	if d.limits.AcceptHASamples(userID) && len(req.Timeseries) > 0 {
+	if d.limits.AcceptHASamples(userID) && d.limits.HADedupPerSeries(userID) && len(req.Timeseries) > 0 {
+		var rejectErr error
+		req.Timeseries, rejectErr, err = d.dedupeSeries(ctx, userID, req.Timeseries, &discarded)
+		if err != nil {
+			cortexpb.ReuseSlice(req.Timeseries)
+			return nil, err
+		}
+
+		if rejectErr != nil && len(req.Timeseries) == 0 {
+			cortexpb.ReuseSlice(req.Timeseries)
+
+			if errors.Is(rejectErr, replicasNotMatchError{}) {
+				// All series have been deduped.
+				return nil, httpgrpc.Errorf(http.StatusAccepted, rejectErr.Error())
+			}
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, rejectErr.Error())
+		}
+
+		// The series of the other clusters are still ingested, like it happens for validation errors.
+		if errors.Is(rejectErr, tooManyClustersError{}) {
+			firstPartialErr = httpgrpc.Errorf(http.StatusBadRequest, rejectErr.Error())
+		}
+	} else if d.limits.AcceptHASamples(userID) && len(req.Timeseries) > 0 {
 		cluster, replica := findHALabels(d.limits.HAReplicaLabel(userID), d.limits.HAClusterLabel(userID), req.Timeseries[0].Labels)
 		removeReplica, err = d.checkSample(ctx, userID, cluster, replica)
 		if err != nil {
+			if errors.Is(err, tooManyClustersError{}) {
+				discarded.addAll(req.Timeseries)
+			}
+
 			// Ensure the request slice is reused if the series get deduped.
 			cortexpb.ReuseSlice(req.Timeseries)
 
@@ -674,6 +876,9 @@ func (d *Distributor) Push(ctx context.Context, req *cortexpb.WriteRequest) (*co
 
 		// Errors in validation are considered non-fatal, as one series in a request may contain
 		// invalid data but all the remaining series could be perfectly valid.
+		if validationErr != nil {
+			discarded.add(ts.Labels, len(ts.Samples))
+		}
 		if validationErr != nil && firstPartialErr == nil {
 			// The series labels may be retained by validationErr but that's not a problem for this
 			// use case because we format it calling Error() and then we discard it.
@@ -719,6 +924,8 @@ func (d *Distributor) Push(ctx context.Context, req *cortexpb.WriteRequest) (*co
 
 	totalN := validatedSamples + validatedExemplars + len(validatedMetadata)
 	if !d.ingestionRateLimiter.AllowN(now, userID, totalN) {
+		discarded.addAll(validatedTimeseries)
+
 		// Ensure the request slice is reused if the request is rate limited.
 		cortexpb.ReuseSlice(req.Timeseries)
 
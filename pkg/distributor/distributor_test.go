@@ -489,6 +489,56 @@ func TestDistributor_PushIngestionRateLimiter(t *testing.T) {
 	}
 }
 
+func TestDistributor_Push_DiscardedSamplesPerAttribution(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), "user")
+
+	limits := &validation.Limits{}
+	flagext.DefaultValues(limits)
+	limits.IngestionRate = 1
+	limits.IngestionBurstSize = 2
+	limits.CostAttributionLabel = "team"
+
+	dists, _, regs := prepare(t, prepConfig{
+		numIngesters:     3,
+		happyIngesters:   3,
+		numDistributors:  1,
+		shardByAllLabels: true,
+		limits:           limits,
+	})
+	d := dists[0]
+
+	// The series missing the metric name is discarded by the validation.
+	req := &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "team", Value: "a"}}, 1, 1),
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: "team", Value: "b"}}, 1, 1),
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: "bar", Value: "baz"}}, 1, 1),
+	}}
+	_, err := d.Push(ctx, req)
+	require.Error(t, err)
+
+	// The whole request is discarded by the rate limiter.
+	req = &cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "team", Value: "a"}}, 2, 1),
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "team", Value: "c"}}, 2, 1),
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: model.MetricNameLabel, Value: "bar"}, {Name: "team", Value: "c"}}, 2, 1),
+	}}
+	_, err = d.Push(ctx, req)
+	require.Error(t, err)
+
+	require.NoError(t, testutil.GatherAndCompare(regs[0], strings.NewReader(`
+		# HELP cortex_distributor_attributed_discarded_samples_total The total number of samples that were discarded per user and cost attribution label value.
+		# TYPE cortex_distributor_attributed_discarded_samples_total counter
+		cortex_distributor_attributed_discarded_samples_total{attribution="__missing__",user="user"} 1
+		cortex_distributor_attributed_discarded_samples_total{attribution="a",user="user"} 1
+		cortex_distributor_attributed_discarded_samples_total{attribution="b",user="user"} 1
+		cortex_distributor_attributed_discarded_samples_total{attribution="c",user="user"} 2
+	`), "cortex_distributor_attributed_discarded_samples_total"))
+
+	d.cleanupInactiveUser("user")
+
+	require.NoError(t, testutil.GatherAndCompare(regs[0], strings.NewReader(""), "cortex_distributor_attributed_discarded_samples_total"))
+}
+
 func TestDistributor_PushInstanceLimits(t *testing.T) {
 
 	type testPush struct {
@@ -726,6 +776,115 @@ func TestDistributor_PushHAInstances(t *testing.T) {
 	}
 }
 
+func TestDistributor_PushHAInstances_DedupPerSeries(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), "user")
+
+	tests := map[string]struct {
+		timeseries       []cortexpb.PreallocTimeseries
+		expectedCode     int32
+		expectedClusters []string
+		expectedMetrics  string
+	}{
+		"should accept the series of the elected replica of each cluster": {
+			timeseries: append(
+				makeWriteRequestHA(2, "instance0", "cluster0").Timeseries,
+				makeWriteRequestHA(3, "instance1", "cluster1").Timeseries...),
+			expectedClusters: []string{"cluster0", "cluster0", "cluster1", "cluster1", "cluster1"},
+		},
+		"should dedupe the series of non-elected replicas and accept the others": {
+			timeseries: append(append(
+				makeWriteRequestHA(2, "instance0", "cluster0").Timeseries,
+				makeWriteRequestHA(3, "instance0", "cluster1").Timeseries...),
+				makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: model.MetricNameLabel, Value: "bar"}}, 1, 1)),
+			expectedClusters: []string{"cluster0", "cluster0", ""},
+			expectedMetrics: `
+				# HELP cortex_distributor_deduped_samples_total The total number of deduplicated samples.
+				# TYPE cortex_distributor_deduped_samples_total counter
+				cortex_distributor_deduped_samples_total{cluster="cluster1",user="user"} 3
+				# HELP cortex_distributor_non_ha_samples_received_total The total number of received samples for a user that has HA tracking turned on, but the sample didn't contain both HA labels.
+				# TYPE cortex_distributor_non_ha_samples_received_total counter
+				cortex_distributor_non_ha_samples_received_total{user="user"} 1
+			`,
+		},
+		"should return 202 if all series have been deduped": {
+			timeseries: append(
+				makeWriteRequestHA(2, "instance1", "cluster0").Timeseries,
+				makeWriteRequestHA(3, "instance0", "cluster1").Timeseries...),
+			expectedCode: 202,
+			expectedMetrics: `
+				# HELP cortex_distributor_deduped_samples_total The total number of deduplicated samples.
+				# TYPE cortex_distributor_deduped_samples_total counter
+				cortex_distributor_deduped_samples_total{cluster="cluster0",user="user"} 2
+				cortex_distributor_deduped_samples_total{cluster="cluster1",user="user"} 3
+			`,
+		},
+		"should discard the series of clusters exceeding the limit and accept the others": {
+			timeseries: append(
+				makeWriteRequestHA(2, "instance0", "cluster0").Timeseries,
+				makeWriteRequestHA(3, "instance0", "cluster2").Timeseries...),
+			expectedCode:     400,
+			expectedClusters: []string{"cluster0", "cluster0"},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var limits validation.Limits
+			flagext.DefaultValues(&limits)
+			limits.AcceptHASamples = true
+			limits.HADedupPerSeries = true
+			limits.HAMaxClusters = 2
+
+			ds, ingesters, regs := prepare(t, prepConfig{
+				numIngesters:     3,
+				happyIngesters:   3,
+				numDistributors:  1,
+				shardByAllLabels: true,
+				limits:           &limits,
+				enableTracker:    true,
+			})
+			d := ds[0]
+
+			require.NoError(t, d.HATracker.checkReplica(ctx, "user", "cluster0", "instance0", time.Now()))
+			require.NoError(t, d.HATracker.checkReplica(ctx, "user", "cluster1", "instance1", time.Now()))
+
+			// Wait until the HA tracker has received the elected replicas from the KV store.
+			test.Poll(t, time.Second, 2, func() interface{} {
+				d.HATracker.electedLock.RLock()
+				defer d.HATracker.electedLock.RUnlock()
+				return len(d.HATracker.clusters["user"])
+			})
+
+			_, err := d.Push(ctx, &cortexpb.WriteRequest{Timeseries: testData.timeseries})
+			if testData.expectedCode == 0 {
+				require.NoError(t, err)
+			} else {
+				httpResp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, testData.expectedCode, httpResp.Code)
+			}
+
+			// The replica label must have been removed from all the ingested series.
+			ingested := map[uint32]labels.Labels{}
+			for i := range ingesters {
+				for hash, ts := range ingesters[i].series() {
+					ingested[hash] = cortexpb.FromLabelAdaptersToLabels(ts.Labels)
+				}
+			}
+
+			var actualClusters []string
+			for _, lbls := range ingested {
+				assert.False(t, lbls.Has("__replica__"))
+				actualClusters = append(actualClusters, lbls.Get("cluster"))
+			}
+			assert.ElementsMatch(t, testData.expectedClusters, actualClusters)
+
+			require.NoError(t, testutil.GatherAndCompare(regs[0], strings.NewReader(testData.expectedMetrics),
+				"cortex_distributor_deduped_samples_total", "cortex_distributor_non_ha_samples_received_total"))
+		})
+	}
+}
+
 func TestDistributor_PushQuery(t *testing.T) {
 	const shuffleShardSize = 5
 
@@ -2015,7 +2174,9 @@ func prepare(t *testing.T, cfg prepConfig) ([]*Distributor, []mockIngester, []*p
 				UpdateTimeout:   100 * time.Millisecond,
 				FailoverTimeout: time.Second,
 			}
-			cfg.limits.HAMaxClusters = 100
+			if cfg.limits.HAMaxClusters == 0 {
+				cfg.limits.HAMaxClusters = 100
+			}
 		}
 
 		overrides, err := validation.NewOverrides(*cfg.limits, nil)
@@ -15,6 +15,7 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/grafana/dskit/kv"
 	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/memberlist"
 	"github.com/grafana/dskit/services"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -26,6 +27,7 @@ import (
 
 var (
 	errNegativeUpdateTimeoutJitterMax = errors.New("HA tracker max update timeout jitter shouldn't be negative")
+	errNegativeElectionTolerance      = errors.New("HA tracker election tolerance shouldn't be negative")
 	errInvalidFailoverTimeout         = "HA Tracker failover timeout (%v) must be at least 1s greater than update timeout - max jitter (%v)"
 )
 
@@ -45,6 +47,67 @@ func NewReplicaDesc() *ReplicaDesc {
 	return &ReplicaDesc{}
 }
 
+// Merge implements memberlist.Mergeable. The most recent value, the one with the highest received-at
+// timestamp, wins. Ties are broken preferring the value marked for deletion and then the highest replica
+// name, so that all the distributors converge to the same elected replica regardless of the merge order.
+func (r *ReplicaDesc) Merge(mergeable memberlist.Mergeable, _ bool) (memberlist.Mergeable, error) {
+	if mergeable == nil {
+		return nil, nil
+	}
+
+	other, ok := mergeable.(*ReplicaDesc)
+	if !ok {
+		return nil, fmt.Errorf("expected *distributor.ReplicaDesc, got %T", mergeable)
+	}
+
+	if other == nil || !other.supersedes(r) {
+		return nil, nil
+	}
+
+	*r = *other
+	return r.Clone(), nil
+}
+
+// supersedes returns whether r should replace other when merging them.
+func (r *ReplicaDesc) supersedes(other *ReplicaDesc) bool {
+	if r.ReceivedAt != other.ReceivedAt {
+		return r.ReceivedAt > other.ReceivedAt
+	}
+	if r.DeletedAt != other.DeletedAt {
+		return r.DeletedAt > other.DeletedAt
+	}
+	return r.Replica > other.Replica
+}
+
+// MergeContent implements memberlist.Mergeable.
+func (r *ReplicaDesc) MergeContent() []string {
+	if r.Replica == "" {
+		return nil
+	}
+	return []string{r.Replica}
+}
+
+// RemoveTombstones implements memberlist.Mergeable. Like the ring does with the instances in the LEFT state,
+// a replica marked for deletion before the given time limit is removed, resetting the descriptor. If the
+// time limit is zero, the replica is removed if marked for deletion.
+func (r *ReplicaDesc) RemoveTombstones(limit time.Time) (total, removed int) {
+	if r.DeletedAt <= 0 {
+		return 0, 0
+	}
+
+	if limit.IsZero() || timestamp.Time(r.DeletedAt).Before(limit) {
+		*r = ReplicaDesc{}
+		return 0, 1
+	}
+	return 1, 0
+}
+
+// Clone implements memberlist.Mergeable.
+func (r *ReplicaDesc) Clone() memberlist.Mergeable {
+	clone := *r
+	return &clone
+}
+
 // HATrackerConfig contains the configuration require to
 // create a HA Tracker.
 type HATrackerConfig struct {
@@ -59,8 +122,12 @@ type HATrackerConfig struct {
 	// between the stored timestamp and the time we received a sample is
 	// more than this duration
 	FailoverTimeout time.Duration `yaml:"ha_tracker_failover_timeout"`
+	// We accept samples from any replica of a cluster for this duration
+	// after a new replica has been elected, because the other distributors
+	// may not have observed the election yet.
+	ElectionTolerance time.Duration `yaml:"ha_tracker_election_tolerance"`
 
-	KVStore kv.Config `yaml:"kvstore" doc:"description=Backend storage to use for the ring. Please be aware that memberlist is not supported by the HA tracker since gossip propagation is too slow for HA purposes."`
+	KVStore kv.Config `yaml:"kvstore" doc:"description=Backend storage to use for the ring. When using memberlist, the elected replica of a cluster is eventually consistent across distributors, so it's recommended to configure an election tolerance covering the gossip propagation time."`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet.
@@ -69,6 +136,7 @@ func (cfg *HATrackerConfig) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.UpdateTimeout, "distributor.ha-tracker.update-timeout", 15*time.Second, "Update the timestamp in the KV store for a given cluster/replica only after this amount of time has passed since the current stored timestamp.")
 	f.DurationVar(&cfg.UpdateTimeoutJitterMax, "distributor.ha-tracker.update-timeout-jitter-max", 5*time.Second, "Maximum jitter applied to the update timeout, in order to spread the HA heartbeats over time.")
 	f.DurationVar(&cfg.FailoverTimeout, "distributor.ha-tracker.failover-timeout", 30*time.Second, "If we don't receive any samples from the accepted replica for a cluster in this amount of time we will failover to the next replica we receive a sample from. This value must be greater than the update timeout")
+	f.DurationVar(&cfg.ElectionTolerance, "distributor.ha-tracker.election-tolerance", 0, "Accept samples from any replica of a cluster for this amount of time after the distributor has observed a change of the elected replica. This avoids losing samples when distributors temporarily disagree on the elected replica, at the cost of ingesting duplicated samples, and it's recommended when the KV store is memberlist. 0 to disable.")
 
 	// We want the ability to use different Consul instances for the ring and
 	// for HA cluster tracking. We also customize the default keys prefix, in
@@ -83,6 +151,10 @@ func (cfg *HATrackerConfig) Validate() error {
 		return errNegativeUpdateTimeoutJitterMax
 	}
 
+	if cfg.ElectionTolerance < 0 {
+		return errNegativeElectionTolerance
+	}
+
 	minFailureTimeout := cfg.UpdateTimeout + cfg.UpdateTimeoutJitterMax + time.Second
 	if cfg.FailoverTimeout < minFailureTimeout {
 		return fmt.Errorf(errInvalidFailoverTimeout, cfg.FailoverTimeout, minFailureTimeout)
@@ -108,6 +180,7 @@ type haTracker struct {
 
 	electedLock sync.RWMutex
 	elected     map[string]ReplicaDesc         // Replicas we are accepting samples from. Key = "user/cluster".
+	electedAt   map[string]time.Time           // When the elected replicas have been observed by this tracker. Key = "user/cluster".
 	clusters    map[string]map[string]struct{} // Known clusters with elected replicas per user. First key = user, second key = cluster name.
 
 	electedReplicaChanges         *prometheus.CounterVec
@@ -135,6 +208,7 @@ func newHATracker(cfg HATrackerConfig, limits haTrackerLimits, reg prometheus.Re
 		updateTimeoutJitter: jitter,
 		limits:              limits,
 		elected:             map[string]ReplicaDesc{},
+		electedAt:           map[string]time.Time{},
 		clusters:            map[string]map[string]struct{}{},
 
 		electedReplicaChanges: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
@@ -223,8 +297,11 @@ func (c *haTracker) loop(ctx context.Context) error {
 		c.electedLock.Lock()
 		defer c.electedLock.Unlock()
 
-		if replica.DeletedAt > 0 {
+		// Memberlist removes the replicas marked for deletion before notifying the watchers,
+		// so an empty replica means that it has been deleted too.
+		if replica.DeletedAt > 0 || replica.Replica == "" {
 			delete(c.elected, key)
+			delete(c.electedAt, key)
 			c.electedReplicaChanges.DeleteLabelValues(user, cluster)
 			c.electedReplicaTimestamp.DeleteLabelValues(user, cluster)
 
@@ -242,6 +319,11 @@ func (c *haTracker) loop(ctx context.Context) error {
 		if replica.Replica != elected.Replica {
 			c.electedReplicaChanges.WithLabelValues(user, cluster).Inc()
 		}
+		// The election tolerance only applies when the elected replica changes, not when the
+		// distributor observes the elected replica of a cluster for the first time.
+		if exists && replica.Replica != elected.Replica {
+			c.electedAt[key] = time.Now()
+		}
 		if !exists {
 			if c.clusters[user] == nil {
 				c.clusters[user] = map[string]struct{}{}
@@ -308,6 +390,12 @@ func (c *haTracker) cleanupOldReplicas(ctx context.Context, deadline time.Time)
 			continue
 		}
 
+		// Memberlist doesn't support deleting keys, and returns the replicas marked for deletion as
+		// empty ones, because it removes them itself once they're older than the left ingesters timeout.
+		if desc.Replica == "" {
+			continue
+		}
+
 		if desc.DeletedAt > 0 {
 			if timestamp.Time(desc.DeletedAt).After(deadline) {
 				continue
@@ -371,11 +459,17 @@ func (c *haTracker) checkReplica(ctx context.Context, userID, cluster, replica s
 
 	c.electedLock.RLock()
 	entry, ok := c.elected[key]
+	electedAt := c.electedAt[key]
 	clusters := len(c.clusters[userID])
 	c.electedLock.RUnlock()
 
+	// A replica elected recently may not have been observed by all distributors yet (eg. because
+	// of the gossip propagation time), so for a short time we accept samples from any replica:
+	// ingesting duplicated samples is better than losing them.
+	tolerated := ok && c.cfg.ElectionTolerance > 0 && now.Sub(electedAt) < c.cfg.ElectionTolerance
+
 	if ok && now.Sub(timestamp.Time(entry.ReceivedAt)) < c.cfg.UpdateTimeout+c.updateTimeoutJitter {
-		if entry.Replica != replica {
+		if entry.Replica != replica && !tolerated {
 			return replicasNotMatchError{replica: replica, elected: entry.Replica}
 		}
 		return nil
@@ -390,6 +484,9 @@ func (c *haTracker) checkReplica(ctx context.Context, userID, cluster, replica s
 
 	err := c.checkKVStore(ctx, key, replica, now)
 	c.kvCASCalls.WithLabelValues(userID, cluster).Inc()
+	if tolerated && errors.Is(err, replicasNotMatchError{}) {
+		return nil
+	}
 	if err != nil {
 		// The callback within checkKVStore will return a replicasNotMatchError if the sample is being deduped,
 		// otherwise there may have been an actual error CAS'ing that we should log.
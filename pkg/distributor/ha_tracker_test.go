@@ -10,7 +10,9 @@ import (
 	"github.com/go-kit/log"
 	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
 	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/kv/memberlist"
 	"github.com/grafana/dskit/ring"
 	"github.com/grafana/dskit/services"
 	"github.com/pkg/errors"
@@ -20,6 +22,7 @@ import (
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
@@ -80,6 +83,16 @@ func TestHATrackerConfig_Validate(t *testing.T) {
 			}(),
 			expectedErr: errNegativeUpdateTimeoutJitterMax,
 		},
+		"should fail if election tolerance is negative": {
+			cfg: func() HATrackerConfig {
+				cfg := HATrackerConfig{}
+				flagext.DefaultValues(&cfg)
+				cfg.ElectionTolerance = -1
+
+				return cfg
+			}(),
+			expectedErr: errNegativeElectionTolerance,
+		},
 		"should fail if failover timeout is < update timeout + jitter + 1 sec": {
 			cfg: func() HATrackerConfig {
 				cfg := HATrackerConfig{}
@@ -520,6 +533,218 @@ func TestFindHALabels(t *testing.T) {
 	}
 }
 
+func TestReplicaDesc_Merge(t *testing.T) {
+	tests := map[string]struct {
+		local    *ReplicaDesc
+		incoming *ReplicaDesc
+		expected *ReplicaDesc
+	}{
+		"the most recent value wins": {
+			local:    &ReplicaDesc{Replica: "r1", ReceivedAt: 100},
+			incoming: &ReplicaDesc{Replica: "r2", ReceivedAt: 200},
+			expected: &ReplicaDesc{Replica: "r2", ReceivedAt: 200},
+		},
+		"an older value is ignored": {
+			local:    &ReplicaDesc{Replica: "r2", ReceivedAt: 200},
+			incoming: &ReplicaDesc{Replica: "r1", ReceivedAt: 100},
+			expected: &ReplicaDesc{Replica: "r2", ReceivedAt: 200},
+		},
+		"the deletion marker wins over the same value": {
+			local:    &ReplicaDesc{Replica: "r1", ReceivedAt: 100},
+			incoming: &ReplicaDesc{Replica: "r1", ReceivedAt: 100, DeletedAt: 300},
+			expected: &ReplicaDesc{Replica: "r1", ReceivedAt: 100, DeletedAt: 300},
+		},
+		"a new election wins over the deletion marker": {
+			local:    &ReplicaDesc{Replica: "r1", ReceivedAt: 100, DeletedAt: 300},
+			incoming: &ReplicaDesc{Replica: "r2", ReceivedAt: 400},
+			expected: &ReplicaDesc{Replica: "r2", ReceivedAt: 400},
+		},
+		"ties are broken by the replica name": {
+			local:    &ReplicaDesc{Replica: "r1", ReceivedAt: 100},
+			incoming: &ReplicaDesc{Replica: "r2", ReceivedAt: 100},
+			expected: &ReplicaDesc{Replica: "r2", ReceivedAt: 100},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			// The merge must be commutative.
+			for _, inputs := range [][2]*ReplicaDesc{{testData.local, testData.incoming}, {testData.incoming, testData.local}} {
+				local := inputs[0].Clone().(*ReplicaDesc)
+				incoming := inputs[1].Clone().(*ReplicaDesc)
+
+				change, err := local.Merge(incoming, false)
+				require.NoError(t, err)
+				assert.Equal(t, testData.expected, local)
+
+				if *inputs[0] == *testData.expected {
+					assert.Nil(t, change)
+				} else {
+					assert.Equal(t, testData.expected, change)
+				}
+
+				// The merge must be idempotent.
+				change, err = local.Merge(incoming, false)
+				require.NoError(t, err)
+				assert.Nil(t, change)
+				assert.Equal(t, testData.expected, local)
+			}
+		})
+	}
+}
+
+func TestHATracker_Memberlist(t *testing.T) {
+	const userID = "user"
+
+	replicaCodec := GetReplicaDescCodec()
+
+	var mlCfg memberlist.KVConfig
+	flagext.DefaultValues(&mlCfg)
+	mlCfg.TCPTransport.BindAddrs = []string{"localhost"}
+	mlCfg.TCPTransport.BindPort = 0
+	mlCfg.Codecs = []codec.Codec{replicaCodec}
+
+	mkv := memberlist.NewKV(mlCfg, log.NewNopLogger(), dns.NewProvider(log.NewNopLogger(), nil, dns.GolangResolverType), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(context.Background(), mkv)) })
+
+	newTracker := func(electionTolerance time.Duration) *haTracker {
+		c, err := newHATracker(HATrackerConfig{
+			EnableHATracker:   true,
+			KVStore:           kv.Config{Store: "memberlist", StoreConfig: kv.StoreConfig{MemberlistKV: func() (*memberlist.KV, error) { return mkv, nil }}},
+			UpdateTimeout:     time.Minute,
+			FailoverTimeout:   2 * time.Minute,
+			ElectionTolerance: electionTolerance,
+		}, trackerLimits{maxClusters: 100}, nil, log.NewNopLogger())
+		require.NoError(t, err)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), c))
+		t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(context.Background(), c)) })
+		return c
+	}
+
+	strict := newTracker(0)
+	tolerant := newTracker(time.Hour)
+
+	// Elect the first replica.
+	now := time.Now()
+	require.NoError(t, strict.checkReplica(context.Background(), userID, "c1", "r1", now))
+	checkReplicaTimestamp(t, time.Second, strict, userID, "c1", "r1", now)
+	checkReplicaTimestamp(t, time.Second, tolerant, userID, "c1", "r1", now)
+
+	// The election tolerance doesn't apply to the first election observed by a distributor.
+	assert.True(t, errors.Is(strict.checkReplica(context.Background(), userID, "c1", "r2", now), replicasNotMatchError{}))
+	assert.True(t, errors.Is(tolerant.checkReplica(context.Background(), userID, "c1", "r2", now), replicasNotMatchError{}))
+
+	// Simulate a conflicting election made concurrently by another distributor, which gets merged.
+	client, err := memberlist.NewClient(mkv, replicaCodec)
+	require.NoError(t, err)
+
+	casReplica := func(desc *ReplicaDesc) {
+		require.NoError(t, client.CAS(context.Background(), userID+"/c1", func(_ interface{}) (interface{}, bool, error) {
+			return desc, true, nil
+		}))
+	}
+
+	later := now.Add(time.Second)
+	casReplica(&ReplicaDesc{Replica: "r2", ReceivedAt: timestamp.FromTime(later)})
+	checkReplicaTimestamp(t, time.Second, strict, userID, "c1", "r2", later)
+	checkReplicaTimestamp(t, time.Second, tolerant, userID, "c1", "r2", later)
+
+	// Samples from the previously elected replica are accepted only within the election tolerance.
+	assert.True(t, errors.Is(strict.checkReplica(context.Background(), userID, "c1", "r1", later), replicasNotMatchError{}))
+	assert.NoError(t, strict.checkReplica(context.Background(), userID, "c1", "r2", later))
+	assert.NoError(t, tolerant.checkReplica(context.Background(), userID, "c1", "r1", later))
+
+	// Replicas marked for deletion are removed from memory, and memberlist doesn't return them anymore.
+	strict.cleanupOldReplicas(context.Background(), later.Add(time.Second))
+	checkReplicaDeletionState(t, time.Second, strict, userID, "c1", false, true, false)
+	checkReplicaDeletionState(t, time.Second, tolerant, userID, "c1", false, true, false)
+
+	val, err := client.Get(context.Background(), userID+"/c1")
+	require.NoError(t, err)
+	assert.Equal(t, &ReplicaDesc{}, val)
+
+	// A new sample elects the replica again, and the election tolerance doesn't apply.
+	reelected := later.Add(time.Minute)
+	require.NoError(t, strict.checkReplica(context.Background(), userID, "c1", "r1", reelected))
+	checkReplicaTimestamp(t, time.Second, tolerant, userID, "c1", "r1", reelected)
+	assert.True(t, errors.Is(tolerant.checkReplica(context.Background(), userID, "c1", "r2", reelected), replicasNotMatchError{}))
+}
+
+func TestHATracker_CleanupOldReplicasWithMultiMemberlist(t *testing.T) {
+	const userID = "user"
+
+	var mlCfg memberlist.KVConfig
+	flagext.DefaultValues(&mlCfg)
+	mlCfg.TCPTransport.BindAddrs = []string{"localhost"}
+	mlCfg.TCPTransport.BindPort = 0
+	mlCfg.Codecs = []codec.Codec{GetReplicaDescCodec()}
+
+	mkv := memberlist.NewKV(mlCfg, log.NewNopLogger(), dns.NewProvider(log.NewNopLogger(), nil, dns.GolangResolverType), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(context.Background(), mkv)) })
+
+	c, err := newHATracker(HATrackerConfig{
+		EnableHATracker: true,
+		KVStore: kv.Config{
+			Store:       "multi",
+			StoreConfig: kv.StoreConfig{MemberlistKV: func() (*memberlist.KV, error) { return mkv, nil }, Multi: kv.MultiConfig{Primary: "memberlist", Secondary: "mock"}},
+		},
+		UpdateTimeout:   time.Minute,
+		FailoverTimeout: 2 * time.Minute,
+	}, trackerLimits{maxClusters: 100}, nil, log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), c))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(context.Background(), c)) })
+
+	now := time.Now()
+	require.NoError(t, c.checkReplica(context.Background(), userID, "c1", "r1", now))
+	checkReplicaTimestamp(t, time.Second, c, userID, "c1", "r1", now)
+
+	// The replica is marked for deletion, and then removed by memberlist without deleting the key.
+	c.cleanupOldReplicas(context.Background(), now.Add(time.Second))
+	checkReplicaDeletionState(t, time.Second, c, userID, "c1", false, true, false)
+	c.cleanupOldReplicas(context.Background(), time.Now().Add(time.Hour))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.replicasMarkedForDeletion))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.deletedReplicas))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.markingForDeletionsFailed))
+}
+
+func TestReplicaDesc_RemoveTombstones(t *testing.T) {
+	now := time.Now()
+
+	elected := &ReplicaDesc{Replica: "r1", ReceivedAt: timestamp.FromTime(now)}
+	total, removed := elected.RemoveTombstones(now)
+	assert.Equal(t, 0, total)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, "r1", elected.Replica)
+
+	deleted := &ReplicaDesc{Replica: "r1", ReceivedAt: timestamp.FromTime(now.Add(-time.Hour)), DeletedAt: timestamp.FromTime(now)}
+
+	// Replicas marked for deletion after the limit are kept.
+	desc := deleted.Clone().(*ReplicaDesc)
+	total, removed = desc.RemoveTombstones(now.Add(-time.Minute))
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, deleted, desc)
+
+	// Replicas marked for deletion before the limit are removed.
+	desc = deleted.Clone().(*ReplicaDesc)
+	total, removed = desc.RemoveTombstones(now.Add(time.Minute))
+	assert.Equal(t, 0, total)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, &ReplicaDesc{}, desc)
+	assert.Empty(t, desc.MergeContent())
+
+	// All the replicas marked for deletion are removed if the limit is zero.
+	desc = deleted.Clone().(*ReplicaDesc)
+	total, removed = desc.RemoveTombstones(time.Time{})
+	assert.Equal(t, 0, total)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, &ReplicaDesc{}, desc)
+}
+
 func TestHATrackerConfig_ShouldCustomizePrefixDefaultValue(t *testing.T) {
 	haConfig := HATrackerConfig{}
 	ringConfig := ring.Config{}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"flag"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+var (
+	errInvalidFlushInterval = errors.New("the query audit log flush interval must be greater than 0")
+	errInvalidMaxBatchSize  = errors.New("the query audit log max batch size must be greater than 0")
+	errInvalidMaxBuffered   = errors.New("the query audit log max buffered records must be greater than or equal to the max batch size")
+)
+
+// Config configures the query audit log.
+type Config struct {
+	Enabled            bool           `yaml:"enabled"`
+	FlushInterval      time.Duration  `yaml:"flush_interval"`
+	MaxBatchSize       int            `yaml:"max_batch_size"`
+	MaxBufferedRecords int            `yaml:"max_buffered_records"`
+	WriteBackoff       backoff.Config `yaml:"write_backoff"`
+	RetentionPeriod    time.Duration  `yaml:"retention_period"`
+
+	bucket.Config `yaml:",inline"`
+}
+
+// RegisterFlags registers the query audit log flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	prefix := "query-audit-log."
+
+	f.BoolVar(&cfg.Enabled, prefix+"enabled", false, "True to record every query received by the query-frontend in the query audit log, stored in the object storage as newline-delimited JSON files per tenant per hour.")
+	f.DurationVar(&cfg.FlushInterval, prefix+"flush-interval", time.Minute, "How frequently the buffered query audit log records are written to the object storage.")
+	f.IntVar(&cfg.MaxBatchSize, prefix+"max-batch-size", 1000, "Number of buffered query audit log records triggering a write to the object storage before the flush interval.")
+	f.IntVar(&cfg.MaxBufferedRecords, prefix+"max-buffered-records", 100000, "Max number of query audit log records buffered in the query-frontend, including the ones failed to be written to the object storage, which are retried at the next flush. Once reached, the records of the queries are dropped until the buffered records are written.")
+	cfg.WriteBackoff.RegisterFlagsWithPrefix(prefix+"write", f)
+	f.DurationVar(&cfg.RetentionPeriod, prefix+"retention-period", 0, "Delete the query audit log files older than this period. The files are deleted by the compactor, which requires the query audit log to be enabled and its storage configured too. 0 to disable.")
+
+	cfg.Config.RegisterFlagsWithPrefix(prefix, f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if cfg.FlushInterval <= 0 {
+		return errInvalidFlushInterval
+	}
+	if cfg.MaxBatchSize <= 0 {
+		return errInvalidMaxBatchSize
+	}
+	if cfg.MaxBufferedRecords < cfg.MaxBatchSize {
+		return errInvalidMaxBuffered
+	}
+
+	return errors.Wrap(cfg.Config.Validate(), "invalid query audit log storage config")
+}
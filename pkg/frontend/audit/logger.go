@@ -0,0 +1,210 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/services"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/auditlog"
+)
+
+// batchKey identifies the records of a tenant written to the same hour directory.
+type batchKey struct {
+	tenant string
+	hour   time.Time
+}
+
+// Logger buffers the query audit log records and periodically writes them to the object
+// storage as newline-delimited JSON files, under the <tenant>/<day>/<hour>/ directory.
+// The records failed to be written are kept buffered and retried at the next flush, while
+// the records logged once the buffer is full are dropped, so that a slow or unavailable
+// object storage never slows down the queries.
+type Logger struct {
+	services.Service
+
+	cfg    Config
+	bkt    objstore.Bucket
+	logger log.Logger
+
+	mtx     sync.Mutex
+	batches map[batchKey][]Record
+	// Number of records buffered, including the ones being written.
+	buffered int
+
+	// Notified when the max batch size is reached.
+	flushCh chan struct{}
+
+	entropy *rand.Rand
+
+	// Metrics.
+	recordsWritten   prometheus.Counter
+	recordsDropped   prometheus.Counter
+	recordsDiscarded prometheus.Counter
+	writeFailures    prometheus.Counter
+}
+
+// NewLogger makes a new Logger writing the query audit log records to the bucket.
+func NewLogger(cfg Config, bkt objstore.Bucket, logger log.Logger, reg prometheus.Registerer) *Logger {
+	l := &Logger{
+		cfg:     cfg,
+		bkt:     bkt,
+		logger:  logger,
+		batches: map[batchKey][]Record{},
+		flushCh: make(chan struct{}, 1),
+		entropy: rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		recordsWritten: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_query_audit_log_records_written_total",
+			Help: "Total number of query audit log records written to the object storage.",
+		}),
+		recordsDropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_frontend_audit_records_dropped_total",
+			Help: "Total number of query audit log records dropped because the buffer was full.",
+		}),
+		recordsDiscarded: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_query_audit_log_records_discarded_total",
+			Help: "Total number of query audit log records discarded because they failed to be written to the object storage on shutdown.",
+		}),
+		writeFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_query_audit_log_write_failures_total",
+			Help: "Total number of query audit log files failed to be written to the object storage after all retries.",
+		}),
+	}
+
+	l.Service = services.NewBasicService(nil, l.running, l.stopping)
+	return l
+}
+
+// Log buffers the record, which is written to the object storage on the next flush. It never
+// blocks: if the buffer is full, the record is dropped.
+func (l *Logger) Log(r Record) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.buffered >= l.cfg.MaxBufferedRecords {
+		level.Warn(l.logger).Log("msg", "dropped query audit log record because the buffer is full", "user", r.Tenant)
+		l.recordsDropped.Inc()
+		return
+	}
+
+	key := batchKey{tenant: r.Tenant, hour: r.Timestamp.UTC().Truncate(time.Hour)}
+	l.batches[key] = append(l.batches[key], r)
+	l.buffered++
+
+	if l.buffered >= l.cfg.MaxBatchSize {
+		l.notifyFlush()
+	}
+}
+
+func (l *Logger) notifyFlush() {
+	select {
+	case l.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Logger) running(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.flush(ctx, false)
+		case <-l.flushCh:
+			l.flush(ctx, false)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (l *Logger) stopping(_ error) error {
+	// Write the records buffered so far before shutting down.
+	l.flush(context.Background(), true)
+	return nil
+}
+
+// flush writes all the buffered records to the object storage, one file per tenant per hour,
+// retrying with backoff. The records failed to be written are buffered again to be retried at
+// the next flush, unless discard is true.
+func (l *Logger) flush(ctx context.Context, discard bool) {
+	l.mtx.Lock()
+	batches := l.batches
+	l.batches = map[batchKey][]Record{}
+	l.mtx.Unlock()
+
+	for key, records := range batches {
+		if err := l.writeWithRetries(ctx, key, records); err != nil {
+			level.Error(l.logger).Log("msg", "failed to write query audit log records", "user", key.tenant, "records", len(records), "err", err)
+			l.writeFailures.Inc()
+
+			if !discard {
+				l.requeue(key, records)
+				continue
+			}
+			l.recordsDiscarded.Add(float64(len(records)))
+		} else {
+			l.recordsWritten.Add(float64(len(records)))
+		}
+
+		l.release(len(records))
+	}
+}
+
+// requeue buffers again the records failed to be written, before the ones logged in the meanwhile.
+func (l *Logger) requeue(key batchKey, records []Record) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.batches[key] = append(records, l.batches[key]...)
+}
+
+// release removes the input number of records from the buffer.
+func (l *Logger) release(n int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.buffered -= n
+}
+
+func (l *Logger) writeWithRetries(ctx context.Context, key batchKey, records []Record) error {
+	retries := backoff.New(ctx, l.cfg.WriteBackoff)
+	for {
+		err := l.write(ctx, key, records)
+		if err == nil {
+			return nil
+		}
+
+		retries.Wait()
+		if !retries.Ongoing() {
+			return err
+		}
+	}
+}
+
+func (l *Logger) write(ctx context.Context, key batchKey, records []Record) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	name := ulid.MustNew(ulid.Now(), l.entropy).String() + auditlog.FileExtension
+	return l.bkt.Upload(ctx, path.Join(auditlog.HourDir(key.tenant, key.hour), name), &buf)
+}
@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/services"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/storage/auditlog"
+)
+
+func TestLogger_ShouldWriteRecordsPerTenantPerHour(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	reg := prometheus.NewPedanticRegistry()
+
+	// Use a long flush interval, so that records are written only on shutdown.
+	l := NewLogger(Config{FlushInterval: time.Hour, MaxBatchSize: 100, MaxBufferedRecords: 100}, bkt, log.NewNopLogger(), reg)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+
+	hour := time.Date(2021, 10, 12, 5, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Timestamp: hour.Add(time.Minute), Tenant: "user-1", Query: "up"},
+		{Timestamp: hour.Add(2 * time.Minute), Tenant: "user-1", Query: "sum(up)"},
+		{Timestamp: hour.Add(time.Hour), Tenant: "user-1", Query: "rate(up[1m])"},
+		{Timestamp: hour.Add(time.Minute), Tenant: "user-2", Query: "up", Stats: &Stats{FetchedSeries: 10}},
+	}
+	for _, r := range records {
+		l.Log(r)
+	}
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+
+	assert.Equal(t, map[string][]Record{
+		"user-1/2021-10-12/05/": {records[0], records[1]},
+		"user-1/2021-10-12/06/": {records[2]},
+		"user-2/2021-10-12/05/": {records[3]},
+	}, readRecords(t, bkt))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_query_audit_log_records_written_total Total number of query audit log records written to the object storage.
+		# TYPE cortex_query_audit_log_records_written_total counter
+		cortex_query_audit_log_records_written_total 4
+	`), "cortex_query_audit_log_records_written_total"))
+}
+
+func TestLogger_ShouldFlushOnceMaxBatchSizeIsReached(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+
+	l := NewLogger(Config{FlushInterval: time.Hour, MaxBatchSize: 2, MaxBufferedRecords: 2}, bkt, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	})
+
+	now := time.Now()
+	l.Log(Record{Timestamp: now, Tenant: "user-1", Query: "up"})
+	l.Log(Record{Timestamp: now, Tenant: "user-1", Query: "sum(up)"})
+
+	require.Eventually(t, func() bool {
+		return len(bkt.Objects()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestLogger_ShouldRetryFailedWrites(t *testing.T) {
+	bkt := &failingBucket{InMemBucket: objstore.NewInMemBucket()}
+	reg := prometheus.NewPedanticRegistry()
+
+	cfg := Config{
+		FlushInterval:      time.Hour,
+		MaxBatchSize:       2,
+		MaxBufferedRecords: 3,
+		WriteBackoff:       backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 2},
+	}
+	l := NewLogger(cfg, bkt, log.NewNopLogger(), reg)
+
+	// The first write fails after all the retries, so its records are kept buffered.
+	bkt.failures.Store(2)
+
+	hour := time.Date(2021, 10, 12, 5, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Timestamp: hour, Tenant: "user-1", Query: "up"},
+		{Timestamp: hour, Tenant: "user-1", Query: "sum(up)"},
+		{Timestamp: hour, Tenant: "user-1", Query: "rate(up[1m])"},
+	}
+	for _, r := range records[:2] {
+		l.Log(r)
+	}
+
+	l.flush(context.Background(), false)
+	assert.Empty(t, bkt.Objects())
+	assert.Equal(t, 2, l.buffered)
+
+	// Once the buffer is full, the records are dropped.
+	l.Log(records[2])
+	l.Log(Record{Timestamp: hour, Tenant: "user-1", Query: "dropped"})
+
+	// The next flush writes the records failed to be written.
+	l.flush(context.Background(), false)
+	assert.Equal(t, map[string][]Record{"user-1/2021-10-12/05/": records}, readRecords(t, bkt.InMemBucket))
+	assert.Equal(t, 0, l.buffered)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_frontend_audit_records_dropped_total Total number of query audit log records dropped because the buffer was full.
+		# TYPE cortex_frontend_audit_records_dropped_total counter
+		cortex_frontend_audit_records_dropped_total 1
+		# HELP cortex_query_audit_log_records_written_total Total number of query audit log records written to the object storage.
+		# TYPE cortex_query_audit_log_records_written_total counter
+		cortex_query_audit_log_records_written_total 3
+		# HELP cortex_query_audit_log_write_failures_total Total number of query audit log files failed to be written to the object storage after all retries.
+		# TYPE cortex_query_audit_log_write_failures_total counter
+		cortex_query_audit_log_write_failures_total 1
+	`), "cortex_frontend_audit_records_dropped_total", "cortex_query_audit_log_records_written_total", "cortex_query_audit_log_write_failures_total"))
+}
+
+func TestLogger_ShouldNotBlockWhileTheObjectStorageIsSlow(t *testing.T) {
+	bkt := &blockingBucket{InMemBucket: objstore.NewInMemBucket(), unblock: make(chan struct{})}
+	reg := prometheus.NewPedanticRegistry()
+
+	l := NewLogger(Config{FlushInterval: time.Hour, MaxBatchSize: 2, MaxBufferedRecords: 2}, bkt, log.NewNopLogger(), reg)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+	t.Cleanup(func() {
+		close(bkt.unblock)
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+	})
+
+	// The first two records fill the buffer and trigger a flush, which hangs on the upload.
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		l.Log(Record{Timestamp: now, Tenant: "user-1", Query: "up"})
+	}
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_frontend_audit_records_dropped_total Total number of query audit log records dropped because the buffer was full.
+		# TYPE cortex_frontend_audit_records_dropped_total counter
+		cortex_frontend_audit_records_dropped_total 8
+	`), "cortex_frontend_audit_records_dropped_total"))
+}
+
+// blockingBucket blocks the uploads until unblock is closed.
+type blockingBucket struct {
+	*objstore.InMemBucket
+
+	unblock chan struct{}
+}
+
+func (b *blockingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	<-b.unblock
+	return b.InMemBucket.Upload(ctx, name, r)
+}
+
+// failingBucket fails the uploads until the configured number of failures is reached.
+type failingBucket struct {
+	*objstore.InMemBucket
+
+	failures atomic.Int32
+}
+
+func (b *failingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if b.failures.Dec() >= 0 {
+		return errors.New("upload failed")
+	}
+	return b.InMemBucket.Upload(ctx, name, r)
+}
+
+// readRecords returns the records written to the bucket, by hour directory.
+func readRecords(t *testing.T, bkt *objstore.InMemBucket) map[string][]Record {
+	out := map[string][]Record{}
+
+	for name, content := range bkt.Objects() {
+		require.True(t, strings.HasSuffix(name, auditlog.FileExtension))
+		dir := name[:strings.LastIndex(name, "/")+1]
+
+		scanner := bufio.NewScanner(strings.NewReader(string(content)))
+		for scanner.Scan() {
+			r := Record{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+			out[dir] = append(out[dir], r)
+		}
+		require.NoError(t, scanner.Err())
+	}
+
+	return out
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"time"
+
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
+)
+
+// Record is the query audit log record of a query received by the query-frontend.
+type Record struct {
+	Timestamp           time.Time `json:"timestamp"`
+	Tenant              string    `json:"tenant"`
+	UserAgent           string    `json:"user_agent,omitempty"`
+	SourceIPs           string    `json:"source_ips,omitempty"`
+	Method              string    `json:"method"`
+	Path                string    `json:"path"`
+	Query               string    `json:"query,omitempty"`
+	Start               string    `json:"start,omitempty"`
+	End                 string    `json:"end,omitempty"`
+	Step                string    `json:"step,omitempty"`
+	Time                string    `json:"time,omitempty"`
+	StatusCode          int       `json:"status_code"`
+	ResponseTimeSeconds float64   `json:"response_time_seconds"`
+
+	Stats *Stats `json:"stats,omitempty"`
+}
+
+// Stats of the query recorded in the query audit log.
+type Stats struct {
+	WallTimeSeconds    float64 `json:"wall_time_seconds"`
+	FetchedSeries      uint64  `json:"fetched_series"`
+	FetchedChunkBytes  uint64  `json:"fetched_chunk_bytes"`
+	ProcessedSamples   uint64  `json:"processed_samples"`
+	SplitQueries       uint64  `json:"split_queries"`
+	ShardedQueries     uint64  `json:"sharded_queries"`
+	ResultsCacheHits   uint64  `json:"results_cache_hits"`
+	ResultsCacheMisses uint64  `json:"results_cache_misses"`
+	QueueTimeSeconds   float64 `json:"queue_time_seconds"`
+	TouchedBlocks      uint64  `json:"touched_blocks"`
+	FetchedIndexBytes  uint64  `json:"fetched_index_bytes"`
+}
+
+// NewStats returns the audit log stats of the query stats, or nil if the query stats are not tracked.
+func NewStats(s *querier_stats.Stats) *Stats {
+	if s == nil {
+		return nil
+	}
+
+	return &Stats{
+		WallTimeSeconds:    s.LoadWallTime().Seconds(),
+		FetchedSeries:      s.LoadFetchedSeries(),
+		FetchedChunkBytes:  s.LoadFetchedChunkBytes(),
+		ProcessedSamples:   s.LoadProcessedSamples(),
+		SplitQueries:       s.LoadSplitQueries(),
+		ShardedQueries:     s.LoadShardedQueries(),
+		ResultsCacheHits:   s.LoadResultsCacheHits(),
+		ResultsCacheMisses: s.LoadResultsCacheMisses(),
+		QueueTimeSeconds:   s.LoadQueueTime().Seconds(),
+		TouchedBlocks:      s.LoadTouchedBlocks(),
+		FetchedIndexBytes:  s.LoadFetchedIndexBytes(),
+	}
+}
@@ -19,7 +19,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/httpgrpc/server"
+	"github.com/weaveworks/common/middleware"
 
+	"github.com/cortexproject/cortex/pkg/frontend/audit"
 	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 	"github.com/cortexproject/cortex/pkg/tenant"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -58,19 +60,37 @@ type Handler struct {
 	log          log.Logger
 	roundTripper http.RoundTripper
 
+	// Query audit log, nil if disabled.
+	auditLog  *audit.Logger
+	sourceIPs *middleware.SourceIPExtractor
+
 	// Metrics.
 	querySeconds *prometheus.CounterVec
 	querySeries  *prometheus.CounterVec
 	queryBytes   *prometheus.CounterVec
 	activeUsers  *util.ActiveUsersCleanupService
+
+	// Per-query metrics.
+	processedSamples  *prometheus.HistogramVec
+	subQueries        *prometheus.HistogramVec
+	cacheHitRatio     *prometheus.HistogramVec
+	queueTime         *prometheus.HistogramVec
+	touchedBlocks     *prometheus.HistogramVec
+	fetchedIndexBytes *prometheus.HistogramVec
 }
 
-// NewHandler creates a new frontend handler.
-func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logger, reg prometheus.Registerer) http.Handler {
+// NewHandler creates a new frontend handler. The queries are recorded in the query audit log if not nil.
+func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, auditLog *audit.Logger, log log.Logger, reg prometheus.Registerer) http.Handler {
 	h := &Handler{
 		cfg:          cfg,
 		log:          log,
 		roundTripper: roundTripper,
+		auditLog:     auditLog,
+	}
+
+	if auditLog != nil {
+		// Extract the source IPs from the forwarding headers, if any, and the remote address.
+		h.sourceIPs, _ = middleware.NewSourceIPs("", "")
 	}
 
 	if cfg.QueryStatsEnabled {
@@ -89,10 +109,52 @@ func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logge
 			Help: "Size of all chunks fetched to execute a query in bytes.",
 		}, []string{"user"})
 
+		h.processedSamples = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cortex_query_processed_samples",
+			Help:    "Number of samples processed by the PromQL engine to execute a query.",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 8),
+		}, []string{"user"})
+
+		h.subQueries = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cortex_query_subqueries",
+			Help:    "Number of split and sharded sub-queries a query has been executed as.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}, []string{"user"})
+
+		h.cacheHitRatio = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cortex_query_results_cache_hit_ratio",
+			Help:    "Ratio of the results cache lookups of a query which have been hits.",
+			Buckets: prometheus.LinearBuckets(0, 0.1, 11),
+		}, []string{"user"})
+
+		h.queueTime = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cortex_query_queue_time_seconds",
+			Help:    "Time the requests of a query have spent in the queue.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"user"})
+
+		h.touchedBlocks = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cortex_query_touched_blocks",
+			Help:    "Number of store-gateway blocks queried to execute a query.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}, []string{"user"})
+
+		h.fetchedIndexBytes = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cortex_query_fetched_index_bytes",
+			Help:    "Size of the blocks index fetched from the storage by the store-gateways to execute a query in bytes.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+		}, []string{"user"})
+
 		h.activeUsers = util.NewActiveUsersCleanupWithDefaultValues(func(user string) {
 			h.querySeconds.DeleteLabelValues(user)
 			h.querySeries.DeleteLabelValues(user)
 			h.queryBytes.DeleteLabelValues(user)
+			h.processedSamples.DeleteLabelValues(user)
+			h.subQueries.DeleteLabelValues(user)
+			h.cacheHitRatio.DeleteLabelValues(user)
+			h.queueTime.DeleteLabelValues(user)
+			h.touchedBlocks.DeleteLabelValues(user)
+			h.fetchedIndexBytes.DeleteLabelValues(user)
 		})
 		// If cleaner stops or fail, we will simply not clean the metrics for inactive users.
 		_ = h.activeUsers.StartAsync(context.Background())
@@ -108,8 +170,8 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	)
 
 	// Initialise the stats in the context and make sure it's propagated
-	// down the request chain.
-	if f.cfg.QueryStatsEnabled {
+	// down the request chain. The stats are recorded in the query audit log too.
+	if f.cfg.QueryStatsEnabled || f.auditLog != nil {
 		var ctx context.Context
 		stats, ctx = querier_stats.ContextWithEmptyStats(r.Context())
 		r = r.WithContext(ctx)
@@ -130,6 +192,10 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		writeError(w, err)
+
+		if f.auditLog != nil {
+			f.reportAuditLog(r, f.parseRequestQueryString(r, buf), startTime, queryResponseTime, errorStatusCode(err), stats)
+		}
 		return
 	}
 
@@ -148,7 +214,7 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Check whether we should parse the query string.
 	shouldReportSlowQuery := f.cfg.LogQueriesLongerThan > 0 && queryResponseTime > f.cfg.LogQueriesLongerThan
-	if shouldReportSlowQuery || f.cfg.QueryStatsEnabled {
+	if shouldReportSlowQuery || f.cfg.QueryStatsEnabled || f.auditLog != nil {
 		queryString = f.parseRequestQueryString(r, buf)
 	}
 
@@ -158,6 +224,9 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if f.cfg.QueryStatsEnabled {
 		f.reportQueryStats(r, queryString, queryResponseTime, stats)
 	}
+	if f.auditLog != nil {
+		f.reportAuditLog(r, queryString, startTime, queryResponseTime, resp.StatusCode, stats)
+	}
 }
 
 // reportSlowQuery reports slow queries.
@@ -182,11 +251,28 @@ func (f *Handler) reportQueryStats(r *http.Request, queryString url.Values, quer
 	wallTime := stats.LoadWallTime()
 	numSeries := stats.LoadFetchedSeries()
 	numBytes := stats.LoadFetchedChunkBytes()
+	processedSamples := stats.LoadProcessedSamples()
+	splitQueries := stats.LoadSplitQueries()
+	shardedQueries := stats.LoadShardedQueries()
+	cacheHits := stats.LoadResultsCacheHits()
+	cacheMisses := stats.LoadResultsCacheMisses()
+	queueTime := stats.LoadQueueTime()
+	touchedBlocks := stats.LoadTouchedBlocks()
+	fetchedIndexBytes := stats.LoadFetchedIndexBytes()
 
 	// Track stats.
 	f.querySeconds.WithLabelValues(userID).Add(wallTime.Seconds())
 	f.querySeries.WithLabelValues(userID).Add(float64(numSeries))
 	f.queryBytes.WithLabelValues(userID).Add(float64(numBytes))
+	f.processedSamples.WithLabelValues(userID).Observe(float64(processedSamples))
+	f.subQueries.WithLabelValues(userID).Observe(float64(splitQueries + shardedQueries))
+	f.queueTime.WithLabelValues(userID).Observe(queueTime.Seconds())
+	f.touchedBlocks.WithLabelValues(userID).Observe(float64(touchedBlocks))
+	f.fetchedIndexBytes.WithLabelValues(userID).Observe(float64(fetchedIndexBytes))
+	// The hit ratio is only tracked for the queries which have looked up the results cache.
+	if lookups := cacheHits + cacheMisses; lookups > 0 {
+		f.cacheHitRatio.WithLabelValues(userID).Observe(float64(cacheHits) / float64(lookups))
+	}
 	f.activeUsers.UpdateUserTimestamp(userID, time.Now())
 
 	// Log stats.
@@ -199,11 +285,44 @@ func (f *Handler) reportQueryStats(r *http.Request, queryString url.Values, quer
 		"query_wall_time_seconds", wallTime.Seconds(),
 		"fetched_series_count", numSeries,
 		"fetched_chunks_bytes", numBytes,
+		"processed_samples", processedSamples,
+		"split_queries", splitQueries,
+		"sharded_queries", shardedQueries,
+		"results_cache_hits", cacheHits,
+		"results_cache_misses", cacheMisses,
+		"queue_time_seconds", queueTime.Seconds(),
+		"touched_blocks", touchedBlocks,
+		"fetched_index_bytes", fetchedIndexBytes,
 	}, formatQueryString(queryString)...)
 
 	level.Info(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
 }
 
+// reportAuditLog records the query in the query audit log.
+func (f *Handler) reportAuditLog(r *http.Request, queryString url.Values, startTime time.Time, queryResponseTime time.Duration, statusCode int, stats *querier_stats.Stats) {
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		return
+	}
+
+	f.auditLog.Log(audit.Record{
+		Timestamp:           startTime,
+		Tenant:              tenant.JoinTenantIDs(tenantIDs),
+		UserAgent:           r.UserAgent(),
+		SourceIPs:           f.sourceIPs.Get(r),
+		Method:              r.Method,
+		Path:                r.URL.Path,
+		Query:               queryString.Get("query"),
+		Start:               queryString.Get("start"),
+		End:                 queryString.Get("end"),
+		Step:                queryString.Get("step"),
+		Time:                queryString.Get("time"),
+		StatusCode:          statusCode,
+		ResponseTimeSeconds: queryResponseTime.Seconds(),
+		Stats:               audit.NewStats(stats),
+	})
+}
+
 func (f *Handler) parseRequestQueryString(r *http.Request, bodyBuf bytes.Buffer) url.Values {
 	// Use previously buffered body.
 	r.Body = ioutil.NopCloser(&bodyBuf)
@@ -239,6 +358,24 @@ func writeError(w http.ResponseWriter, err error) {
 	server.WriteError(w, err)
 }
 
+// errorStatusCode returns the HTTP status code of the response written by writeError for the error.
+func errorStatusCode(err error) int {
+	switch err {
+	case context.Canceled:
+		return StatusClientClosedRequest
+	case context.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+
+	if util.IsRequestBodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); ok {
+		return int(resp.Code)
+	}
+	return http.StatusInternalServerError
+}
+
 func writeServiceTimingHeader(queryResponseTime time.Duration, headers http.Header, stats *querier_stats.Stats) {
 	if stats != nil {
 		parts := make([]string, 0)
@@ -1,21 +1,29 @@
 package transport
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/frontend/audit"
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 )
 
 type roundTripperFunc func(*http.Request) (*http.Response, error)
@@ -33,6 +41,7 @@ func TestWriteError(t *testing.T) {
 		{http.StatusGatewayTimeout, context.DeadlineExceeded},
 		{StatusClientClosedRequest, context.Canceled},
 		{http.StatusBadRequest, httpgrpc.Errorf(http.StatusBadRequest, "")},
+		{http.StatusUnprocessableEntity, httpgrpc.Errorf(http.StatusUnprocessableEntity, "the query is blocked")},
 	} {
 		t.Run(test.err.Error(), func(t *testing.T) {
 			w := httptest.NewRecorder()
@@ -68,7 +77,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			})
 
 			reg := prometheus.NewPedanticRegistry()
-			handler := NewHandler(tt.cfg, roundTripper, log.NewNopLogger(), reg)
+			handler := NewHandler(tt.cfg, roundTripper, nil, log.NewNopLogger(), reg)
 
 			ctx := user.InjectOrgID(context.Background(), "12345")
 			req := httptest.NewRequest("GET", "/", nil)
@@ -91,3 +100,142 @@ func TestHandler_ServeHTTP(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_ServeHTTP_ShouldTrackPerQueryStats(t *testing.T) {
+	for _, tt := range []struct {
+		name                  string
+		cacheHits             uint64
+		cacheMisses           uint64
+		expectedCacheHitRatio string
+	}{
+		{
+			name: "query not looking up the results cache",
+		},
+		{
+			name:        "query looking up the results cache",
+			cacheHits:   3,
+			cacheMisses: 1,
+			expectedCacheHitRatio: `
+				# HELP cortex_query_results_cache_hit_ratio Ratio of the results cache lookups of a query which have been hits.
+				# TYPE cortex_query_results_cache_hit_ratio histogram
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.1"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.2"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.30000000000000004"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.4"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.5"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.6"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.7"} 0
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.7999999999999999"} 1
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.8999999999999999"} 1
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="0.9999999999999999"} 1
+				cortex_query_results_cache_hit_ratio_bucket{user="12345",le="+Inf"} 1
+				cortex_query_results_cache_hit_ratio_sum{user="12345"} 0.75
+				cortex_query_results_cache_hit_ratio_count{user="12345"} 1
+			`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				stats := querier_stats.FromContext(req.Context())
+				stats.AddProcessedSamples(1000)
+				stats.AddSplitQueries(2)
+				stats.AddShardedQueries(4)
+				stats.AddResultsCacheHits(tt.cacheHits)
+				stats.AddResultsCacheMisses(tt.cacheMisses)
+				stats.AddQueueTime(time.Second)
+				stats.AddTouchedBlocks(3)
+				stats.AddFetchedIndexBytes(4096)
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("{}")),
+				}, nil
+			})
+
+			reg := prometheus.NewPedanticRegistry()
+			handler := NewHandler(HandlerConfig{QueryStatsEnabled: true}, roundTripper, nil, log.NewNopLogger(), reg)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req = req.WithContext(user.InjectOrgID(context.Background(), "12345"))
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			count, err := promtest.GatherAndCount(
+				reg,
+				"cortex_query_processed_samples",
+				"cortex_query_subqueries",
+				"cortex_query_queue_time_seconds",
+				"cortex_query_touched_blocks",
+				"cortex_query_fetched_index_bytes",
+			)
+			require.NoError(t, err)
+			assert.Equal(t, 5, count)
+
+			assert.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(tt.expectedCacheHitRatio), "cortex_query_results_cache_hit_ratio"))
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_ShouldRecordQueriesInAuditLog(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	auditLog := audit.NewLogger(audit.Config{FlushInterval: time.Hour, MaxBatchSize: 100, MaxBufferedRecords: 100}, bkt, log.NewNopLogger(), nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), auditLog))
+
+	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get("query") == "invalid" {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid query")
+		}
+
+		querier_stats.FromContext(req.Context()).AddFetchedSeries(10)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("{}")),
+		}, nil
+	})
+
+	handler := NewHandler(HandlerConfig{}, roundTripper, auditLog, log.NewNopLogger(), nil)
+
+	for _, query := range []string{"up", "invalid"} {
+		req := httptest.NewRequest("GET", "/api/v1/query_range?query="+query+"&start=0&end=3600&step=60", nil)
+		req = req.WithContext(user.InjectOrgID(context.Background(), "user-1"))
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Stopping the audit log writes the buffered records.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), auditLog))
+
+	var records []audit.Record
+	for _, content := range bkt.Objects() {
+		dec := json.NewDecoder(bytes.NewReader(content))
+		for dec.More() {
+			r := audit.Record{}
+			require.NoError(t, dec.Decode(&r))
+			records = append(records, r)
+		}
+	}
+	require.Len(t, records, 2)
+
+	for i, expected := range []struct {
+		query      string
+		statusCode int
+	}{{"up", http.StatusOK}, {"invalid", http.StatusBadRequest}} {
+		r := records[i]
+		assert.Equal(t, "user-1", r.Tenant)
+		assert.Equal(t, "test-agent", r.UserAgent)
+		assert.Contains(t, r.SourceIPs, "1.2.3.4")
+		assert.Equal(t, "/api/v1/query_range", r.Path)
+		assert.Equal(t, expected.query, r.Query)
+		assert.Equal(t, "0", r.Start)
+		assert.Equal(t, "3600", r.End)
+		assert.Equal(t, "60", r.Step)
+		assert.Equal(t, expected.statusCode, r.StatusCode)
+		require.NotNil(t, r.Stats)
+	}
+	assert.Equal(t, uint64(10), records[0].Stats.FetchedSeries)
+}
@@ -44,6 +44,9 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 type Limits interface {
 	// Returns max queriers to use per tenant, or 0 if shuffle sharding is disabled.
 	MaxQueriersPerUser(user string) int
+
+	// Returns the query priorities of the tenant.
+	QueryPriorities(user string) []validation.QueryPriority
 }
 
 // Frontend queues HTTP requests, dispatches them to backends, and handles retries
@@ -63,10 +66,11 @@ type Frontend struct {
 	subservicesWatcher *services.FailureWatcher
 
 	// Metrics.
-	queueLength       *prometheus.GaugeVec
-	discardedRequests *prometheus.CounterVec
-	numClients        prometheus.GaugeFunc
-	queueDuration     prometheus.Histogram
+	queueLength            *prometheus.GaugeVec
+	queueLengthPerPriority *prometheus.GaugeVec
+	discardedRequests      *prometheus.CounterVec
+	numClients             prometheus.GaugeFunc
+	queueDuration          prometheus.Histogram
 }
 
 type request struct {
@@ -75,10 +79,16 @@ type request struct {
 	originalCtx context.Context
 
 	request  *httpgrpc.HTTPRequest
+	priority int64
 	err      chan error
 	response chan *httpgrpc.HTTPResponse
 }
 
+// Priority implements queue.PriorityRequest.
+func (r *request) Priority() int64 {
+	return r.priority
+}
+
 // New creates a new frontend. Frontend implements service, and must be started and stopped.
 func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Registerer) (*Frontend, error) {
 	f := &Frontend{
@@ -89,6 +99,10 @@ func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Regist
 			Name: "cortex_query_frontend_queue_length",
 			Help: "Number of queries in the queue.",
 		}, []string{"user"}),
+		queueLengthPerPriority: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_query_frontend_queue_length_per_priority",
+			Help: "Number of queries in the queue, by priority.",
+		}, []string{"user", "priority"}),
 		discardedRequests: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
 			Name: "cortex_query_frontend_discarded_requests_total",
 			Help: "Total number of query requests discarded.",
@@ -100,7 +114,7 @@ func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Regist
 		}),
 	}
 
-	f.requestQueue = queue.NewRequestQueue(cfg.MaxOutstandingPerTenant, cfg.QuerierForgetDelay, f.queueLength, f.discardedRequests)
+	f.requestQueue = queue.NewRequestQueue(cfg.MaxOutstandingPerTenant, cfg.QuerierForgetDelay, f.queueLength, f.discardedRequests, f.queueLengthPerPriority, limits)
 	f.activeUsers = util.NewActiveUsersCleanupWithDefaultValues(f.cleanupInactiveUserMetrics)
 
 	var err error
@@ -147,6 +161,9 @@ func (f *Frontend) stopping(_ error) error {
 func (f *Frontend) cleanupInactiveUserMetrics(user string) {
 	f.queueLength.DeleteLabelValues(user)
 	f.discardedRequests.DeleteLabelValues(user)
+	if err := util.DeleteMatchingLabels(f.queueLengthPerPriority, map[string]string{"user": user}); err != nil {
+		level.Warn(f.log).Log("msg", "failed to remove cortex_query_frontend_queue_length_per_priority metric for user", "user", user, "err", err)
+	}
 }
 
 // RoundTripGRPC round trips a proto (instead of a HTTP request).
@@ -217,9 +234,13 @@ func (f *Frontend) Process(server frontendv1pb.Frontend_ProcessServer) error {
 
 		req := reqWrapper.(*request)
 
-		f.queueDuration.Observe(time.Since(req.enqueueTime).Seconds())
+		queueTime := time.Since(req.enqueueTime)
+		f.queueDuration.Observe(queueTime.Seconds())
 		req.queueSpan.Finish()
 
+		// Safe if stats is nil.
+		stats.FromContext(req.originalCtx).AddQueueTime(queueTime)
+
 		/*
 		  We want to dequeue the next unexpired request from the chosen tenant queue.
 		  The chance of choosing a particular tenant for dequeueing is (1/active_tenants).
@@ -331,6 +352,8 @@ func (f *Frontend) queueRequest(ctx context.Context, req *request) error {
 	joinedTenantID := tenant.JoinTenantIDs(tenantIDs)
 	f.activeUsers.UpdateUserTimestamp(joinedTenantID, now)
 
+	req.priority = queue.GetPriority(req.request, f.limits.QueryPriorities(joinedTenantID), now)
+
 	err = f.requestQueue.EnqueueRequest(joinedTenantID, req, maxQueriers, nil)
 	if err == queue.ErrTooManyRequests {
 		return errTooManyRequest
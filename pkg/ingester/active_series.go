@@ -102,6 +102,16 @@ func (c *ActiveSeries) Active() int {
 	return total
 }
 
+// ActiveByLabelValue returns the number of active series by value of the given label.
+// Series without the label are counted under the empty value.
+func (c *ActiveSeries) ActiveByLabelValue(name string) map[string]int {
+	counts := map[string]int{}
+	for s := 0; s < numActiveSeriesStripes; s++ {
+		c.stripes[s].countByLabelValue(name, counts)
+	}
+	return counts
+}
+
 func (s *activeSeriesStripe) updateSeriesTimestamp(now time.Time, series labels.Labels, fingerprint uint64, labelsCopy func(labels.Labels) labels.Labels) {
 	nowNanos := now.UnixNano()
 
@@ -242,3 +252,14 @@ func (s *activeSeriesStripe) getActive() int {
 
 	return s.active
 }
+
+func (s *activeSeriesStripe) countByLabelValue(name string, counts map[string]int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entries := range s.refs {
+		for _, entry := range entries {
+			counts[entry.lbs.Get(name)]++
+		}
+	}
+}
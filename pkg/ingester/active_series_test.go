@@ -35,6 +35,17 @@ func TestActiveSeries_UpdateSeries(t *testing.T) {
 	assert.Equal(t, 2, c.Active())
 }
 
+func TestActiveSeries_ActiveByLabelValue(t *testing.T) {
+	c := NewActiveSeries()
+	c.UpdateSeries(labels.FromStrings("a", "1", "team", "x"), time.Now(), copyFn)
+	c.UpdateSeries(labels.FromStrings("a", "2", "team", "x"), time.Now(), copyFn)
+	c.UpdateSeries(labels.FromStrings("a", "3", "team", "y"), time.Now(), copyFn)
+	c.UpdateSeries(labels.FromStrings("a", "4"), time.Now(), copyFn)
+
+	assert.Equal(t, map[string]int{"x": 2, "y": 1, "": 1}, c.ActiveByLabelValue("team"))
+	assert.Equal(t, map[string]int{"": 4}, c.ActiveByLabelValue("missing"))
+}
+
 func TestActiveSeries_ShouldCorrectlyHandleFingerprintCollisions(t *testing.T) {
 	metric := labels.NewBuilder(labels.FromStrings("__name__", "logs"))
 	ls1 := metric.Set("_", "ypfajYg2lsv").Labels()
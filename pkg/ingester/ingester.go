@@ -204,6 +204,9 @@ type Ingester struct {
 	// Prometheus block storage
 	TSDBState TSDBState
 
+	// Tracks the values of the per-tenant cost attribution label. Only used by V2-ingester.
+	costAttribution *util.CostAttribution
+
 	// Rate of pushed samples. Only used by V2-ingester to limit global samples push rate.
 	ingestionRate        *util_math.EwmaRate
 	inflightPushRequests atomic.Int64
@@ -26,6 +26,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/shipper"
@@ -36,7 +37,9 @@ import (
 	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/cortexpb"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
 	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/tenant"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -114,6 +117,10 @@ type userTSDB struct {
 	seriesInMetric *metricCounter
 	limiter        *Limiter
 
+	// Cost attribution values for which the active series metric has been exported.
+	// Only accessed by the active series update loop.
+	activeSeriesAttributions map[string]struct{}
+
 	instanceSeriesCount *atomic.Int64 // Shared across all userTSDB instances created by ingester.
 	instanceLimitsFn    func() *InstanceLimits
 
@@ -127,6 +134,13 @@ type userTSDB struct {
 	// Thanos shipper used to ship blocks to the storage.
 	shipper Shipper
 
+	// Samples accepted within the tenant's out-of-order time window.
+	outOfOrderHead *outOfOrderHead
+
+	// Whether the TSDB has been opened allowing the overlapping blocks compacted
+	// from the out-of-order head.
+	outOfOrderEnabled bool
+
 	// When deletion marker is found for the tenant (checked before shipping),
 	// shipping stops and TSDB is closed before reaching idle timeout time (if enabled).
 	deletionMarkFound atomic.Bool
@@ -150,11 +164,21 @@ func (u *userTSDB) Appender(ctx context.Context) storage.Appender {
 }
 
 func (u *userTSDB) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
-	return u.db.Querier(ctx, mint, maxt)
+	q, err := u.db.Querier(ctx, mint, maxt)
+	if err != nil || u.outOfOrderHead.Empty() {
+		return q, err
+	}
+
+	return storage.NewMergeQuerier([]storage.Querier{q, u.outOfOrderHead.Querier(mint, maxt)}, nil, storage.ChainedSeriesMerge), nil
 }
 
 func (u *userTSDB) ChunkQuerier(ctx context.Context, mint, maxt int64) (storage.ChunkQuerier, error) {
-	return u.db.ChunkQuerier(ctx, mint, maxt)
+	q, err := u.db.ChunkQuerier(ctx, mint, maxt)
+	if err != nil || u.outOfOrderHead.Empty() {
+		return q, err
+	}
+
+	return storage.NewMergeChunkQuerier([]storage.ChunkQuerier{q, u.outOfOrderHead.ChunkQuerier(mint, maxt)}, nil, storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge)), nil
 }
 
 func (u *userTSDB) ExemplarQuerier(ctx context.Context) (storage.ExemplarQuerier, error) {
@@ -170,7 +194,7 @@ func (u *userTSDB) Blocks() []*tsdb.Block {
 }
 
 func (u *userTSDB) Close() error {
-	return u.db.Close()
+	return tsdb_errors.NewMulti(u.outOfOrderHead.Close(), u.db.Close()).Err()
 }
 
 func (u *userTSDB) Compact() error {
@@ -224,6 +248,18 @@ func (u *userTSDB) compactHead(blockDuration int64) error {
 	return u.db.CompactHead(tsdb.NewRangeHead(h, minTime, maxTime))
 }
 
+// compactOutOfOrderHead compacts the out-of-order head into blocks written to the TSDB directory.
+// Unless forced, samples are compacted once the first of them has been appended blockDuration ago.
+func (u *userTSDB) compactOutOfOrderHead(logger log.Logger, blockDuration int64, force bool) error {
+	return u.outOfOrderHead.Compact(logger, u.db.Dir(), blockDuration, force, func() map[ulid.ULID]struct{} {
+		loaded := map[ulid.ULID]struct{}{}
+		for _, b := range u.db.Blocks() {
+			loaded[b.Meta().ULID] = struct{}{}
+		}
+		return loaded
+	})
+}
+
 // PreCreation implements SeriesLifecycleCallback interface.
 func (u *userTSDB) PreCreation(metric labels.Labels) error {
 	if u.limiter == nil {
@@ -238,8 +274,8 @@ func (u *userTSDB) PreCreation(metric labels.Labels) error {
 		}
 	}
 
-	// Total series limit.
-	if err := u.limiter.AssertMaxSeriesPerUser(u.userID, int(u.Head().NumSeries())); err != nil {
+	// Total series limit, including the series only in the out-of-order head.
+	if err := u.limiter.AssertMaxSeriesPerUser(u.userID, int(u.Head().NumSeries())+u.outOfOrderHead.NumAccountedSeries()); err != nil {
 		return err
 	}
 
@@ -375,7 +411,7 @@ func (u *userTSDB) shouldCloseTSDB(idleTimeout time.Duration) tsdbCloseCheckResu
 	}
 
 	// If head is not compacted, we cannot close this yet.
-	if u.Head().NumSeries() > 0 {
+	if u.Head().NumSeries() > 0 || !u.outOfOrderHead.Empty() {
 		return tsdbNotCompacted
 	}
 
@@ -493,6 +529,7 @@ func NewV2(cfg Config, clientConfig client.Config, limits *validation.Overrides,
 		logger:        logger,
 		ingestionRate: util_math.NewEWMARate(0.2, instanceIngestionRateTickInterval),
 	}
+	i.costAttribution = util.NewCostAttribution(limits)
 	i.metrics = newIngesterMetrics(registerer, false, cfg.ActiveSeriesMetricsEnabled, i.getInstanceLimits, i.ingestionRate, &i.inflightPushRequests)
 
 	// Replace specific metrics which we can't directly track but we need to read
@@ -705,6 +742,36 @@ func (i *Ingester) v2UpdateActiveSeries() {
 
 		userDB.activeSeries.Purge(purgeTime)
 		i.metrics.activeSeriesPerUser.WithLabelValues(userID).Set(float64(userDB.activeSeries.Active()))
+		i.updateActiveSeriesPerAttribution(userDB)
+	}
+
+	for userID, attributions := range i.costAttribution.PurgeInactive(purgeTime) {
+		i.metrics.deletePerAttributionMetrics(userID, attributions...)
+	}
+}
+
+// updateActiveSeriesPerAttribution updates the tenant's active series metric broken down
+// by the values of the tenant's cost attribution label.
+func (i *Ingester) updateActiveSeriesPerAttribution(userDB *userTSDB) {
+	now := time.Now()
+	counts := map[string]int{}
+
+	if label := i.costAttribution.Label(userDB.userID); label != "" {
+		for value, count := range userDB.activeSeries.ActiveByLabelValue(label) {
+			counts[i.costAttribution.Attribute(userDB.userID, value, now)] += count
+		}
+	}
+
+	for attribution := range userDB.activeSeriesAttributions {
+		if _, ok := counts[attribution]; !ok {
+			i.metrics.activeSeriesPerAttribution.DeleteLabelValues(userDB.userID, attribution)
+		}
+	}
+
+	userDB.activeSeriesAttributions = make(map[string]struct{}, len(counts))
+	for attribution, count := range counts {
+		i.metrics.activeSeriesPerAttribution.WithLabelValues(userDB.userID, attribution).Set(float64(count))
+		userDB.activeSeriesAttributions[attribution] = struct{}{}
 	}
 }
 
@@ -769,6 +836,11 @@ func (i *Ingester) v2Push(ctx context.Context, req *cortexpb.WriteRequest) (*cor
 		newValueForTimestampCount = 0
 		perUserSeriesLimitCount   = 0
 		perMetricSeriesLimitCount = 0
+		outOfOrderSamplesCount    = 0
+
+		// Number of samples ingested by value of the tenant's cost attribution label.
+		attributionLabel         = i.costAttribution.Label(userID)
+		succeededSamplesPerValue map[string]int
 
 		updateFirstPartial = func(errFn func() error) {
 			if firstPartialErr == nil {
@@ -777,8 +849,20 @@ func (i *Ingester) v2Push(ctx context.Context, req *cortexpb.WriteRequest) (*cor
 		}
 	)
 
-	// Walk the samples, appending them to the users database
+	// Samples rejected by the TSDB head because too old are appended to the out-of-order head
+	// if within the tenant's out-of-order time window from the most recent sample in the head.
+	// The window is honored only if the TSDB has been opened allowing overlapping blocks.
+	outOfOrderMinValidTime := int64(math.MaxInt64)
+	if window := i.limits.OutOfOrderTimeWindow(userID); window > 0 && db.outOfOrderEnabled {
+		if headMaxTime := db.Head().MaxTime(); headMaxTime != math.MinInt64 {
+			outOfOrderMinValidTime = headMaxTime - window.Milliseconds()
+		}
+	}
+
+	// Walk the samples, appending them to the users database. The out-of-order samples are
+	// committed only once the in-order ones have been successfully committed.
 	app := db.Appender(ctx).(extendedAppender)
+	oooApp := db.outOfOrderHead.Appender()
 	for _, ts := range req.Timeseries {
 		// The labels must be sorted (in our case, it's guaranteed a write request
 		// has sorted labels once hit the ingester).
@@ -810,6 +894,22 @@ func (i *Ingester) v2Push(ctx context.Context, req *cortexpb.WriteRequest) (*cor
 				}
 			}
 
+			if cause := errors.Cause(err); (cause == storage.ErrOutOfBounds || cause == storage.ErrOutOfOrderSample) && s.TimestampMs >= outOfOrderMinValidTime {
+				// The out-of-order head retains the labels, so we pass the copied ones (or the TSDB head
+				// ones, if the series exists). Series not in the TSDB head yet are subject to the series
+				// limits when created in the out-of-order head.
+				headRef := ref
+				if headRef == 0 {
+					headRef, _ = app.GetRef(copiedLabels)
+				}
+
+				if err = oooApp.Append(copiedLabels, headRef != 0, s.TimestampMs, s.Value); err == nil {
+					succeededSamplesCount++
+					outOfOrderSamplesCount++
+					continue
+				}
+			}
+
 			failedSamplesCount++
 
 			// Check if the error is a soft error we can proceed on. If so, we keep track
@@ -849,10 +949,18 @@ func (i *Ingester) v2Push(ctx context.Context, req *cortexpb.WriteRequest) (*cor
 			if rollbackErr := app.Rollback(); rollbackErr != nil {
 				level.Warn(i.logger).Log("msg", "failed to rollback on error", "user", userID, "err", rollbackErr)
 			}
+			oooApp.Rollback()
 
 			return nil, wrapWithUser(err, userID)
 		}
 
+		if attributionLabel != "" && succeededSamplesCount > oldSucceededSamplesCount {
+			if succeededSamplesPerValue == nil {
+				succeededSamplesPerValue = map[string]int{}
+			}
+			succeededSamplesPerValue[cortexpb.FromLabelAdaptersToLabels(ts.Labels).Get(attributionLabel)] += succeededSamplesCount - oldSucceededSamplesCount
+		}
+
 		if i.cfg.ActiveSeriesMetricsEnabled && succeededSamplesCount > oldSucceededSamplesCount {
 			db.activeSeries.UpdateSeries(cortexpb.FromLabelAdaptersToLabels(ts.Labels), startAppend, func(l labels.Labels) labels.Labels {
 				// we must already have copied the labels if succeededSamplesCount has been incremented.
@@ -898,6 +1006,13 @@ func (i *Ingester) v2Push(ctx context.Context, req *cortexpb.WriteRequest) (*cor
 
 	startCommit := time.Now()
 	if err := app.Commit(); err != nil {
+		oooApp.Rollback()
+		return nil, wrapWithUser(err, userID)
+	}
+
+	// If the out-of-order samples fail to be committed, the in-order ones are kept: the client
+	// retries the request and the in-order samples already ingested are deduplicated on read.
+	if err := oooApp.Commit(); err != nil {
 		return nil, wrapWithUser(err, userID)
 	}
 	i.TSDBState.appenderCommitDuration.Observe(time.Since(startCommit).Seconds())
@@ -915,6 +1030,15 @@ func (i *Ingester) v2Push(ctx context.Context, req *cortexpb.WriteRequest) (*cor
 	i.metrics.ingestedExemplars.Add(float64(succeededExemplarsCount))
 	i.metrics.ingestedExemplarsFail.Add(float64(failedExemplarsCount))
 
+	if outOfOrderSamplesCount > 0 {
+		i.metrics.ingestedOutOfOrder.WithLabelValues(userID).Add(float64(outOfOrderSamplesCount))
+	}
+
+	for value, count := range succeededSamplesPerValue {
+		attribution := i.costAttribution.Attribute(userID, value, startAppend)
+		i.metrics.ingestedAttributed.WithLabelValues(userID, attribution).Add(float64(count))
+	}
+
 	if sampleOutOfBoundsCount > 0 {
 		validation.DiscardedSamples.WithLabelValues(sampleOutOfBounds, userID).Add(float64(sampleOutOfBoundsCount))
 	}
@@ -994,6 +1118,12 @@ func (i *Ingester) v2Query(ctx context.Context, req *client.QueryRequest) (*clie
 		return nil, err
 	}
 
+	// The shard label is not stored in TSDB, so we filter series by shard after selecting them.
+	shard, matchers, err := sharding.RemoveShardFromMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+
 	i.metrics.queries.Inc()
 
 	db := i.getTSDB(userID)
@@ -1008,7 +1138,7 @@ func (i *Ingester) v2Query(ctx context.Context, req *client.QueryRequest) (*clie
 	defer q.Close()
 
 	// It's not required to return sorted series because series are sorted by the Cortex querier.
-	ss := q.Select(false, nil, matchers...)
+	ss := sharding.NewSeriesSet(q.Select(false, nil, matchers...), shard)
 	if ss.Err() != nil {
 		return nil, ss.Err()
 	}
@@ -1310,6 +1440,12 @@ func (i *Ingester) v2QueryStream(req *client.QueryRequest, stream client.Ingeste
 		return err
 	}
 
+	// The shard label is not stored in TSDB, so we filter series by shard after selecting them.
+	shard, matchers, err := sharding.RemoveShardFromMatchers(matchers)
+	if err != nil {
+		return err
+	}
+
 	i.metrics.queries.Inc()
 
 	db := i.getTSDB(userID)
@@ -1339,10 +1475,10 @@ func (i *Ingester) v2QueryStream(req *client.QueryRequest, stream client.Ingeste
 
 	if streamType == QueryStreamChunks {
 		level.Debug(spanlog).Log("msg", "using v2QueryStreamChunks")
-		numSeries, numSamples, err = i.v2QueryStreamChunks(ctx, db, int64(from), int64(through), matchers, stream)
+		numSeries, numSamples, err = i.v2QueryStreamChunks(ctx, db, int64(from), int64(through), matchers, shard, stream)
 	} else {
 		level.Debug(spanlog).Log("msg", "using v2QueryStreamSamples")
-		numSeries, numSamples, err = i.v2QueryStreamSamples(ctx, db, int64(from), int64(through), matchers, stream)
+		numSeries, numSamples, err = i.v2QueryStreamSamples(ctx, db, int64(from), int64(through), matchers, shard, stream)
 	}
 	if err != nil {
 		return err
@@ -1354,7 +1490,7 @@ func (i *Ingester) v2QueryStream(req *client.QueryRequest, stream client.Ingeste
 	return nil
 }
 
-func (i *Ingester) v2QueryStreamSamples(ctx context.Context, db *userTSDB, from, through int64, matchers []*labels.Matcher, stream client.Ingester_QueryStreamServer) (numSeries, numSamples int, _ error) {
+func (i *Ingester) v2QueryStreamSamples(ctx context.Context, db *userTSDB, from, through int64, matchers []*labels.Matcher, shard *astmapper.ShardAnnotation, stream client.Ingester_QueryStreamServer) (numSeries, numSamples int, _ error) {
 	q, err := db.Querier(ctx, from, through)
 	if err != nil {
 		return 0, 0, err
@@ -1362,7 +1498,7 @@ func (i *Ingester) v2QueryStreamSamples(ctx context.Context, db *userTSDB, from,
 	defer q.Close()
 
 	// It's not required to return sorted series because series are sorted by the Cortex querier.
-	ss := q.Select(false, nil, matchers...)
+	ss := sharding.NewSeriesSet(q.Select(false, nil, matchers...), shard)
 	if ss.Err() != nil {
 		return 0, 0, ss.Err()
 	}
@@ -1423,7 +1559,7 @@ func (i *Ingester) v2QueryStreamSamples(ctx context.Context, db *userTSDB, from,
 }
 
 // v2QueryStream streams metrics from a TSDB. This implements the client.IngesterServer interface
-func (i *Ingester) v2QueryStreamChunks(ctx context.Context, db *userTSDB, from, through int64, matchers []*labels.Matcher, stream client.Ingester_QueryStreamServer) (numSeries, numSamples int, _ error) {
+func (i *Ingester) v2QueryStreamChunks(ctx context.Context, db *userTSDB, from, through int64, matchers []*labels.Matcher, shard *astmapper.ShardAnnotation, stream client.Ingester_QueryStreamServer) (numSeries, numSamples int, _ error) {
 	q, err := db.ChunkQuerier(ctx, from, through)
 	if err != nil {
 		return 0, 0, err
@@ -1431,7 +1567,7 @@ func (i *Ingester) v2QueryStreamChunks(ctx context.Context, db *userTSDB, from,
 	defer q.Close()
 
 	// It's not required to return sorted series because series are sorted by the Cortex querier.
-	ss := q.Select(false, nil, matchers...)
+	ss := sharding.NewChunkSeriesSet(q.Select(false, nil, matchers...), shard)
 	if ss.Err() != nil {
 		return 0, 0, ss.Err()
 	}
@@ -1598,6 +1734,20 @@ func (i *Ingester) createTSDB(userID string) (*userTSDB, error) {
 		instanceSeriesCount: &i.TSDBState.seriesCount,
 	}
 
+	// The out-of-order head series are subject to the same limits of the TSDB head ones.
+	userDB.outOfOrderHead = newOutOfOrderHead(userDB)
+
+	// Blocks compacted from the out-of-order head overlap with the others, so overlapping blocks
+	// are allowed only if the tenant's out-of-order time window is enabled, or has been enabled
+	// in the past (and so the out-of-order WAL exists), because the TSDB may still have such blocks.
+	outOfOrderWALDir := filepath.Join(udir, outOfOrderWALDirName)
+	userDB.outOfOrderEnabled = i.limits.OutOfOrderTimeWindow(userID) > 0
+	if !userDB.outOfOrderEnabled {
+		if _, err := os.Stat(outOfOrderWALDir); err == nil {
+			userDB.outOfOrderEnabled = true
+		}
+	}
+
 	enableExemplars := false
 	if i.cfg.BlocksStorageConfig.TSDB.MaxExemplars > 0 {
 		enableExemplars = true
@@ -1616,6 +1766,7 @@ func (i *Ingester) createTSDB(userID string) (*userTSDB, error) {
 		BlocksToDelete:            userDB.blocksToDelete,
 		EnableExemplarStorage:     enableExemplars,
 		MaxExemplars:              int64(i.cfg.BlocksStorageConfig.TSDB.MaxExemplars),
+		AllowOverlappingBlocks:    userDB.outOfOrderEnabled,
 	}, nil)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to open TSDB: %s", udir)
@@ -1632,6 +1783,22 @@ func (i *Ingester) createTSDB(userID string) (*userTSDB, error) {
 	}
 
 	userDB.db = db
+
+	if userDB.outOfOrderEnabled {
+		// Replayed out-of-order series are accounted only if not in the TSDB head.
+		app := db.Appender(context.Background()).(extendedAppender)
+		err = userDB.outOfOrderHead.OpenWAL(userLogger, outOfOrderWALDir, func(lbls labels.Labels) bool {
+			ref, _ := app.GetRef(lbls)
+			return ref != 0
+		})
+		if rollbackErr := app.Rollback(); rollbackErr != nil {
+			level.Warn(userLogger).Log("msg", "failed to rollback appender", "err", rollbackErr)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open out-of-order WAL: %s", outOfOrderWALDir)
+		}
+	}
+
 	// We set the limiter here because we don't want to limit
 	// series during WAL replay.
 	userDB.limiter = i.limiter
@@ -1991,6 +2158,14 @@ func (i *Ingester) compactBlocks(ctx context.Context, force bool, allowed *util.
 			return nil
 		}
 
+		blockDuration := i.cfg.BlocksStorageConfig.TSDB.BlockRanges[0].Milliseconds()
+		idle := i.TSDBState.compactionIdleTimeout > 0 && userDB.isIdle(time.Now(), i.TSDBState.compactionIdleTimeout)
+
+		// The out-of-order head is compacted independently of the TSDB head, which may be empty.
+		if err := userDB.compactOutOfOrderHead(logutil.WithUserID(userID, i.logger), blockDuration, force || idle); err != nil {
+			level.Warn(i.logger).Log("msg", "TSDB out-of-order head compaction for user has failed", "user", userID, "err", err)
+		}
+
 		// Don't do anything, if there is nothing to compact.
 		h := userDB.Head()
 		if h.NumSeries() == 0 {
@@ -2005,12 +2180,12 @@ func (i *Ingester) compactBlocks(ctx context.Context, force bool, allowed *util.
 		switch {
 		case force:
 			reason = "forced"
-			err = userDB.compactHead(i.cfg.BlocksStorageConfig.TSDB.BlockRanges[0].Milliseconds())
+			err = userDB.compactHead(blockDuration)
 
-		case i.TSDBState.compactionIdleTimeout > 0 && userDB.isIdle(time.Now(), i.TSDBState.compactionIdleTimeout):
+		case idle:
 			reason = "idle"
 			level.Info(i.logger).Log("msg", "TSDB is idle, forcing compaction", "user", userID)
-			err = userDB.compactHead(i.cfg.BlocksStorageConfig.TSDB.BlockRanges[0].Milliseconds())
+			err = userDB.compactHead(blockDuration)
 
 		default:
 			reason = "regular"
@@ -46,6 +46,8 @@ import (
 	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/cortexpb"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/util"
 	util_math "github.com/cortexproject/cortex/pkg/util/math"
@@ -744,6 +746,86 @@ func TestIngester_v2Push_DecreaseInactiveSeries(t *testing.T) {
 	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expectedMetrics), metricNames...))
 }
 
+func TestIngester_v2Push_CostAttribution(t *testing.T) {
+	metricNames := []string{
+		"cortex_ingester_attributed_active_series",
+		"cortex_ingester_attributed_ingested_samples_total",
+	}
+
+	registry := prometheus.NewRegistry()
+
+	cfg := defaultIngesterTestConfig(t)
+	cfg.ActiveSeriesMetricsIdleTimeout = 100 * time.Millisecond
+	cfg.LifecyclerConfig.JoinAfter = 0
+
+	limits := defaultLimitsTestConfig()
+	limits.CostAttributionLabel = "team"
+	limits.MaxCostAttributionPerUser = 3
+
+	i, err := prepareIngesterWithBlocksStorageAndLimits(t, cfg, limits, "", registry)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), i))
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	// Wait until the ingester is ACTIVE
+	test.Poll(t, 100*time.Millisecond, ring.ACTIVE, func() interface{} {
+		return i.lifecycler.GetState()
+	})
+
+	// Values are pushed in different requests to deterministically hit the limit with the last one.
+	reqs := []*cortexpb.WriteRequest{
+		cortexpb.ToWriteRequest(
+			[]labels.Labels{labels.FromStrings(labels.MetricName, "test", "team", "a"), labels.FromStrings(labels.MetricName, "test", "team", "b")},
+			[]cortexpb.Sample{{Value: 1, TimestampMs: 9}, {Value: 1, TimestampMs: 9}},
+			nil,
+			cortexpb.API),
+		cortexpb.ToWriteRequest(
+			[]labels.Labels{labels.FromStrings(labels.MetricName, "test", "team", "a")},
+			[]cortexpb.Sample{{Value: 2, TimestampMs: 10}},
+			nil,
+			cortexpb.API),
+		cortexpb.ToWriteRequest(
+			[]labels.Labels{labels.FromStrings(labels.MetricName, "test")},
+			[]cortexpb.Sample{{Value: 1, TimestampMs: 9}},
+			nil,
+			cortexpb.API),
+		cortexpb.ToWriteRequest(
+			[]labels.Labels{labels.FromStrings(labels.MetricName, "test", "team", "c"), labels.FromStrings(labels.MetricName, "test", "team", "d")},
+			[]cortexpb.Sample{{Value: 1, TimestampMs: 9}, {Value: 1, TimestampMs: 9}},
+			nil,
+			cortexpb.API),
+	}
+
+	ctx := user.InjectOrgID(context.Background(), userID)
+	for _, req := range reqs {
+		_, err := i.v2Push(ctx, req)
+		require.NoError(t, err)
+	}
+
+	i.v2UpdateActiveSeries()
+
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP cortex_ingester_attributed_active_series Number of currently active series per user and cost attribution label value.
+		# TYPE cortex_ingester_attributed_active_series gauge
+		cortex_ingester_attributed_active_series{attribution="__missing__",user="1"} 1
+		cortex_ingester_attributed_active_series{attribution="__overflow__",user="1"} 2
+		cortex_ingester_attributed_active_series{attribution="a",user="1"} 1
+		cortex_ingester_attributed_active_series{attribution="b",user="1"} 1
+		# HELP cortex_ingester_attributed_ingested_samples_total The total number of samples ingested per user and cost attribution label value.
+		# TYPE cortex_ingester_attributed_ingested_samples_total counter
+		cortex_ingester_attributed_ingested_samples_total{attribution="__missing__",user="1"} 1
+		cortex_ingester_attributed_ingested_samples_total{attribution="__overflow__",user="1"} 2
+		cortex_ingester_attributed_ingested_samples_total{attribution="a",user="1"} 2
+		cortex_ingester_attributed_ingested_samples_total{attribution="b",user="1"} 1
+	`), metricNames...))
+
+	// Wait a bit to make series and cost attribution values inactive (set to 100ms above).
+	time.Sleep(200 * time.Millisecond)
+	i.v2UpdateActiveSeries()
+
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(""), metricNames...))
+}
+
 func BenchmarkIngesterV2Push(b *testing.B) {
 	limits := defaultLimitsTestConfig()
 	benchmarkIngesterV2Push(b, limits, false)
@@ -1308,6 +1390,62 @@ func Test_Ingester_v2Query(t *testing.T) {
 		})
 	}
 }
+
+func TestIngester_v2Query_ShouldFilterSeriesByShard(t *testing.T) {
+	const (
+		numSeries = 100
+		numShards = 4
+	)
+
+	i, err := prepareIngesterWithBlocksStorage(t, defaultIngesterTestConfig(t), nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), i))
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	// Wait until it's ACTIVE
+	test.Poll(t, 1*time.Second, ring.ACTIVE, func() interface{} {
+		return i.lifecycler.GetState()
+	})
+
+	// Push series
+	ctx := user.InjectOrgID(context.Background(), "test")
+
+	for s := 0; s < numSeries; s++ {
+		lbls := labels.Labels{{Name: labels.MetricName, Value: "test"}, {Name: "series", Value: strconv.Itoa(s)}}
+		req, _, _, _ := mockWriteRequest(t, lbls, float64(s), 100000)
+		_, err := i.v2Push(ctx, req)
+		require.NoError(t, err)
+	}
+
+	// Query each shard and ensure each series is returned exactly once.
+	seen := map[string]int{}
+
+	for shardIdx := 0; shardIdx < numShards; shardIdx++ {
+		shard := astmapper.ShardAnnotation{Shard: shardIdx, Of: numShards}
+
+		res, err := i.v2Query(ctx, &client.QueryRequest{
+			StartTimestampMs: math.MinInt64,
+			EndTimestampMs:   math.MaxInt64,
+			Matchers: []*client.LabelMatcher{
+				{Type: client.EQUAL, Name: model.MetricNameLabel, Value: "test"},
+				{Type: client.EQUAL, Name: astmapper.ShardLabel, Value: shard.String()},
+			},
+		})
+		require.NoError(t, err)
+
+		for _, series := range res.Timeseries {
+			lbls := cortexpb.FromLabelAdaptersToLabels(series.Labels)
+			assert.True(t, sharding.IsSeriesInShard(shard, lbls))
+			seen[lbls.String()]++
+		}
+	}
+
+	require.Len(t, seen, numSeries)
+	for _, count := range seen {
+		assert.Equal(t, 1, count)
+	}
+}
+
 func TestIngester_v2Query_ShouldNotCreateTSDBIfDoesNotExists(t *testing.T) {
 	i, err := prepareIngesterWithBlocksStorage(t, defaultIngesterTestConfig(t), nil)
 	require.NoError(t, err)
@@ -1394,6 +1532,223 @@ func TestIngester_v2Push_ShouldNotCreateTSDBIfNotInActiveState(t *testing.T) {
 	assert.False(t, tsdbCreated)
 }
 
+func TestIngester_v2Push_OutOfOrderTimeWindow(t *testing.T) {
+	const userID = "test"
+
+	var (
+		ctx          = user.InjectOrgID(context.Background(), userID)
+		metricLabels = labels.Labels{{Name: labels.MetricName, Value: "test"}}
+		baseTime     = (10 * time.Hour).Milliseconds()
+	)
+
+	cfg := defaultIngesterTestConfig(t)
+	cfg.LifecyclerConfig.JoinAfter = 0
+
+	limits := defaultLimitsTestConfig()
+	limits.OutOfOrderTimeWindow = model.Duration(time.Hour)
+
+	registry := prometheus.NewRegistry()
+	i, err := prepareIngesterWithBlocksStorageAndLimits(t, cfg, limits, "", registry)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), i))
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	// Wait until it's ACTIVE.
+	test.Poll(t, 1*time.Second, ring.ACTIVE, func() interface{} {
+		return i.lifecycler.GetState()
+	})
+
+	push := func(timestampMs int64, value float64) error {
+		req, _, _, _ := mockWriteRequest(t, metricLabels, value, timestampMs)
+		_, err := i.v2Push(ctx, req)
+		return err
+	}
+
+	require.NoError(t, push(baseTime, 1))
+
+	// Out-of-order sample within the window.
+	require.NoError(t, push(baseTime-(30*time.Minute).Milliseconds(), 2))
+
+	// Same out-of-order sample pushed twice.
+	require.NoError(t, push(baseTime-(30*time.Minute).Milliseconds(), 2))
+
+	// Different value for an out-of-order sample timestamp.
+	err = push(baseTime-(30*time.Minute).Milliseconds(), 3)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), storage.ErrDuplicateSampleForTimestamp.Error())
+
+	// Out-of-order sample outside the window.
+	err = push(baseTime-(2*time.Hour).Milliseconds(), 4)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), storage.ErrOutOfBounds.Error())
+
+	expected := model.Matrix{{
+		Metric: util.LabelsToMetric(metricLabels),
+		Values: []model.SamplePair{
+			{Timestamp: model.Time(baseTime - (30 * time.Minute).Milliseconds()), Value: 2},
+			{Timestamp: model.Time(baseTime), Value: 1},
+		},
+	}}
+
+	query := func() model.Matrix {
+		res, err := i.v2Query(ctx, &client.QueryRequest{
+			StartTimestampMs: 0,
+			EndTimestampMs:   baseTime,
+			Matchers:         []*client.LabelMatcher{{Type: client.EQUAL, Name: labels.MetricName, Value: "test"}},
+		})
+		require.NoError(t, err)
+		return client.FromQueryResponse(res)
+	}
+
+	assert.Equal(t, expected, query())
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP cortex_ingester_ingested_out_of_order_samples_total The total number of out-of-order samples ingested per user, within the out-of-order time window.
+		# TYPE cortex_ingester_ingested_out_of_order_samples_total counter
+		cortex_ingester_ingested_out_of_order_samples_total{user="test"} 2
+	`), "cortex_ingester_ingested_out_of_order_samples_total"))
+
+	// Force compaction, which writes a block for the out-of-order samples too.
+	i.compactBlocks(context.Background(), true, nil)
+
+	db := i.getTSDB(userID)
+	require.Len(t, db.Blocks(), 2)
+	assert.Equal(t, baseTime-(30*time.Minute).Milliseconds(), db.Blocks()[0].MinTime())
+	assert.False(t, db.outOfOrderHead.Empty())
+	assert.Equal(t, expected, query())
+
+	// Once the block has been loaded, out-of-order samples are removed from memory.
+	i.compactBlocks(context.Background(), false, nil)
+	assert.True(t, db.outOfOrderHead.Empty())
+	assert.Equal(t, expected, query())
+}
+
+func TestIngester_v2Push_OutOfOrderSamplesShouldHonorSeriesLimitAndBeReplayedOnRestart(t *testing.T) {
+	const userID = "test"
+
+	var (
+		ctx      = user.InjectOrgID(context.Background(), userID)
+		series1  = labels.Labels{{Name: labels.MetricName, Value: "test"}, {Name: "series", Value: "1"}}
+		series2  = labels.Labels{{Name: labels.MetricName, Value: "test"}, {Name: "series", Value: "2"}}
+		baseTime = (10 * time.Hour).Milliseconds()
+	)
+
+	cfg := defaultIngesterTestConfig(t)
+	cfg.LifecyclerConfig.JoinAfter = 0
+
+	limits := defaultLimitsTestConfig()
+	limits.OutOfOrderTimeWindow = model.Duration(2 * time.Hour)
+	limits.MaxLocalSeriesPerUser = 1
+
+	dataDir := t.TempDir()
+
+	start := func() *Ingester {
+		i, err := prepareIngesterWithBlocksStorageAndLimits(t, cfg, limits, dataDir, nil)
+		require.NoError(t, err)
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), i))
+
+		// Wait until it's ACTIVE.
+		test.Poll(t, 1*time.Second, ring.ACTIVE, func() interface{} {
+			return i.lifecycler.GetState()
+		})
+		return i
+	}
+
+	push := func(i *Ingester, lbls labels.Labels, timestampMs int64) error {
+		req, _, _, _ := mockWriteRequest(t, lbls, 1, timestampMs)
+		_, err := i.v2Push(ctx, req)
+		return err
+	}
+
+	query := func(i *Ingester) model.Matrix {
+		res, err := i.v2Query(ctx, &client.QueryRequest{
+			StartTimestampMs: 0,
+			EndTimestampMs:   baseTime,
+			Matchers:         []*client.LabelMatcher{{Type: client.EQUAL, Name: labels.MetricName, Value: "test"}},
+		})
+		require.NoError(t, err)
+		return client.FromQueryResponse(res)
+	}
+
+	expected := model.Matrix{{
+		Metric: util.LabelsToMetric(series1),
+		Values: []model.SamplePair{
+			{Timestamp: model.Time(baseTime - (90 * time.Minute).Milliseconds()), Value: 1},
+			{Timestamp: model.Time(baseTime), Value: 1},
+		},
+	}}
+
+	i := start()
+	require.NoError(t, push(i, series1, baseTime))
+	require.NoError(t, push(i, series1, baseTime-(90*time.Minute).Milliseconds()))
+
+	// A series with only out-of-order samples counts towards the series limit.
+	err := push(i, series2, baseTime-(90*time.Minute).Milliseconds())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "per-user series limit of 1 exceeded")
+
+	db := i.getTSDB(userID)
+	require.False(t, db.outOfOrderHead.Empty())
+	require.Empty(t, db.Blocks())
+	assert.Equal(t, expected, query(i))
+
+	// Out-of-order samples are written to the WAL, so they're replayed once the ingester restarts.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), i))
+
+	i = start()
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	db = i.getTSDB(userID)
+	require.NotNil(t, db)
+	assert.True(t, db.outOfOrderEnabled)
+	assert.Equal(t, 1, db.outOfOrderHead.NumSamples())
+	assert.Equal(t, expected, query(i))
+
+	err = push(i, series2, baseTime-(90*time.Minute).Milliseconds())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "per-user series limit of 1 exceeded")
+}
+
+func TestIngester_v2Push_OutOfOrderTimeWindowDisabled(t *testing.T) {
+	const userID = "test"
+
+	var (
+		ctx          = user.InjectOrgID(context.Background(), userID)
+		metricLabels = labels.Labels{{Name: labels.MetricName, Value: "test"}}
+		baseTime     = (10 * time.Hour).Milliseconds()
+	)
+
+	cfg := defaultIngesterTestConfig(t)
+	cfg.LifecyclerConfig.JoinAfter = 0
+
+	i, err := prepareIngesterWithBlocksStorageAndLimits(t, cfg, defaultLimitsTestConfig(), "", nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), i))
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	// Wait until it's ACTIVE.
+	test.Poll(t, 1*time.Second, ring.ACTIVE, func() interface{} {
+		return i.lifecycler.GetState()
+	})
+
+	push := func(timestampMs int64) error {
+		req, _, _, _ := mockWriteRequest(t, metricLabels, 1, timestampMs)
+		_, err := i.v2Push(ctx, req)
+		return err
+	}
+
+	require.NoError(t, push(baseTime))
+	err = push(baseTime - (30 * time.Minute).Milliseconds())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), storage.ErrOutOfOrderSample.Error())
+
+	// Neither overlapping blocks nor the out-of-order WAL are enabled.
+	db := i.getTSDB(userID)
+	assert.False(t, db.outOfOrderEnabled)
+	assert.True(t, db.outOfOrderHead.Empty())
+	_, err = os.Stat(filepath.Join(db.db.Dir(), outOfOrderWALDirName))
+	assert.True(t, os.IsNotExist(err))
+}
+
 func TestIngester_getOrCreateTSDB_ShouldNotAllowToCreateTSDBIfIngesterStateIsNotActive(t *testing.T) {
 	tests := map[string]struct {
 		state       ring.InstanceState
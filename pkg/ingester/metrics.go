@@ -25,6 +25,8 @@ type ingesterMetrics struct {
 	ingestedSamplesFail     prometheus.Counter
 	ingestedExemplarsFail   prometheus.Counter
 	ingestedMetadataFail    prometheus.Counter
+	ingestedOutOfOrder      *prometheus.CounterVec
+	ingestedAttributed      *prometheus.CounterVec
 	queries                 prometheus.Counter
 	queriedSamples          prometheus.Histogram
 	queriedExemplars        prometheus.Histogram
@@ -57,7 +59,8 @@ type ingesterMetrics struct {
 	droppedChunks                 prometheus.Counter
 	oldestUnflushedChunkTimestamp prometheus.Gauge
 
-	activeSeriesPerUser *prometheus.GaugeVec
+	activeSeriesPerUser        *prometheus.GaugeVec
+	activeSeriesPerAttribution *prometheus.GaugeVec
 
 	// Global limit metrics
 	maxUsersGauge           prometheus.GaugeFunc
@@ -104,6 +107,14 @@ func newIngesterMetrics(r prometheus.Registerer, createMetricsConflictingWithTSD
 			Name: "cortex_ingester_ingested_metadata_failures_total",
 			Help: "The total number of metadata that errored on ingestion.",
 		}),
+		ingestedOutOfOrder: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_ingested_out_of_order_samples_total",
+			Help: "The total number of out-of-order samples ingested per user, within the out-of-order time window.",
+		}, []string{"user"}),
+		ingestedAttributed: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_attributed_ingested_samples_total",
+			Help: "The total number of samples ingested per user and cost attribution label value.",
+		}, []string{"user", "attribution"}),
 		queries: promauto.With(r).NewCounter(prometheus.CounterOpts{
 			Name: "cortex_ingester_queries_total",
 			Help: "The total number of queries the ingester has handled.",
@@ -292,10 +303,17 @@ func newIngesterMetrics(r prometheus.Registerer, createMetricsConflictingWithTSD
 			Name: "cortex_ingester_active_series",
 			Help: "Number of currently active series per user.",
 		}, []string{"user"}),
+
+		// Not registered automatically, but only if activeSeriesEnabled is true.
+		activeSeriesPerAttribution: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_ingester_attributed_active_series",
+			Help: "Number of currently active series per user and cost attribution label value.",
+		}, []string{"user", "attribution"}),
 	}
 
 	if activeSeriesEnabled && r != nil {
 		r.MustRegister(m.activeSeriesPerUser)
+		r.MustRegister(m.activeSeriesPerAttribution)
 	}
 
 	if createMetricsConflictingWithTSDB {
@@ -317,6 +335,8 @@ func (m *ingesterMetrics) deletePerUserMetrics(userID string) {
 	m.memMetadataCreatedTotal.DeleteLabelValues(userID)
 	m.memMetadataRemovedTotal.DeleteLabelValues(userID)
 	m.activeSeriesPerUser.DeleteLabelValues(userID)
+	m.ingestedOutOfOrder.DeleteLabelValues(userID)
+	m.deletePerAttributionMetrics(userID)
 
 	if m.memSeriesCreatedTotal != nil {
 		m.memSeriesCreatedTotal.DeleteLabelValues(userID)
@@ -655,3 +675,17 @@ func (sm *tsdbMetrics) setRegistryForUser(userID string, registry *prometheus.Re
 func (sm *tsdbMetrics) removeRegistryForUser(userID string) {
 	sm.regs.RemoveUserRegistry(userID, false)
 }
+
+func (m *ingesterMetrics) deletePerAttributionMetrics(userID string, attributions ...string) {
+	filter := map[string]string{"user": userID}
+	if len(attributions) == 0 {
+		_ = util.DeleteMatchingLabels(m.ingestedAttributed, filter)
+		_ = util.DeleteMatchingLabels(m.activeSeriesPerAttribution, filter)
+		return
+	}
+
+	for _, attribution := range attributions {
+		m.ingestedAttributed.DeleteLabelValues(userID, attribution)
+		m.activeSeriesPerAttribution.DeleteLabelValues(userID, attribution)
+	}
+}
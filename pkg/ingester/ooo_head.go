@@ -0,0 +1,1006 @@
+package ingester
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"go.uber.org/atomic"
+)
+
+// outOfOrderWALDirName is the name of the directory, within the tenant's TSDB directory,
+// of the out-of-order head WAL.
+const outOfOrderWALDirName = "ooo_wal"
+
+// outOfOrderHead keeps in memory the samples of a single tenant which have been rejected
+// by the TSDB head because out of order or out of bounds, but fall within the tenant's
+// out-of-order time window. The samples are queryable until they're compacted into
+// blocks written to the TSDB directory, which are then shipped like any other block
+// and merged with the overlapping ones by the compactor.
+//
+// Samples are appended through an appender, and are only added to the head once committed.
+// Once the WAL has been opened, samples are written to it before being added to the head,
+// and the WAL segments are truncated once the samples they hold have been written to blocks.
+type outOfOrderHead struct {
+	mtx sync.RWMutex
+
+	// Notified about the series which are not in the TSDB head, so that they're
+	// subject to the same limits of the series in the TSDB head.
+	callback tsdb.SeriesLifecycleCallback
+
+	// Number of series not in the TSDB head, including the ones being compacted.
+	numAccountedSeries atomic.Int64
+
+	// Series accepting new samples.
+	series     *outOfOrderIndex
+	numSamples int
+
+	// Wall clock time of the first sample appended since the last compaction.
+	firstAppend time.Time
+
+	// Series being compacted. They're read-only and still queried until the blocks
+	// they've been compacted into get loaded by the TSDB.
+	compacting []*outOfOrderBatch
+
+	// Nil until the WAL is opened.
+	wal *wal.WAL
+
+	// Reference of the next series written to the WAL.
+	nextRef uint64
+
+	// First WAL segment holding the samples of the series accepting new samples.
+	firstSegment int
+}
+
+type outOfOrderBatch struct {
+	series *outOfOrderIndex
+	blocks []ulid.ULID // Nil until the blocks have been successfully written.
+
+	// First WAL segment holding the samples of the batch.
+	firstSegment int
+}
+
+type outOfOrderSeries struct {
+	ref     uint64 // Reference of the series in the WAL.
+	lbls    labels.Labels
+	samples []outOfOrderSample // Sorted by timestamp.
+
+	// Whether the series was not in the TSDB head when created, and so it has been
+	// accounted in the out-of-order head series.
+	accounted bool
+}
+
+type outOfOrderSample struct {
+	t int64
+	v float64
+}
+
+func (s outOfOrderSample) T() int64   { return s.t }
+func (s outOfOrderSample) V() float64 { return s.v }
+
+// outOfOrderIndex keeps the out-of-order series by labels hash, and the postings of each
+// label name and value, so that series can be looked up without scanning all of them.
+type outOfOrderIndex struct {
+	series   map[uint64][]*outOfOrderSeries
+	postings map[string]map[string][]*outOfOrderSeries
+}
+
+func newOutOfOrderIndex() *outOfOrderIndex {
+	return &outOfOrderIndex{
+		series:   map[uint64][]*outOfOrderSeries{},
+		postings: map[string]map[string][]*outOfOrderSeries{},
+	}
+}
+
+func (idx *outOfOrderIndex) get(hash uint64, lbls labels.Labels) *outOfOrderSeries {
+	for _, s := range idx.series[hash] {
+		if labels.Equal(s.lbls, lbls) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (idx *outOfOrderIndex) add(hash uint64, s *outOfOrderSeries) {
+	idx.series[hash] = append(idx.series[hash], s)
+
+	for _, l := range s.lbls {
+		if idx.postings[l.Name] == nil {
+			idx.postings[l.Name] = map[string][]*outOfOrderSeries{}
+		}
+		idx.postings[l.Name][l.Value] = append(idx.postings[l.Name][l.Value], s)
+	}
+}
+
+// forEach calls f for each series in the index.
+func (idx *outOfOrderIndex) forEach(f func(s *outOfOrderSeries)) {
+	for _, entries := range idx.series {
+		for _, s := range entries {
+			f(s)
+		}
+	}
+}
+
+// selectSeries returns the series matching all the input matchers. The postings of the
+// matchers not matching the empty label value are intersected to find the candidate
+// series, which are then filtered by all the matchers.
+func (idx *outOfOrderIndex) selectSeries(matchers []*labels.Matcher) []*outOfOrderSeries {
+	var candidates map[*outOfOrderSeries]struct{}
+
+	for _, m := range matchers {
+		// Series without the label would match, so postings can't be used.
+		if m.Matches("") {
+			continue
+		}
+
+		matching := map[*outOfOrderSeries]struct{}{}
+		if m.Type == labels.MatchEqual {
+			for _, s := range idx.postings[m.Name][m.Value] {
+				if _, ok := candidates[s]; candidates == nil || ok {
+					matching[s] = struct{}{}
+				}
+			}
+		} else {
+			for value, series := range idx.postings[m.Name] {
+				if !m.Matches(value) {
+					continue
+				}
+				for _, s := range series {
+					if _, ok := candidates[s]; candidates == nil || ok {
+						matching[s] = struct{}{}
+					}
+				}
+			}
+		}
+
+		candidates = matching
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+
+	var result []*outOfOrderSeries
+
+	// No matcher could be used to look up the postings, so all the series are candidates.
+	if candidates == nil {
+		idx.forEach(func(s *outOfOrderSeries) {
+			if matchesAll(s.lbls, matchers) {
+				result = append(result, s)
+			}
+		})
+		return result
+	}
+
+	for s := range candidates {
+		if matchesAll(s.lbls, matchers) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// newOutOfOrderHead makes a new outOfOrderHead. The callback can be nil.
+func newOutOfOrderHead(callback tsdb.SeriesLifecycleCallback) *outOfOrderHead {
+	return &outOfOrderHead{
+		callback: callback,
+		series:   newOutOfOrderIndex(),
+	}
+}
+
+// Append adds a sample to the series with the input labels and commits it right away.
+// See outOfOrderAppender.Append.
+func (h *outOfOrderHead) Append(lbls labels.Labels, inHead bool, t int64, v float64) error {
+	app := h.Appender()
+	if err := app.Append(lbls, inHead, t, v); err != nil {
+		app.Rollback()
+		return err
+	}
+	return app.Commit()
+}
+
+// Appender returns a new appender on the head. The appender is not safe for concurrent use.
+func (h *outOfOrderHead) Appender() *outOfOrderAppender {
+	return &outOfOrderAppender{head: h, series: map[uint64][]*outOfOrderPendingSeries{}}
+}
+
+// accountSeries notifies the callback about the creation of a series not in the TSDB head.
+func (h *outOfOrderHead) accountSeries(lbls labels.Labels) {
+	h.numAccountedSeries.Inc()
+	if h.callback != nil {
+		h.callback.PostCreation(lbls)
+	}
+}
+
+// unaccountSeries notifies the callback about the removal of series not in the TSDB head.
+func (h *outOfOrderHead) unaccountSeries(lbls ...labels.Labels) {
+	if len(lbls) == 0 {
+		return
+	}
+
+	h.numAccountedSeries.Sub(int64(len(lbls)))
+	if h.callback != nil {
+		h.callback.PostDeletion(lbls...)
+	}
+}
+
+// addSeries adds a new series, replayed from the WAL, to the series accepting new samples.
+func (h *outOfOrderHead) addSeries(hash uint64, s *outOfOrderSeries) {
+	h.series.add(hash, s)
+
+	if s.accounted {
+		h.accountSeries(s.lbls)
+	}
+}
+
+// insertSample inserts a sample at the index i of the series samples.
+func (h *outOfOrderHead) insertSample(s *outOfOrderSeries, i int, t int64, v float64) {
+	s.samples = append(s.samples, outOfOrderSample{})
+	copy(s.samples[i+1:], s.samples[i:])
+	s.samples[i] = outOfOrderSample{t: t, v: v}
+
+	if h.numSamples == 0 {
+		h.firstAppend = time.Now()
+	}
+	h.numSamples++
+}
+
+// outOfOrderAppender stages the samples appended to the out-of-order head until they're
+// committed, so that the samples of a failed request can be rolled back together with the
+// ones appended to the TSDB head.
+type outOfOrderAppender struct {
+	head *outOfOrderHead
+
+	// Series of the staged samples, by labels hash.
+	series map[uint64][]*outOfOrderPendingSeries
+}
+
+type outOfOrderPendingSeries struct {
+	lbls    labels.Labels
+	inHead  bool
+	samples map[int64]float64
+
+	// Series created by the appender, not in the head yet, which has been accounted
+	// in the head series. Nil if the series was in the head when first appended.
+	reserved *outOfOrderSeries
+}
+
+// Append stages a sample of the series with the input labels, which are retained and must
+// not be modified afterwards. If the series doesn't exist yet and is not in the TSDB head
+// either, it's subject to the callback series limits, and it's accounted right away so that
+// the limits apply to the series staged too. Appending the same sample twice is a no-op,
+// while appending a different value for an existing timestamp is rejected.
+func (a *outOfOrderAppender) Append(lbls labels.Labels, inHead bool, t int64, v float64) error {
+	h := a.head
+	hash := lbls.Hash()
+
+	ps := a.get(hash, lbls)
+	if ps == nil {
+		h.mtx.RLock()
+		s := h.series.get(hash, lbls)
+		var (
+			existing float64
+			found    bool
+		)
+		if s != nil {
+			if i, ok := s.search(t); ok {
+				existing, found = s.samples[i].v, true
+			}
+		}
+		h.mtx.RUnlock()
+
+		if found {
+			if math.Float64bits(existing) == math.Float64bits(v) {
+				return nil
+			}
+			return storage.ErrDuplicateSampleForTimestamp
+		}
+
+		ps = &outOfOrderPendingSeries{lbls: lbls, inHead: inHead, samples: map[int64]float64{}}
+		if s == nil {
+			if !inHead && h.callback != nil {
+				if err := h.callback.PreCreation(lbls); err != nil {
+					return err
+				}
+			}
+
+			ps.reserved = &outOfOrderSeries{lbls: lbls, accounted: !inHead}
+			if ps.reserved.accounted {
+				h.accountSeries(lbls)
+			}
+		}
+		a.series[hash] = append(a.series[hash], ps)
+	} else if err := a.checkSample(ps, hash, t, v); err != nil {
+		return err
+	}
+
+	ps.samples[t] = v
+	return nil
+}
+
+func (a *outOfOrderAppender) get(hash uint64, lbls labels.Labels) *outOfOrderPendingSeries {
+	for _, ps := range a.series[hash] {
+		if labels.Equal(ps.lbls, lbls) {
+			return ps
+		}
+	}
+	return nil
+}
+
+// checkSample returns an error if a different value has already been staged or committed
+// for the timestamp of the sample.
+func (a *outOfOrderAppender) checkSample(ps *outOfOrderPendingSeries, hash uint64, t int64, v float64) error {
+	if staged, ok := ps.samples[t]; ok {
+		if math.Float64bits(staged) == math.Float64bits(v) {
+			return nil
+		}
+		return storage.ErrDuplicateSampleForTimestamp
+	}
+
+	a.head.mtx.RLock()
+	defer a.head.mtx.RUnlock()
+
+	if s := a.head.series.get(hash, ps.lbls); s != nil {
+		if i, ok := s.search(t); ok && math.Float64bits(s.samples[i].v) != math.Float64bits(v) {
+			return storage.ErrDuplicateSampleForTimestamp
+		}
+	}
+	return nil
+}
+
+// Commit writes the staged samples to the WAL, if open, and then adds them to the head.
+// Samples whose timestamp has been committed by another appender in the meanwhile are
+// dropped. If the samples fail to be written to the WAL, none of them is added to the head.
+func (a *outOfOrderAppender) Commit() error {
+	h := a.head
+	defer a.reset()
+
+	type resolvedSeries struct {
+		hash    uint64
+		series  *outOfOrderSeries
+		created bool
+		// Whether the created series must be accounted, because not reserved by the appender.
+		account bool
+		samples []outOfOrderSample
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var (
+		resolved []resolvedSeries
+		unused   []labels.Labels
+		nextRef  = h.nextRef
+	)
+
+	for hash, entries := range a.series {
+		for _, ps := range entries {
+			r := resolvedSeries{hash: hash, series: h.series.get(hash, ps.lbls)}
+
+			// The series may have been created by another appender, or compacted, in the meanwhile.
+			// A series compacted in the meanwhile is accounted again, without checking the limits,
+			// which have been checked when the series was created.
+			if r.series == nil {
+				r.created = true
+				r.series = ps.reserved
+				if r.series == nil {
+					r.series = &outOfOrderSeries{lbls: ps.lbls, accounted: !ps.inHead}
+					r.account = r.series.accounted
+				}
+				r.series.ref = nextRef
+				nextRef++
+			} else if ps.reserved != nil && ps.reserved.accounted {
+				unused = append(unused, ps.lbls)
+			}
+
+			for t, v := range ps.samples {
+				if !r.created {
+					if _, found := r.series.search(t); found {
+						continue
+					}
+				}
+				r.samples = append(r.samples, outOfOrderSample{t: t, v: v})
+			}
+
+			if len(r.samples) > 0 {
+				resolved = append(resolved, r)
+			}
+		}
+	}
+
+	if h.wal != nil {
+		var (
+			enc     record.Encoder
+			series  []record.RefSeries
+			samples []record.RefSample
+		)
+		for _, r := range resolved {
+			if r.created {
+				series = append(series, record.RefSeries{Ref: r.series.ref, Labels: r.series.lbls})
+			}
+			for _, s := range r.samples {
+				samples = append(samples, record.RefSample{Ref: r.series.ref, T: s.t, V: s.v})
+			}
+		}
+
+		if len(samples) > 0 {
+			var recs [][]byte
+			if len(series) > 0 {
+				recs = append(recs, enc.Series(series, nil))
+			}
+			recs = append(recs, enc.Samples(samples, nil))
+
+			if err := h.wal.Log(recs...); err != nil {
+				a.unreserve()
+				return errors.Wrap(err, "write to out-of-order WAL")
+			}
+		}
+	}
+
+	h.nextRef = nextRef
+	for _, r := range resolved {
+		if r.created {
+			h.series.add(r.hash, r.series)
+		}
+		if r.account {
+			h.accountSeries(r.series.lbls)
+		}
+
+		for _, s := range r.samples {
+			if i, found := r.series.search(s.t); !found {
+				h.insertSample(r.series, i, s.t, s.v)
+			}
+		}
+	}
+
+	h.unaccountSeries(unused...)
+	return nil
+}
+
+// Rollback discards the staged samples.
+func (a *outOfOrderAppender) Rollback() {
+	a.unreserve()
+	a.reset()
+}
+
+// unreserve releases the accounted series created by the appender.
+func (a *outOfOrderAppender) unreserve() {
+	var reserved []labels.Labels
+	for _, entries := range a.series {
+		for _, ps := range entries {
+			if ps.reserved != nil && ps.reserved.accounted {
+				reserved = append(reserved, ps.lbls)
+			}
+		}
+	}
+
+	a.head.unaccountSeries(reserved...)
+}
+
+func (a *outOfOrderAppender) reset() {
+	a.series = map[uint64][]*outOfOrderPendingSeries{}
+}
+
+// search returns the index of the first sample with timestamp not lower than t, and whether
+// its timestamp is t.
+func (s *outOfOrderSeries) search(t int64) (int, bool) {
+	i := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].t >= t })
+	return i, i < len(s.samples) && s.samples[i].t == t
+}
+
+// OpenWAL opens the WAL in dir and appends the samples it holds to the head, which are
+// subject to the callback series limits only if inHead returns false for their series.
+// If the WAL is corrupted, the samples after the corruption are discarded.
+func (h *outOfOrderHead) OpenWAL(logger log.Logger, dir string, inHead func(labels.Labels) bool) error {
+	// The WAL metrics are not registered, because they would clash with the TSDB ones.
+	w, err := wal.NewSize(logger, nil, dir, wal.DefaultSegmentSize, false)
+	if err != nil {
+		return errors.Wrap(err, "open out-of-order WAL")
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if err := h.replayWAL(dir, inHead); err != nil {
+		level.Warn(logger).Log("msg", "out-of-order WAL is corrupted, repairing it", "err", err)
+		if err := w.Repair(err); err != nil {
+			return tsdb_errors.NewMulti(errors.Wrap(err, "repair out-of-order WAL"), w.Close()).Err()
+		}
+	}
+
+	first, _, err := wal.Segments(dir)
+	if err != nil {
+		return tsdb_errors.NewMulti(errors.Wrap(err, "list out-of-order WAL segments"), w.Close()).Err()
+	}
+
+	h.wal = w
+	h.firstSegment = first
+	return nil
+}
+
+// replayWAL appends the samples held by the WAL in dir to the series accepting new samples.
+func (h *outOfOrderHead) replayWAL(dir string, inHead func(labels.Labels) bool) error {
+	sr, err := wal.NewSegmentsReader(dir)
+	if err != nil {
+		return errors.Wrap(err, "open out-of-order WAL segments")
+	}
+	defer sr.Close()
+
+	var (
+		dec     record.Decoder
+		series  []record.RefSeries
+		samples []record.RefSample
+		refs    = map[uint64]*outOfOrderSeries{}
+		r       = wal.NewReader(sr)
+	)
+
+	for r.Next() {
+		rec := r.Record()
+
+		switch dec.Type(rec) {
+		case record.Series:
+			if series, err = dec.Series(rec, series[:0]); err != nil {
+				return &wal.CorruptionErr{Err: errors.Wrap(err, "decode series"), Segment: r.Segment(), Offset: r.Offset()}
+			}
+
+			for _, rs := range series {
+				// The same series may have been written multiple times, with different references,
+				// if it belongs to multiple batches.
+				hash := rs.Labels.Hash()
+				s := h.series.get(hash, rs.Labels)
+				if s == nil {
+					s = &outOfOrderSeries{ref: rs.Ref, lbls: rs.Labels, accounted: !inHead(rs.Labels)}
+					h.addSeries(hash, s)
+				}
+
+				refs[rs.Ref] = s
+				if rs.Ref >= h.nextRef {
+					h.nextRef = rs.Ref + 1
+				}
+			}
+
+		case record.Samples:
+			if samples, err = dec.Samples(rec, samples[:0]); err != nil {
+				return &wal.CorruptionErr{Err: errors.Wrap(err, "decode samples"), Segment: r.Segment(), Offset: r.Offset()}
+			}
+
+			for _, rs := range samples {
+				s, ok := refs[rs.Ref]
+				if !ok {
+					continue
+				}
+
+				// Samples written multiple times are appended once.
+				if i, found := s.search(rs.T); !found {
+					h.insertSample(s, i, rs.T, rs.V)
+				}
+			}
+		}
+	}
+
+	return r.Err()
+}
+
+// Close closes the WAL, if open. Samples not compacted yet are replayed once the WAL is opened again.
+func (h *outOfOrderHead) Close() error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.wal == nil {
+		return nil
+	}
+
+	err := h.wal.Close()
+	h.wal = nil
+	return errors.Wrap(err, "close out-of-order WAL")
+}
+
+// Empty returns true if the head holds no sample, including the ones being compacted.
+func (h *outOfOrderHead) Empty() bool {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	return h.numSamples == 0 && len(h.compacting) == 0
+}
+
+// NumSamples returns the number of samples appended to the head since the last compaction.
+func (h *outOfOrderHead) NumSamples() int {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	return h.numSamples
+}
+
+// NumAccountedSeries returns the number of series held by the head, which were not in the
+// TSDB head when created.
+func (h *outOfOrderHead) NumAccountedSeries() int {
+	return int(h.numAccountedSeries.Load())
+}
+
+// Compact writes the head samples to blocks in dir, one for each blockRange-aligned range.
+// Compacted samples keep being queried from the head until all the blocks they've been
+// written to are in the set returned by loaded, which is checked on subsequent calls.
+// If force is false, samples are compacted only once the first of them has been appended
+// at least blockRange ago.
+func (h *outOfOrderHead) Compact(logger log.Logger, dir string, blockRange int64, force bool, loaded func() map[ulid.ULID]struct{}) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if len(h.compacting) > 0 {
+		blocks := loaded()
+		pending := h.compacting[:0]
+		for _, b := range h.compacting {
+			if b.blocks == nil || !allBlocksLoaded(b.blocks, blocks) {
+				pending = append(pending, b)
+				continue
+			}
+
+			h.releaseBatch(b)
+		}
+		h.compacting = pending
+	}
+
+	// Retry writing the blocks which previously failed.
+	for _, b := range h.compacting {
+		if b.blocks != nil {
+			continue
+		}
+
+		ids, err := writeOutOfOrderBlocks(logger, dir, blockRange, b.series)
+		if err != nil {
+			return err
+		}
+		b.blocks = ids
+	}
+
+	if h.numSamples == 0 || (!force && time.Since(h.firstAppend) < time.Duration(blockRange)*time.Millisecond) {
+		return h.truncateWAL()
+	}
+
+	// The samples appended from now on are written to a new WAL segment, so that the
+	// segments holding the samples of the batch can be removed once compacted.
+	b := &outOfOrderBatch{series: h.series, firstSegment: h.firstSegment}
+	if h.wal != nil {
+		if err := h.wal.NextSegment(); err != nil {
+			return errors.Wrap(err, "cut out-of-order WAL segment")
+		}
+
+		segment, _, err := h.wal.LastSegmentAndOffset()
+		if err != nil {
+			return errors.Wrap(err, "get out-of-order WAL segment")
+		}
+		h.firstSegment = segment
+	}
+
+	h.compacting = append(h.compacting, b)
+	h.series = newOutOfOrderIndex()
+	h.numSamples = 0
+
+	ids, err := writeOutOfOrderBlocks(logger, dir, blockRange, b.series)
+	if err != nil {
+		return err
+	}
+	b.blocks = ids
+	return h.truncateWAL()
+}
+
+// truncateWAL removes the WAL segments only holding samples which have been written to blocks.
+func (h *outOfOrderHead) truncateWAL() error {
+	if h.wal == nil {
+		return nil
+	}
+
+	first := h.firstSegment
+	for _, b := range h.compacting {
+		if b.blocks == nil && b.firstSegment < first {
+			first = b.firstSegment
+		}
+	}
+
+	return errors.Wrap(h.wal.Truncate(first), "truncate out-of-order WAL")
+}
+
+// releaseBatch notifies the callback about the removal of the accounted series of the batch.
+func (h *outOfOrderHead) releaseBatch(b *outOfOrderBatch) {
+	var deleted []labels.Labels
+	b.series.forEach(func(s *outOfOrderSeries) {
+		if s.accounted {
+			deleted = append(deleted, s.lbls)
+		}
+	})
+
+	h.unaccountSeries(deleted...)
+}
+
+// Querier returns a querier on the head samples within mint and maxt (both inclusive).
+func (h *outOfOrderHead) Querier(mint, maxt int64) storage.Querier {
+	return &outOfOrderQuerier{head: h, mint: mint, maxt: maxt}
+}
+
+// ChunkQuerier returns a chunk querier on the head samples within mint and maxt (both inclusive).
+func (h *outOfOrderHead) ChunkQuerier(mint, maxt int64) storage.ChunkQuerier {
+	return &outOfOrderChunkQuerier{outOfOrderQuerier{head: h, mint: mint, maxt: maxt}}
+}
+
+// selectSeries returns the labels and the samples within mint and maxt of the series matching
+// the input matchers, sorted by labels. Samples of the same series being compacted are merged.
+func (h *outOfOrderHead) selectSeries(mint, maxt int64, matchers []*labels.Matcher) ([]labels.Labels, [][]tsdbutil.Sample) {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	merged := map[string]int{}
+	var (
+		lbls    []labels.Labels
+		samples [][]tsdbutil.Sample
+	)
+
+	indexes := make([]*outOfOrderIndex, 0, len(h.compacting)+1)
+	for _, b := range h.compacting {
+		indexes = append(indexes, b.series)
+	}
+	indexes = append(indexes, h.series)
+
+	for _, idx := range indexes {
+		for _, s := range idx.selectSeries(matchers) {
+			var selected []tsdbutil.Sample
+			for _, sample := range s.samples {
+				if sample.t >= mint && sample.t <= maxt {
+					selected = append(selected, sample)
+				}
+			}
+			if len(selected) == 0 {
+				continue
+			}
+
+			key := s.lbls.String()
+			if pos, ok := merged[key]; ok {
+				samples[pos] = mergeOutOfOrderSamples(samples[pos], selected)
+				continue
+			}
+
+			merged[key] = len(lbls)
+			lbls = append(lbls, s.lbls)
+			samples = append(samples, selected)
+		}
+	}
+
+	sort.Sort(&labelsAndSamples{lbls: lbls, samples: samples})
+	return lbls, samples
+}
+
+type outOfOrderQuerier struct {
+	head       *outOfOrderHead
+	mint, maxt int64
+}
+
+// Select implements storage.Querier. Series are always sorted.
+func (q *outOfOrderQuerier) Select(_ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	lbls, samples := q.head.selectSeries(q.mint, q.maxt, matchers)
+
+	series := make([]storage.Series, 0, len(lbls))
+	for i := range lbls {
+		series = append(series, storage.NewListSeries(lbls[i], samples[i]))
+	}
+	return &outOfOrderSeriesSet{series: series, curr: -1}
+}
+
+// LabelValues implements storage.Querier.
+func (q *outOfOrderQuerier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	lbls, _ := q.head.selectSeries(q.mint, q.maxt, matchers)
+
+	values := map[string]struct{}{}
+	for _, l := range lbls {
+		if v := l.Get(name); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	return sortedKeys(values), nil, nil
+}
+
+// LabelNames implements storage.Querier.
+func (q *outOfOrderQuerier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	lbls, _ := q.head.selectSeries(q.mint, q.maxt, matchers)
+
+	names := map[string]struct{}{}
+	for _, l := range lbls {
+		for _, p := range l {
+			names[p.Name] = struct{}{}
+		}
+	}
+	return sortedKeys(names), nil, nil
+}
+
+// Close implements storage.Querier.
+func (q *outOfOrderQuerier) Close() error {
+	return nil
+}
+
+type outOfOrderChunkQuerier struct {
+	outOfOrderQuerier
+}
+
+// Select implements storage.ChunkQuerier. Series are always sorted.
+func (q *outOfOrderChunkQuerier) Select(_ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.ChunkSeriesSet {
+	lbls, samples := q.head.selectSeries(q.mint, q.maxt, matchers)
+
+	series := make([]storage.ChunkSeries, 0, len(lbls))
+	for i := range lbls {
+		series = append(series, storage.NewListChunkSeriesFromSamples(lbls[i], samples[i]))
+	}
+	return &outOfOrderChunkSeriesSet{series: series, curr: -1}
+}
+
+type outOfOrderSeriesSet struct {
+	series []storage.Series
+	curr   int
+}
+
+func (s *outOfOrderSeriesSet) Next() bool {
+	s.curr++
+	return s.curr < len(s.series)
+}
+
+func (s *outOfOrderSeriesSet) At() storage.Series         { return s.series[s.curr] }
+func (s *outOfOrderSeriesSet) Err() error                 { return nil }
+func (s *outOfOrderSeriesSet) Warnings() storage.Warnings { return nil }
+
+type outOfOrderChunkSeriesSet struct {
+	series []storage.ChunkSeries
+	curr   int
+}
+
+func (s *outOfOrderChunkSeriesSet) Next() bool {
+	s.curr++
+	return s.curr < len(s.series)
+}
+
+func (s *outOfOrderChunkSeriesSet) At() storage.ChunkSeries    { return s.series[s.curr] }
+func (s *outOfOrderChunkSeriesSet) Err() error                 { return nil }
+func (s *outOfOrderChunkSeriesSet) Warnings() storage.Warnings { return nil }
+
+// writeOutOfOrderBlocks writes the input series to dir, in a block for each blockRange-aligned
+// range. If any block fails to be written, the blocks written so far are removed.
+func writeOutOfOrderBlocks(logger log.Logger, dir string, blockRange int64, series *outOfOrderIndex) (_ []ulid.ULID, err error) {
+	// Group the samples by block range.
+	ranges := map[int64][]*outOfOrderSeries{}
+	series.forEach(func(s *outOfOrderSeries) {
+		start := 0
+		for start < len(s.samples) {
+			rangeStart := rangeStartForTimestamp(s.samples[start].t, blockRange)
+			end := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].t >= rangeStart+blockRange })
+
+			ranges[rangeStart] = append(ranges[rangeStart], &outOfOrderSeries{lbls: s.lbls, samples: s.samples[start:end]})
+			start = end
+		}
+	})
+
+	var ids []ulid.ULID
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, id := range ids {
+			_ = os.RemoveAll(filepath.Join(dir, id.String()))
+		}
+	}()
+
+	for _, rangeSeries := range ranges {
+		id, err := writeOutOfOrderBlock(logger, dir, blockRange, rangeSeries)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func writeOutOfOrderBlock(logger log.Logger, dir string, blockRange int64, series []*outOfOrderSeries) (_ ulid.ULID, err error) {
+	w, err := tsdb.NewBlockWriter(logger, dir, blockRange)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "create block writer")
+	}
+	defer func() {
+		if closeErr := w.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "close block writer")
+		}
+	}()
+
+	// The head used by the writer rejects samples older than half the block range from the
+	// first appended one, so series are appended starting from the one with the oldest sample.
+	sort.Slice(series, func(i, j int) bool { return series[i].samples[0].t < series[j].samples[0].t })
+
+	app := w.Appender(context.Background())
+	for _, s := range series {
+		var ref uint64
+		for _, sample := range s.samples {
+			if ref, err = app.Append(ref, s.lbls, sample.t, sample.v); err != nil {
+				_ = app.Rollback()
+				return ulid.ULID{}, errors.Wrap(err, "append out-of-order sample")
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "commit out-of-order samples")
+	}
+
+	id, err := w.Flush(context.Background())
+	return id, errors.Wrap(err, "flush out-of-order block")
+}
+
+func rangeStartForTimestamp(t, blockRange int64) int64 {
+	if t < 0 {
+		return ((t - blockRange + 1) / blockRange) * blockRange
+	}
+	return (t / blockRange) * blockRange
+}
+
+func allBlocksLoaded(ids []ulid.ULID, loaded map[ulid.ULID]struct{}) bool {
+	for _, id := range ids {
+		if _, ok := loaded[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAll(lbls labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeOutOfOrderSamples merges two sorted lists of samples. On equal timestamps, the sample in b wins.
+func mergeOutOfOrderSamples(a, b []tsdbutil.Sample) []tsdbutil.Sample {
+	result := make([]tsdbutil.Sample, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0].T() < b[0].T():
+			result, a = append(result, a[0]), a[1:]
+		case a[0].T() > b[0].T():
+			result, b = append(result, b[0]), b[1:]
+		default:
+			result, a, b = append(result, b[0]), a[1:], b[1:]
+		}
+	}
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type labelsAndSamples struct {
+	lbls    []labels.Labels
+	samples [][]tsdbutil.Sample
+}
+
+func (s *labelsAndSamples) Len() int           { return len(s.lbls) }
+func (s *labelsAndSamples) Less(i, j int) bool { return labels.Compare(s.lbls[i], s.lbls[j]) < 0 }
+func (s *labelsAndSamples) Swap(i, j int) {
+	s.lbls[i], s.lbls[j] = s.lbls[j], s.lbls[i]
+	s.samples[i], s.samples[j] = s.samples[j], s.samples[i]
+}
@@ -0,0 +1,391 @@
+package ingester
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutOfOrderHead_Append(t *testing.T) {
+	h := newOutOfOrderHead(nil)
+	series1 := labels.FromStrings(labels.MetricName, "test", "series", "1")
+	series2 := labels.FromStrings(labels.MetricName, "test", "series", "2")
+
+	require.NoError(t, h.Append(series1, false, 30, 3))
+	require.NoError(t, h.Append(series1, false, 10, 1))
+	require.NoError(t, h.Append(series1, false, 20, 2))
+	require.NoError(t, h.Append(series2, false, 10, 1))
+
+	// Appending the same sample twice is a no-op.
+	require.NoError(t, h.Append(series1, false, 20, 2))
+
+	// Appending a different value for the same timestamp is rejected.
+	assert.Equal(t, storage.ErrDuplicateSampleForTimestamp, h.Append(series1, false, 20, 5))
+
+	assert.Equal(t, 4, h.NumSamples())
+	assert.False(t, h.Empty())
+
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series1.String(): {{t: 10, v: 1}, {t: 20, v: 2}}, // Sample at 30 is out of the queried range.
+		series2.String(): {{t: 10, v: 1}},
+	}, querySamples(t, h.Querier(0, 25), labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")))
+
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series2.String(): {{t: 10, v: 1}},
+	}, querySamples(t, h.Querier(0, 25), labels.MustNewMatcher(labels.MatchEqual, "series", "2")))
+
+	values, _, err := h.Querier(0, 25).LabelValues("series")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, values)
+
+	names, _, err := h.Querier(0, 25).LabelNames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{labels.MetricName, "series"}, names)
+}
+
+func TestOutOfOrderAppender_ShouldAddTheSamplesOnlyOnceCommitted(t *testing.T) {
+	walDir := filepath.Join(t.TempDir(), outOfOrderWALDirName)
+	logger := log.NewNopLogger()
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")
+	callback := &mockSeriesLifecycleCallback{maxSeries: 10}
+	inHead := func(labels.Labels) bool { return false }
+
+	series1 := labels.FromStrings(labels.MetricName, "test", "series", "1")
+	series2 := labels.FromStrings(labels.MetricName, "test", "series", "2")
+
+	h := newOutOfOrderHead(callback)
+	require.NoError(t, h.OpenWAL(logger, walDir, inHead))
+	require.NoError(t, h.Append(series1, false, 10, 1))
+
+	// Staged samples are checked against the committed and the staged ones, but not added to the head.
+	app := h.Appender()
+	require.NoError(t, app.Append(series1, false, 20, 2))
+	require.NoError(t, app.Append(series1, false, 20, 2))
+	require.NoError(t, app.Append(series2, false, 10, 3))
+	assert.Equal(t, storage.ErrDuplicateSampleForTimestamp, app.Append(series1, false, 10, 5))
+	assert.Equal(t, storage.ErrDuplicateSampleForTimestamp, app.Append(series1, false, 20, 5))
+
+	assert.Equal(t, 1, h.NumSamples())
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series1.String(): {{t: 10, v: 1}},
+	}, querySamples(t, h.Querier(0, 100), matcher))
+
+	// The series created by the appender are accounted right away, and released on rollback.
+	assert.Equal(t, 2, h.NumAccountedSeries())
+	app.Rollback()
+	assert.Equal(t, 1, h.NumAccountedSeries())
+	assert.Equal(t, []labels.Labels{series2}, callback.deleted)
+	assert.Equal(t, 1, h.NumSamples())
+
+	// Committed samples are added to the head and written to the WAL.
+	app = h.Appender()
+	require.NoError(t, app.Append(series1, false, 30, 4))
+	require.NoError(t, app.Append(series2, false, 10, 3))
+	require.NoError(t, app.Commit())
+
+	assert.Equal(t, 2, h.NumAccountedSeries())
+	expected := map[string][]outOfOrderSample{
+		series1.String(): {{t: 10, v: 1}, {t: 30, v: 4}},
+		series2.String(): {{t: 10, v: 3}},
+	}
+	assert.Equal(t, expected, querySamples(t, h.Querier(0, 100), matcher))
+	require.NoError(t, h.Close())
+
+	h = newOutOfOrderHead(nil)
+	require.NoError(t, h.OpenWAL(logger, walDir, inHead))
+	assert.Equal(t, expected, querySamples(t, h.Querier(0, 100), matcher))
+	require.NoError(t, h.Close())
+}
+
+func TestOutOfOrderAppender_ShouldNotAccountTwiceTheSeriesCreatedConcurrently(t *testing.T) {
+	callback := &mockSeriesLifecycleCallback{maxSeries: 10}
+	h := newOutOfOrderHead(callback)
+	series := labels.FromStrings(labels.MetricName, "test", "series", "1")
+
+	app1 := h.Appender()
+	app2 := h.Appender()
+	require.NoError(t, app1.Append(series, false, 10, 1))
+	require.NoError(t, app2.Append(series, false, 20, 2))
+	assert.Equal(t, 2, h.NumAccountedSeries())
+
+	require.NoError(t, app1.Commit())
+	require.NoError(t, app2.Commit())
+
+	assert.Equal(t, 1, h.NumAccountedSeries())
+	assert.Equal(t, 2, h.NumSamples())
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series.String(): {{t: 10, v: 1}, {t: 20, v: 2}},
+	}, querySamples(t, h.Querier(0, 100), labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")))
+}
+
+func TestOutOfOrderHead_Compact(t *testing.T) {
+	const blockRange = int64(100)
+
+	dir, err := ioutil.TempDir("", "ooo-head")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, os.RemoveAll(dir))
+	})
+
+	h := newOutOfOrderHead(nil)
+	series1 := labels.FromStrings(labels.MetricName, "test", "series", "1")
+	series2 := labels.FromStrings(labels.MetricName, "test", "series", "2")
+
+	// Samples spanning two block ranges.
+	require.NoError(t, h.Append(series1, false, 90, 1))
+	require.NoError(t, h.Append(series1, false, 110, 2))
+	require.NoError(t, h.Append(series2, false, 10, 3))
+
+	loaded := map[ulid.ULID]struct{}{}
+	loadedFn := func() map[ulid.ULID]struct{} { return loaded }
+
+	// Not compacted until the first sample has been appended a block range ago, unless forced.
+	require.NoError(t, h.Compact(log.NewNopLogger(), dir, int64(time.Hour/time.Millisecond), false, loadedFn))
+	assert.Equal(t, 3, h.NumSamples())
+
+	require.NoError(t, h.Compact(log.NewNopLogger(), dir, blockRange, true, loadedFn))
+	assert.Equal(t, 0, h.NumSamples())
+	assert.False(t, h.Empty())
+
+	// Samples are still queried until the blocks have been loaded.
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series1.String(): {{t: 90, v: 1}, {t: 110, v: 2}},
+		series2.String(): {{t: 10, v: 3}},
+	}, querySamples(t, h.Querier(0, 200), labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	actual := map[string][]outOfOrderSample{}
+	for _, entry := range entries {
+		id, err := ulid.Parse(entry.Name())
+		require.NoError(t, err)
+		loaded[id] = struct{}{}
+
+		block, err := tsdb.OpenBlock(log.NewNopLogger(), filepath.Join(dir, entry.Name()), nil)
+		require.NoError(t, err)
+		assert.Equal(t, block.MinTime()/blockRange, (block.MaxTime()-1)/blockRange, "block must not span multiple ranges")
+
+		q, err := tsdb.NewBlockQuerier(block, block.MinTime(), block.MaxTime())
+		require.NoError(t, err)
+		for k, v := range querySamples(t, q, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")) {
+			actual[k] = append(actual[k], v...)
+		}
+		require.NoError(t, block.Close())
+	}
+
+	assert.Len(t, actual[series1.String()], 2)
+	assert.ElementsMatch(t, []outOfOrderSample{{t: 90, v: 1}, {t: 110, v: 2}}, actual[series1.String()])
+	assert.Equal(t, []outOfOrderSample{{t: 10, v: 3}}, actual[series2.String()])
+
+	// Once the blocks have been loaded, samples are removed from memory.
+	require.NoError(t, h.Compact(log.NewNopLogger(), dir, blockRange, false, loadedFn))
+	assert.True(t, h.Empty())
+}
+
+func TestOutOfOrderHead_Select(t *testing.T) {
+	h := newOutOfOrderHead(nil)
+	series1 := labels.FromStrings(labels.MetricName, "test", "pod", "a-1")
+	series2 := labels.FromStrings(labels.MetricName, "test", "pod", "b-1")
+	series3 := labels.FromStrings(labels.MetricName, "other")
+
+	for _, series := range []labels.Labels{series1, series2, series3} {
+		require.NoError(t, h.Append(series, false, 10, 1))
+	}
+
+	tests := map[string]struct {
+		matchers []*labels.Matcher
+		expected []labels.Labels
+	}{
+		"equal matcher": {
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "pod", "a-1")},
+			expected: []labels.Labels{series1},
+		},
+		"equal matchers intersection": {
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test"),
+				labels.MustNewMatcher(labels.MatchEqual, "pod", "b-1"),
+			},
+			expected: []labels.Labels{series2},
+		},
+		"regexp matcher": {
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "pod", ".*-1")},
+			expected: []labels.Labels{series1, series2},
+		},
+		"not equal matcher not matching the empty value": {
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test"),
+				labels.MustNewMatcher(labels.MatchNotEqual, "pod", "a-1"),
+			},
+			expected: []labels.Labels{series2},
+		},
+		"matcher matching the empty value": {
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "pod", "a-1")},
+			expected: []labels.Labels{series3, series2},
+		},
+		"no matching series": {
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "other"),
+				labels.MustNewMatcher(labels.MatchEqual, "pod", "a-1"),
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			actual, _ := h.selectSeries(0, 100, testData.matchers)
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+}
+
+func TestOutOfOrderHead_ShouldNotifyTheCallbackAboutSeriesNotInTheHead(t *testing.T) {
+	dir := t.TempDir()
+	callback := &mockSeriesLifecycleCallback{maxSeries: 2}
+	h := newOutOfOrderHead(callback)
+
+	series1 := labels.FromStrings(labels.MetricName, "test", "series", "1")
+	series2 := labels.FromStrings(labels.MetricName, "test", "series", "2")
+	series3 := labels.FromStrings(labels.MetricName, "test", "series", "3")
+	series4 := labels.FromStrings(labels.MetricName, "test", "series", "4")
+
+	require.NoError(t, h.Append(series1, false, 10, 1))
+	require.NoError(t, h.Append(series1, false, 20, 1))
+	require.NoError(t, h.Append(series2, true, 10, 1))
+	require.NoError(t, h.Append(series3, false, 10, 1))
+	assert.Equal(t, errMaxSeriesPerUserLimitExceeded, h.Append(series4, false, 10, 1))
+
+	// Series already in the TSDB head are not accounted.
+	assert.Equal(t, 2, h.NumAccountedSeries())
+	assert.Equal(t, []labels.Labels{series1, series3}, callback.created)
+
+	// Accounted series are released once the blocks they've been compacted into are loaded.
+	loaded := map[ulid.ULID]struct{}{}
+	loadedFn := func() map[ulid.ULID]struct{} { return loaded }
+
+	require.NoError(t, h.Compact(log.NewNopLogger(), dir, 100, true, loadedFn))
+	assert.Equal(t, 2, h.NumAccountedSeries())
+	assert.Empty(t, callback.deleted)
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		loaded[ulid.MustParse(entry.Name())] = struct{}{}
+	}
+
+	require.NoError(t, h.Compact(log.NewNopLogger(), dir, 100, false, loadedFn))
+	assert.Equal(t, 0, h.NumAccountedSeries())
+	assert.ElementsMatch(t, []labels.Labels{series1, series3}, callback.deleted)
+}
+
+func TestOutOfOrderHead_ShouldReplayTheWALSamplesNotCompactedYet(t *testing.T) {
+	blocksDir := t.TempDir()
+	walDir := filepath.Join(t.TempDir(), outOfOrderWALDirName)
+	logger := log.NewNopLogger()
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")
+
+	series1 := labels.FromStrings(labels.MetricName, "test", "series", "1")
+	series2 := labels.FromStrings(labels.MetricName, "test", "series", "2")
+	series3 := labels.FromStrings(labels.MetricName, "test", "series", "3")
+	inHead := func(lbls labels.Labels) bool { return labels.Equal(lbls, series2) }
+
+	h := newOutOfOrderHead(nil)
+	require.NoError(t, h.OpenWAL(logger, walDir, inHead))
+	require.NoError(t, h.Append(series1, false, 20, 2))
+	require.NoError(t, h.Append(series1, false, 10, 1))
+	require.NoError(t, h.Append(series1, false, 10, 1))
+	require.NoError(t, h.Append(series2, true, 10, 3))
+	require.NoError(t, h.Close())
+
+	// Replayed series are accounted only if not in the TSDB head.
+	callback := &mockSeriesLifecycleCallback{maxSeries: 10}
+	h = newOutOfOrderHead(callback)
+	require.NoError(t, h.OpenWAL(logger, walDir, inHead))
+	assert.Equal(t, 3, h.NumSamples())
+	assert.Equal(t, 1, h.NumAccountedSeries())
+	assert.Equal(t, []labels.Labels{series1}, callback.created)
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series1.String(): {{t: 10, v: 1}, {t: 20, v: 2}},
+		series2.String(): {{t: 10, v: 3}},
+	}, querySamples(t, h.Querier(0, 100), matcher))
+
+	// Samples appended after the replay are written to the WAL too.
+	require.NoError(t, h.Append(series3, false, 30, 4))
+	require.NoError(t, h.Close())
+
+	h = newOutOfOrderHead(nil)
+	require.NoError(t, h.OpenWAL(logger, walDir, inHead))
+	assert.Equal(t, 4, h.NumSamples())
+
+	// Once compacted into blocks, samples are removed from the WAL, while the ones
+	// appended afterwards are kept.
+	require.NoError(t, h.Compact(logger, blocksDir, 100, true, func() map[ulid.ULID]struct{} { return nil }))
+	require.NoError(t, h.Append(series1, false, 40, 5))
+	require.NoError(t, h.Close())
+
+	h = newOutOfOrderHead(nil)
+	require.NoError(t, h.OpenWAL(logger, walDir, inHead))
+	assert.Equal(t, map[string][]outOfOrderSample{
+		series1.String(): {{t: 40, v: 5}},
+	}, querySamples(t, h.Querier(0, 100), matcher))
+	require.NoError(t, h.Close())
+}
+
+type mockSeriesLifecycleCallback struct {
+	maxSeries int
+	created   []labels.Labels
+	deleted   []labels.Labels
+}
+
+func (c *mockSeriesLifecycleCallback) PreCreation(labels.Labels) error {
+	if len(c.created)-len(c.deleted) >= c.maxSeries {
+		return errMaxSeriesPerUserLimitExceeded
+	}
+	return nil
+}
+
+func (c *mockSeriesLifecycleCallback) PostCreation(lbls labels.Labels) {
+	c.created = append(c.created, lbls)
+}
+
+func (c *mockSeriesLifecycleCallback) PostDeletion(lbls ...labels.Labels) {
+	c.deleted = append(c.deleted, lbls...)
+}
+
+func TestRangeStartForTimestamp(t *testing.T) {
+	assert.Equal(t, int64(0), rangeStartForTimestamp(0, 100))
+	assert.Equal(t, int64(0), rangeStartForTimestamp(99, 100))
+	assert.Equal(t, int64(100), rangeStartForTimestamp(100, 100))
+	assert.Equal(t, int64(-100), rangeStartForTimestamp(-1, 100))
+	assert.Equal(t, int64(-100), rangeStartForTimestamp(-100, 100))
+}
+
+func querySamples(t *testing.T, q storage.Querier, matchers ...*labels.Matcher) map[string][]outOfOrderSample {
+	result := map[string][]outOfOrderSample{}
+
+	set := q.Select(true, nil, matchers...)
+	for set.Next() {
+		series := set.At()
+		it := series.Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			result[series.Labels().String()] = append(result[series.Labels().String()], outOfOrderSample{t: ts, v: v})
+		}
+		require.NoError(t, it.Err())
+	}
+	require.NoError(t, set.Err())
+	require.NoError(t, q.Close())
+
+	return result
+}
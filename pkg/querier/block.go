@@ -1,6 +1,7 @@
 package querier
 
 import (
+	"io"
 	"math"
 	"sort"
 
@@ -8,10 +9,12 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 
 	"github.com/cortexproject/cortex/pkg/querier/series"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
 )
 
 func convertMatchersToLabelMatcher(matchers []*labels.Matcher) []storepb.LabelMatcher {
@@ -43,6 +46,9 @@ type blockQuerierSeriesSet struct {
 	series   []*storepb.Series
 	warnings storage.Warnings
 
+	// aggregates requested to the store-gateway, used to read the chunks of downsampled blocks.
+	aggrs []storepb.Aggr
+
 	// next response to process
 	next int
 
@@ -69,7 +75,7 @@ func (bqss *blockQuerierSeriesSet) Next() bool {
 		bqss.next++
 	}
 
-	bqss.currSeries = newBlockQuerierSeries(currLabels, currChunks)
+	bqss.currSeries = newBlockQuerierSeries(currLabels, currChunks, bqss.aggrs)
 	return true
 }
 
@@ -85,18 +91,190 @@ func (bqss *blockQuerierSeriesSet) Warnings() storage.Warnings {
 	return bqss.warnings
 }
 
+// blockStreamingQuerierSeriesSet is a storage.SeriesSet lazily reading the series from a store-gateway
+// Series() stream, so that the series don't have to be held in memory all together. It's used with
+// store-gateways sending the hints at the beginning of the stream, which have already been received.
+type blockStreamingQuerierSeriesSet struct {
+	stream     storegatewaypb.StoreGateway_SeriesClient
+	remoteAddr string
+
+	// aggregates requested to the store-gateway, used to read the chunks of downsampled blocks.
+	aggrs []storepb.Aggr
+
+	// Called for each series, returns an error if the series can't be accepted (eg. a limit is hit).
+	onSeries func(*storepb.Series) error
+
+	// Called once the stream has been fully consumed.
+	onEOF func()
+
+	// Called if the stream fails, with the error and the labels of the last returned series, to resume
+	// the stream from another store-gateway. It returns a nil set if the stream can't be resumed.
+	resume func(err error, after labels.Labels) (storage.SeriesSet, error)
+
+	// next received series, not returned yet
+	next *storepb.Series
+	done bool
+
+	// labels of the last returned series, received series up to them are skipped.
+	last labels.Labels
+	// series set resuming the stream, once it failed
+	resumed storage.SeriesSet
+
+	currSeries storage.Series
+	warnings   storage.Warnings
+	err        error
+}
+
+func newBlockStreamingQuerierSeriesSet(stream storegatewaypb.StoreGateway_SeriesClient, remoteAddr string, aggrs []storepb.Aggr, onSeries func(*storepb.Series) error, onEOF func(), resume func(error, labels.Labels) (storage.SeriesSet, error)) *blockStreamingQuerierSeriesSet {
+	return &blockStreamingQuerierSeriesSet{
+		stream:     stream,
+		remoteAddr: remoteAddr,
+		aggrs:      aggrs,
+		onSeries:   onSeries,
+		onEOF:      onEOF,
+		resume:     resume,
+	}
+}
+
+func (bqss *blockStreamingQuerierSeriesSet) Next() bool {
+	if bqss.resumed != nil {
+		return bqss.resumed.Next()
+	}
+
+	bqss.currSeries = nil
+
+	if bqss.next == nil {
+		bqss.next = bqss.receive()
+	}
+	if bqss.next == nil {
+		return bqss.err != nil && bqss.resumeStream()
+	}
+
+	currLabels := bqss.next.Labels
+	currChunks := bqss.next.Chunks
+
+	// Merge chunks for current series. Chunks may come in multiple responses, but as soon
+	// as the response has chunks for a new series, we can stop receiving. Series are sorted.
+	for {
+		bqss.next = bqss.receive()
+		if bqss.next == nil || labels.Compare(labelpb.ZLabelsToPromLabels(currLabels), labelpb.ZLabelsToPromLabels(bqss.next.Labels)) != 0 {
+			break
+		}
+
+		currChunks = append(currChunks, bqss.next.Chunks...)
+	}
+
+	// The series may be incomplete, so it's received again from the resumed stream.
+	if bqss.err != nil {
+		return bqss.resumeStream()
+	}
+
+	if err := bqss.onSeries(&storepb.Series{Labels: currLabels, Chunks: currChunks}); err != nil {
+		bqss.err = err
+		bqss.next = nil
+		bqss.done = true
+		return false
+	}
+
+	bqss.last = labelpb.ZLabelsToPromLabels(currLabels)
+	bqss.currSeries = newBlockQuerierSeries(bqss.last, currChunks, bqss.aggrs)
+	return true
+}
+
+// resumeStream resumes the failed stream from another store-gateway, skipping the series
+// already returned, and returns whether there's a next series.
+func (bqss *blockStreamingQuerierSeriesSet) resumeStream() bool {
+	err := bqss.err
+	bqss.err = errors.Wrapf(err, "failed to receive series from %s", bqss.remoteAddr)
+	if bqss.resume == nil {
+		return false
+	}
+
+	resumed, resumeErr := bqss.resume(err, bqss.last)
+	if resumeErr != nil {
+		bqss.err = errors.Wrapf(resumeErr, "failed to resume series stream from %s after error: %s", bqss.remoteAddr, err)
+		return false
+	}
+	if resumed == nil {
+		return false
+	}
+
+	bqss.err = nil
+	bqss.resumed = resumed
+	return bqss.resumed.Next()
+}
+
+// receive returns the next series received from the stream, or nil if the stream
+// has been fully consumed or an error occurred.
+func (bqss *blockStreamingQuerierSeriesSet) receive() *storepb.Series {
+	for !bqss.done {
+		resp, err := bqss.stream.Recv()
+		if err == io.EOF {
+			bqss.done = true
+			if bqss.onEOF != nil {
+				bqss.onEOF()
+			}
+			break
+		}
+		if err != nil {
+			bqss.err = err
+			bqss.done = true
+			break
+		}
+
+		// Response may either contain series or warning. Hints have already been received.
+		if s := resp.GetSeries(); s != nil {
+			// Skip the series already returned before resuming the stream.
+			if bqss.last != nil && labels.Compare(labelpb.ZLabelsToPromLabels(s.Labels), bqss.last) <= 0 {
+				continue
+			}
+
+			return s
+		}
+
+		if w := resp.GetWarning(); w != "" {
+			bqss.warnings = append(bqss.warnings, errors.New(w))
+		}
+	}
+
+	return nil
+}
+
+func (bqss *blockStreamingQuerierSeriesSet) At() storage.Series {
+	if bqss.resumed != nil {
+		return bqss.resumed.At()
+	}
+	return bqss.currSeries
+}
+
+func (bqss *blockStreamingQuerierSeriesSet) Err() error {
+	if bqss.resumed != nil {
+		return bqss.resumed.Err()
+	}
+	return bqss.err
+}
+
+func (bqss *blockStreamingQuerierSeriesSet) Warnings() storage.Warnings {
+	if bqss.resumed != nil {
+		return append(bqss.warnings, bqss.resumed.Warnings()...)
+	}
+	return bqss.warnings
+}
+
 // newBlockQuerierSeries makes a new blockQuerierSeries. Input labels must be already sorted by name.
-func newBlockQuerierSeries(lbls []labels.Label, chunks []storepb.AggrChunk) *blockQuerierSeries {
+// The aggregates are used to read the chunks of downsampled blocks, and are ignored for raw chunks.
+func newBlockQuerierSeries(lbls []labels.Label, chunks []storepb.AggrChunk, aggrs []storepb.Aggr) *blockQuerierSeries {
 	sort.Slice(chunks, func(i, j int) bool {
 		return chunks[i].MinTime < chunks[j].MinTime
 	})
 
-	return &blockQuerierSeries{labels: lbls, chunks: chunks}
+	return &blockQuerierSeries{labels: lbls, chunks: chunks, aggrs: aggrs}
 }
 
 type blockQuerierSeries struct {
 	labels labels.Labels
 	chunks []storepb.AggrChunk
+	aggrs  []storepb.Aggr
 }
 
 func (bqs *blockQuerierSeries) Labels() labels.Labels {
@@ -112,18 +290,76 @@ func (bqs *blockQuerierSeries) Iterator() chunkenc.Iterator {
 	its := make([]chunkenc.Iterator, 0, len(bqs.chunks))
 
 	for _, c := range bqs.chunks {
-		ch, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+		it, err := bqs.chunkIterator(c)
 		if err != nil {
-			return series.NewErrIterator(errors.Wrapf(err, "failed to initialize chunk from XOR encoded raw data (series: %v min time: %d max time: %d)", bqs.Labels(), c.MinTime, c.MaxTime))
+			return series.NewErrIterator(errors.Wrapf(err, "failed to initialize chunk from XOR encoded data (series: %v min time: %d max time: %d)", bqs.Labels(), c.MinTime, c.MaxTime))
 		}
 
-		it := ch.Iterator(nil)
 		its = append(its, it)
 	}
 
+	// Counter resets must be applied across chunks when reading downsampled counters.
+	if len(bqs.aggrs) == 1 && bqs.aggrs[0] == storepb.Aggr_COUNTER {
+		return downsample.NewApplyCounterResetsIterator(its...)
+	}
+
 	return newBlockQuerierSeriesIterator(bqs.Labels(), its)
 }
 
+// chunkIterator returns an iterator over the input chunk. Raw chunks are read as is, while the
+// chunks of downsampled blocks are read from the requested aggregate, or computed as the
+// average of the sum and count aggregates if both have been requested.
+func (bqs *blockQuerierSeries) chunkIterator(c storepb.AggrChunk) (chunkenc.Iterator, error) {
+	if c.Raw != nil || len(bqs.aggrs) == 0 {
+		return chunkIteratorFromData(c.Raw)
+	}
+
+	if len(bqs.aggrs) == 1 {
+		return chunkIteratorFromData(aggrChunk(c, bqs.aggrs[0]))
+	}
+
+	cntIt, err := chunkIteratorFromData(c.Count)
+	if err != nil {
+		return nil, err
+	}
+	sumIt, err := chunkIteratorFromData(c.Sum)
+	if err != nil {
+		return nil, err
+	}
+
+	return downsample.NewAverageChunkIterator(cntIt, sumIt), nil
+}
+
+func aggrChunk(c storepb.AggrChunk, aggr storepb.Aggr) *storepb.Chunk {
+	switch aggr {
+	case storepb.Aggr_COUNT:
+		return c.Count
+	case storepb.Aggr_SUM:
+		return c.Sum
+	case storepb.Aggr_MIN:
+		return c.Min
+	case storepb.Aggr_MAX:
+		return c.Max
+	case storepb.Aggr_COUNTER:
+		return c.Counter
+	default:
+		return nil
+	}
+}
+
+func chunkIteratorFromData(c *storepb.Chunk) (chunkenc.Iterator, error) {
+	if c == nil {
+		return nil, errors.New("missing chunk")
+	}
+
+	ch, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return ch.Iterator(nil), nil
+}
+
 func newBlockQuerierSeriesIterator(labels labels.Labels, its []chunkenc.Iterator) *blockQuerierSeriesIterator {
 	return &blockQuerierSeriesIterator{labels: labels, iterators: its, lastT: math.MinInt64}
 }
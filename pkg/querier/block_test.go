@@ -29,6 +29,7 @@ func TestBlockQuerierSeries(t *testing.T) {
 
 	tests := map[string]struct {
 		series          *storepb.Series
+		aggrs           []storepb.Aggr
 		expectedMetric  labels.Labels
 		expectedSamples []model.SamplePair
 		expectedErr     string
@@ -65,13 +66,65 @@ func TestBlockQuerierSeries(t *testing.T) {
 			expectedMetric: labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
 			expectedErr:    `cannot iterate chunk for series: {foo="bar"}: EOF`,
 		},
+		"should return the requested aggregate of downsampled chunks": {
+			series: &storepb.Series{
+				Labels: []labelpb.ZLabel{{Name: "foo", Value: "bar"}},
+				Chunks: []storepb.AggrChunk{
+					{
+						MinTime: minTimestamp.Unix() * 1000,
+						MaxTime: maxTimestamp.Unix() * 1000,
+						Max:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: mockTSDBChunkDataWithValues(5, 6)},
+						Min:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: mockTSDBChunkDataWithValues(1, 2)},
+					},
+				},
+			},
+			aggrs:          []storepb.Aggr{storepb.Aggr_MAX},
+			expectedMetric: labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			expectedSamples: []model.SamplePair{
+				{Timestamp: model.TimeFromUnixNano(time.Unix(1, 0).UnixNano()), Value: model.SampleValue(5)},
+				{Timestamp: model.TimeFromUnixNano(time.Unix(2, 0).UnixNano()), Value: model.SampleValue(6)},
+			},
+		},
+		"should return the average of downsampled chunks if both sum and count are requested": {
+			series: &storepb.Series{
+				Labels: []labelpb.ZLabel{{Name: "foo", Value: "bar"}},
+				Chunks: []storepb.AggrChunk{
+					{
+						MinTime: minTimestamp.Unix() * 1000,
+						MaxTime: maxTimestamp.Unix() * 1000,
+						Count:   &storepb.Chunk{Type: storepb.Chunk_XOR, Data: mockTSDBChunkDataWithValues(2, 4)},
+						Sum:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: mockTSDBChunkDataWithValues(6, 4)},
+					},
+				},
+			},
+			aggrs:          []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
+			expectedMetric: labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			expectedSamples: []model.SamplePair{
+				{Timestamp: model.TimeFromUnixNano(time.Unix(1, 0).UnixNano()), Value: model.SampleValue(3)},
+				{Timestamp: model.TimeFromUnixNano(time.Unix(2, 0).UnixNano()), Value: model.SampleValue(1)},
+			},
+		},
+		"should return raw chunks even if aggregates are requested": {
+			series: &storepb.Series{
+				Labels: []labelpb.ZLabel{{Name: "foo", Value: "bar"}},
+				Chunks: []storepb.AggrChunk{
+					{MinTime: minTimestamp.Unix() * 1000, MaxTime: maxTimestamp.Unix() * 1000, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: mockTSDBChunkData()}},
+				},
+			},
+			aggrs:          []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
+			expectedMetric: labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			expectedSamples: []model.SamplePair{
+				{Timestamp: model.TimeFromUnixNano(time.Unix(1, 0).UnixNano()), Value: model.SampleValue(1)},
+				{Timestamp: model.TimeFromUnixNano(time.Unix(2, 0).UnixNano()), Value: model.SampleValue(2)},
+			},
+		},
 	}
 
 	for testName, testData := range tests {
 		testData := testData
 
 		t.Run(testName, func(t *testing.T) {
-			series := newBlockQuerierSeries(labelpb.ZLabelsToPromLabels(testData.series.Labels), testData.series.Chunks)
+			series := newBlockQuerierSeries(labelpb.ZLabelsToPromLabels(testData.series.Labels), testData.series.Chunks, testData.aggrs)
 
 			assert.Equal(t, testData.expectedMetric, series.Labels())
 
@@ -98,14 +151,19 @@ func TestBlockQuerierSeries(t *testing.T) {
 }
 
 func mockTSDBChunkData() []byte {
+	return mockTSDBChunkDataWithValues(1, 2)
+}
+
+// mockTSDBChunkDataWithValues returns a chunk with the input values at 1s and 2s.
+func mockTSDBChunkDataWithValues(first, second float64) []byte {
 	chunk := chunkenc.NewXORChunk()
 	appender, err := chunk.Appender()
 	if err != nil {
 		panic(err)
 	}
 
-	appender.Append(time.Unix(1, 0).Unix()*1000, 1)
-	appender.Append(time.Unix(2, 0).Unix()*1000, 2)
+	appender.Append(time.Unix(1, 0).Unix()*1000, first)
+	appender.Append(time.Unix(2, 0).Unix()*1000, second)
 
 	return chunk.Bytes()
 }
@@ -299,7 +357,7 @@ func Benchmark_newBlockQuerierSeries(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		newBlockQuerierSeries(lbls, chunks)
+		newBlockQuerierSeries(lbls, chunks, nil)
 	}
 }
 
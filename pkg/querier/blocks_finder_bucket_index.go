@@ -45,6 +45,11 @@ func NewBucketIndexBlocksFinder(cfg BucketIndexBlocksFinderConfig, bkt objstore.
 	}
 }
 
+// GetIndex returns the bucket index of the tenant.
+func (f *BucketIndexBlocksFinder) GetIndex(ctx context.Context, userID string) (*bucketindex.Index, error) {
+	return f.loader.GetIndex(ctx, userID)
+}
+
 // GetBlocks implements BlocksFinder.
 func (f *BucketIndexBlocksFinder) GetBlocks(ctx context.Context, userID string, minT, maxT int64) (bucketindex.Blocks, map[ulid.ULID]*bucketindex.BlockDeletionMark, error) {
 	if f.State() != services.Running {
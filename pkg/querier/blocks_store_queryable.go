@@ -11,6 +11,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
 	"github.com/grafana/dskit/kv"
 	"github.com/grafana/dskit/ring"
@@ -28,12 +29,18 @@ import (
 	"github.com/thanos-io/thanos/pkg/strutil"
 	"go.uber.org/atomic"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	grpc_metadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	"github.com/cortexproject/cortex/pkg/querier/downsampling"
 	"github.com/cortexproject/cortex/pkg/querier/series"
 	"github.com/cortexproject/cortex/pkg/querier/stats"
 	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	"github.com/cortexproject/cortex/pkg/storegateway"
@@ -123,13 +130,22 @@ func newBlocksStoreQueryableMetrics(reg prometheus.Registerer) *blocksStoreQuery
 type BlocksStoreQueryable struct {
 	services.Service
 
-	stores          BlocksStoreSet
-	finder          BlocksFinder
-	consistency     *BlocksConsistencyChecker
-	logger          log.Logger
-	queryStoreAfter time.Duration
-	metrics         *blocksStoreQueryableMetrics
-	limits          BlocksStoreLimits
+	stores           BlocksStoreSet
+	finder           BlocksFinder
+	consistency      *BlocksConsistencyChecker
+	logger           log.Logger
+	queryStoreAfter  time.Duration
+	autoDownsampling bool
+	metrics          *blocksStoreQueryableMetrics
+	limits           BlocksStoreLimits
+
+	// If set, the blocks which fail to be queried because a store-gateway is unavailable
+	// are queried from another store-gateway.
+	storeGatewayFailover bool
+
+	// If set, the lazily consumed series streams failing because a store-gateway is
+	// unavailable are resumed from another store-gateway.
+	streamResume bool
 
 	// Subservices manager.
 	subservices        *services.Manager
@@ -142,6 +158,9 @@ func NewBlocksStoreQueryable(
 	consistency *BlocksConsistencyChecker,
 	limits BlocksStoreLimits,
 	queryStoreAfter time.Duration,
+	autoDownsampling bool,
+	storeGatewayFailover bool,
+	streamResume bool,
 	logger log.Logger,
 	reg prometheus.Registerer,
 ) (*BlocksStoreQueryable, error) {
@@ -151,15 +170,18 @@ func NewBlocksStoreQueryable(
 	}
 
 	q := &BlocksStoreQueryable{
-		stores:             stores,
-		finder:             finder,
-		consistency:        consistency,
-		queryStoreAfter:    queryStoreAfter,
-		logger:             logger,
-		subservices:        manager,
-		subservicesWatcher: services.NewFailureWatcher(),
-		metrics:            newBlocksStoreQueryableMetrics(reg),
-		limits:             limits,
+		stores:               stores,
+		finder:               finder,
+		consistency:          consistency,
+		queryStoreAfter:      queryStoreAfter,
+		autoDownsampling:     autoDownsampling,
+		storeGatewayFailover: storeGatewayFailover,
+		streamResume:         streamResume,
+		logger:               logger,
+		subservices:          manager,
+		subservicesWatcher:   services.NewFailureWatcher(),
+		metrics:              newBlocksStoreQueryableMetrics(reg),
+		limits:               limits,
 	}
 
 	q.Service = services.NewBasicService(q.starting, q.running, q.stopping)
@@ -183,9 +205,12 @@ func NewBlocksStoreQueryableFromConfig(querierCfg Config, gatewayCfg storegatewa
 	bucketClient = cachingBucket
 
 	// Create the blocks finder.
-	var finder BlocksFinder
+	var (
+		finder      BlocksFinder
+		indexLoader bucketIndexLoader
+	)
 	if storageCfg.BucketStore.BucketIndex.Enabled {
-		finder = NewBucketIndexBlocksFinder(BucketIndexBlocksFinderConfig{
+		indexFinder := NewBucketIndexBlocksFinder(BucketIndexBlocksFinderConfig{
 			IndexLoader: bucketindex.LoaderConfig{
 				CheckInterval:         time.Minute,
 				UpdateOnStaleInterval: storageCfg.BucketStore.SyncInterval,
@@ -195,6 +220,8 @@ func NewBlocksStoreQueryableFromConfig(querierCfg Config, gatewayCfg storegatewa
 			MaxStalePeriod:           storageCfg.BucketStore.BucketIndex.MaxStalePeriod,
 			IgnoreDeletionMarksDelay: storageCfg.BucketStore.IgnoreDeletionMarksDelay,
 		}, bucketClient, limits, logger, reg)
+
+		finder, indexLoader = indexFinder, indexFinder
 	} else {
 		finder = NewBucketScanBlocksFinder(BucketScanBlocksFinderConfig{
 			ScanInterval:             storageCfg.BucketStore.SyncInterval,
@@ -222,7 +249,23 @@ func NewBlocksStoreQueryableFromConfig(querierCfg Config, gatewayCfg storegatewa
 			return nil, errors.Wrap(err, "failed to create store-gateway ring client")
 		}
 
-		stores, err = newBlocksStoreReplicationSet(storesRing, gatewayCfg.ShardingStrategy, randomLoadBalancing, limits, querierCfg.StoreGatewayClient, logger, reg)
+		// The balanced sharding strategy queries first the store-gateways which have loaded the blocks placement.
+		var loads *storegateway.BalancedLoads
+		if gatewayCfg.ShardingStrategy == util.ShardingStrategyBalanced {
+			loadsBackend, err := kv.NewClient(
+				storesRingCfg.KVStore,
+				storegateway.GetLoadDescCodec(),
+				kv.RegistererWithKVName(prometheus.WrapRegistererWithPrefix("cortex_", reg), "querier-store-gateway-load"),
+				logger,
+			)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to create store-gateway load backend")
+			}
+
+			loads = storegateway.NewBalancedLoads(loadsBackend, "", gatewayCfg.ShardingRing.HeartbeatPeriod, gatewayCfg.ShardingRing.HeartbeatTimeout, logger)
+		}
+
+		stores, err = newBlocksStoreReplicationSet(storesRing, gatewayCfg.ShardingStrategy, randomLoadBalancing, querierCfg.AvailabilityZone, limits, indexLoader, loads, querierCfg.StoreGatewayClient, logger, reg)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create store set")
 		}
@@ -246,7 +289,7 @@ func NewBlocksStoreQueryableFromConfig(querierCfg Config, gatewayCfg storegatewa
 		reg,
 	)
 
-	return NewBlocksStoreQueryable(stores, finder, consistency, limits, querierCfg.QueryStoreAfter, logger, reg)
+	return NewBlocksStoreQueryable(stores, finder, consistency, limits, querierCfg.QueryStoreAfter, querierCfg.AutoDownsampling, querierCfg.AvailabilityZone != "", querierCfg.StoreGatewayStreamResumeEnabled, logger, reg)
 }
 
 func (q *BlocksStoreQueryable) starting(ctx context.Context) error {
@@ -286,17 +329,20 @@ func (q *BlocksStoreQueryable) Querier(ctx context.Context, mint, maxt int64) (s
 	}
 
 	return &blocksStoreQuerier{
-		ctx:             ctx,
-		minT:            mint,
-		maxT:            maxt,
-		userID:          userID,
-		finder:          q.finder,
-		stores:          q.stores,
-		metrics:         q.metrics,
-		limits:          q.limits,
-		consistency:     q.consistency,
-		logger:          q.logger,
-		queryStoreAfter: q.queryStoreAfter,
+		ctx:                  ctx,
+		minT:                 mint,
+		maxT:                 maxt,
+		userID:               userID,
+		finder:               q.finder,
+		stores:               q.stores,
+		metrics:              q.metrics,
+		limits:               q.limits,
+		consistency:          q.consistency,
+		logger:               q.logger,
+		queryStoreAfter:      q.queryStoreAfter,
+		autoDownsampling:     q.autoDownsampling,
+		storeGatewayFailover: q.storeGatewayFailover,
+		streamResume:         q.streamResume,
 	}, nil
 }
 
@@ -314,6 +360,22 @@ type blocksStoreQuerier struct {
 	// If set, the querier manipulates the max time to not be greater than
 	// "now - queryStoreAfter" so that most recent blocks are not queried.
 	queryStoreAfter time.Duration
+
+	// If set, the max resolution of the queried blocks is picked based on the query
+	// step, unless a max source resolution is explicitly requested.
+	autoDownsampling bool
+
+	// If set, the blocks which fail to be queried because a store-gateway is unavailable
+	// are queried from another store-gateway.
+	storeGatewayFailover bool
+
+	// If set, the lazily consumed series streams failing because a store-gateway is
+	// unavailable are resumed from another store-gateway.
+	streamResume bool
+
+	// Functions canceling the store-gateway streams lazily consumed by the returned series sets.
+	streamsMtx     sync.Mutex
+	streamsCancels []context.CancelFunc
 }
 
 // Select implements storage.Querier interface.
@@ -335,10 +397,10 @@ func (q *blocksStoreQuerier) LabelNames(matchers ...*labels.Matcher) ([]string,
 		convertedMatchers = convertMatchersToLabelMatcher(matchers)
 	)
 
-	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error) {
-		nameSets, warnings, queriedBlocks, err := q.fetchLabelNamesFromStore(spanCtx, clients, minT, maxT, convertedMatchers)
+	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, []ulid.ULID, error) {
+		nameSets, warnings, queriedBlocks, failedBlocks, err := q.fetchLabelNamesFromStore(spanCtx, clients, minT, maxT, convertedMatchers)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		resMtx.Lock()
@@ -346,10 +408,10 @@ func (q *blocksStoreQuerier) LabelNames(matchers ...*labels.Matcher) ([]string,
 		resWarnings = append(resWarnings, warnings...)
 		resMtx.Unlock()
 
-		return queriedBlocks, nil
+		return queriedBlocks, failedBlocks, nil
 	}
 
-	err := q.queryWithConsistencyCheck(spanCtx, spanLog, minT, maxT, queryFunc)
+	err := q.queryWithConsistencyCheck(spanCtx, spanLog, minT, maxT, nil, 0, queryFunc)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -370,10 +432,10 @@ func (q *blocksStoreQuerier) LabelValues(name string, matchers ...*labels.Matche
 		resultMtx sync.Mutex
 	)
 
-	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error) {
-		valueSets, warnings, queriedBlocks, err := q.fetchLabelValuesFromStore(spanCtx, name, clients, minT, maxT, matchers...)
+	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, []ulid.ULID, error) {
+		valueSets, warnings, queriedBlocks, failedBlocks, err := q.fetchLabelValuesFromStore(spanCtx, name, clients, minT, maxT, matchers...)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		resultMtx.Lock()
@@ -381,10 +443,10 @@ func (q *blocksStoreQuerier) LabelValues(name string, matchers ...*labels.Matche
 		resWarnings = append(resWarnings, warnings...)
 		resultMtx.Unlock()
 
-		return queriedBlocks, nil
+		return queriedBlocks, failedBlocks, nil
 	}
 
-	err := q.queryWithConsistencyCheck(spanCtx, spanLog, minT, maxT, queryFunc)
+	err := q.queryWithConsistencyCheck(spanCtx, spanLog, minT, maxT, nil, 0, queryFunc)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -393,6 +455,15 @@ func (q *blocksStoreQuerier) LabelValues(name string, matchers ...*labels.Matche
 }
 
 func (q *blocksStoreQuerier) Close() error {
+	q.streamsMtx.Lock()
+	defer q.streamsMtx.Unlock()
+
+	// Release the store-gateway streams which have not been fully consumed.
+	for _, cancel := range q.streamsCancels {
+		cancel()
+	}
+	q.streamsCancels = nil
+
 	return nil
 }
 
@@ -405,39 +476,51 @@ func (q *blocksStoreQuerier) selectSorted(sp *storage.SelectHints, matchers ...*
 		minT, maxT = sp.Start, sp.End
 	}
 
+	// The shard is sent to the store-gateways in the request hints, so that series are filtered
+	// by shard before their chunks are loaded. Blocks split by the compactor can also be skipped
+	// if they don't contain any series of the query shard.
+	shard, seriesMatchers, err := sharding.RemoveShardFromMatchers(matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
 	var (
-		convertedMatchers = convertMatchersToLabelMatcher(matchers)
+		convertedMatchers = convertMatchersToLabelMatcher(seriesMatchers)
 		resSeriesSets     = []storage.SeriesSet(nil)
 		resWarnings       = storage.Warnings(nil)
 
-		maxChunksLimit  = q.limits.MaxChunksPerQueryFromStore(q.userID)
-		leftChunksLimit = maxChunksLimit
+		// Given a single block is guaranteed to not be queried twice, the number of chunks
+		// fetched across all attempts can be checked against the limit (max == 0 means disabled).
+		maxChunksLimit = q.limits.MaxChunksPerQueryFromStore(q.userID)
+		numChunks      = atomic.NewInt32(0)
+
+		// Downsampled blocks are queried only up to the max resolution, reading the
+		// aggregates matching the function the series are selected for.
+		maxResolution = q.maxSourceResolution(sp)
+		aggrs         = []storepb.Aggr(nil)
 
 		resultMtx sync.Mutex
 	)
 
-	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error) {
-		seriesSets, queriedBlocks, warnings, numChunks, err := q.fetchSeriesFromStores(spanCtx, sp, clients, minT, maxT, matchers, convertedMatchers, maxChunksLimit, leftChunksLimit)
+	queryFunc := func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, []ulid.ULID, error) {
+		seriesSets, queriedBlocks, failedBlocks, warnings, err := q.fetchSeriesFromStores(spanCtx, sp, clients, minT, maxT, maxResolution, aggrs, shard, matchers, convertedMatchers, maxChunksLimit, numChunks)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		resultMtx.Lock()
-
 		resSeriesSets = append(resSeriesSets, seriesSets...)
 		resWarnings = append(resWarnings, warnings...)
-
-		// Given a single block is guaranteed to not be queried twice, we can safely decrease the number of
-		// chunks we can still read before hitting the limit (max == 0 means disabled).
-		if maxChunksLimit > 0 {
-			leftChunksLimit -= numChunks
-		}
 		resultMtx.Unlock()
 
-		return queriedBlocks, nil
+		return queriedBlocks, failedBlocks, nil
+	}
+
+	if maxResolution > 0 {
+		aggrs = aggrsFromHints(sp)
 	}
 
-	err := q.queryWithConsistencyCheck(spanCtx, spanLog, minT, maxT, queryFunc)
+	err = q.queryWithConsistencyCheck(spanCtx, spanLog, minT, maxT, shard, maxResolution, queryFunc)
 	if err != nil {
 		return storage.ErrSeriesSet(err)
 	}
@@ -451,8 +534,8 @@ func (q *blocksStoreQuerier) selectSorted(sp *storage.SelectHints, matchers ...*
 		resWarnings)
 }
 
-func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logger log.Logger, minT, maxT int64,
-	queryFunc func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, error)) error {
+func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logger log.Logger, minT, maxT int64, shard *astmapper.ShardAnnotation, maxResolution int64,
+	queryFunc func(clients map[BlocksStoreClient][]ulid.ULID, minT, maxT int64) ([]ulid.ULID, []ulid.ULID, error)) error {
 	// If queryStoreAfter is enabled, we do manipulate the query maxt to query samples up until
 	// now - queryStoreAfter, because the most recent time range is covered by ingesters. This
 	// optimization is particularly important for the blocks storage because can be used to skip
@@ -479,6 +562,14 @@ func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logg
 		return err
 	}
 
+	if shard != nil {
+		knownBlocks = filterBlocksByShard(knownBlocks, *shard)
+	}
+
+	// The store-gateway only queries the blocks it would pick for the max resolution, so
+	// we select the same ones in order to not fail the consistency check.
+	knownBlocks = selectBlocksByResolution(knownBlocks, minT, maxT, maxResolution)
+
 	if len(knownBlocks) == 0 {
 		q.metrics.storesHit.Observe(0)
 		level.Debug(logger).Log("msg", "no blocks found")
@@ -513,8 +604,10 @@ func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logg
 		level.Debug(logger).Log("msg", "found store-gateway instances to query", "num instances", len(clients), "attempt", attempt)
 
 		// Fetch series from stores. If an error occur we do not retry because retries
-		// are only meant to cover missing blocks.
-		queriedBlocks, err := queryFunc(clients, minT, maxT)
+		// are only meant to cover missing blocks, unless the store-gateway failover is
+		// enabled, in which case the blocks failed because of an unavailable store-gateway
+		// are returned and retried.
+		queriedBlocks, failedBlocks, err := queryFunc(clients, minT, maxT)
 		if err != nil {
 			return err
 		}
@@ -533,6 +626,10 @@ func (q *blocksStoreQuerier) queryWithConsistencyCheck(ctx context.Context, logg
 
 		// Ensure all expected blocks have been queried (during all tries done so far).
 		missingBlocks := q.consistency.Check(knownBlocks, knownDeletionMarks, resQueriedBlocks)
+
+		// The failed blocks should be queried from another store-gateway, even if they're
+		// not checked by the consistency check (eg. they have been recently uploaded).
+		missingBlocks = mergeMissingBlocks(missingBlocks, failedBlocks)
 		if len(missingBlocks) == 0 {
 			q.metrics.storesHit.Observe(float64(len(touchedStores)))
 			q.metrics.refetches.Observe(float64(attempt - 1))
@@ -557,44 +654,181 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 	clients map[BlocksStoreClient][]ulid.ULID,
 	minT int64,
 	maxT int64,
+	maxResolution int64,
+	aggrs []storepb.Aggr,
+	shard *astmapper.ShardAnnotation,
 	matchers []*labels.Matcher,
 	convertedMatchers []storepb.LabelMatcher,
 	maxChunksLimit int,
-	leftChunksLimit int,
-) ([]storage.SeriesSet, []ulid.ULID, storage.Warnings, int, error) {
+	numChunks *atomic.Int32,
+) ([]storage.SeriesSet, []ulid.ULID, []ulid.ULID, storage.Warnings, error) {
 	var (
 		reqCtx        = grpc_metadata.AppendToOutgoingContext(ctx, cortex_tsdb.TenantIDExternalLabel, q.userID)
-		g, gCtx       = errgroup.WithContext(reqCtx)
+		g             = errgroup.Group{}
 		mtx           = sync.Mutex{}
 		seriesSets    = []storage.SeriesSet(nil)
 		warnings      = storage.Warnings(nil)
 		queriedBlocks = []ulid.ULID(nil)
-		numChunks     = atomic.NewInt32(0)
+		failedBlocks  = []ulid.ULID(nil)
+		streaming     = false
 		spanLog       = spanlogger.FromContext(ctx)
 		queryLimiter  = limiter.QueryLimiterFromContextWithFallback(ctx)
 		reqStats      = stats.FromContext(ctx)
 	)
 
+	// The streams lazily consumed outlive this function, so they're not bound to the
+	// errgroup context but canceled on error or once the querier is closed.
+	streamsCtx, cancelStreams := context.WithCancel(reqCtx)
+
+	// checkSeries applies the query limits to a received series and tracks it in the query stats.
+	checkSeries := func(s *storepb.Series) error {
+		// Add series fingerprint to query limiter; will return error if we are over the limit
+		limitErr := queryLimiter.AddSeries(cortexpb.FromLabelsToLabelAdapters(s.PromLabels()))
+		if limitErr != nil {
+			return validation.LimitError(limitErr.Error())
+		}
+
+		// Ensure the max number of chunks limit hasn't been reached (max == 0 means disabled).
+		if maxChunksLimit > 0 {
+			actual := numChunks.Add(int32(len(s.Chunks)))
+			if actual > int32(maxChunksLimit) {
+				return validation.LimitError(fmt.Sprintf(errMaxChunksPerQueryLimit, util.LabelMatchersToString(matchers), maxChunksLimit))
+			}
+		}
+		chunksSize := countChunkBytes(s)
+		if chunkBytesLimitErr := queryLimiter.AddChunkBytes(chunksSize); chunkBytesLimitErr != nil {
+			return validation.LimitError(chunkBytesLimitErr.Error())
+		}
+		if chunkLimitErr := queryLimiter.AddChunks(len(s.Chunks)); chunkLimitErr != nil {
+			return validation.LimitError(chunkLimitErr.Error())
+		}
+
+		reqStats.AddFetchedSeries(1)
+		reqStats.AddFetchedChunkBytes(uint64(chunksSize))
+		return nil
+	}
+
+	// trackFetchedIndexBytes returns the function tracking the index bytes fetched by the store-gateway
+	// to serve the request, which are returned in the trailer once the stream has been fully consumed.
+	trackFetchedIndexBytes := func(trailer *grpc_metadata.MD) func() {
+		return func() {
+			reqStats.AddFetchedIndexBytes(storegateway.ParseFetchedIndexBytesTrailer(*trailer))
+		}
+	}
+
+	// See: https://github.com/prometheus/prometheus/pull/8050
+	// TODO(goutham): we should ideally be passing the hints down to the storage layer
+	// and let the TSDB return us data with no chunks as in prometheus#8050.
+	// But this is an acceptable workaround for now.
+	skipChunks := sp != nil && sp.Func == "series"
+
+	// resumeStream returns the function resuming a lazily consumed stream failed with an unavailable
+	// store-gateway, by querying its blocks from other store-gateways. The series are sorted, so the
+	// resumed streams skip the series already returned. A resumed stream can be resumed again.
+	var resumeStream func(blockIDs []ulid.ULID, exclude map[ulid.ULID][]string) func(error, labels.Labels) (storage.SeriesSet, error)
+	resumeStream = func(blockIDs []ulid.ULID, exclude map[ulid.ULID][]string) func(error, labels.Labels) (storage.SeriesSet, error) {
+		return func(streamErr error, after labels.Labels) (storage.SeriesSet, error) {
+			if !q.streamResume || !isStoreGatewayUnavailable(streamsCtx, streamErr) {
+				return nil, nil
+			}
+
+			clients, err := q.stores.GetClientsFor(q.userID, blockIDs, exclude)
+			if err != nil {
+				return nil, err
+			}
+
+			sets := make([]storage.SeriesSet, 0, len(clients))
+			for c, ids := range clients {
+				q.logStoreGatewayFailover(spanLog, c, ids, streamErr)
+
+				req, err := createSeriesRequest(minT, maxT, maxResolution, aggrs, convertedMatchers, shard, skipChunks, ids)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to create series request")
+				}
+
+				trailer := grpc_metadata.MD{}
+				stream, err := c.Series(streamsCtx, req, grpc.Trailer(&trailer))
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to fetch series from %s", c.RemoteAddress())
+				}
+
+				// The blocks have already been accounted as queried, so the store-gateway
+				// is expected to stream the series of all of them.
+				resp, err := stream.Recv()
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to receive series from %s", c.RemoteAddress())
+				}
+				if resp.GetHints() == nil {
+					return nil, fmt.Errorf("store-gateway %s did not send the series hints at the beginning of the stream", c.RemoteAddress())
+				}
+
+				hints := hintspb.SeriesResponseHints{}
+				if err := types.UnmarshalAny(resp.GetHints(), &hints); err != nil {
+					return nil, errors.Wrapf(err, "failed to unmarshal series hints from %s", c.RemoteAddress())
+				}
+
+				queried, err := convertBlockHintsToULIDs(hints.QueriedBlocks)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to parse queried block IDs from received hints")
+				}
+				for _, id := range ids {
+					if !containsULID(queried, id) {
+						return nil, fmt.Errorf("store-gateway %s did not query the block %s of the series stream to resume", c.RemoteAddress(), id.String())
+					}
+				}
+
+				nextExclude := make(map[ulid.ULID][]string, len(exclude))
+				for id, addrs := range exclude {
+					nextExclude[id] = addrs
+				}
+				for _, id := range ids {
+					nextExclude[id] = append(append([]string(nil), exclude[id]...), c.RemoteAddress())
+				}
+
+				set := newBlockStreamingQuerierSeriesSet(stream, c.RemoteAddress(), aggrs, checkSeries, trackFetchedIndexBytes(&trailer), resumeStream(ids, nextExclude))
+				set.last = after
+				sets = append(sets, set)
+			}
+
+			if len(sets) == 1 {
+				return sets[0], nil
+			}
+			return storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge), nil
+		}
+	}
+
 	// Concurrently fetch series from all clients.
 	for c, blockIDs := range clients {
 		// Change variables scope since it will be used in a goroutine.
 		c := c
 		blockIDs := blockIDs
 
-		g.Go(func() error {
-			// See: https://github.com/prometheus/prometheus/pull/8050
-			// TODO(goutham): we should ideally be passing the hints down to the storage layer
-			// and let the TSDB return us data with no chunks as in prometheus#8050.
-			// But this is an acceptable workaround for now.
-			skipChunks := sp != nil && sp.Func == "series"
+		g.Go(func() (err error) {
+			// Stop fetching from the other clients on error.
+			defer func() {
+				if err != nil {
+					cancelStreams()
+				}
+			}()
 
-			req, err := createSeriesRequest(minT, maxT, convertedMatchers, skipChunks, blockIDs)
+			req, err := createSeriesRequest(minT, maxT, maxResolution, aggrs, convertedMatchers, shard, skipChunks, blockIDs)
 			if err != nil {
 				return errors.Wrapf(err, "failed to create series request")
 			}
 
-			stream, err := c.Series(gCtx, req)
+			// The store-gateway returns the index bytes fetched to serve the request in the trailer.
+			trailer := grpc_metadata.MD{}
+			onEOF := trackFetchedIndexBytes(&trailer)
+
+			stream, err := c.Series(streamsCtx, req, grpc.Trailer(&trailer))
 			if err != nil {
+				if q.canFailover(streamsCtx, err) {
+					q.logStoreGatewayFailover(spanLog, c, blockIDs, err)
+					mtx.Lock()
+					failedBlocks = append(failedBlocks, blockIDs...)
+					mtx.Unlock()
+					return nil
+				}
 				return errors.Wrapf(err, "failed to fetch series from %s", c.RemoteAddress())
 			}
 
@@ -602,18 +836,27 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 			myWarnings := storage.Warnings(nil)
 			myQueriedBlocks := []ulid.ULID(nil)
 
-			for {
+			for first := true; ; first = false {
 				// Ensure the context hasn't been canceled in the meanwhile (eg. an error occurred
 				// in another goroutine).
-				if gCtx.Err() != nil {
-					return gCtx.Err()
+				if streamsCtx.Err() != nil {
+					return streamsCtx.Err()
 				}
 
 				resp, err := stream.Recv()
 				if err == io.EOF {
+					onEOF()
 					break
 				}
 				if err != nil {
+					// The store-gateway can be failed over only if nothing has been received yet.
+					if first && q.canFailover(streamsCtx, err) {
+						q.logStoreGatewayFailover(spanLog, c, blockIDs, err)
+						mtx.Lock()
+						failedBlocks = append(failedBlocks, blockIDs...)
+						mtx.Unlock()
+						return nil
+					}
 					return errors.Wrapf(err, "failed to receive series from %s", c.RemoteAddress())
 				}
 
@@ -621,25 +864,8 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 				if s := resp.GetSeries(); s != nil {
 					mySeries = append(mySeries, s)
 
-					// Add series fingerprint to query limiter; will return error if we are over the limit
-					limitErr := queryLimiter.AddSeries(cortexpb.FromLabelsToLabelAdapters(s.PromLabels()))
-					if limitErr != nil {
-						return validation.LimitError(limitErr.Error())
-					}
-
-					// Ensure the max number of chunks limit hasn't been reached (max == 0 means disabled).
-					if maxChunksLimit > 0 {
-						actual := numChunks.Add(int32(len(s.Chunks)))
-						if actual > int32(leftChunksLimit) {
-							return validation.LimitError(fmt.Sprintf(errMaxChunksPerQueryLimit, util.LabelMatchersToString(matchers), maxChunksLimit))
-						}
-					}
-					chunksSize := countChunkBytes(s)
-					if chunkBytesLimitErr := queryLimiter.AddChunkBytes(chunksSize); chunkBytesLimitErr != nil {
-						return validation.LimitError(chunkBytesLimitErr.Error())
-					}
-					if chunkLimitErr := queryLimiter.AddChunks(len(s.Chunks)); chunkLimitErr != nil {
-						return validation.LimitError(chunkLimitErr.Error())
+					if err := checkSeries(s); err != nil {
+						return err
 					}
 				}
 
@@ -659,25 +885,42 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 					}
 
 					myQueriedBlocks = append(myQueriedBlocks, ids...)
-				}
-			}
+					reqStats.AddTouchedBlocks(uint64(len(ids)))
+
+					// Store-gateways sending the series in batches send the hints at the beginning
+					// of the stream, so that the series can be lazily consumed while merging them.
+					if first {
+						level.Debug(spanLog).Log("msg", "streaming series from store-gateway",
+							"instance", c.RemoteAddress(),
+							"requested blocks", strings.Join(convertULIDsToString(blockIDs), " "),
+							"queried blocks", strings.Join(convertULIDsToString(myQueriedBlocks), " "))
+
+						exclude := make(map[ulid.ULID][]string, len(myQueriedBlocks))
+						for _, id := range myQueriedBlocks {
+							exclude[id] = []string{c.RemoteAddress()}
+						}
 
-			numSeries := len(mySeries)
-			chunkBytes := countChunkBytes(mySeries...)
+						mtx.Lock()
+						seriesSets = append(seriesSets, newBlockStreamingQuerierSeriesSet(stream, c.RemoteAddress(), aggrs, checkSeries, onEOF, resumeStream(myQueriedBlocks, exclude)))
+						queriedBlocks = append(queriedBlocks, myQueriedBlocks...)
+						streaming = true
+						mtx.Unlock()
 
-			reqStats.AddFetchedSeries(uint64(numSeries))
-			reqStats.AddFetchedChunkBytes(uint64(chunkBytes))
+						return nil
+					}
+				}
+			}
 
 			level.Debug(spanLog).Log("msg", "received series from store-gateway",
 				"instance", c.RemoteAddress(),
-				"fetched series", numSeries,
-				"fetched chunk bytes", chunkBytes,
+				"fetched series", len(mySeries),
+				"fetched chunk bytes", countChunkBytes(mySeries...),
 				"requested blocks", strings.Join(convertULIDsToString(blockIDs), " "),
 				"queried blocks", strings.Join(convertULIDsToString(myQueriedBlocks), " "))
 
 			// Store the result.
 			mtx.Lock()
-			seriesSets = append(seriesSets, &blockQuerierSeriesSet{series: mySeries})
+			seriesSets = append(seriesSets, &blockQuerierSeriesSet{series: mySeries, aggrs: aggrs})
 			warnings = append(warnings, myWarnings...)
 			queriedBlocks = append(queriedBlocks, myQueriedBlocks...)
 			mtx.Unlock()
@@ -688,10 +931,50 @@ func (q *blocksStoreQuerier) fetchSeriesFromStores(
 
 	// Wait until all client requests complete.
 	if err := g.Wait(); err != nil {
-		return nil, nil, nil, 0, err
+		cancelStreams()
+		return nil, nil, nil, nil, err
+	}
+
+	if streaming {
+		q.addStreamsCancel(cancelStreams)
+	} else {
+		cancelStreams()
+	}
+
+	return seriesSets, queriedBlocks, failedBlocks, warnings, nil
+}
+
+// addStreamsCancel registers the function canceling the store-gateway streams which are
+// lazily consumed, to be called once the querier is closed.
+func (q *blocksStoreQuerier) addStreamsCancel(cancel context.CancelFunc) {
+	q.streamsMtx.Lock()
+	defer q.streamsMtx.Unlock()
+
+	q.streamsCancels = append(q.streamsCancels, cancel)
+}
+
+// canFailover returns whether the blocks which failed to be queried from a store-gateway with the
+// input error can be queried from another store-gateway.
+func (q *blocksStoreQuerier) canFailover(ctx context.Context, err error) bool {
+	return q.storeGatewayFailover && isStoreGatewayUnavailable(ctx, err)
+}
+
+// isStoreGatewayUnavailable returns whether the input error has been returned by an unavailable
+// store-gateway, while the request is still ongoing.
+func isStoreGatewayUnavailable(ctx context.Context, err error) bool {
+	// The request has been canceled (eg. an error occurred in another goroutine).
+	if ctx.Err() != nil {
+		return false
 	}
 
-	return seriesSets, queriedBlocks, warnings, int(numChunks.Load()), nil
+	return status.Code(err) == codes.Unavailable
+}
+
+func (q *blocksStoreQuerier) logStoreGatewayFailover(logger log.Logger, c BlocksStoreClient, blockIDs []ulid.ULID, err error) {
+	level.Warn(logger).Log("msg", "failed to query store-gateway, blocks will be queried from another store-gateway",
+		"instance", c.RemoteAddress(),
+		"requested blocks", strings.Join(convertULIDsToString(blockIDs), " "),
+		"err", err)
 }
 
 func (q *blocksStoreQuerier) fetchLabelNamesFromStore(
@@ -700,7 +983,7 @@ func (q *blocksStoreQuerier) fetchLabelNamesFromStore(
 	minT int64,
 	maxT int64,
 	matchers []storepb.LabelMatcher,
-) ([][]string, storage.Warnings, []ulid.ULID, error) {
+) ([][]string, storage.Warnings, []ulid.ULID, []ulid.ULID, error) {
 	var (
 		reqCtx        = grpc_metadata.AppendToOutgoingContext(ctx, cortex_tsdb.TenantIDExternalLabel, q.userID)
 		g, gCtx       = errgroup.WithContext(reqCtx)
@@ -708,6 +991,7 @@ func (q *blocksStoreQuerier) fetchLabelNamesFromStore(
 		nameSets      = [][]string{}
 		warnings      = storage.Warnings(nil)
 		queriedBlocks = []ulid.ULID(nil)
+		failedBlocks  = []ulid.ULID(nil)
 		spanLog       = spanlogger.FromContext(ctx)
 	)
 
@@ -725,6 +1009,13 @@ func (q *blocksStoreQuerier) fetchLabelNamesFromStore(
 
 			namesResp, err := c.LabelNames(gCtx, req)
 			if err != nil {
+				if q.canFailover(gCtx, err) {
+					q.logStoreGatewayFailover(spanLog, c, blockIDs, err)
+					mtx.Lock()
+					failedBlocks = append(failedBlocks, blockIDs...)
+					mtx.Unlock()
+					return nil
+				}
 				return errors.Wrapf(err, "failed to fetch series from %s", c.RemoteAddress())
 			}
 
@@ -764,10 +1055,10 @@ func (q *blocksStoreQuerier) fetchLabelNamesFromStore(
 
 	// Wait until all client requests complete.
 	if err := g.Wait(); err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	return nameSets, warnings, queriedBlocks, nil
+	return nameSets, warnings, queriedBlocks, failedBlocks, nil
 }
 
 func (q *blocksStoreQuerier) fetchLabelValuesFromStore(
@@ -777,7 +1068,7 @@ func (q *blocksStoreQuerier) fetchLabelValuesFromStore(
 	minT int64,
 	maxT int64,
 	matchers ...*labels.Matcher,
-) ([][]string, storage.Warnings, []ulid.ULID, error) {
+) ([][]string, storage.Warnings, []ulid.ULID, []ulid.ULID, error) {
 	var (
 		reqCtx        = grpc_metadata.AppendToOutgoingContext(ctx, cortex_tsdb.TenantIDExternalLabel, q.userID)
 		g, gCtx       = errgroup.WithContext(reqCtx)
@@ -785,6 +1076,7 @@ func (q *blocksStoreQuerier) fetchLabelValuesFromStore(
 		valueSets     = [][]string{}
 		warnings      = storage.Warnings(nil)
 		queriedBlocks = []ulid.ULID(nil)
+		failedBlocks  = []ulid.ULID(nil)
 		spanLog       = spanlogger.FromContext(ctx)
 	)
 
@@ -802,6 +1094,13 @@ func (q *blocksStoreQuerier) fetchLabelValuesFromStore(
 
 			valuesResp, err := c.LabelValues(gCtx, req)
 			if err != nil {
+				if q.canFailover(gCtx, err) {
+					q.logStoreGatewayFailover(spanLog, c, blockIDs, err)
+					mtx.Lock()
+					failedBlocks = append(failedBlocks, blockIDs...)
+					mtx.Unlock()
+					return nil
+				}
 				return errors.Wrapf(err, "failed to fetch series from %s", c.RemoteAddress())
 			}
 
@@ -844,24 +1143,54 @@ func (q *blocksStoreQuerier) fetchLabelValuesFromStore(
 
 	// Wait until all client requests complete.
 	if err := g.Wait(); err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	return valueSets, warnings, queriedBlocks, nil
+	return valueSets, warnings, queriedBlocks, failedBlocks, nil
 }
 
-func createSeriesRequest(minT, maxT int64, matchers []storepb.LabelMatcher, skipChunks bool, blockIDs []ulid.ULID) (*storepb.SeriesRequest, error) {
+// mergeMissingBlocks returns the missing blocks, including the failed ones.
+func mergeMissingBlocks(missing, failed []ulid.ULID) []ulid.ULID {
+	for _, blockID := range failed {
+		if !containsULID(missing, blockID) {
+			missing = append(missing, blockID)
+		}
+	}
+	return missing
+}
+
+func containsULID(ids []ulid.ULID, id ulid.ULID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+func createSeriesRequest(minT, maxT, maxResolution int64, aggrs []storepb.Aggr, matchers []storepb.LabelMatcher, shard *astmapper.ShardAnnotation, skipChunks bool, blockIDs []ulid.ULID) (*storepb.SeriesRequest, error) {
 	// Selectively query only specific blocks.
-	hints := &hintspb.SeriesRequestHints{
-		BlockMatchers: []storepb.LabelMatcher{
-			{
-				Type:  storepb.LabelMatcher_RE,
-				Name:  block.BlockIDLabel,
-				Value: strings.Join(convertULIDsToString(blockIDs), "|"),
-			},
+	blockMatchers := []storepb.LabelMatcher{
+		{
+			Type:  storepb.LabelMatcher_RE,
+			Name:  block.BlockIDLabel,
+			Value: strings.Join(convertULIDsToString(blockIDs), "|"),
 		},
 	}
 
+	// Selectively query only the series of the shard. The shard is only supported by the
+	// Cortex hints, so the Thanos ones are sent when the query is not sharded.
+	var hints proto.Message = &hintspb.SeriesRequestHints{BlockMatchers: blockMatchers}
+	if shard != nil {
+		hints = &storegatewaypb.SeriesRequestHints{
+			BlockMatchers: blockMatchers,
+			ShardInfo: &storegatewaypb.ShardInfo{
+				ShardIndex:  int64(shard.Shard),
+				TotalShards: int64(shard.Of),
+			},
+		}
+	}
+
 	anyHints, err := types.MarshalAny(hints)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to marshal series request hints")
@@ -874,6 +1203,8 @@ func createSeriesRequest(minT, maxT int64, matchers []storepb.LabelMatcher, skip
 		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
 		Hints:                   anyHints,
 		SkipChunks:              skipChunks,
+		MaxResolutionWindow:     maxResolution,
+		Aggregates:              aggrs,
 	}, nil
 }
 
@@ -957,6 +1288,138 @@ func convertBlockHintsToULIDs(hints []hintspb.Block) ([]ulid.ULID, error) {
 	return res, nil
 }
 
+// filterBlocksByShard removes the blocks split by the compactor which can't contain any series
+// belonging to the query shard. Blocks not split, or whose shard ID can't be parsed, are kept.
+func filterBlocksByShard(blocks bucketindex.Blocks, shard astmapper.ShardAnnotation) bucketindex.Blocks {
+	filtered := make(bucketindex.Blocks, 0, len(blocks))
+
+	for _, b := range blocks {
+		if b.CompactorShardID != "" {
+			if blockShard, err := astmapper.ParseShard(b.CompactorShardID); err == nil && !sharding.ShardsOverlap(shard, blockShard) {
+				continue
+			}
+		}
+
+		filtered = append(filtered, b)
+	}
+
+	return filtered
+}
+
+// maxSourceResolution returns the max resolution (in milliseconds) of the blocks to query. The resolution
+// explicitly requested via the max_source_resolution parameter takes precedence over the automatic one.
+func (q *blocksStoreQuerier) maxSourceResolution(sp *storage.SelectHints) int64 {
+	var step int64
+	if sp != nil {
+		step = sp.Step
+	}
+
+	if resolution, ok := downsampling.FromContext(q.ctx); ok {
+		return resolution.ForStep(step)
+	}
+
+	if q.autoDownsampling {
+		return downsampling.MaxSourceResolution{Auto: true}.ForStep(step)
+	}
+
+	return 0
+}
+
+// aggrsFromHints returns the aggregates to read from downsampled blocks, based on the function the
+// series are selected for. The sum and count aggregates are read to compute the average by default.
+func aggrsFromHints(sp *storage.SelectHints) []storepb.Aggr {
+	var f string
+	if sp != nil {
+		f = sp.Func
+	}
+
+	switch {
+	case f == "min" || strings.HasPrefix(f, "min_"):
+		return []storepb.Aggr{storepb.Aggr_MIN}
+	case f == "max" || strings.HasPrefix(f, "max_"):
+		return []storepb.Aggr{storepb.Aggr_MAX}
+	case f == "count" || strings.HasPrefix(f, "count_"):
+		return []storepb.Aggr{storepb.Aggr_COUNT}
+	case strings.HasPrefix(f, "sum_"):
+		// A plain sum falls back to the default, because it aggregates across series and not over time.
+		return []storepb.Aggr{storepb.Aggr_SUM}
+	case f == "increase" || f == "rate" || f == "irate" || f == "resets":
+		return []storepb.Aggr{storepb.Aggr_COUNTER}
+	default:
+		return []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}
+	}
+}
+
+// selectBlocksByResolution selects the blocks to query within the time range, picking the lowest
+// resolution not greater than maxResolution and filling the time ranges not covered by it with higher
+// resolution blocks. It matches the selection done by the store-gateway.
+func selectBlocksByResolution(blocks bucketindex.Blocks, minT, maxT, maxResolution int64) bucketindex.Blocks {
+	byResolution := map[int64]bucketindex.Blocks{}
+	for _, b := range blocks {
+		byResolution[b.Resolution] = append(byResolution[b.Resolution], b)
+	}
+
+	// All the blocks are queried if there are only raw blocks.
+	if _, ok := byResolution[0]; ok && len(byResolution) == 1 {
+		return blocks
+	}
+
+	// Sort resolutions from the lowest one (largest window) to raw.
+	resolutions := make([]int64, 0, len(byResolution))
+	for resolution, resBlocks := range byResolution {
+		resolutions = append(resolutions, resolution)
+
+		sort.SliceStable(resBlocks, func(i, j int) bool {
+			return resBlocks[i].MinTime < resBlocks[j].MinTime
+		})
+	}
+	sort.Slice(resolutions, func(i, j int) bool {
+		return resolutions[i] > resolutions[j]
+	})
+
+	return selectBlocksByResolutionWithin(byResolution, resolutions, minT, maxT, maxResolution)
+}
+
+func selectBlocksByResolutionWithin(byResolution map[int64]bucketindex.Blocks, resolutions []int64, minT, maxT, maxResolution int64) (selected bucketindex.Blocks) {
+	if minT > maxT {
+		return nil
+	}
+
+	// Find the first resolution not greater than the max one.
+	i := 0
+	for ; i < len(resolutions) && resolutions[i] > maxResolution; i++ {
+	}
+
+	if i >= len(resolutions) {
+		return nil
+	}
+
+	start := minT
+	for _, b := range byResolution[resolutions[i]] {
+		// NOTE: Block intervals are half-open: [MinTime, MaxTime).
+		if b.MaxTime <= minT {
+			continue
+		}
+		if b.MinTime > maxT {
+			break
+		}
+
+		// Fill the gap before the block with higher resolution blocks.
+		if i+1 < len(resolutions) {
+			selected = append(selected, selectBlocksByResolutionWithin(byResolution, resolutions, start, b.MinTime-1, resolutions[i+1])...)
+		}
+
+		selected = append(selected, b)
+		start = b.MaxTime
+	}
+
+	if i+1 < len(resolutions) {
+		selected = append(selected, selectBlocksByResolutionWithin(byResolution, resolutions, start, maxT, resolutions[i+1])...)
+	}
+
+	return selected
+}
+
 // countChunkBytes returns the size of the chunks making up the provided series in bytes
 func countChunkBytes(series ...*storepb.Series) (count int) {
 	for _, s := range series {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/gogo/protobuf/types"
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/services"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
@@ -28,8 +29,15 @@ import (
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/weaveworks/common/user"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	"github.com/cortexproject/cortex/pkg/querier/downsampling"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/limiter"
@@ -655,6 +663,333 @@ func TestBlocksStoreQuerier_Select(t *testing.T) {
 	}
 }
 
+func TestBlocksStoreQuerier_Select_ShouldFailoverUnavailableStoreGateways(t *testing.T) {
+	const (
+		metricName = "test_metric"
+		minT       = int64(10)
+		maxT       = int64(20)
+	)
+
+	var (
+		block1          = ulid.MustNew(1, nil)
+		block2          = ulid.MustNew(2, nil)
+		metricNameLabel = labels.Label{Name: labels.MetricName, Value: metricName}
+		series1Label    = labels.Label{Name: "series", Value: "1"}
+		series2Label    = labels.Label{Name: "series", Value: "2"}
+	)
+
+	tests := map[string]struct {
+		storeGatewayFailover bool
+		storeGatewayErr      error
+		expectedSeries       int
+		expectedErr          string
+	}{
+		"should query the blocks from another store-gateway if a store-gateway is unavailable": {
+			storeGatewayFailover: true,
+			storeGatewayErr:      status.Error(codes.Unavailable, "connection refused"),
+			expectedSeries:       2,
+		},
+		"should not failover if disabled": {
+			storeGatewayFailover: false,
+			storeGatewayErr:      status.Error(codes.Unavailable, "connection refused"),
+			expectedErr:          "failed to fetch series from 1.1.1.1: rpc error: code = Unavailable desc = connection refused",
+		},
+		"should not failover on errors other than unavailability": {
+			storeGatewayFailover: true,
+			storeGatewayErr:      status.Error(codes.Internal, "internal error"),
+			expectedErr:          "failed to fetch series from 1.1.1.1: rpc error: code = Internal desc = internal error",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := limiter.AddQueryLimiterToContext(context.Background(), limiter.NewQueryLimiter(0, 0, 0))
+			stores := &blocksStoreSetMock{mockedResponses: []interface{}{
+				map[BlocksStoreClient][]ulid.ULID{
+					&storeGatewayClientMock{remoteAddr: "1.1.1.1", mockedSeriesErr: testData.storeGatewayErr}: {block1},
+					&storeGatewayClientMock{remoteAddr: "2.2.2.2", mockedSeriesResponses: []*storepb.SeriesResponse{
+						mockSeriesResponse(labels.Labels{metricNameLabel, series2Label}, minT, 2),
+						mockHintsResponse(block2),
+					}}: {block2},
+				},
+				// The blocks of the unavailable store-gateway are queried from another one.
+				map[BlocksStoreClient][]ulid.ULID{
+					&storeGatewayClientMock{remoteAddr: "3.3.3.3", mockedSeriesResponses: []*storepb.SeriesResponse{
+						mockSeriesResponse(labels.Labels{metricNameLabel, series1Label}, minT, 1),
+						mockHintsResponse(block1),
+					}}: {block1},
+				},
+			}}
+
+			finder := &blocksFinderMock{}
+			finder.On("GetBlocks", mock.Anything, "user-1", minT, maxT).Return(bucketindex.Blocks{{ID: block1}, {ID: block2}}, map[ulid.ULID]*bucketindex.BlockDeletionMark(nil), error(nil))
+
+			q := &blocksStoreQuerier{
+				ctx:                  ctx,
+				minT:                 minT,
+				maxT:                 maxT,
+				userID:               "user-1",
+				finder:               finder,
+				stores:               stores,
+				consistency:          NewBlocksConsistencyChecker(0, 0, log.NewNopLogger(), nil),
+				logger:               log.NewNopLogger(),
+				metrics:              newBlocksStoreQueryableMetrics(nil),
+				limits:               &blocksStoreLimitsMock{},
+				storeGatewayFailover: testData.storeGatewayFailover,
+			}
+
+			set := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metricName))
+			if testData.expectedErr != "" {
+				require.Error(t, set.Err())
+				assert.Contains(t, set.Err().Error(), testData.expectedErr)
+				return
+			}
+
+			actualSeries := 0
+			for set.Next() {
+				actualSeries++
+			}
+			require.NoError(t, set.Err())
+			assert.Equal(t, testData.expectedSeries, actualSeries)
+		})
+	}
+}
+
+func TestBlocksStoreQuerier_Select_ShouldLazilyConsumeStreamedSeries(t *testing.T) {
+	const (
+		metricName = "test_metric"
+		minT       = int64(10)
+		maxT       = int64(20)
+	)
+
+	var (
+		block1  = ulid.MustNew(1, nil)
+		block2  = ulid.MustNew(2, nil)
+		series1 = labels.FromStrings(labels.MetricName, metricName, "series", "1")
+		series2 = labels.FromStrings(labels.MetricName, metricName, "series", "2")
+	)
+
+	tests := map[string]struct {
+		queryLimiter   *limiter.QueryLimiter
+		expectedSeries []labels.Labels
+		expectedErr    error
+	}{
+		"should merge the chunks of the same series while consuming the stream": {
+			queryLimiter:   limiter.NewQueryLimiter(0, 0, 0),
+			expectedSeries: []labels.Labels{series1, series2},
+		},
+		"should fail while consuming the stream if a limit is hit": {
+			queryLimiter:   limiter.NewQueryLimiter(1, 0, 0),
+			expectedSeries: []labels.Labels{series1},
+			expectedErr:    validation.LimitError(fmt.Sprintf(limiter.ErrMaxSeriesHit, 1)),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := limiter.AddQueryLimiterToContext(context.Background(), testData.queryLimiter)
+
+			// The store-gateway sends the hints before the series.
+			client := &storeGatewayStreamingClientMock{
+				storeGatewayClientMock: storeGatewayClientMock{remoteAddr: "1.1.1.1"},
+				stream: &storeGatewaySeriesClientMock{mockedResponses: []*storepb.SeriesResponse{
+					mockHintsResponse(block1, block2),
+					mockSeriesResponse(series1, minT, 1),
+					mockSeriesResponse(series1, minT+1, 2),
+					mockSeriesResponse(series2, minT, 3),
+				}},
+			}
+
+			finder := &blocksFinderMock{}
+			finder.On("GetBlocks", mock.Anything, "user-1", minT, maxT).Return(bucketindex.Blocks{{ID: block1}, {ID: block2}}, map[ulid.ULID]*bucketindex.BlockDeletionMark(nil), nil)
+
+			q := &blocksStoreQuerier{
+				ctx:    ctx,
+				minT:   minT,
+				maxT:   maxT,
+				userID: "user-1",
+				finder: finder,
+				stores: &blocksStoreSetMock{mockedResponses: []interface{}{
+					map[BlocksStoreClient][]ulid.ULID{client: {block1, block2}},
+				}},
+				consistency: NewBlocksConsistencyChecker(0, 0, log.NewNopLogger(), nil),
+				logger:      log.NewNopLogger(),
+				metrics:     newBlocksStoreQueryableMetrics(prometheus.NewPedanticRegistry()),
+				limits:      &blocksStoreLimitsMock{},
+			}
+
+			set := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metricName))
+			require.NoError(t, set.Err())
+
+			// Only the hints have been received so far.
+			assert.Len(t, client.stream.mockedResponses, 3)
+
+			var actualSeries []labels.Labels
+			for set.Next() {
+				actualSeries = append(actualSeries, set.At().Labels())
+
+				// The series with multiple chunks is merged.
+				if labels.Equal(set.At().Labels(), series1) {
+					assert.Len(t, set.At().(*blockQuerierSeries).chunks, 2)
+				}
+			}
+
+			if testData.expectedErr != nil {
+				assert.EqualError(t, set.Err(), testData.expectedErr.Error())
+				assert.IsType(t, set.Err(), testData.expectedErr)
+			} else {
+				require.NoError(t, set.Err())
+			}
+			assert.Equal(t, testData.expectedSeries, actualSeries)
+
+			// Closing the querier cancels the stream.
+			require.NoError(t, client.seriesCtx.Err())
+			require.NoError(t, q.Close())
+			assert.Error(t, client.seriesCtx.Err())
+		})
+	}
+}
+
+func TestBlocksStoreQuerier_Select_ShouldResumeFailedStreamedSeries(t *testing.T) {
+	const (
+		metricName = "test_metric"
+		minT       = int64(10)
+		maxT       = int64(20)
+	)
+
+	var (
+		block1  = ulid.MustNew(1, nil)
+		block2  = ulid.MustNew(2, nil)
+		series1 = labels.FromStrings(labels.MetricName, metricName, "series", "1")
+		series2 = labels.FromStrings(labels.MetricName, metricName, "series", "2")
+		series3 = labels.FromStrings(labels.MetricName, metricName, "series", "3")
+	)
+
+	tests := map[string]struct {
+		streamResume   bool
+		streamErr      error
+		resumedHints   *storepb.SeriesResponse
+		expectedSeries []labels.Labels
+		expectedErr    string
+	}{
+		"should resume the stream from another store-gateway if the store-gateway becomes unavailable": {
+			streamResume:   true,
+			streamErr:      status.Error(codes.Unavailable, "connection reset"),
+			resumedHints:   mockHintsResponse(block1, block2),
+			expectedSeries: []labels.Labels{series1, series2, series3},
+		},
+		"should not resume the stream if the stream resume is disabled": {
+			streamResume:   false,
+			streamErr:      status.Error(codes.Unavailable, "connection reset"),
+			resumedHints:   mockHintsResponse(block1, block2),
+			expectedSeries: []labels.Labels{series1},
+			expectedErr:    "failed to receive series from 1.1.1.1: rpc error: code = Unavailable desc = connection reset",
+		},
+		"should not resume the stream on errors other than unavailability": {
+			streamResume:   true,
+			streamErr:      status.Error(codes.Internal, "internal error"),
+			resumedHints:   mockHintsResponse(block1, block2),
+			expectedSeries: []labels.Labels{series1},
+			expectedErr:    "failed to receive series from 1.1.1.1: rpc error: code = Internal desc = internal error",
+		},
+		"should fail if the store-gateway resuming the stream doesn't query all the blocks": {
+			streamResume:   true,
+			streamErr:      status.Error(codes.Unavailable, "connection reset"),
+			resumedHints:   mockHintsResponse(block1),
+			expectedSeries: []labels.Labels{series1},
+			expectedErr:    "did not query the block " + block2.String(),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := limiter.AddQueryLimiterToContext(context.Background(), limiter.NewQueryLimiter(0, 0, 0))
+
+			// The store-gateway fails after having sent some of the chunks of the second series.
+			failing := &storeGatewayStreamingClientMock{
+				storeGatewayClientMock: storeGatewayClientMock{remoteAddr: "1.1.1.1"},
+				stream: &storeGatewaySeriesClientMock{mockedResponses: []*storepb.SeriesResponse{
+					mockHintsResponse(block1, block2),
+					mockSeriesResponse(series1, minT, 1),
+					mockSeriesResponse(series2, minT, 2),
+				}, mockedErr: testData.streamErr},
+			}
+			resuming := &storeGatewayStreamingClientMock{
+				storeGatewayClientMock: storeGatewayClientMock{remoteAddr: "2.2.2.2"},
+				stream: &storeGatewaySeriesClientMock{mockedResponses: []*storepb.SeriesResponse{
+					testData.resumedHints,
+					mockSeriesResponse(series1, minT, 1),
+					mockSeriesResponse(series2, minT, 2),
+					mockSeriesResponse(series2, minT+1, 3),
+					mockSeriesResponse(series3, minT, 4),
+				}},
+			}
+
+			finder := &blocksFinderMock{}
+			finder.On("GetBlocks", mock.Anything, "user-1", minT, maxT).Return(bucketindex.Blocks{{ID: block1}, {ID: block2}}, map[ulid.ULID]*bucketindex.BlockDeletionMark(nil), nil)
+
+			q := &blocksStoreQuerier{
+				ctx:    ctx,
+				minT:   minT,
+				maxT:   maxT,
+				userID: "user-1",
+				finder: finder,
+				stores: &blocksStoreSetMock{mockedResponses: []interface{}{
+					map[BlocksStoreClient][]ulid.ULID{failing: {block1, block2}},
+					map[BlocksStoreClient][]ulid.ULID{resuming: {block1, block2}},
+				}},
+				consistency:  NewBlocksConsistencyChecker(0, 0, log.NewNopLogger(), nil),
+				logger:       log.NewNopLogger(),
+				metrics:      newBlocksStoreQueryableMetrics(prometheus.NewPedanticRegistry()),
+				limits:       &blocksStoreLimitsMock{},
+				streamResume: testData.streamResume,
+				// The querier availability zone is not set, so the store-gateway failover is disabled.
+				storeGatewayFailover: false,
+			}
+
+			set := q.Select(true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metricName))
+			require.NoError(t, set.Err())
+
+			var actualSeries []labels.Labels
+			for set.Next() {
+				actualSeries = append(actualSeries, set.At().Labels())
+
+				// The series partially received before the failure is fully received from the resumed stream.
+				if labels.Equal(set.At().Labels(), series2) {
+					assert.Len(t, set.At().(*blockQuerierSeries).chunks, 2)
+				}
+			}
+
+			if testData.expectedErr != "" {
+				require.Error(t, set.Err())
+				assert.Contains(t, set.Err().Error(), testData.expectedErr)
+			} else {
+				require.NoError(t, set.Err())
+			}
+			assert.Equal(t, testData.expectedSeries, actualSeries)
+			require.NoError(t, q.Close())
+		})
+	}
+}
+
+func TestNewBlocksStoreQueryableFromConfig_ShouldEnableStreamResumeWithoutAvailabilityZone(t *testing.T) {
+	querierCfg := Config{}
+	flagext.DefaultValues(&querierCfg)
+	querierCfg.StoreGatewayAddresses = "localhost:9095"
+	require.Empty(t, querierCfg.AvailabilityZone)
+
+	storageCfg := cortex_tsdb.BlocksStorageConfig{}
+	flagext.DefaultValues(&storageCfg)
+	storageCfg.Bucket.Backend = bucket.Filesystem
+	storageCfg.Bucket.Filesystem.Directory = t.TempDir()
+
+	queryable, err := NewBlocksStoreQueryableFromConfig(querierCfg, storegateway.Config{}, storageCfg, &blocksStoreLimitsMock{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	assert.True(t, queryable.streamResume)
+	assert.False(t, queryable.storeGatewayFailover)
+}
+
 func TestBlocksStoreQuerier_Labels(t *testing.T) {
 	const (
 		metricName = "test_metric"
@@ -1201,6 +1536,186 @@ func TestBlocksStoreQuerier_SelectSortedShouldHonorQueryStoreAfter(t *testing.T)
 	}
 }
 
+func TestFilterBlocksByShard(t *testing.T) {
+	var (
+		block1 = &bucketindex.Block{ID: ulid.MustNew(1, nil)}
+		block2 = &bucketindex.Block{ID: ulid.MustNew(2, nil), CompactorShardID: "0_of_2"}
+		block3 = &bucketindex.Block{ID: ulid.MustNew(3, nil), CompactorShardID: "1_of_2"}
+		block4 = &bucketindex.Block{ID: ulid.MustNew(4, nil), CompactorShardID: "1_of_3"}
+		block5 = &bucketindex.Block{ID: ulid.MustNew(5, nil), CompactorShardID: "invalid"}
+	)
+
+	tests := map[string]struct {
+		shard    astmapper.ShardAnnotation
+		expected bucketindex.Blocks
+	}{
+		"same number of shards": {
+			shard:    astmapper.ShardAnnotation{Shard: 0, Of: 2},
+			expected: bucketindex.Blocks{block1, block2, block4, block5},
+		},
+		"query shards are a multiple of the compactor shards": {
+			shard:    astmapper.ShardAnnotation{Shard: 3, Of: 4},
+			expected: bucketindex.Blocks{block1, block3, block4, block5},
+		},
+		"query shards are not a multiple of the compactor shards": {
+			shard:    astmapper.ShardAnnotation{Shard: 0, Of: 3},
+			expected: bucketindex.Blocks{block1, block2, block3, block5},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			blocks := bucketindex.Blocks{block1, block2, block3, block4, block5}
+			assert.Equal(t, testData.expected, filterBlocksByShard(blocks, testData.shard))
+		})
+	}
+}
+
+func TestCreateSeriesRequest_ShouldSendTheShardInTheHints(t *testing.T) {
+	blockID := ulid.MustNew(1, nil)
+	matchers := []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: labels.MetricName, Value: "metric"}}
+
+	req, err := createSeriesRequest(0, 10, 0, nil, matchers, &astmapper.ShardAnnotation{Shard: 1, Of: 4}, false, []ulid.ULID{blockID})
+	require.NoError(t, err)
+	assert.Equal(t, matchers, req.Matchers)
+
+	hints := storegatewaypb.SeriesRequestHints{}
+	require.NoError(t, types.UnmarshalAny(req.Hints, &hints))
+	assert.Equal(t, &storegatewaypb.ShardInfo{ShardIndex: 1, TotalShards: 4}, hints.ShardInfo)
+
+	// The Thanos hints should be sent for non sharded queries.
+	req, err = createSeriesRequest(0, 10, 0, nil, matchers, nil, false, []ulid.ULID{blockID})
+	require.NoError(t, err)
+
+	thanosHints := hintspb.SeriesRequestHints{}
+	require.NoError(t, types.UnmarshalAny(req.Hints, &thanosHints))
+	assert.Len(t, thanosHints.BlockMatchers, 1)
+}
+
+func TestSelectBlocksByResolution(t *testing.T) {
+	const (
+		res5m = int64(5 * time.Minute / time.Millisecond)
+		res1h = int64(time.Hour / time.Millisecond)
+	)
+
+	var (
+		raw1 = &bucketindex.Block{ID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: 10}
+		raw2 = &bucketindex.Block{ID: ulid.MustNew(2, nil), MinTime: 10, MaxTime: 20}
+		raw3 = &bucketindex.Block{ID: ulid.MustNew(3, nil), MinTime: 20, MaxTime: 30}
+		ds1  = &bucketindex.Block{ID: ulid.MustNew(4, nil), MinTime: 0, MaxTime: 10, Resolution: res5m}
+		ds2  = &bucketindex.Block{ID: ulid.MustNew(5, nil), MinTime: 10, MaxTime: 20, Resolution: res5m}
+		ds3  = &bucketindex.Block{ID: ulid.MustNew(6, nil), MinTime: 0, MaxTime: 10, Resolution: res1h}
+	)
+
+	tests := map[string]struct {
+		blocks        bucketindex.Blocks
+		minT, maxT    int64
+		maxResolution int64
+		expected      bucketindex.Blocks
+	}{
+		"should return all blocks if there are only raw blocks": {
+			blocks:        bucketindex.Blocks{raw2, raw1},
+			minT:          0,
+			maxT:          30,
+			maxResolution: res1h,
+			expected:      bucketindex.Blocks{raw2, raw1},
+		},
+		"should only return raw blocks on max resolution 0": {
+			blocks:        bucketindex.Blocks{raw1, raw2, raw3, ds1, ds2, ds3},
+			minT:          0,
+			maxT:          30,
+			maxResolution: 0,
+			expected:      bucketindex.Blocks{raw1, raw2, raw3},
+		},
+		"should pick the 5m blocks and fill the gaps with raw blocks": {
+			blocks:        bucketindex.Blocks{raw1, raw2, raw3, ds1, ds2, ds3},
+			minT:          0,
+			maxT:          30,
+			maxResolution: res5m,
+			expected:      bucketindex.Blocks{ds1, ds2, raw3},
+		},
+		"should pick the lowest resolution available for each time range": {
+			blocks:        bucketindex.Blocks{raw1, raw2, raw3, ds1, ds2, ds3},
+			minT:          0,
+			maxT:          30,
+			maxResolution: res1h,
+			expected:      bucketindex.Blocks{ds3, ds2, raw3},
+		},
+		"should only return blocks within the time range": {
+			blocks:        bucketindex.Blocks{raw1, raw2, raw3, ds1, ds2, ds3},
+			minT:          12,
+			maxT:          18,
+			maxResolution: res1h,
+			expected:      bucketindex.Blocks{ds2},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testData.expected, selectBlocksByResolution(testData.blocks, testData.minT, testData.maxT, testData.maxResolution))
+		})
+	}
+}
+
+func TestBlocksStoreQuerier_MaxSourceResolution(t *testing.T) {
+	hints := &storage.SelectHints{Step: int64(time.Hour / time.Millisecond)}
+
+	tests := map[string]struct {
+		autoDownsampling bool
+		resolution       *downsampling.MaxSourceResolution
+		expected         int64
+	}{
+		"should query raw blocks by default": {
+			expected: 0,
+		},
+		"should pick the resolution based on the step if auto downsampling is enabled": {
+			autoDownsampling: true,
+			expected:         int64(12 * time.Minute / time.Millisecond),
+		},
+		"should honor the requested resolution": {
+			autoDownsampling: true,
+			resolution:       &downsampling.MaxSourceResolution{Millis: 0},
+			expected:         0,
+		},
+		"should pick the resolution based on the step if requested": {
+			resolution: &downsampling.MaxSourceResolution{Auto: true},
+			expected:   int64(12 * time.Minute / time.Millisecond),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			if testData.resolution != nil {
+				ctx = downsampling.InjectIntoContext(ctx, *testData.resolution)
+			}
+
+			q := &blocksStoreQuerier{ctx: ctx, autoDownsampling: testData.autoDownsampling}
+			assert.Equal(t, testData.expected, q.maxSourceResolution(hints))
+		})
+	}
+}
+
+func TestAggrsFromHints(t *testing.T) {
+	tests := map[string][]storepb.Aggr{
+		"":              {storepb.Aggr_COUNT, storepb.Aggr_SUM},
+		"sum":           {storepb.Aggr_COUNT, storepb.Aggr_SUM},
+		"avg_over_time": {storepb.Aggr_COUNT, storepb.Aggr_SUM},
+		"sum_over_time": {storepb.Aggr_SUM},
+		"min":           {storepb.Aggr_MIN},
+		"max_over_time": {storepb.Aggr_MAX},
+		"count":         {storepb.Aggr_COUNT},
+		"rate":          {storepb.Aggr_COUNTER},
+		"increase":      {storepb.Aggr_COUNTER},
+	}
+
+	for fn, expected := range tests {
+		t.Run(fn, func(t *testing.T) {
+			assert.Equal(t, expected, aggrsFromHints(&storage.SelectHints{Func: fn}))
+		})
+	}
+}
+
 func TestBlocksStoreQuerier_PromQLExecution(t *testing.T) {
 	block1 := ulid.MustNew(1, nil)
 	block2 := ulid.MustNew(2, nil)
@@ -1293,7 +1808,7 @@ func TestBlocksStoreQuerier_PromQLExecution(t *testing.T) {
 
 	// Instance the querier that will be executed to run the query.
 	logger := log.NewNopLogger()
-	queryable, err := NewBlocksStoreQueryable(stores, finder, NewBlocksConsistencyChecker(0, 0, logger, nil), &blocksStoreLimitsMock{}, 0, logger, nil)
+	queryable, err := NewBlocksStoreQueryable(stores, finder, NewBlocksConsistencyChecker(0, 0, logger, nil), &blocksStoreLimitsMock{}, 0, false, false, false, logger, nil)
 	require.NoError(t, err)
 	require.NoError(t, services.StartAndAwaitRunning(context.Background(), queryable))
 	defer services.StopAndAwaitTerminated(context.Background(), queryable) // nolint:errcheck
@@ -1361,11 +1876,16 @@ func (m *blocksFinderMock) GetBlocks(ctx context.Context, userID string, minT, m
 type storeGatewayClientMock struct {
 	remoteAddr                string
 	mockedSeriesResponses     []*storepb.SeriesResponse
+	mockedSeriesErr           error
 	mockedLabelNamesResponse  *storepb.LabelNamesResponse
 	mockedLabelValuesResponse *storepb.LabelValuesResponse
 }
 
 func (m *storeGatewayClientMock) Series(ctx context.Context, in *storepb.SeriesRequest, opts ...grpc.CallOption) (storegatewaypb.StoreGateway_SeriesClient, error) {
+	if m.mockedSeriesErr != nil {
+		return nil, m.mockedSeriesErr
+	}
+
 	seriesClient := &storeGatewaySeriesClientMock{
 		mockedResponses: m.mockedSeriesResponses,
 	}
@@ -1385,10 +1905,26 @@ func (m *storeGatewayClientMock) RemoteAddress() string {
 	return m.remoteAddr
 }
 
+// storeGatewayStreamingClientMock is a store-gateway client returning the mocked stream and
+// keeping track of the context of the Series() request.
+type storeGatewayStreamingClientMock struct {
+	storeGatewayClientMock
+
+	stream    *storeGatewaySeriesClientMock
+	seriesCtx context.Context
+}
+
+func (m *storeGatewayStreamingClientMock) Series(ctx context.Context, _ *storepb.SeriesRequest, _ ...grpc.CallOption) (storegatewaypb.StoreGateway_SeriesClient, error) {
+	m.seriesCtx = ctx
+	return m.stream, nil
+}
+
 type storeGatewaySeriesClientMock struct {
 	grpc.ClientStream
 
 	mockedResponses []*storepb.SeriesResponse
+	// Error returned once all the mocked responses have been received, instead of io.EOF.
+	mockedErr error
 }
 
 func (m *storeGatewaySeriesClientMock) Recv() (*storepb.SeriesResponse, error) {
@@ -1396,6 +1932,9 @@ func (m *storeGatewaySeriesClientMock) Recv() (*storepb.SeriesResponse, error) {
 	time.Sleep(10 * time.Millisecond)
 
 	if len(m.mockedResponses) == 0 {
+		if m.mockedErr != nil {
+			return nil, m.mockedErr
+		}
 		return nil, io.EOF
 	}
 
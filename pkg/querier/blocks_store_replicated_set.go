@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sort"
+	"sync"
 
 	"github.com/go-kit/log"
 	"github.com/grafana/dskit/ring"
@@ -12,19 +14,31 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
 type loadBalancingStrategy int
 
+const (
+	sameZone  = "same-zone"
+	crossZone = "cross-zone"
+)
+
 const (
 	noLoadBalancing = loadBalancingStrategy(iota)
 	randomLoadBalancing
 )
 
+// bucketIndexLoader loads the bucket index of a tenant.
+type bucketIndexLoader interface {
+	GetIndex(ctx context.Context, userID string) (*bucketindex.Index, error)
+}
+
 // BlocksStoreSet implementation used when the blocks are sharded and replicated across
 // a set of store-gateway instances.
 type blocksStoreReplicationSet struct {
@@ -36,6 +50,19 @@ type blocksStoreReplicationSet struct {
 	balancingStrategy loadBalancingStrategy
 	limits            BlocksStoreLimits
 
+	// The availability zone of the querier. If set, store-gateways in the same zone are preferred.
+	zone         string
+	zoneRequests *prometheus.CounterVec
+
+	// Used by the balanced sharding strategy only, to compute the blocks placement and to find out
+	// which store-gateways have loaded it.
+	indexLoader bucketIndexLoader
+	loads       *storegateway.BalancedLoads
+
+	// The last blocks placement computed for each tenant by the balanced sharding strategy.
+	assignmentsMx sync.Mutex
+	assignments   map[string]balancedAssignmentEntry
+
 	// Subservices manager.
 	subservices        *services.Manager
 	subservicesWatcher *services.FailureWatcher
@@ -45,7 +72,10 @@ func newBlocksStoreReplicationSet(
 	storesRing *ring.Ring,
 	shardingStrategy string,
 	balancingStrategy loadBalancingStrategy,
+	zone string,
 	limits BlocksStoreLimits,
+	indexLoader bucketIndexLoader,
+	loads *storegateway.BalancedLoads,
 	clientConfig ClientConfig,
 	logger log.Logger,
 	reg prometheus.Registerer,
@@ -56,10 +86,24 @@ func newBlocksStoreReplicationSet(
 		shardingStrategy:  shardingStrategy,
 		balancingStrategy: balancingStrategy,
 		limits:            limits,
+		zone:              zone,
+		indexLoader:       indexLoader,
+		loads:             loads,
+		assignments:       map[string]balancedAssignmentEntry{},
+
+		zoneRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_querier_storegateway_zone_requests_total",
+			Help: "Total number of requests sent to store-gateways running in the same availability zone of the querier or in another one. Tracked only if the querier availability zone is configured.",
+		}, []string{"locality"}),
+	}
+
+	subservices := []services.Service{s.storesRing, s.clientsPool}
+	if loads != nil {
+		subservices = append(subservices, loads)
 	}
 
 	var err error
-	s.subservices, err = services.NewManager(s.storesRing, s.clientsPool)
+	s.subservices, err = services.NewManager(subservices...)
 	if err != nil {
 		return nil, err
 	}
@@ -96,16 +140,37 @@ func (s *blocksStoreReplicationSet) stopping(_ error) error {
 
 func (s *blocksStoreReplicationSet) GetClientsFor(userID string, blockIDs []ulid.ULID, exclude map[ulid.ULID][]string) (map[BlocksStoreClient][]ulid.ULID, error) {
 	shards := map[string][]ulid.ULID{}
+	zones := map[string]string{}
 
 	// If shuffle sharding is enabled, we should build a subring for the user,
 	// otherwise we just use the full ring.
 	var userRing ring.ReadRing
-	if s.shardingStrategy == util.ShardingStrategyShuffle {
+	if s.shardingStrategy == util.ShardingStrategyShuffle || s.shardingStrategy == util.ShardingStrategyBalanced {
 		userRing = storegateway.GetShuffleShardingSubring(s.storesRing, userID, s.limits)
 	} else {
 		userRing = s.storesRing
 	}
 
+	if s.shardingStrategy == util.ShardingStrategyBalanced {
+		entry, err := s.getBalancedAssignment(userID, userRing)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get store-gateway blocks placement")
+		}
+
+		for _, blockID := range blockIDs {
+			// Pick a non excluded store-gateway instance.
+			instance, ok := getNonExcludedBalancedInstance(entry, s.loads, userID, blockID, exclude[blockID], s.balancingStrategy, s.zone)
+			if !ok {
+				return nil, fmt.Errorf("no store-gateway instance left after checking exclude for block %s", blockID.String())
+			}
+
+			shards[instance.Addr] = append(shards[instance.Addr], blockID)
+			zones[instance.Addr] = instance.Zone
+		}
+
+		return s.getClients(shards, zones)
+	}
+
 	// Find the replication set of each block we need to query.
 	for _, blockID := range blockIDs {
 		// Do not reuse the same buffer across multiple Get() calls because we do retain the
@@ -118,14 +183,19 @@ func (s *blocksStoreReplicationSet) GetClientsFor(userID string, blockIDs []ulid
 		}
 
 		// Pick a non excluded store-gateway instance.
-		addr := getNonExcludedInstanceAddr(set, exclude[blockID], s.balancingStrategy)
-		if addr == "" {
+		instance, ok := getNonExcludedInstance(set, exclude[blockID], s.balancingStrategy, s.zone)
+		if !ok {
 			return nil, fmt.Errorf("no store-gateway instance left after checking exclude for block %s", blockID.String())
 		}
 
-		shards[addr] = append(shards[addr], blockID)
+		shards[instance.Addr] = append(shards[instance.Addr], blockID)
+		zones[instance.Addr] = instance.Zone
 	}
 
+	return s.getClients(shards, zones)
+}
+
+func (s *blocksStoreReplicationSet) getClients(shards map[string][]ulid.ULID, zones map[string]string) (map[BlocksStoreClient][]ulid.ULID, error) {
 	clients := map[BlocksStoreClient][]ulid.ULID{}
 
 	// Get the client for each store-gateway.
@@ -138,10 +208,21 @@ func (s *blocksStoreReplicationSet) GetClientsFor(userID string, blockIDs []ulid
 		clients[c.(BlocksStoreClient)] = blockIDs
 	}
 
+	// Track the requests to store-gateways in the querier zone and in the other zones.
+	if s.zone != "" {
+		for addr := range shards {
+			if zones[addr] == s.zone {
+				s.zoneRequests.WithLabelValues(sameZone).Inc()
+			} else {
+				s.zoneRequests.WithLabelValues(crossZone).Inc()
+			}
+		}
+	}
+
 	return clients, nil
 }
 
-func getNonExcludedInstanceAddr(set ring.ReplicationSet, exclude []string, balancingStrategy loadBalancingStrategy) string {
+func getNonExcludedInstance(set ring.ReplicationSet, exclude []string, balancingStrategy loadBalancingStrategy, preferredZone string) (ring.InstanceDesc, bool) {
 	if balancingStrategy == randomLoadBalancing {
 		// Randomize the list of instances to not always query the same one.
 		rand.Shuffle(len(set.Instances), func(i, j int) {
@@ -149,11 +230,142 @@ func getNonExcludedInstanceAddr(set ring.ReplicationSet, exclude []string, balan
 		})
 	}
 
+	// Query the instances in the preferred zone first, and the other zones only on failure.
+	sortInstancesByZone(set.Instances, preferredZone)
+
 	for _, instance := range set.Instances {
 		if !util.StringsContain(exclude, instance.Addr) {
-			return instance.Addr
+			return instance, true
+		}
+	}
+
+	return ring.InstanceDesc{}, false
+}
+
+// sortInstancesByZone moves the instances running in the preferred zone at the beginning
+// of the input slice, preserving the order of the instances otherwise.
+func sortInstancesByZone(instances []ring.InstanceDesc, preferredZone string) {
+	if preferredZone == "" {
+		return
+	}
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].Zone == preferredZone && instances[j].Zone != preferredZone
+	})
+}
+
+type balancedAssignmentEntry struct {
+	idx        *bucketindex.Index
+	assignment *storegateway.BalancedAssignment
+
+	// The placement computed before the current one, if any. The owners of a block in the previous
+	// placement keep it until one of its current owners has loaded it.
+	previous *storegateway.BalancedAssignment
+}
+
+// getBalancedAssignment returns the placement of the blocks of the tenant computed by the balanced sharding
+// strategy, along with the previous one. The placement is computed again only when the bucket index or the
+// store-gateways change.
+func (s *blocksStoreReplicationSet) getBalancedAssignment(userID string, userRing ring.ReadRing) (balancedAssignmentEntry, error) {
+	if s.indexLoader == nil {
+		return balancedAssignmentEntry{}, errors.New("the balanced sharding strategy requires the bucket index to be enabled")
+	}
+
+	// The bucket index has already been loaded by the blocks finder, so it's returned from the
+	// in-memory cache, and it's the same index used to find the blocks to query.
+	idx, err := s.indexLoader.GetIndex(context.Background(), userID)
+	if err != nil {
+		return balancedAssignmentEntry{}, err
+	}
+
+	set, err := userRing.GetAllHealthy(storegateway.BlocksOwnerSync)
+	if err != nil {
+		return balancedAssignmentEntry{}, err
+	}
+
+	s.assignmentsMx.Lock()
+	entry, ok := s.assignments[userID]
+	s.assignmentsMx.Unlock()
+
+	if ok && entry.idx == idx && entry.assignment.HasInstances(set.Instances) {
+		return entry, nil
+	}
+
+	assignment, err := storegateway.AssignBlocksBalanced(userRing, idx)
+	if err != nil {
+		return balancedAssignmentEntry{}, err
+	}
+
+	entry = balancedAssignmentEntry{idx: idx, assignment: assignment, previous: entry.assignment}
+
+	s.assignmentsMx.Lock()
+	s.assignments[userID] = entry
+	s.assignmentsMx.Unlock()
+
+	return entry, nil
+}
+
+func getNonExcludedBalancedInstance(entry balancedAssignmentEntry, loads *storegateway.BalancedLoads, userID string, blockID ulid.ULID, exclude []string, balancingStrategy loadBalancingStrategy, preferredZone string) (ring.InstanceDesc, bool) {
+	candidates := entry.assignment.GetCandidates(blockID)
+	owners := entry.assignment.GetOwners(blockID)
+
+	// Query first the owners which have published that they have loaded the blocks placed on them,
+	// then the owners in the previous placement, which keep the block until then, and finally the
+	// other owners.
+	var loadedOwners, otherOwners, previousOwners []ring.InstanceDesc
+	for _, instance := range owners {
+		if loads.HasLoaded(instance.Addr, userID, entry.assignment.Version()) {
+			loadedOwners = append(loadedOwners, instance)
+		} else {
+			otherOwners = append(otherOwners, instance)
+		}
+	}
+
+	if entry.previous != nil {
+		for _, instance := range entry.previous.GetOwners(blockID) {
+			// Skip the instances which are not healthy in the ring anymore.
+			if containsInstanceAddr(candidates, instance.Addr) {
+				previousOwners = append(previousOwners, instance)
+			}
+		}
+	}
+
+	for _, instances := range [][]ring.InstanceDesc{loadedOwners, previousOwners, otherOwners} {
+		if balancingStrategy == randomLoadBalancing {
+			// Randomize the list of instances to not always query the same one.
+			rand.Shuffle(len(instances), func(i, j int) {
+				instances[i], instances[j] = instances[j], instances[i]
+			})
+		}
+
+		sortInstancesByZone(instances, preferredZone)
+
+		for _, instance := range instances {
+			if !util.StringsContain(exclude, instance.Addr) {
+				return instance, true
+			}
+		}
+	}
+
+	// The owners may not have loaded the block if they computed the placement from a different
+	// version of the bucket index, so we fallback to the other instances in preference order.
+	others := candidates[len(owners):]
+	sortInstancesByZone(others, preferredZone)
+
+	for _, instance := range others {
+		if !util.StringsContain(exclude, instance.Addr) {
+			return instance, true
 		}
 	}
 
-	return ""
+	return ring.InstanceDesc{}, false
+}
+
+func containsInstanceAddr(instances []ring.InstanceDesc, addr string) bool {
+	for _, instance := range instances {
+		if instance.Addr == addr {
+			return true
+		}
+	}
+	return false
 }
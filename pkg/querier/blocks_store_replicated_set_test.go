@@ -19,6 +19,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/test"
 )
@@ -349,7 +351,7 @@ func TestBlocksStoreReplicationSet_GetClientsFor(t *testing.T) {
 			}
 
 			reg := prometheus.NewPedanticRegistry()
-			s, err := newBlocksStoreReplicationSet(r, testData.shardingStrategy, noLoadBalancing, limits, ClientConfig{}, log.NewNopLogger(), reg)
+			s, err := newBlocksStoreReplicationSet(r, testData.shardingStrategy, noLoadBalancing, "", limits, nil, nil, ClientConfig{}, log.NewNopLogger(), reg)
 			require.NoError(t, err)
 			require.NoError(t, services.StartAndAwaitRunning(ctx, s))
 			defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
@@ -409,7 +411,7 @@ func TestBlocksStoreReplicationSet_GetClientsFor_ShouldSupportRandomLoadBalancin
 
 	limits := &blocksStoreLimitsMock{}
 	reg := prometheus.NewPedanticRegistry()
-	s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyDefault, randomLoadBalancing, limits, ClientConfig{}, log.NewNopLogger(), reg)
+	s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyDefault, randomLoadBalancing, "", limits, nil, nil, ClientConfig{}, log.NewNopLogger(), reg)
 	require.NoError(t, err)
 	require.NoError(t, services.StartAndAwaitRunning(ctx, s))
 	defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
@@ -442,6 +444,276 @@ func TestBlocksStoreReplicationSet_GetClientsFor_ShouldSupportRandomLoadBalancin
 	}
 }
 
+func TestBlocksStoreReplicationSet_GetClientsFor_ShouldPreferStoreGatewaysInTheQuerierZone(t *testing.T) {
+	const numRuns = 100
+
+	ctx := context.Background()
+	userID := "user-A"
+	registeredAt := time.Now()
+	block1 := ulid.MustNew(1, nil)
+
+	// Create a ring with a store-gateway per zone.
+	ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	require.NoError(t, ringStore.CAS(ctx, "test", func(in interface{}) (interface{}, bool, error) {
+		d := ring.NewDesc()
+		d.AddIngester("instance-1", "127.0.0.1", "zone-a", []uint32{1}, ring.ACTIVE, registeredAt)
+		d.AddIngester("instance-2", "127.0.0.2", "zone-b", []uint32{2}, ring.ACTIVE, registeredAt)
+		d.AddIngester("instance-3", "127.0.0.3", "zone-c", []uint32{3}, ring.ACTIVE, registeredAt)
+		return d, true, nil
+	}))
+
+	ringCfg := ring.Config{}
+	flagext.DefaultValues(&ringCfg)
+	ringCfg.ReplicationFactor = 3
+	ringCfg.ZoneAwarenessEnabled = true
+
+	r, err := ring.NewWithStoreClientAndStrategy(ringCfg, "test", "test", ringStore, ring.NewIgnoreUnhealthyInstancesReplicationStrategy(), nil, nil)
+	require.NoError(t, err)
+
+	limits := &blocksStoreLimitsMock{}
+	reg := prometheus.NewPedanticRegistry()
+	s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyDefault, randomLoadBalancing, "zone-b", limits, nil, nil, ClientConfig{}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, s))
+	defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
+
+	// Wait until the ring client has initialised the state.
+	test.Poll(t, time.Second, true, func() interface{} {
+		all, err := r.GetAllHealthy(ring.Read)
+		return err == nil && len(all.Instances) > 0
+	})
+
+	// The store-gateway in the querier zone should always be picked, regardless of the load balancing.
+	for n := 0; n < numRuns; n++ {
+		clients, err := s.GetClientsFor(userID, []ulid.ULID{block1}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]ulid.ULID{"127.0.0.2": {block1}}, getStoreGatewayClientAddrs(clients))
+	}
+
+	// When the store-gateway in the querier zone is excluded, a store-gateway in another zone should be picked.
+	clients, err := s.GetClientsFor(userID, []ulid.ULID{block1}, map[ulid.ULID][]string{block1: {"127.0.0.2"}})
+	require.NoError(t, err)
+	require.Len(t, clients, 1)
+	assert.NotContains(t, getStoreGatewayClientAddrs(clients), "127.0.0.2")
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+		# HELP cortex_querier_storegateway_zone_requests_total Total number of requests sent to store-gateways running in the same availability zone of the querier or in another one. Tracked only if the querier availability zone is configured.
+		# TYPE cortex_querier_storegateway_zone_requests_total counter
+		cortex_querier_storegateway_zone_requests_total{locality="cross-zone"} 1
+		cortex_querier_storegateway_zone_requests_total{locality="same-zone"} %d
+	`, numRuns)), "cortex_querier_storegateway_zone_requests_total"))
+}
+
+func TestBlocksStoreReplicationSet_GetClientsFor_ShouldSupportBalancedShardingStrategy(t *testing.T) {
+	// The following block IDs have been picked to have increasing hash values
+	// in order to simplify the tests.
+	block1 := ulid.MustNew(1, nil) // hash: 283204220
+	block2 := ulid.MustNew(2, nil) // hash: 444110359
+	block3 := ulid.MustNew(5, nil) // hash: 2931974232
+	block4 := ulid.MustNew(6, nil) // hash: 3092880371
+
+	block2Hash := cortex_tsdb.HashBlockID(block2)
+	block4Hash := cortex_tsdb.HashBlockID(block4)
+
+	// By ring token, block1 and block2 belong to instance-1 while block3 and block4 belong to instance-2,
+	// but block2 is placed on instance-2 because block1 and block2 are much bigger than the others.
+	idx := &bucketindex.Index{
+		Blocks: bucketindex.Blocks{
+			{ID: block1, IndexSize: 100},
+			{ID: block2, IndexSize: 100},
+			{ID: block3, IndexSize: 10},
+			{ID: block4, IndexSize: 10},
+		},
+	}
+
+	userID := "user-A"
+	registeredAt := time.Now()
+
+	tests := map[string]struct {
+		queryBlocks     []ulid.ULID
+		exclude         map[ulid.ULID][]string
+		expectedClients map[string][]ulid.ULID
+		expectedErr     error
+	}{
+		"should query the store-gateways owning the blocks": {
+			queryBlocks: []ulid.ULID{block1, block2, block3, block4},
+			expectedClients: map[string][]ulid.ULID{
+				"127.0.0.1": {block1},
+				"127.0.0.2": {block2, block3, block4},
+			},
+		},
+		"should fallback to the other store-gateways if the owner is excluded": {
+			queryBlocks: []ulid.ULID{block1, block2},
+			exclude: map[ulid.ULID][]string{
+				block2: {"127.0.0.2"},
+			},
+			expectedClients: map[string][]ulid.ULID{
+				"127.0.0.1": {block1, block2},
+			},
+		},
+		"should return error if all store-gateways are excluded": {
+			queryBlocks: []ulid.ULID{block2},
+			exclude: map[ulid.ULID][]string{
+				block2: {"127.0.0.1", "127.0.0.2"},
+			},
+			expectedErr: fmt.Errorf("no store-gateway instance left after checking exclude for block %s", block2.String()),
+		},
+	}
+
+	for testName, testData := range tests {
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			// Setup the ring state.
+			ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+			t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+			require.NoError(t, ringStore.CAS(ctx, "test", func(in interface{}) (interface{}, bool, error) {
+				d := ring.NewDesc()
+				d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+				return d, true, nil
+			}))
+
+			ringCfg := ring.Config{}
+			flagext.DefaultValues(&ringCfg)
+			ringCfg.ReplicationFactor = 1
+
+			r, err := ring.NewWithStoreClientAndStrategy(ringCfg, "test", "test", ringStore, ring.NewIgnoreUnhealthyInstancesReplicationStrategy(), nil, nil)
+			require.NoError(t, err)
+
+			reg := prometheus.NewPedanticRegistry()
+			s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyBalanced, noLoadBalancing, "", &blocksStoreLimitsMock{}, &bucketIndexLoaderMock{idx: idx}, nil, ClientConfig{}, log.NewNopLogger(), reg)
+			require.NoError(t, err)
+			require.NoError(t, services.StartAndAwaitRunning(ctx, s))
+			defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
+
+			// Wait until the ring client has initialised the state.
+			test.Poll(t, time.Second, true, func() interface{} {
+				all, err := r.GetAllHealthy(ring.Read)
+				return err == nil && len(all.Instances) > 0
+			})
+
+			clients, err := s.GetClientsFor(userID, testData.queryBlocks, testData.exclude)
+			assert.Equal(t, testData.expectedErr, err)
+
+			if testData.expectedErr == nil {
+				assert.Equal(t, testData.expectedClients, getStoreGatewayClientAddrs(clients))
+			}
+		})
+	}
+}
+
+func TestBlocksStoreReplicationSet_GetClientsFor_ShouldQueryThePreviousOwnersUntilTheBalancedPlacementIsLoaded(t *testing.T) {
+	block1 := ulid.MustNew(1, nil) // hash: 283204220
+	block2 := ulid.MustNew(2, nil) // hash: 444110359
+	block3 := ulid.MustNew(5, nil) // hash: 2931974232
+	block4 := ulid.MustNew(6, nil) // hash: 3092880371
+
+	block2Hash := cortex_tsdb.HashBlockID(block2)
+	block4Hash := cortex_tsdb.HashBlockID(block4)
+
+	// By ring token, block1 and block2 belong to instance-1 while block3 and block4 belong to instance-2.
+	// Once block1 and block2 grow much bigger than the others, block2 is moved to instance-2.
+	prevIdx := &bucketindex.Index{
+		Blocks: bucketindex.Blocks{
+			{ID: block1, IndexSize: 10},
+			{ID: block2, IndexSize: 10},
+			{ID: block3, IndexSize: 10},
+			{ID: block4, IndexSize: 10},
+		},
+		UpdatedAt: 100,
+	}
+	currIdx := &bucketindex.Index{
+		Blocks: bucketindex.Blocks{
+			{ID: block1, IndexSize: 100},
+			{ID: block2, IndexSize: 100},
+			{ID: block3, IndexSize: 10},
+			{ID: block4, IndexSize: 10},
+		},
+		UpdatedAt: 200,
+	}
+
+	userID := "user-A"
+	ctx := context.Background()
+
+	ringStore, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	require.NoError(t, ringStore.CAS(ctx, "test", func(in interface{}) (interface{}, bool, error) {
+		d := ring.NewDesc()
+		d.AddIngester("instance-1", "127.0.0.1", "", []uint32{block2Hash + 1}, ring.ACTIVE, time.Now())
+		d.AddIngester("instance-2", "127.0.0.2", "", []uint32{block4Hash + 1}, ring.ACTIVE, time.Now())
+		return d, true, nil
+	}))
+
+	ringCfg := ring.Config{}
+	flagext.DefaultValues(&ringCfg)
+	ringCfg.ReplicationFactor = 1
+
+	r, err := ring.NewWithStoreClientAndStrategy(ringCfg, "test", "test", ringStore, ring.NewIgnoreUnhealthyInstancesReplicationStrategy(), nil, nil)
+	require.NoError(t, err)
+
+	loadsStore, loadsCloser := consul.NewInMemoryClient(storegateway.GetLoadDescCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, loadsCloser.Close()) })
+	loads := storegateway.NewBalancedLoads(loadsStore, "", 10*time.Millisecond, time.Minute, log.NewNopLogger())
+
+	loader := &bucketIndexLoaderMock{idx: prevIdx}
+	s, err := newBlocksStoreReplicationSet(r, util.ShardingStrategyBalanced, noLoadBalancing, "", &blocksStoreLimitsMock{}, loader, loads, ClientConfig{}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, s))
+	defer services.StopAndAwaitTerminated(ctx, s) //nolint:errcheck
+
+	// Wait until the ring client has initialised the state.
+	test.Poll(t, time.Second, true, func() interface{} {
+		all, err := r.GetAllHealthy(ring.Read)
+		return err == nil && len(all.Instances) > 0
+	})
+
+	clients, err := s.GetClientsFor(userID, []ulid.ULID{block2}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]ulid.ULID{"127.0.0.1": {block2}}, getStoreGatewayClientAddrs(clients))
+
+	// The previous owner of block2 is queried until its new owner has loaded the new placement.
+	loader.idx = currIdx
+
+	clients, err = s.GetClientsFor(userID, []ulid.ULID{block2}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]ulid.ULID{"127.0.0.1": {block2}}, getStoreGatewayClientAddrs(clients))
+
+	assignment, err := storegateway.AssignBlocksBalanced(r, currIdx)
+	require.NoError(t, err)
+
+	require.NoError(t, loadsStore.CAS(ctx, storegateway.LoadKey, func(in interface{}) (interface{}, bool, error) {
+		desc := storegateway.NewLoadDesc()
+		desc.Instances["127.0.0.2"] = storegateway.InstanceLoad{
+			Timestamp:        time.Now().Unix(),
+			LoadedPlacements: map[string]storegateway.PlacementVersion{userID: assignment.Version()},
+		}
+		return desc, true, nil
+	}))
+
+	test.Poll(t, time.Second, map[string][]ulid.ULID{"127.0.0.2": {block2}}, func() interface{} {
+		clients, err := s.GetClientsFor(userID, []ulid.ULID{block2}, nil)
+		require.NoError(t, err)
+		return getStoreGatewayClientAddrs(clients)
+	})
+}
+
+type bucketIndexLoaderMock struct {
+	idx *bucketindex.Index
+}
+
+func (m *bucketIndexLoaderMock) GetIndex(_ context.Context, _ string) (*bucketindex.Index, error) {
+	return m.idx, nil
+}
+
 func getStoreGatewayClientAddrs(clients map[BlocksStoreClient][]ulid.ULID) map[string][]ulid.ULID {
 	addrs := map[string][]ulid.ULID{}
 	for c, blockIDs := range clients {
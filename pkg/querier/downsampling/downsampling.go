@@ -0,0 +1,69 @@
+package downsampling
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// MaxSourceResolutionParam is the name of the query API parameter used to select the max
+	// resolution of the blocks queried from the blocks storage.
+	MaxSourceResolutionParam = "max_source_resolution"
+
+	// Auto is the max source resolution value picking the resolution based on the query step.
+	Auto = "auto"
+
+	// autoStepRatio is the ratio between the query step and the max resolution picked when
+	// the resolution is automatically selected. Matches the Thanos querier.
+	autoStepRatio = 5
+)
+
+type contextKey int
+
+var ctxKey = contextKey(0)
+
+// MaxSourceResolution is the max resolution of the blocks queried from the blocks storage.
+type MaxSourceResolution struct {
+	// Auto picks the resolution based on the query step.
+	Auto bool
+
+	// Millis is the max resolution, in milliseconds. Only used if Auto is false.
+	Millis int64
+}
+
+// Parse parses the value of the max_source_resolution parameter, which is either
+// "auto" or a duration (e.g. "0s" for raw data, "5m" or "1h").
+func Parse(value string) (MaxSourceResolution, error) {
+	if value == Auto {
+		return MaxSourceResolution{Auto: true}, nil
+	}
+
+	d, err := model.ParseDuration(value)
+	if err != nil {
+		return MaxSourceResolution{}, fmt.Errorf("invalid %s parameter %q: must be %q or a duration", MaxSourceResolutionParam, value, Auto)
+	}
+
+	return MaxSourceResolution{Millis: int64(time.Duration(d) / time.Millisecond)}, nil
+}
+
+// ForStep returns the max resolution, in milliseconds, for a query with the given step in milliseconds.
+func (r MaxSourceResolution) ForStep(step int64) int64 {
+	if r.Auto {
+		return step / autoStepRatio
+	}
+	return r.Millis
+}
+
+// InjectIntoContext returns a context carrying the max source resolution.
+func InjectIntoContext(ctx context.Context, r MaxSourceResolution) context.Context {
+	return context.WithValue(ctx, ctxKey, r)
+}
+
+// FromContext returns the max source resolution carried by the context, if any.
+func FromContext(ctx context.Context) (MaxSourceResolution, bool) {
+	r, ok := ctx.Value(ctxKey).(MaxSourceResolution)
+	return r, ok
+}
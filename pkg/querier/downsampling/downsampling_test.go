@@ -0,0 +1,71 @@
+package downsampling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		value       string
+		expected    MaxSourceResolution
+		expectedErr bool
+	}{
+		"auto": {
+			value:    "auto",
+			expected: MaxSourceResolution{Auto: true},
+		},
+		"raw": {
+			value:    "0s",
+			expected: MaxSourceResolution{Millis: 0},
+		},
+		"5m": {
+			value:    "5m",
+			expected: MaxSourceResolution{Millis: 300000},
+		},
+		"1h": {
+			value:    "1h",
+			expected: MaxSourceResolution{Millis: 3600000},
+		},
+		"invalid": {
+			value:       "foo",
+			expectedErr: true,
+		},
+		"negative": {
+			value:       "-5m",
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			actual, err := Parse(testData.value)
+			if testData.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+}
+
+func TestMaxSourceResolution_ForStep(t *testing.T) {
+	assert.Equal(t, int64(60000), MaxSourceResolution{Auto: true}.ForStep(300000))
+	assert.Equal(t, int64(0), MaxSourceResolution{Auto: true}.ForStep(0))
+	assert.Equal(t, int64(300000), MaxSourceResolution{Millis: 300000}.ForStep(60000))
+}
+
+func TestFromContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := InjectIntoContext(context.Background(), MaxSourceResolution{Millis: 300000})
+	actual, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, MaxSourceResolution{Millis: 300000}, actual)
+}
@@ -0,0 +1,81 @@
+package querier
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
+)
+
+// processedSamplesQuerier is a storage.Querier tracking in the query stats the number
+// of samples iterated by the PromQL engine.
+type processedSamplesQuerier struct {
+	storage.Querier
+
+	stats *querier_stats.Stats
+}
+
+func newProcessedSamplesQuerier(q storage.Querier, stats *querier_stats.Stats) storage.Querier {
+	if stats == nil {
+		return q
+	}
+	return processedSamplesQuerier{Querier: q, stats: stats}
+}
+
+func (q processedSamplesQuerier) Select(sortSeries bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return processedSamplesSeriesSet{SeriesSet: q.Querier.Select(sortSeries, sp, matchers...), stats: q.stats}
+}
+
+type processedSamplesSeriesSet struct {
+	storage.SeriesSet
+
+	stats *querier_stats.Stats
+}
+
+func (s processedSamplesSeriesSet) At() storage.Series {
+	return processedSamplesSeries{Series: s.SeriesSet.At(), stats: s.stats}
+}
+
+type processedSamplesSeries struct {
+	storage.Series
+
+	stats *querier_stats.Stats
+}
+
+func (s processedSamplesSeries) Iterator() chunkenc.Iterator {
+	return &processedSamplesIterator{Iterator: s.Series.Iterator(), stats: s.stats, lastT: math.MinInt64}
+}
+
+// processedSamplesIterator counts each sample the iterator is positioned at once.
+type processedSamplesIterator struct {
+	chunkenc.Iterator
+
+	stats *querier_stats.Stats
+	lastT int64
+}
+
+func (it *processedSamplesIterator) Next() bool {
+	if !it.Iterator.Next() {
+		return false
+	}
+
+	it.lastT, _ = it.Iterator.At()
+	it.stats.AddProcessedSamples(1)
+	return true
+}
+
+func (it *processedSamplesIterator) Seek(t int64) bool {
+	if !it.Iterator.Seek(t) {
+		return false
+	}
+
+	// Seeking to a timestamp the iterator is already past of doesn't move it.
+	if ts, _ := it.Iterator.At(); ts != it.lastT {
+		it.lastT = ts
+		it.stats.AddProcessedSamples(1)
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
+)
+
+func TestProcessedSamplesQuerier(t *testing.T) {
+	matrix := model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "metric_1"},
+			Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3}},
+		},
+		{
+			Metric: model.Metric{"__name__": "metric_2"},
+			Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 5, Value: 5}},
+		},
+	}
+
+	tests := map[string]struct {
+		iterate  func(t *testing.T, set storage.SeriesSet)
+		expected uint64
+	}{
+		"should count all samples iterated with Next()": {
+			iterate: func(t *testing.T, set storage.SeriesSet) {
+				for set.Next() {
+					it := set.At().Iterator()
+					for it.Next() {
+					}
+					require.NoError(t, it.Err())
+				}
+			},
+			expected: 5,
+		},
+		"should count once a sample both seeked and iterated": {
+			iterate: func(t *testing.T, set storage.SeriesSet) {
+				require.True(t, set.Next())
+				it := set.At().Iterator()
+
+				require.True(t, it.Seek(2))
+				require.True(t, it.Seek(2))
+				require.True(t, it.Next())
+				require.False(t, it.Next())
+			},
+			expected: 2,
+		},
+		"should not count samples of series not iterated": {
+			iterate: func(t *testing.T, set storage.SeriesSet) {
+				for set.Next() {
+				}
+			},
+			expected: 0,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			stats := &querier_stats.Stats{}
+			q := newProcessedSamplesQuerier(mockQuerier{matrix: matrix}, stats)
+
+			testData.iterate(t, q.Select(true, &storage.SelectHints{}, labels.MustNewMatcher(labels.MatchRegexp, "__name__", ".+")))
+			assert.Equal(t, testData.expected, stats.LoadProcessedSamples())
+		})
+	}
+}
+
+func TestProcessedSamplesQuerier_ShouldNotWrapQuerierWithoutStats(t *testing.T) {
+	q := mockQuerier{}
+	assert.Equal(t, q, newProcessedSamplesQuerier(q, nil))
+}
@@ -17,16 +17,20 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
 	"github.com/thanos-io/thanos/pkg/strutil"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/purger"
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
 	"github.com/cortexproject/cortex/pkg/querier/batch"
 	"github.com/cortexproject/cortex/pkg/querier/chunkstore"
 	"github.com/cortexproject/cortex/pkg/querier/iterators"
 	"github.com/cortexproject/cortex/pkg/querier/lazyquery"
 	"github.com/cortexproject/cortex/pkg/querier/series"
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
 	"github.com/cortexproject/cortex/pkg/tenant"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/limiter"
@@ -65,8 +69,11 @@ type Config struct {
 	LookbackDelta time.Duration `yaml:"lookback_delta"`
 
 	// Blocks storage only.
-	StoreGatewayAddresses string       `yaml:"store_gateway_addresses"`
-	StoreGatewayClient    ClientConfig `yaml:"store_gateway_client"`
+	StoreGatewayAddresses           string       `yaml:"store_gateway_addresses"`
+	StoreGatewayClient              ClientConfig `yaml:"store_gateway_client"`
+	AutoDownsampling                bool         `yaml:"auto_downsampling_enabled"`
+	AvailabilityZone                string       `yaml:"availability_zone"`
+	StoreGatewayStreamResumeEnabled bool         `yaml:"store_gateway_stream_resume_enabled"`
 
 	SecondStoreEngine        string       `yaml:"second_store_engine"`
 	UseSecondStoreBeforeTime flagext.Time `yaml:"use_second_store_before_time"`
@@ -97,6 +104,9 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.QueryStoreAfter, "querier.query-store-after", 0, "The time after which a metric should be queried from storage and not just ingesters. 0 means all queries are sent to store. When running the blocks storage, if this option is enabled, the time range of the query sent to the store will be manipulated to ensure the query end is not more recent than 'now - query-store-after'.")
 	f.StringVar(&cfg.ActiveQueryTrackerDir, "querier.active-query-tracker-dir", "./active-query-tracker", "Active query tracker monitors active queries, and writes them to the file in given directory. If Cortex discovers any queries in this log during startup, it will log them to the log file. Setting to empty value disables active query tracker, which also disables -querier.max-concurrent option.")
 	f.StringVar(&cfg.StoreGatewayAddresses, "querier.store-gateway-addresses", "", "Comma separated list of store-gateway addresses in DNS Service Discovery format. This option should be set when using the blocks storage and the store-gateway sharding is disabled (when enabled, the store-gateway instances form a ring and addresses are picked from the ring).")
+	f.BoolVar(&cfg.AutoDownsampling, "querier.auto-downsampling-enabled", false, "When enabled, queries to the blocks storage read downsampled blocks up to a resolution of 1/5 of the query step, unless the max_source_resolution parameter is set. When disabled, only raw blocks are read unless requested otherwise via the max_source_resolution parameter.")
+	f.StringVar(&cfg.AvailabilityZone, "querier.availability-zone", "", "The availability zone where this querier is running. When set, store-gateways running in the same zone are preferred when querying blocks, and blocks failing to be queried because a store-gateway is unavailable are queried from the store-gateways running in the other zones. Applies only when the store-gateway sharding is enabled.")
+	f.BoolVar(&cfg.StoreGatewayStreamResumeEnabled, "querier.store-gateway-stream-resume-enabled", true, "When enabled, a series stream lazily consumed from a store-gateway which becomes unavailable is resumed from another store-gateway holding the same blocks, skipping the series already received. When disabled, the query fails. Applies only when the store-gateway sharding is enabled and the store-gateways send the series in batches.")
 	f.DurationVar(&cfg.LookbackDelta, "querier.lookback-delta", 5*time.Minute, "Time since the last sample after which a time series is considered stale and ignored by expression evaluations.")
 	f.StringVar(&cfg.SecondStoreEngine, "querier.second-store-engine", "", "Second store engine to use for querying. Empty = disabled.")
 	f.Var(&cfg.UseSecondStoreBeforeTime, "querier.use-second-store-before-time", "If specified, second store is only used for queries before this timestamp. Default value 0 means secondary store is always queried.")
@@ -164,7 +174,7 @@ func New(cfg Config, limits *validation.Overrides, distributor Distributor, stor
 		if err != nil {
 			return nil, err
 		}
-		return lazyquery.NewLazyQuerier(querier), nil
+		return lazyquery.NewLazyQuerier(newProcessedSamplesQuerier(querier, querier_stats.FromContext(ctx))), nil
 	})
 
 	engine := promql.NewEngine(promql.EngineOpts{
@@ -350,6 +360,19 @@ func (q querier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Mat
 		return storage.ErrSeriesSet(err)
 	}
 
+	if rules := q.limits.CompactorRetentionRules(userID); len(rules) > 0 {
+		retentionTombstones, err := retentionRulesTombstones(rules, time.Now())
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		tombstones = tombstones.Merge(retentionTombstones)
+	}
+
+	shard, _, err := astmapper.ShardFromMatchers(matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
 	if len(q.queriers) == 1 {
 		seriesSet := q.queriers[0].Select(true, sp, matchers...)
 
@@ -357,7 +380,7 @@ func (q querier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Mat
 			seriesSet = series.NewDeletedSeriesSet(seriesSet, tombstones, model.Interval{Start: startTime, End: endTime})
 		}
 
-		return seriesSet
+		return withShardLabel(seriesSet, shard)
 	}
 
 	sets := make(chan storage.SeriesSet, len(q.queriers))
@@ -385,7 +408,41 @@ func (q querier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Mat
 	if tombstones.Len() != 0 {
 		seriesSet = series.NewDeletedSeriesSet(seriesSet, tombstones, model.Interval{Start: startTime, End: endTime})
 	}
-	return seriesSet
+	return withShardLabel(seriesSet, shard)
+}
+
+// withShardLabel adds the shard label to the series of a sharded query. Ingesters and
+// store-gateways filter series by shard but don't return the shard label (which is not
+// stored in the blocks storage), while the query-frontend requires it to merge the
+// results of the sharded queries. Adding it is a no-op for the chunks storage, which
+// already injects the shard label.
+func withShardLabel(set storage.SeriesSet, shard *astmapper.ShardAnnotation) storage.SeriesSet {
+	if shard == nil {
+		return set
+	}
+	return sharding.NewShardLabelSeriesSet(set, *shard)
+}
+
+// retentionRulesTombstones returns the tombstones hiding the samples of the series matching the
+// retention rules which are older than the rule's period, until they're deleted by the compactor.
+func retentionRulesTombstones(rules []validation.RetentionRule, now time.Time) (*purger.TombstonesSet, error) {
+	deleteRequests := make([]purger.DeleteRequest, 0, len(rules))
+
+	for _, rule := range rules {
+		matchers, err := rule.Matchers()
+		if err != nil {
+			return nil, err
+		}
+
+		deleteRequests = append(deleteRequests, purger.DeleteRequest{
+			StartTime: model.Earliest,
+			EndTime:   model.TimeFromUnixNano(now.Add(-time.Duration(rule.Period)).UnixNano()),
+			Selectors: []string{rule.Selector},
+			Matchers:  [][]*labels.Matcher{matchers},
+		})
+	}
+
+	return purger.NewTombstonesSet(deleteRequests), nil
 }
 
 // LabelsValue implements storage.Querier.
@@ -477,8 +534,13 @@ func (q querier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warn
 	return strutil.MergeSlices(sets...), warnings, nil
 }
 
-func (querier) Close() error {
-	return nil
+// Close releases the resources of the underlying queriers.
+func (q querier) Close() error {
+	errs := tsdb_errors.NewMulti()
+	for _, querier := range q.queriers {
+		errs.Add(querier.Close())
+	}
+	return errs.Err()
 }
 
 func (q querier) mergeSeriesSets(sets []storage.SeriesSet) storage.SeriesSet {
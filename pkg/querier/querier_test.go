@@ -1020,3 +1020,42 @@ func (m *mockQueryableWithFilter) UseQueryable(_ time.Time, _, _ int64) bool {
 	m.useQueryableCalled = true
 	return true
 }
+
+func TestRetentionRulesTombstones(t *testing.T) {
+	now := time.Now()
+	rules := []validation.RetentionRule{
+		{Selector: `{__name__=~"debug_.+"}`, Period: model.Duration(7 * 24 * time.Hour)},
+		{Selector: `{job="short"}`, Period: model.Duration(24 * time.Hour)},
+	}
+
+	tombstones, err := retentionRulesTombstones(rules, now)
+	require.NoError(t, err)
+	require.Equal(t, 2, tombstones.Len())
+
+	from := model.TimeFromUnixNano(now.Add(-30 * 24 * time.Hour).UnixNano())
+	to := model.TimeFromUnixNano(now.UnixNano())
+
+	tests := map[string]struct {
+		lbls     labels.Labels
+		expected []model.Interval
+	}{
+		"series not matching any rule": {
+			lbls:     labels.FromStrings(labels.MetricName, "slo_metric"),
+			expected: nil,
+		},
+		"series matching a rule": {
+			lbls:     labels.FromStrings(labels.MetricName, "debug_metric"),
+			expected: []model.Interval{{Start: from, End: model.TimeFromUnixNano(now.Add(-7 * 24 * time.Hour).UnixNano())}},
+		},
+		"series matching multiple rules": {
+			lbls:     labels.FromStrings(labels.MetricName, "debug_metric", "job", "short"),
+			expected: []model.Interval{{Start: from, End: model.TimeFromUnixNano(now.Add(-24 * time.Hour).UnixNano())}},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testData.expected, tombstones.GetDeletedIntervals(testData.lbls, from, to))
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package queryrange
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// blockedQueriesChecker rejects the queries matching any of the tenants' blocked queries.
+type blockedQueriesChecker struct {
+	limits         Limits
+	logger         log.Logger
+	blockedQueries *prometheus.CounterVec
+}
+
+// check returns an HTTP 422 error if the query of the request is blocked for any of the tenants.
+// The request body, if any, is preserved.
+func (c *blockedQueriesChecker) check(r *http.Request, op string, tenantIDs []string) error {
+	var blocked []validation.BlockedQuery
+	for _, tenantID := range tenantIDs {
+		blocked = append(blocked, c.limits.BlockedQueries(tenantID)...)
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	form, err := readRequestForm(r)
+	if err != nil {
+		// Let the downstream handler report the malformed request.
+		return nil
+	}
+
+	var (
+		now   = time.Now()
+		query = form.Get("query")
+		start = util.ParseTimeParam(form.Get("start"), now)
+		end   = util.ParseTimeParam(form.Get("end"), now)
+	)
+
+	// Instant queries are evaluated at a single timestamp.
+	if op == "query" {
+		start = util.ParseTimeParam(form.Get("time"), now)
+		end = start
+	}
+
+	for _, b := range blocked {
+		if !b.Matches(query, start, end, now) {
+			continue
+		}
+
+		c.blockedQueries.WithLabelValues(op, tenant.JoinTenantIDs(tenantIDs)).Inc()
+		level.Info(util_log.WithContext(r.Context(), c.logger)).Log("msg", "query blocked", "op", op, "query", query, "pattern", b.Pattern)
+
+		return httpgrpc.Errorf(http.StatusUnprocessableEntity, validation.ErrQueryBlocked, b.Pattern)
+	}
+
+	return nil
+}
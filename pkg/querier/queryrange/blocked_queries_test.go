@@ -0,0 +1,169 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestTripperware_ShouldRejectBlockedQueries(t *testing.T) {
+	now := time.Now()
+
+	blocked := []validation.BlockedQuery{
+		{Pattern: `.*\{.*=~"\.\*".*\}.*`},
+		{Pattern: "expensive_metric", TimeWindow: validation.QueryTimeWindow{Start: model.Duration(24 * time.Hour)}},
+	}
+	for i := range blocked {
+		require.NoError(t, blocked[i].Validate())
+	}
+
+	tests := map[string]struct {
+		method          string
+		path            string
+		params          url.Values
+		rawBody         string
+		expectedBlocked bool
+		expectedOp      string
+	}{
+		"range query matching a pattern": {
+			method:          http.MethodGet,
+			path:            "/api/v1/query_range",
+			params:          url.Values{"query": {`up{job=~".*"}`}, "start": {"0"}, "end": {"3600"}, "step": {"60"}},
+			expectedBlocked: true,
+			expectedOp:      "query_range",
+		},
+		"instant query matching a pattern in the POST body": {
+			method:          http.MethodPost,
+			path:            "/api/v1/query",
+			params:          url.Values{"query": {`up{job=~".*"}`}},
+			expectedBlocked: true,
+			expectedOp:      "query",
+		},
+		"instant query not matching any pattern in the POST body": {
+			method:          http.MethodPost,
+			path:            "/api/v1/query",
+			params:          url.Values{"query": {`up{job="test"}`}},
+			expectedBlocked: false,
+		},
+		"instant query matching a pattern within the time window": {
+			method:          http.MethodGet,
+			path:            "/api/v1/query",
+			params:          url.Values{"query": {"expensive_metric"}, "time": {formatUnixTime(now.Add(-time.Hour))}},
+			expectedBlocked: true,
+			expectedOp:      "query",
+		},
+		"instant query matching a pattern outside the time window": {
+			method:          http.MethodGet,
+			path:            "/api/v1/query",
+			params:          url.Values{"query": {"expensive_metric"}, "time": {formatUnixTime(now.Add(-48 * time.Hour))}},
+			expectedBlocked: false,
+		},
+		"series query should not be checked": {
+			method:          http.MethodGet,
+			path:            "/api/v1/series",
+			params:          url.Values{"query": {`up{job=~".*"}`}, "match[]": {`up{job=~".*"}`}},
+			expectedBlocked: false,
+		},
+		"labels query should not be checked": {
+			method:          http.MethodPost,
+			path:            "/api/v1/labels",
+			params:          url.Values{"query": {`up{job=~".*"}`}},
+			expectedBlocked: false,
+		},
+		"malformed query should be passed to the downstream handler": {
+			method:          http.MethodPost,
+			path:            "/api/v1/query",
+			rawBody:         "query=%zz",
+			expectedBlocked: false,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+
+			var downstreamBody string
+			downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Body != nil {
+					body, err := ioutil.ReadAll(r.Body)
+					require.NoError(t, err)
+					downstreamBody = string(body)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(responseBody))}, nil
+			})
+
+			tw, _, err := NewTripperware(Config{},
+				log.NewNopLogger(),
+				mockLimits{blockedQueries: blocked},
+				PrometheusCodec,
+				nil,
+				chunk.SchemaConfig{},
+				promql.EngineOpts{},
+				0,
+				reg,
+				nil,
+			)
+			require.NoError(t, err)
+
+			body := testData.params.Encode()
+			if testData.rawBody != "" {
+				body = testData.rawBody
+			}
+
+			var req *http.Request
+			if testData.method == http.MethodPost {
+				req, err = http.NewRequest(http.MethodPost, testData.path, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req, err = http.NewRequest(http.MethodGet, testData.path+"?"+testData.params.Encode(), http.NoBody)
+			}
+			require.NoError(t, err)
+			req = req.WithContext(user.InjectOrgID(context.Background(), "user-1"))
+
+			resp, err := tw(downstream).RoundTrip(req)
+
+			if !testData.expectedBlocked {
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				if testData.method == http.MethodPost {
+					assert.Equal(t, body, downstreamBody)
+				}
+				return
+			}
+
+			require.Error(t, err)
+			errResp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusUnprocessableEntity), errResp.Code)
+			assert.Contains(t, string(errResp.Body), "the query is blocked")
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(`
+				# HELP cortex_query_frontend_blocked_queries_total Total queries blocked per tenant.
+				# TYPE cortex_query_frontend_blocked_queries_total counter
+				cortex_query_frontend_blocked_queries_total{op="%s",user="user-1"} 1
+			`, testData.expectedOp)), "cortex_query_frontend_blocked_queries_total"))
+		})
+	}
+}
+
+func formatUnixTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
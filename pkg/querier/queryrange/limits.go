@@ -31,6 +31,16 @@ type Limits interface {
 	// MaxCacheFreshness returns the period after which results are cacheable,
 	// to prevent caching of very recent results.
 	MaxCacheFreshness(string) time.Duration
+
+	// ResultsCacheTTLForInstantQuery returns the time to live of the cached instant query results.
+	ResultsCacheTTLForInstantQuery(string) time.Duration
+
+	// ResultsCacheTTLForLabelsQuery returns the time to live of the cached label names,
+	// label values and series API results.
+	ResultsCacheTTLForLabelsQuery(string) time.Duration
+
+	// BlockedQueries returns the queries blocked by the query-frontend.
+	BlockedQueries(string) []validation.BlockedQuery
 }
 
 type limitsMiddleware struct {
@@ -11,6 +11,7 @@ import (
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 func TestLimitsMiddleware_MaxQueryLookback(t *testing.T) {
@@ -187,9 +188,12 @@ func TestLimitsMiddleware_MaxQueryLength(t *testing.T) {
 }
 
 type mockLimits struct {
-	maxQueryLookback  time.Duration
-	maxQueryLength    time.Duration
-	maxCacheFreshness time.Duration
+	maxQueryLookback            time.Duration
+	maxQueryLength              time.Duration
+	maxCacheFreshness           time.Duration
+	resultsCacheTTLInstantQuery time.Duration
+	resultsCacheTTLLabelsQuery  time.Duration
+	blockedQueries              []validation.BlockedQuery
 }
 
 func (m mockLimits) MaxQueryLookback(string) time.Duration {
@@ -208,6 +212,18 @@ func (m mockLimits) MaxCacheFreshness(string) time.Duration {
 	return m.maxCacheFreshness
 }
 
+func (m mockLimits) ResultsCacheTTLForInstantQuery(string) time.Duration {
+	return m.resultsCacheTTLInstantQuery
+}
+
+func (m mockLimits) ResultsCacheTTLForLabelsQuery(string) time.Duration {
+	return m.resultsCacheTTLLabelsQuery
+}
+
+func (m mockLimits) BlockedQueries(string) []validation.BlockedQuery {
+	return m.blockedQueries
+}
+
 type mockHandler struct {
 	mock.Mock
 }
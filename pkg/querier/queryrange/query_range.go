@@ -23,6 +23,7 @@ import (
 	"github.com/weaveworks/common/httpgrpc"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/querier/downsampling"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 )
@@ -222,6 +223,14 @@ func (prometheusCodec) DecodeRequest(_ context.Context, r *http.Request) (Reques
 	result.Query = r.FormValue("query")
 	result.Path = r.URL.Path
 
+	// The max source resolution is validated here, but only parsed by the querier.
+	if value := r.FormValue(downsampling.MaxSourceResolutionParam); value != "" {
+		if _, err := downsampling.Parse(value); err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+		}
+		result.MaxSourceResolution = value
+	}
+
 	for _, value := range r.Header.Values(cacheControlHeader) {
 		if strings.Contains(value, noStoreValue) {
 			result.CachingOptions.Disabled = true
@@ -243,6 +252,9 @@ func (prometheusCodec) EncodeRequest(ctx context.Context, r Request) (*http.Requ
 		"step":  []string{encodeDurationMs(promReq.Step)},
 		"query": []string{promReq.Query},
 	}
+	if promReq.MaxSourceResolution != "" {
+		params.Set(downsampling.MaxSourceResolutionParam, promReq.MaxSourceResolution)
+	}
 	u := &url.URL{
 		Path:     promReq.Path,
 		RawQuery: params.Encode(),
@@ -51,6 +51,21 @@ func TestRequest(t *testing.T) {
 			url:         "api/v1/query_range?start=0&end=11001&step=1",
 			expectedErr: errStepTooSmall,
 		},
+		{
+			url: "/api/v1/query_range?end=1536716898&max_source_resolution=5m&query=sum%28container_memory_rss%29+by+%28namespace%29&start=1536673680&step=120",
+			expected: &PrometheusRequest{
+				Path:                "/api/v1/query_range",
+				Start:               1536673680 * 1e3,
+				End:                 1536716898 * 1e3,
+				Step:                120 * 1e3,
+				Query:               "sum(container_memory_rss) by (namespace)",
+				MaxSourceResolution: "5m",
+			},
+		},
+		{
+			url:         "api/v1/query_range?start=123&end=456&step=1&max_source_resolution=foo",
+			expectedErr: httpgrpc.Errorf(http.StatusBadRequest, "invalid max_source_resolution parameter \"foo\": must be \"auto\" or a duration"),
+		},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			r, err := http.NewRequest("GET", tc.url, nil)
@@ -9,6 +9,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 
 	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 )
 
 const (
@@ -85,6 +86,8 @@ func (q *ShardedQuerier) handleEmbeddedQuery(encoded string) storage.SeriesSet {
 	// buffer channels to length of queries to prevent leaking memory due to sending to unbuffered channels after cancel/err
 	errCh := make(chan error, len(queries))
 	samplesCh := make(chan []SampleStream, len(queries))
+	querier_stats.FromContext(ctx).AddShardedQueries(uint64(len(queries)))
+
 	// TODO(owen-d): impl unified concurrency controls, not per middleware
 	for _, query := range queries {
 		go func(query string) {
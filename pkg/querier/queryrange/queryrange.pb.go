@@ -4,6 +4,7 @@
 package queryrange
 
 import (
+	bytes "bytes"
 	fmt "fmt"
 	cortexpb "github.com/cortexproject/cortex/pkg/cortexpb"
 	github_com_cortexproject_cortex_pkg_cortexpb "github.com/cortexproject/cortex/pkg/cortexpb"
@@ -11,7 +12,7 @@ import (
 	proto "github.com/gogo/protobuf/proto"
 	github_com_gogo_protobuf_types "github.com/gogo/protobuf/types"
 	types "github.com/gogo/protobuf/types"
-	_ "github.com/golang/protobuf/ptypes/duration"
+	_ "google.golang.org/protobuf/types/known/durationpb"
 	io "io"
 	math "math"
 	math_bits "math/bits"
@@ -33,13 +34,14 @@ var _ = time.Kitchen
 const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
 type PrometheusRequest struct {
-	Path           string         `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
-	Start          int64          `protobuf:"varint,2,opt,name=start,proto3" json:"start,omitempty"`
-	End            int64          `protobuf:"varint,3,opt,name=end,proto3" json:"end,omitempty"`
-	Step           int64          `protobuf:"varint,4,opt,name=step,proto3" json:"step,omitempty"`
-	Timeout        time.Duration  `protobuf:"bytes,5,opt,name=timeout,proto3,stdduration" json:"timeout"`
-	Query          string         `protobuf:"bytes,6,opt,name=query,proto3" json:"query,omitempty"`
-	CachingOptions CachingOptions `protobuf:"bytes,7,opt,name=cachingOptions,proto3" json:"cachingOptions"`
+	Path                string         `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Start               int64          `protobuf:"varint,2,opt,name=start,proto3" json:"start,omitempty"`
+	End                 int64          `protobuf:"varint,3,opt,name=end,proto3" json:"end,omitempty"`
+	Step                int64          `protobuf:"varint,4,opt,name=step,proto3" json:"step,omitempty"`
+	Timeout             time.Duration  `protobuf:"bytes,5,opt,name=timeout,proto3,stdduration" json:"timeout"`
+	Query               string         `protobuf:"bytes,6,opt,name=query,proto3" json:"query,omitempty"`
+	CachingOptions      CachingOptions `protobuf:"bytes,7,opt,name=cachingOptions,proto3" json:"cachingOptions"`
+	MaxSourceResolution string         `protobuf:"bytes,8,opt,name=max_source_resolution,json=maxSourceResolution,proto3" json:"max_source_resolution,omitempty"`
 }
 
 func (m *PrometheusRequest) Reset()      { *m = PrometheusRequest{} }
@@ -123,6 +125,13 @@ func (m *PrometheusRequest) GetCachingOptions() CachingOptions {
 	return CachingOptions{}
 }
 
+func (m *PrometheusRequest) GetMaxSourceResolution() string {
+	if m != nil {
+		return m.MaxSourceResolution
+	}
+	return ""
+}
+
 type PrometheusResponseHeader struct {
 	Name   string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"-"`
 	Values []string `protobuf:"bytes,2,rep,name=Values,proto3" json:"-"`
@@ -463,6 +472,83 @@ func (m *Extent) GetResponse() *types.Any {
 	return nil
 }
 
+// CachedHTTPResponse is a HTTP response cached by the query-frontend as is.
+type CachedHTTPResponse struct {
+	CacheKey   string                      `protobuf:"bytes,1,opt,name=cache_key,json=cacheKey,proto3" json:"cache_key,omitempty"`
+	StatusCode int32                       `protobuf:"varint,2,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Headers    []*PrometheusResponseHeader `protobuf:"bytes,3,rep,name=headers,proto3" json:"headers,omitempty"`
+	Body       []byte                      `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+	// Unix timestamp in milliseconds after which the cached response is expired.
+	ExpiresAt int64 `protobuf:"varint,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+}
+
+func (m *CachedHTTPResponse) Reset()      { *m = CachedHTTPResponse{} }
+func (*CachedHTTPResponse) ProtoMessage() {}
+func (*CachedHTTPResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_79b02382e213d0b2, []int{7}
+}
+func (m *CachedHTTPResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CachedHTTPResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CachedHTTPResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *CachedHTTPResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CachedHTTPResponse.Merge(m, src)
+}
+func (m *CachedHTTPResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *CachedHTTPResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CachedHTTPResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CachedHTTPResponse proto.InternalMessageInfo
+
+func (m *CachedHTTPResponse) GetCacheKey() string {
+	if m != nil {
+		return m.CacheKey
+	}
+	return ""
+}
+
+func (m *CachedHTTPResponse) GetStatusCode() int32 {
+	if m != nil {
+		return m.StatusCode
+	}
+	return 0
+}
+
+func (m *CachedHTTPResponse) GetHeaders() []*PrometheusResponseHeader {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *CachedHTTPResponse) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (m *CachedHTTPResponse) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
 type CachingOptions struct {
 	Disabled bool `protobuf:"varint,1,opt,name=disabled,proto3" json:"disabled,omitempty"`
 }
@@ -470,7 +556,7 @@ type CachingOptions struct {
 func (m *CachingOptions) Reset()      { *m = CachingOptions{} }
 func (*CachingOptions) ProtoMessage() {}
 func (*CachingOptions) Descriptor() ([]byte, []int) {
-	return fileDescriptor_79b02382e213d0b2, []int{7}
+	return fileDescriptor_79b02382e213d0b2, []int{8}
 }
 func (m *CachingOptions) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -514,65 +600,73 @@ func init() {
 	proto.RegisterType((*SampleStream)(nil), "queryrange.SampleStream")
 	proto.RegisterType((*CachedResponse)(nil), "queryrange.CachedResponse")
 	proto.RegisterType((*Extent)(nil), "queryrange.Extent")
+	proto.RegisterType((*CachedHTTPResponse)(nil), "queryrange.CachedHTTPResponse")
 	proto.RegisterType((*CachingOptions)(nil), "queryrange.CachingOptions")
 }
 
 func init() { proto.RegisterFile("queryrange.proto", fileDescriptor_79b02382e213d0b2) }
 
 var fileDescriptor_79b02382e213d0b2 = []byte{
-	// 827 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0xcd, 0x8e, 0x1b, 0x45,
-	0x10, 0x76, 0xaf, 0xed, 0xb1, 0xdd, 0x1b, 0x39, 0x4b, 0x6f, 0x04, 0xe3, 0x95, 0x98, 0xb1, 0x46,
-	0x1c, 0x16, 0x29, 0x99, 0x95, 0x16, 0x71, 0x00, 0x09, 0x94, 0x0c, 0x59, 0x14, 0x7e, 0x04, 0x51,
-	0x6f, 0xc4, 0x81, 0x0b, 0x6a, 0x7b, 0x0a, 0x7b, 0x12, 0xcf, 0x4f, 0x7a, 0x7a, 0xd0, 0xfa, 0x86,
-	0xf2, 0x04, 0x1c, 0x79, 0x04, 0x90, 0x78, 0x0c, 0x0e, 0x39, 0xee, 0x31, 0xe2, 0x30, 0xb0, 0xde,
-	0x0b, 0x9a, 0x53, 0x1e, 0x01, 0xf5, 0xcf, 0x78, 0x66, 0x77, 0xb9, 0xe4, 0x62, 0x55, 0x55, 0xd7,
-	0x57, 0xf5, 0xd5, 0xd7, 0x3d, 0x65, 0xbc, 0xf7, 0xbc, 0x00, 0xbe, 0xe6, 0x2c, 0x59, 0x80, 0x9f,
-	0xf1, 0x54, 0xa4, 0x04, 0x37, 0x91, 0x83, 0x7b, 0x8b, 0x48, 0x2c, 0x8b, 0x99, 0x3f, 0x4f, 0xe3,
-	0xa3, 0x45, 0xba, 0x48, 0x8f, 0x54, 0xca, 0xac, 0xf8, 0x51, 0x79, 0xca, 0x51, 0x96, 0x86, 0x1e,
-	0x38, 0x8b, 0x34, 0x5d, 0xac, 0xa0, 0xc9, 0x0a, 0x0b, 0xce, 0x44, 0x94, 0x26, 0xe6, 0xfc, 0xa3,
-	0x56, 0xb9, 0x79, 0xca, 0x05, 0x9c, 0x65, 0x3c, 0x7d, 0x0a, 0x73, 0x61, 0xbc, 0xa3, 0xec, 0xd9,
-	0xa2, 0x3e, 0x98, 0x19, 0xc3, 0x40, 0x27, 0xd7, 0x4b, 0xb3, 0x64, 0xad, 0x8f, 0xbc, 0x17, 0x3b,
-	0xf8, 0xad, 0xc7, 0x3c, 0x8d, 0x41, 0x2c, 0xa1, 0xc8, 0x29, 0x3c, 0x2f, 0x20, 0x17, 0x84, 0xe0,
-	0x5e, 0xc6, 0xc4, 0xd2, 0x46, 0x53, 0x74, 0x38, 0xa2, 0xca, 0x26, 0x77, 0x70, 0x3f, 0x17, 0x8c,
-	0x0b, 0x7b, 0x67, 0x8a, 0x0e, 0xbb, 0x54, 0x3b, 0x64, 0x0f, 0x77, 0x21, 0x09, 0xed, 0xae, 0x8a,
-	0x49, 0x53, 0x62, 0x73, 0x01, 0x99, 0xdd, 0x53, 0x21, 0x65, 0x93, 0x4f, 0xf0, 0x40, 0x44, 0x31,
-	0xa4, 0x85, 0xb0, 0xfb, 0x53, 0x74, 0xb8, 0x7b, 0x3c, 0xf1, 0x35, 0x25, 0xbf, 0xa6, 0xe4, 0x3f,
-	0x34, 0xd3, 0x06, 0xc3, 0x97, 0xa5, 0xdb, 0xf9, 0xf5, 0x6f, 0x17, 0xd1, 0x1a, 0x23, 0x5b, 0x2b,
-	0x5d, 0x6d, 0x4b, 0xf1, 0xd1, 0x0e, 0x79, 0x84, 0xc7, 0x73, 0x36, 0x5f, 0x46, 0xc9, 0xe2, 0xdb,
-	0x4c, 0x22, 0x73, 0x7b, 0xa0, 0x6a, 0x1f, 0xf8, 0xad, 0x6b, 0xf9, 0xec, 0x4a, 0x46, 0xd0, 0x93,
-	0xc5, 0xe9, 0x35, 0x9c, 0xf7, 0x04, 0xdb, 0x6d, 0x0d, 0xf2, 0x2c, 0x4d, 0x72, 0x78, 0x04, 0x2c,
-	0x04, 0x4e, 0x26, 0xb8, 0xf7, 0x0d, 0x8b, 0x41, 0x4b, 0x11, 0xf4, 0xab, 0xd2, 0x45, 0xf7, 0xa8,
-	0x0a, 0x91, 0x77, 0xb1, 0xf5, 0x1d, 0x5b, 0x15, 0x90, 0xdb, 0x3b, 0xd3, 0x6e, 0x73, 0x68, 0x82,
-	0xde, 0xef, 0x3b, 0x98, 0xdc, 0x2c, 0x4b, 0x3c, 0x6c, 0x9d, 0x0a, 0x26, 0x8a, 0xdc, 0x94, 0xc4,
-	0x55, 0xe9, 0x5a, 0xb9, 0x8a, 0x50, 0x73, 0x42, 0x3e, 0xc7, 0xbd, 0x87, 0x4c, 0x30, 0x25, 0xf5,
-	0xb5, 0x81, 0x9a, 0x8a, 0x32, 0x23, 0x78, 0x5b, 0x0e, 0x54, 0x95, 0xee, 0x38, 0x64, 0x82, 0xdd,
-	0x4d, 0xe3, 0x48, 0x40, 0x9c, 0x89, 0x35, 0x55, 0x78, 0xf2, 0x21, 0x1e, 0x9d, 0x70, 0x9e, 0xf2,
-	0x27, 0xeb, 0x0c, 0xd4, 0x1d, 0x8d, 0x82, 0x77, 0xaa, 0xd2, 0xdd, 0x87, 0x3a, 0xd8, 0x42, 0x34,
-	0x99, 0xe4, 0x7d, 0xdc, 0x57, 0x8e, 0xba, 0xc3, 0x51, 0xb0, 0x5f, 0x95, 0xee, 0x6d, 0x05, 0x69,
-	0xa5, 0xeb, 0x0c, 0x72, 0x82, 0x07, 0x5a, 0xa8, 0xdc, 0xee, 0x4f, 0xbb, 0x87, 0xbb, 0xc7, 0xef,
-	0xfd, 0x3f, 0xd9, 0xab, 0xaa, 0xd6, 0x52, 0xd5, 0x58, 0xef, 0x05, 0xc2, 0xe3, 0xab, 0x93, 0x11,
-	0x1f, 0x63, 0x0a, 0x79, 0xb1, 0x12, 0x8a, 0xbc, 0xd6, 0x6a, 0x5c, 0x95, 0x2e, 0xe6, 0xdb, 0x28,
-	0x6d, 0x65, 0x90, 0xfb, 0xd8, 0xd2, 0x9e, 0xba, 0x8d, 0xdd, 0x63, 0xbb, 0x4d, 0xe4, 0x94, 0xc5,
-	0xd9, 0x0a, 0x4e, 0x05, 0x07, 0x16, 0x07, 0x63, 0xa3, 0x99, 0xa5, 0x2b, 0x51, 0x83, 0xf3, 0xfe,
-	0x44, 0xf8, 0x56, 0x3b, 0x91, 0x9c, 0x61, 0x6b, 0xc5, 0x66, 0xb0, 0x92, 0x57, 0x25, 0x4b, 0xee,
-	0xfb, 0xf5, 0xf7, 0xe5, 0x7f, 0x2d, 0xe3, 0x8f, 0x59, 0xc4, 0x83, 0xaf, 0x64, 0xb5, 0xbf, 0x4a,
-	0xf7, 0x8d, 0xbe, 0x4f, 0x8d, 0x7f, 0x10, 0xb2, 0x4c, 0x00, 0x97, 0x54, 0x62, 0x10, 0x3c, 0x9a,
-	0x53, 0xd3, 0x8f, 0x7c, 0x8c, 0x07, 0xb9, 0x62, 0x92, 0x9b, 0x69, 0xf6, 0x9a, 0xd6, 0x9a, 0x62,
-	0x33, 0xc5, 0x4f, 0xea, 0xb9, 0xd1, 0x1a, 0xe0, 0x3d, 0xc5, 0x63, 0xf9, 0xea, 0x21, 0xdc, 0x3e,
-	0xb9, 0x09, 0xee, 0x3e, 0x83, 0xb5, 0xd1, 0x70, 0x50, 0x95, 0xae, 0x74, 0xa9, 0xfc, 0x91, 0x5f,
-	0x26, 0x9c, 0x09, 0x48, 0x44, 0xdd, 0x88, 0xb4, 0x65, 0x3b, 0x51, 0x47, 0xc1, 0x6d, 0xd3, 0xaa,
-	0x4e, 0xa5, 0xb5, 0xe1, 0xfd, 0x81, 0xb0, 0xa5, 0x93, 0x88, 0x5b, 0xef, 0x07, 0xd9, 0xa6, 0x1b,
-	0x8c, 0xaa, 0xd2, 0xd5, 0x81, 0x7a, 0x55, 0x4c, 0xf4, 0xaa, 0x50, 0xeb, 0x43, 0xb3, 0x80, 0x24,
-	0xd4, 0x3b, 0x63, 0x8a, 0x87, 0x82, 0xb3, 0x39, 0xfc, 0x10, 0x85, 0xe6, 0xcd, 0xd5, 0x0f, 0x44,
-	0x85, 0xbf, 0x08, 0xc9, 0xa7, 0x78, 0xc8, 0xcd, 0x38, 0x66, 0x85, 0xdc, 0xb9, 0xb1, 0x42, 0x1e,
-	0x24, 0xeb, 0xe0, 0x56, 0x55, 0xba, 0xdb, 0x4c, 0xba, 0xb5, 0xbe, 0xec, 0x0d, 0xbb, 0x7b, 0x3d,
-	0xef, 0xae, 0x96, 0xa6, 0xf9, 0xf4, 0xc9, 0x01, 0x1e, 0x86, 0x51, 0xce, 0x66, 0x2b, 0x08, 0x15,
-	0xf1, 0x21, 0xdd, 0xfa, 0xc1, 0xfd, 0xf3, 0x0b, 0xa7, 0xf3, 0xea, 0xc2, 0xe9, 0xbc, 0xbe, 0x70,
-	0xd0, 0xcf, 0x1b, 0x07, 0xfd, 0xb6, 0x71, 0xd0, 0xcb, 0x8d, 0x83, 0xce, 0x37, 0x0e, 0xfa, 0x67,
-	0xe3, 0xa0, 0x7f, 0x37, 0x4e, 0xe7, 0xf5, 0xc6, 0x41, 0xbf, 0x5c, 0x3a, 0x9d, 0xf3, 0x4b, 0xa7,
-	0xf3, 0xea, 0xd2, 0xe9, 0x7c, 0xdf, 0xfa, 0x0b, 0x98, 0x59, 0x8a, 0xdb, 0x07, 0xff, 0x05, 0x00,
-	0x00, 0xff, 0xff, 0x08, 0x83, 0xd3, 0x7d, 0x29, 0x06, 0x00, 0x00,
+	// 938 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0x4f, 0x6f, 0x1b, 0x45,
+	0x14, 0xf7, 0x66, 0xfd, 0xf7, 0xa5, 0x72, 0xc3, 0xa4, 0xc0, 0x26, 0xa8, 0xbb, 0xd6, 0x8a, 0x43,
+	0x90, 0x5a, 0x47, 0x0a, 0xe2, 0x00, 0x12, 0x55, 0xb3, 0x6d, 0x50, 0xa0, 0x08, 0xa2, 0x49, 0xc4,
+	0x81, 0x8b, 0x35, 0xf6, 0x3e, 0x9c, 0x6d, 0xbd, 0x9e, 0xed, 0xec, 0x2c, 0xb2, 0x6f, 0x88, 0x4f,
+	0xc0, 0x91, 0x8f, 0x00, 0x12, 0x67, 0x3e, 0x01, 0x48, 0x3d, 0xe6, 0x58, 0x71, 0x58, 0x88, 0x73,
+	0x41, 0x3e, 0xf5, 0x23, 0xa0, 0x99, 0xd9, 0xb5, 0x37, 0x0d, 0x07, 0xb8, 0x58, 0xef, 0xfd, 0xde,
+	0xfb, 0xbd, 0x79, 0xf3, 0x9b, 0x7d, 0xcf, 0xb0, 0xf5, 0x3c, 0x43, 0x31, 0x17, 0x6c, 0x3a, 0xc6,
+	0x7e, 0x22, 0xb8, 0xe4, 0x04, 0xd6, 0xc8, 0xee, 0xfd, 0x71, 0x24, 0xcf, 0xb3, 0x61, 0x7f, 0xc4,
+	0xe3, 0xfd, 0x31, 0x1f, 0xf3, 0x7d, 0x9d, 0x32, 0xcc, 0xbe, 0xd1, 0x9e, 0x76, 0xb4, 0x65, 0xa8,
+	0xbb, 0xee, 0x98, 0xf3, 0xf1, 0x04, 0xd7, 0x59, 0x61, 0x26, 0x98, 0x8c, 0xf8, 0xb4, 0x88, 0x7f,
+	0x58, 0x29, 0x37, 0xe2, 0x42, 0xe2, 0x2c, 0x11, 0xfc, 0x29, 0x8e, 0x64, 0xe1, 0xed, 0x27, 0xcf,
+	0xc6, 0x65, 0x60, 0x58, 0x18, 0x05, 0x75, 0xe7, 0xf5, 0xd2, 0x6c, 0x3a, 0x37, 0x21, 0xff, 0xd7,
+	0x0d, 0x78, 0xe3, 0x44, 0xf0, 0x18, 0xe5, 0x39, 0x66, 0x29, 0xc5, 0xe7, 0x19, 0xa6, 0x92, 0x10,
+	0xa8, 0x27, 0x4c, 0x9e, 0x3b, 0x56, 0xcf, 0xda, 0xeb, 0x50, 0x6d, 0x93, 0x3b, 0xd0, 0x48, 0x25,
+	0x13, 0xd2, 0xd9, 0xe8, 0x59, 0x7b, 0x36, 0x35, 0x0e, 0xd9, 0x02, 0x1b, 0xa7, 0xa1, 0x63, 0x6b,
+	0x4c, 0x99, 0x8a, 0x9b, 0x4a, 0x4c, 0x9c, 0xba, 0x86, 0xb4, 0x4d, 0x3e, 0x86, 0x96, 0x8c, 0x62,
+	0xe4, 0x99, 0x74, 0x1a, 0x3d, 0x6b, 0x6f, 0xf3, 0x60, 0xa7, 0x6f, 0x5a, 0xea, 0x97, 0x2d, 0xf5,
+	0x1f, 0x17, 0xb7, 0x0d, 0xda, 0x2f, 0x72, 0xaf, 0xf6, 0xe3, 0x9f, 0x9e, 0x45, 0x4b, 0x8e, 0x3a,
+	0x5a, 0xeb, 0xea, 0x34, 0x75, 0x3f, 0xc6, 0x21, 0xc7, 0xd0, 0x1d, 0xb1, 0xd1, 0x79, 0x34, 0x1d,
+	0x7f, 0x99, 0x28, 0x66, 0xea, 0xb4, 0x74, 0xed, 0xdd, 0x7e, 0xe5, 0x59, 0x1e, 0x5d, 0xcb, 0x08,
+	0xea, 0xaa, 0x38, 0x7d, 0x8d, 0x47, 0x0e, 0xe0, 0xcd, 0x98, 0xcd, 0x06, 0x29, 0xcf, 0xc4, 0x08,
+	0x07, 0x02, 0x53, 0x3e, 0xc9, 0x54, 0xc4, 0x69, 0xeb, 0xf3, 0xb6, 0x63, 0x36, 0x3b, 0xd5, 0x31,
+	0xba, 0x0a, 0xf9, 0x67, 0xe0, 0x54, 0x75, 0x4b, 0x13, 0x3e, 0x4d, 0xf1, 0x18, 0x59, 0x88, 0x82,
+	0xec, 0x40, 0xfd, 0x0b, 0x16, 0xa3, 0x91, 0x2f, 0x68, 0x2c, 0x73, 0xcf, 0xba, 0x4f, 0x35, 0x44,
+	0xee, 0x42, 0xf3, 0x2b, 0x36, 0xc9, 0x30, 0x75, 0x36, 0x7a, 0xf6, 0x3a, 0x58, 0x80, 0xfe, 0xcf,
+	0x1b, 0x40, 0x6e, 0x96, 0x25, 0x3e, 0x34, 0x4f, 0x25, 0x93, 0x59, 0x5a, 0x94, 0x84, 0x65, 0xee,
+	0x35, 0x53, 0x8d, 0xd0, 0x22, 0x42, 0x3e, 0x81, 0xfa, 0x63, 0x26, 0x99, 0xb3, 0x71, 0x53, 0x84,
+	0x75, 0x45, 0x95, 0x11, 0xbc, 0xa5, 0x44, 0x58, 0xe6, 0x5e, 0x37, 0x64, 0x92, 0xdd, 0xe3, 0x71,
+	0x24, 0x31, 0x4e, 0xe4, 0x9c, 0x6a, 0x3e, 0xf9, 0x00, 0x3a, 0x47, 0x42, 0x70, 0x71, 0x36, 0x4f,
+	0x50, 0xbf, 0x6b, 0x27, 0x78, 0x7b, 0x99, 0x7b, 0xdb, 0x58, 0x82, 0x15, 0xc6, 0x3a, 0x93, 0xbc,
+	0x07, 0x0d, 0xed, 0xe8, 0x77, 0xef, 0x04, 0xdb, 0xcb, 0xdc, 0xbb, 0xad, 0x29, 0x95, 0x74, 0x93,
+	0x41, 0x8e, 0xa0, 0x65, 0x84, 0x4a, 0x9d, 0x46, 0xcf, 0xde, 0xdb, 0x3c, 0x78, 0xf7, 0xdf, 0x9b,
+	0xbd, 0xae, 0x6a, 0x29, 0x55, 0xc9, 0xf5, 0xbf, 0xb7, 0xa0, 0x7b, 0xfd, 0x66, 0xa4, 0x0f, 0x40,
+	0x31, 0xcd, 0x26, 0x52, 0x37, 0x6f, 0xb4, 0xea, 0x2e, 0x73, 0x0f, 0xc4, 0x0a, 0xa5, 0x95, 0x0c,
+	0xf2, 0x10, 0x9a, 0xc6, 0xd3, 0xaf, 0xb1, 0x79, 0xe0, 0x54, 0x1b, 0x39, 0x65, 0x71, 0x32, 0xc1,
+	0x53, 0x29, 0x90, 0xc5, 0x41, 0xb7, 0xd0, 0xac, 0x69, 0x2a, 0xd1, 0x82, 0xe7, 0xff, 0x66, 0xc1,
+	0xad, 0x6a, 0x22, 0x99, 0x41, 0x73, 0xc2, 0x86, 0x38, 0x51, 0x4f, 0xa5, 0x4a, 0x6e, 0xf7, 0xcb,
+	0x99, 0xec, 0x7f, 0xae, 0xf0, 0x13, 0x16, 0x89, 0xe0, 0x89, 0xaa, 0xf6, 0x47, 0xee, 0xfd, 0xaf,
+	0x99, 0x36, 0xfc, 0xc3, 0x90, 0x25, 0x12, 0x85, 0x6a, 0x25, 0x46, 0x29, 0xa2, 0x11, 0x2d, 0xce,
+	0x23, 0x1f, 0x41, 0x2b, 0xd5, 0x9d, 0xa4, 0xc5, 0x6d, 0xb6, 0xd6, 0x47, 0x9b, 0x16, 0xd7, 0xb7,
+	0xf8, 0x56, 0x7f, 0x6e, 0xb4, 0x24, 0xf8, 0x4f, 0xa1, 0xab, 0x26, 0x05, 0xc3, 0xd5, 0x27, 0xb7,
+	0x03, 0xf6, 0x33, 0x9c, 0x17, 0x1a, 0xb6, 0x96, 0xb9, 0xa7, 0x5c, 0xaa, 0x7e, 0xd4, 0x34, 0xe3,
+	0x4c, 0xe2, 0x54, 0x96, 0x07, 0x91, 0xaa, 0x6c, 0x47, 0x3a, 0x14, 0xdc, 0x2e, 0x8e, 0x2a, 0x53,
+	0x69, 0x69, 0xf8, 0xbf, 0x58, 0xd0, 0x34, 0x49, 0xc4, 0x2b, 0x77, 0x8a, 0x3a, 0xc6, 0x0e, 0x3a,
+	0xcb, 0xdc, 0x33, 0x40, 0xb9, 0x5e, 0x76, 0xcc, 0x7a, 0xd1, 0x2b, 0xc7, 0x74, 0x81, 0xd3, 0xd0,
+	0xec, 0x99, 0x1e, 0xb4, 0xa5, 0x60, 0x23, 0x1c, 0x44, 0x61, 0xf1, 0xcd, 0x95, 0x1f, 0x88, 0x86,
+	0x3f, 0x0d, 0xc9, 0x03, 0x68, 0x8b, 0xe2, 0x3a, 0xc5, 0xda, 0xb9, 0x73, 0x63, 0xed, 0x1c, 0x4e,
+	0xe7, 0xc1, 0xad, 0x65, 0xee, 0xad, 0x32, 0xe9, 0xca, 0xfa, 0xac, 0xde, 0xb6, 0xb7, 0xea, 0xfe,
+	0xef, 0x16, 0x10, 0xa3, 0xcd, 0xf1, 0xd9, 0xd9, 0xc9, 0x4a, 0x9f, 0x77, 0xa0, 0xa3, 0xb6, 0x08,
+	0x0e, 0x56, 0x2a, 0xd1, 0xb6, 0x06, 0x9e, 0xe0, 0x9c, 0x78, 0xb0, 0x69, 0xa6, 0x73, 0x30, 0xe2,
+	0x21, 0xea, 0xf6, 0x1b, 0x14, 0x0c, 0xf4, 0x88, 0x87, 0x48, 0x1e, 0x40, 0xeb, 0xbc, 0x18, 0x01,
+	0xfb, 0xbf, 0x8f, 0x00, 0x2d, 0x49, 0x6a, 0xc9, 0x0e, 0x79, 0x38, 0xd7, 0x17, 0xbf, 0x45, 0xb5,
+	0x4d, 0xee, 0x02, 0xe0, 0x2c, 0x89, 0x04, 0xa6, 0x03, 0x66, 0xf6, 0xac, 0x4d, 0x3b, 0x05, 0x72,
+	0x28, 0xfd, 0x7b, 0xe6, 0x89, 0x2b, 0x6b, 0x6f, 0x17, 0xda, 0x61, 0x94, 0xb2, 0xe1, 0x04, 0x43,
+	0x7d, 0x83, 0x36, 0x5d, 0xf9, 0xc1, 0xc3, 0x8b, 0x4b, 0xb7, 0xf6, 0xf2, 0xd2, 0xad, 0xbd, 0xba,
+	0x74, 0xad, 0xef, 0x16, 0xae, 0xf5, 0xd3, 0xc2, 0xb5, 0x5e, 0x2c, 0x5c, 0xeb, 0x62, 0xe1, 0x5a,
+	0x7f, 0x2d, 0x5c, 0xeb, 0xef, 0x85, 0x5b, 0x7b, 0xb5, 0x70, 0xad, 0x1f, 0xae, 0xdc, 0xda, 0xc5,
+	0x95, 0x5b, 0x7b, 0x79, 0xe5, 0xd6, 0xbe, 0xae, 0xfc, 0xfd, 0x0d, 0x9b, 0x5a, 0xe3, 0xf7, 0xff,
+	0x19, 0x00, 0x92, 0x07, 0x89, 0x12, 0x25, 0x07, 0x00, 0x00,
 }
 
 func (this *PrometheusRequest) Equal(that interface{}) bool {
@@ -615,6 +709,9 @@ func (this *PrometheusRequest) Equal(that interface{}) bool {
 	if !this.CachingOptions.Equal(&that1.CachingOptions) {
 		return false
 	}
+	if this.MaxSourceResolution != that1.MaxSourceResolution {
+		return false
+	}
 	return true
 }
 func (this *PrometheusResponseHeader) Equal(that interface{}) bool {
@@ -824,6 +921,47 @@ func (this *Extent) Equal(that interface{}) bool {
 	}
 	return true
 }
+func (this *CachedHTTPResponse) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*CachedHTTPResponse)
+	if !ok {
+		that2, ok := that.(CachedHTTPResponse)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.CacheKey != that1.CacheKey {
+		return false
+	}
+	if this.StatusCode != that1.StatusCode {
+		return false
+	}
+	if len(this.Headers) != len(that1.Headers) {
+		return false
+	}
+	for i := range this.Headers {
+		if !this.Headers[i].Equal(that1.Headers[i]) {
+			return false
+		}
+	}
+	if !bytes.Equal(this.Body, that1.Body) {
+		return false
+	}
+	if this.ExpiresAt != that1.ExpiresAt {
+		return false
+	}
+	return true
+}
 func (this *CachingOptions) Equal(that interface{}) bool {
 	if that == nil {
 		return this == nil
@@ -852,7 +990,7 @@ func (this *PrometheusRequest) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 11)
+	s := make([]string, 0, 12)
 	s = append(s, "&queryrange.PrometheusRequest{")
 	s = append(s, "Path: "+fmt.Sprintf("%#v", this.Path)+",\n")
 	s = append(s, "Start: "+fmt.Sprintf("%#v", this.Start)+",\n")
@@ -861,6 +999,7 @@ func (this *PrometheusRequest) GoString() string {
 	s = append(s, "Timeout: "+fmt.Sprintf("%#v", this.Timeout)+",\n")
 	s = append(s, "Query: "+fmt.Sprintf("%#v", this.Query)+",\n")
 	s = append(s, "CachingOptions: "+strings.Replace(this.CachingOptions.GoString(), `&`, ``, 1)+",\n")
+	s = append(s, "MaxSourceResolution: "+fmt.Sprintf("%#v", this.MaxSourceResolution)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -957,6 +1096,22 @@ func (this *Extent) GoString() string {
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
+func (this *CachedHTTPResponse) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 9)
+	s = append(s, "&queryrange.CachedHTTPResponse{")
+	s = append(s, "CacheKey: "+fmt.Sprintf("%#v", this.CacheKey)+",\n")
+	s = append(s, "StatusCode: "+fmt.Sprintf("%#v", this.StatusCode)+",\n")
+	if this.Headers != nil {
+		s = append(s, "Headers: "+fmt.Sprintf("%#v", this.Headers)+",\n")
+	}
+	s = append(s, "Body: "+fmt.Sprintf("%#v", this.Body)+",\n")
+	s = append(s, "ExpiresAt: "+fmt.Sprintf("%#v", this.ExpiresAt)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
 func (this *CachingOptions) GoString() string {
 	if this == nil {
 		return "nil"
@@ -995,6 +1150,13 @@ func (m *PrometheusRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.MaxSourceResolution) > 0 {
+		i -= len(m.MaxSourceResolution)
+		copy(dAtA[i:], m.MaxSourceResolution)
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.MaxSourceResolution)))
+		i--
+		dAtA[i] = 0x42
+	}
 	{
 		size, err := m.CachingOptions.MarshalToSizedBuffer(dAtA[:i])
 		if err != nil {
@@ -1343,6 +1505,67 @@ func (m *Extent) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *CachedHTTPResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CachedHTTPResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CachedHTTPResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.ExpiresAt != 0 {
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.ExpiresAt))
+		i--
+		dAtA[i] = 0x28
+	}
+	if len(m.Body) > 0 {
+		i -= len(m.Body)
+		copy(dAtA[i:], m.Body)
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Body)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if len(m.Headers) > 0 {
+		for iNdEx := len(m.Headers) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Headers[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintQueryrange(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if m.StatusCode != 0 {
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.StatusCode))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.CacheKey) > 0 {
+		i -= len(m.CacheKey)
+		copy(dAtA[i:], m.CacheKey)
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.CacheKey)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func (m *CachingOptions) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -1414,6 +1637,10 @@ func (m *PrometheusRequest) Size() (n int) {
 	}
 	l = m.CachingOptions.Size()
 	n += 1 + l + sovQueryrange(uint64(l))
+	l = len(m.MaxSourceResolution)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
 	return n
 }
 
@@ -1547,6 +1774,35 @@ func (m *Extent) Size() (n int) {
 	return n
 }
 
+func (m *CachedHTTPResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.CacheKey)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	if m.StatusCode != 0 {
+		n += 1 + sovQueryrange(uint64(m.StatusCode))
+	}
+	if len(m.Headers) > 0 {
+		for _, e := range m.Headers {
+			l = e.Size()
+			n += 1 + l + sovQueryrange(uint64(l))
+		}
+	}
+	l = len(m.Body)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	if m.ExpiresAt != 0 {
+		n += 1 + sovQueryrange(uint64(m.ExpiresAt))
+	}
+	return n
+}
+
 func (m *CachingOptions) Size() (n int) {
 	if m == nil {
 		return 0
@@ -1574,9 +1830,10 @@ func (this *PrometheusRequest) String() string {
 		`Start:` + fmt.Sprintf("%v", this.Start) + `,`,
 		`End:` + fmt.Sprintf("%v", this.End) + `,`,
 		`Step:` + fmt.Sprintf("%v", this.Step) + `,`,
-		`Timeout:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.Timeout), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
+		`Timeout:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.Timeout), "Duration", "durationpb.Duration", 1), `&`, ``, 1) + `,`,
 		`Query:` + fmt.Sprintf("%v", this.Query) + `,`,
 		`CachingOptions:` + strings.Replace(strings.Replace(this.CachingOptions.String(), "CachingOptions", "CachingOptions", 1), `&`, ``, 1) + `,`,
+		`MaxSourceResolution:` + fmt.Sprintf("%v", this.MaxSourceResolution) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1672,6 +1929,25 @@ func (this *Extent) String() string {
 	}, "")
 	return s
 }
+func (this *CachedHTTPResponse) String() string {
+	if this == nil {
+		return "nil"
+	}
+	repeatedStringForHeaders := "[]*PrometheusResponseHeader{"
+	for _, f := range this.Headers {
+		repeatedStringForHeaders += strings.Replace(f.String(), "PrometheusResponseHeader", "PrometheusResponseHeader", 1) + ","
+	}
+	repeatedStringForHeaders += "}"
+	s := strings.Join([]string{`&CachedHTTPResponse{`,
+		`CacheKey:` + fmt.Sprintf("%v", this.CacheKey) + `,`,
+		`StatusCode:` + fmt.Sprintf("%v", this.StatusCode) + `,`,
+		`Headers:` + repeatedStringForHeaders + `,`,
+		`Body:` + fmt.Sprintf("%v", this.Body) + `,`,
+		`ExpiresAt:` + fmt.Sprintf("%v", this.ExpiresAt) + `,`,
+		`}`,
+	}, "")
+	return s
+}
 func (this *CachingOptions) String() string {
 	if this == nil {
 		return "nil"
@@ -1906,6 +2182,38 @@ func (m *PrometheusRequest) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxSourceResolution", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MaxSourceResolution = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -2781,6 +3089,197 @@ func (m *Extent) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *CachedHTTPResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowQueryrange
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CachedHTTPResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CachedHTTPResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CacheKey", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CacheKey = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StatusCode", wireType)
+			}
+			m.StatusCode = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StatusCode |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Headers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Headers = append(m.Headers, &PrometheusResponseHeader{})
+			if err := m.Headers[len(m.Headers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Body", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Body = append(m.Body[:0], dAtA[iNdEx:postIndex]...)
+			if m.Body == nil {
+				m.Body = []byte{}
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExpiresAt", wireType)
+			}
+			m.ExpiresAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ExpiresAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipQueryrange(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func (m *CachingOptions) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
@@ -63,6 +63,13 @@ func (confs ShardingConfigs) GetConf(r Request) (chunk.PeriodConfig, error) {
 	return conf, nil
 }
 
+// BlocksShardingConfigs returns the sharding configs used with the blocks storage, which
+// has no schema: series are assigned to shards at query time by hashing their labels,
+// so the same number of shards applies to the whole time range.
+func BlocksShardingConfigs(totalShards int) ShardingConfigs {
+	return ShardingConfigs{{RowShards: uint32(totalShards)}}
+}
+
 func (confs ShardingConfigs) hasShards() bool {
 	for _, conf := range confs {
 		if conf.RowShards > 0 {
@@ -255,6 +255,14 @@ func TestShardingConfigs_ValidRange(t *testing.T) {
 				RowShards: 2,
 			},
 		},
+		{
+			name:  "blocks storage config covers any time range",
+			confs: BlocksShardingConfigs(16),
+			req:   reqWith("1970-01-02", "2019-11-25"),
+			expected: chunk.PeriodConfig{
+				RowShards: 16,
+			},
+		},
 	}
 
 	for _, c := range testExpr {
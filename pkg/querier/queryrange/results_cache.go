@@ -27,6 +27,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 	"github.com/cortexproject/cortex/pkg/tenant"
 	util_log "github.com/cortexproject/cortex/pkg/util/log"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
@@ -119,7 +120,14 @@ type constSplitter time.Duration
 // GenerateCacheKey generates a cache key based on the userID, Request and interval.
 func (t constSplitter) GenerateCacheKey(userID string, r Request) string {
 	currentInterval := r.GetStart() / int64(time.Duration(t)/time.Millisecond)
-	return fmt.Sprintf("%s:%s:%d:%d", userID, r.GetQuery(), r.GetStep(), currentInterval)
+	key := fmt.Sprintf("%s:%s:%d:%d", userID, r.GetQuery(), r.GetStep(), currentInterval)
+
+	// Results depend on the resolution of the queried blocks.
+	if promReq, ok := r.(*PrometheusRequest); ok && promReq.MaxSourceResolution != "" {
+		key += ":" + promReq.MaxSourceResolution
+	}
+
+	return key
 }
 
 // ShouldCacheFn checks whether the current request should go to cache
@@ -215,6 +223,7 @@ func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 
 	cached, ok := s.get(ctx, key)
 	if ok {
+		// The hits are tracked by handleHit, since cached results may cover the request only partially.
 		response, extents, err = s.handleHit(ctx, r, cached, maxCacheTime)
 	} else {
 		response, extents, err = s.handleMiss(ctx, r, maxCacheTime)
@@ -241,7 +250,7 @@ func (s resultsCache) shouldCacheResponse(ctx context.Context, req Request, r Re
 		}
 	}
 
-	if !s.isAtModifierCachable(req, maxCacheTime) {
+	if !isAtModifierCachable(s.logger, req, maxCacheTime) {
 		return false
 	}
 
@@ -271,7 +280,7 @@ var errAtModifierAfterEnd = errors.New("at modifier after end")
 
 // isAtModifierCachable returns true if the @ modifier result
 // is safe to cache.
-func (s resultsCache) isAtModifierCachable(r Request, maxCacheTime int64) bool {
+func isAtModifierCachable(logger log.Logger, r Request, maxCacheTime int64) bool {
 	// There are 2 cases when @ modifier is not safe to cache:
 	//   1. When @ modifier points to time beyond the maxCacheTime.
 	//   2. If the @ modifier time is > the query range end while being
@@ -285,7 +294,7 @@ func (s resultsCache) isAtModifierCachable(r Request, maxCacheTime int64) bool {
 	expr, err := parser.ParseExpr(query)
 	if err != nil {
 		// We are being pessimistic in such cases.
-		level.Warn(s.logger).Log("msg", "failed to parse query, considering @ modifier as not cachable", "query", query, "err", err)
+		level.Warn(logger).Log("msg", "failed to parse query, considering @ modifier as not cachable", "query", query, "err", err)
 		return false
 	}
 
@@ -332,6 +341,8 @@ func getHeaderValuesWithName(r Response, headerName string) (headerValues []stri
 }
 
 func (s resultsCache) handleMiss(ctx context.Context, r Request, maxCacheTime int64) (Response, []Extent, error) {
+	querier_stats.FromContext(ctx).AddResultsCacheMisses(1)
+
 	response, err := s.next.Do(ctx, r)
 	if err != nil {
 		return nil, nil, err
@@ -365,11 +376,15 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		return nil, nil, err
 	}
 	if len(requests) == 0 {
+		querier_stats.FromContext(ctx).AddResultsCacheHits(1)
+
 		response, err := s.merger.MergeResponse(responses...)
 		// No downstream requests so no need to write back to the cache.
 		return response, nil, err
 	}
 
+	querier_stats.FromContext(ctx).AddResultsCacheMisses(1)
+
 	reqResps, err = DoRequests(ctx, s.next, requests, s.limits)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,548 @@
+package queryrange
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/dskit/concurrency"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/querier/downsampling"
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+type httpResultsCacheQueryType int
+
+const (
+	notCacheableQuery httpResultsCacheQueryType = iota
+	instantQuery
+	labelNamesQuery
+	labelValuesQuery
+	seriesQuery
+)
+
+const labelsQuerySplitInterval = day
+
+// httpResultsCache is a http.RoundTripper caching the responses of instant queries and of
+// the label names, label values and series APIs, for the time to live configured per-tenant.
+//
+// Instant query responses are cached by query and evaluation time. Labels queries are split by
+// day, so that the results of the days fully covered by the requested time range can be reused
+// across requests with a different time range, and the responses of each split are cached by
+// matchers and time range.
+//
+// Requests which may return results still in flux, because they query a time more recent than
+// the max cache freshness, are not cached.
+type httpResultsCache struct {
+	logger               log.Logger
+	next                 http.RoundTripper
+	cache                cache.Cache
+	limits               Limits
+	cacheGenNumberLoader CacheGenNumberLoader
+}
+
+func newHTTPResultsCache(logger log.Logger, next http.RoundTripper, c cache.Cache, limits Limits, cacheGenNumberLoader CacheGenNumberLoader) http.RoundTripper {
+	return &httpResultsCache{
+		logger:               logger,
+		next:                 next,
+		cache:                c,
+		limits:               limits,
+		cacheGenNumberLoader: cacheGenNumberLoader,
+	}
+}
+
+// getHTTPResultsCacheQueryType returns the type of query served by the input path.
+func getHTTPResultsCacheQueryType(path string) httpResultsCacheQueryType {
+	switch {
+	case strings.HasSuffix(path, "/query"):
+		return instantQuery
+	case strings.HasSuffix(path, "/labels"):
+		return labelNamesQuery
+	case strings.HasSuffix(path, "/values") && strings.Contains(path, "/label/"):
+		return labelValuesQuery
+	case strings.HasSuffix(path, "/series"):
+		return seriesQuery
+	default:
+		return notCacheableQuery
+	}
+}
+
+func (c *httpResultsCache) RoundTrip(r *http.Request) (*http.Response, error) {
+	// The series API also supports DELETE, which must never be cached.
+	queryType := getHTTPResultsCacheQueryType(r.URL.Path)
+	if queryType == notCacheableQuery || (r.Method != http.MethodGet && r.Method != http.MethodPost) {
+		return c.next.RoundTrip(r)
+	}
+
+	for _, value := range r.Header.Values(cacheControlHeader) {
+		if strings.Contains(value, noStoreValue) {
+			return c.next.RoundTrip(r)
+		}
+	}
+
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	ttlFn := c.limits.ResultsCacheTTLForLabelsQuery
+	if queryType == instantQuery {
+		ttlFn = c.limits.ResultsCacheTTLForInstantQuery
+	}
+	ttl := validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, ttlFn)
+	if ttl <= 0 {
+		return c.next.RoundTrip(r)
+	}
+
+	form, err := readRequestForm(r)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := r.Context()
+	if c.cacheGenNumberLoader != nil {
+		ctx = cache.InjectCacheGenNumber(ctx, c.cacheGenNumberLoader.GetResultsCacheGenNumber(tenantIDs))
+	}
+
+	// Responses are cached uncompressed, and labels queries responses need to be decoded to be merged.
+	r = r.Clone(ctx)
+	r.Header.Del("Accept-Encoding")
+
+	maxCacheFreshness := validation.MaxDurationPerTenant(tenantIDs, c.limits.MaxCacheFreshness)
+	maxCacheTime := int64(model.Now().Add(-maxCacheFreshness))
+
+	if queryType == instantQuery {
+		return c.roundTripInstantQuery(r, form, tenant.JoinTenantIDs(tenantIDs), ttl, maxCacheTime)
+	}
+	return c.roundTripLabelsQuery(r, form, queryType, tenantIDs, ttl, maxCacheTime)
+}
+
+func (c *httpResultsCache) roundTripInstantQuery(r *http.Request, form url.Values, tenantID string, ttl time.Duration, maxCacheTime int64) (*http.Response, error) {
+	// Queries without an explicit time are evaluated at the current time, so they're not cacheable.
+	if form.Get("time") == "" {
+		return c.next.RoundTrip(r)
+	}
+
+	ts, err := util.ParseTime(form.Get("time"))
+	if err != nil || ts > maxCacheTime {
+		return c.next.RoundTrip(r)
+	}
+
+	query := form.Get("query")
+	if !isAtModifierCachable(c.logger, &PrometheusRequest{Query: query, Start: ts, End: ts}, maxCacheTime) {
+		return c.next.RoundTrip(r)
+	}
+
+	key := fmt.Sprintf("instant:%s:%s:%d", tenantID, query, ts)
+	if resolution := form.Get(downsampling.MaxSourceResolutionParam); resolution != "" {
+		key += ":" + resolution
+	}
+
+	if cached, ok := c.get(r.Context(), []string{key})[key]; ok {
+		querier_stats.FromContext(r.Context()).AddResultsCacheHits(1)
+		return cached.toHTTPResponse(r), nil
+	}
+	querier_stats.FromContext(r.Context()).AddResultsCacheMisses(1)
+
+	resp, body, err := c.doRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK && c.shouldCacheResponse(r.Context(), resp.Header) {
+		c.put(r.Context(), key, resp, body, ttl)
+	}
+	return resp, nil
+}
+
+type labelsQuerySplit struct {
+	start, end int64
+	key        string // Empty if not cacheable.
+}
+
+func (c *httpResultsCache) roundTripLabelsQuery(r *http.Request, form url.Values, queryType httpResultsCacheQueryType, tenantIDs []string, ttl time.Duration, maxCacheTime int64) (*http.Response, error) {
+	// Requests without an explicit time range query up until the current time, so they're not cacheable.
+	if form.Get("start") == "" || form.Get("end") == "" {
+		return c.next.RoundTrip(r)
+	}
+
+	start, err := util.ParseTime(form.Get("start"))
+	if err != nil {
+		return c.next.RoundTrip(r)
+	}
+	end, err := util.ParseTime(form.Get("end"))
+	if err != nil || end < start {
+		return c.next.RoundTrip(r)
+	}
+
+	matchers := append([]string(nil), form["match[]"]...)
+	sort.Strings(matchers)
+
+	splits := splitLabelsQueryByDay(start, end)
+	cacheable := false
+	for i := range splits {
+		if splits[i].end <= maxCacheTime {
+			splits[i].key = fmt.Sprintf("labels:%s:%s:%q:%d:%d", tenant.JoinTenantIDs(tenantIDs), r.URL.Path, matchers, splits[i].start, splits[i].end)
+			cacheable = true
+		}
+	}
+	if !cacheable {
+		return c.next.RoundTrip(r)
+	}
+
+	keys := make([]string, 0, len(splits))
+	for _, split := range splits {
+		if split.key != "" {
+			keys = append(keys, split.key)
+		}
+	}
+	cached := c.get(r.Context(), keys)
+
+	var (
+		mtx       sync.Mutex
+		bodies    = make([][]byte, len(splits))
+		failedRes *http.Response
+		jobs      []interface{}
+	)
+
+	for i, split := range splits {
+		if res, ok := cached[split.key]; ok {
+			bodies[i] = res.Body
+			querier_stats.FromContext(r.Context()).AddResultsCacheHits(1)
+			continue
+		}
+		if split.key != "" {
+			querier_stats.FromContext(r.Context()).AddResultsCacheMisses(1)
+		}
+		jobs = append(jobs, i)
+	}
+
+	parallelism := validation.SmallestPositiveIntPerTenant(tenantIDs, c.limits.MaxQueryParallelism)
+	err = concurrency.ForEach(r.Context(), jobs, parallelism, func(ctx context.Context, job interface{}) error {
+		i := job.(int)
+
+		req, err := newLabelsQuerySplitRequest(r, form, splits[i].start, splits[i].end)
+		if err != nil {
+			return err
+		}
+
+		resp, body, err := c.doRequest(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			mtx.Lock()
+			failedRes = resp
+			mtx.Unlock()
+			return errLabelsQuerySplitFailed
+		}
+
+		mtx.Lock()
+		bodies[i] = body
+		mtx.Unlock()
+
+		if splits[i].key != "" && c.shouldCacheResponse(ctx, resp.Header) && !hasWarnings(body) {
+			c.put(ctx, splits[i].key, resp, body, ttl)
+		}
+		return nil
+	})
+	if failedRes != nil {
+		return failedRes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := mergeLabelsQueryResponses(queryType, bodies)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "error merging labels query responses: %v", err)
+	}
+
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(merged)),
+		ContentLength: int64(len(merged)),
+		Request:       r,
+	}, nil
+}
+
+var errLabelsQuerySplitFailed = fmt.Errorf("labels query split failed")
+
+// splitLabelsQueryByDay splits the input time range (both inclusive) into non overlapping
+// ranges, aligned to the day.
+func splitLabelsQueryByDay(start, end int64) []labelsQuerySplit {
+	interval := labelsQuerySplitInterval.Milliseconds()
+
+	var splits []labelsQuerySplit
+	for splitStart := start; splitStart <= end; {
+		splitEnd := (splitStart/interval+1)*interval - 1
+		if splitEnd > end {
+			splitEnd = end
+		}
+		splits = append(splits, labelsQuerySplit{start: splitStart, end: splitEnd})
+		splitStart = splitEnd + 1
+	}
+	return splits
+}
+
+// newLabelsQuerySplitRequest returns a copy of the input request with the time range replaced.
+func newLabelsQuerySplitRequest(r *http.Request, form url.Values, start, end int64) (*http.Request, error) {
+	params := url.Values{}
+	for name, values := range form {
+		params[name] = values
+	}
+	params.Set("start", formatTime(start))
+	params.Set("end", formatTime(end))
+	encoded := params.Encode()
+
+	req := r.Clone(r.Context())
+	if r.Method == http.MethodPost {
+		req.Body = ioutil.NopCloser(strings.NewReader(encoded))
+		req.ContentLength = int64(len(encoded))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.URL.RawQuery = ""
+		return req, nil
+	}
+
+	req.Body = nil
+	req.ContentLength = 0
+	req.URL.RawQuery = encoded
+	return req, nil
+}
+
+func formatTime(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+}
+
+type labelsQueryResponse struct {
+	Status   string              `json:"status"`
+	Data     jsoniter.RawMessage `json:"data"`
+	Warnings []string            `json:"warnings,omitempty"`
+}
+
+func hasWarnings(body []byte) bool {
+	var resp labelsQueryResponse
+	return json.Unmarshal(body, &resp) != nil || len(resp.Warnings) > 0
+}
+
+// mergeLabelsQueryResponses merges the responses of the splits of a labels query, removing duplicates.
+func mergeLabelsQueryResponses(queryType httpResultsCacheQueryType, bodies [][]byte) ([]byte, error) {
+	var (
+		warnings    = map[string]struct{}{}
+		values      = map[string]struct{}{}
+		series      = map[string]labels.Labels{}
+		mergedValue interface{}
+	)
+
+	for _, body := range bodies {
+		var resp labelsQueryResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		for _, w := range resp.Warnings {
+			warnings[w] = struct{}{}
+		}
+
+		if queryType == seriesQuery {
+			var data []map[string]string
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				return nil, err
+			}
+			for _, s := range data {
+				lbls := labels.FromMap(s)
+				series[lbls.String()] = lbls
+			}
+			continue
+		}
+
+		var data []string
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			return nil, err
+		}
+		for _, v := range data {
+			values[v] = struct{}{}
+		}
+	}
+
+	if queryType == seriesQuery {
+		result := make([]labels.Labels, 0, len(series))
+		for _, s := range series {
+			result = append(result, s)
+		}
+		sort.Slice(result, func(i, j int) bool { return labels.Compare(result[i], result[j]) < 0 })
+		mergedValue = result
+	} else {
+		mergedValue = sortedStrings(values)
+	}
+
+	return json.Marshal(struct {
+		Status   string      `json:"status"`
+		Data     interface{} `json:"data"`
+		Warnings []string    `json:"warnings,omitempty"`
+	}{
+		Status:   StatusSuccess,
+		Data:     mergedValue,
+		Warnings: sortedStrings(warnings),
+	})
+}
+
+func sortedStrings(m map[string]struct{}) []string {
+	result := make([]string, 0, len(m))
+	for v := range m {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// doRequest executes the request and returns the response along with its body, which is read
+// and replaced so that the response can still be consumed by the caller.
+func (c *httpResultsCache) doRequest(r *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.next.RoundTrip(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, body, nil
+}
+
+// shouldCacheResponse says whether the response with the input headers should be cached or not.
+func (c *httpResultsCache) shouldCacheResponse(ctx context.Context, header http.Header) bool {
+	for _, v := range header.Values(cacheControlHeader) {
+		if v == noStoreValue {
+			return false
+		}
+	}
+
+	if c.cacheGenNumberLoader == nil {
+		return true
+	}
+
+	genNumbersFromResp := header.Values(ResultsCacheGenNumberHeaderName)
+	genNumberFromCtx := cache.ExtractCacheGenNumber(ctx)
+
+	if len(genNumbersFromResp) == 0 && genNumberFromCtx != "" {
+		return false
+	}
+	for _, gen := range genNumbersFromResp {
+		if gen != genNumberFromCtx {
+			level.Debug(c.logger).Log("msg", fmt.Sprintf("inconsistency in results cache gen numbers %s (GEN-FROM-RESPONSE) != %s (GEN-FROM-STORE), not caching the response", gen, genNumberFromCtx))
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the cached, non expired, responses for the input keys.
+func (c *httpResultsCache) get(ctx context.Context, keys []string) map[string]*CachedHTTPResponse {
+	hashed := make([]string, 0, len(keys))
+	byHash := make(map[string]string, len(keys))
+	for _, key := range keys {
+		h := cache.HashKey(key)
+		hashed = append(hashed, h)
+		byHash[h] = key
+	}
+
+	found, bufs, _ := c.cache.Fetch(ctx, hashed)
+
+	now := util.TimeToMillis(time.Now())
+	result := make(map[string]*CachedHTTPResponse, len(found))
+	for i, h := range found {
+		var resp CachedHTTPResponse
+		if err := proto.Unmarshal(bufs[i], &resp); err != nil {
+			level.Error(c.logger).Log("msg", "error unmarshalling cached value", "err", err)
+			continue
+		}
+
+		if resp.CacheKey != byHash[h] || resp.ExpiresAt < now {
+			continue
+		}
+		result[resp.CacheKey] = &resp
+	}
+	return result
+}
+
+func (c *httpResultsCache) put(ctx context.Context, key string, resp *http.Response, body []byte, ttl time.Duration) {
+	cached := &CachedHTTPResponse{
+		CacheKey:   key,
+		StatusCode: int32(resp.StatusCode),
+		Body:       body,
+		ExpiresAt:  util.TimeToMillis(time.Now().Add(ttl)),
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		cached.Headers = []*PrometheusResponseHeader{{Name: "Content-Type", Values: []string{contentType}}}
+	}
+
+	buf, err := proto.Marshal(cached)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error marshalling cached value", "err", err)
+		return
+	}
+
+	c.cache.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
+}
+
+func (r *CachedHTTPResponse) toHTTPResponse(req *http.Request) *http.Response {
+	header := http.Header{}
+	for _, h := range r.Headers {
+		header[h.Name] = h.Values
+	}
+
+	return &http.Response{
+		StatusCode:    int(r.StatusCode),
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// readRequestForm returns the query and form parameters of the request, leaving the request
+// body untouched so that it can still be forwarded.
+func readRequestForm(r *http.Request) (url.Values, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r.URL.Query(), nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	clone := r.Clone(r.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := clone.ParseForm(); err != nil {
+		return nil, err
+	}
+	return clone.Form, nil
+}
@@ -0,0 +1,342 @@
+package queryrange
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+type mockRoundTripper struct {
+	mtx      sync.Mutex
+	requests []*http.Request
+	handler  func(r *http.Request) (int, http.Header, string)
+}
+
+func (m *mockRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	m.mtx.Lock()
+	m.requests = append(m.requests, r)
+	m.mtx.Unlock()
+
+	status, header, body := m.handler(r)
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+func (m *mockRoundTripper) numRequests() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.requests)
+}
+
+type staticCacheGenNumberLoader string
+
+func (l staticCacheGenNumberLoader) GetResultsCacheGenNumber(_ []string) string {
+	return string(l)
+}
+
+func TestHTTPResultsCache_InstantQuery(t *testing.T) {
+	now := time.Now()
+	oldTime := formatTime(util.TimeToMillis(now.Add(-time.Hour)))
+	recentTime := formatTime(util.TimeToMillis(now))
+
+	tests := map[string]struct {
+		limits             mockLimits
+		params             url.Values
+		header             http.Header
+		responseHeader     http.Header
+		responseStatus     int
+		expectedDownstream int
+	}{
+		"should cache queries with an explicit time": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour},
+			params:             url.Values{"query": {"up"}, "time": {oldTime}},
+			expectedDownstream: 1,
+		},
+		"should not cache if the TTL is 0": {
+			limits:             mockLimits{},
+			params:             url.Values{"query": {"up"}, "time": {oldTime}},
+			expectedDownstream: 2,
+		},
+		"should not cache queries without time": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour},
+			params:             url.Values{"query": {"up"}},
+			expectedDownstream: 2,
+		},
+		"should not cache queries more recent than the max cache freshness": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour, maxCacheFreshness: time.Minute},
+			params:             url.Values{"query": {"up"}, "time": {recentTime}},
+			expectedDownstream: 2,
+		},
+		"should not cache queries with the @ modifier after the max cache freshness": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour, maxCacheFreshness: time.Minute},
+			params:             url.Values{"query": {"up @ " + recentTime}, "time": {oldTime}},
+			expectedDownstream: 2,
+		},
+		"should not cache if caching is disabled by the request": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour},
+			params:             url.Values{"query": {"up"}, "time": {oldTime}},
+			header:             http.Header{cacheControlHeader: {noStoreValue}},
+			expectedDownstream: 2,
+		},
+		"should not cache if caching is disabled by the response": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour},
+			params:             url.Values{"query": {"up"}, "time": {oldTime}},
+			responseHeader:     http.Header{cacheControlHeader: {noStoreValue}},
+			expectedDownstream: 2,
+		},
+		"should not cache failed requests": {
+			limits:             mockLimits{resultsCacheTTLInstantQuery: time.Hour},
+			params:             url.Values{"query": {"up"}, "time": {oldTime}},
+			responseStatus:     http.StatusBadRequest,
+			expectedDownstream: 2,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			status := testData.responseStatus
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+				return status, testData.responseHeader, `{"status":"success","data":{"resultType":"vector","result":[]}}`
+			}}
+			rt := newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewMockCache(), testData.limits, nil)
+
+			for _, method := range []string{http.MethodPost, http.MethodGet} {
+				req := newTestHTTPRequest(t, method, "/api/v1/query", testData.params)
+				for name, values := range testData.header {
+					req.Header[name] = values
+				}
+
+				resp, err := rt.RoundTrip(req)
+				require.NoError(t, err)
+				assert.Equal(t, status, resp.StatusCode)
+
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, `{"status":"success","data":{"resultType":"vector","result":[]}}`, string(body))
+			}
+
+			assert.Equal(t, testData.expectedDownstream, downstream.numRequests())
+
+			// The body of the POST request must still be forwarded.
+			require.NoError(t, downstream.requests[0].ParseForm())
+			assert.Equal(t, testData.params.Get("query"), downstream.requests[0].PostForm.Get("query"))
+		})
+	}
+}
+
+func TestHTTPResultsCache_InstantQuery_ShouldInvalidateOnCacheGenNumberChange(t *testing.T) {
+	downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+		// Responses must not be compressed, to be cached as is.
+		assert.Empty(t, r.Header.Get("Accept-Encoding"))
+		return http.StatusOK, http.Header{ResultsCacheGenNumberHeaderName: {"1"}}, `{"status":"success"}`
+	}}
+
+	c := cache.NewMockCache()
+	limits := mockLimits{resultsCacheTTLInstantQuery: time.Hour}
+	params := url.Values{"query": {"up"}, "time": {"1600000000"}}
+
+	// The response gen number is different from the one in the store, so it's not cached.
+	rt := newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewCacheGenNumMiddleware(c), limits, staticCacheGenNumberLoader("2"))
+	for i := 0; i < 2; i++ {
+		_, err := rt.RoundTrip(newTestHTTPRequest(t, http.MethodGet, "/api/v1/query", params))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, downstream.numRequests())
+
+	// Once the gen numbers match, the response is cached.
+	rt = newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewCacheGenNumMiddleware(c), limits, staticCacheGenNumberLoader("1"))
+	for i := 0; i < 2; i++ {
+		_, err := rt.RoundTrip(newTestHTTPRequest(t, http.MethodGet, "/api/v1/query", params))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, downstream.numRequests())
+}
+
+func TestHTTPResultsCache_ShouldNotReturnExpiredResponses(t *testing.T) {
+	downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+		return http.StatusOK, nil, `{"status":"success"}`
+	}}
+
+	c := cache.NewMockCache()
+	rt := newHTTPResultsCache(log.NewNopLogger(), downstream, c, mockLimits{resultsCacheTTLInstantQuery: time.Hour}, nil).(*httpResultsCache)
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	rt.put(ctx, "expired", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, []byte("{}"), -time.Second)
+	rt.put(ctx, "valid", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, []byte("{}"), time.Hour)
+
+	cached := rt.get(ctx, []string{"expired", "valid", "missing"})
+	require.Len(t, cached, 1)
+	assert.Contains(t, cached, "valid")
+}
+
+func TestHTTPResultsCache_LabelsQuery(t *testing.T) {
+	dayMs := day.Milliseconds()
+	base := (util.TimeToMillis(time.Now().Add(-30*day)) / dayMs) * dayMs
+
+	// Each day has a different label value, plus a common one.
+	downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+		require.NoError(t, r.ParseForm())
+		start, err := util.ParseTime(r.Form.Get("start"))
+		require.NoError(t, err)
+		end, err := util.ParseTime(r.Form.Get("end"))
+		require.NoError(t, err)
+		assert.Equal(t, start/dayMs, end/dayMs, "split must not span multiple days")
+		assert.Equal(t, []string{`{job="test"}`}, r.Form["match[]"])
+
+		if strings.HasSuffix(r.URL.Path, "/series") {
+			return http.StatusOK, nil, `{"status":"success","data":[{"__name__":"up","day":"` + strconv.FormatInt((start-base)/dayMs, 10) + `"},{"__name__":"up"}]}`
+		}
+		return http.StatusOK, nil, `{"status":"success","data":["common","day-` + strconv.FormatInt((start-base)/dayMs, 10) + `"]}`
+	}}
+
+	rt := newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewMockCache(), mockLimits{resultsCacheTTLLabelsQuery: time.Hour}, nil)
+
+	query := func(path string, start, end int64) string {
+		resp, err := rt.RoundTrip(newTestHTTPRequest(t, http.MethodGet, path, url.Values{
+			"match[]": {`{job="test"}`},
+			"start":   {formatTime(start)},
+			"end":     {formatTime(end)},
+		}))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// The time range spans 3 days.
+	assert.JSONEq(t, `{"status":"success","data":["common","day-0","day-1","day-2"]}`, query("/api/v1/label/job/values", base+time.Hour.Milliseconds(), base+2*dayMs+time.Hour.Milliseconds()))
+	assert.Equal(t, 3, downstream.numRequests())
+
+	// Same request is fully cached.
+	assert.JSONEq(t, `{"status":"success","data":["common","day-0","day-1","day-2"]}`, query("/api/v1/label/job/values", base+time.Hour.Milliseconds(), base+2*dayMs+time.Hour.Milliseconds()))
+	assert.Equal(t, 3, downstream.numRequests())
+
+	// A different time range only queries the splits not cached yet: the partial
+	// first and last days, while the fully covered day in between is cached.
+	assert.JSONEq(t, `{"status":"success","data":["common","day-0","day-1","day-2"]}`, query("/api/v1/label/job/values", base+2*time.Hour.Milliseconds(), base+2*dayMs+2*time.Hour.Milliseconds()))
+	assert.Equal(t, 5, downstream.numRequests())
+
+	// Different endpoints are cached separately.
+	assert.JSONEq(t, `{"status":"success","data":["common","day-0","day-1","day-2"]}`, query("/api/v1/labels", base+time.Hour.Milliseconds(), base+2*dayMs+time.Hour.Milliseconds()))
+	assert.Equal(t, 8, downstream.numRequests())
+
+	assert.JSONEq(t, `{"status":"success","data":[{"__name__":"up"},{"__name__":"up","day":"0"},{"__name__":"up","day":"1"}]}`, query("/api/v1/series", base, base+dayMs+1))
+	assert.Equal(t, 10, downstream.numRequests())
+}
+
+func TestHTTPResultsCache_LabelsQuery_ShouldNotCacheRecentSplits(t *testing.T) {
+	downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+		return http.StatusOK, nil, `{"status":"success","data":["a"]}`
+	}}
+	rt := newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewMockCache(), mockLimits{resultsCacheTTLLabelsQuery: time.Hour, maxCacheFreshness: 10 * time.Minute}, nil)
+
+	now := util.TimeToMillis(time.Now())
+	params := url.Values{"start": {formatTime(now - 5*time.Minute.Milliseconds())}, "end": {formatTime(now)}}
+
+	for i := 0; i < 2; i++ {
+		_, err := rt.RoundTrip(newTestHTTPRequest(t, http.MethodGet, "/api/v1/labels", params))
+		require.NoError(t, err)
+	}
+
+	// Not split, nor cached.
+	assert.Equal(t, 2, downstream.numRequests())
+	assert.Equal(t, params.Get("start"), downstream.requests[0].URL.Query().Get("start"))
+}
+
+func TestHTTPResultsCache_LabelsQuery_ShouldNotCacheSeriesDeletion(t *testing.T) {
+	downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+		return http.StatusNoContent, nil, ""
+	}}
+	rt := newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewMockCache(), mockLimits{resultsCacheTTLLabelsQuery: time.Hour}, nil)
+
+	params := url.Values{"match[]": {`{job="test"}`}, "start": {"1600000000"}, "end": {"1600200000"}}
+	for i := 0; i < 2; i++ {
+		req := newTestHTTPRequest(t, http.MethodGet, "/api/v1/series", params)
+		req.Method = http.MethodDelete
+
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	// Not split, nor cached.
+	assert.Equal(t, 2, downstream.numRequests())
+}
+
+func TestHTTPResultsCache_LabelsQuery_ShouldReturnFailedSplitResponse(t *testing.T) {
+	downstream := &mockRoundTripper{handler: func(r *http.Request) (int, http.Header, string) {
+		return http.StatusUnprocessableEntity, nil, `{"status":"error","error":"too many series"}`
+	}}
+	rt := newHTTPResultsCache(log.NewNopLogger(), downstream, cache.NewMockCache(), mockLimits{resultsCacheTTLLabelsQuery: time.Hour}, nil)
+
+	resp, err := rt.RoundTrip(newTestHTTPRequest(t, http.MethodGet, "/api/v1/labels", url.Values{"start": {"1600000000"}, "end": {"1600200000"}}))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"status":"error","error":"too many series"}`, string(body))
+}
+
+func TestSplitLabelsQueryByDay(t *testing.T) {
+	dayMs := day.Milliseconds()
+
+	assert.Equal(t, []labelsQuerySplit{{start: 10, end: 20}}, splitLabelsQueryByDay(10, 20))
+	assert.Equal(t, []labelsQuerySplit{{start: 0, end: dayMs - 1}, {start: dayMs, end: dayMs}}, splitLabelsQueryByDay(0, dayMs))
+	assert.Equal(t, []labelsQuerySplit{
+		{start: 10, end: dayMs - 1},
+		{start: dayMs, end: 2*dayMs - 1},
+		{start: 2 * dayMs, end: 2*dayMs + 10},
+	}, splitLabelsQueryByDay(10, 2*dayMs+10))
+}
+
+func TestGetHTTPResultsCacheQueryType(t *testing.T) {
+	assert.Equal(t, instantQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/query"))
+	assert.Equal(t, notCacheableQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/query_range"))
+	assert.Equal(t, notCacheableQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/query_exemplars"))
+	assert.Equal(t, labelNamesQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/labels"))
+	assert.Equal(t, labelValuesQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/label/job/values"))
+	assert.Equal(t, seriesQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/series"))
+	assert.Equal(t, notCacheableQuery, getHTTPResultsCacheQueryType("/prometheus/api/v1/metadata"))
+}
+
+func newTestHTTPRequest(t *testing.T, method, path string, params url.Values) *http.Request {
+	var (
+		req *http.Request
+		err error
+	)
+
+	if method == http.MethodPost {
+		req, err = http.NewRequest(method, "http://localhost"+path, strings.NewReader(params.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequest(method, "http://localhost"+path+"?"+params.Encode(), nil)
+		require.NoError(t, err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	return req.WithContext(user.InjectOrgID(context.Background(), "user-1"))
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 )
 
 const (
@@ -781,6 +782,52 @@ func TestResultsCache(t *testing.T) {
 	require.Equal(t, 2, calls)
 }
 
+func TestResultsCache_ShouldTrackHitsAndMissesInQueryStats(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			Cache: cache.NewMockCache(),
+		},
+	}
+	rcm, _, err := NewResultsCacheMiddleware(
+		log.NewNopLogger(),
+		cfg,
+		constSplitter(day),
+		mockLimits{},
+		PrometheusCodec,
+		PrometheusResponseExtractor{},
+		nil,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		return parsedResponse, nil
+	}))
+
+	do := func(req Request) *querier_stats.Stats {
+		stats, ctx := querier_stats.ContextWithEmptyStats(user.InjectOrgID(context.Background(), "1"))
+		_, err := rc.Do(ctx, req)
+		require.NoError(t, err)
+		return stats
+	}
+
+	// The first request is a miss.
+	stats := do(parsedRequest)
+	assert.Equal(t, uint64(0), stats.LoadResultsCacheHits())
+	assert.Equal(t, uint64(1), stats.LoadResultsCacheMisses())
+
+	// The same request is fully served from the cache.
+	stats = do(parsedRequest)
+	assert.Equal(t, uint64(1), stats.LoadResultsCacheHits())
+	assert.Equal(t, uint64(0), stats.LoadResultsCacheMisses())
+
+	// A request partially served from the cache is a miss.
+	stats = do(parsedRequest.WithStartEnd(parsedRequest.GetStart(), parsedRequest.GetEnd()+100))
+	assert.Equal(t, uint64(0), stats.LoadResultsCacheHits())
+	assert.Equal(t, uint64(1), stats.LoadResultsCacheMisses())
+}
+
 func TestResultsCacheRecent(t *testing.T) {
 	var cfg ResultsCacheConfig
 	flagext.DefaultValues(&cfg)
@@ -943,6 +990,7 @@ func TestConstSplitter_generateCacheKey(t *testing.T) {
 		{"<1d", &PrometheusRequest{Start: toMs(22 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:0"},
 		{"4d", &PrometheusRequest{Start: toMs(4 * 24 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:4"},
 		{"3d5h", &PrometheusRequest{Start: toMs(77 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:3"},
+		{"max source resolution", &PrometheusRequest{Start: toMs(77 * time.Hour), Step: 10, Query: "foo{}", MaxSourceResolution: "5m"}, 24 * time.Hour, "fake:foo{}:10:3:5m"},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("%s - %s", tt.name, tt.interval), func(t *testing.T) {
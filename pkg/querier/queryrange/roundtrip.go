@@ -49,6 +49,7 @@ var (
 	})
 
 	errInvalidMinShardingLookback = errors.New("a non-zero value is required for querier.query-ingesters-within when -querier.parallelise-shardable-queries is enabled")
+	errInvalidTotalShards         = errors.New("a positive value is required for querier.query-sharding-total-shards when -querier.parallelise-shardable-queries is enabled")
 )
 
 // Config for query_range middleware chain.
@@ -59,6 +60,9 @@ type Config struct {
 	CacheResults           bool `yaml:"cache_results"`
 	MaxRetries             int  `yaml:"max_retries"`
 	ShardedQueries         bool `yaml:"parallelise_shardable_queries"`
+
+	// Blocks storage only.
+	QueryShardingTotalShards int `yaml:"query_sharding_total_shards"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet.
@@ -67,7 +71,8 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.SplitQueriesByInterval, "querier.split-queries-by-interval", 0, "Split queries by an interval and execute in parallel, 0 disables it. You should use an a multiple of 24 hours (same as the storage bucketing scheme), to avoid queriers downloading and processing the same chunks. This also determines how cache keys are chosen when result caching is enabled")
 	f.BoolVar(&cfg.AlignQueriesWithStep, "querier.align-querier-with-step", false, "Mutate incoming queries to align their start and end with their step.")
 	f.BoolVar(&cfg.CacheResults, "querier.cache-results", false, "Cache query results.")
-	f.BoolVar(&cfg.ShardedQueries, "querier.parallelise-shardable-queries", false, "Perform query parallelisations based on storage sharding configuration and query ASTs. This feature is supported only by the chunks storage engine.")
+	f.BoolVar(&cfg.ShardedQueries, "querier.parallelise-shardable-queries", false, "Perform query parallelisations based on storage sharding configuration and query ASTs. When running the blocks storage engine, the number of shards is configured via -querier.query-sharding-total-shards.")
+	f.IntVar(&cfg.QueryShardingTotalShards, "querier.query-sharding-total-shards", 16, "The number of shards used to parallelise shardable queries when running the blocks storage engine. Series are assigned to shards by hashing their labels. Requires -querier.parallelise-shardable-queries.")
 	cfg.ResultsCacheConfig.RegisterFlags(f)
 }
 
@@ -81,6 +86,9 @@ func (cfg *Config) Validate() error {
 			return errors.Wrap(err, "invalid ResultsCache config")
 		}
 	}
+	if cfg.ShardedQueries && cfg.QueryShardingTotalShards <= 0 {
+		return errInvalidTotalShards
+	}
 	return nil
 }
 
@@ -151,13 +159,24 @@ func NewTripperware(
 		Help: "Total queries sent per tenant.",
 	}, []string{"op", "user"})
 
+	blockedQueriesPerTenant := promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_query_frontend_blocked_queries_total",
+		Help: "Total queries blocked per tenant.",
+	}, []string{"op", "user"})
+
 	activeUsers := util.NewActiveUsersCleanupWithDefaultValues(func(user string) {
 		err := util.DeleteMatchingLabels(queriesPerTenant, map[string]string{"user": user})
 		if err != nil {
 			level.Warn(log).Log("msg", "failed to remove cortex_query_frontend_queries_total metric for user", "user", user)
 		}
+		err = util.DeleteMatchingLabels(blockedQueriesPerTenant, map[string]string{"user": user})
+		if err != nil {
+			level.Warn(log).Log("msg", "failed to remove cortex_query_frontend_blocked_queries_total metric for user", "user", user)
+		}
 	})
 
+	blockedQueries := &blockedQueriesChecker{limits: limits, logger: log, blockedQueries: blockedQueriesPerTenant}
+
 	// Metric used to keep track of each middleware execution duration.
 	metrics := NewInstrumentMiddlewareMetrics(registerer)
 
@@ -211,32 +230,46 @@ func NewTripperware(
 	// Start cleanup. If cleaner stops or fail, we will simply not clean the metrics for inactive users.
 	_ = activeUsers.StartAsync(context.Background())
 	return func(next http.RoundTripper) http.RoundTripper {
+		// Instant queries and labels queries are cached as is, if results caching is enabled.
+		other := next
+		if c != nil {
+			other = newHTTPResultsCache(log, next, c, limits, cacheGenNumberLoader)
+		}
+
 		// Finally, if the user selected any query range middleware, stitch it in.
+		queryrange := next
 		if len(queryRangeMiddleware) > 0 {
-			queryrange := NewRoundTripper(next, codec, queryRangeMiddleware...)
-			return RoundTripFunc(func(r *http.Request) (*http.Response, error) {
-				isQueryRange := strings.HasSuffix(r.URL.Path, "/query_range")
-				op := "query"
-				if isQueryRange {
-					op = "query_range"
-				}
+			queryrange = NewRoundTripper(next, codec, queryRangeMiddleware...)
+		}
 
-				tenantIDs, err := tenant.TenantIDs(r.Context())
-				// This should never happen anyways because we have auth middleware before this.
-				if err != nil {
+		return RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+			isQueryRange := strings.HasSuffix(r.URL.Path, "/query_range")
+			op := "query"
+			if isQueryRange {
+				op = "query_range"
+			}
+
+			tenantIDs, err := tenant.TenantIDs(r.Context())
+			// This should never happen anyways because we have auth middleware before this.
+			if err != nil {
+				return nil, err
+			}
+			userStr := tenant.JoinTenantIDs(tenantIDs)
+			activeUsers.UpdateUserTimestamp(userStr, time.Now())
+			queriesPerTenant.WithLabelValues(op, userStr).Inc()
+
+			// Reject the blocked instant and range queries before they're queued.
+			if isQueryRange || strings.HasSuffix(r.URL.Path, "/query") {
+				if err := blockedQueries.check(r, op, tenantIDs); err != nil {
 					return nil, err
 				}
-				userStr := tenant.JoinTenantIDs(tenantIDs)
-				activeUsers.UpdateUserTimestamp(userStr, time.Now())
-				queriesPerTenant.WithLabelValues(op, userStr).Inc()
+			}
 
-				if !isQueryRange {
-					return next.RoundTrip(r)
-				}
-				return queryrange.RoundTrip(r)
-			})
-		}
-		return next
+			if !isQueryRange {
+				return other.RoundTrip(r)
+			}
+			return queryrange.RoundTrip(r)
+		})
 	}, c, nil
 }
 
@@ -122,3 +122,31 @@ func Test_ShardingConfigError(t *testing.T) {
 
 	require.EqualError(t, err, errInvalidMinShardingLookback.Error())
 }
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      Config
+		expected error
+	}{
+		"should pass if sharding is disabled": {
+			cfg: Config{QueryShardingTotalShards: 0},
+		},
+		"should pass if sharding is enabled with a positive number of shards": {
+			cfg: Config{ShardedQueries: true, QueryShardingTotalShards: 16},
+		},
+		"should fail if sharding is enabled with zero shards": {
+			cfg:      Config{ShardedQueries: true, QueryShardingTotalShards: 0},
+			expected: errInvalidTotalShards,
+		},
+		"should fail if sharding is enabled with a negative number of shards": {
+			cfg:      Config{ShardedQueries: true, QueryShardingTotalShards: -1},
+			expected: errInvalidTotalShards,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, testData.expected, testData.cfg.Validate())
+		})
+	}
+}
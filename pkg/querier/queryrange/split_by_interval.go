@@ -9,6 +9,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/weaveworks/common/httpgrpc"
+
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 )
 
 type IntervalFn func(r Request) time.Duration
@@ -48,6 +50,7 @@ func (s splitByInterval) Do(ctx context.Context, r Request) (Response, error) {
 		return nil, err
 	}
 	s.splitByCounter.Add(float64(len(reqs)))
+	querier_stats.FromContext(ctx).AddSplitQueries(uint64(len(reqs)))
 
 	reqResps, err := DoRequests(ctx, s.next, reqs, s.limits)
 	if err != nil {
@@ -86,6 +86,136 @@ func (s *Stats) LoadFetchedChunkBytes() uint64 {
 	return atomic.LoadUint64(&s.FetchedChunkBytes)
 }
 
+func (s *Stats) AddProcessedSamples(samples uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ProcessedSamples, samples)
+}
+
+func (s *Stats) LoadProcessedSamples() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ProcessedSamples)
+}
+
+func (s *Stats) AddSplitQueries(queries uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.SplitQueries, queries)
+}
+
+func (s *Stats) LoadSplitQueries() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.SplitQueries)
+}
+
+func (s *Stats) AddShardedQueries(queries uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ShardedQueries, queries)
+}
+
+func (s *Stats) LoadShardedQueries() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ShardedQueries)
+}
+
+func (s *Stats) AddResultsCacheHits(hits uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ResultsCacheHits, hits)
+}
+
+func (s *Stats) LoadResultsCacheHits() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ResultsCacheHits)
+}
+
+func (s *Stats) AddResultsCacheMisses(misses uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ResultsCacheMisses, misses)
+}
+
+func (s *Stats) LoadResultsCacheMisses() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ResultsCacheMisses)
+}
+
+// AddQueueTime adds some time to the queue time counter.
+func (s *Stats) AddQueueTime(t time.Duration) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddInt64((*int64)(&s.QueueTime), int64(t))
+}
+
+// LoadQueueTime returns current queue time.
+func (s *Stats) LoadQueueTime() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	return time.Duration(atomic.LoadInt64((*int64)(&s.QueueTime)))
+}
+
+func (s *Stats) AddTouchedBlocks(blocks uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.TouchedBlocks, blocks)
+}
+
+func (s *Stats) LoadTouchedBlocks() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.TouchedBlocks)
+}
+
+func (s *Stats) AddFetchedIndexBytes(bytes uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.FetchedIndexBytes, bytes)
+}
+
+func (s *Stats) LoadFetchedIndexBytes() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.FetchedIndexBytes)
+}
+
 // Merge the provide Stats into this one.
 func (s *Stats) Merge(other *Stats) {
 	if s == nil || other == nil {
@@ -95,6 +225,14 @@ func (s *Stats) Merge(other *Stats) {
 	s.AddWallTime(other.LoadWallTime())
 	s.AddFetchedSeries(other.LoadFetchedSeries())
 	s.AddFetchedChunkBytes(other.LoadFetchedChunkBytes())
+	s.AddProcessedSamples(other.LoadProcessedSamples())
+	s.AddSplitQueries(other.LoadSplitQueries())
+	s.AddShardedQueries(other.LoadShardedQueries())
+	s.AddResultsCacheHits(other.LoadResultsCacheHits())
+	s.AddResultsCacheMisses(other.LoadResultsCacheMisses())
+	s.AddQueueTime(other.LoadQueueTime())
+	s.AddTouchedBlocks(other.LoadTouchedBlocks())
+	s.AddFetchedIndexBytes(other.LoadFetchedIndexBytes())
 }
 
 func ShouldTrackHTTPGRPCResponse(r *httpgrpc.HTTPResponse) bool {
@@ -36,6 +36,22 @@ type Stats struct {
 	FetchedSeriesCount uint64 `protobuf:"varint,2,opt,name=fetched_series_count,json=fetchedSeriesCount,proto3" json:"fetched_series_count,omitempty"`
 	// The number of bytes of the chunks fetched for the query
 	FetchedChunkBytes uint64 `protobuf:"varint,3,opt,name=fetched_chunk_bytes,json=fetchedChunkBytes,proto3" json:"fetched_chunk_bytes,omitempty"`
+	// The number of samples processed by the PromQL engine to execute the query.
+	ProcessedSamples uint64 `protobuf:"varint,4,opt,name=processed_samples,json=processedSamples,proto3" json:"processed_samples,omitempty"`
+	// The number of sub-queries the query has been split into by time interval.
+	SplitQueries uint64 `protobuf:"varint,5,opt,name=split_queries,json=splitQueries,proto3" json:"split_queries,omitempty"`
+	// The number of sub-queries the query has been sharded into.
+	ShardedQueries uint64 `protobuf:"varint,6,opt,name=sharded_queries,json=shardedQueries,proto3" json:"sharded_queries,omitempty"`
+	// The number of (sub-)queries fully served from the results cache.
+	ResultsCacheHits uint64 `protobuf:"varint,7,opt,name=results_cache_hits,json=resultsCacheHits,proto3" json:"results_cache_hits,omitempty"`
+	// The number of (sub-)queries not or only partially served from the results cache.
+	ResultsCacheMisses uint64 `protobuf:"varint,8,opt,name=results_cache_misses,json=resultsCacheMisses,proto3" json:"results_cache_misses,omitempty"`
+	// The sum of all time spent by the (sub-)queries in the query-frontend or query-scheduler queue.
+	QueueTime time.Duration `protobuf:"bytes,9,opt,name=queue_time,json=queueTime,proto3,stdduration" json:"queue_time"`
+	// The number of blocks touched by the store-gateways to execute the query.
+	TouchedBlocks uint64 `protobuf:"varint,10,opt,name=touched_blocks,json=touchedBlocks,proto3" json:"touched_blocks,omitempty"`
+	// The number of bytes of the index fetched from the object storage by the store-gateways.
+	FetchedIndexBytes uint64 `protobuf:"varint,11,opt,name=fetched_index_bytes,json=fetchedIndexBytes,proto3" json:"fetched_index_bytes,omitempty"`
 }
 
 func (m *Stats) Reset()      { *m = Stats{} }
@@ -91,6 +107,62 @@ func (m *Stats) GetFetchedChunkBytes() uint64 {
 	return 0
 }
 
+func (m *Stats) GetProcessedSamples() uint64 {
+	if m != nil {
+		return m.ProcessedSamples
+	}
+	return 0
+}
+
+func (m *Stats) GetSplitQueries() uint64 {
+	if m != nil {
+		return m.SplitQueries
+	}
+	return 0
+}
+
+func (m *Stats) GetShardedQueries() uint64 {
+	if m != nil {
+		return m.ShardedQueries
+	}
+	return 0
+}
+
+func (m *Stats) GetResultsCacheHits() uint64 {
+	if m != nil {
+		return m.ResultsCacheHits
+	}
+	return 0
+}
+
+func (m *Stats) GetResultsCacheMisses() uint64 {
+	if m != nil {
+		return m.ResultsCacheMisses
+	}
+	return 0
+}
+
+func (m *Stats) GetQueueTime() time.Duration {
+	if m != nil {
+		return m.QueueTime
+	}
+	return 0
+}
+
+func (m *Stats) GetTouchedBlocks() uint64 {
+	if m != nil {
+		return m.TouchedBlocks
+	}
+	return 0
+}
+
+func (m *Stats) GetFetchedIndexBytes() uint64 {
+	if m != nil {
+		return m.FetchedIndexBytes
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Stats)(nil), "stats.Stats")
 }
@@ -98,25 +170,35 @@ func init() {
 func init() { proto.RegisterFile("stats.proto", fileDescriptor_b4756a0aec8b9d44) }
 
 var fileDescriptor_b4756a0aec8b9d44 = []byte{
-	// 281 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0xd0, 0xb1, 0x4e, 0x83, 0x40,
-	0x1c, 0xc7, 0xf1, 0xfb, 0xab, 0x35, 0x95, 0x4e, 0xa2, 0x03, 0x76, 0xf8, 0xb7, 0x71, 0xea, 0xe2,
-	0xd5, 0xe8, 0xe8, 0x62, 0xa8, 0x4f, 0xd0, 0x3a, 0xb9, 0x10, 0xa0, 0x57, 0x20, 0x02, 0x67, 0xe0,
-	0x2e, 0xc6, 0xcd, 0x47, 0x70, 0xf4, 0x11, 0x4c, 0x7c, 0x91, 0x8e, 0x8c, 0x9d, 0x54, 0x8e, 0xc5,
-	0xb1, 0x8f, 0x60, 0xee, 0xa0, 0x71, 0xe3, 0x97, 0x0f, 0xdf, 0x4b, 0xee, 0xac, 0x41, 0x29, 0x7c,
-	0x51, 0xd2, 0xa7, 0x82, 0x0b, 0x6e, 0xf7, 0xcc, 0x18, 0x5e, 0x44, 0x89, 0x88, 0x65, 0x40, 0x43,
-	0x9e, 0x4d, 0x23, 0x1e, 0xf1, 0xa9, 0xd1, 0x40, 0xae, 0xcc, 0x32, 0xc3, 0x7c, 0xb5, 0xd5, 0x10,
-	0x23, 0xce, 0xa3, 0x94, 0xfd, 0xff, 0xb5, 0x94, 0x85, 0x2f, 0x12, 0x9e, 0xb7, 0x7e, 0xfe, 0x09,
-	0x56, 0x6f, 0xa1, 0x0f, 0xb6, 0x6f, 0xad, 0xa3, 0x67, 0x3f, 0x4d, 0x3d, 0x91, 0x64, 0xcc, 0x81,
-	0x31, 0x4c, 0x06, 0x57, 0x67, 0xb4, 0xad, 0xe9, 0xae, 0xa6, 0x77, 0x5d, 0xed, 0xf6, 0xd7, 0x5f,
-	0x23, 0xf2, 0xfe, 0x3d, 0x82, 0x79, 0x5f, 0x57, 0xf7, 0x49, 0xc6, 0xec, 0x4b, 0xeb, 0x74, 0xc5,
-	0x44, 0x18, 0xb3, 0xa5, 0x57, 0xb2, 0x22, 0x61, 0xa5, 0x17, 0x72, 0x99, 0x0b, 0x67, 0x6f, 0x0c,
-	0x93, 0x83, 0xb9, 0xdd, 0xd9, 0xc2, 0xd0, 0x4c, 0x8b, 0x4d, 0xad, 0x93, 0x5d, 0x11, 0xc6, 0x32,
-	0x7f, 0xf4, 0x82, 0x17, 0xc1, 0x4a, 0x67, 0xdf, 0x04, 0xc7, 0x1d, 0xcd, 0xb4, 0xb8, 0x1a, 0xdc,
-	0x9b, 0xaa, 0x46, 0xb2, 0xa9, 0x91, 0x6c, 0x6b, 0x84, 0x57, 0x85, 0xf0, 0xa1, 0x10, 0xd6, 0x0a,
-	0xa1, 0x52, 0x08, 0x3f, 0x0a, 0xe1, 0x57, 0x21, 0xd9, 0x2a, 0x84, 0xb7, 0x06, 0x49, 0xd5, 0x20,
-	0xd9, 0x34, 0x48, 0x1e, 0xda, 0x97, 0x0b, 0x0e, 0xcd, 0x2d, 0xae, 0xff, 0x02, 0x00, 0x00, 0xff,
-	0xff, 0x9d, 0xf1, 0x86, 0xb8, 0x56, 0x01, 0x00, 0x00,
+	// 443 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x92, 0x3f, 0x73, 0xd3, 0x40,
+	0x10, 0xc5, 0x75, 0x60, 0x07, 0xfb, 0x4c, 0x02, 0x39, 0x28, 0x44, 0x8a, 0x4b, 0x06, 0x86, 0x21,
+	0x33, 0x80, 0xc2, 0x40, 0x49, 0xc3, 0xc8, 0x14, 0x50, 0x50, 0x10, 0x53, 0xd1, 0x68, 0xf4, 0x67,
+	0x23, 0xdd, 0x44, 0xf2, 0x39, 0xda, 0xbb, 0x01, 0x3a, 0x3e, 0x02, 0x25, 0x2d, 0x1d, 0x1f, 0x25,
+	0xa5, 0xcb, 0x54, 0x80, 0xe5, 0x86, 0x32, 0x1f, 0x81, 0xd1, 0x9e, 0x1c, 0xe2, 0x2e, 0x9d, 0xf6,
+	0xfd, 0xde, 0xd3, 0xde, 0xbc, 0x3b, 0x3e, 0x42, 0x13, 0x1b, 0x0c, 0x66, 0xb5, 0x36, 0x5a, 0xf4,
+	0x69, 0xd8, 0x79, 0x9a, 0x2b, 0x53, 0xd8, 0x24, 0x48, 0x75, 0x75, 0x90, 0xeb, 0x5c, 0x1f, 0x10,
+	0x4d, 0xec, 0x11, 0x4d, 0x34, 0xd0, 0x97, 0x4b, 0xed, 0xc8, 0x5c, 0xeb, 0xbc, 0x84, 0xff, 0xae,
+	0xcc, 0xd6, 0xb1, 0x51, 0x7a, 0xea, 0xf8, 0xfd, 0x1f, 0x3d, 0xde, 0x9f, 0xb4, 0x3f, 0x16, 0xaf,
+	0xf8, 0xf0, 0x53, 0x5c, 0x96, 0x91, 0x51, 0x15, 0xf8, 0x6c, 0x8f, 0xed, 0x8f, 0x9e, 0xdf, 0x0b,
+	0x5c, 0x3a, 0x58, 0xa5, 0x83, 0xd7, 0x5d, 0x3a, 0x1c, 0x9c, 0xfe, 0xda, 0xf5, 0xbe, 0xff, 0xde,
+	0x65, 0x87, 0x83, 0x36, 0xf5, 0x41, 0x55, 0x20, 0x9e, 0xf1, 0xbb, 0x47, 0x60, 0xd2, 0x02, 0xb2,
+	0x08, 0xa1, 0x56, 0x80, 0x51, 0xaa, 0xed, 0xd4, 0xf8, 0xd7, 0xf6, 0xd8, 0x7e, 0xef, 0x50, 0x74,
+	0x6c, 0x42, 0x68, 0xdc, 0x12, 0x11, 0xf0, 0x3b, 0xab, 0x44, 0x5a, 0xd8, 0xe9, 0x71, 0x94, 0x7c,
+	0x31, 0x80, 0xfe, 0x75, 0x0a, 0x6c, 0x77, 0x68, 0xdc, 0x92, 0xb0, 0x05, 0xe2, 0x31, 0xdf, 0x9e,
+	0xd5, 0x3a, 0x05, 0xc4, 0x76, 0x47, 0x5c, 0xcd, 0x4a, 0x40, 0xbf, 0x47, 0xee, 0xdb, 0x17, 0x60,
+	0xe2, 0x74, 0xf1, 0x80, 0x6f, 0xe2, 0xac, 0x54, 0x26, 0x3a, 0xb1, 0xb4, 0xd2, 0xef, 0x93, 0xf1,
+	0x26, 0x89, 0xef, 0x9d, 0x26, 0x1e, 0xf1, 0x5b, 0x58, 0xc4, 0x75, 0x06, 0xd9, 0x85, 0x6d, 0x83,
+	0x6c, 0x5b, 0x9d, 0xbc, 0x32, 0x3e, 0xe1, 0xa2, 0x06, 0xb4, 0xa5, 0xc1, 0x28, 0x8d, 0xd3, 0x02,
+	0xa2, 0x42, 0x19, 0xf4, 0x6f, 0xb8, 0xdd, 0x1d, 0x19, 0xb7, 0xe0, 0x8d, 0x32, 0xd8, 0x56, 0xb1,
+	0xee, 0xae, 0x14, 0x22, 0xa0, 0x3f, 0x70, 0x55, 0x5c, 0xf6, 0xbf, 0x23, 0x22, 0x42, 0xce, 0x4f,
+	0x2c, 0x58, 0x70, 0xfd, 0x0f, 0xaf, 0xde, 0xff, 0x90, 0x62, 0x74, 0x01, 0x0f, 0xf9, 0x96, 0xd1,
+	0x96, 0xea, 0x4c, 0x4a, 0x9d, 0x1e, 0xa3, 0xcf, 0x69, 0xdf, 0x66, 0xa7, 0x86, 0x24, 0x5e, 0x6e,
+	0x5d, 0x4d, 0x33, 0xf8, 0xdc, 0xb5, 0x3e, 0x5a, 0x6b, 0xfd, 0x6d, 0x4b, 0xa8, 0xf5, 0xf0, 0xe5,
+	0x7c, 0x21, 0xbd, 0xb3, 0x85, 0xf4, 0xce, 0x17, 0x92, 0x7d, 0x6d, 0x24, 0xfb, 0xd9, 0x48, 0x76,
+	0xda, 0x48, 0x36, 0x6f, 0x24, 0xfb, 0xd3, 0x48, 0xf6, 0xb7, 0x91, 0xde, 0x79, 0x23, 0xd9, 0xb7,
+	0xa5, 0xf4, 0xe6, 0x4b, 0xe9, 0x9d, 0x2d, 0xa5, 0xf7, 0xd1, 0xbd, 0xd7, 0x64, 0x83, 0xce, 0xfe,
+	0xe2, 0xdf, 0x00, 0x00, 0x68, 0x6c, 0xd8, 0xcc, 0x02, 0x00, 0x00,
 }
 
 func (this *Stats) Equal(that interface{}) bool {
@@ -147,17 +229,49 @@ func (this *Stats) Equal(that interface{}) bool {
 	if this.FetchedChunkBytes != that1.FetchedChunkBytes {
 		return false
 	}
+	if this.ProcessedSamples != that1.ProcessedSamples {
+		return false
+	}
+	if this.SplitQueries != that1.SplitQueries {
+		return false
+	}
+	if this.ShardedQueries != that1.ShardedQueries {
+		return false
+	}
+	if this.ResultsCacheHits != that1.ResultsCacheHits {
+		return false
+	}
+	if this.ResultsCacheMisses != that1.ResultsCacheMisses {
+		return false
+	}
+	if this.QueueTime != that1.QueueTime {
+		return false
+	}
+	if this.TouchedBlocks != that1.TouchedBlocks {
+		return false
+	}
+	if this.FetchedIndexBytes != that1.FetchedIndexBytes {
+		return false
+	}
 	return true
 }
 func (this *Stats) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 7)
+	s := make([]string, 0, 15)
 	s = append(s, "&stats.Stats{")
 	s = append(s, "WallTime: "+fmt.Sprintf("%#v", this.WallTime)+",\n")
 	s = append(s, "FetchedSeriesCount: "+fmt.Sprintf("%#v", this.FetchedSeriesCount)+",\n")
 	s = append(s, "FetchedChunkBytes: "+fmt.Sprintf("%#v", this.FetchedChunkBytes)+",\n")
+	s = append(s, "ProcessedSamples: "+fmt.Sprintf("%#v", this.ProcessedSamples)+",\n")
+	s = append(s, "SplitQueries: "+fmt.Sprintf("%#v", this.SplitQueries)+",\n")
+	s = append(s, "ShardedQueries: "+fmt.Sprintf("%#v", this.ShardedQueries)+",\n")
+	s = append(s, "ResultsCacheHits: "+fmt.Sprintf("%#v", this.ResultsCacheHits)+",\n")
+	s = append(s, "ResultsCacheMisses: "+fmt.Sprintf("%#v", this.ResultsCacheMisses)+",\n")
+	s = append(s, "QueueTime: "+fmt.Sprintf("%#v", this.QueueTime)+",\n")
+	s = append(s, "TouchedBlocks: "+fmt.Sprintf("%#v", this.TouchedBlocks)+",\n")
+	s = append(s, "FetchedIndexBytes: "+fmt.Sprintf("%#v", this.FetchedIndexBytes)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -189,6 +303,49 @@ func (m *Stats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.FetchedIndexBytes != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.FetchedIndexBytes))
+		i--
+		dAtA[i] = 0x58
+	}
+	if m.TouchedBlocks != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.TouchedBlocks))
+		i--
+		dAtA[i] = 0x50
+	}
+	n1, err1 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.QueueTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.QueueTime):])
+	if err1 != nil {
+		return 0, err1
+	}
+	i -= n1
+	i = encodeVarintStats(dAtA, i, uint64(n1))
+	i--
+	dAtA[i] = 0x4a
+	if m.ResultsCacheMisses != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.ResultsCacheMisses))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.ResultsCacheHits != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.ResultsCacheHits))
+		i--
+		dAtA[i] = 0x38
+	}
+	if m.ShardedQueries != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.ShardedQueries))
+		i--
+		dAtA[i] = 0x30
+	}
+	if m.SplitQueries != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.SplitQueries))
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.ProcessedSamples != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.ProcessedSamples))
+		i--
+		dAtA[i] = 0x20
+	}
 	if m.FetchedChunkBytes != 0 {
 		i = encodeVarintStats(dAtA, i, uint64(m.FetchedChunkBytes))
 		i--
@@ -199,12 +356,12 @@ func (m *Stats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i--
 		dAtA[i] = 0x10
 	}
-	n1, err1 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.WallTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.WallTime):])
-	if err1 != nil {
-		return 0, err1
+	n2, err2 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.WallTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.WallTime):])
+	if err2 != nil {
+		return 0, err2
 	}
-	i -= n1
-	i = encodeVarintStats(dAtA, i, uint64(n1))
+	i -= n2
+	i = encodeVarintStats(dAtA, i, uint64(n2))
 	i--
 	dAtA[i] = 0xa
 	return len(dAtA) - i, nil
@@ -235,6 +392,29 @@ func (m *Stats) Size() (n int) {
 	if m.FetchedChunkBytes != 0 {
 		n += 1 + sovStats(uint64(m.FetchedChunkBytes))
 	}
+	if m.ProcessedSamples != 0 {
+		n += 1 + sovStats(uint64(m.ProcessedSamples))
+	}
+	if m.SplitQueries != 0 {
+		n += 1 + sovStats(uint64(m.SplitQueries))
+	}
+	if m.ShardedQueries != 0 {
+		n += 1 + sovStats(uint64(m.ShardedQueries))
+	}
+	if m.ResultsCacheHits != 0 {
+		n += 1 + sovStats(uint64(m.ResultsCacheHits))
+	}
+	if m.ResultsCacheMisses != 0 {
+		n += 1 + sovStats(uint64(m.ResultsCacheMisses))
+	}
+	l = github_com_gogo_protobuf_types.SizeOfStdDuration(m.QueueTime)
+	n += 1 + l + sovStats(uint64(l))
+	if m.TouchedBlocks != 0 {
+		n += 1 + sovStats(uint64(m.TouchedBlocks))
+	}
+	if m.FetchedIndexBytes != 0 {
+		n += 1 + sovStats(uint64(m.FetchedIndexBytes))
+	}
 	return n
 }
 
@@ -252,6 +432,14 @@ func (this *Stats) String() string {
 		`WallTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.WallTime), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
 		`FetchedSeriesCount:` + fmt.Sprintf("%v", this.FetchedSeriesCount) + `,`,
 		`FetchedChunkBytes:` + fmt.Sprintf("%v", this.FetchedChunkBytes) + `,`,
+		`ProcessedSamples:` + fmt.Sprintf("%v", this.ProcessedSamples) + `,`,
+		`SplitQueries:` + fmt.Sprintf("%v", this.SplitQueries) + `,`,
+		`ShardedQueries:` + fmt.Sprintf("%v", this.ShardedQueries) + `,`,
+		`ResultsCacheHits:` + fmt.Sprintf("%v", this.ResultsCacheHits) + `,`,
+		`ResultsCacheMisses:` + fmt.Sprintf("%v", this.ResultsCacheMisses) + `,`,
+		`QueueTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.QueueTime), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
+		`TouchedBlocks:` + fmt.Sprintf("%v", this.TouchedBlocks) + `,`,
+		`FetchedIndexBytes:` + fmt.Sprintf("%v", this.FetchedIndexBytes) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -364,6 +552,172 @@ func (m *Stats) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ProcessedSamples", wireType)
+			}
+			m.ProcessedSamples = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ProcessedSamples |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SplitQueries", wireType)
+			}
+			m.SplitQueries = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SplitQueries |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ShardedQueries", wireType)
+			}
+			m.ShardedQueries = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ShardedQueries |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResultsCacheHits", wireType)
+			}
+			m.ResultsCacheHits = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ResultsCacheHits |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResultsCacheMisses", wireType)
+			}
+			m.ResultsCacheMisses = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ResultsCacheMisses |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field QueueTime", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthStats
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthStats
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdDurationUnmarshal(&m.QueueTime, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TouchedBlocks", wireType)
+			}
+			m.TouchedBlocks = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TouchedBlocks |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedIndexBytes", wireType)
+			}
+			m.FetchedIndexBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedIndexBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipStats(dAtA[iNdEx:])
@@ -59,23 +59,58 @@ func TestStats_AddFetchedChunkBytes(t *testing.T) {
 	})
 }
 
+func TestStats_QueueTime(t *testing.T) {
+	t.Run("add and load queue time", func(t *testing.T) {
+		stats, _ := ContextWithEmptyStats(context.Background())
+		stats.AddQueueTime(time.Second)
+		stats.AddQueueTime(time.Second)
+
+		assert.Equal(t, 2*time.Second, stats.LoadQueueTime())
+	})
+
+	t.Run("add and load queue time nil receiver", func(t *testing.T) {
+		var stats *Stats
+		stats.AddQueueTime(time.Second)
+
+		assert.Equal(t, time.Duration(0), stats.LoadQueueTime())
+	})
+}
+
 func TestStats_Merge(t *testing.T) {
 	t.Run("merge two stats objects", func(t *testing.T) {
 		stats1 := &Stats{}
 		stats1.AddWallTime(time.Millisecond)
 		stats1.AddFetchedSeries(50)
 		stats1.AddFetchedChunkBytes(42)
+		stats1.AddProcessedSamples(1000)
+		stats1.AddSplitQueries(2)
+		stats1.AddResultsCacheHits(1)
+		stats1.AddQueueTime(time.Millisecond)
+		stats1.AddTouchedBlocks(3)
 
 		stats2 := &Stats{}
 		stats2.AddWallTime(time.Second)
 		stats2.AddFetchedSeries(60)
 		stats2.AddFetchedChunkBytes(100)
+		stats2.AddProcessedSamples(500)
+		stats2.AddShardedQueries(16)
+		stats2.AddResultsCacheMisses(1)
+		stats2.AddQueueTime(time.Second)
+		stats2.AddFetchedIndexBytes(2048)
 
 		stats1.Merge(stats2)
 
 		assert.Equal(t, 1001*time.Millisecond, stats1.LoadWallTime())
 		assert.Equal(t, uint64(110), stats1.LoadFetchedSeries())
 		assert.Equal(t, uint64(142), stats1.LoadFetchedChunkBytes())
+		assert.Equal(t, uint64(1500), stats1.LoadProcessedSamples())
+		assert.Equal(t, uint64(2), stats1.LoadSplitQueries())
+		assert.Equal(t, uint64(16), stats1.LoadShardedQueries())
+		assert.Equal(t, uint64(1), stats1.LoadResultsCacheHits())
+		assert.Equal(t, uint64(1), stats1.LoadResultsCacheMisses())
+		assert.Equal(t, 1001*time.Millisecond, stats1.LoadQueueTime())
+		assert.Equal(t, uint64(3), stats1.LoadTouchedBlocks())
+		assert.Equal(t, uint64(2048), stats1.LoadFetchedIndexBytes())
 	})
 
 	t.Run("merge two nil stats objects", func(t *testing.T) {
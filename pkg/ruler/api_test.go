@@ -356,6 +356,98 @@ rules:
 	}
 }
 
+func TestRuler_CreateFederatedRuleGroup(t *testing.T) {
+	input := `
+name: test
+interval: 15s
+source_tenants: [tenant-b, tenant-a]
+rules:
+- record: up_rule
+  expr: sum by (__tenant_id__) (up{})
+`
+
+	tc := []struct {
+		name                 string
+		tenantFederation     bool
+		allowedSourceTenants []string
+		input                string
+		output               string
+		status               int
+	}{
+		{
+			name:                 "when federated rule groups are enabled for the tenant",
+			tenantFederation:     true,
+			allowedSourceTenants: []string{"tenant-a", "tenant-b"},
+			input:                input,
+			status:               202,
+			output:               "name: test\ninterval: 15s\nrules:\n    - record: up_rule\n      expr: sum by (__tenant_id__) (up{})\nsource_tenants:\n    - tenant-b\n    - tenant-a\n",
+		},
+		{
+			name:   "when federated rule groups are disabled for the tenant",
+			input:  input,
+			status: 400,
+			output: "federated rule groups are not enabled for the tenant\n",
+		},
+		{
+			name:             "with an invalid source tenant",
+			tenantFederation: true,
+			input:            strings.Replace(input, "tenant-a", "tenant/a", 1),
+			status:           400,
+			output:           "invalid source tenant: tenant ID 'tenant/a' contains unsupported character '/'\n",
+		},
+		{
+			name:                 "with a source tenant not allowed for the tenant",
+			tenantFederation:     true,
+			allowedSourceTenants: []string{"tenant-a"},
+			input:                input,
+			status:               400,
+			output:               "the source tenant tenant-b is not allowed for the federated rule groups of the tenant\n",
+		},
+		{
+			name:             "with the tenant itself as source tenant",
+			tenantFederation: true,
+			input:            strings.Replace(input, "[tenant-b, tenant-a]", "[user1]", 1),
+			status:           202,
+			output:           "name: test\ninterval: 15s\nrules:\n    - record: up_rule\n      expr: sum by (__tenant_id__) (up{})\nsource_tenants:\n    - user1\n",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, cleanup := defaultRulerConfig(t, newMockRuleStore(make(map[string]rulespb.RuleGroupList)))
+			defer cleanup()
+
+			r, rcleanup := newTestRuler(t, cfg, nil)
+			defer rcleanup()
+			defer services.StopAndAwaitTerminated(context.Background(), r) //nolint:errcheck
+
+			r.limits = ruleLimits{tenantFederation: tt.tenantFederation, allowedSourceTenants: tt.allowedSourceTenants}
+
+			a := NewAPI(r, r.store, log.NewNopLogger())
+
+			router := mux.NewRouter()
+			router.Path("/api/v1/rules/{namespace}").Methods("POST").HandlerFunc(a.CreateRuleGroup)
+			router.Path("/api/v1/rules/{namespace}/{groupName}").Methods("GET").HandlerFunc(a.GetRuleGroup)
+			// POST
+			req := requestFor(t, http.MethodPost, "https://localhost:8080/api/v1/rules/namespace", strings.NewReader(tt.input), "user1")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+			require.Equal(t, tt.status, w.Code)
+
+			if tt.status == 202 {
+				// GET
+				req = requestFor(t, http.MethodGet, "https://localhost:8080/api/v1/rules/namespace/test", nil, "user1")
+				w = httptest.NewRecorder()
+
+				router.ServeHTTP(w, req)
+				require.Equal(t, 200, w.Code)
+			}
+			require.Equal(t, tt.output, w.Body.String())
+		})
+	}
+}
+
 func TestRuler_RulerGroupLimits(t *testing.T) {
 	cfg, cleanup := defaultRulerConfig(t, newMockRuleStore(make(map[string]rulespb.RuleGroupList)))
 	defer cleanup()
@@ -131,6 +131,8 @@ type RulesLimits interface {
 	RulerTenantShardSize(userID string) int
 	RulerMaxRuleGroupsPerTenant(userID string) int
 	RulerMaxRulesPerRuleGroup(userID string) int
+	RulerTenantFederationEnabled(userID string) bool
+	RulerAllowedSourceTenants(userID string) []string
 }
 
 // EngineQueryFunc returns a new query function using the rules.EngineQueryFunc function
@@ -222,7 +224,9 @@ type RulesManager interface {
 // ManagerFactory is a function that creates new RulesManager for given user and notifier.Manager.
 type ManagerFactory func(ctx context.Context, userID string, notifier *notifier.Manager, logger log.Logger, reg prometheus.Registerer) RulesManager
 
-func DefaultTenantManagerFactory(cfg Config, p Pusher, q storage.Queryable, engine *promql.Engine, overrides RulesLimits, reg prometheus.Registerer) ManagerFactory {
+// DefaultTenantManagerFactory returns the ManagerFactory evaluating the rule queries with engine, or through
+// the query-frontend if remote is not nil.
+func DefaultTenantManagerFactory(cfg Config, p Pusher, q storage.Queryable, engine *promql.Engine, remote *RemoteQuerier, overrides RulesLimits, reg prometheus.Registerer) ManagerFactory {
 	totalWrites := promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "cortex_ruler_write_requests_total",
 		Help: "Number of write requests to ingesters.",
@@ -248,6 +252,11 @@ func DefaultTenantManagerFactory(cfg Config, p Pusher, q storage.Queryable, engi
 		}, []string{"user"})
 	}
 
+	var remoteMetrics *remoteEvaluationMetrics
+	if remote != nil {
+		remoteMetrics = newRemoteEvaluationMetrics(reg)
+	}
+
 	// Wrap errors returned by Queryable to our wrapper, so that we can distinguish between those errors
 	// and errors returned by PromQL engine. Errors from Queryable can be either caused by user (limits) or internal errors.
 	// Errors from PromQL are always "user" errors.
@@ -259,10 +268,20 @@ func DefaultTenantManagerFactory(cfg Config, p Pusher, q storage.Queryable, engi
 			queryTime = rulerQuerySeconds.WithLabelValues(userID)
 		}
 
+		queryFunc := EngineQueryFunc(engine, q, overrides, userID)
+		if remote != nil {
+			var local rules.QueryFunc
+			if cfg.Frontend.FallbackEnabled {
+				local = queryFunc
+			}
+			queryFunc = RemoteQueryFunc(remote, local, overrides, userID, remoteMetrics, logger)
+		}
+		queryFunc = TenantFederationQueryFunc(queryFunc, overrides, userID)
+
 		return rules.NewManager(&rules.ManagerOptions{
 			Appendable:      NewPusherAppendable(p, userID, overrides, totalWrites, failedWrites),
 			Queryable:       q,
-			QueryFunc:       RecordAndReportRuleQueryMetrics(MetricsQueryFunc(EngineQueryFunc(engine, q, overrides, userID), totalQueries, failedQueries), queryTime, logger),
+			QueryFunc:       RecordAndReportRuleQueryMetrics(MetricsQueryFunc(queryFunc, totalQueries, failedQueries), queryTime, logger),
 			Context:         user.InjectOrgID(ctx, userID),
 			ExternalURL:     cfg.ExternalURL.URL,
 			NotifyFunc:      SendAlerts(notifier, cfg.ExternalURL.URL.String()),
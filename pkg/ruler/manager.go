@@ -35,6 +35,9 @@ type DefaultMultiTenantManager struct {
 	userManagers       map[string]RulesManager
 	userManagerMetrics *ManagerMetrics
 
+	// Per-user source tenants of the federated rule groups, guarded by userManagerMtx.
+	userFederatedRuleGroups map[string]*federatedRuleGroups
+
 	// Per-user notifiers with separate queues.
 	notifiersMtx sync.Mutex
 	notifiers    map[string]*rulerNotifier
@@ -66,6 +69,8 @@ func NewDefaultMultiTenantManager(cfg Config, managerFactory ManagerFactory, reg
 		mapper:             newMapper(cfg.RulePath, logger),
 		userManagers:       map[string]RulesManager{},
 		userManagerMetrics: userManagerMetrics,
+
+		userFederatedRuleGroups: map[string]*federatedRuleGroups{},
 		managersTotal: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
 			Namespace: "cortex",
 			Name:      "ruler_managers_total",
@@ -106,6 +111,7 @@ func (r *DefaultMultiTenantManager) SyncRuleGroups(ctx context.Context, ruleGrou
 		if _, exists := ruleGroups[userID]; !exists {
 			go mngr.Stop()
 			delete(r.userManagers, userID)
+			delete(r.userFederatedRuleGroups, userID)
 
 			r.mapper.cleanupUser(userID)
 			r.lastReloadSuccessful.DeleteLabelValues(userID)
@@ -131,6 +137,15 @@ func (r *DefaultMultiTenantManager) syncRulesToManager(ctx context.Context, user
 		return
 	}
 
+	// The source tenants of the federated rule groups are not part of the rule files, so
+	// they're updated even if the rule files didn't change.
+	federated, exists := r.userFederatedRuleGroups[user]
+	if !exists {
+		federated = newFederatedRuleGroups()
+		r.userFederatedRuleGroups[user] = federated
+	}
+	federated.update(r.mapper, user, groups)
+
 	manager, exists := r.userManagers[user]
 	if !exists || update {
 		level.Debug(r.logger).Log("msg", "updating rules", "user", user)
@@ -173,6 +188,11 @@ func (r *DefaultMultiTenantManager) newManager(ctx context.Context, userID strin
 	reg := prometheus.NewRegistry()
 	r.userManagerMetrics.AddUserRegistry(userID, reg)
 
+	// The source tenants of the federated rule groups are passed to the rule queries through the context.
+	if federated, ok := r.userFederatedRuleGroups[userID]; ok {
+		ctx = contextWithFederatedRuleGroups(ctx, federated)
+	}
+
 	return r.managerFactory(ctx, userID, notifier, r.logger, reg), nil
 }
 
@@ -0,0 +1,259 @@
+package ruler
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/grpcclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+// FrontendConfig is the configuration of the remote evaluation of rules through a query-frontend.
+type FrontendConfig struct {
+	// The query-frontend gRPC address. Remote evaluation is disabled if empty.
+	Address         string            `yaml:"address"`
+	Timeout         time.Duration     `yaml:"timeout"`
+	FallbackEnabled bool              `yaml:"fallback_enabled"`
+	GRPCClient      grpcclient.Config `yaml:"grpc_client_config"`
+
+	// The HTTP path prefix of the Prometheus API, copied from the API config.
+	PrometheusHTTPPrefix string `yaml:"-"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *FrontendConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Address, "ruler.frontend-address", "", "GRPC listen address of the query-frontend to evaluate rules through. If empty, rules are evaluated by the ruler itself.")
+	f.DurationVar(&cfg.Timeout, "ruler.frontend-timeout", 2*time.Minute, "Timeout of a rule query evaluated through the query-frontend.")
+	f.BoolVar(&cfg.FallbackEnabled, "ruler.frontend-fallback-enabled", true, "If enabled, rule queries which fail to be evaluated through the query-frontend because of an internal or network error are evaluated by the ruler itself.")
+	cfg.GRPCClient.RegisterFlagsWithPrefix("ruler.frontend-client", f)
+}
+
+// Validate the config.
+func (cfg *FrontendConfig) Validate(log log.Logger) error {
+	if cfg.Address == "" {
+		return nil
+	}
+	return cfg.GRPCClient.Validate(log)
+}
+
+// RemoteQuerier evaluates rule queries through the Prometheus instant query API exposed by a query-frontend.
+type RemoteQuerier struct {
+	client    httpgrpc.HTTPClient
+	timeout   time.Duration
+	queryPath string
+}
+
+// NewRemoteQuerier dials the query-frontend configured in cfg and returns a RemoteQuerier using it.
+func NewRemoteQuerier(cfg FrontendConfig, reg prometheus.Registerer) (*RemoteQuerier, error) {
+	requestDuration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cortex_ruler_frontend_client_request_duration_seconds",
+		Help:    "Time spent executing requests to the query-frontend.",
+		Buckets: prometheus.ExponentialBuckets(0.008, 4, 7),
+	}, []string{"operation", "status_code"})
+
+	unary, stream := grpcclient.Instrument(requestDuration)
+	unary = append(unary, middleware.ClientUserHeaderInterceptor)
+	stream = append(stream, middleware.StreamClientUserHeaderInterceptor)
+
+	opts, err := cfg.GRPCClient.DialOption(unary, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(cfg.Address, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial query-frontend %s", cfg.Address)
+	}
+
+	return newRemoteQuerier(httpgrpc.NewHTTPClient(conn), cfg.Timeout, cfg.PrometheusHTTPPrefix), nil
+}
+
+func newRemoteQuerier(client httpgrpc.HTTPClient, timeout time.Duration, prometheusHTTPPrefix string) *RemoteQuerier {
+	return &RemoteQuerier{
+		client:    client,
+		timeout:   timeout,
+		queryPath: path.Join("/", prometheusHTTPPrefix, "/api/v1/query"),
+	}
+}
+
+// Query runs the instant query qs at time t through the query-frontend. The tenant is read from ctx.
+func (q *RemoteQuerier) Query(ctx context.Context, qs string, t time.Time) (promql.Vector, error) {
+	args := url.Values{}
+	args.Set("query", qs)
+	args.Set("time", strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', -1, 64))
+	body := []byte(args.Encode())
+
+	orgID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &httpgrpc.HTTPRequest{
+		Method: http.MethodPost,
+		Url:    q.queryPath,
+		Body:   body,
+		Headers: []*httpgrpc.Header{
+			{Key: user.OrgIDHeaderName, Values: []string{orgID}},
+			{Key: "Content-Type", Values: []string{"application/x-www-form-urlencoded"}},
+			{Key: "Content-Length", Values: []string{strconv.Itoa(len(body))}},
+		},
+	}
+
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+
+	resp, err := q.client.Handle(ctx, req)
+	if err != nil {
+		// 5xx responses and transport errors are returned as errors by the httpgrpc client.
+		return nil, err
+	}
+	if resp.Code/100 != 2 {
+		// Returned as a httpgrpc error so that the status code is preserved.
+		return nil, httpgrpc.ErrorFromHTTPResponse(resp)
+	}
+
+	return decodeQueryResponse(resp.Body)
+}
+
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+type queryData struct {
+	ResultType model.ValueType `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// decodeQueryResponse converts the JSON response of the Prometheus instant query API into a promql.Vector,
+// which is the only value type a rule query can return, apart from a scalar.
+func decodeQueryResponse(body []byte) (promql.Vector, error) {
+	var resp apiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode query response")
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("query failed with error type %q: %s", resp.ErrorType, resp.Error)
+	}
+
+	var data queryData
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to decode query response data")
+	}
+
+	switch data.ResultType {
+	case model.ValVector:
+		var vector model.Vector
+		if err := json.Unmarshal(data.Result, &vector); err != nil {
+			return nil, errors.Wrap(err, "failed to decode vector")
+		}
+
+		out := make(promql.Vector, 0, len(vector))
+		for _, s := range vector {
+			out = append(out, promql.Sample{
+				Metric: cortexpb.FromLabelAdaptersToLabels(cortexpb.FromMetricsToLabelAdapters(s.Metric)),
+				Point:  promql.Point{T: int64(s.Timestamp), V: float64(s.Value)},
+			})
+		}
+		return out, nil
+
+	case model.ValScalar:
+		var scalar model.Scalar
+		if err := json.Unmarshal(data.Result, &scalar); err != nil {
+			return nil, errors.Wrap(err, "failed to decode scalar")
+		}
+
+		return promql.Vector{{
+			Metric: labels.Labels{},
+			Point:  promql.Point{T: int64(scalar.Timestamp), V: float64(scalar.Value)},
+		}}, nil
+
+	default:
+		return nil, fmt.Errorf("rule result is not a vector or scalar: %q", data.ResultType)
+	}
+}
+
+type remoteEvaluationMetrics struct {
+	duration  prometheus.Histogram
+	failures  prometheus.Counter
+	fallbacks prometheus.Counter
+}
+
+func newRemoteEvaluationMetrics(reg prometheus.Registerer) *remoteEvaluationMetrics {
+	return &remoteEvaluationMetrics{
+		duration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_ruler_remote_evaluation_duration_seconds",
+			Help:    "Time spent evaluating rule queries through the query-frontend.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ruler_remote_evaluation_failures_total",
+			Help: "Total number of rule queries which failed to be evaluated through the query-frontend because of an internal or network error.",
+		}),
+		fallbacks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ruler_remote_evaluation_fallbacks_total",
+			Help: "Total number of rule queries evaluated by the ruler itself after failing to be evaluated through the query-frontend.",
+		}),
+	}
+}
+
+// RemoteQueryFunc returns a new query function evaluating the rule queries through the query-frontend.
+// If local is not nil, queries failing because of an internal or network error are evaluated with it instead.
+func RemoteQueryFunc(remote *RemoteQuerier, local rules.QueryFunc, overrides RulesLimits, userID string, metrics *remoteEvaluationMetrics, logger log.Logger) rules.QueryFunc {
+	return func(ctx context.Context, qs string, t time.Time) (promql.Vector, error) {
+		// Delay the evaluation of all rules by a set interval to give a buffer
+		// to metric that haven't been forwarded to cortex yet.
+		timer := prometheus.NewTimer(metrics.duration)
+		result, err := remote.Query(ctx, qs, t.Add(-overrides.EvaluationDelay(userID)))
+		timer.ObserveDuration()
+
+		// 4xx errors are caused by the query itself (eg. invalid query or limits), so there's no point in
+		// running the query again locally.
+		if err == nil || isRemoteUserError(err) {
+			return result, err
+		}
+
+		metrics.failures.Inc()
+
+		// Do not fallback if the rule evaluation itself has been canceled (eg. on shutdown).
+		if local == nil || ctx.Err() != nil {
+			return nil, WrapQueryableErrors(err)
+		}
+
+		level.Warn(util_log.WithContext(ctx, logger)).Log("msg", "failed to evaluate rule query through the query-frontend, falling back to local evaluation", "query", qs, "err", err)
+		metrics.fallbacks.Inc()
+		return local(ctx, qs, t)
+	}
+}
+
+func isRemoteUserError(err error) bool {
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	return ok && resp.Code/100 == 4
+}
@@ -0,0 +1,194 @@
+package ruler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+)
+
+type mockHTTPClient struct {
+	req  *httpgrpc.HTTPRequest
+	resp *httpgrpc.HTTPResponse
+	err  error
+}
+
+func (c *mockHTTPClient) Handle(_ context.Context, req *httpgrpc.HTTPRequest, _ ...grpc.CallOption) (*httpgrpc.HTTPResponse, error) {
+	c.req = req
+	return c.resp, c.err
+}
+
+func TestRemoteQuerier_Query(t *testing.T) {
+	tests := map[string]struct {
+		resp     *httpgrpc.HTTPResponse
+		expected promql.Vector
+		err      string
+	}{
+		"vector result": {
+			resp: &httpgrpc.HTTPResponse{Code: http.StatusOK, Body: []byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"test"},"value":[1.5,"1"]}]}}`)},
+			expected: promql.Vector{{
+				Metric: labels.FromStrings("__name__", "up", "job", "test"),
+				Point:  promql.Point{T: 1500, V: 1},
+			}},
+		},
+		"scalar result": {
+			resp: &httpgrpc.HTTPResponse{Code: http.StatusOK, Body: []byte(`{"status":"success","data":{"resultType":"scalar","result":[1.5,"2"]}}`)},
+			expected: promql.Vector{{
+				Metric: labels.Labels{},
+				Point:  promql.Point{T: 1500, V: 2},
+			}},
+		},
+		"matrix result": {
+			resp: &httpgrpc.HTTPResponse{Code: http.StatusOK, Body: []byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`)},
+			err:  `rule result is not a vector or scalar: "matrix"`,
+		},
+		"user error": {
+			resp: &httpgrpc.HTTPResponse{Code: http.StatusBadRequest, Body: []byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`)},
+			err:  "rpc error: code = Code(400)",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			client := &mockHTTPClient{resp: testData.resp}
+			q := newRemoteQuerier(client, time.Minute, "/prometheus")
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			res, err := q.Query(ctx, "up", time.Unix(1, 500*int64(time.Millisecond)))
+			if testData.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), testData.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testData.expected, res)
+			}
+
+			require.NotNil(t, client.req)
+			assert.Equal(t, http.MethodPost, client.req.Method)
+			assert.Equal(t, "/prometheus/api/v1/query", client.req.Url)
+
+			args, err := url.ParseQuery(string(client.req.Body))
+			require.NoError(t, err)
+			assert.Equal(t, "up", args.Get("query"))
+			assert.Equal(t, "1.5", args.Get("time"))
+
+			headers := map[string][]string{}
+			for _, h := range client.req.Headers {
+				headers[h.Key] = h.Values
+			}
+			assert.Equal(t, []string{"user-1"}, headers[user.OrgIDHeaderName])
+		})
+	}
+}
+
+func TestRemoteQuerier_QueryShouldFailWithoutTenant(t *testing.T) {
+	client := &mockHTTPClient{}
+	q := newRemoteQuerier(client, time.Minute, "/prometheus")
+
+	_, err := q.Query(context.Background(), "up", time.Now())
+	require.Error(t, err)
+	assert.Nil(t, client.req)
+}
+
+func TestRemoteQueryFunc(t *testing.T) {
+	localResult := promql.Vector{{Metric: labels.FromStrings("source", "local")}}
+
+	tests := map[string]struct {
+		remoteErr         error
+		fallbackEnabled   bool
+		expectedResult    promql.Vector
+		expectedErr       bool
+		expectedQueryable bool
+		expectedFailures  int
+		expectedFallbacks int
+	}{
+		"remote evaluation succeeded": {
+			expectedResult: promql.Vector{},
+		},
+		"user error should not fallback": {
+			remoteErr:       httpgrpc.Errorf(http.StatusUnprocessableEntity, "limit reached"),
+			fallbackEnabled: true,
+			expectedErr:     true,
+		},
+		"internal error should fallback if enabled": {
+			remoteErr:         httpgrpc.Errorf(http.StatusInternalServerError, "internal error"),
+			fallbackEnabled:   true,
+			expectedResult:    localResult,
+			expectedFailures:  1,
+			expectedFallbacks: 1,
+		},
+		"network error should fallback if enabled": {
+			remoteErr:         errors.New("connection refused"),
+			fallbackEnabled:   true,
+			expectedResult:    localResult,
+			expectedFailures:  1,
+			expectedFallbacks: 1,
+		},
+		"internal error should be returned as queryable error if fallback is disabled": {
+			remoteErr:         httpgrpc.Errorf(http.StatusInternalServerError, "internal error"),
+			expectedErr:       true,
+			expectedQueryable: true,
+			expectedFailures:  1,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			client := &mockHTTPClient{
+				resp: &httpgrpc.HTTPResponse{Code: http.StatusOK, Body: []byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`)},
+				err:  testData.remoteErr,
+			}
+
+			var local func(ctx context.Context, qs string, t time.Time) (promql.Vector, error)
+			if testData.fallbackEnabled {
+				local = func(context.Context, string, time.Time) (promql.Vector, error) {
+					return localResult, nil
+				}
+			}
+
+			reg := prometheus.NewPedanticRegistry()
+			metrics := newRemoteEvaluationMetrics(reg)
+			qf := RemoteQueryFunc(newRemoteQuerier(client, time.Minute, ""), local, ruleLimits{evalDelay: time.Minute}, "user-1", metrics, log.NewNopLogger())
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			res, err := qf(ctx, "up", time.Unix(120, 0))
+			if testData.expectedErr {
+				require.Error(t, err)
+				assert.Equal(t, testData.expectedQueryable, errors.As(err, &QueryableError{}))
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testData.expectedResult, res)
+			}
+
+			// The evaluation delay should be applied to the remote query.
+			args, err := url.ParseQuery(string(client.req.Body))
+			require.NoError(t, err)
+			assert.Equal(t, "60", args.Get("time"))
+
+			assert.Equal(t, 1, testutil.CollectAndCount(metrics.duration))
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP cortex_ruler_remote_evaluation_failures_total Total number of rule queries which failed to be evaluated through the query-frontend because of an internal or network error.
+				# TYPE cortex_ruler_remote_evaluation_failures_total counter
+				cortex_ruler_remote_evaluation_failures_total `+strconv.Itoa(testData.expectedFailures)+`
+				# HELP cortex_ruler_remote_evaluation_fallbacks_total Total number of rule queries evaluated by the ruler itself after failing to be evaluated through the query-frontend.
+				# TYPE cortex_ruler_remote_evaluation_fallbacks_total counter
+				cortex_ruler_remote_evaluation_fallbacks_total `+strconv.Itoa(testData.expectedFallbacks)+`
+			`), "cortex_ruler_remote_evaluation_failures_total", "cortex_ruler_remote_evaluation_fallbacks_total"))
+		})
+	}
+}
@@ -116,6 +116,9 @@ type Config struct {
 	RingCheckPeriod time.Duration `yaml:"-"`
 
 	EnableQueryStats bool `yaml:"query_stats_enabled"`
+
+	// Remote evaluation of rules through the query-frontend.
+	Frontend FrontendConfig `yaml:"frontend"`
 }
 
 // Validate config and returns error on failure
@@ -134,6 +137,9 @@ func (cfg *Config) Validate(limits validation.Limits, log log.Logger) error {
 	if err := cfg.ClientTLSConfig.Validate(log); err != nil {
 		return errors.Wrap(err, "invalid ruler gRPC client config")
 	}
+	if err := cfg.Frontend.Validate(log); err != nil {
+		return errors.Wrap(err, "invalid ruler query-frontend config")
+	}
 	return nil
 }
 
@@ -143,6 +149,7 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	cfg.StoreConfig.RegisterFlags(f)
 	cfg.Ring.RegisterFlags(f)
 	cfg.Notifier.RegisterFlags(f)
+	cfg.Frontend.RegisterFlags(f)
 
 	// Deprecated Flags that will be maintained to avoid user disruption
 
@@ -834,6 +841,22 @@ func (r *Ruler) AssertMaxRulesPerRuleGroup(userID string, rules int) error {
 	return fmt.Errorf(errMaxRulesPerRuleGroupPerUserLimitExceeded, limit, rules)
 }
 
+// AssertSourceTenants checks the source tenants of a rule group are valid and allowed for the user, and the
+// federated rule groups are enabled for the user, if the rule group is a federated one, and returns an error if not.
+func (r *Ruler) AssertSourceTenants(userID string, sourceTenants []string) error {
+	if len(sourceTenants) == 0 {
+		return nil
+	}
+
+	for _, sourceTenant := range sourceTenants {
+		if err := tenant.ValidTenantID(sourceTenant); err != nil {
+			return errors.Wrap(err, "invalid source tenant")
+		}
+	}
+
+	return assertSourceTenantsAllowed(r.limits, userID, sourceTenants)
+}
+
 func (r *Ruler) DeleteTenantConfiguration(w http.ResponseWriter, req *http.Request) {
 	logger := util_log.WithContext(req.Context(), r.logger)
 
@@ -882,7 +905,7 @@ func (r *Ruler) ListAllRules(w http.ResponseWriter, req *http.Request) {
 		if err := r.store.LoadRuleGroups(ctx, userRules); err != nil {
 			return errors.Wrapf(err, "failed to load ruler config for user %s", userID)
 		}
-		data := map[string]map[string][]rulefmt.RuleGroup{userID: userRules[userID].Formatted()}
+		data := map[string]map[string][]rulespb.RuleGroup{userID: userRules[userID].FormattedWithSourceTenants()}
 
 		select {
 		case iter <- data:
@@ -93,6 +93,8 @@ type ruleLimits struct {
 	tenantShard          int
 	maxRulesPerRuleGroup int
 	maxRuleGroups        int
+	tenantFederation     bool
+	allowedSourceTenants []string
 }
 
 func (r ruleLimits) EvaluationDelay(_ string) time.Duration {
@@ -111,6 +113,14 @@ func (r ruleLimits) RulerMaxRulesPerRuleGroup(_ string) int {
 	return r.maxRulesPerRuleGroup
 }
 
+func (r ruleLimits) RulerTenantFederationEnabled(_ string) bool {
+	return r.tenantFederation
+}
+
+func (r ruleLimits) RulerAllowedSourceTenants(_ string) []string {
+	return r.allowedSourceTenants
+}
+
 type emptyChunkStore struct {
 	sync.Mutex
 	called bool
@@ -176,7 +186,7 @@ func testSetup(t *testing.T, querierTestConfig *querier.TestConfig) (*promql.Eng
 
 func newManager(t *testing.T, cfg Config) (*DefaultMultiTenantManager, func()) {
 	engine, queryable, pusher, logger, overrides, reg, cleanup := testSetup(t, nil)
-	manager, err := NewDefaultMultiTenantManager(cfg, DefaultTenantManagerFactory(cfg, pusher, queryable, engine, overrides, nil), reg, logger)
+	manager, err := NewDefaultMultiTenantManager(cfg, DefaultTenantManagerFactory(cfg, pusher, queryable, engine, nil, overrides, nil), reg, logger)
 	require.NoError(t, err)
 
 	return manager, cleanup
@@ -225,7 +235,7 @@ func buildRuler(t *testing.T, rulerConfig Config, querierTestConfig *querier.Tes
 	storage, err := NewLegacyRuleStore(rulerConfig.StoreConfig, promRules.FileLoader{}, log.NewNopLogger())
 	require.NoError(t, err)
 
-	managerFactory := DefaultTenantManagerFactory(rulerConfig, pusher, queryable, engine, overrides, reg)
+	managerFactory := DefaultTenantManagerFactory(rulerConfig, pusher, queryable, engine, nil, overrides, reg)
 	manager, err := NewDefaultMultiTenantManager(rulerConfig, managerFactory, reg, log.NewNopLogger())
 	require.NoError(t, err)
 
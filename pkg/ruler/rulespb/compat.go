@@ -39,6 +39,30 @@ func formattedRuleToProto(rls []rulefmt.RuleNode) []*RuleDesc {
 	return rules
 }
 
+// RuleGroup is a Prometheus rule group extended with the Cortex specific fields.
+type RuleGroup struct {
+	rulefmt.RuleGroup `yaml:",inline"`
+
+	// The tenants the rule group queries are evaluated over. If empty, the queries
+	// are evaluated over the tenant owning the rule group.
+	SourceTenants []string `yaml:"source_tenants,omitempty"`
+}
+
+// ToProtoWithSourceTenants transforms a Cortex rule group to a rule group protobuf
+func ToProtoWithSourceTenants(user string, namespace string, rl RuleGroup) *RuleGroupDesc {
+	rg := ToProto(user, namespace, rl.RuleGroup)
+	rg.SourceTenants = rl.SourceTenants
+	return rg
+}
+
+// FromProtoWithSourceTenants generates a Cortex RuleGroup
+func FromProtoWithSourceTenants(rg *RuleGroupDesc) RuleGroup {
+	return RuleGroup{
+		RuleGroup:     FromProto(rg),
+		SourceTenants: rg.GetSourceTenants(),
+	}
+}
+
 // FromProto generates a rulefmt RuleGroup
 func FromProto(rg *RuleGroupDesc) rulefmt.RuleGroup {
 	formattedRuleGroup := rulefmt.RuleGroup{
@@ -19,3 +19,13 @@ func (l RuleGroupList) Formatted() map[string][]rulefmt.RuleGroup {
 	}
 	return ruleMap
 }
+
+// FormattedWithSourceTenants returns the rule group list as a set of Cortex rule
+// groups mapped by namespace
+func (l RuleGroupList) FormattedWithSourceTenants() map[string][]RuleGroup {
+	ruleMap := map[string][]RuleGroup{}
+	for _, g := range l {
+		ruleMap[g.Namespace] = append(ruleMap[g.Namespace], FromProtoWithSourceTenants(g))
+	}
+	return ruleMap
+}
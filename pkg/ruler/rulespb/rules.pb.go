@@ -44,6 +44,9 @@ type RuleGroupDesc struct {
 	// to create custom `ManagerOpts` based on rule configs which can then be passed
 	// to the Prometheus Manager.
 	Options []*types.Any `protobuf:"bytes,9,rep,name=options,proto3" json:"options,omitempty"`
+	// The tenants the rule group queries are evaluated over, when the rule group is
+	// a federated one. If empty, the queries are evaluated over the owning tenant.
+	SourceTenants []string `protobuf:"bytes,10,rep,name=sourceTenants,proto3" json:"sourceTenants,omitempty"`
 }
 
 func (m *RuleGroupDesc) Reset()      { *m = RuleGroupDesc{} }
@@ -120,6 +123,13 @@ func (m *RuleGroupDesc) GetOptions() []*types.Any {
 	return nil
 }
 
+func (m *RuleGroupDesc) GetSourceTenants() []string {
+	if m != nil {
+		return m.SourceTenants
+	}
+	return nil
+}
+
 // RuleDesc is a proto representation of a Prometheus Rule
 type RuleDesc struct {
 	Expr        string                                                      `protobuf:"bytes,1,opt,name=expr,proto3" json:"expr,omitempty"`
@@ -198,37 +208,38 @@ func init() {
 func init() { proto.RegisterFile("rules.proto", fileDescriptor_8e722d3e922f0937) }
 
 var fileDescriptor_8e722d3e922f0937 = []byte{
-	// 476 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x52, 0x3f, 0x6f, 0xd3, 0x40,
-	0x1c, 0xf5, 0x35, 0x8e, 0x63, 0x5f, 0x54, 0x11, 0x1d, 0x15, 0x72, 0x2b, 0x74, 0x89, 0x2a, 0x21,
-	0x65, 0xe1, 0x22, 0x15, 0x31, 0x30, 0x20, 0x94, 0xa8, 0x12, 0x52, 0xc4, 0x80, 0x3c, 0xb2, 0x9d,
-	0x9d, 0xab, 0x31, 0xb8, 0xbe, 0xd3, 0xf9, 0x8c, 0xda, 0x8d, 0x8f, 0xc0, 0xc8, 0x47, 0xe0, 0xa3,
-	0x74, 0xcc, 0x58, 0x31, 0x14, 0xe2, 0x2c, 0x8c, 0x95, 0xf8, 0x00, 0xa0, 0xfb, 0x63, 0x5a, 0xc1,
-	0x02, 0x03, 0x53, 0x7e, 0xef, 0xde, 0xbd, 0xbc, 0xf7, 0x7b, 0x67, 0x38, 0x94, 0x4d, 0xc9, 0x6a,
-	0x22, 0x24, 0x57, 0x1c, 0xf5, 0x0d, 0x38, 0x78, 0x98, 0x17, 0xea, 0x75, 0x93, 0x92, 0x8c, 0x9f,
-	0xce, 0x72, 0x9e, 0xf3, 0x99, 0x61, 0xd3, 0xe6, 0xc4, 0x20, 0x03, 0xcc, 0x64, 0x55, 0x07, 0x38,
-	0xe7, 0x3c, 0x2f, 0xd9, 0xcd, 0xad, 0x55, 0x23, 0xa9, 0x2a, 0x78, 0xe5, 0xf8, 0xfd, 0xdf, 0x79,
-	0x5a, 0x9d, 0x3b, 0xea, 0xc9, 0x2d, 0xa7, 0x8c, 0x4b, 0xc5, 0xce, 0x84, 0xe4, 0x6f, 0x58, 0xa6,
-	0x1c, 0x9a, 0x89, 0xb7, 0x79, 0x47, 0xa4, 0x6e, 0xb0, 0xd2, 0xc3, 0x1f, 0x00, 0xee, 0x26, 0x4d,
-	0xc9, 0x9e, 0x4b, 0xde, 0x88, 0x63, 0x56, 0x67, 0x08, 0x41, 0xbf, 0xa2, 0xa7, 0x2c, 0x06, 0x13,
-	0x30, 0x8d, 0x12, 0x33, 0xa3, 0xfb, 0x30, 0xd2, 0xbf, 0xb5, 0xa0, 0x19, 0x8b, 0x77, 0x0c, 0x71,
-	0x73, 0x80, 0x9e, 0xc1, 0xb0, 0xa8, 0x14, 0x93, 0xef, 0x68, 0x19, 0xf7, 0x26, 0x60, 0x3a, 0x3c,
-	0xda, 0x27, 0x36, 0x2c, 0xe9, 0xc2, 0x92, 0x63, 0xb7, 0xcc, 0x22, 0xbc, 0xb8, 0x1a, 0x7b, 0x1f,
-	0xbf, 0x8c, 0x41, 0xf2, 0x4b, 0x84, 0x1e, 0x40, 0x5b, 0x59, 0xec, 0x4f, 0x7a, 0xd3, 0xe1, 0xd1,
-	0x1d, 0x62, 0xdb, 0xd4, 0xb9, 0x74, 0xa4, 0xc4, 0xb2, 0x3a, 0x59, 0x53, 0x33, 0x19, 0x07, 0x36,
-	0x99, 0x9e, 0x11, 0x81, 0x03, 0x2e, 0xf4, 0x1f, 0xd7, 0x71, 0x64, 0xc4, 0x7b, 0x7f, 0x58, 0xcf,
-	0xab, 0xf3, 0xa4, 0xbb, 0xb4, 0xf4, 0xc3, 0xfe, 0x28, 0x58, 0xfa, 0xe1, 0x60, 0x14, 0x2e, 0xfd,
-	0x30, 0x1c, 0x45, 0x87, 0xdf, 0x77, 0x60, 0xd8, 0x39, 0x69, 0x0b, 0x5d, 0x5e, 0xb7, 0xbc, 0x9e,
-	0xd1, 0x3d, 0x18, 0x48, 0x96, 0x71, 0xb9, 0x72, 0x9b, 0x3b, 0x84, 0xf6, 0x60, 0x9f, 0x96, 0x4c,
-	0x2a, 0xb3, 0x73, 0x94, 0x58, 0x80, 0x1e, 0xc3, 0xde, 0x09, 0x97, 0xb1, 0xff, 0xf7, 0x3d, 0xe8,
-	0xfb, 0xa8, 0x82, 0x41, 0x49, 0x53, 0x56, 0xd6, 0x71, 0xdf, 0xac, 0x71, 0x97, 0x74, 0xef, 0x45,
-	0x5e, 0xe8, 0xf3, 0x97, 0xb4, 0x90, 0x8b, 0xb9, 0xd6, 0x7c, 0xbe, 0x1a, 0xff, 0xd3, 0x7b, 0x5b,
-	0xfd, 0x7c, 0x45, 0x85, 0x62, 0x32, 0x71, 0x2e, 0xe8, 0x0c, 0x0e, 0x69, 0x55, 0x71, 0x45, 0x6d,
-	0x77, 0xc1, 0x7f, 0x35, 0xbd, 0x6d, 0x65, 0xba, 0xdf, 0x5d, 0x3c, 0x5d, 0x6f, 0xb0, 0x77, 0xb9,
-	0xc1, 0xde, 0xf5, 0x06, 0x83, 0xf7, 0x2d, 0x06, 0x9f, 0x5a, 0x0c, 0x2e, 0x5a, 0x0c, 0xd6, 0x2d,
-	0x06, 0x5f, 0x5b, 0x0c, 0xbe, 0xb5, 0xd8, 0xbb, 0x6e, 0x31, 0xf8, 0xb0, 0xc5, 0xde, 0x7a, 0x8b,
-	0xbd, 0xcb, 0x2d, 0xf6, 0x5e, 0x0d, 0xcc, 0x87, 0x20, 0xd2, 0x34, 0x30, 0x85, 0x3e, 0xfa, 0x19,
-	0x00, 0x00, 0xff, 0xff, 0xa0, 0xd3, 0x9a, 0x1a, 0x78, 0x03, 0x00, 0x00,
+	// 496 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x52, 0x41, 0x6f, 0xd3, 0x30,
+	0x18, 0x8d, 0xdb, 0x34, 0x4d, 0x5c, 0x55, 0x54, 0x66, 0x42, 0xd9, 0x84, 0xdc, 0x6a, 0x02, 0xa9,
+	0x17, 0x5c, 0x69, 0x88, 0x03, 0x07, 0x84, 0x5a, 0x4d, 0x42, 0xaa, 0x38, 0xa0, 0x88, 0x13, 0x37,
+	0x27, 0xf5, 0x42, 0x20, 0xb3, 0x23, 0xc7, 0x41, 0xdb, 0x8d, 0x9f, 0xc0, 0x91, 0x3f, 0x80, 0xc4,
+	0x4f, 0xd9, 0xb1, 0xc7, 0x89, 0xc3, 0xa0, 0xe9, 0x85, 0xe3, 0x24, 0xfe, 0x00, 0xb2, 0x9d, 0xb0,
+	0x01, 0x17, 0x38, 0xec, 0x94, 0xef, 0x7d, 0xef, 0x7b, 0xf9, 0x9e, 0x9f, 0x0d, 0x07, 0xb2, 0xca,
+	0x59, 0x49, 0x0a, 0x29, 0x94, 0x40, 0x3d, 0x03, 0xf6, 0x1e, 0xa4, 0x99, 0x7a, 0x5d, 0xc5, 0x24,
+	0x11, 0xc7, 0xb3, 0x54, 0xa4, 0x62, 0x66, 0xd8, 0xb8, 0x3a, 0x32, 0xc8, 0x00, 0x53, 0x59, 0xd5,
+	0x1e, 0x4e, 0x85, 0x48, 0x73, 0x76, 0x35, 0xb5, 0xaa, 0x24, 0x55, 0x99, 0xe0, 0x0d, 0xbf, 0xfb,
+	0x27, 0x4f, 0xf9, 0x69, 0x43, 0x3d, 0xbe, 0xb6, 0x29, 0x11, 0x52, 0xb1, 0x93, 0x42, 0x8a, 0x37,
+	0x2c, 0x51, 0x0d, 0x9a, 0x15, 0x6f, 0xd3, 0x96, 0x88, 0x9b, 0xc2, 0x4a, 0xf7, 0x3f, 0x75, 0xe0,
+	0x30, 0xaa, 0x72, 0xf6, 0x4c, 0x8a, 0xaa, 0x38, 0x64, 0x65, 0x82, 0x10, 0x74, 0x39, 0x3d, 0x66,
+	0x21, 0x98, 0x80, 0x69, 0x10, 0x99, 0x1a, 0xdd, 0x85, 0x81, 0xfe, 0x96, 0x05, 0x4d, 0x58, 0xd8,
+	0x31, 0xc4, 0x55, 0x03, 0x3d, 0x85, 0x7e, 0xc6, 0x15, 0x93, 0xef, 0x68, 0x1e, 0x76, 0x27, 0x60,
+	0x3a, 0x38, 0xd8, 0x25, 0xd6, 0x2c, 0x69, 0xcd, 0x92, 0xc3, 0xe6, 0x30, 0x0b, 0xff, 0xec, 0x62,
+	0xec, 0x7c, 0xfc, 0x3a, 0x06, 0xd1, 0x2f, 0x11, 0xba, 0x0f, 0x6d, 0x64, 0xa1, 0x3b, 0xe9, 0x4e,
+	0x07, 0x07, 0xb7, 0x88, 0x41, 0x44, 0xfb, 0xd2, 0x96, 0x22, 0xcb, 0x6a, 0x67, 0x55, 0xc9, 0x64,
+	0xe8, 0x59, 0x67, 0xba, 0x46, 0x04, 0xf6, 0x45, 0xa1, 0x7f, 0x5c, 0x86, 0x81, 0x11, 0xef, 0xfc,
+	0xb5, 0x7a, 0xce, 0x4f, 0xa3, 0x76, 0x08, 0xdd, 0x83, 0xc3, 0x52, 0x54, 0x32, 0x61, 0x2f, 0x19,
+	0xa7, 0x5c, 0x95, 0x21, 0x9c, 0x74, 0xa7, 0x41, 0xf4, 0x7b, 0x73, 0xe9, 0xfa, 0xbd, 0x91, 0xb7,
+	0x74, 0xfd, 0xfe, 0xc8, 0x5f, 0xba, 0xbe, 0x3f, 0x0a, 0xf6, 0x7f, 0x74, 0xa0, 0xdf, 0xfa, 0xd1,
+	0x46, 0x74, 0xc4, 0x6d, 0x44, 0xba, 0x46, 0x77, 0xa0, 0x27, 0x59, 0x22, 0xe4, 0xaa, 0xc9, 0xa7,
+	0x41, 0x68, 0x07, 0xf6, 0x68, 0xce, 0xa4, 0x32, 0xc9, 0x04, 0x91, 0x05, 0xe8, 0x11, 0xec, 0x1e,
+	0x09, 0x19, 0xba, 0xff, 0x9e, 0x96, 0x9e, 0x47, 0x1c, 0x7a, 0x39, 0x8d, 0x59, 0x5e, 0x86, 0x3d,
+	0x73, 0xd8, 0xdb, 0xa4, 0xbd, 0x55, 0xf2, 0x5c, 0xf7, 0x5f, 0xd0, 0x4c, 0x2e, 0xe6, 0x5a, 0xf3,
+	0xe5, 0x62, 0xfc, 0x5f, 0xaf, 0xc2, 0xea, 0xe7, 0x2b, 0x5a, 0x28, 0x26, 0xa3, 0x66, 0x0b, 0x3a,
+	0x81, 0x03, 0xca, 0xb9, 0x50, 0xd4, 0x26, 0xec, 0xdd, 0xe8, 0xd2, 0xeb, 0xab, 0x4c, 0xf6, 0xc3,
+	0xc5, 0x93, 0xf5, 0x06, 0x3b, 0xe7, 0x1b, 0xec, 0x5c, 0x6e, 0x30, 0x78, 0x5f, 0x63, 0xf0, 0xb9,
+	0xc6, 0xe0, 0xac, 0xc6, 0x60, 0x5d, 0x63, 0xf0, 0xad, 0xc6, 0xe0, 0x7b, 0x8d, 0x9d, 0xcb, 0x1a,
+	0x83, 0x0f, 0x5b, 0xec, 0xac, 0xb7, 0xd8, 0x39, 0xdf, 0x62, 0xe7, 0x55, 0xdf, 0x3c, 0x97, 0x22,
+	0x8e, 0x3d, 0x13, 0xe8, 0xc3, 0x9f, 0x03, 0x00, 0x6b, 0x90, 0x31, 0x1e, 0x9e, 0x03, 0x00, 0x00,
 }
 
 func (this *RuleGroupDesc) Equal(that interface{}) bool {
@@ -278,6 +289,14 @@ func (this *RuleGroupDesc) Equal(that interface{}) bool {
 			return false
 		}
 	}
+	if len(this.SourceTenants) != len(that1.SourceTenants) {
+		return false
+	}
+	for i := range this.SourceTenants {
+		if this.SourceTenants[i] != that1.SourceTenants[i] {
+			return false
+		}
+	}
 	return true
 }
 func (this *RuleDesc) Equal(that interface{}) bool {
@@ -333,7 +352,7 @@ func (this *RuleGroupDesc) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 10)
+	s := make([]string, 0, 11)
 	s = append(s, "&rulespb.RuleGroupDesc{")
 	s = append(s, "Name: "+fmt.Sprintf("%#v", this.Name)+",\n")
 	s = append(s, "Namespace: "+fmt.Sprintf("%#v", this.Namespace)+",\n")
@@ -345,6 +364,7 @@ func (this *RuleGroupDesc) GoString() string {
 	if this.Options != nil {
 		s = append(s, "Options: "+fmt.Sprintf("%#v", this.Options)+",\n")
 	}
+	s = append(s, "SourceTenants: "+fmt.Sprintf("%#v", this.SourceTenants)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -391,6 +411,15 @@ func (m *RuleGroupDesc) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.SourceTenants) > 0 {
+		for iNdEx := len(m.SourceTenants) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.SourceTenants[iNdEx])
+			copy(dAtA[i:], m.SourceTenants[iNdEx])
+			i = encodeVarintRules(dAtA, i, uint64(len(m.SourceTenants[iNdEx])))
+			i--
+			dAtA[i] = 0x52
+		}
+	}
 	if len(m.Options) > 0 {
 		for iNdEx := len(m.Options) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -574,6 +603,12 @@ func (m *RuleGroupDesc) Size() (n int) {
 			n += 1 + l + sovRules(uint64(l))
 		}
 	}
+	if len(m.SourceTenants) > 0 {
+		for _, s := range m.SourceTenants {
+			l = len(s)
+			n += 1 + l + sovRules(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -639,6 +674,7 @@ func (this *RuleGroupDesc) String() string {
 		`Rules:` + repeatedStringForRules + `,`,
 		`User:` + fmt.Sprintf("%v", this.User) + `,`,
 		`Options:` + repeatedStringForOptions + `,`,
+		`SourceTenants:` + fmt.Sprintf("%v", this.SourceTenants) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -892,6 +928,38 @@ func (m *RuleGroupDesc) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SourceTenants", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRules
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRules
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRules
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SourceTenants = append(m.SourceTenants, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRules(dAtA[iNdEx:])
@@ -0,0 +1,121 @@
+package ruler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ruler/rulespb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const errSourceTenantNotAllowed = "the source tenant %s is not allowed for the federated rule groups of the tenant"
+
+var errTenantFederationDisabled = errors.New("federated rule groups are not enabled for the tenant")
+
+// assertSourceTenantsAllowed returns an error if the federated rule groups are not enabled for the user,
+// or if any of the source tenants is neither the user itself nor one of its allowed source tenants.
+func assertSourceTenantsAllowed(overrides RulesLimits, userID string, sourceTenants []string) error {
+	if !overrides.RulerTenantFederationEnabled(userID) {
+		return errTenantFederationDisabled
+	}
+
+	allowed := overrides.RulerAllowedSourceTenants(userID)
+	for _, sourceTenant := range sourceTenants {
+		if sourceTenant != userID && !util.StringsContain(allowed, sourceTenant) {
+			return fmt.Errorf(errSourceTenantNotAllowed, sourceTenant)
+		}
+	}
+	return nil
+}
+
+type federatedRuleGroupsContextKey struct{}
+
+type federatedRuleGroupKey struct {
+	file string
+	name string
+}
+
+// federatedRuleGroups holds the source tenants of the federated rule groups of a tenant,
+// by rule file and rule group name, as seen by the Prometheus rules manager.
+type federatedRuleGroups struct {
+	mtx           sync.RWMutex
+	sourceTenants map[federatedRuleGroupKey][]string
+}
+
+func newFederatedRuleGroups() *federatedRuleGroups {
+	return &federatedRuleGroups{
+		sourceTenants: map[federatedRuleGroupKey][]string{},
+	}
+}
+
+// update replaces the source tenants with the ones of the federated groups of the input rule groups.
+func (f *federatedRuleGroups) update(m *mapper, userID string, groups rulespb.RuleGroupList) {
+	sourceTenants := map[federatedRuleGroupKey][]string{}
+	for _, g := range groups {
+		if len(g.SourceTenants) == 0 {
+			continue
+		}
+
+		key := federatedRuleGroupKey{file: m.ruleFilePath(userID, g.Namespace), name: g.Name}
+		sourceTenants[key] = tenant.NormalizeTenantIDs(append([]string(nil), g.SourceTenants...))
+	}
+
+	f.mtx.Lock()
+	f.sourceTenants = sourceTenants
+	f.mtx.Unlock()
+}
+
+func (f *federatedRuleGroups) get(file, name string) []string {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	return f.sourceTenants[federatedRuleGroupKey{file: file, name: name}]
+}
+
+func contextWithFederatedRuleGroups(ctx context.Context, f *federatedRuleGroups) context.Context {
+	return context.WithValue(ctx, federatedRuleGroupsContextKey{}, f)
+}
+
+// sourceTenantsFromContext returns the source tenants of the rule group being evaluated, if it's a federated one.
+func sourceTenantsFromContext(ctx context.Context) []string {
+	f, ok := ctx.Value(federatedRuleGroupsContextKey{}).(*federatedRuleGroups)
+	if !ok {
+		return nil
+	}
+
+	// The Prometheus rules manager attaches the file and name of the rule group being evaluated to the context.
+	origin, _ := ctx.Value(promql.QueryOrigin{}).(map[string]interface{})
+	group, _ := origin["ruleGroup"].(map[string]string)
+	if group == nil {
+		return nil
+	}
+
+	return f.get(group["file"], group["name"])
+}
+
+// TenantFederationQueryFunc returns a new query function evaluating the queries of the federated rule
+// groups over their source tenants, and the queries of the other rule groups over the owning tenant.
+// The source tenants are checked again on each evaluation, since the limits may have changed since
+// the rule group has been stored.
+func TenantFederationQueryFunc(qf rules.QueryFunc, overrides RulesLimits, userID string) rules.QueryFunc {
+	return func(ctx context.Context, qs string, t time.Time) (promql.Vector, error) {
+		sourceTenants := sourceTenantsFromContext(ctx)
+		if len(sourceTenants) == 0 {
+			return qf(ctx, qs, t)
+		}
+
+		if err := assertSourceTenantsAllowed(overrides, userID, sourceTenants); err != nil {
+			return nil, err
+		}
+
+		return qf(user.InjectOrgID(ctx, tenant.JoinTenantIDs(sourceTenants)), qs, t)
+	}
+}
@@ -0,0 +1,123 @@
+package ruler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/notifier"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ruler/rulespb"
+)
+
+func TestTenantFederationQueryFunc(t *testing.T) {
+	m := newMapper("/rules", log.NewNopLogger())
+	federated := newFederatedRuleGroups()
+	federated.update(m, "user-1", rulespb.RuleGroupList{
+		{Name: "federated", Namespace: "ns", User: "user-1", SourceTenants: []string{"user-3", "user-2", "user-3"}},
+		{Name: "regular", Namespace: "ns", User: "user-1"},
+	})
+
+	groupContext := func(name string) context.Context {
+		ctx := user.InjectOrgID(contextWithFederatedRuleGroups(context.Background(), federated), "user-1")
+		return promql.NewOriginContext(ctx, map[string]interface{}{
+			"ruleGroup": map[string]string{
+				"file": m.ruleFilePath("user-1", "ns"),
+				"name": name,
+			},
+		})
+	}
+
+	tests := map[string]struct {
+		ctx                  context.Context
+		tenantFederation     bool
+		allowedSourceTenants []string
+		expectedOrgID        string
+		expectedErr          error
+	}{
+		"regular rule group should be evaluated over the owning tenant": {
+			ctx:           groupContext("regular"),
+			expectedOrgID: "user-1",
+		},
+		"federated rule group should be evaluated over the source tenants": {
+			ctx:                  groupContext("federated"),
+			tenantFederation:     true,
+			allowedSourceTenants: []string{"user-2", "user-3"},
+			expectedOrgID:        "user-2|user-3",
+		},
+		"federated rule group should fail if a source tenant is not allowed for the tenant": {
+			ctx:                  groupContext("federated"),
+			tenantFederation:     true,
+			allowedSourceTenants: []string{"user-2"},
+			expectedErr:          fmt.Errorf(errSourceTenantNotAllowed, "user-3"),
+		},
+		"federated rule group should fail if tenant federation is disabled for the tenant": {
+			ctx:         groupContext("federated"),
+			expectedErr: errTenantFederationDisabled,
+		},
+		"query without rule group origin should be evaluated over the owning tenant": {
+			ctx:           user.InjectOrgID(contextWithFederatedRuleGroups(context.Background(), federated), "user-1"),
+			expectedOrgID: "user-1",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var orgID string
+			qf := TenantFederationQueryFunc(func(ctx context.Context, _ string, _ time.Time) (promql.Vector, error) {
+				var err error
+				orgID, err = user.ExtractOrgID(ctx)
+				return nil, err
+			}, ruleLimits{tenantFederation: testData.tenantFederation, allowedSourceTenants: testData.allowedSourceTenants}, "user-1")
+
+			_, err := qf(testData.ctx, "up", time.Now())
+			require.Equal(t, testData.expectedErr, err)
+			assert.Equal(t, testData.expectedOrgID, orgID)
+		})
+	}
+}
+
+func TestSyncRuleGroups_ShouldTrackSourceTenantsOfFederatedRuleGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	var managerCtx context.Context
+	captureFactory := func(ctx context.Context, userID string, n *notifier.Manager, logger log.Logger, reg prometheus.Registerer) RulesManager {
+		managerCtx = ctx
+		return factory(ctx, userID, n, logger, reg)
+	}
+
+	m, err := NewDefaultMultiTenantManager(Config{RulePath: dir}, captureFactory, nil, log.NewNopLogger())
+	require.NoError(t, err)
+	t.Cleanup(m.Stop)
+
+	const user = "user-1"
+	group := &rulespb.RuleGroupDesc{Name: "group", Namespace: "ns", Interval: time.Minute, User: user}
+
+	m.SyncRuleGroups(context.Background(), map[string]rulespb.RuleGroupList{user: {group}})
+	require.NotNil(t, managerCtx)
+
+	federated, ok := managerCtx.Value(federatedRuleGroupsContextKey{}).(*federatedRuleGroups)
+	require.True(t, ok)
+	assert.Nil(t, federated.get(m.mapper.ruleFilePath(user, "ns"), "group"))
+
+	// Changing the source tenants only should be taken into account without re-creating the manager.
+	managerCtx = nil
+	federatedGroup := *group
+	federatedGroup.SourceTenants = []string{"user-2", "user-3"}
+	m.SyncRuleGroups(context.Background(), map[string]rulespb.RuleGroupList{user: {&federatedGroup}})
+	assert.Nil(t, managerCtx)
+	assert.Equal(t, []string{"user-2", "user-3"}, federated.get(m.mapper.ruleFilePath(user, "ns"), "group"))
+}
@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"bytes"
+	"container/heap"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// PriorityRequest is a Request having a priority. The requests of a user with higher priority are
+// dequeued first, while requests with the same priority are dequeued in FIFO order. Requests not
+// implementing this interface have priority 0.
+type PriorityRequest interface {
+	Priority() int64
+}
+
+func requestPriority(req Request) int64 {
+	if r, ok := req.(PriorityRequest); ok {
+		return r.Priority()
+	}
+	return 0
+}
+
+// GetPriority returns the priority of the HTTP request, which is the highest of the
+// priorities the request matches, or 0 if it doesn't match any.
+func GetPriority(req *httpgrpc.HTTPRequest, priorities []validation.QueryPriority, now time.Time) int64 {
+	if len(priorities) == 0 {
+		return 0
+	}
+
+	httpReq, err := http.NewRequest(req.Method, req.Url, bytes.NewReader(req.Body))
+	if err != nil {
+		return 0
+	}
+	for _, h := range req.Headers {
+		httpReq.Header[h.Key] = h.Values
+	}
+	if err := httpReq.ParseForm(); err != nil {
+		return 0
+	}
+
+	query := httpReq.FormValue("query")
+	start := util.ParseTimeParam(httpReq.FormValue("start"), now)
+	end := util.ParseTimeParam(httpReq.FormValue("end"), now)
+
+	// Instant queries are evaluated at a single timestamp.
+	if t := httpReq.FormValue("time"); t != "" {
+		start = util.ParseTimeParam(t, now)
+		end = start
+	}
+
+	var (
+		priority int64
+		matched  bool
+	)
+
+	for _, p := range priorities {
+		if (!matched || p.Priority > priority) && p.Matches(query, httpReq.Header, start, end, now) {
+			priority = p.Priority
+			matched = true
+		}
+	}
+
+	return priority
+}
+
+// reservedQueriersForUser returns the queriers reserved to the user's high priority requests, each
+// with the min priority of the requests it can handle. The queriers are reserved, among the ones
+// handling the user's requests, starting from the highest priority. At least one querier is never
+// reserved, so that requests of any priority can be handled.
+func reservedQueriersForUser(priorities []validation.QueryPriority, userQueriers map[string]struct{}, allSortedQueriers []string) map[string]int64 {
+	if len(priorities) == 0 {
+		return nil
+	}
+
+	candidates := allSortedQueriers
+	if userQueriers != nil {
+		candidates = make([]string, 0, len(userQueriers))
+		for querierID := range userQueriers {
+			candidates = append(candidates, querierID)
+		}
+		sort.Strings(candidates)
+	}
+
+	sorted := make([]validation.QueryPriority, len(priorities))
+	copy(sorted, priorities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+
+	var (
+		reserved map[string]int64
+		next     int
+	)
+
+	for _, p := range sorted {
+		for n := p.ReservedQueriersCount(len(candidates)); n > 0 && next < len(candidates)-1; n-- {
+			if reserved == nil {
+				reserved = map[string]int64{}
+			}
+
+			reserved[candidates[next]] = p.Priority
+			next++
+		}
+	}
+
+	return reserved
+}
+
+// userRequests holds the pending requests of a user, ordered by priority and then by arrival.
+// It implements heap.Interface.
+type userRequests struct {
+	items []queuedRequest
+
+	// Sequence number of the next enqueued request, used to keep the FIFO order within a priority.
+	nextSeq uint64
+}
+
+type queuedRequest struct {
+	request  Request
+	priority int64
+	seq      uint64
+}
+
+func (r *userRequests) enqueue(req Request) {
+	heap.Push(r, queuedRequest{request: req, priority: requestPriority(req), seq: r.nextSeq})
+	r.nextSeq++
+}
+
+func (r *userRequests) dequeue() (Request, int64) {
+	item := heap.Pop(r).(queuedRequest)
+	return item.request, item.priority
+}
+
+// highestPriority returns the priority of the next request to dequeue. Must not be called on an empty queue.
+func (r *userRequests) highestPriority() int64 {
+	return r.items[0].priority
+}
+
+func (r *userRequests) Len() int {
+	return len(r.items)
+}
+
+func (r *userRequests) Less(i, j int) bool {
+	if r.items[i].priority != r.items[j].priority {
+		return r.items[i].priority > r.items[j].priority
+	}
+	return r.items[i].seq < r.items[j].seq
+}
+
+func (r *userRequests) Swap(i, j int) {
+	r.items[i], r.items[j] = r.items[j], r.items[i]
+}
+
+func (r *userRequests) Push(x interface{}) {
+	r.items = append(r.items, x.(queuedRequest))
+}
+
+func (r *userRequests) Pop() interface{} {
+	last := len(r.items) - 1
+	item := r.items[last]
+	r.items[last] = queuedRequest{}
+	r.items = r.items[:last]
+	return item
+}
@@ -0,0 +1,223 @@
+package queue
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+type priorityRequest struct {
+	id       string
+	priority int64
+}
+
+func (r priorityRequest) Priority() int64 {
+	return r.priority
+}
+
+type mockLimits struct {
+	priorities []validation.QueryPriority
+}
+
+func (m mockLimits) QueryPriorities(_ string) []validation.QueryPriority {
+	return m.priorities
+}
+
+func mockQueryPriority(t *testing.T, priority int64, reservedQueriers float64, attrs ...validation.QueryAttribute) validation.QueryPriority {
+	p := validation.QueryPriority{Priority: priority, ReservedQueriers: reservedQueriers, QueryAttributes: attrs}
+	require.NoError(t, p.Validate())
+	return p
+}
+
+func TestGetPriority(t *testing.T) {
+	now := time.Now()
+
+	priorities := []validation.QueryPriority{
+		mockQueryPriority(t, 1, 0, validation.QueryAttribute{TimeWindow: validation.QueryTimeWindow{Start: model.Duration(time.Hour)}}),
+		mockQueryPriority(t, 2, 0, validation.QueryAttribute{Regex: ".*ALERTS.*"}),
+		mockQueryPriority(t, 3, 0, validation.QueryAttribute{Header: "X-Dashboard", HeaderRegex: "critical-.*"}),
+	}
+
+	formatTime := func(t time.Time) string {
+		return t.Format(time.RFC3339Nano)
+	}
+
+	tests := map[string]struct {
+		method   string
+		params   url.Values
+		headers  []*httpgrpc.Header
+		expected int64
+	}{
+		"should return 0 if no priority matches": {
+			method:   http.MethodGet,
+			params:   url.Values{"query": {"up"}, "start": {formatTime(now.Add(-24 * time.Hour))}, "end": {formatTime(now)}},
+			expected: 0,
+		},
+		"should match the time window of a range query": {
+			method:   http.MethodGet,
+			params:   url.Values{"query": {"up"}, "start": {formatTime(now.Add(-30 * time.Minute))}, "end": {formatTime(now)}},
+			expected: 1,
+		},
+		"should match the time of an instant query": {
+			method:   http.MethodGet,
+			params:   url.Values{"query": {"up"}, "time": {formatTime(now.Add(-30 * time.Minute))}},
+			expected: 1,
+		},
+		"should match the query regex of a POST request": {
+			method:   http.MethodPost,
+			params:   url.Values{"query": {"count(ALERTS)"}, "start": {formatTime(now.Add(-24 * time.Hour))}, "end": {formatTime(now)}},
+			expected: 2,
+		},
+		"should return the highest matching priority": {
+			method:   http.MethodGet,
+			params:   url.Values{"query": {"count(ALERTS)"}, "start": {formatTime(now.Add(-24 * time.Hour))}, "end": {formatTime(now)}},
+			headers:  []*httpgrpc.Header{{Key: "X-Dashboard", Values: []string{"critical-slo"}}},
+			expected: 3,
+		},
+		"should not match a header value not matching the regex": {
+			method:   http.MethodGet,
+			params:   url.Values{"query": {"up"}, "start": {formatTime(now.Add(-24 * time.Hour))}, "end": {formatTime(now)}},
+			headers:  []*httpgrpc.Header{{Key: "X-Dashboard", Values: []string{"ad-hoc"}}},
+			expected: 0,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := &httpgrpc.HTTPRequest{Method: testData.method, Url: "/prometheus/api/v1/query_range", Headers: testData.headers}
+			if testData.method == http.MethodPost {
+				req.Body = []byte(testData.params.Encode())
+				req.Headers = append(req.Headers, &httpgrpc.Header{Key: "Content-Type", Values: []string{"application/x-www-form-urlencoded"}})
+			} else {
+				req.Url += "?" + testData.params.Encode()
+			}
+
+			assert.Equal(t, testData.expected, GetPriority(req, priorities, now))
+		})
+	}
+}
+
+func TestReservedQueriersForUser(t *testing.T) {
+	attr := validation.QueryAttribute{Regex: "up"}
+	queriers := []string{"querier-1", "querier-2", "querier-3", "querier-4"}
+
+	tests := map[string]struct {
+		priorities   []validation.QueryPriority
+		userQueriers map[string]struct{}
+		expected     map[string]int64
+	}{
+		"no priorities": {
+			expected: nil,
+		},
+		"no reserved queriers": {
+			priorities: []validation.QueryPriority{mockQueryPriority(t, 1, 0, attr)},
+			expected:   nil,
+		},
+		"reserved queriers as a count": {
+			priorities: []validation.QueryPriority{mockQueryPriority(t, 1, 2, attr)},
+			expected:   map[string]int64{"querier-1": 1, "querier-2": 1},
+		},
+		"reserved queriers as a fraction": {
+			priorities: []validation.QueryPriority{mockQueryPriority(t, 1, 0.25, attr)},
+			expected:   map[string]int64{"querier-1": 1},
+		},
+		"queriers are reserved starting from the highest priority": {
+			priorities: []validation.QueryPriority{mockQueryPriority(t, 1, 1, attr), mockQueryPriority(t, 2, 1, attr)},
+			expected:   map[string]int64{"querier-1": 2, "querier-2": 1},
+		},
+		"at least one querier is never reserved": {
+			priorities: []validation.QueryPriority{mockQueryPriority(t, 1, 10, attr)},
+			expected:   map[string]int64{"querier-1": 1, "querier-2": 1, "querier-3": 1},
+		},
+		"queriers are reserved among the user's queriers": {
+			priorities:   []validation.QueryPriority{mockQueryPriority(t, 1, 1, attr)},
+			userQueriers: map[string]struct{}{"querier-4": {}, "querier-3": {}},
+			expected:     map[string]int64{"querier-3": 1},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testData.expected, reservedQueriersForUser(testData.priorities, testData.userQueriers, queriers))
+		})
+	}
+}
+
+func TestRequestQueue_GetNextRequestForQuerier_ShouldDequeueHigherPriorityFirst(t *testing.T) {
+	queueLengthPerPriority := prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user", "priority"})
+	queue := NewRequestQueue(10, 0,
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
+		queueLengthPerPriority,
+		nil)
+
+	ctx := context.Background()
+	require.NoError(t, services.StartAndAwaitRunning(ctx, queue))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(ctx, queue))
+	})
+
+	queue.RegisterQuerierConnection("querier-1")
+
+	for _, req := range []priorityRequest{{"low-1", 0}, {"high-1", 2}, {"medium-1", 1}, {"low-2", 0}, {"high-2", 2}} {
+		require.NoError(t, queue.EnqueueRequest("user-1", req, 0, nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(queueLengthPerPriority.WithLabelValues("user-1", "0")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(queueLengthPerPriority.WithLabelValues("user-1", "1")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(queueLengthPerPriority.WithLabelValues("user-1", "2")))
+
+	var actual []string
+	lastUserIndex := FirstUser()
+	for i := 0; i < 5; i++ {
+		var (
+			req Request
+			err error
+		)
+		req, lastUserIndex, err = queue.GetNextRequestForQuerier(ctx, lastUserIndex, "querier-1")
+		require.NoError(t, err)
+		actual = append(actual, req.(priorityRequest).id)
+	}
+
+	assert.Equal(t, []string{"high-1", "high-2", "medium-1", "low-1", "low-2"}, actual)
+	assert.Equal(t, float64(0), testutil.ToFloat64(queueLengthPerPriority.WithLabelValues("user-1", "0")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(queueLengthPerPriority.WithLabelValues("user-1", "2")))
+}
+
+func TestQueues_ReservedQuerierShouldOnlyHandleHighPriorityRequests(t *testing.T) {
+	limits := mockLimits{priorities: []validation.QueryPriority{
+		mockQueryPriority(t, 1, 1, validation.QueryAttribute{Regex: "up"}),
+	}}
+
+	uq := newUserQueues(10, 0, limits)
+	uq.addQuerierConnection("querier-1")
+	uq.addQuerierConnection("querier-2")
+
+	q := getOrAdd(t, uq, "user-1", 0)
+	require.Equal(t, map[string]int64{"querier-1": 1}, q.reservedQueriers)
+
+	// The reserved querier doesn't get the user's low priority requests.
+	q.requests.enqueue(priorityRequest{id: "low", priority: 0})
+	confirmOrderForQuerier(t, uq, "querier-1", -1, nil)
+	confirmOrderForQuerier(t, uq, "querier-2", -1, q)
+
+	// The reserved querier gets the user's queue once a high priority request is enqueued.
+	q.requests.enqueue(priorityRequest{id: "high", priority: 1})
+	confirmOrderForQuerier(t, uq, "querier-1", -1, q)
+
+	// Adding a querier recomputes the reserved queriers.
+	uq.addQuerierConnection("querier-0")
+	assert.Equal(t, map[string]int64{"querier-0": 1}, q.reservedQueriers)
+}
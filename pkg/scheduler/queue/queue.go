@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"strconv"
 	"sync"
 	"time"
 
@@ -9,6 +10,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 const (
@@ -43,6 +46,13 @@ func FirstUser() UserIndex {
 // Request stored into the queue.
 type Request interface{}
 
+// Limits needed by the request queue.
+type Limits interface {
+	// QueryPriorities returns the query priorities of the user, used to reserve
+	// queriers to the user's high priority requests.
+	QueryPriorities(user string) []validation.QueryPriority
+}
+
 // RequestQueue holds incoming requests in per-user queues. It also assigns each user specified number of queriers,
 // and when querier asks for next request to handle (using GetNextRequestForQuerier), it returns requests
 // in a fair fashion.
@@ -56,15 +66,19 @@ type RequestQueue struct {
 	queues  *queues
 	stopped bool
 
-	queueLength       *prometheus.GaugeVec   // Per user and reason.
-	discardedRequests *prometheus.CounterVec // Per user.
+	queueLength            *prometheus.GaugeVec   // Per user and reason.
+	queueLengthPerPriority *prometheus.GaugeVec   // Per user and priority.
+	discardedRequests      *prometheus.CounterVec // Per user.
 }
 
-func NewRequestQueue(maxOutstandingPerTenant int, forgetDelay time.Duration, queueLength *prometheus.GaugeVec, discardedRequests *prometheus.CounterVec) *RequestQueue {
+// NewRequestQueue makes a new RequestQueue. Limits may be nil, in which case no querier is reserved
+// to the high priority requests, which are still dequeued first.
+func NewRequestQueue(maxOutstandingPerTenant int, forgetDelay time.Duration, queueLength *prometheus.GaugeVec, discardedRequests *prometheus.CounterVec, queueLengthPerPriority *prometheus.GaugeVec, limits Limits) *RequestQueue {
 	q := &RequestQueue{
-		queues:                  newUserQueues(maxOutstandingPerTenant, forgetDelay),
+		queues:                  newUserQueues(maxOutstandingPerTenant, forgetDelay, limits),
 		connectedQuerierWorkers: atomic.NewInt32(0),
 		queueLength:             queueLength,
+		queueLengthPerPriority:  queueLengthPerPriority,
 		discardedRequests:       discardedRequests,
 	}
 
@@ -93,19 +107,24 @@ func (q *RequestQueue) EnqueueRequest(userID string, req Request, maxQueriers in
 		return errors.New("no queue found")
 	}
 
-	select {
-	case queue <- req:
-		q.queueLength.WithLabelValues(userID).Inc()
-		q.cond.Broadcast()
-		// Call this function while holding a lock. This guarantees that no querier can fetch the request before function returns.
-		if successFn != nil {
-			successFn()
+	if queue.requests.Len() >= q.queues.maxUserQueueSize {
+		if queue.requests.Len() == 0 {
+			q.queues.deleteQueue(userID)
 		}
-		return nil
-	default:
+
 		q.discardedRequests.WithLabelValues(userID).Inc()
 		return ErrTooManyRequests
 	}
+
+	queue.requests.enqueue(req)
+	q.queueLength.WithLabelValues(userID).Inc()
+	q.queueLengthPerPriority.WithLabelValues(userID, strconv.FormatInt(requestPriority(req), 10)).Inc()
+	q.cond.Broadcast()
+	// Call this function while holding a lock. This guarantees that no querier can fetch the request before function returns.
+	if successFn != nil {
+		successFn()
+	}
+	return nil
 }
 
 // GetNextRequestForQuerier find next user queue and takes the next request off of it. Will block if there are no requests.
@@ -141,12 +160,13 @@ FindQueue:
 
 		// Pick next request from the queue.
 		for {
-			request := <-queue
-			if len(queue) == 0 {
+			request, priority := queue.requests.dequeue()
+			if queue.requests.Len() == 0 {
 				q.queues.deleteQueue(userID)
 			}
 
 			q.queueLength.WithLabelValues(userID).Dec()
+			q.queueLengthPerPriority.WithLabelValues(userID, strconv.FormatInt(priority, 10)).Dec()
 
 			// Tell close() we've processed a request.
 			q.cond.Broadcast()
@@ -25,6 +25,8 @@ func BenchmarkGetNextRequest(b *testing.B) {
 		queue := NewRequestQueue(maxOutstandingPerTenant, 0,
 			prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
 			prometheus.NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
+			prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user", "priority"}),
+			nil,
 		)
 		queues = append(queues, queue)
 
@@ -82,6 +84,8 @@ func BenchmarkQueueRequest(b *testing.B) {
 		q := NewRequestQueue(maxOutstandingPerTenant, 0,
 			prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
 			prometheus.NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
+			prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user", "priority"}),
+			nil,
 		)
 
 		for ix := 0; ix < queriers; ix++ {
@@ -114,7 +118,9 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldGetRequestAfterReshardingBe
 
 	queue := NewRequestQueue(1, forgetDelay,
 		prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
-		prometheus.NewCounterVec(prometheus.CounterOpts{}, []string{"user"}))
+		prometheus.NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{}, []string{"user", "priority"}),
+		nil)
 
 	// Start the queue service.
 	ctx := context.Background()
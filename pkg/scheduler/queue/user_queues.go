@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 // querier holds information about a querier registered in the queue.
@@ -41,10 +42,13 @@ type queues struct {
 
 	// Sorted list of querier names, used when creating per-user shard.
 	sortedQueriers []string
+
+	// Provides the users' query priorities. May be nil.
+	limits Limits
 }
 
 type userQueue struct {
-	ch chan Request
+	requests userRequests
 
 	// If not nil, only these queriers can handle user requests. If nil, all queriers can.
 	// We set this to nil if number of available queriers <= maxQueriers.
@@ -57,9 +61,14 @@ type userQueue struct {
 
 	// Points back to 'users' field in queues. Enables quick cleanup.
 	index int
+
+	// User's query priorities, and the queriers reserved to the high priority requests
+	// with the min priority of the requests each reserved querier can handle.
+	priorities       []validation.QueryPriority
+	reservedQueriers map[string]int64
 }
 
-func newUserQueues(maxUserQueueSize int, forgetDelay time.Duration) *queues {
+func newUserQueues(maxUserQueueSize int, forgetDelay time.Duration, limits Limits) *queues {
 	return &queues{
 		userQueues:       map[string]*userQueue{},
 		users:            nil,
@@ -67,6 +76,7 @@ func newUserQueues(maxUserQueueSize int, forgetDelay time.Duration) *queues {
 		forgetDelay:      forgetDelay,
 		queriers:         map[string]*querier{},
 		sortedQueriers:   nil,
+		limits:           limits,
 	}
 }
 
@@ -93,7 +103,7 @@ func (q *queues) deleteQueue(userID string) {
 // MaxQueriers is used to compute which queriers should handle requests for this user.
 // If maxQueriers is <= 0, all queriers can handle this user's requests.
 // If maxQueriers has changed since the last call, queriers for this are recomputed.
-func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan Request {
+func (q *queues) getOrAddQueue(userID string, maxQueriers int) *userQueue {
 	// Empty user is not allowed, as that would break our users list ("" is used for free spot).
 	if userID == "" {
 		return nil
@@ -107,10 +117,13 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan Request {
 
 	if uq == nil {
 		uq = &userQueue{
-			ch:    make(chan Request, q.maxUserQueueSize),
 			seed:  util.ShuffleShardSeed(userID, ""),
 			index: -1,
 		}
+		if q.limits != nil {
+			uq.priorities = q.limits.QueryPriorities(userID)
+			uq.reservedQueriers = reservedQueriersForUser(uq.priorities, nil, q.sortedQueriers)
+		}
 		q.userQueues[userID] = uq
 
 		// Add user to the list of users... find first free spot, and put it there.
@@ -132,15 +145,16 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan Request {
 	if uq.maxQueriers != maxQueriers {
 		uq.maxQueriers = maxQueriers
 		uq.queriers = shuffleQueriersForUser(uq.seed, maxQueriers, q.sortedQueriers, nil)
+		uq.reservedQueriers = reservedQueriersForUser(uq.priorities, uq.queriers, q.sortedQueriers)
 	}
 
-	return uq.ch
+	return uq
 }
 
 // Finds next queue for the querier. To support fair scheduling between users, client is expected
 // to pass last user index returned by this function as argument. Is there was no previous
 // last user index, use -1.
-func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (chan Request, string, int) {
+func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (*userQueue, string, int) {
 	uid := lastUserIndex
 
 	for iters := 0; iters < len(q.users); iters++ {
@@ -166,7 +180,12 @@ func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (ch
 			}
 		}
 
-		return q.ch, u, uid
+		if minPriority, ok := q.reservedQueriers[querierID]; ok && q.requests.Len() > 0 && q.requests.highestPriority() < minPriority {
+			// This querier is reserved to the user's requests with higher priority.
+			continue
+		}
+
+		return q, u, uid
 	}
 	return nil, "", uid
 }
@@ -275,6 +294,7 @@ func (q *queues) recomputeUserQueriers() {
 
 	for _, uq := range q.userQueues {
 		uq.queriers = shuffleQueriersForUser(uq.seed, uq.maxQueriers, q.sortedQueriers, scratchpad)
+		uq.reservedQueriers = reservedQueriersForUser(uq.priorities, uq.queriers, q.sortedQueriers)
 	}
 }
 
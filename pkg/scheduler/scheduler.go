@@ -59,6 +59,7 @@ type Scheduler struct {
 
 	// Metrics.
 	queueLength              *prometheus.GaugeVec
+	queueLengthPerPriority   *prometheus.GaugeVec
 	discardedRequests        *prometheus.CounterVec
 	connectedQuerierClients  prometheus.GaugeFunc
 	connectedFrontendClients prometheus.GaugeFunc
@@ -107,11 +108,16 @@ func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer promethe
 		Help: "Number of queries in the queue.",
 	}, []string{"user"})
 
+	s.queueLengthPerPriority = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cortex_query_scheduler_queue_length_per_priority",
+		Help: "Number of queries in the queue, by priority.",
+	}, []string{"user", "priority"})
+
 	s.discardedRequests = promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
 		Name: "cortex_query_scheduler_discarded_requests_total",
 		Help: "Total number of query requests discarded.",
 	}, []string{"user"})
-	s.requestQueue = queue.NewRequestQueue(cfg.MaxOutstandingPerTenant, cfg.QuerierForgetDelay, s.queueLength, s.discardedRequests)
+	s.requestQueue = queue.NewRequestQueue(cfg.MaxOutstandingPerTenant, cfg.QuerierForgetDelay, s.queueLength, s.discardedRequests, s.queueLengthPerPriority, limits)
 
 	s.queueDuration = promauto.With(registerer).NewHistogram(prometheus.HistogramOpts{
 		Name:    "cortex_query_scheduler_queue_duration_seconds",
@@ -143,6 +149,9 @@ func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer promethe
 type Limits interface {
 	// MaxQueriersPerUser returns max queriers to use per tenant, or 0 if shuffle sharding is disabled.
 	MaxQueriersPerUser(user string) int
+
+	// QueryPriorities returns the query priorities of the tenant.
+	QueryPriorities(user string) []validation.QueryPriority
 }
 
 type schedulerRequest struct {
@@ -151,8 +160,10 @@ type schedulerRequest struct {
 	queryID         uint64
 	request         *httpgrpc.HTTPRequest
 	statsEnabled    bool
+	priority        int64
 
 	enqueueTime time.Time
+	queueTime   time.Duration
 
 	ctx       context.Context
 	ctxCancel context.CancelFunc
@@ -162,6 +173,11 @@ type schedulerRequest struct {
 	parentSpanContext opentracing.SpanContext
 }
 
+// Priority implements queue.PriorityRequest.
+func (s *schedulerRequest) Priority() int64 {
+	return s.priority
+}
+
 // FrontendLoop handles connection from frontend.
 func (s *Scheduler) FrontendLoop(frontend schedulerpb.SchedulerForFrontend_FrontendLoopServer) error {
 	frontendAddress, frontendCtx, err := s.frontendConnected(frontend)
@@ -306,6 +322,8 @@ func (s *Scheduler) enqueueRequest(frontendContext context.Context, frontendAddr
 	}
 	maxQueriers := validation.SmallestPositiveNonZeroIntPerTenant(tenantIDs, s.limits.MaxQueriersPerUser)
 
+	req.priority = queue.GetPriority(req.request, s.limits.QueryPriorities(userID), now)
+
 	s.activeUsers.UpdateUserTimestamp(userID, now)
 	return s.requestQueue.EnqueueRequest(userID, req, maxQueriers, func() {
 		shouldCancel = false
@@ -361,7 +379,8 @@ func (s *Scheduler) QuerierLoop(querier schedulerpb.SchedulerForQuerier_QuerierL
 
 		r := req.(*schedulerRequest)
 
-		s.queueDuration.Observe(time.Since(r.enqueueTime).Seconds())
+		r.queueTime = time.Since(r.enqueueTime)
+		s.queueDuration.Observe(r.queueTime.Seconds())
 		r.queueSpan.Finish()
 
 		/*
@@ -407,13 +426,18 @@ func (s *Scheduler) forwardRequestToQuerier(querier schedulerpb.SchedulerForQuer
 	// monitoring the contexts in a select and cancel things appropriately.
 	errCh := make(chan error, 1)
 	go func() {
-		err := querier.Send(&schedulerpb.SchedulerToQuerier{
+		msg := &schedulerpb.SchedulerToQuerier{
 			UserID:          req.userID,
 			QueryID:         req.queryID,
 			FrontendAddress: req.frontendAddress,
 			HttpRequest:     req.request,
 			StatsEnabled:    req.statsEnabled,
-		})
+		}
+		if req.statsEnabled {
+			msg.QueueTime = req.queueTime
+		}
+
+		err := querier.Send(msg)
 		if err != nil {
 			errCh <- err
 			return
@@ -513,6 +537,9 @@ func (s *Scheduler) stopping(_ error) error {
 func (s *Scheduler) cleanupMetricsForInactiveUser(user string) {
 	s.queueLength.DeleteLabelValues(user)
 	s.discardedRequests.DeleteLabelValues(user)
+	if err := util.DeleteMatchingLabels(s.queueLengthPerPriority, map[string]string{"user": user}); err != nil {
+		level.Warn(s.log).Log("msg", "failed to remove cortex_query_scheduler_queue_length_per_priority metric for user", "user", user, "err", err)
+	}
 }
 
 func (s *Scheduler) getConnectedFrontendClientsMetric() float64 {
@@ -8,6 +8,8 @@ import (
 	fmt "fmt"
 	_ "github.com/gogo/protobuf/gogoproto"
 	proto "github.com/gogo/protobuf/proto"
+	github_com_gogo_protobuf_types "github.com/gogo/protobuf/types"
+	_ "github.com/golang/protobuf/ptypes/duration"
 	httpgrpc "github.com/weaveworks/common/httpgrpc"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
@@ -18,12 +20,14 @@ import (
 	reflect "reflect"
 	strconv "strconv"
 	strings "strings"
+	time "time"
 )
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
 var _ = fmt.Errorf
 var _ = math.Inf
+var _ = time.Kitchen
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the proto package it is being compiled against.
@@ -139,6 +143,8 @@ type SchedulerToQuerier struct {
 	// Whether query statistics tracking should be enabled. The response will include
 	// statistics only when this option is enabled.
 	StatsEnabled bool `protobuf:"varint,5,opt,name=statsEnabled,proto3" json:"statsEnabled,omitempty"`
+	// Time spent by the request in the scheduler queue. Only set when statsEnabled is true.
+	QueueTime time.Duration `protobuf:"bytes,6,opt,name=queueTime,proto3,stdduration" json:"queueTime"`
 }
 
 func (m *SchedulerToQuerier) Reset()      { *m = SchedulerToQuerier{} }
@@ -208,6 +214,13 @@ func (m *SchedulerToQuerier) GetStatsEnabled() bool {
 	return false
 }
 
+func (m *SchedulerToQuerier) GetQueueTime() time.Duration {
+	if m != nil {
+		return m.QueueTime
+	}
+	return 0
+}
+
 type FrontendToScheduler struct {
 	Type FrontendToSchedulerType `protobuf:"varint,1,opt,name=type,proto3,enum=schedulerpb.FrontendToSchedulerType" json:"type,omitempty"`
 	// Used by INIT message. Will be put into all requests passed to querier.
@@ -438,48 +451,51 @@ func init() {
 func init() { proto.RegisterFile("scheduler.proto", fileDescriptor_2b3fc28395a6d9c5) }
 
 var fileDescriptor_2b3fc28395a6d9c5 = []byte{
-	// 650 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0xcf, 0x4f, 0x13, 0x41,
-	0x14, 0xde, 0x29, 0x6d, 0x81, 0x57, 0x94, 0x75, 0x00, 0xad, 0x0d, 0x0e, 0x4d, 0x63, 0x4c, 0x25,
-	0xb1, 0x35, 0xd5, 0x44, 0x0f, 0xc4, 0xa4, 0xc2, 0x22, 0x8d, 0xb8, 0x85, 0xe9, 0x34, 0xfe, 0xb8,
-	0x34, 0xb4, 0x1d, 0x5a, 0x02, 0xec, 0x2c, 0xb3, 0xbb, 0x92, 0xde, 0x3c, 0x7a, 0xf4, 0xcf, 0xf0,
-	0x4f, 0xf1, 0x62, 0xc2, 0x91, 0x83, 0x07, 0x59, 0x2e, 0x1e, 0xf9, 0x13, 0x0c, 0xd3, 0x6d, 0xdd,
-	0xd6, 0x16, 0xb8, 0xbd, 0xf7, 0xf6, 0xfb, 0x76, 0xde, 0xf7, 0xbd, 0x37, 0x03, 0xb3, 0x4e, 0xa3,
-	0xcd, 0x9b, 0xde, 0x01, 0x97, 0x39, 0x5b, 0x0a, 0x57, 0xe0, 0x44, 0xbf, 0x60, 0xd7, 0x53, 0x4f,
-	0x5a, 0x7b, 0x6e, 0xdb, 0xab, 0xe7, 0x1a, 0xe2, 0x30, 0xdf, 0x12, 0x2d, 0x91, 0x57, 0x98, 0xba,
-	0xb7, 0xab, 0x32, 0x95, 0xa8, 0xa8, 0xcb, 0x4d, 0x3d, 0x0f, 0xc1, 0x8f, 0xf9, 0xce, 0x67, 0x7e,
-	0x2c, 0xe4, 0xbe, 0x93, 0x6f, 0x88, 0xc3, 0x43, 0x61, 0xe5, 0xdb, 0xae, 0x6b, 0xb7, 0xa4, 0xdd,
-	0xe8, 0x07, 0x5d, 0x56, 0xa6, 0x00, 0x78, 0xdb, 0xe3, 0x72, 0x8f, 0x4b, 0x26, 0x2a, 0xbd, 0xc3,
-	0xf1, 0x22, 0x4c, 0x1f, 0x75, 0xab, 0xa5, 0xb5, 0x24, 0x4a, 0xa3, 0xec, 0x34, 0xfd, 0x57, 0xc8,
-	0xfc, 0x44, 0x80, 0xfb, 0x58, 0x26, 0x02, 0x3e, 0x4e, 0xc2, 0xe4, 0x25, 0xa6, 0x13, 0x50, 0xa2,
-	0xb4, 0x97, 0xe2, 0x17, 0x90, 0xb8, 0x3c, 0x96, 0xf2, 0x23, 0x8f, 0x3b, 0x6e, 0x32, 0x92, 0x46,
-	0xd9, 0x44, 0x61, 0x21, 0xd7, 0x6f, 0x65, 0x83, 0xb1, 0xad, 0xe0, 0x23, 0x0d, 0x23, 0x71, 0x16,
-	0x66, 0x77, 0xa5, 0xb0, 0x5c, 0x6e, 0x35, 0x8b, 0xcd, 0xa6, 0xe4, 0x8e, 0x93, 0x9c, 0x50, 0xdd,
-	0x0c, 0x97, 0xf1, 0x5d, 0x88, 0x7b, 0x8e, 0x6a, 0x37, 0xaa, 0x00, 0x41, 0x86, 0x33, 0x30, 0xe3,
-	0xb8, 0x3b, 0xae, 0x63, 0x58, 0x3b, 0xf5, 0x03, 0xde, 0x4c, 0xc6, 0xd2, 0x28, 0x3b, 0x45, 0x07,
-	0x6a, 0x99, 0xaf, 0x11, 0x98, 0x5b, 0x0f, 0xfe, 0x17, 0x76, 0xe1, 0x25, 0x44, 0xdd, 0x8e, 0xcd,
-	0x95, 0x9a, 0xdb, 0x85, 0x87, 0xb9, 0xd0, 0x70, 0x72, 0x23, 0xf0, 0xac, 0x63, 0x73, 0xaa, 0x18,
-	0xa3, 0xfa, 0x8e, 0x8c, 0xee, 0x3b, 0x64, 0xda, 0xc4, 0xa0, 0x69, 0xe3, 0x14, 0x0d, 0x99, 0x19,
-	0xbb, 0xb1, 0x99, 0xc3, 0x56, 0xc4, 0x47, 0x58, 0xb1, 0x0f, 0x73, 0xa1, 0xc9, 0xf6, 0x44, 0xe2,
-	0x57, 0x10, 0xbf, 0x84, 0x79, 0x4e, 0xe0, 0xc5, 0xa3, 0x01, 0x2f, 0x46, 0x30, 0x2a, 0x0a, 0x4d,
-	0x03, 0x16, 0x9e, 0x87, 0x18, 0x97, 0x52, 0xc8, 0xc0, 0x85, 0x6e, 0x92, 0x59, 0x81, 0x45, 0x53,
-	0xb8, 0x7b, 0xbb, 0x9d, 0x60, 0x83, 0x2a, 0x6d, 0xcf, 0x6d, 0x8a, 0x63, 0xab, 0xd7, 0xf0, 0xd5,
-	0x5b, 0xb8, 0x04, 0x0f, 0xc6, 0xb0, 0x1d, 0x5b, 0x58, 0x0e, 0x5f, 0x5e, 0x81, 0x7b, 0x63, 0xa6,
-	0x84, 0xa7, 0x20, 0x5a, 0x32, 0x4b, 0x4c, 0xd7, 0x70, 0x02, 0x26, 0x0d, 0x73, 0xbb, 0x6a, 0x54,
-	0x0d, 0x1d, 0x61, 0x80, 0xf8, 0x6a, 0xd1, 0x5c, 0x35, 0x36, 0xf5, 0xc8, 0x72, 0x03, 0xee, 0x8f,
-	0xd5, 0x85, 0xe3, 0x10, 0x29, 0xbf, 0xd5, 0x35, 0x9c, 0x86, 0x45, 0x56, 0x2e, 0xd7, 0xde, 0x15,
-	0xcd, 0x8f, 0x35, 0x6a, 0x6c, 0x57, 0x8d, 0x0a, 0xab, 0xd4, 0xb6, 0x0c, 0x5a, 0x63, 0x86, 0x59,
-	0x34, 0x99, 0x8e, 0xf0, 0x34, 0xc4, 0x0c, 0x4a, 0xcb, 0x54, 0x8f, 0xe0, 0x3b, 0x70, 0xab, 0xb2,
-	0x51, 0x65, 0xac, 0x64, 0xbe, 0xa9, 0xad, 0x95, 0xdf, 0x9b, 0xfa, 0x44, 0xe1, 0x17, 0x0a, 0xf9,
-	0xbd, 0x2e, 0x64, 0xef, 0x2a, 0x55, 0x21, 0x11, 0x84, 0x9b, 0x42, 0xd8, 0x78, 0x69, 0xc0, 0xee,
-	0xff, 0xef, 0x6b, 0x6a, 0x69, 0xdc, 0x3c, 0x02, 0x6c, 0x46, 0xcb, 0xa2, 0xa7, 0x08, 0x5b, 0xb0,
-	0x30, 0xd2, 0x32, 0xfc, 0x78, 0x80, 0x7f, 0xd5, 0x50, 0x52, 0xcb, 0x37, 0x81, 0x76, 0x27, 0x50,
-	0xb0, 0x61, 0x3e, 0xac, 0xae, 0xbf, 0x4e, 0x1f, 0x60, 0xa6, 0x17, 0x2b, 0x7d, 0xe9, 0xeb, 0xae,
-	0x56, 0x2a, 0x7d, 0xdd, 0xc2, 0x75, 0x15, 0xbe, 0x2e, 0x9e, 0x9c, 0x11, 0xed, 0xf4, 0x8c, 0x68,
-	0x17, 0x67, 0x04, 0x7d, 0xf1, 0x09, 0xfa, 0xee, 0x13, 0xf4, 0xc3, 0x27, 0xe8, 0xc4, 0x27, 0xe8,
-	0xb7, 0x4f, 0xd0, 0x1f, 0x9f, 0x68, 0x17, 0x3e, 0x41, 0xdf, 0xce, 0x89, 0x76, 0x72, 0x4e, 0xb4,
-	0xd3, 0x73, 0xa2, 0x7d, 0x0a, 0x3f, 0xbb, 0xf5, 0xb8, 0x7a, 0x18, 0x9f, 0xfd, 0x0d, 0x00, 0x00,
-	0xff, 0xff, 0x49, 0x11, 0xf9, 0x34, 0x9d, 0x05, 0x00, 0x00,
+	// 701 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x94, 0x4d, 0x4f, 0xdb, 0x4c,
+	0x10, 0xc7, 0xbd, 0x21, 0x09, 0x30, 0xe1, 0x79, 0xc8, 0xb3, 0xc0, 0xd3, 0x10, 0xd1, 0x4d, 0x14,
+	0x55, 0x55, 0x8a, 0x54, 0xa7, 0x4a, 0x2b, 0xb5, 0x07, 0x54, 0x29, 0x80, 0x29, 0x51, 0xa9, 0x03,
+	0x8e, 0xa3, 0xbe, 0x5c, 0xa2, 0x24, 0x5e, 0x92, 0x08, 0xe2, 0x35, 0x7e, 0x29, 0xca, 0xad, 0xc7,
+	0x1e, 0x39, 0xf6, 0x23, 0xf4, 0xa3, 0x70, 0xe4, 0xc8, 0xa1, 0x6a, 0x8b, 0xb9, 0xf4, 0xc8, 0x47,
+	0xa8, 0x58, 0xdb, 0xc1, 0x81, 0x04, 0xb8, 0xed, 0x8c, 0xff, 0x7f, 0x6b, 0xe6, 0x37, 0xb3, 0x0b,
+	0xb3, 0x56, 0xab, 0x43, 0x35, 0x67, 0x9f, 0x9a, 0xa2, 0x61, 0x32, 0x9b, 0xe1, 0xc4, 0x20, 0x61,
+	0x34, 0xd3, 0x4f, 0xdb, 0x5d, 0xbb, 0xe3, 0x34, 0xc5, 0x16, 0xeb, 0x15, 0xda, 0xac, 0xcd, 0x0a,
+	0x5c, 0xd3, 0x74, 0x76, 0x79, 0xc4, 0x03, 0x7e, 0xf2, 0xbc, 0xe9, 0x17, 0x21, 0xf9, 0x21, 0x6d,
+	0x7c, 0xa6, 0x87, 0xcc, 0xdc, 0xb3, 0x0a, 0x2d, 0xd6, 0xeb, 0x31, 0xbd, 0xd0, 0xb1, 0x6d, 0xa3,
+	0x6d, 0x1a, 0xad, 0xc1, 0xc1, 0x77, 0x91, 0x36, 0x63, 0xed, 0x7d, 0x7a, 0xf5, 0x6f, 0xcd, 0x31,
+	0x1b, 0x76, 0x97, 0xe9, 0xde, 0xf7, 0x5c, 0x11, 0xf0, 0x8e, 0x43, 0xcd, 0x2e, 0x35, 0x55, 0x56,
+	0x0d, 0x8a, 0xc3, 0x4b, 0x30, 0x7d, 0xe0, 0x65, 0xcb, 0xeb, 0x29, 0x94, 0x45, 0xf9, 0x69, 0xe5,
+	0x2a, 0x91, 0x3b, 0x8a, 0x00, 0x1e, 0x68, 0x55, 0xe6, 0xfb, 0x71, 0x0a, 0x26, 0x2f, 0x35, 0x7d,
+	0xdf, 0x12, 0x55, 0x82, 0x10, 0xbf, 0x84, 0xc4, 0x65, 0x59, 0x0a, 0x3d, 0x70, 0xa8, 0x65, 0xa7,
+	0x22, 0x59, 0x94, 0x4f, 0x14, 0x17, 0xc4, 0x41, 0xa9, 0x9b, 0xaa, 0xba, 0xed, 0x7f, 0x54, 0xc2,
+	0x4a, 0x9c, 0x87, 0xd9, 0x5d, 0x93, 0xe9, 0x36, 0xd5, 0xb5, 0x92, 0xa6, 0x99, 0xd4, 0xb2, 0x52,
+	0x13, 0xbc, 0x9a, 0xeb, 0x69, 0xfc, 0x3f, 0xc4, 0x1d, 0x8b, 0x97, 0x1b, 0xe5, 0x02, 0x3f, 0xc2,
+	0x39, 0x98, 0xb1, 0xec, 0x86, 0x6d, 0x49, 0x7a, 0xa3, 0xb9, 0x4f, 0xb5, 0x54, 0x2c, 0x8b, 0xf2,
+	0x53, 0xca, 0x50, 0x0e, 0x97, 0x78, 0xb7, 0x0e, 0x55, 0xbb, 0x3d, 0x9a, 0x8a, 0xf3, 0xe2, 0x16,
+	0x45, 0x8f, 0x9b, 0x18, 0x70, 0x13, 0xd7, 0x7d, 0x6e, 0xab, 0x53, 0xc7, 0x3f, 0x33, 0xc2, 0xb7,
+	0x5f, 0x19, 0xa4, 0x5c, 0xb9, 0x72, 0x5f, 0x23, 0x30, 0xb7, 0xe1, 0x97, 0x14, 0x06, 0xf9, 0x0a,
+	0xa2, 0x76, 0xdf, 0xa0, 0x1c, 0xc8, 0xbf, 0xc5, 0x47, 0x62, 0x68, 0xfe, 0xe2, 0x08, 0xbd, 0xda,
+	0x37, 0xa8, 0xc2, 0x1d, 0xa3, 0x5a, 0x8f, 0x8c, 0x6e, 0x3d, 0xc4, 0x7d, 0x62, 0x98, 0xfb, 0x38,
+	0x28, 0xd7, 0xe6, 0x11, 0xbb, 0xf7, 0x3c, 0xae, 0xd3, 0x8c, 0xdf, 0xa4, 0x99, 0xdb, 0x83, 0xb9,
+	0xd0, 0x72, 0x04, 0x4d, 0xe2, 0xd7, 0x10, 0xbf, 0x94, 0x39, 0x96, 0xcf, 0xe2, 0xf1, 0x10, 0x8b,
+	0x11, 0x8e, 0x2a, 0x57, 0x2b, 0xbe, 0x0b, 0xcf, 0x43, 0x8c, 0x9a, 0x26, 0x33, 0x7d, 0x0a, 0x5e,
+	0x90, 0x5b, 0x81, 0x25, 0x99, 0xd9, 0xdd, 0xdd, 0xbe, 0xbf, 0x84, 0xd5, 0x8e, 0x63, 0x6b, 0xec,
+	0x50, 0x0f, 0x0a, 0xbe, 0x7d, 0x91, 0x33, 0xf0, 0x70, 0x8c, 0xdb, 0x32, 0x98, 0x6e, 0xd1, 0xe5,
+	0x15, 0x78, 0x30, 0x66, 0x4a, 0x78, 0x0a, 0xa2, 0x65, 0xb9, 0xac, 0x26, 0x05, 0x9c, 0x80, 0x49,
+	0x49, 0xde, 0xa9, 0x49, 0x35, 0x29, 0x89, 0x30, 0x40, 0x7c, 0xad, 0x24, 0xaf, 0x49, 0x5b, 0xc9,
+	0xc8, 0x72, 0x0b, 0x16, 0xc7, 0xf6, 0x85, 0xe3, 0x10, 0xa9, 0xbc, 0x4d, 0x0a, 0x38, 0x0b, 0x4b,
+	0x6a, 0xa5, 0x52, 0x7f, 0x57, 0x92, 0x3f, 0xd6, 0x15, 0x69, 0xa7, 0x26, 0x55, 0xd5, 0x6a, 0x7d,
+	0x5b, 0x52, 0xea, 0xaa, 0x24, 0x97, 0x64, 0x35, 0x89, 0xf0, 0x34, 0xc4, 0x24, 0x45, 0xa9, 0x28,
+	0xc9, 0x08, 0xfe, 0x0f, 0xfe, 0xa9, 0x6e, 0xd6, 0x54, 0xb5, 0x2c, 0xbf, 0xa9, 0xaf, 0x57, 0xde,
+	0xcb, 0xc9, 0x89, 0xe2, 0x0f, 0x14, 0xe2, 0xbd, 0xc1, 0xcc, 0xe0, 0x36, 0xd6, 0x20, 0xe1, 0x1f,
+	0xb7, 0x18, 0x33, 0x70, 0x66, 0x08, 0xf7, 0xcd, 0x2b, 0x9f, 0xce, 0x8c, 0x9b, 0x87, 0xaf, 0xcd,
+	0x09, 0x79, 0xf4, 0x0c, 0x61, 0x1d, 0x16, 0x46, 0x22, 0xc3, 0x4f, 0x86, 0xfc, 0xb7, 0x0d, 0x25,
+	0xbd, 0x7c, 0x1f, 0xa9, 0x37, 0x81, 0xa2, 0x01, 0xf3, 0xe1, 0xee, 0x06, 0xeb, 0xf4, 0x01, 0x66,
+	0x82, 0x33, 0xef, 0x2f, 0x7b, 0xd7, 0xd5, 0x4a, 0x67, 0xef, 0x5a, 0x38, 0xaf, 0xc3, 0xd5, 0xd2,
+	0xc9, 0x19, 0x11, 0x4e, 0xcf, 0x88, 0x70, 0x71, 0x46, 0xd0, 0x17, 0x97, 0xa0, 0xef, 0x2e, 0x41,
+	0xc7, 0x2e, 0x41, 0x27, 0x2e, 0x41, 0xbf, 0x5d, 0x82, 0xfe, 0xb8, 0x44, 0xb8, 0x70, 0x09, 0x3a,
+	0x3a, 0x27, 0xc2, 0xc9, 0x39, 0x11, 0x4e, 0xcf, 0x89, 0xf0, 0x29, 0xfc, 0xb2, 0x37, 0xe3, 0xfc,
+	0xd5, 0x78, 0xfe, 0x77, 0x00, 0xcd, 0x6d, 0x10, 0x3c, 0x00, 0x06, 0x00, 0x00,
 }
 
 func (x FrontendToSchedulerType) String() string {
@@ -554,6 +570,9 @@ func (this *SchedulerToQuerier) Equal(that interface{}) bool {
 	if this.StatsEnabled != that1.StatsEnabled {
 		return false
 	}
+	if this.QueueTime != that1.QueueTime {
+		return false
+	}
 	return true
 }
 func (this *FrontendToScheduler) Equal(that interface{}) bool {
@@ -681,7 +700,7 @@ func (this *SchedulerToQuerier) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 9)
+	s := make([]string, 0, 10)
 	s = append(s, "&schedulerpb.SchedulerToQuerier{")
 	s = append(s, "QueryID: "+fmt.Sprintf("%#v", this.QueryID)+",\n")
 	if this.HttpRequest != nil {
@@ -690,6 +709,7 @@ func (this *SchedulerToQuerier) GoString() string {
 	s = append(s, "FrontendAddress: "+fmt.Sprintf("%#v", this.FrontendAddress)+",\n")
 	s = append(s, "UserID: "+fmt.Sprintf("%#v", this.UserID)+",\n")
 	s = append(s, "StatsEnabled: "+fmt.Sprintf("%#v", this.StatsEnabled)+",\n")
+	s = append(s, "QueueTime: "+fmt.Sprintf("%#v", this.QueueTime)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -1078,6 +1098,14 @@ func (m *SchedulerToQuerier) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	n1, err1 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.QueueTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.QueueTime):])
+	if err1 != nil {
+		return 0, err1
+	}
+	i -= n1
+	i = encodeVarintScheduler(dAtA, i, uint64(n1))
+	i--
+	dAtA[i] = 0x32
 	if m.StatsEnabled {
 		i--
 		if m.StatsEnabled {
@@ -1327,6 +1355,8 @@ func (m *SchedulerToQuerier) Size() (n int) {
 	if m.StatsEnabled {
 		n += 2
 	}
+	l = github_com_gogo_protobuf_types.SizeOfStdDuration(m.QueueTime)
+	n += 1 + l + sovScheduler(uint64(l))
 	return n
 }
 
@@ -1424,6 +1454,7 @@ func (this *SchedulerToQuerier) String() string {
 		`FrontendAddress:` + fmt.Sprintf("%v", this.FrontendAddress) + `,`,
 		`UserID:` + fmt.Sprintf("%v", this.UserID) + `,`,
 		`StatsEnabled:` + fmt.Sprintf("%v", this.StatsEnabled) + `,`,
+		`QueueTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.QueueTime), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1734,6 +1765,39 @@ func (m *SchedulerToQuerier) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.StatsEnabled = bool(v != 0)
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field QueueTime", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowScheduler
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthScheduler
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthScheduler
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdDurationUnmarshal(&m.QueueTime, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipScheduler(dAtA[iNdEx:])
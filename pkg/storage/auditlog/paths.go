@@ -0,0 +1,20 @@
+package auditlog
+
+import (
+	"path"
+	"time"
+)
+
+const (
+	dayFormat  = "2006-01-02"
+	hourFormat = "15"
+
+	// FileExtension is the extension of the query audit log files.
+	FileExtension = ".jsonl"
+)
+
+// HourDir returns the directory of the query audit log files of the tenant recorded
+// in the input hour, in the <tenant>/<day>/<hour> format.
+func HourDir(tenant string, hour time.Time) string {
+	return path.Join(tenant, hour.Format(dayFormat), hour.Format(hourFormat))
+}
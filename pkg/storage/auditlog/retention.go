@@ -0,0 +1,97 @@
+package auditlog
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+)
+
+// DeleteExpiredRecords deletes the query audit log files of the hours which are entirely older
+// than the retention period, for the tenants owned by the caller. It returns the number of deleted files.
+func DeleteExpiredRecords(ctx context.Context, bkt objstore.Bucket, retention time.Duration, now time.Time, isOwned func(userID string) (bool, error), logger log.Logger) (int, error) {
+	var tenants []string
+	err := bkt.Iter(ctx, "", func(entry string) error {
+		tenants = append(tenants, strings.TrimSuffix(entry, objstore.DirDelim))
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list query audit log tenants")
+	}
+
+	var (
+		deadline = now.Add(-retention)
+		deleted  = 0
+	)
+
+	for _, tenant := range tenants {
+		if owned, err := isOwned(tenant); err != nil {
+			level.Warn(logger).Log("msg", "unable to check if user is owned by this shard", "user", tenant, "err", err)
+			continue
+		} else if !owned {
+			continue
+		}
+
+		n, err := deleteExpiredTenantRecords(ctx, bkt, tenant, deadline, logger)
+		deleted += n
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to delete expired query audit log records of user %s", tenant)
+		}
+	}
+
+	return deleted, nil
+}
+
+func deleteExpiredTenantRecords(ctx context.Context, bkt objstore.Bucket, tenant string, deadline time.Time, logger log.Logger) (int, error) {
+	var expired []string
+
+	err := bkt.Iter(ctx, tenant+objstore.DirDelim, func(dayDir string) error {
+		day, err := time.Parse(dayFormat, path.Base(dayDir))
+		if err != nil {
+			// Not a query audit log directory.
+			return nil
+		}
+
+		// The whole day has expired.
+		if !day.Add(24 * time.Hour).After(deadline) {
+			expired = append(expired, dayDir)
+			return nil
+		}
+		if !day.Before(deadline) {
+			return nil
+		}
+
+		return bkt.Iter(ctx, dayDir, func(hourDir string) error {
+			hour, err := time.Parse(dayFormat+"/"+hourFormat, path.Base(dayDir)+"/"+path.Base(hourDir))
+			if err != nil {
+				return nil
+			}
+
+			if !hour.Add(time.Hour).After(deadline) {
+				expired = append(expired, hourDir)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, dir := range expired {
+		n, err := bucket.DeletePrefix(ctx, bkt, dir, logger)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
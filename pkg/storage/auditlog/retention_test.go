@@ -0,0 +1,54 @@
+package auditlog
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+)
+
+func TestDeleteExpiredRecords(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	for _, name := range []string{
+		"user-1/2021-10-10/23/01.jsonl",
+		"user-1/2021-10-11/00/01.jsonl",
+		"user-1/2021-10-11/11/01.jsonl",
+		"user-1/2021-10-11/11/02.jsonl",
+		"user-1/2021-10-11/12/01.jsonl",
+		"user-1/2021-10-12/00/01.jsonl",
+		"user-1/unknown/01.jsonl",
+		"user-2/2021-10-10/00/01.jsonl",
+		"user-3/2021-10-10/00/01.jsonl",
+	} {
+		require.NoError(t, bkt.Upload(context.Background(), name, strings.NewReader("{}\n")))
+	}
+
+	// The retention deadline is at 2021-10-11 12:30.
+	now := time.Date(2021, 10, 12, 12, 30, 0, 0, time.UTC)
+	isOwned := func(userID string) (bool, error) {
+		return userID != "user-3", nil
+	}
+
+	deleted, err := DeleteExpiredRecords(context.Background(), bkt, 24*time.Hour, now, isOwned, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Equal(t, 5, deleted)
+
+	var remaining []string
+	for name := range bkt.Objects() {
+		remaining = append(remaining, name)
+	}
+	sort.Strings(remaining)
+
+	assert.Equal(t, []string{
+		"user-1/2021-10-11/12/01.jsonl",
+		"user-1/2021-10-12/00/01.jsonl",
+		"user-1/unknown/01.jsonl",
+		"user-3/2021-10-10/00/01.jsonl",
+	}, remaining)
+}
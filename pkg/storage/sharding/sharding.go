@@ -0,0 +1,122 @@
+package sharding
+
+import (
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+)
+
+// RemoveShardFromMatchers returns the shard annotation carried by the input matchers (if any)
+// and the input matchers without the shard matcher. The blocks storage doesn't store the
+// shard label, so the shard matcher must be removed before selecting series from TSDB.
+func RemoveShardFromMatchers(matchers []*labels.Matcher) (*astmapper.ShardAnnotation, []*labels.Matcher, error) {
+	shard, idx, err := astmapper.ShardFromMatchers(matchers)
+	if err != nil || shard == nil {
+		return nil, matchers, err
+	}
+
+	filtered := make([]*labels.Matcher, 0, len(matchers)-1)
+	filtered = append(filtered, matchers[:idx]...)
+	filtered = append(filtered, matchers[idx+1:]...)
+
+	return shard, filtered, nil
+}
+
+// IsSeriesInShard returns whether the series identified by the input labels belongs to the shard.
+// The blocks storage shards series by the hash of their labels set.
+func IsSeriesInShard(shard astmapper.ShardAnnotation, lbls labels.Labels) bool {
+	return lbls.Hash()%uint64(shard.Of) == uint64(shard.Shard)
+}
+
+// ShardsOverlap returns whether two shards may contain the same series. Shards are guaranteed
+// to be disjoint only when the number of shards of one is a multiple of the other's, because
+// series are sharded by the modulo of their hash.
+func ShardsOverlap(a, b astmapper.ShardAnnotation) bool {
+	switch {
+	case a.Of%b.Of == 0:
+		return a.Shard%b.Of == b.Shard
+	case b.Of%a.Of == 0:
+		return b.Shard%a.Of == a.Shard
+	default:
+		return true
+	}
+}
+
+// NewSeriesSet returns a storage.SeriesSet which only iterates the series of the input
+// set belonging to the shard. If the shard is nil, the input set is returned.
+func NewSeriesSet(set storage.SeriesSet, shard *astmapper.ShardAnnotation) storage.SeriesSet {
+	if shard == nil {
+		return set
+	}
+
+	return &shardedSeriesSet{SeriesSet: set, shard: *shard}
+}
+
+type shardedSeriesSet struct {
+	storage.SeriesSet
+	shard astmapper.ShardAnnotation
+}
+
+func (s *shardedSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		if IsSeriesInShard(s.shard, s.SeriesSet.At().Labels()) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewChunkSeriesSet returns a storage.ChunkSeriesSet which only iterates the series of the
+// input set belonging to the shard. If the shard is nil, the input set is returned.
+func NewChunkSeriesSet(set storage.ChunkSeriesSet, shard *astmapper.ShardAnnotation) storage.ChunkSeriesSet {
+	if shard == nil {
+		return set
+	}
+
+	return &shardedChunkSeriesSet{ChunkSeriesSet: set, shard: *shard}
+}
+
+type shardedChunkSeriesSet struct {
+	storage.ChunkSeriesSet
+	shard astmapper.ShardAnnotation
+}
+
+func (s *shardedChunkSeriesSet) Next() bool {
+	for s.ChunkSeriesSet.Next() {
+		if IsSeriesInShard(s.shard, s.ChunkSeriesSet.At().Labels()) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewShardLabelSeriesSet returns a storage.SeriesSet which adds the shard label to each series
+// of the input set. The query-frontend relies on the shard label to merge the results of the
+// sharded queries, so series must carry it once returned by the querier.
+func NewShardLabelSeriesSet(set storage.SeriesSet, shard astmapper.ShardAnnotation) storage.SeriesSet {
+	return &shardLabelSeriesSet{SeriesSet: set, label: shard.Label()}
+}
+
+type shardLabelSeriesSet struct {
+	storage.SeriesSet
+	label labels.Label
+}
+
+func (s *shardLabelSeriesSet) At() storage.Series {
+	series := s.SeriesSet.At()
+
+	b := labels.NewBuilder(series.Labels())
+	b.Set(s.label.Name, s.label.Value)
+
+	return &shardLabelSeries{Series: series, lbls: b.Labels()}
+}
+
+type shardLabelSeries struct {
+	storage.Series
+	lbls labels.Labels
+}
+
+func (s *shardLabelSeries) Labels() labels.Labels {
+	return s.lbls
+}
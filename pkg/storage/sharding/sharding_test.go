@@ -0,0 +1,150 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
+	"github.com/cortexproject/cortex/pkg/querier/series"
+)
+
+func TestRemoveShardFromMatchers(t *testing.T) {
+	tests := map[string]struct {
+		input            []*labels.Matcher
+		expectedShard    *astmapper.ShardAnnotation
+		expectedMatchers []*labels.Matcher
+		expectedErr      bool
+	}{
+		"should return the input matchers if there's no shard matcher": {
+			input: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test"),
+			},
+			expectedShard: nil,
+			expectedMatchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test"),
+			},
+		},
+		"should remove the shard matcher": {
+			input: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test"),
+				labels.MustNewMatcher(labels.MatchEqual, astmapper.ShardLabel, "2_of_4"),
+				labels.MustNewMatcher(labels.MatchEqual, "foo", "bar"),
+			},
+			expectedShard: &astmapper.ShardAnnotation{Shard: 2, Of: 4},
+			expectedMatchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test"),
+				labels.MustNewMatcher(labels.MatchEqual, "foo", "bar"),
+			},
+		},
+		"should fail on invalid shard matcher": {
+			input: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, astmapper.ShardLabel, "4_of_4"),
+			},
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			shard, matchers, err := RemoveShardFromMatchers(testData.input)
+			if testData.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedShard, shard)
+			assert.Equal(t, testData.expectedMatchers, matchers)
+		})
+	}
+}
+
+func TestNewSeriesSet(t *testing.T) {
+	const numSeries = 100
+	const numShards = 4
+
+	var input []storage.Series
+	for i := 0; i < numSeries; i++ {
+		input = append(input, series.NewEmptySeries(labels.FromStrings(labels.MetricName, "test", "series", fmt.Sprintf("%d", i))))
+	}
+
+	// Each series should belong to exactly one shard.
+	seen := map[string]int{}
+	for shardIdx := 0; shardIdx < numShards; shardIdx++ {
+		shard := &astmapper.ShardAnnotation{Shard: shardIdx, Of: numShards}
+
+		set := NewShardLabelSeriesSet(NewSeriesSet(series.NewConcreteSeriesSet(input), shard), *shard)
+		for set.Next() {
+			lbls := set.At().Labels()
+			assert.Equal(t, shard.String(), lbls.Get(astmapper.ShardLabel))
+
+			lbls = labels.NewBuilder(lbls).Del(astmapper.ShardLabel).Labels()
+			assert.True(t, IsSeriesInShard(*shard, lbls))
+			seen[lbls.String()]++
+		}
+		require.NoError(t, set.Err())
+	}
+
+	require.Len(t, seen, numSeries)
+	for _, count := range seen {
+		assert.Equal(t, 1, count)
+	}
+}
+
+func TestNewSeriesSet_NilShard(t *testing.T) {
+	input := series.NewConcreteSeriesSet(nil)
+	assert.Equal(t, input, NewSeriesSet(input, nil))
+}
+
+func TestShardsOverlap(t *testing.T) {
+	tests := map[string]struct {
+		a, b     astmapper.ShardAnnotation
+		expected bool
+	}{
+		"same shard": {
+			a:        astmapper.ShardAnnotation{Shard: 1, Of: 4},
+			b:        astmapper.ShardAnnotation{Shard: 1, Of: 4},
+			expected: true,
+		},
+		"different shard with the same number of shards": {
+			a:        astmapper.ShardAnnotation{Shard: 1, Of: 4},
+			b:        astmapper.ShardAnnotation{Shard: 2, Of: 4},
+			expected: false,
+		},
+		"number of shards is a multiple, overlapping": {
+			a:        astmapper.ShardAnnotation{Shard: 5, Of: 8},
+			b:        astmapper.ShardAnnotation{Shard: 1, Of: 4},
+			expected: true,
+		},
+		"number of shards is a multiple, not overlapping": {
+			a:        astmapper.ShardAnnotation{Shard: 1, Of: 2},
+			b:        astmapper.ShardAnnotation{Shard: 2, Of: 4},
+			expected: false,
+		},
+		"number of shards is not a multiple": {
+			a:        astmapper.ShardAnnotation{Shard: 0, Of: 3},
+			b:        astmapper.ShardAnnotation{Shard: 1, Of: 4},
+			expected: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testData.expected, ShardsOverlap(testData.a, testData.b))
+			assert.Equal(t, testData.expected, ShardsOverlap(testData.b, testData.a))
+
+			// Double check with series.
+			found := false
+			for i := 0; i < 1000 && !found; i++ {
+				lbls := labels.FromStrings("series", fmt.Sprintf("%d", i))
+				found = IsSeriesInShard(testData.a, lbls) && IsSeriesInShard(testData.b, lbls)
+			}
+			assert.Equal(t, testData.expected, found)
+		})
+	}
+}
@@ -41,6 +41,11 @@ type Index struct {
 	// UpdatedAt is a unix timestamp (seconds precision) of when the index has been updated
 	// (written in the storage) the last time.
 	UpdatedAt int64 `json:"updated_at"`
+
+	// The load published by each store-gateway, by instance address, when the index has been updated.
+	// It's used by the balanced store-gateway sharding strategy, so that queriers and store-gateways
+	// place the blocks based on the same loads.
+	StoreGatewayLoads map[string]int64 `json:"store_gateway_loads,omitempty"`
 }
 
 func (idx *Index) GetUpdatedAt() time.Time {
@@ -83,6 +88,21 @@ type Block struct {
 	// UploadedAt is a unix timestamp (seconds precision) of when the block has been completed to be uploaded
 	// to the storage.
 	UploadedAt int64 `json:"uploaded_at"`
+
+	// CompactorShardID is the shard ID (formatted as "<shard>_of_<shards>") of blocks split by the
+	// split-and-merge compactor. Empty if the block has not been split.
+	CompactorShardID string `json:"compactor_shard_id,omitempty"`
+
+	// Resolution is the downsampling resolution of the block (millis precision).
+	// Zero for raw blocks.
+	Resolution int64 `json:"resolution,omitempty"`
+
+	// NumSeries is the number of series in the block. Zero if unknown.
+	NumSeries uint64 `json:"num_series,omitempty"`
+
+	// IndexSize is the size in bytes of the block index file. Zero if unknown
+	// (eg. the meta.json doesn't list the block files).
+	IndexSize int64 `json:"index_size,omitempty"`
 }
 
 // Within returns whether the block contains samples within the provided range.
@@ -112,6 +132,7 @@ func (m *Block) ThanosMeta(userID string) *metadata.Meta {
 			Labels: map[string]string{
 				cortex_tsdb.TenantIDExternalLabel: userID,
 			},
+			Downsample:   metadata.ThanosDownsample{Resolution: m.Resolution},
 			SegmentFiles: m.thanosMetaSegmentFiles(),
 		},
 	}
@@ -138,14 +159,28 @@ func BlockFromThanosMeta(meta metadata.Meta) *Block {
 	segmentsFormat, segmentsNum := detectBlockSegmentsFormat(meta)
 
 	return &Block{
-		ID:             meta.ULID,
-		MinTime:        meta.MinTime,
-		MaxTime:        meta.MaxTime,
-		SegmentsFormat: segmentsFormat,
-		SegmentsNum:    segmentsNum,
+		ID:               meta.ULID,
+		MinTime:          meta.MinTime,
+		MaxTime:          meta.MaxTime,
+		SegmentsFormat:   segmentsFormat,
+		SegmentsNum:      segmentsNum,
+		CompactorShardID: meta.Thanos.Labels[cortex_tsdb.CompactorShardIDExternalLabel],
+		Resolution:       meta.Thanos.Downsample.Resolution,
+		NumSeries:        meta.Stats.NumSeries,
+		IndexSize:        detectBlockIndexSize(meta),
 	}
 }
 
+func detectBlockIndexSize(meta metadata.Meta) int64 {
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath == block.IndexFilename {
+			return f.SizeBytes
+		}
+	}
+
+	return 0
+}
+
 func detectBlockSegmentsFormat(meta metadata.Meta) (string, int) {
 	if num, ok := detectBlockSegmentsFormat1Based6Digits(meta); ok {
 		return SegmentsFormat1Based6Digits, num
@@ -7,6 +7,8 @@ import (
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/stretchr/testify/assert"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
 )
 
 func TestIndex_RemoveBlock(t *testing.T) {
@@ -201,6 +203,74 @@ func TestBlockFromThanosMeta(t *testing.T) {
 				SegmentsNum:    3,
 			},
 		},
+		"meta.json with compactor shard ID": {
+			meta: metadata.Meta{
+				BlockMeta: tsdb.BlockMeta{
+					ULID:    blockID,
+					MinTime: 10,
+					MaxTime: 20,
+				},
+				Thanos: metadata.Thanos{
+					Labels: map[string]string{
+						cortex_tsdb.TenantIDExternalLabel:         "user-1",
+						cortex_tsdb.CompactorShardIDExternalLabel: "1_of_4",
+					},
+				},
+			},
+			expected: Block{
+				ID:               blockID,
+				MinTime:          10,
+				MaxTime:          20,
+				SegmentsFormat:   SegmentsFormatUnknown,
+				SegmentsNum:      0,
+				CompactorShardID: "1_of_4",
+			},
+		},
+		"meta.json with downsampling resolution": {
+			meta: metadata.Meta{
+				BlockMeta: tsdb.BlockMeta{
+					ULID:    blockID,
+					MinTime: 10,
+					MaxTime: 20,
+				},
+				Thanos: metadata.Thanos{
+					Downsample: metadata.ThanosDownsample{Resolution: 300000},
+				},
+			},
+			expected: Block{
+				ID:             blockID,
+				MinTime:        10,
+				MaxTime:        20,
+				SegmentsFormat: SegmentsFormatUnknown,
+				SegmentsNum:    0,
+				Resolution:     300000,
+			},
+		},
+		"meta.json with stats and index file size": {
+			meta: metadata.Meta{
+				BlockMeta: tsdb.BlockMeta{
+					ULID:    blockID,
+					MinTime: 10,
+					MaxTime: 20,
+					Stats:   tsdb.BlockStats{NumSeries: 1000},
+				},
+				Thanos: metadata.Thanos{
+					Files: []metadata.File{
+						{RelPath: "index", SizeBytes: 4096},
+						{RelPath: "chunks/000001", SizeBytes: 65536},
+					},
+				},
+			},
+			expected: Block{
+				ID:             blockID,
+				MinTime:        10,
+				MaxTime:        20,
+				SegmentsFormat: SegmentsFormat1Based6Digits,
+				SegmentsNum:    1,
+				NumSeries:      1000,
+				IndexSize:      4096,
+			},
+		},
 	}
 
 	for testName, testData := range tests {
@@ -314,6 +384,29 @@ func TestBlock_ThanosMeta(t *testing.T) {
 				},
 			},
 		},
+		"downsampled block": {
+			block: Block{
+				ID:         blockID,
+				MinTime:    10,
+				MaxTime:    20,
+				Resolution: 300000,
+			},
+			expected: &metadata.Meta{
+				BlockMeta: tsdb.BlockMeta{
+					ULID:    blockID,
+					MinTime: 10,
+					MaxTime: 20,
+					Version: metadata.TSDBVersion1,
+				},
+				Thanos: metadata.Thanos{
+					Version: metadata.ThanosVersion1,
+					Labels: map[string]string{
+						"__org_id__": userID,
+					},
+					Downsample: metadata.ThanosDownsample{Resolution: 300000},
+				},
+			},
+		},
 	}
 
 	for testName, testData := range tests {
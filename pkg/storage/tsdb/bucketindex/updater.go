@@ -45,11 +45,13 @@ func NewUpdater(bkt objstore.Bucket, userID string, cfgProvider bucket.TenantCon
 func (w *Updater) UpdateIndex(ctx context.Context, old *Index) (*Index, map[ulid.ULID]error, error) {
 	var oldBlocks []*Block
 	var oldBlockDeletionMarks []*BlockDeletionMark
+	var oldStoreGatewayLoads map[string]int64
 
 	// Read the old index, if provided.
 	if old != nil {
 		oldBlocks = old.Blocks
 		oldBlockDeletionMarks = old.BlockDeletionMarks
+		oldStoreGatewayLoads = old.StoreGatewayLoads
 	}
 
 	blocks, partials, err := w.updateBlocks(ctx, oldBlocks)
@@ -67,6 +69,7 @@ func (w *Updater) UpdateIndex(ctx context.Context, old *Index) (*Index, map[ulid
 		Blocks:             blocks,
 		BlockDeletionMarks: blockDeletionMarks,
 		UpdatedAt:          time.Now().Unix(),
+		StoreGatewayLoads:  oldStoreGatewayLoads,
 	}, partials, nil
 }
 
@@ -61,6 +61,13 @@ func TestUpdater_UpdateIndex(t *testing.T) {
 	assertBucketIndexEqual(t, returnedIdx, bkt, userID,
 		[]tsdb.BlockMeta{block1, block3, block4},
 		[]*metadata.DeletionMark{block4Mark})
+
+	// The store-gateways load is not updated by the updater, so it's kept.
+	returnedIdx.StoreGatewayLoads = map[string]int64{"127.0.0.1": 100}
+
+	returnedIdx, _, err = w.UpdateIndex(ctx, returnedIdx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"127.0.0.1": 100}, returnedIdx.StoreGatewayLoads)
 }
 
 func TestUpdater_UpdateIndex_ShouldSkipPartialBlocks(t *testing.T) {
@@ -28,6 +28,10 @@ const (
 	// and can be used to shard blocks.
 	ShardIDExternalLabel = "__shard_id__"
 
+	// CompactorShardIDExternalLabel is the external label containing the shard ID
+	// (formatted as "<shard>_of_<shards>") of blocks split by the split-and-merge compactor.
+	CompactorShardIDExternalLabel = "__compactor_shard_id__"
+
 	// How often are open TSDBs checked for being idle and closed.
 	DefaultCloseIdleTSDBInterval = 5 * time.Minute
 
@@ -50,6 +54,7 @@ var (
 	errInvalidWALSegmentSizeBytes   = errors.New("invalid TSDB WAL segment size bytes")
 	errInvalidStripeSize            = errors.New("invalid TSDB stripe size")
 	errEmptyBlockranges             = errors.New("empty block ranges for TSDB")
+	errInvalidSeriesBatchSize       = errors.New("invalid bucket store series batch size")
 )
 
 // BlocksStorageConfig holds the config information for the blocks storage.
@@ -221,6 +226,16 @@ func (cfg *TSDBConfig) IsBlocksShippingEnabled() bool {
 	return cfg.ShipInterval > 0
 }
 
+// MaxUnshippedSamplesAge returns how long the samples may only be in the ingesters before being shipped to
+// the storage: the TSDB head is compacted once it spans 1.5 times the block range, which is checked at each
+// head compaction interval, and the compacted blocks are shipped at the next ship interval.
+func (cfg *TSDBConfig) MaxUnshippedSamplesAge() time.Duration {
+	if len(cfg.BlockRanges) == 0 {
+		return 0
+	}
+	return cfg.BlockRanges[0]*3/2 + cfg.HeadCompactionInterval + cfg.ShipInterval
+}
+
 // BucketStoreConfig holds the config information for Bucket Stores used by the querier and store-gateway.
 type BucketStoreConfig struct {
 	SyncDir                  string              `yaml:"sync_dir"`
@@ -255,6 +270,12 @@ type BucketStoreConfig struct {
 	// On the contrary, smaller value will increase baseline memory usage, but improve latency slightly.
 	// 1 will keep all in memory. Default value is the same as in Prometheus which gives a good balance.
 	PostingOffsetsInMemSampling int `yaml:"postings_offsets_in_mem_sampling" doc:"hidden"`
+
+	// Controls whether the series are sent to the querier in batches, loading the chunks of one batch at a time.
+	SeriesBatchSize int `yaml:"series_batch_size"`
+
+	// Controls whether the series deleted by the tenant's tombstones are filtered out, configured from the purger config.
+	SeriesDeletionEnabled bool `yaml:"-"`
 }
 
 // RegisterFlags registers the BucketStore flags
@@ -281,6 +302,7 @@ func (cfg *BucketStoreConfig) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&cfg.IndexHeaderLazyLoadingEnabled, "blocks-storage.bucket-store.index-header-lazy-loading-enabled", false, "If enabled, store-gateway will lazy load an index-header only once required by a query.")
 	f.DurationVar(&cfg.IndexHeaderLazyLoadingIdleTimeout, "blocks-storage.bucket-store.index-header-lazy-loading-idle-timeout", 20*time.Minute, "If index-header lazy loading is enabled and this setting is > 0, the store-gateway will offload unused index-headers after 'idle timeout' inactivity.")
 	f.Uint64Var(&cfg.PartitionerMaxGapBytes, "blocks-storage.bucket-store.partitioner-max-gap-bytes", store.PartitionerMaxGapSize, "Max size - in bytes - of a gap for which the partitioner aggregates together two bucket GET object requests.")
+	f.IntVar(&cfg.SeriesBatchSize, "blocks-storage.bucket-store.series-batch-size", 0, "If greater than 0, the store-gateway sends the series of a query in batches of this number of series, loading the chunks of one batch at a time instead of loading all the chunks up front. 0 to disable.")
 }
 
 // Validate the config.
@@ -297,6 +319,9 @@ func (cfg *BucketStoreConfig) Validate() error {
 	if err != nil {
 		return errors.Wrap(err, "metadata-cache configuration")
 	}
+	if cfg.SeriesBatchSize < 0 {
+		return errInvalidSeriesBatchSize
+	}
 	return nil
 }
 
@@ -178,3 +178,13 @@ func TestConfig_DurationList(t *testing.T) {
 		})
 	}
 }
+
+func TestTSDBConfig_MaxUnshippedSamplesAge(t *testing.T) {
+	cfg := TSDBConfig{
+		BlockRanges:            DurationList{2 * time.Hour, 12 * time.Hour},
+		HeadCompactionInterval: time.Minute,
+		ShipInterval:           time.Minute,
+	}
+
+	assert.Equal(t, 3*time.Hour+2*time.Minute, cfg.MaxUnshippedSamplesAge())
+}
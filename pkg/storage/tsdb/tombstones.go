@@ -0,0 +1,198 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-io/objstore"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+// Relative to user-specific prefix.
+const TombstonesPath = "tombstones"
+
+const tombstoneFileExtension = ".json"
+
+// TombstoneState is the state of a series deletion request.
+type TombstoneState string
+
+const (
+	// TombstonePending is the state of a deletion request which has not been
+	// applied to the blocks yet. Deleted series are filtered out at query time.
+	TombstonePending TombstoneState = "pending"
+
+	// TombstoneProcessed is the state of a deletion request which has been
+	// applied by the compactor rewriting the affected blocks.
+	TombstoneProcessed TombstoneState = "processed"
+
+	// TombstoneCancelled is the state of a deletion request which has been
+	// cancelled by the user before being processed.
+	TombstoneCancelled TombstoneState = "cancelled"
+)
+
+var ErrTombstoneNotFound = errors.New("tombstone not found")
+
+// Tombstone is a series deletion request for the blocks storage. Each tombstone
+// is stored as a JSON file in the tenant's tombstones location in the bucket.
+type Tombstone struct {
+	RequestID string `json:"request_id"`
+
+	// Unix timestamp (milliseconds) when the deletion request was created.
+	RequestCreatedAt int64 `json:"request_created_at"`
+
+	// Unix timestamp (milliseconds) when the current state was set.
+	StateCreatedAt int64 `json:"state_created_at"`
+
+	// Time range (milliseconds, inclusive) of the samples to delete.
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+
+	// Series selectors of the series to delete.
+	Selectors []string       `json:"selectors"`
+	State     TombstoneState `json:"state"`
+
+	// Matchers parsed from Selectors.
+	Matchers [][]*labels.Matcher `json:"-"`
+}
+
+// NewTombstone makes a new pending tombstone, parsing the input selectors.
+func NewTombstone(requestID string, createdAt, startTime, endTime int64, selectors []string) (*Tombstone, error) {
+	t := &Tombstone{
+		RequestID:        requestID,
+		RequestCreatedAt: createdAt,
+		StateCreatedAt:   createdAt,
+		StartTime:        startTime,
+		EndTime:          endTime,
+		Selectors:        selectors,
+		State:            TombstonePending,
+	}
+
+	if err := t.parseMatchers(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Tombstone) parseMatchers() error {
+	t.Matchers = make([][]*labels.Matcher, 0, len(t.Selectors))
+
+	for _, selector := range t.Selectors {
+		matchers, err := parser.ParseMetricSelector(selector)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse selector %s", selector)
+		}
+		t.Matchers = append(t.Matchers, matchers)
+	}
+
+	return nil
+}
+
+// Overlaps returns whether the tombstone time range overlaps the input one (both inclusive).
+func (t *Tombstone) Overlaps(minT, maxT int64) bool {
+	return t.StartTime <= maxT && minT <= t.EndTime
+}
+
+// WriteTombstone uploads the tombstone to the tenant location in the bucket,
+// overwriting any previous state of the same deletion request.
+func WriteTombstone(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider, t *Tombstone) error {
+	bkt = bucket.NewUserBucketClient(userID, bkt, cfgProvider)
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "serialize tombstone")
+	}
+
+	return errors.Wrap(bkt.Upload(ctx, tombstoneFilepath(t.RequestID), bytes.NewReader(data)), "upload tombstone")
+}
+
+// DeleteTombstone removes the tombstone of the deletion request with the given ID from
+// the tenant location in the bucket. Deleting a non-existing tombstone is not an error.
+func DeleteTombstone(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider, requestID string) error {
+	bkt = bucket.NewUserBucketClient(userID, bkt, cfgProvider)
+
+	if err := bkt.Delete(ctx, tombstoneFilepath(requestID)); err != nil && !bkt.IsObjNotFoundErr(err) {
+		return errors.Wrap(err, "delete tombstone")
+	}
+
+	return nil
+}
+
+// ReadTombstone returns the tombstone of the deletion request with the given ID.
+// Returns ErrTombstoneNotFound if it doesn't exist.
+func ReadTombstone(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider, requestID string) (*Tombstone, error) {
+	bkt = bucket.NewUserBucketClient(userID, bkt, cfgProvider)
+	tombstoneFile := tombstoneFilepath(requestID)
+
+	r, err := bkt.Get(ctx, tombstoneFile)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return nil, ErrTombstoneNotFound
+		}
+
+		return nil, errors.Wrapf(err, "failed to read tombstone object: %s", tombstoneFile)
+	}
+
+	t := &Tombstone{}
+	err = json.NewDecoder(r).Decode(t)
+
+	// Close reader before dealing with decode error.
+	if closeErr := r.Close(); closeErr != nil {
+		level.Warn(util_log.Logger).Log("msg", "failed to close bucket reader", "err", closeErr)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode tombstone object: %s", tombstoneFile)
+	}
+
+	if err := t.parseMatchers(); err != nil {
+		return nil, errors.Wrapf(err, "invalid tombstone object: %s", tombstoneFile)
+	}
+
+	return t, nil
+}
+
+// ListTombstones returns all the tombstones of the tenant, whatever their state is.
+func ListTombstones(ctx context.Context, bkt objstore.Bucket, userID string, cfgProvider bucket.TenantConfigProvider) ([]*Tombstone, error) {
+	var requestIDs []string
+
+	userBkt := bucket.NewUserBucketClient(userID, bkt, cfgProvider)
+	err := userBkt.Iter(ctx, TombstonesPath+objstore.DirDelim, func(name string) error {
+		if base := path.Base(name); strings.HasSuffix(base, tombstoneFileExtension) {
+			requestIDs = append(requestIDs, strings.TrimSuffix(base, tombstoneFileExtension))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list tombstones")
+	}
+
+	tombstones := make([]*Tombstone, 0, len(requestIDs))
+	for _, requestID := range requestIDs {
+		t, err := ReadTombstone(ctx, bkt, userID, cfgProvider, requestID)
+		if errors.Is(err, ErrTombstoneNotFound) {
+			// The tombstone may have been deleted in the meanwhile.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tombstones = append(tombstones, t)
+	}
+
+	return tombstones, nil
+}
+
+func tombstoneFilepath(requestID string) string {
+	return path.Join(TombstonesPath, requestID+tombstoneFileExtension)
+}
@@ -0,0 +1,87 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+)
+
+func TestTombstones_WriteReadList(t *testing.T) {
+	const username = "user"
+
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	// Upload an unrelated object to ensure it's not listed as a tombstone.
+	require.NoError(t, bkt.Upload(ctx, "user/01EQK4QKFHVSZYVJ908Y7HH9E0/meta.json", bytes.NewReader([]byte("data"))))
+
+	// No tombstones.
+	tombstones, err := ListTombstones(ctx, bkt, username, nil)
+	require.NoError(t, err)
+	assert.Empty(t, tombstones)
+
+	_, err = ReadTombstone(ctx, bkt, username, nil, "request-1")
+	assert.Equal(t, ErrTombstoneNotFound, err)
+
+	// Write tombstones.
+	first, err := NewTombstone("request-1", 1000, 10, 20, []string{`{job="test"}`})
+	require.NoError(t, err)
+	require.NoError(t, WriteTombstone(ctx, bkt, username, nil, first))
+
+	second, err := NewTombstone("request-2", 2000, 30, 40, []string{`series_1`, `{__name__="series_2",pod=~"a.*"}`})
+	require.NoError(t, err)
+	require.NoError(t, WriteTombstone(ctx, bkt, username, nil, second))
+
+	// Read back a tombstone.
+	actual, err := ReadTombstone(ctx, bkt, username, nil, "request-2")
+	require.NoError(t, err)
+	assert.Equal(t, second, actual)
+	assert.Equal(t, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "series_2"),
+		labels.MustNewMatcher(labels.MatchRegexp, "pod", "a.*"),
+	}, actual.Matchers[1])
+
+	// Update the state.
+	first.State = TombstoneCancelled
+	first.StateCreatedAt = 3000
+	require.NoError(t, WriteTombstone(ctx, bkt, username, nil, first))
+
+	tombstones, err = ListTombstones(ctx, bkt, username, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*Tombstone{first, second}, tombstones)
+
+	// Tombstones of other users are not listed.
+	tombstones, err = ListTombstones(ctx, bkt, "another-user", nil)
+	require.NoError(t, err)
+	assert.Empty(t, tombstones)
+
+	// Delete a tombstone. Deleting it again is a no-op.
+	require.NoError(t, DeleteTombstone(ctx, bkt, username, nil, "request-1"))
+	require.NoError(t, DeleteTombstone(ctx, bkt, username, nil, "request-1"))
+
+	tombstones, err = ListTombstones(ctx, bkt, username, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*Tombstone{second}, tombstones)
+}
+
+func TestNewTombstone_ShouldFailOnInvalidSelector(t *testing.T) {
+	_, err := NewTombstone("request-1", 1000, 10, 20, []string{`{job=}`})
+	require.Error(t, err)
+}
+
+func TestTombstone_Overlaps(t *testing.T) {
+	tombstone, err := NewTombstone("request-1", 1000, 10, 20, []string{`{job="test"}`})
+	require.NoError(t, err)
+
+	assert.True(t, tombstone.Overlaps(0, 10))
+	assert.True(t, tombstone.Overlaps(15, 16))
+	assert.True(t, tombstone.Overlaps(20, 30))
+	assert.True(t, tombstone.Overlaps(0, 30))
+	assert.False(t, tombstone.Overlaps(0, 9))
+	assert.False(t, tombstone.Overlaps(21, 30))
+}
@@ -0,0 +1,359 @@
+package storegateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/services"
+)
+
+const (
+	// LoadKey is the key under which the store-gateways publish their load to the KV store
+	// used by the store-gateways ring.
+	LoadKey = "store-gateway-load"
+)
+
+// ProtoLoadDescFactory makes new LoadDescs.
+func ProtoLoadDescFactory() proto.Message {
+	return NewLoadDesc()
+}
+
+// NewLoadDesc returns an empty *storegateway.LoadDesc.
+func NewLoadDesc() *LoadDesc {
+	return &LoadDesc{Instances: map[string]InstanceLoad{}}
+}
+
+// GetLoadDescCodec returns the codec used to store the store-gateways load in the KV store.
+func GetLoadDescCodec() codec.Proto {
+	return codec.NewProtoCodec("storeGatewayLoadDesc", ProtoLoadDescFactory)
+}
+
+// GetOrCreateLoadDesc returns the input value as *LoadDesc, or an empty one if nil.
+func GetOrCreateLoadDesc(d interface{}) *LoadDesc {
+	if d == nil {
+		return NewLoadDesc()
+	}
+
+	desc := d.(*LoadDesc)
+	if desc == nil {
+		return NewLoadDesc()
+	}
+	if desc.Instances == nil {
+		desc.Instances = map[string]InstanceLoad{}
+	}
+	return desc
+}
+
+// Merge implements memberlist.Mergeable. For each instance, the most recently published load wins.
+// Ties are broken preferring the highest load, so that all the clients converge to the same loads
+// regardless of the merge order.
+func (d *LoadDesc) Merge(mergeable memberlist.Mergeable, _ bool) (memberlist.Mergeable, error) {
+	if mergeable == nil {
+		return nil, nil
+	}
+
+	other, ok := mergeable.(*LoadDesc)
+	if !ok {
+		return nil, fmt.Errorf("expected *storegateway.LoadDesc, got %T", mergeable)
+	}
+
+	if other == nil {
+		return nil, nil
+	}
+
+	if d.Instances == nil {
+		d.Instances = map[string]InstanceLoad{}
+	}
+
+	change := NewLoadDesc()
+	for addr, load := range other.Instances {
+		if current, ok := d.Instances[addr]; ok && !load.supersedes(current) {
+			continue
+		}
+
+		d.Instances[addr] = load
+		change.Instances[addr] = load
+	}
+
+	if len(change.Instances) == 0 {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// supersedes returns whether l should replace other when merging them.
+func (l InstanceLoad) supersedes(other InstanceLoad) bool {
+	if l.Timestamp != other.Timestamp {
+		return l.Timestamp > other.Timestamp
+	}
+	return l.Load > other.Load
+}
+
+// MergeContent implements memberlist.Mergeable.
+func (d *LoadDesc) MergeContent() []string {
+	out := make([]string, 0, len(d.Instances))
+	for addr := range d.Instances {
+		out = append(out, addr)
+	}
+	return out
+}
+
+// RemoveTombstones implements memberlist.Mergeable. There are no tombstones, but like the instances
+// auto-forgotten from the ring, the loads which have not been published since the given time limit
+// are removed. If the time limit is zero, nothing is removed.
+func (d *LoadDesc) RemoveTombstones(limit time.Time) (total, removed int) {
+	if limit.IsZero() {
+		return 0, 0
+	}
+
+	return 0, d.removeOlderThan(limit)
+}
+
+func (d *LoadDesc) removeOlderThan(limit time.Time) int {
+	removed := 0
+	for addr, load := range d.Instances {
+		if time.Unix(load.Timestamp, 0).Before(limit) {
+			delete(d.Instances, addr)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Clone implements memberlist.Mergeable.
+func (d *LoadDesc) Clone() memberlist.Mergeable {
+	clone := NewLoadDesc()
+	for addr, load := range d.Instances {
+		clone.Instances[addr] = load
+	}
+	return clone
+}
+
+// covers returns whether the placement identified by v has been computed from the same store-gateways
+// as the other one, and from the same or a more recent version of the bucket index.
+func (v PlacementVersion) covers(other PlacementVersion) bool {
+	return v.InstancesHash == other.InstancesHash && v.IndexUpdatedAt >= other.IndexUpdatedAt
+}
+
+// ReadLoads returns the load published by each store-gateway to the KV store, by instance address.
+func ReadLoads(ctx context.Context, kvClient kv.Client) (map[string]int64, error) {
+	value, err := kvClient.Get(ctx, LoadKey)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := GetOrCreateLoadDesc(value)
+
+	loads := make(map[string]int64, len(desc.Instances))
+	for addr, load := range desc.Instances {
+		loads[addr] = load.Load
+	}
+	return loads, nil
+}
+
+// BalancedLoads publishes the load of a store-gateway to the KV store, and keeps track of the placements
+// of the blocks each store-gateway has loaded. The load of a store-gateway is the sum of the weights of the
+// blocks it owns before balancing, across all the tenants, so that it doesn't depend on the loads of the
+// other store-gateways. The loads are stored in the bucket index by the compactor, and the balanced
+// sharding strategy places the blocks based on the loads in the bucket index, so that queriers and
+// store-gateways compute the same placement from the same bucket index.
+//
+// For each tenant, a store-gateway also publishes the last placement for which it has loaded all the blocks
+// it owns. When the placement changes, the previous owners of a block keep it until one of its new owners
+// has published the new placement, and queriers prefer the owners which have.
+//
+// A nil *BalancedLoads is valid and has no loaded placements.
+type BalancedLoads struct {
+	services.Service
+
+	kv               kv.Client
+	instanceAddr     string
+	heartbeatPeriod  time.Duration
+	heartbeatTimeout time.Duration
+	logger           log.Logger
+
+	mtx              sync.RWMutex
+	instances        map[string]InstanceLoad
+	userLoads        map[string]int64
+	loadedPlacements map[string]PlacementVersion
+}
+
+// NewBalancedLoads makes a new BalancedLoads. The instance address should be empty if the loaded
+// placements are only read, like queriers do.
+func NewBalancedLoads(kvClient kv.Client, instanceAddr string, heartbeatPeriod, heartbeatTimeout time.Duration, logger log.Logger) *BalancedLoads {
+	l := &BalancedLoads{
+		kv:               kvClient,
+		instanceAddr:     instanceAddr,
+		heartbeatPeriod:  heartbeatPeriod,
+		heartbeatTimeout: heartbeatTimeout,
+		logger:           logger,
+		instances:        map[string]InstanceLoad{},
+		userLoads:        map[string]int64{},
+		loadedPlacements: map[string]PlacementVersion{},
+	}
+
+	l.Service = services.NewBasicService(l.starting, l.running, nil)
+	return l
+}
+
+func (l *BalancedLoads) starting(ctx context.Context) error {
+	value, err := l.kv.Get(ctx, LoadKey)
+	if err != nil {
+		return err
+	}
+
+	l.updateLoads(value)
+	return nil
+}
+
+func (l *BalancedLoads) running(ctx context.Context) error {
+	go l.kv.WatchKey(ctx, LoadKey, func(value interface{}) bool {
+		l.updateLoads(value)
+		return true
+	})
+
+	if l.instanceAddr == "" {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(l.heartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.publish(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (l *BalancedLoads) updateLoads(value interface{}) {
+	desc, ok := value.(*LoadDesc)
+	if !ok || desc == nil {
+		return
+	}
+
+	instances := make(map[string]InstanceLoad, len(desc.Instances))
+	for addr, load := range desc.Instances {
+		instances[addr] = load
+	}
+
+	l.mtx.Lock()
+	l.instances = instances
+	l.mtx.Unlock()
+}
+
+// publish writes the load and the loaded placements of the instance to the KV store.
+func (l *BalancedLoads) publish(ctx context.Context) {
+	load, loadedPlacements := l.InstanceLoad(), l.getLoadedPlacements()
+
+	err := l.kv.CAS(ctx, LoadKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		desc := GetOrCreateLoadDesc(in)
+
+		// Remove the loads of the instances which have been auto-forgotten from the ring.
+		desc.removeOlderThan(time.Now().Add(-ringAutoForgetUnhealthyPeriods * l.heartbeatTimeout))
+
+		desc.Instances[l.instanceAddr] = InstanceLoad{Load: load, Timestamp: time.Now().Unix(), LoadedPlacements: loadedPlacements}
+		return desc, true, nil
+	})
+	if err != nil {
+		level.Warn(l.logger).Log("msg", "failed to publish the store-gateway load", "err", err)
+	}
+}
+
+// HasLoaded returns whether the input store-gateway has published that it has loaded all the blocks it
+// owns according to the input placement of the blocks of the tenant, or a more recent one.
+func (l *BalancedLoads) HasLoaded(instanceAddr, userID string, placement PlacementVersion) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	loaded, ok := l.instances[instanceAddr].LoadedPlacements[userID]
+	return ok && loaded.covers(placement)
+}
+
+// InstanceLoad returns the load of the instance, to be published. It's rounded up by balancedShardingCapacity,
+// so that the loads stored in the bucket index don't change each time a small block is added or deleted.
+func (l *BalancedLoads) InstanceLoad() int64 {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	total := int64(0)
+	for _, load := range l.userLoads {
+		total += load
+	}
+	return balancedShardingCapacity(total)
+}
+
+func (l *BalancedLoads) getLoadedPlacements() map[string]PlacementVersion {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	out := make(map[string]PlacementVersion, len(l.loadedPlacements))
+	for userID, placement := range l.loadedPlacements {
+		out[userID] = placement
+	}
+	return out
+}
+
+// setUserLoad sets the load of the instance for the blocks of a tenant.
+func (l *BalancedLoads) setUserLoad(userID string, load int64) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	l.userLoads[userID] = load
+	l.mtx.Unlock()
+}
+
+// setLoadedPlacement sets the placement of the blocks of a tenant for which the instance has loaded all the blocks it owns.
+func (l *BalancedLoads) setLoadedPlacement(userID string, placement PlacementVersion) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	l.loadedPlacements[userID] = placement
+	l.mtx.Unlock()
+}
+
+// retainUsers removes the load and the loaded placement of the instance for the tenants which are not in the input list.
+func (l *BalancedLoads) retainUsers(userIDs []string) {
+	if l == nil {
+		return
+	}
+
+	owned := make(map[string]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		owned[userID] = struct{}{}
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for userID := range l.userLoads {
+		if _, ok := owned[userID]; !ok {
+			delete(l.userLoads, userID)
+		}
+	}
+	for userID := range l.loadedPlacements {
+		if _, ok := owned[userID]; !ok {
+			delete(l.loadedPlacements, userID)
+		}
+	}
+}
@@ -0,0 +1,1269 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: balanced_load.proto
+
+package storegateway
+
+import (
+	fmt "fmt"
+	_ "github.com/gogo/protobuf/gogoproto"
+	proto "github.com/gogo/protobuf/proto"
+	github_com_gogo_protobuf_sortkeys "github.com/gogo/protobuf/sortkeys"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+	reflect "reflect"
+	strings "strings"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+// LoadDesc is the load published by the store-gateways to the KV store,
+// used by the balanced sharding strategy to place blocks.
+type LoadDesc struct {
+	// The load of each store-gateway, by instance address.
+	Instances map[string]InstanceLoad `protobuf:"bytes,1,rep,name=instances,proto3" json:"instances" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *LoadDesc) Reset()      { *m = LoadDesc{} }
+func (*LoadDesc) ProtoMessage() {}
+func (*LoadDesc) Descriptor() ([]byte, []int) {
+	return fileDescriptor_533b6a3967330109, []int{0}
+}
+func (m *LoadDesc) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *LoadDesc) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_LoadDesc.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *LoadDesc) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LoadDesc.Merge(m, src)
+}
+func (m *LoadDesc) XXX_Size() int {
+	return m.Size()
+}
+func (m *LoadDesc) XXX_DiscardUnknown() {
+	xxx_messageInfo_LoadDesc.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LoadDesc proto.InternalMessageInfo
+
+func (m *LoadDesc) GetInstances() map[string]InstanceLoad {
+	if m != nil {
+		return m.Instances
+	}
+	return nil
+}
+
+type InstanceLoad struct {
+	// The sum of the weights of the blocks owned by the store-gateway before
+	// balancing, rounded up to reduce the number of updates.
+	Load int64 `protobuf:"varint,1,opt,name=load,proto3" json:"load,omitempty"`
+	// Unix timestamp (with seconds precision) of the last time the load
+	// has been published by the store-gateway.
+	Timestamp int64 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// The last placement of the blocks of each tenant for which the store-gateway
+	// has loaded all the blocks it owns, by tenant ID.
+	LoadedPlacements map[string]PlacementVersion `protobuf:"bytes,3,rep,name=loaded_placements,json=loadedPlacements,proto3" json:"loaded_placements" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *InstanceLoad) Reset()      { *m = InstanceLoad{} }
+func (*InstanceLoad) ProtoMessage() {}
+func (*InstanceLoad) Descriptor() ([]byte, []int) {
+	return fileDescriptor_533b6a3967330109, []int{1}
+}
+func (m *InstanceLoad) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *InstanceLoad) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_InstanceLoad.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *InstanceLoad) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InstanceLoad.Merge(m, src)
+}
+func (m *InstanceLoad) XXX_Size() int {
+	return m.Size()
+}
+func (m *InstanceLoad) XXX_DiscardUnknown() {
+	xxx_messageInfo_InstanceLoad.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InstanceLoad proto.InternalMessageInfo
+
+func (m *InstanceLoad) GetLoad() int64 {
+	if m != nil {
+		return m.Load
+	}
+	return 0
+}
+
+func (m *InstanceLoad) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *InstanceLoad) GetLoadedPlacements() map[string]PlacementVersion {
+	if m != nil {
+		return m.LoadedPlacements
+	}
+	return nil
+}
+
+// PlacementVersion identifies the inputs the placement of the blocks of a tenant
+// has been computed from.
+type PlacementVersion struct {
+	// The bucket index updated at timestamp.
+	IndexUpdatedAt int64 `protobuf:"varint,1,opt,name=index_updated_at,json=indexUpdatedAt,proto3" json:"index_updated_at,omitempty"`
+	// The hash of the addresses, zones and tokens of the store-gateways.
+	InstancesHash uint64 `protobuf:"varint,2,opt,name=instances_hash,json=instancesHash,proto3" json:"instances_hash,omitempty"`
+}
+
+func (m *PlacementVersion) Reset()      { *m = PlacementVersion{} }
+func (*PlacementVersion) ProtoMessage() {}
+func (*PlacementVersion) Descriptor() ([]byte, []int) {
+	return fileDescriptor_533b6a3967330109, []int{2}
+}
+func (m *PlacementVersion) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PlacementVersion) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PlacementVersion.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PlacementVersion) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PlacementVersion.Merge(m, src)
+}
+func (m *PlacementVersion) XXX_Size() int {
+	return m.Size()
+}
+func (m *PlacementVersion) XXX_DiscardUnknown() {
+	xxx_messageInfo_PlacementVersion.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PlacementVersion proto.InternalMessageInfo
+
+func (m *PlacementVersion) GetIndexUpdatedAt() int64 {
+	if m != nil {
+		return m.IndexUpdatedAt
+	}
+	return 0
+}
+
+func (m *PlacementVersion) GetInstancesHash() uint64 {
+	if m != nil {
+		return m.InstancesHash
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*LoadDesc)(nil), "storegateway.LoadDesc")
+	proto.RegisterMapType((map[string]InstanceLoad)(nil), "storegateway.LoadDesc.InstancesEntry")
+	proto.RegisterType((*InstanceLoad)(nil), "storegateway.InstanceLoad")
+	proto.RegisterMapType((map[string]PlacementVersion)(nil), "storegateway.InstanceLoad.LoadedPlacementsEntry")
+	proto.RegisterType((*PlacementVersion)(nil), "storegateway.PlacementVersion")
+}
+
+func init() { proto.RegisterFile("balanced_load.proto", fileDescriptor_533b6a3967330109) }
+
+var fileDescriptor_533b6a3967330109 = []byte{
+	// 411 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x52, 0x4d, 0xab, 0xd3, 0x40,
+	0x14, 0xcd, 0x34, 0x55, 0xec, 0xbc, 0x67, 0x89, 0x23, 0x42, 0x08, 0x32, 0x96, 0x07, 0x0f, 0xb2,
+	0x31, 0xaf, 0xd4, 0x2e, 0xc4, 0x9d, 0x45, 0xc1, 0x82, 0x0b, 0x09, 0x28, 0xee, 0xc2, 0x24, 0x33,
+	0x26, 0xc1, 0x24, 0x13, 0x32, 0x13, 0xb5, 0x3b, 0xd7, 0xae, 0xfc, 0x19, 0xee, 0xfc, 0x1b, 0x5d,
+	0x76, 0xd9, 0x95, 0xd8, 0x74, 0xe3, 0xb2, 0x3f, 0x41, 0x32, 0xb5, 0x69, 0x52, 0xea, 0xee, 0xde,
+	0x73, 0xcf, 0xb9, 0x1f, 0x67, 0x06, 0xde, 0xf7, 0x49, 0x42, 0xb2, 0x80, 0x51, 0x2f, 0xe1, 0x84,
+	0x3a, 0x79, 0xc1, 0x25, 0x47, 0x97, 0x42, 0xf2, 0x82, 0x85, 0x44, 0xb2, 0xcf, 0x64, 0x61, 0x3d,
+	0x0e, 0x63, 0x19, 0x95, 0xbe, 0x13, 0xf0, 0xf4, 0x26, 0xe4, 0x21, 0xbf, 0x51, 0x24, 0xbf, 0xfc,
+	0xa0, 0x32, 0x95, 0xa8, 0x68, 0x2f, 0xbe, 0xfa, 0x09, 0xe0, 0x9d, 0xd7, 0x9c, 0xd0, 0x17, 0x4c,
+	0x04, 0x68, 0x0e, 0x07, 0x71, 0x26, 0x64, 0x3d, 0x41, 0x98, 0x60, 0xa4, 0xdb, 0x17, 0x93, 0x6b,
+	0xa7, 0xdd, 0xdd, 0x39, 0x50, 0x9d, 0xf9, 0x81, 0xf7, 0x32, 0x93, 0xc5, 0x62, 0xd6, 0x5f, 0xfe,
+	0x7a, 0xa4, 0xb9, 0x47, 0xb5, 0xf5, 0x1e, 0x0e, 0xbb, 0x14, 0x64, 0x40, 0xfd, 0x23, 0x5b, 0x98,
+	0x60, 0x04, 0xec, 0x81, 0x5b, 0x87, 0x68, 0x0c, 0x6f, 0x7d, 0x22, 0x49, 0xc9, 0xcc, 0xde, 0x08,
+	0xd8, 0x17, 0x13, 0xab, 0x3b, 0xea, 0x20, 0xaf, 0x47, 0xba, 0x7b, 0xe2, 0xb3, 0xde, 0x53, 0x70,
+	0xf5, 0xad, 0x07, 0x2f, 0xdb, 0x35, 0x84, 0x60, 0xbf, 0x76, 0x43, 0x75, 0xd6, 0x5d, 0x15, 0xa3,
+	0x87, 0x70, 0x20, 0xe3, 0x94, 0x09, 0x49, 0xd2, 0x5c, 0xb5, 0xd7, 0xdd, 0x23, 0x80, 0x02, 0x78,
+	0xaf, 0x66, 0x31, 0xea, 0xe5, 0x09, 0x09, 0x58, 0xca, 0x32, 0x29, 0x4c, 0x5d, 0xdd, 0x3b, 0xfe,
+	0xff, 0x12, 0xea, 0x78, 0x46, 0xdf, 0x34, 0x92, 0xf6, 0xe9, 0x46, 0x72, 0x52, 0xb4, 0x02, 0xf8,
+	0xe0, 0xac, 0xe0, 0x8c, 0x11, 0xd3, 0xae, 0x11, 0xb8, 0xbb, 0x43, 0xa3, 0x7f, 0xc7, 0x0a, 0x11,
+	0xf3, 0xac, 0x6d, 0x46, 0x00, 0x8d, 0xd3, 0x32, 0xb2, 0xa1, 0x11, 0x67, 0x94, 0x7d, 0xf1, 0xca,
+	0x9c, 0x12, 0xc9, 0xa8, 0x47, 0xe4, 0x3f, 0x6f, 0x86, 0x0a, 0x7f, 0xbb, 0x87, 0x9f, 0x4b, 0x74,
+	0x0d, 0x87, 0xcd, 0x8b, 0x79, 0x11, 0x11, 0x91, 0x5a, 0xa0, 0xef, 0xde, 0x6d, 0xd0, 0x57, 0x44,
+	0x44, 0xb3, 0xe9, 0x6a, 0x83, 0xb5, 0xf5, 0x06, 0x6b, 0xbb, 0x0d, 0x06, 0x5f, 0x2b, 0x0c, 0x7e,
+	0x54, 0x18, 0x2c, 0x2b, 0x0c, 0x56, 0x15, 0x06, 0xbf, 0x2b, 0x0c, 0xfe, 0x54, 0x58, 0xdb, 0x55,
+	0x18, 0x7c, 0xdf, 0x62, 0x6d, 0xb5, 0xc5, 0xda, 0x7a, 0x8b, 0x35, 0xff, 0xb6, 0xfa, 0x60, 0x4f,
+	0xfe, 0x0e, 0x00, 0xe2, 0x0c, 0xfb, 0x05, 0xb4, 0x02, 0x00, 0x00,
+}
+
+func (this *LoadDesc) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*LoadDesc)
+	if !ok {
+		that2, ok := that.(LoadDesc)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if len(this.Instances) != len(that1.Instances) {
+		return false
+	}
+	for i := range this.Instances {
+		a := this.Instances[i]
+		b := that1.Instances[i]
+		if !(&a).Equal(&b) {
+			return false
+		}
+	}
+	return true
+}
+func (this *InstanceLoad) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*InstanceLoad)
+	if !ok {
+		that2, ok := that.(InstanceLoad)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Load != that1.Load {
+		return false
+	}
+	if this.Timestamp != that1.Timestamp {
+		return false
+	}
+	if len(this.LoadedPlacements) != len(that1.LoadedPlacements) {
+		return false
+	}
+	for i := range this.LoadedPlacements {
+		a := this.LoadedPlacements[i]
+		b := that1.LoadedPlacements[i]
+		if !(&a).Equal(&b) {
+			return false
+		}
+	}
+	return true
+}
+func (this *PlacementVersion) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*PlacementVersion)
+	if !ok {
+		that2, ok := that.(PlacementVersion)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.IndexUpdatedAt != that1.IndexUpdatedAt {
+		return false
+	}
+	if this.InstancesHash != that1.InstancesHash {
+		return false
+	}
+	return true
+}
+func (this *LoadDesc) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 5)
+	s = append(s, "&storegateway.LoadDesc{")
+	keysForInstances := make([]string, 0, len(this.Instances))
+	for k, _ := range this.Instances {
+		keysForInstances = append(keysForInstances, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Strings(keysForInstances)
+	mapStringForInstances := "map[string]InstanceLoad{"
+	for _, k := range keysForInstances {
+		mapStringForInstances += fmt.Sprintf("%#v: %#v,", k, this.Instances[k])
+	}
+	mapStringForInstances += "}"
+	if this.Instances != nil {
+		s = append(s, "Instances: "+mapStringForInstances+",\n")
+	}
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *InstanceLoad) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 7)
+	s = append(s, "&storegateway.InstanceLoad{")
+	s = append(s, "Load: "+fmt.Sprintf("%#v", this.Load)+",\n")
+	s = append(s, "Timestamp: "+fmt.Sprintf("%#v", this.Timestamp)+",\n")
+	keysForLoadedPlacements := make([]string, 0, len(this.LoadedPlacements))
+	for k, _ := range this.LoadedPlacements {
+		keysForLoadedPlacements = append(keysForLoadedPlacements, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Strings(keysForLoadedPlacements)
+	mapStringForLoadedPlacements := "map[string]PlacementVersion{"
+	for _, k := range keysForLoadedPlacements {
+		mapStringForLoadedPlacements += fmt.Sprintf("%#v: %#v,", k, this.LoadedPlacements[k])
+	}
+	mapStringForLoadedPlacements += "}"
+	if this.LoadedPlacements != nil {
+		s = append(s, "LoadedPlacements: "+mapStringForLoadedPlacements+",\n")
+	}
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *PlacementVersion) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&storegateway.PlacementVersion{")
+	s = append(s, "IndexUpdatedAt: "+fmt.Sprintf("%#v", this.IndexUpdatedAt)+",\n")
+	s = append(s, "InstancesHash: "+fmt.Sprintf("%#v", this.InstancesHash)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func valueToGoStringBalancedLoad(v interface{}, typ string) string {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return "nil"
+	}
+	pv := reflect.Indirect(rv).Interface()
+	return fmt.Sprintf("func(v %v) *%v { return &v } ( %#v )", typ, typ, pv)
+}
+func (m *LoadDesc) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *LoadDesc) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *LoadDesc) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Instances) > 0 {
+		for k := range m.Instances {
+			v := m.Instances[k]
+			baseI := i
+			{
+				size, err := (&v).MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintBalancedLoad(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x12
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintBalancedLoad(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintBalancedLoad(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *InstanceLoad) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *InstanceLoad) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *InstanceLoad) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.LoadedPlacements) > 0 {
+		for k := range m.LoadedPlacements {
+			v := m.LoadedPlacements[k]
+			baseI := i
+			{
+				size, err := (&v).MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintBalancedLoad(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x12
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintBalancedLoad(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintBalancedLoad(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if m.Timestamp != 0 {
+		i = encodeVarintBalancedLoad(dAtA, i, uint64(m.Timestamp))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Load != 0 {
+		i = encodeVarintBalancedLoad(dAtA, i, uint64(m.Load))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *PlacementVersion) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *PlacementVersion) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *PlacementVersion) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.InstancesHash != 0 {
+		i = encodeVarintBalancedLoad(dAtA, i, uint64(m.InstancesHash))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.IndexUpdatedAt != 0 {
+		i = encodeVarintBalancedLoad(dAtA, i, uint64(m.IndexUpdatedAt))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintBalancedLoad(dAtA []byte, offset int, v uint64) int {
+	offset -= sovBalancedLoad(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *LoadDesc) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Instances) > 0 {
+		for k, v := range m.Instances {
+			_ = k
+			_ = v
+			l = v.Size()
+			mapEntrySize := 1 + len(k) + sovBalancedLoad(uint64(len(k))) + 1 + l + sovBalancedLoad(uint64(l))
+			n += mapEntrySize + 1 + sovBalancedLoad(uint64(mapEntrySize))
+		}
+	}
+	return n
+}
+
+func (m *InstanceLoad) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Load != 0 {
+		n += 1 + sovBalancedLoad(uint64(m.Load))
+	}
+	if m.Timestamp != 0 {
+		n += 1 + sovBalancedLoad(uint64(m.Timestamp))
+	}
+	if len(m.LoadedPlacements) > 0 {
+		for k, v := range m.LoadedPlacements {
+			_ = k
+			_ = v
+			l = v.Size()
+			mapEntrySize := 1 + len(k) + sovBalancedLoad(uint64(len(k))) + 1 + l + sovBalancedLoad(uint64(l))
+			n += mapEntrySize + 1 + sovBalancedLoad(uint64(mapEntrySize))
+		}
+	}
+	return n
+}
+
+func (m *PlacementVersion) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.IndexUpdatedAt != 0 {
+		n += 1 + sovBalancedLoad(uint64(m.IndexUpdatedAt))
+	}
+	if m.InstancesHash != 0 {
+		n += 1 + sovBalancedLoad(uint64(m.InstancesHash))
+	}
+	return n
+}
+
+func sovBalancedLoad(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozBalancedLoad(x uint64) (n int) {
+	return sovBalancedLoad(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (this *LoadDesc) String() string {
+	if this == nil {
+		return "nil"
+	}
+	keysForInstances := make([]string, 0, len(this.Instances))
+	for k, _ := range this.Instances {
+		keysForInstances = append(keysForInstances, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Strings(keysForInstances)
+	mapStringForInstances := "map[string]InstanceLoad{"
+	for _, k := range keysForInstances {
+		mapStringForInstances += fmt.Sprintf("%v: %v,", k, this.Instances[k])
+	}
+	mapStringForInstances += "}"
+	s := strings.Join([]string{`&LoadDesc{`,
+		`Instances:` + mapStringForInstances + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *InstanceLoad) String() string {
+	if this == nil {
+		return "nil"
+	}
+	keysForLoadedPlacements := make([]string, 0, len(this.LoadedPlacements))
+	for k, _ := range this.LoadedPlacements {
+		keysForLoadedPlacements = append(keysForLoadedPlacements, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Strings(keysForLoadedPlacements)
+	mapStringForLoadedPlacements := "map[string]PlacementVersion{"
+	for _, k := range keysForLoadedPlacements {
+		mapStringForLoadedPlacements += fmt.Sprintf("%v: %v,", k, this.LoadedPlacements[k])
+	}
+	mapStringForLoadedPlacements += "}"
+	s := strings.Join([]string{`&InstanceLoad{`,
+		`Load:` + fmt.Sprintf("%v", this.Load) + `,`,
+		`Timestamp:` + fmt.Sprintf("%v", this.Timestamp) + `,`,
+		`LoadedPlacements:` + mapStringForLoadedPlacements + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *PlacementVersion) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&PlacementVersion{`,
+		`IndexUpdatedAt:` + fmt.Sprintf("%v", this.IndexUpdatedAt) + `,`,
+		`InstancesHash:` + fmt.Sprintf("%v", this.InstancesHash) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func valueToStringBalancedLoad(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return "nil"
+	}
+	pv := reflect.Indirect(rv).Interface()
+	return fmt.Sprintf("*%v", pv)
+}
+func (m *LoadDesc) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowBalancedLoad
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LoadDesc: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LoadDesc: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Instances", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Instances == nil {
+				m.Instances = make(map[string]InstanceLoad)
+			}
+			var mapkey string
+			mapvalue := &InstanceLoad{}
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowBalancedLoad
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowBalancedLoad
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var mapmsglen int
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowBalancedLoad
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						mapmsglen |= int(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					if mapmsglen < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					postmsgIndex := iNdEx + mapmsglen
+					if postmsgIndex < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					if postmsgIndex > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = &InstanceLoad{}
+					if err := mapvalue.Unmarshal(dAtA[iNdEx:postmsgIndex]); err != nil {
+						return err
+					}
+					iNdEx = postmsgIndex
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipBalancedLoad(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Instances[mapkey] = *mapvalue
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipBalancedLoad(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *InstanceLoad) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowBalancedLoad
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: InstanceLoad: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: InstanceLoad: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Load", wireType)
+			}
+			m.Load = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Load |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			m.Timestamp = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Timestamp |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LoadedPlacements", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.LoadedPlacements == nil {
+				m.LoadedPlacements = make(map[string]PlacementVersion)
+			}
+			var mapkey string
+			mapvalue := &PlacementVersion{}
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowBalancedLoad
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowBalancedLoad
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var mapmsglen int
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowBalancedLoad
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						mapmsglen |= int(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					if mapmsglen < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					postmsgIndex := iNdEx + mapmsglen
+					if postmsgIndex < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					if postmsgIndex > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = &PlacementVersion{}
+					if err := mapvalue.Unmarshal(dAtA[iNdEx:postmsgIndex]); err != nil {
+						return err
+					}
+					iNdEx = postmsgIndex
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipBalancedLoad(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthBalancedLoad
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.LoadedPlacements[mapkey] = *mapvalue
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipBalancedLoad(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PlacementVersion) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowBalancedLoad
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PlacementVersion: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PlacementVersion: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IndexUpdatedAt", wireType)
+			}
+			m.IndexUpdatedAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IndexUpdatedAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field InstancesHash", wireType)
+			}
+			m.InstancesHash = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.InstancesHash |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipBalancedLoad(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthBalancedLoad
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipBalancedLoad(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowBalancedLoad
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+			return iNdEx, nil
+		case 1:
+			iNdEx += 8
+			return iNdEx, nil
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowBalancedLoad
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthBalancedLoad
+			}
+			iNdEx += length
+			if iNdEx < 0 {
+				return 0, ErrInvalidLengthBalancedLoad
+			}
+			return iNdEx, nil
+		case 3:
+			for {
+				var innerWire uint64
+				var start int = iNdEx
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return 0, ErrIntOverflowBalancedLoad
+					}
+					if iNdEx >= l {
+						return 0, io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					innerWire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				innerWireType := int(innerWire & 0x7)
+				if innerWireType == 4 {
+					break
+				}
+				next, err := skipBalancedLoad(dAtA[start:])
+				if err != nil {
+					return 0, err
+				}
+				iNdEx = start + next
+				if iNdEx < 0 {
+					return 0, ErrInvalidLengthBalancedLoad
+				}
+			}
+			return iNdEx, nil
+		case 4:
+			return iNdEx, nil
+		case 5:
+			iNdEx += 4
+			return iNdEx, nil
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+	}
+	panic("unreachable")
+}
+
+var (
+	ErrInvalidLengthBalancedLoad = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowBalancedLoad   = fmt.Errorf("proto: integer overflow")
+)
@@ -0,0 +1,140 @@
+package storegateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestLoadDesc_Merge(t *testing.T) {
+	tests := map[string]struct {
+		local          map[string]InstanceLoad
+		incoming       map[string]InstanceLoad
+		expectedLocal  map[string]InstanceLoad
+		expectedChange map[string]InstanceLoad
+	}{
+		"should add the loads of new instances": {
+			local:          map[string]InstanceLoad{"127.0.0.1": {Load: 10, Timestamp: 1}},
+			incoming:       map[string]InstanceLoad{"127.0.0.2": {Load: 20, Timestamp: 1}},
+			expectedLocal:  map[string]InstanceLoad{"127.0.0.1": {Load: 10, Timestamp: 1}, "127.0.0.2": {Load: 20, Timestamp: 1}},
+			expectedChange: map[string]InstanceLoad{"127.0.0.2": {Load: 20, Timestamp: 1}},
+		},
+		"should keep the most recently published load": {
+			local:          map[string]InstanceLoad{"127.0.0.1": {Load: 10, Timestamp: 2}, "127.0.0.2": {Load: 20, Timestamp: 1}},
+			incoming:       map[string]InstanceLoad{"127.0.0.1": {Load: 30, Timestamp: 1}, "127.0.0.2": {Load: 40, Timestamp: 2}},
+			expectedLocal:  map[string]InstanceLoad{"127.0.0.1": {Load: 10, Timestamp: 2}, "127.0.0.2": {Load: 40, Timestamp: 2}},
+			expectedChange: map[string]InstanceLoad{"127.0.0.2": {Load: 40, Timestamp: 2}},
+		},
+		"should keep the highest load if published at the same time": {
+			local:          map[string]InstanceLoad{"127.0.0.1": {Load: 10, Timestamp: 1}},
+			incoming:       map[string]InstanceLoad{"127.0.0.1": {Load: 20, Timestamp: 1}},
+			expectedLocal:  map[string]InstanceLoad{"127.0.0.1": {Load: 20, Timestamp: 1}},
+			expectedChange: map[string]InstanceLoad{"127.0.0.1": {Load: 20, Timestamp: 1}},
+		},
+		"should return no change if nothing changed": {
+			local:         map[string]InstanceLoad{"127.0.0.1": {Load: 20, Timestamp: 1}},
+			incoming:      map[string]InstanceLoad{"127.0.0.1": {Load: 10, Timestamp: 1}},
+			expectedLocal: map[string]InstanceLoad{"127.0.0.1": {Load: 20, Timestamp: 1}},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			local := &LoadDesc{Instances: testData.local}
+			change, err := local.Merge(&LoadDesc{Instances: testData.incoming}, false)
+			require.NoError(t, err)
+
+			assert.Equal(t, testData.expectedLocal, local.Instances)
+			if testData.expectedChange == nil {
+				assert.Nil(t, change)
+			} else {
+				assert.Equal(t, &LoadDesc{Instances: testData.expectedChange}, change)
+			}
+
+			// Merging the same value again should not change anything.
+			change, err = local.Merge(&LoadDesc{Instances: testData.incoming}, false)
+			require.NoError(t, err)
+			assert.Nil(t, change)
+		})
+	}
+}
+
+func TestLoadDesc_RemoveTombstones(t *testing.T) {
+	now := time.Now()
+	desc := &LoadDesc{Instances: map[string]InstanceLoad{
+		"127.0.0.1": {Load: 10, Timestamp: now.Add(-time.Hour).Unix()},
+		"127.0.0.2": {Load: 20, Timestamp: now.Unix()},
+	}}
+
+	total, removed := desc.RemoveTombstones(time.Time{})
+	assert.Equal(t, 0, total)
+	assert.Equal(t, 0, removed)
+	assert.Len(t, desc.Instances, 2)
+
+	total, removed = desc.RemoveTombstones(now.Add(-time.Minute))
+	assert.Equal(t, 0, total)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, map[string]InstanceLoad{"127.0.0.2": {Load: 20, Timestamp: now.Unix()}}, desc.Instances)
+}
+
+func TestBalancedLoads(t *testing.T) {
+	ctx := context.Background()
+	store, closer := consul.NewInMemoryClient(GetLoadDescCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	// The load of an instance which has been auto-forgotten from the ring should be removed.
+	require.NoError(t, store.CAS(ctx, LoadKey, func(in interface{}) (interface{}, bool, error) {
+		desc := NewLoadDesc()
+		desc.Instances["127.0.0.3"] = InstanceLoad{Load: 100, Timestamp: time.Now().Add(-time.Hour).Unix()}
+		return desc, true, nil
+	}))
+
+	loads, err := ReadLoads(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"127.0.0.3": 100}, loads)
+
+	placement := PlacementVersion{IndexUpdatedAt: 10, InstancesHash: 1}
+
+	publisher := NewBalancedLoads(store, "127.0.0.1", 10*time.Millisecond, time.Minute, log.NewNopLogger())
+	publisher.setUserLoad("user-1", 1000)
+	publisher.setUserLoad("user-2", 20)
+	publisher.setLoadedPlacement("user-1", placement)
+	publisher.setLoadedPlacement("user-2", placement)
+	publisher.retainUsers([]string{"user-1"})
+	assert.Equal(t, balancedShardingCapacity(1000), publisher.InstanceLoad())
+
+	reader := NewBalancedLoads(store, "", 10*time.Millisecond, time.Minute, log.NewNopLogger())
+	require.NoError(t, services.StartAndAwaitRunning(ctx, reader))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(ctx, reader)) })
+
+	require.NoError(t, services.StartAndAwaitRunning(ctx, publisher))
+	t.Cleanup(func() { assert.NoError(t, services.StopAndAwaitTerminated(ctx, publisher)) })
+
+	test.Poll(t, 5*time.Second, true, func() interface{} {
+		return reader.HasLoaded("127.0.0.1", "user-1", placement)
+	})
+
+	loads, err = ReadLoads(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"127.0.0.1": balancedShardingCapacity(1000)}, loads)
+
+	// The placements computed from an older bucket index are covered too, while the ones
+	// computed from a more recent bucket index or from other store-gateways are not.
+	assert.True(t, reader.HasLoaded("127.0.0.1", "user-1", PlacementVersion{IndexUpdatedAt: 5, InstancesHash: 1}))
+	assert.False(t, reader.HasLoaded("127.0.0.1", "user-1", PlacementVersion{IndexUpdatedAt: 20, InstancesHash: 1}))
+	assert.False(t, reader.HasLoaded("127.0.0.1", "user-1", PlacementVersion{IndexUpdatedAt: 10, InstancesHash: 2}))
+	assert.False(t, reader.HasLoaded("127.0.0.1", "user-2", placement))
+	assert.False(t, reader.HasLoaded("127.0.0.2", "user-1", placement))
+
+	// A nil BalancedLoads has no loaded placements.
+	var nilLoads *BalancedLoads
+	assert.False(t, nilLoads.HasLoaded("127.0.0.1", "user-1", placement))
+}
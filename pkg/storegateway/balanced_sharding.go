@@ -0,0 +1,632 @@
+package storegateway
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/ring"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+)
+
+const (
+	// balancedShardingMaxLoadFactor is the maximum load of a store-gateway, relative to the average load
+	// rounded up by balancedShardingCapacity, above which a block is placed on the next store-gateway
+	// of its preference list.
+	balancedShardingMaxLoadFactor = 1.25
+
+	// balancedShardingCapacityBits is the number of most significant bits of the average load kept by
+	// balancedShardingCapacity: the average load is rounded up to steps of at most 1/4 of its value.
+	balancedShardingCapacityBits = 3
+
+	// balancedShardingIndexBytesPerSeries is the estimated index size of a series, used to compute the
+	// weight of the blocks whose index size is unknown when it can't be estimated from other blocks.
+	balancedShardingIndexBytesPerSeries = 256
+)
+
+var errBalancedShardingRequiresBucketIndex = errors.New("the balanced sharding strategy requires the bucket index to be enabled")
+
+// BalancedShardingStrategy is a sharding strategy, based on the hash ring formed by store-gateways, where
+// blocks are placed taking into account their size and the load of each store-gateway, both stored in the
+// bucket index. The placement is computed by AssignBlocksBalanced, which is deterministic and only depends
+// on the ring and the bucket index, so that queriers can find out the store-gateways owning a block without
+// any coordination. Like ShuffleShardingStrategy, the blocks of each tenant are placed on a subset of
+// store-gateway instances if a tenant shard size is configured.
+type BalancedShardingStrategy struct {
+	r            *ring.Ring
+	instanceID   string
+	instanceAddr string
+	limits       ShardingLimits
+	loads        *BalancedLoads
+	logger       log.Logger
+
+	// The placement of the blocks of each tenant computed by the last filtering, waiting for the blocks
+	// owned by the store-gateway to be loaded.
+	pendingMx sync.Mutex
+	pending   map[string]pendingPlacement
+}
+
+type pendingPlacement struct {
+	version PlacementVersion
+	owned   []ulid.ULID
+}
+
+// NewBalancedShardingStrategy makes a new BalancedShardingStrategy. The load of this store-gateway and
+// the placements for which it has loaded all the blocks it owns are set on the loads, which are also used
+// to find out whether the other store-gateways have loaded the blocks moved to them. If the loads are nil,
+// the blocks moved to other store-gateways are never unloaded.
+func NewBalancedShardingStrategy(r *ring.Ring, instanceID, instanceAddr string, limits ShardingLimits, loads *BalancedLoads, logger log.Logger) *BalancedShardingStrategy {
+	return &BalancedShardingStrategy{
+		r:            r,
+		instanceID:   instanceID,
+		instanceAddr: instanceAddr,
+		limits:       limits,
+		loads:        loads,
+		logger:       logger,
+		pending:      map[string]pendingPlacement{},
+	}
+}
+
+// FilterUsers implements ShardingStrategy.
+func (s *BalancedShardingStrategy) FilterUsers(_ context.Context, userIDs []string) []string {
+	var filteredIDs []string
+
+	for _, userID := range userIDs {
+		subRing := GetShuffleShardingSubring(s.r, userID, s.limits)
+
+		// Include the user only if it belongs to this store-gateway shard.
+		if subRing.HasInstance(s.instanceID) {
+			filteredIDs = append(filteredIDs, userID)
+		}
+	}
+
+	s.loads.retainUsers(filteredIDs)
+	return filteredIDs
+}
+
+// BlocksSynced implements ShardingStrategyWithSyncedBlocks. Once all the blocks owned by the store-gateway
+// according to the last placement of the blocks of the tenant have been loaded, the placement is published,
+// so that the previous owners of the blocks can unload them.
+func (s *BalancedShardingStrategy) BlocksSynced(userID string, isLoaded func(ulid.ULID) bool) {
+	s.pendingMx.Lock()
+	pending, ok := s.pending[userID]
+	delete(s.pending, userID)
+	s.pendingMx.Unlock()
+
+	if !ok {
+		return
+	}
+
+	for _, blockID := range pending.owned {
+		if !isLoaded(blockID) {
+			return
+		}
+	}
+
+	s.loads.setLoadedPlacement(userID, pending.version)
+}
+
+// FilterBlocks implements ShardingStrategy. The blocks placement requires the bucket index, so
+// this function always fails: blocks are filtered by FilterBlocksWithBucketIndex() instead.
+func (s *BalancedShardingStrategy) FilterBlocks(_ context.Context, _ string, _ map[ulid.ULID]*metadata.Meta, _ map[ulid.ULID]struct{}, _ *extprom.TxGaugeVec) error {
+	return errBalancedShardingRequiresBucketIndex
+}
+
+// FilterBlocksWithBucketIndex implements ShardingStrategyWithBucketIndex.
+func (s *BalancedShardingStrategy) FilterBlocksWithBucketIndex(_ context.Context, userID string, metas map[ulid.ULID]*metadata.Meta, idx *bucketindex.Index, loaded map[ulid.ULID]struct{}, synced *extprom.TxGaugeVec) error {
+	subRing := GetShuffleShardingSubring(s.r, userID, s.limits)
+
+	assignment, err := AssignBlocksBalanced(subRing, idx)
+	if err != nil {
+		// If an error occurs while checking the ring, we keep the previously loaded blocks.
+		level.Warn(s.logger).Log("msg", "failed to compute blocks placement, previously loaded blocks are kept", "user", userID, "err", err)
+
+		for blockID := range metas {
+			if _, ok := loaded[blockID]; !ok {
+				synced.WithLabelValues(shardExcludedMeta).Inc()
+				delete(metas, blockID)
+			}
+		}
+
+		return nil
+	}
+
+	s.loads.setUserLoad(userID, assignment.getHashLoad(s.instanceAddr))
+
+	var owned []ulid.ULID
+	for blockID := range metas {
+		owners := assignment.GetOwners(blockID)
+
+		// Keep the block if it is owned by the store-gateway.
+		if containsInstanceAddr(owners, s.instanceAddr) {
+			owned = append(owned, blockID)
+			continue
+		}
+
+		// The block is not owned by the store-gateway. However, if it's currently loaded
+		// we can safely unload it only once at least 1 owner has loaded it, because
+		// queriers may keep querying the previous owners until then.
+		if _, ok := loaded[blockID]; ok && !s.isLoadedByAnyOwner(userID, owners, assignment.Version()) {
+			continue
+		}
+
+		synced.WithLabelValues(shardExcludedMeta).Inc()
+		delete(metas, blockID)
+	}
+
+	s.pendingMx.Lock()
+	s.pending[userID] = pendingPlacement{version: assignment.Version(), owned: owned}
+	s.pendingMx.Unlock()
+
+	return nil
+}
+
+// isLoadedByAnyOwner returns whether any of the owners has published that it has loaded
+// all the blocks it owns according to the placement.
+func (s *BalancedShardingStrategy) isLoadedByAnyOwner(userID string, owners []ring.InstanceDesc, placement PlacementVersion) bool {
+	for _, owner := range owners {
+		if s.loads.HasLoaded(owner.Addr, userID, placement) {
+			return true
+		}
+	}
+	return false
+}
+
+// BalancedAssignment is the placement of the blocks of a tenant across store-gateways,
+// computed by AssignBlocksBalanced.
+type BalancedAssignment struct {
+	// Instances sorted by address.
+	instances []ring.InstanceDesc
+
+	// The tokens of all instances, sorted.
+	tokens []instanceToken
+
+	// The version of the placement, identifying the inputs it has been computed from.
+	version PlacementVersion
+
+	// The load published by each instance, used to compute the assignment.
+	published []int64
+
+	// The indexes of the instances owning each block.
+	owners map[ulid.ULID][]int
+
+	// The sum of the weights of the blocks owned by each instance before balancing.
+	hashLoads []int64
+
+	// The load of each instance after balancing, which is the sum of the weights of the blocks
+	// assigned to it plus the load of the blocks of the other tenants.
+	loads []int64
+}
+
+type instanceToken struct {
+	token    uint32
+	instance int
+}
+
+// AssignBlocksBalanced places the blocks of the input bucket index on the healthy instances of the ring. This
+// function should be used both by store-gateway and querier in order to guarantee the same placement is computed.
+// The placement only depends on the ring and the bucket index, so store-gateways and queriers compute the same
+// placement as long as they see the same instances in the ring and the same version of the bucket index.
+//
+// Each block has a preference list of instances, which is the list of instances found walking the ring
+// clockwise starting from the block hash. First, each block is placed on the first instances of its preference
+// list, like the default sharding strategy does. Then, the blocks of the instances whose load exceeds the
+// max load are moved, newest first, to the next instances of their preference list with enough spare capacity,
+// until the load of the instance doesn't exceed the max load anymore. The instances whose load doesn't exceed
+// the max load keep all their blocks, so the placement only changes when the load of an instance changes
+// from one side of the max load to the other, and only for the blocks in excess.
+//
+// The weight of a block is its index size, estimated from its number of series if unknown. The load of an
+// instance is the sum of the weights of the tenant blocks placed on it plus the load of the blocks of the other
+// tenants, which is the load of the instance stored in the bucket index (see BalancedLoads) minus the weights of
+// the tenant blocks owned by the instance before balancing. The max load is the average load of the instances,
+// rounded up by balancedShardingCapacity, times balancedShardingMaxLoadFactor. When an instance joins or leaves
+// the ring, the blocks whose preference list changes are moved like the default sharding strategy does, while
+// the other blocks are moved only if the max load change makes an instance cross it, so the placement is mostly
+// stable.
+func AssignBlocksBalanced(r ring.ReadRing, idx *bucketindex.Index) (*BalancedAssignment, error) {
+	set, err := r.GetAllHealthy(BlocksOwnerSync)
+	if err != nil {
+		return nil, err
+	}
+
+	a := assignBlocksBalanced(set.Instances, r.ReplicationFactor(), idx.Blocks, idx.StoreGatewayLoads)
+	a.version.IndexUpdatedAt = idx.UpdatedAt
+	return a, nil
+}
+
+func assignBlocksBalanced(instances []ring.InstanceDesc, replicationFactor int, blocks bucketindex.Blocks, loads map[string]int64) *BalancedAssignment {
+	instances = append([]ring.InstanceDesc(nil), instances...)
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Addr < instances[j].Addr
+	})
+
+	a := &BalancedAssignment{
+		instances: instances,
+		version:   PlacementVersion{InstancesHash: hashInstances(instances)},
+		published: make([]int64, len(instances)),
+		owners:    make(map[ulid.ULID][]int, len(blocks)),
+		hashLoads: make([]int64, len(instances)),
+		loads:     make([]int64, len(instances)),
+	}
+
+	for i, instance := range instances {
+		a.published[i] = loads[instance.Addr]
+
+		for _, token := range instance.Tokens {
+			a.tokens = append(a.tokens, instanceToken{token: token, instance: i})
+		}
+	}
+	sort.Slice(a.tokens, func(i, j int) bool {
+		if a.tokens[i].token != a.tokens[j].token {
+			return a.tokens[i].token < a.tokens[j].token
+		}
+		return a.tokens[i].instance < a.tokens[j].instance
+	})
+
+	if len(instances) == 0 {
+		return a
+	}
+
+	if replicationFactor > len(instances) {
+		replicationFactor = len(instances)
+	}
+	if replicationFactor < 1 {
+		replicationFactor = 1
+	}
+	zoneAware := isZoneAwarePlacementPossible(instances, replicationFactor)
+
+	// canOwn returns whether the instance can own the block in place of the replaced owner, if any.
+	canOwn := func(owners []int, replaced, instance int) bool {
+		for _, owner := range owners {
+			if owner == instance {
+				return false
+			}
+			if zoneAware && owner != replaced && instances[owner].Zone == instances[instance].Zone {
+				return false
+			}
+		}
+		return true
+	}
+
+	sorted := append(bucketindex.Blocks(nil), blocks...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID.Compare(sorted[j].ID) < 0
+	})
+	weights := balancedBlockWeights(sorted)
+
+	// Place each block on the first instances of its preference list.
+	for i, b := range sorted {
+		owners := make([]int, 0, replicationFactor)
+
+		it := a.newPreferenceIterator(b.ID)
+		for len(owners) < replicationFactor {
+			instance, ok := it.next()
+			if !ok {
+				break
+			}
+			if canOwn(owners, -1, instance) {
+				owners = append(owners, instance)
+			}
+		}
+
+		for _, instance := range owners {
+			a.hashLoads[instance] += weights[i]
+		}
+		a.owners[b.ID] = owners
+	}
+
+	// The load of the blocks of the other tenants can't be negative, but the published load may
+	// have been computed from a different version of the bucket index.
+	totalLoad := int64(0)
+	for i := range instances {
+		otherLoad := a.published[i] - a.hashLoads[i]
+		if otherLoad < 0 {
+			otherLoad = 0
+		}
+
+		a.loads[i] = otherLoad + a.hashLoads[i]
+		totalLoad += a.loads[i]
+	}
+
+	capacity := balancedShardingCapacity(totalLoad / int64(len(instances)))
+	maxLoad := int64(math.Ceil(balancedShardingMaxLoadFactor * float64(capacity)))
+
+	// Find the instances exceeding the max load, which are the only ones moving blocks away.
+	overloaded := make([]bool, len(instances))
+	for i := range instances {
+		overloaded[i] = a.loads[i] > maxLoad
+	}
+
+	// Move the newest blocks first, so that adding a block to an instance exceeding the
+	// max load moves the new block only.
+	for i := len(sorted) - 1; i >= 0; i-- {
+		b, weight := sorted[i], weights[i]
+		owners := a.owners[b.ID]
+
+		for pos, owner := range owners {
+			if !overloaded[owner] || a.loads[owner] <= maxLoad {
+				continue
+			}
+
+			// Move the block to the next instance of its preference list with enough spare capacity.
+			it := a.newPreferenceIterator(b.ID)
+			for {
+				instance, ok := it.next()
+				if !ok {
+					break
+				}
+				if overloaded[instance] || a.loads[instance]+weight > maxLoad || !canOwn(owners, owner, instance) {
+					continue
+				}
+
+				owners[pos] = instance
+				a.loads[owner] -= weight
+				a.loads[instance] += weight
+				break
+			}
+		}
+	}
+
+	return a
+}
+
+// GetOwners returns the instances owning the input block. The returned slice is empty
+// if the block was not part of the assignment.
+func (a *BalancedAssignment) GetOwners(blockID ulid.ULID) []ring.InstanceDesc {
+	owners := a.owners[blockID]
+
+	out := make([]ring.InstanceDesc, 0, len(owners))
+	for _, instance := range owners {
+		out = append(out, a.instances[instance])
+	}
+	return out
+}
+
+// GetCandidates returns the instances owning the input block followed by the other instances
+// of its preference list, which can be queried if the owners are not available or have not
+// loaded the block yet (eg. the assignment was computed from a different bucket index).
+func (a *BalancedAssignment) GetCandidates(blockID ulid.ULID) []ring.InstanceDesc {
+	owners := a.owners[blockID]
+
+	out := make([]ring.InstanceDesc, 0, len(a.instances))
+	for _, instance := range owners {
+		out = append(out, a.instances[instance])
+	}
+	for _, instance := range a.newPreferenceIterator(blockID).all() {
+		if !containsInt(owners, instance) {
+			out = append(out, a.instances[instance])
+		}
+	}
+	return out
+}
+
+// HasInstances returns whether the assignment has been computed for the input instances.
+func (a *BalancedAssignment) HasInstances(instances []ring.InstanceDesc) bool {
+	if len(instances) != len(a.instances) {
+		return false
+	}
+
+	sorted := append([]ring.InstanceDesc(nil), instances...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Addr < sorted[j].Addr
+	})
+
+	for i, instance := range sorted {
+		other := a.instances[i]
+		if instance.Addr != other.Addr || instance.Zone != other.Zone || instance.State != other.State || !equalTokens(instance.Tokens, other.Tokens) {
+			return false
+		}
+	}
+	return true
+}
+
+// Version returns the version of the placement, which identifies the inputs it has been computed from.
+func (a *BalancedAssignment) Version() PlacementVersion {
+	return a.version
+}
+
+// getHashLoad returns the sum of the weights of the blocks owned by the input instance before balancing.
+func (a *BalancedAssignment) getHashLoad(addr string) int64 {
+	for i, instance := range a.instances {
+		if instance.Addr == addr {
+			return a.hashLoads[i]
+		}
+	}
+	return 0
+}
+
+// preferenceIterator walks the ring clockwise starting from a block hash,
+// returning each instance the first time one of its tokens is found.
+type preferenceIterator struct {
+	a       *BalancedAssignment
+	start   int
+	offset  int
+	seen    []bool
+	visited []int
+}
+
+func (a *BalancedAssignment) newPreferenceIterator(blockID ulid.ULID) *preferenceIterator {
+	key := cortex_tsdb.HashBlockID(blockID)
+
+	// Like the ring, the owner of a key is the instance with the first token greater than the key.
+	start := sort.Search(len(a.tokens), func(i int) bool {
+		return a.tokens[i].token > key
+	})
+
+	return &preferenceIterator{
+		a:       a,
+		start:   start,
+		seen:    make([]bool, len(a.instances)),
+		visited: make([]int, 0, len(a.instances)),
+	}
+}
+
+func (it *preferenceIterator) next() (int, bool) {
+	for ; it.offset < len(it.a.tokens) && len(it.visited) < len(it.a.instances); it.offset++ {
+		instance := it.a.tokens[(it.start+it.offset)%len(it.a.tokens)].instance
+		if !it.seen[instance] {
+			it.seen[instance] = true
+			it.visited = append(it.visited, instance)
+			it.offset++
+			return instance, true
+		}
+	}
+
+	// Instances without tokens come last, in address order.
+	for instance := range it.a.instances {
+		if !it.seen[instance] {
+			it.seen[instance] = true
+			it.visited = append(it.visited, instance)
+			return instance, true
+		}
+	}
+
+	return 0, false
+}
+
+// all returns the whole preference list.
+func (it *preferenceIterator) all() []int {
+	for {
+		if _, ok := it.next(); !ok {
+			return it.visited
+		}
+	}
+}
+
+// balancedBlockWeights returns the weight of each input block, which is its index size. If the index size
+// is unknown, it's estimated from the number of series and, if unknown too, the average weight is used.
+func balancedBlockWeights(blocks bucketindex.Blocks) []int64 {
+	// Estimate the index size of a series from the blocks for which both are known.
+	var knownSize, knownSeries uint64
+	for _, b := range blocks {
+		if b.IndexSize > 0 && b.NumSeries > 0 {
+			knownSize += uint64(b.IndexSize)
+			knownSeries += b.NumSeries
+		}
+	}
+
+	bytesPerSeries := float64(balancedShardingIndexBytesPerSeries)
+	if knownSeries > 0 {
+		bytesPerSeries = float64(knownSize) / float64(knownSeries)
+	}
+
+	weights := make([]int64, len(blocks))
+	knownWeight, knownCount := int64(0), int64(0)
+	for i, b := range blocks {
+		switch {
+		case b.IndexSize > 0:
+			weights[i] = b.IndexSize
+		case b.NumSeries > 0:
+			weights[i] = int64(math.Ceil(float64(b.NumSeries) * bytesPerSeries))
+		default:
+			continue
+		}
+
+		knownWeight += weights[i]
+		knownCount++
+	}
+
+	defaultWeight := int64(1)
+	if knownCount > 0 && knownWeight >= knownCount {
+		defaultWeight = knownWeight / knownCount
+	}
+
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = defaultWeight
+		}
+	}
+
+	return weights
+}
+
+// balancedShardingCapacity returns the input average load rounded up to its balancedShardingCapacityBits
+// most significant bits. Since the max load changes only when the rounded average load does, the blocks
+// added or deleted over time don't change the placement of the other blocks until the total weight of the
+// tenant blocks changes by a large enough amount.
+func balancedShardingCapacity(avgLoad int64) int64 {
+	shift := bits.Len64(uint64(avgLoad)) - balancedShardingCapacityBits
+	if avgLoad <= 0 || shift <= 0 {
+		return avgLoad
+	}
+
+	step := int64(1) << shift
+	return (avgLoad + step - 1) / step * step
+}
+
+// hashInstances returns the hash of the addresses, zones and tokens of the input instances, sorted by address.
+func hashInstances(instances []ring.InstanceDesc) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 4)
+
+	for _, instance := range instances {
+		_, _ = h.Write([]byte(instance.Addr))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(instance.Zone))
+		_, _ = h.Write([]byte{0})
+
+		for _, token := range instance.Tokens {
+			binary.BigEndian.PutUint32(buf, token)
+			_, _ = h.Write(buf)
+		}
+		_, _ = h.Write([]byte{0})
+	}
+
+	return h.Sum64()
+}
+
+// isZoneAwarePlacementPossible returns whether the replicas of each block can be placed in different zones.
+func isZoneAwarePlacementPossible(instances []ring.InstanceDesc, replicationFactor int) bool {
+	zones := map[string]struct{}{}
+	for _, instance := range instances {
+		if instance.Zone == "" {
+			return false
+		}
+		zones[instance.Zone] = struct{}{}
+	}
+
+	return replicationFactor > 1 && len(zones) >= replicationFactor
+}
+
+func containsInstanceAddr(instances []ring.InstanceDesc, addr string) bool {
+	for _, instance := range instances {
+		if instance.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func equalTokens(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,565 @@
+package storegateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/services"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extprom"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
+)
+
+func TestBalancedShardingStrategy(t *testing.T) {
+	// The following block IDs have been picked to have increasing hash values
+	// in order to simplify the tests.
+	block1 := ulid.MustNew(1, nil) // hash: 283204220
+	block2 := ulid.MustNew(2, nil) // hash: 444110359
+	block3 := ulid.MustNew(5, nil) // hash: 2931974232
+	block4 := ulid.MustNew(6, nil) // hash: 3092880371
+
+	block2Hash := cortex_tsdb.HashBlockID(block2)
+	block4Hash := cortex_tsdb.HashBlockID(block4)
+
+	// By ring token, block1 and block2 belong to instance-1 while block3 and block4 belong to instance-2.
+	// Given block1 and block2 are much bigger than the others, one of them is placed on instance-2.
+	idx := &bucketindex.Index{
+		Blocks: bucketindex.Blocks{
+			{ID: block1, IndexSize: 100},
+			{ID: block2, IndexSize: 100},
+			{ID: block3, IndexSize: 10},
+			{ID: block4, IndexSize: 10},
+		},
+		UpdatedAt: 100,
+	}
+
+	registeredAt := time.Now()
+
+	tests := map[string]struct {
+		replicationFactor int
+		setupRing         func(*ring.Desc)
+		loaded            map[string][]ulid.ULID
+		hasLoaded         map[string]bool
+		expectedBlocks    map[string][]ulid.ULID
+		expectedLoads     map[string]int64
+	}{
+		"two ACTIVE instances in the ring with replication factor = 1": {
+			replicationFactor: 1,
+			setupRing: func(r *ring.Desc) {
+				r.AddIngester("instance-1", "127.0.0.1", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				r.AddIngester("instance-2", "127.0.0.2", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+			},
+			expectedBlocks: map[string][]ulid.ULID{
+				"127.0.0.1": {block1},
+				"127.0.0.2": {block2, block3, block4},
+			},
+			expectedLoads: map[string]int64{
+				"127.0.0.1": balancedShardingCapacity(200),
+				"127.0.0.2": balancedShardingCapacity(20),
+			},
+		},
+		"two ACTIVE instances in the ring with replication factor = 2": {
+			replicationFactor: 2,
+			setupRing: func(r *ring.Desc) {
+				r.AddIngester("instance-1", "127.0.0.1", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				r.AddIngester("instance-2", "127.0.0.2", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+			},
+			expectedBlocks: map[string][]ulid.ULID{
+				"127.0.0.1": {block1, block2, block3, block4},
+				"127.0.0.2": {block1, block2, block3, block4},
+			},
+			expectedLoads: map[string]int64{
+				"127.0.0.1": balancedShardingCapacity(220),
+				"127.0.0.2": balancedShardingCapacity(220),
+			},
+		},
+		"a previously loaded block should be kept until its new owner has loaded it": {
+			replicationFactor: 1,
+			setupRing: func(r *ring.Desc) {
+				r.AddIngester("instance-1", "127.0.0.1", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				r.AddIngester("instance-2", "127.0.0.2", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+			},
+			loaded: map[string][]ulid.ULID{
+				"127.0.0.1": {block1, block2},
+			},
+			expectedBlocks: map[string][]ulid.ULID{
+				"127.0.0.1": {block1, block2},
+				"127.0.0.2": {block2, block3, block4},
+			},
+			expectedLoads: map[string]int64{
+				"127.0.0.1": balancedShardingCapacity(200),
+				"127.0.0.2": balancedShardingCapacity(20),
+			},
+		},
+		"a previously loaded block should be unloaded once its new owner has loaded it": {
+			replicationFactor: 1,
+			setupRing: func(r *ring.Desc) {
+				r.AddIngester("instance-1", "127.0.0.1", "", []uint32{block2Hash + 1}, ring.ACTIVE, registeredAt)
+				r.AddIngester("instance-2", "127.0.0.2", "", []uint32{block4Hash + 1}, ring.ACTIVE, registeredAt)
+			},
+			loaded: map[string][]ulid.ULID{
+				"127.0.0.1": {block1, block2},
+			},
+			hasLoaded: map[string]bool{
+				"127.0.0.2": true,
+			},
+			expectedBlocks: map[string][]ulid.ULID{
+				"127.0.0.1": {block1},
+				"127.0.0.2": {block2, block3, block4},
+			},
+			expectedLoads: map[string]int64{
+				"127.0.0.1": balancedShardingCapacity(200),
+				"127.0.0.2": balancedShardingCapacity(20),
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		testName := testName
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store, closer := consul.NewInMemoryClient(ring.GetCodec(), log.NewNopLogger(), nil)
+			t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+			// Initialize the ring state.
+			require.NoError(t, store.CAS(ctx, "test", func(in interface{}) (interface{}, bool, error) {
+				d := ring.NewDesc()
+				testData.setupRing(d)
+				return d, true, nil
+			}))
+
+			cfg := ring.Config{
+				ReplicationFactor: testData.replicationFactor,
+				HeartbeatTimeout:  time.Minute,
+			}
+
+			r, err := ring.NewWithStoreClientAndStrategy(cfg, "test", "test", store, ring.NewIgnoreUnhealthyInstancesReplicationStrategy(), nil, nil)
+			require.NoError(t, err)
+			require.NoError(t, services.StartAndAwaitRunning(ctx, r))
+			defer services.StopAndAwaitTerminated(ctx, r) //nolint:errcheck
+
+			// Wait until the ring client has synced.
+			require.NoError(t, ring.WaitInstanceState(ctx, r, "instance-1", ring.ACTIVE))
+
+			assignment, err := AssignBlocksBalanced(r, idx)
+			require.NoError(t, err)
+
+			// Mock the placements published by the other instances.
+			published := map[string]InstanceLoad{}
+			for instanceAddr, hasLoaded := range testData.hasLoaded {
+				if hasLoaded {
+					published[instanceAddr] = InstanceLoad{LoadedPlacements: map[string]PlacementVersion{"user-1": assignment.Version()}}
+				}
+			}
+
+			for instanceAddr, expectedBlocks := range testData.expectedBlocks {
+				loads := NewBalancedLoads(nil, instanceAddr, time.Second, time.Minute, log.NewNopLogger())
+				loads.updateLoads(&LoadDesc{Instances: published})
+				filter := NewBalancedShardingStrategy(r, "", instanceAddr, &shardingLimitsMock{}, loads, log.NewNopLogger())
+				synced := extprom.NewTxGaugeVec(nil, prometheus.GaugeOpts{}, []string{"state"})
+				synced.WithLabelValues(shardExcludedMeta).Set(0)
+
+				metas := map[ulid.ULID]*metadata.Meta{}
+				for _, b := range idx.Blocks {
+					metas[b.ID] = b.ThanosMeta("user-1")
+				}
+
+				loaded := map[ulid.ULID]struct{}{}
+				for _, blockID := range testData.loaded[instanceAddr] {
+					loaded[blockID] = struct{}{}
+				}
+
+				err = filter.FilterBlocksWithBucketIndex(ctx, "user-1", metas, idx, loaded, synced)
+				require.NoError(t, err)
+
+				var actualBlocks []ulid.ULID
+				for id := range metas {
+					actualBlocks = append(actualBlocks, id)
+				}
+
+				assert.ElementsMatch(t, expectedBlocks, actualBlocks)
+
+				// Assert on the metric used to keep track of the blocks filtered out.
+				synced.Submit()
+				assert.Equal(t, float64(len(idx.Blocks)-len(expectedBlocks)), testutil.ToFloat64(synced))
+
+				// The load of the instance is the load of the blocks it owns before balancing.
+				assert.Equal(t, testData.expectedLoads[instanceAddr], loads.InstanceLoad())
+
+				// The placement is published only once all the owned blocks have been loaded.
+				filter.BlocksSynced("user-1", func(ulid.ULID) bool { return false })
+				assert.Empty(t, loads.getLoadedPlacements())
+
+				require.NoError(t, filter.FilterBlocksWithBucketIndex(ctx, "user-1", metas, idx, loaded, synced))
+				filter.BlocksSynced("user-1", func(ulid.ULID) bool { return true })
+				assert.Equal(t, map[string]PlacementVersion{"user-1": assignment.Version()}, loads.getLoadedPlacements())
+			}
+		})
+	}
+}
+
+func TestBalancedShardingStrategy_FilterBlocksShouldFailWithoutBucketIndex(t *testing.T) {
+	s := NewBalancedShardingStrategy(nil, "instance-1", "127.0.0.1", &shardingLimitsMock{}, nil, log.NewNopLogger())
+	synced := extprom.NewTxGaugeVec(nil, prometheus.GaugeOpts{}, []string{"state"})
+
+	err := s.FilterBlocks(context.Background(), "user-1", map[ulid.ULID]*metadata.Meta{}, map[ulid.ULID]struct{}{}, synced)
+	assert.Equal(t, errBalancedShardingRequiresBucketIndex, err)
+}
+
+func TestAssignBlocksBalanced_ShouldBeDeterministic(t *testing.T) {
+	instances, blocks := generateBalancedShardingInput(rand.New(rand.NewSource(1)), 5, 200)
+	expected := assignBlocksBalanced(instances, 2, blocks, nil)
+
+	for n := 0; n < 10; n++ {
+		// The order of the instances and blocks should not matter.
+		rand.Shuffle(len(instances), func(i, j int) { instances[i], instances[j] = instances[j], instances[i] })
+		rand.Shuffle(len(blocks), func(i, j int) { blocks[i], blocks[j] = blocks[j], blocks[i] })
+
+		actual := assignBlocksBalanced(instances, 2, blocks, nil)
+		for _, b := range blocks {
+			assert.Equal(t, expected.GetOwners(b.ID), actual.GetOwners(b.ID))
+			assert.Equal(t, expected.GetCandidates(b.ID), actual.GetCandidates(b.ID))
+		}
+	}
+}
+
+func TestAssignBlocksBalanced_ShouldBoundTheLoadOfEachInstance(t *testing.T) {
+	const numInstances = 6
+
+	for _, replicationFactor := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("replication factor = %d", replicationFactor), func(t *testing.T) {
+			instances, blocks := generateBalancedShardingInput(rand.New(rand.NewSource(2)), numInstances, 500)
+			a := assignBlocksBalanced(instances, replicationFactor, blocks, nil)
+
+			totalWeight, maxWeight := int64(0), int64(0)
+			for _, b := range blocks {
+				totalWeight += b.IndexSize
+				if b.IndexSize > maxWeight {
+					maxWeight = b.IndexSize
+				}
+
+				owners := a.GetOwners(b.ID)
+				assert.Len(t, owners, replicationFactor)
+
+				// Replicas should be placed on different zones.
+				zones := map[string]struct{}{}
+				for _, owner := range owners {
+					zones[owner.Zone] = struct{}{}
+				}
+				assert.Len(t, zones, replicationFactor)
+			}
+
+			maxLoad := balancedShardingMaxLoadFactor * float64(balancedShardingCapacity(totalWeight*int64(replicationFactor)/numInstances))
+			for _, load := range a.loads {
+				assert.LessOrEqual(t, float64(load), maxLoad+float64(maxWeight))
+			}
+		})
+	}
+}
+
+func TestAssignBlocksBalanced_ShouldNotChangeThePlacementOfOlderBlocksWhenNewBlocksAreAdded(t *testing.T) {
+	instances, blocks := generateBalancedShardingInput(rand.New(rand.NewSource(3)), 6, 500)
+	before := assignBlocksBalanced(instances, 2, blocks, nil)
+
+	// Add a few blocks, not changing the total weight enough to change the max load.
+	added := append(bucketindex.Blocks(nil), blocks...)
+	for i := 0; i < 5; i++ {
+		added = append(added, &bucketindex.Block{ID: ulid.MustNew(uint64(len(blocks)+i), nil), IndexSize: 100})
+	}
+	after := assignBlocksBalanced(instances, 2, added, nil)
+
+	for _, b := range blocks {
+		assert.Equal(t, before.GetOwners(b.ID), after.GetOwners(b.ID))
+	}
+}
+
+func TestAssignBlocksBalanced_ShouldChangeFewPlacementsWhenABlockIsRemoved(t *testing.T) {
+	const numBlocks = 200
+
+	instances, blocks := generateBalancedShardingInput(rand.New(rand.NewSource(4)), 6, numBlocks)
+	before := assignBlocksBalanced(instances, 2, blocks, nil)
+
+	// Remove each block in turn, counting the placements of the other blocks which change.
+	// Queriers and store-gateways computing the placement from different bucket index versions
+	// disagree on the owners of these blocks only.
+	totalChanged, maxChanged := 0, 0
+	for removed := range blocks {
+		remaining := append(append(bucketindex.Blocks(nil), blocks[:removed]...), blocks[removed+1:]...)
+		after := assignBlocksBalanced(instances, 2, remaining, nil)
+
+		changed := 0
+		for _, b := range remaining {
+			if !assert.ObjectsAreEqual(before.GetOwners(b.ID), after.GetOwners(b.ID)) {
+				changed++
+			}
+		}
+
+		totalChanged += changed
+		if changed > maxChanged {
+			maxChanged = changed
+		}
+	}
+
+	t.Logf("placements changed when removing a block: avg %.2f, max %d (out of %d blocks)", float64(totalChanged)/numBlocks, maxChanged, numBlocks-1)
+	assert.Less(t, totalChanged, numBlocks)
+	assert.LessOrEqual(t, maxChanged, numBlocks/10)
+}
+
+func TestBalancedShardingCapacity(t *testing.T) {
+	for input, expected := range map[int64]int64{
+		0:    0,
+		1:    1,
+		7:    7,
+		8:    8,
+		9:    10,
+		1000: 1024,
+		1023: 1024,
+		1025: 1280,
+	} {
+		assert.Equal(t, expected, balancedShardingCapacity(input), "input: %d", input)
+	}
+}
+
+func TestAssignBlocksBalanced_ShouldPlaceBlocksOnRingOwnersWhenBalanced(t *testing.T) {
+	block1 := ulid.MustNew(1, nil) // hash: 283204220
+	block2 := ulid.MustNew(2, nil) // hash: 444110359
+
+	instances := []ring.InstanceDesc{
+		{Addr: "127.0.0.1", Tokens: []uint32{cortex_tsdb.HashBlockID(block1) + 1}, State: ring.ACTIVE},
+		{Addr: "127.0.0.2", Tokens: []uint32{cortex_tsdb.HashBlockID(block2) + 1}, State: ring.JOINING},
+	}
+	blocks := bucketindex.Blocks{{ID: block1}, {ID: block2}}
+
+	a := assignBlocksBalanced(instances, 1, blocks, nil)
+	assert.Equal(t, []ring.InstanceDesc{instances[0]}, a.GetOwners(block1))
+	assert.Equal(t, []ring.InstanceDesc{instances[1]}, a.GetOwners(block2))
+	assert.Equal(t, []ring.InstanceDesc{instances[1], instances[0]}, a.GetCandidates(block2))
+
+	// A block which is not part of the assignment has no owners.
+	assert.Empty(t, a.GetOwners(ulid.MustNew(3, nil)))
+
+	assert.True(t, a.HasInstances([]ring.InstanceDesc{instances[1], instances[0]}))
+	assert.False(t, a.HasInstances(instances[:1]))
+	assert.False(t, a.HasInstances([]ring.InstanceDesc{instances[0], {Addr: "127.0.0.2", Tokens: instances[1].Tokens, State: ring.ACTIVE}}))
+
+	// The version of the placement depends on the instances, regardless of their order and state.
+	assert.Equal(t, a.Version(), assignBlocksBalanced([]ring.InstanceDesc{instances[1], instances[0]}, 1, blocks, nil).Version())
+	assert.Equal(t, a.Version(), assignBlocksBalanced([]ring.InstanceDesc{instances[0], {Addr: "127.0.0.2", Tokens: instances[1].Tokens, State: ring.ACTIVE}}, 1, blocks, nil).Version())
+	assert.NotEqual(t, a.Version(), assignBlocksBalanced(instances[:1], 1, blocks, nil).Version())
+	assert.NotEqual(t, a.Version(), assignBlocksBalanced([]ring.InstanceDesc{instances[0], {Addr: "127.0.0.2", Tokens: []uint32{1}}}, 1, blocks, nil).Version())
+}
+
+func TestAssignBlocksBalanced_ShouldTakeIntoAccountThePublishedLoads(t *testing.T) {
+	block1 := ulid.MustNew(1, nil) // hash: 283204220
+	block2 := ulid.MustNew(2, nil) // hash: 444110359
+	block3 := ulid.MustNew(5, nil) // hash: 2931974232
+	block4 := ulid.MustNew(6, nil) // hash: 3092880371
+
+	// By ring token, block1 and block2 belong to the first instance while block3 and block4 belong to the second one.
+	instances := []ring.InstanceDesc{
+		{Addr: "127.0.0.1", Tokens: []uint32{cortex_tsdb.HashBlockID(block2) + 1}, State: ring.ACTIVE},
+		{Addr: "127.0.0.2", Tokens: []uint32{cortex_tsdb.HashBlockID(block4) + 1}, State: ring.ACTIVE},
+	}
+	blocks := bucketindex.Blocks{
+		{ID: block1, IndexSize: 10},
+		{ID: block2, IndexSize: 10},
+		{ID: block3, IndexSize: 10},
+		{ID: block4, IndexSize: 10},
+	}
+
+	tests := map[string]struct {
+		loads          map[string]int64
+		expectedOwners map[ulid.ULID]string
+	}{
+		"no published loads": {
+			expectedOwners: map[ulid.ULID]string{block1: "127.0.0.1", block2: "127.0.0.1", block3: "127.0.0.2", block4: "127.0.0.2"},
+		},
+		"the instances own the blocks of other tenants of the same size": {
+			loads:          map[string]int64{"127.0.0.1": 1000, "127.0.0.2": 1000},
+			expectedOwners: map[ulid.ULID]string{block1: "127.0.0.1", block2: "127.0.0.1", block3: "127.0.0.2", block4: "127.0.0.2"},
+		},
+		"the first instance owns the blocks of other tenants": {
+			loads:          map[string]int64{"127.0.0.1": 1000},
+			expectedOwners: map[ulid.ULID]string{block1: "127.0.0.2", block2: "127.0.0.2", block3: "127.0.0.2", block4: "127.0.0.2"},
+		},
+		"the first instance owns slightly bigger blocks of other tenants": {
+			loads:          map[string]int64{"127.0.0.1": 80, "127.0.0.2": 20},
+			expectedOwners: map[ulid.ULID]string{block1: "127.0.0.1", block2: "127.0.0.2", block3: "127.0.0.2", block4: "127.0.0.2"},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			a := assignBlocksBalanced(instances, 1, blocks, testData.loads)
+
+			for blockID, expected := range testData.expectedOwners {
+				owners := a.GetOwners(blockID)
+				require.Len(t, owners, 1)
+				assert.Equal(t, expected, owners[0].Addr, "block: %s", blockID.String())
+			}
+
+			// The load before balancing doesn't depend on the published loads.
+			assert.Equal(t, int64(20), a.getHashLoad("127.0.0.1"))
+			assert.Equal(t, int64(20), a.getHashLoad("127.0.0.2"))
+		})
+	}
+}
+
+func TestAssignBlocksBalanced_ShouldOnlyMoveTheBlocksOfInstancesExceedingTheMaxLoad(t *testing.T) {
+	const replicationFactor = 2
+
+	instances, blocks := generateBalancedShardingInput(rand.New(rand.NewSource(5)), 6, 500)
+
+	// Some instances own the blocks of other tenants.
+	loads := map[string]int64{instances[0].Addr: 1000000, instances[1].Addr: 500000}
+	a := assignBlocksBalanced(instances, replicationFactor, blocks, loads)
+
+	totalLoad := int64(0)
+	for i := range a.instances {
+		totalLoad += a.loads[i]
+	}
+	maxLoad := int64(math.Ceil(balancedShardingMaxLoadFactor * float64(balancedShardingCapacity(totalLoad/int64(len(instances))))))
+
+	moved := 0
+	for _, b := range blocks {
+		hashOwners := balancedHashOwners(a, b.ID, replicationFactor)
+		owners := a.owners[b.ID]
+		require.Len(t, owners, replicationFactor)
+
+		for pos, owner := range hashOwners {
+			if owners[pos] == owner {
+				continue
+			}
+
+			// The block can only be moved away from an instance exceeding the max load before balancing.
+			assert.Greater(t, math.Max(float64(loads[a.instances[owner].Addr]), float64(a.hashLoads[owner])), float64(maxLoad))
+			moved++
+		}
+	}
+
+	assert.Greater(t, moved, 0)
+	assert.Less(t, moved, len(blocks)*replicationFactor/2)
+}
+
+func TestAssignBlocksBalanced_ShouldChangeFewPlacementsWhenAnInstanceIsUnhealthy(t *testing.T) {
+	instances, blocks := generateBalancedShardingInput(rand.New(rand.NewSource(6)), 6, 500)
+	before := assignBlocksBalanced(instances, 2, blocks, nil)
+
+	// An instance becomes unhealthy, so its blocks are placed on the next instances of their preference list.
+	after := assignBlocksBalanced(instances[1:], 2, blocks, nil)
+
+	changed := 0
+	for _, b := range blocks {
+		// The blocks owned by the unhealthy instance are moved by the consistent hashing.
+		beforeOwners := before.GetOwners(b.ID)
+		if containsInstanceAddr(beforeOwners, instances[0].Addr) {
+			continue
+		}
+
+		if !assert.ObjectsAreEqual(beforeOwners, after.GetOwners(b.ID)) {
+			changed++
+		}
+	}
+
+	t.Logf("placements changed when an instance is unhealthy: %d (out of %d blocks)", changed, len(blocks))
+	assert.LessOrEqual(t, changed, len(blocks)/50)
+}
+
+func TestBalancedBlockWeights(t *testing.T) {
+	tests := map[string]struct {
+		blocks   bucketindex.Blocks
+		expected []int64
+	}{
+		"no stats": {
+			blocks:   bucketindex.Blocks{{}, {}},
+			expected: []int64{1, 1},
+		},
+		"index size known": {
+			blocks:   bucketindex.Blocks{{IndexSize: 100}, {IndexSize: 300}, {}},
+			expected: []int64{100, 300, 200},
+		},
+		"number of series known without any index size": {
+			blocks:   bucketindex.Blocks{{NumSeries: 10}, {}},
+			expected: []int64{10 * balancedShardingIndexBytesPerSeries, 10 * balancedShardingIndexBytesPerSeries},
+		},
+		"index size per series estimated from the other blocks": {
+			blocks:   bucketindex.Blocks{{IndexSize: 1000, NumSeries: 10}, {NumSeries: 20}},
+			expected: []int64{1000, 2000},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, testData.expected, balancedBlockWeights(testData.blocks))
+		})
+	}
+}
+
+func generateBalancedShardingInput(r *rand.Rand, numInstances, numBlocks int) ([]ring.InstanceDesc, bucketindex.Blocks) {
+	instances := make([]ring.InstanceDesc, 0, numInstances)
+	for i := 0; i < numInstances; i++ {
+		tokens := make([]uint32, 0, 128)
+		for len(tokens) < cap(tokens) {
+			tokens = append(tokens, r.Uint32())
+		}
+
+		instances = append(instances, ring.InstanceDesc{
+			Addr:   fmt.Sprintf("127.0.0.%d", i+1),
+			Zone:   fmt.Sprintf("zone-%d", i%3),
+			Tokens: tokens,
+			State:  ring.ACTIVE,
+		})
+	}
+
+	blocks := make(bucketindex.Blocks, 0, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		// Some blocks are much bigger than the others, like compacted ones.
+		size := int64(r.Intn(1000) + 1)
+		if i%20 == 0 {
+			size *= 50
+		}
+
+		blocks = append(blocks, &bucketindex.Block{ID: ulid.MustNew(uint64(i), nil), IndexSize: size})
+	}
+
+	return instances, blocks
+}
+
+// balancedHashOwners returns the instances owning the block before balancing.
+func balancedHashOwners(a *BalancedAssignment, blockID ulid.ULID, replicationFactor int) []int {
+	var owners []int
+	zones := map[string]struct{}{}
+
+	it := a.newPreferenceIterator(blockID)
+	for len(owners) < replicationFactor {
+		instance, ok := it.next()
+		if !ok {
+			break
+		}
+		if _, ok := zones[a.instances[instance].Zone]; ok {
+			continue
+		}
+
+		owners = append(owners, instance)
+		zones[a.instances[instance].Zone] = struct{}{}
+	}
+	return owners
+}
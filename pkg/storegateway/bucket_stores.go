@@ -65,7 +65,11 @@ type BucketStores struct {
 
 	// Keeps a bucket store for each tenant.
 	storesMu sync.RWMutex
-	stores   map[string]*store.BucketStore
+	stores   map[string]*BucketStore
+
+	// Keeps the non-cancelled tombstones of each tenant, when series deletion is enabled.
+	tombstonesMu sync.RWMutex
+	tombstones   map[string][]*tsdb.Tombstone
 
 	// Metrics.
 	syncTimes         prometheus.Histogram
@@ -95,7 +99,8 @@ func NewBucketStores(cfg tsdb.BlocksStorageConfig, shardingStrategy ShardingStra
 		limits:             limits,
 		bucket:             cachingBucket,
 		shardingStrategy:   shardingStrategy,
-		stores:             map[string]*store.BucketStore{},
+		stores:             map[string]*BucketStore{},
+		tombstones:         map[string][]*tsdb.Tombstone{},
 		logLevel:           logLevel,
 		bucketStoreMetrics: NewBucketStoreMetrics(),
 		metaFetcherMetrics: NewMetadataFetcherMetrics(),
@@ -141,7 +146,7 @@ func NewBucketStores(cfg tsdb.BlocksStorageConfig, shardingStrategy ShardingStra
 func (u *BucketStores) InitialSync(ctx context.Context) error {
 	level.Info(u.logger).Log("msg", "synchronizing TSDB blocks for all users")
 
-	if err := u.syncUsersBlocksWithRetries(ctx, func(ctx context.Context, s *store.BucketStore) error {
+	if err := u.syncUsersBlocksWithRetries(ctx, func(ctx context.Context, s *BucketStore) error {
 		return s.InitialSync(ctx)
 	}); err != nil {
 		level.Warn(u.logger).Log("msg", "failed to synchronize TSDB blocks", "err", err)
@@ -154,12 +159,12 @@ func (u *BucketStores) InitialSync(ctx context.Context) error {
 
 // SyncBlocks synchronizes the stores state with the Bucket store for every user.
 func (u *BucketStores) SyncBlocks(ctx context.Context) error {
-	return u.syncUsersBlocksWithRetries(ctx, func(ctx context.Context, s *store.BucketStore) error {
+	return u.syncUsersBlocksWithRetries(ctx, func(ctx context.Context, s *BucketStore) error {
 		return s.SyncBlocks(ctx)
 	})
 }
 
-func (u *BucketStores) syncUsersBlocksWithRetries(ctx context.Context, f func(context.Context, *store.BucketStore) error) error {
+func (u *BucketStores) syncUsersBlocksWithRetries(ctx context.Context, f func(context.Context, *BucketStore) error) error {
 	retries := backoff.New(ctx, backoff.Config{
 		MinBackoff: 1 * time.Second,
 		MaxBackoff: 10 * time.Second,
@@ -183,7 +188,7 @@ func (u *BucketStores) syncUsersBlocksWithRetries(ctx context.Context, f func(co
 	return lastErr
 }
 
-func (u *BucketStores) syncUsersBlocks(ctx context.Context, f func(context.Context, *store.BucketStore) error) (returnErr error) {
+func (u *BucketStores) syncUsersBlocks(ctx context.Context, f func(context.Context, *BucketStore) error) (returnErr error) {
 	defer func(start time.Time) {
 		u.syncTimes.Observe(time.Since(start).Seconds())
 		if returnErr == nil {
@@ -193,7 +198,7 @@ func (u *BucketStores) syncUsersBlocks(ctx context.Context, f func(context.Conte
 
 	type job struct {
 		userID string
-		store  *store.BucketStore
+		store  *BucketStore
 	}
 
 	wg := &sync.WaitGroup{}
@@ -230,6 +235,17 @@ func (u *BucketStores) syncUsersBlocks(ctx context.Context, f func(context.Conte
 					errsMx.Lock()
 					errs.Add(errors.Wrapf(err, "failed to synchronize TSDB blocks for user %s", job.userID))
 					errsMx.Unlock()
+				} else if s, ok := u.shardingStrategy.(ShardingStrategyWithSyncedBlocks); ok {
+					store := job.store
+					s.BlocksSynced(job.userID, func(id ulid.ULID) bool {
+						return store.getBlock(id) != nil
+					})
+				}
+
+				if err := u.syncTombstones(ctx, job.userID); err != nil {
+					errsMx.Lock()
+					errs.Add(errors.Wrapf(err, "failed to synchronize tombstones for user %s", job.userID))
+					errsMx.Unlock()
 				}
 			}
 		}()
@@ -287,10 +303,22 @@ func (u *BucketStores) Series(req *storepb.SeriesRequest, srv storepb.Store_Seri
 		return nil
 	}
 
-	return store.Series(req, spanSeriesServer{
+	// Track the index bytes fetched from the bucket, which are returned to the querier in the trailer.
+	spanCtx, fetchedIndexBytes := contextWithFetchedIndexBytes(spanCtx)
+	defer func() {
+		setFetchedIndexBytesTrailer(srv.Context(), fetchedIndexBytes.Load())
+	}()
+
+	var seriesSrv storepb.Store_SeriesServer = spanSeriesServer{
 		Store_SeriesServer: srv,
 		ctx:                spanCtx,
-	})
+	}
+
+	if tombstones := u.getTombstones(userID); len(tombstones) > 0 {
+		seriesSrv = newTombstonesSeriesServer(seriesSrv, tombstones, req.MinTime, req.MaxTime)
+	}
+
+	return store.Series(req, seriesSrv)
 }
 
 // LabelNames implements the Storegateway proto service.
@@ -345,7 +373,45 @@ func (u *BucketStores) scanUsers(ctx context.Context) ([]string, error) {
 	return users, err
 }
 
-func (u *BucketStores) getStore(userID string) *store.BucketStore {
+// syncTombstones loads the tenant's tombstones which haven't been cancelled, so that the
+// deleted series are filtered out of the blocks until the compactor has rewritten them.
+// On failure, the previously loaded tombstones are kept.
+func (u *BucketStores) syncTombstones(ctx context.Context, userID string) error {
+	if !u.cfg.BucketStore.SeriesDeletionEnabled {
+		return nil
+	}
+
+	tombstones, err := tsdb.ListTombstones(ctx, u.bucket, userID, u.limits)
+	if err != nil {
+		return err
+	}
+
+	active := make([]*tsdb.Tombstone, 0, len(tombstones))
+	for _, t := range tombstones {
+		if t.State != tsdb.TombstoneCancelled {
+			active = append(active, t)
+		}
+	}
+
+	u.tombstonesMu.Lock()
+	defer u.tombstonesMu.Unlock()
+
+	if len(active) == 0 {
+		delete(u.tombstones, userID)
+	} else {
+		u.tombstones[userID] = active
+	}
+
+	return nil
+}
+
+func (u *BucketStores) getTombstones(userID string) []*tsdb.Tombstone {
+	u.tombstonesMu.RLock()
+	defer u.tombstonesMu.RUnlock()
+	return u.tombstones[userID]
+}
+
+func (u *BucketStores) getStore(userID string) *BucketStore {
 	u.storesMu.RLock()
 	defer u.storesMu.RUnlock()
 	return u.stores[userID]
@@ -383,12 +449,16 @@ func (u *BucketStores) closeEmptyBucketStore(userID string) error {
 	unlockInDefer = false
 	u.storesMu.Unlock()
 
+	u.tombstonesMu.Lock()
+	delete(u.tombstones, userID)
+	u.tombstonesMu.Unlock()
+
 	u.metaFetcherMetrics.RemoveUserRegistry(userID)
 	u.bucketStoreMetrics.RemoveUserRegistry(userID)
 	return bs.Close()
 }
 
-func isEmptyBucketStore(bs *store.BucketStore) bool {
+func isEmptyBucketStore(bs *BucketStore) bool {
 	min, max := bs.TimeRange()
 	return min == math.MaxInt64 && max == math.MinInt64
 }
@@ -397,7 +467,7 @@ func (u *BucketStores) syncDirForUser(userID string) string {
 	return filepath.Join(u.cfg.BucketStore.SyncDir, userID)
 }
 
-func (u *BucketStores) getOrCreateStore(userID string) (*store.BucketStore, error) {
+func (u *BucketStores) getOrCreateStore(userID string) (*BucketStore, error) {
 	// Check if the store already exists.
 	bs := u.getStore(userID)
 	if bs != nil {
@@ -437,6 +507,7 @@ func (u *BucketStores) getOrCreateStore(userID string) (*store.BucketStore, erro
 			tsdb.TenantIDExternalLabel,
 			tsdb.IngesterIDExternalLabel,
 			tsdb.ShardIDExternalLabel,
+			tsdb.CompactorShardIDExternalLabel,
 		}),
 	}
 
@@ -476,19 +547,20 @@ func (u *BucketStores) getOrCreateStore(userID string) (*store.BucketStore, erro
 	}
 
 	bucketStoreReg := prometheus.NewRegistry()
-	bucketStoreOpts := []store.BucketStoreOption{
-		store.WithLogger(userLogger),
-		store.WithRegistry(bucketStoreReg),
-		store.WithIndexCache(u.indexCache),
-		store.WithQueryGate(u.queryGate),
-		store.WithChunkPool(u.chunksPool),
+	bucketStoreOpts := []BucketStoreOption{
+		WithLogger(userLogger),
+		WithRegistry(bucketStoreReg),
+		WithIndexCache(u.indexCache),
+		WithQueryGate(u.queryGate),
+		WithChunkPool(u.chunksPool),
+		WithSeriesBatchSize(u.cfg.BucketStore.SeriesBatchSize),
 	}
 	if u.logLevel.String() == "debug" {
-		bucketStoreOpts = append(bucketStoreOpts, store.WithDebugLogging())
+		bucketStoreOpts = append(bucketStoreOpts, WithDebugLogging())
 	}
 
-	bs, err := store.NewBucketStore(
-		userBkt,
+	bs, err := NewBucketStore(
+		newIndexBytesTrackingBucket(userBkt),
 		fetcher,
 		u.syncDirForUser(userID),
 		newChunksLimiterFactory(u.limits, userID),
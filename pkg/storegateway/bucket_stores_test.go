@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/types"
 	"github.com/grafana/dskit/flagext"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,15 +30,19 @@ import (
 	thanos_metadata "github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/store"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/weaveworks/common/logging"
 	"go.uber.org/atomic"
 	"google.golang.org/grpc/metadata"
 
+	"github.com/cortexproject/cortex/pkg/querier/astmapper"
 	"github.com/cortexproject/cortex/pkg/storage/bucket"
 	"github.com/cortexproject/cortex/pkg/storage/bucket/filesystem"
+	"github.com/cortexproject/cortex/pkg/storage/sharding"
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
@@ -289,7 +294,7 @@ func TestBucketStores_syncUsersBlocks(t *testing.T) {
 
 			// Sync user stores and count the number of times the callback is called.
 			var storesCount atomic.Int32
-			err = stores.syncUsersBlocks(context.Background(), func(ctx context.Context, bs *store.BucketStore) error {
+			err = stores.syncUsersBlocks(context.Background(), func(ctx context.Context, bs *BucketStore) error {
 				storesCount.Inc()
 				return nil
 			})
@@ -378,6 +383,188 @@ func testBucketStoresSeriesShouldCorrectlyQuerySeriesSpanningMultipleChunks(t *t
 	}
 }
 
+func TestBucketStores_Series_ShouldFilterSeriesByShard(t *testing.T) {
+	const (
+		userID    = "user-1"
+		numSeries = 20
+		numShards = 4
+	)
+
+	ctx := context.Background()
+	cfg, cleanup := prepareStorageConfig(t)
+	defer cleanup()
+
+	storageDir, err := ioutil.TempDir(os.TempDir(), "storage-*")
+	require.NoError(t, err)
+
+	for s := 0; s < numSeries; s++ {
+		generateStorageBlock(t, storageDir, userID, fmt.Sprintf("series_%d", s), 10, 100, 15)
+	}
+
+	bucket, err := filesystem.NewBucketClient(filesystem.Config{Directory: storageDir})
+	require.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	stores, err := NewBucketStores(cfg, NewNoShardingStrategy(), bucket, defaultLimitsOverrides(t), mockLoggingLevel(), log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	require.NoError(t, stores.InitialSync(ctx))
+
+	// Query each shard and ensure each series is returned exactly once.
+	seen := map[string]int{}
+
+	for shardIdx := 0; shardIdx < numShards; shardIdx++ {
+		shard := astmapper.ShardAnnotation{Shard: shardIdx, Of: numShards}
+
+		hints, err := types.MarshalAny(&storegatewaypb.SeriesRequestHints{
+			ShardInfo: &storegatewaypb.ShardInfo{ShardIndex: int64(shardIdx), TotalShards: numShards},
+		})
+		require.NoError(t, err)
+
+		req := &storepb.SeriesRequest{
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_RE, Name: labels.MetricName, Value: "series_.*"},
+			},
+			PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+			Hints:                   hints,
+		}
+
+		srv := newBucketStoreSeriesServer(setUserIDToGRPCContext(ctx, userID))
+		require.NoError(t, stores.Series(req, srv))
+
+		for _, series := range srv.SeriesSet {
+			lbls := labelpb.ZLabelsToPromLabels(series.Labels)
+			assert.True(t, sharding.IsSeriesInShard(shard, lbls))
+			seen[lbls.String()]++
+		}
+	}
+
+	require.Len(t, seen, numSeries)
+	for _, count := range seen {
+		assert.Equal(t, 1, count)
+	}
+
+	// Series are filtered by shard before loading their chunks, so each chunk
+	// should have been touched only once across all shards.
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+
+	chunksTouched := float64(0)
+	for _, family := range metrics {
+		if family.GetName() != "cortex_bucket_store_series_data_touched" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "data_type" && l.GetValue() == "chunks" {
+					chunksTouched += m.GetSummary().GetSampleSum()
+				}
+			}
+		}
+	}
+	assert.Equal(t, float64(numSeries), chunksTouched)
+}
+
+func TestBucketStores_Series_ShouldSendSeriesInBatches(t *testing.T) {
+	const (
+		userID    = "user-1"
+		numBlocks = 2
+		numSeries = 25
+	)
+
+	ctx := context.Background()
+	cfg, cleanup := prepareStorageConfig(t)
+	defer cleanup()
+
+	storageDir, err := ioutil.TempDir(os.TempDir(), "storage-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(storageDir) //nolint:errcheck
+
+	// Each series spans multiple chunks, and is stored in all the blocks.
+	var series []labels.Labels
+	for s := 0; s < numSeries; s++ {
+		series = append(series, labels.FromStrings(labels.MetricName, "series", "series_id", fmt.Sprintf("%02d", s)))
+	}
+	for b := 0; b < numBlocks; b++ {
+		generateStorageBlockWithSeries(t, storageDir, userID, series, int64(b*1000), int64(b*1000+500), 1)
+	}
+
+	bucket, err := filesystem.NewBucketClient(filesystem.Config{Directory: storageDir})
+	require.NoError(t, err)
+
+	query := func(batchSize int) []*storepb.SeriesResponse {
+		cfg.BucketStore.SeriesBatchSize = batchSize
+
+		stores, err := NewBucketStores(cfg, NewNoShardingStrategy(), bucket, defaultLimitsOverrides(t), mockLoggingLevel(), log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		require.NoError(t, stores.InitialSync(ctx))
+
+		req := &storepb.SeriesRequest{
+			MinTime:                 math.MinInt64,
+			MaxTime:                 math.MaxInt64,
+			Matchers:                []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: labels.MetricName, Value: "series"}},
+			PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+		}
+
+		srv := &recordingSeriesServer{ctx: setUserIDToGRPCContext(ctx, userID)}
+		require.NoError(t, stores.Series(req, srv))
+		return srv.responses
+	}
+
+	expected := query(0)
+	actual := query(3)
+
+	// Without batching, the hints are sent after the series.
+	require.Len(t, expected, numSeries+1)
+	require.NotNil(t, expected[numSeries].GetHints())
+
+	// With batching, the hints are sent before the series.
+	require.Len(t, actual, numSeries+1)
+	require.NotNil(t, actual[0].GetHints())
+	assert.ElementsMatch(t, decodeQueriedBlocks(t, expected[numSeries]), decodeQueriedBlocks(t, actual[0]))
+
+	// The chunks loaded in batches should be the same as the ones loaded all at once.
+	for i := 0; i < numSeries; i++ {
+		assert.Equal(t, expected[i].GetSeries(), actual[i+1].GetSeries())
+		assert.Len(t, actual[i+1].GetSeries().Chunks, numBlocks*5)
+	}
+}
+
+func decodeQueriedBlocks(t *testing.T, r *storepb.SeriesResponse) []hintspb.Block {
+	hints := hintspb.SeriesResponseHints{}
+	require.NoError(t, types.UnmarshalAny(r.GetHints(), &hints))
+	return hints.QueriedBlocks
+}
+
+// recordingSeriesServer is a storepb.Store_SeriesServer keeping a copy of all the sent responses, in order.
+type recordingSeriesServer struct {
+	storepb.Store_SeriesServer
+
+	ctx       context.Context
+	responses []*storepb.SeriesResponse
+}
+
+func (s *recordingSeriesServer) Send(r *storepb.SeriesResponse) error {
+	// Copy the response, because the series may be backed by pooled memory.
+	data, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+
+	copied := &storepb.SeriesResponse{}
+	if err := copied.Unmarshal(data); err != nil {
+		return err
+	}
+
+	s.responses = append(s.responses, copied)
+	return nil
+}
+
+func (s *recordingSeriesServer) Context() context.Context {
+	return s.ctx
+}
+
 func prepareStorageConfig(t *testing.T) (cortex_tsdb.BlocksStorageConfig, func()) {
 	tmpDir, err := ioutil.TempDir(os.TempDir(), "blocks-sync-*")
 	require.NoError(t, err)
@@ -394,6 +581,10 @@ func prepareStorageConfig(t *testing.T) (cortex_tsdb.BlocksStorageConfig, func()
 }
 
 func generateStorageBlock(t *testing.T, storageDir, userID string, metricName string, minT, maxT int64, step int) {
+	generateStorageBlockWithSeries(t, storageDir, userID, []labels.Labels{{labels.Label{Name: labels.MetricName, Value: metricName}}}, minT, maxT, step)
+}
+
+func generateStorageBlockWithSeries(t *testing.T, storageDir, userID string, series []labels.Labels, minT, maxT int64, step int) {
 	// Create a directory for the user (if doesn't already exist).
 	userDir := filepath.Join(storageDir, userID)
 	if _, err := os.Stat(userDir); err != nil {
@@ -414,12 +605,12 @@ func generateStorageBlock(t *testing.T, storageDir, userID string, metricName st
 		require.NoError(t, db.Close())
 	}()
 
-	series := labels.Labels{labels.Label{Name: labels.MetricName, Value: metricName}}
-
 	app := db.Appender(context.Background())
-	for ts := minT; ts < maxT; ts += int64(step) {
-		_, err = app.Append(0, series, ts, 1)
-		require.NoError(t, err)
+	for _, lbls := range series {
+		for ts := minT; ts < maxT; ts += int64(step) {
+			_, err = app.Append(0, lbls, ts, 1)
+			require.NoError(t, err)
+		}
 	}
 	require.NoError(t, app.Commit())
 
@@ -42,11 +42,12 @@ const (
 )
 
 var (
-	supportedShardingStrategies = []string{util.ShardingStrategyDefault, util.ShardingStrategyShuffle}
+	supportedShardingStrategies = []string{util.ShardingStrategyDefault, util.ShardingStrategyShuffle, util.ShardingStrategyBalanced}
 
 	// Validation errors.
 	errInvalidShardingStrategy = errors.New("invalid sharding strategy")
 	errInvalidTenantShardSize  = errors.New("invalid tenant shard size, the value must be greater than 0")
+	errMissingInstanceZone     = errors.New("the instance availability zone must be set when zone-awareness is enabled")
 )
 
 // Config holds the store gateway config.
@@ -65,7 +66,7 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 }
 
 // Validate the Config.
-func (cfg *Config) Validate(limits validation.Limits) error {
+func (cfg *Config) Validate(limits validation.Limits, storageCfg cortex_tsdb.BlocksStorageConfig) error {
 	if cfg.ShardingEnabled {
 		if !util.StringsContain(supportedShardingStrategies, cfg.ShardingStrategy) {
 			return errInvalidShardingStrategy
@@ -74,6 +75,10 @@ func (cfg *Config) Validate(limits validation.Limits) error {
 		if cfg.ShardingStrategy == util.ShardingStrategyShuffle && limits.StoreGatewayTenantShardSize <= 0 {
 			return errInvalidTenantShardSize
 		}
+
+		if cfg.ShardingStrategy == util.ShardingStrategyBalanced && !storageCfg.BucketStore.BucketIndex.Enabled {
+			return errBalancedShardingRequiresBucketIndex
+		}
 	}
 
 	return nil
@@ -94,6 +99,9 @@ type StoreGateway struct {
 	ringLifecycler *ring.BasicLifecycler
 	ring           *ring.Ring
 
+	// Load published by the store-gateways, used by the balanced sharding strategy only.
+	loads *BalancedLoads
+
 	// Subservices manager (ring, lifecycler)
 	subservices        *services.Manager
 	subservicesWatcher *services.FailureWatcher
@@ -102,7 +110,7 @@ type StoreGateway struct {
 }
 
 func NewStoreGateway(gatewayCfg Config, storageCfg cortex_tsdb.BlocksStorageConfig, limits *validation.Overrides, logLevel logging.Level, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
-	var ringStore kv.Client
+	var ringStore, loadStore kv.Client
 
 	bucketClient, err := createBucketClient(storageCfg, logger, reg)
 	if err != nil {
@@ -121,10 +129,24 @@ func NewStoreGateway(gatewayCfg Config, storageCfg cortex_tsdb.BlocksStorageConf
 		}
 	}
 
-	return newStoreGateway(gatewayCfg, storageCfg, bucketClient, ringStore, limits, logLevel, logger, reg)
+	if gatewayCfg.ShardingEnabled && gatewayCfg.ShardingStrategy == util.ShardingStrategyBalanced {
+		loadStore, err = kv.NewClient(
+			gatewayCfg.ShardingRing.KVStore,
+			GetLoadDescCodec(),
+			kv.RegistererWithKVName(prometheus.WrapRegistererWithPrefix("cortex_", reg), "store-gateway-load"),
+			logger,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "create load KV store client")
+		}
+	}
+
+	return newStoreGateway(gatewayCfg, storageCfg, bucketClient, ringStore, loadStore, limits, logLevel, logger, reg)
 }
 
-func newStoreGateway(gatewayCfg Config, storageCfg cortex_tsdb.BlocksStorageConfig, bucketClient objstore.Bucket, ringStore kv.Client, limits *validation.Overrides, logLevel logging.Level, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
+// newStoreGateway makes a new StoreGateway. The load KV store client is used by the balanced sharding
+// strategy only, and if nil the load of the store-gateways is not taken into account.
+func newStoreGateway(gatewayCfg Config, storageCfg cortex_tsdb.BlocksStorageConfig, bucketClient objstore.Bucket, ringStore, loadStore kv.Client, limits *validation.Overrides, logLevel logging.Level, logger log.Logger, reg prometheus.Registerer) (*StoreGateway, error) {
 	var err error
 
 	g := &StoreGateway{
@@ -175,6 +197,11 @@ func newStoreGateway(gatewayCfg Config, storageCfg cortex_tsdb.BlocksStorageConf
 			shardingStrategy = NewDefaultShardingStrategy(g.ring, lifecyclerCfg.Addr, logger)
 		case util.ShardingStrategyShuffle:
 			shardingStrategy = NewShuffleShardingStrategy(g.ring, lifecyclerCfg.ID, lifecyclerCfg.Addr, limits, logger)
+		case util.ShardingStrategyBalanced:
+			if loadStore != nil {
+				g.loads = NewBalancedLoads(loadStore, lifecyclerCfg.Addr, gatewayCfg.ShardingRing.HeartbeatPeriod, gatewayCfg.ShardingRing.HeartbeatTimeout, logger)
+			}
+			shardingStrategy = NewBalancedShardingStrategy(g.ring, lifecyclerCfg.ID, lifecyclerCfg.Addr, limits, g.loads, logger)
 		default:
 			return nil, errInvalidShardingStrategy
 		}
@@ -209,7 +236,12 @@ func (g *StoreGateway) starting(ctx context.Context) (err error) {
 	if g.gatewayCfg.ShardingEnabled {
 		// First of all we register the instance in the ring and wait
 		// until the lifecycler successfully started.
-		if g.subservices, err = services.NewManager(g.ringLifecycler, g.ring); err != nil {
+		subservices := []services.Service{g.ringLifecycler, g.ring}
+		if g.loads != nil {
+			subservices = append(subservices, g.loads)
+		}
+
+		if g.subservices, err = services.NewManager(subservices...); err != nil {
 			return errors.Wrap(err, "unable to start store-gateway dependencies")
 		}
 
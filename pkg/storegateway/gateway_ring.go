@@ -131,6 +131,11 @@ func (cfg *RingConfig) ToRingConfig() ring.Config {
 }
 
 func (cfg *RingConfig) ToLifecyclerConfig(logger log.Logger) (ring.BasicLifecyclerConfig, error) {
+	// Replicas are guaranteed to be spread across zones only if every store-gateway registers its zone.
+	if cfg.ZoneAwarenessEnabled && cfg.InstanceZone == "" {
+		return ring.BasicLifecyclerConfig{}, errMissingInstanceZone
+	}
+
 	instanceAddr, err := ring.GetInstanceAddr(cfg.InstanceAddr, cfg.InstanceInterfaceNames, logger)
 	if err != nil {
 		return ring.BasicLifecyclerConfig{}, err
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-kit/log"
 	"github.com/grafana/dskit/ring"
 	"github.com/stretchr/testify/assert"
 )
@@ -70,3 +71,40 @@ func TestIsHealthyForStoreGatewayOperations(t *testing.T) {
 		})
 	}
 }
+
+func TestRingConfig_ToLifecyclerConfig(t *testing.T) {
+	tests := map[string]struct {
+		zoneAwarenessEnabled bool
+		instanceZone         string
+		expectedErr          error
+	}{
+		"zone-awareness disabled": {
+			zoneAwarenessEnabled: false,
+		},
+		"zone-awareness enabled and instance zone set": {
+			zoneAwarenessEnabled: true,
+			instanceZone:         "zone-a",
+		},
+		"zone-awareness enabled and instance zone missing": {
+			zoneAwarenessEnabled: true,
+			expectedErr:          errMissingInstanceZone,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := RingConfig{
+				InstanceAddr:         "127.0.0.1",
+				InstancePort:         9095,
+				ZoneAwarenessEnabled: testData.zoneAwarenessEnabled,
+				InstanceZone:         testData.instanceZone,
+			}
+
+			lifecyclerCfg, err := cfg.ToLifecyclerConfig(log.NewNopLogger())
+			assert.Equal(t, testData.expectedErr, err)
+			if testData.expectedErr == nil {
+				assert.Equal(t, testData.instanceZone, lifecyclerCfg.Zone)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package storegateway
+
+import (
+	"context"
+	"io"
+	"path"
+	"strconv"
+
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FetchedIndexBytesTrailer is the gRPC trailer through which the store-gateway returns
+// the number of index bytes fetched from the bucket to serve a series request.
+const FetchedIndexBytesTrailer = "cortex-fetched-index-bytes"
+
+type fetchedIndexBytesContextKey struct{}
+
+// contextWithFetchedIndexBytes returns a context tracking the index bytes fetched from the bucket.
+func contextWithFetchedIndexBytes(ctx context.Context) (context.Context, *atomic.Uint64) {
+	counter := atomic.NewUint64(0)
+	return context.WithValue(ctx, fetchedIndexBytesContextKey{}, counter), counter
+}
+
+func fetchedIndexBytesFromContext(ctx context.Context) *atomic.Uint64 {
+	counter, _ := ctx.Value(fetchedIndexBytesContextKey{}).(*atomic.Uint64)
+	return counter
+}
+
+// setFetchedIndexBytesTrailer sends the fetched index bytes to the client. It's a no-op if
+// the server stream is not a gRPC one.
+func setFetchedIndexBytesTrailer(ctx context.Context, fetched uint64) {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(FetchedIndexBytesTrailer, strconv.FormatUint(fetched, 10)))
+}
+
+// ParseFetchedIndexBytesTrailer returns the fetched index bytes from the trailer, or 0 if missing.
+func ParseFetchedIndexBytesTrailer(trailer metadata.MD) uint64 {
+	values := trailer.Get(FetchedIndexBytesTrailer)
+	if len(values) == 0 {
+		return 0
+	}
+
+	fetched, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return fetched
+}
+
+// indexBytesTrackingBucket is an objstore.InstrumentedBucketReader tracking the bytes read
+// from the blocks index into the counter stored in the request context, if any.
+type indexBytesTrackingBucket struct {
+	objstore.InstrumentedBucketReader
+}
+
+func newIndexBytesTrackingBucket(bkt objstore.InstrumentedBucketReader) objstore.InstrumentedBucketReader {
+	return indexBytesTrackingBucket{InstrumentedBucketReader: bkt}
+}
+
+func (b indexBytesTrackingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, err := b.InstrumentedBucketReader.Get(ctx, name)
+	return trackIndexBytes(ctx, name, r, err)
+}
+
+func (b indexBytesTrackingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	r, err := b.InstrumentedBucketReader.GetRange(ctx, name, off, length)
+	return trackIndexBytes(ctx, name, r, err)
+}
+
+func trackIndexBytes(ctx context.Context, name string, r io.ReadCloser, err error) (io.ReadCloser, error) {
+	if err != nil || path.Base(name) != block.IndexFilename {
+		return r, err
+	}
+
+	counter := fetchedIndexBytesFromContext(ctx)
+	if counter == nil {
+		return r, err
+	}
+
+	return countingReadCloser{ReadCloser: r, counter: counter}, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+
+	counter *atomic.Uint64
+}
+
+func (r countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,51 @@
+package storegateway
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestIndexBytesTrackingBucket(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	require.NoError(t, bkt.Upload(context.Background(), "block-1/index", bytes.NewReader(make([]byte, 100))))
+	require.NoError(t, bkt.Upload(context.Background(), "block-1/chunks/000001", bytes.NewReader(make([]byte, 100))))
+
+	trackingBkt := newIndexBytesTrackingBucket(objstore.WithNoopInstr(bkt))
+
+	read := func(ctx context.Context, name string, off, length int64) {
+		r, err := trackingBkt.GetRange(ctx, name, off, length)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+	}
+
+	// Reads without a counter in the context are not tracked.
+	read(context.Background(), "block-1/index", 0, 10)
+
+	ctx, fetched := contextWithFetchedIndexBytes(context.Background())
+	read(ctx, "block-1/index", 0, 10)
+	read(ctx, "block-1/index", 50, 20)
+	read(ctx, "block-1/chunks/000001", 0, 30)
+
+	r, err := trackingBkt.Get(ctx, "block-1/index")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, uint64(130), fetched.Load())
+}
+
+func TestParseFetchedIndexBytesTrailer(t *testing.T) {
+	assert.Equal(t, uint64(0), ParseFetchedIndexBytesTrailer(nil))
+	assert.Equal(t, uint64(0), ParseFetchedIndexBytesTrailer(metadata.Pairs(FetchedIndexBytesTrailer, "invalid")))
+	assert.Equal(t, uint64(1024), ParseFetchedIndexBytesTrailer(metadata.Pairs(FetchedIndexBytesTrailer, "1024")))
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/extprom"
 
 	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/bucketindex"
 )
 
 const (
@@ -30,6 +31,25 @@ type ShardingStrategy interface {
 	FilterBlocks(ctx context.Context, userID string, metas map[ulid.ULID]*metadata.Meta, loaded map[ulid.ULID]struct{}, synced *extprom.TxGaugeVec) error
 }
 
+// ShardingStrategyWithBucketIndex is a ShardingStrategy which filters blocks based on the bucket index.
+type ShardingStrategyWithBucketIndex interface {
+	ShardingStrategy
+
+	// FilterBlocksWithBucketIndex is like FilterBlocks() but it provides in input the bucket index too.
+	// It's used instead of FilterBlocks() when the bucket index is enabled.
+	FilterBlocksWithBucketIndex(ctx context.Context, userID string, metas map[ulid.ULID]*metadata.Meta, idx *bucketindex.Index, loaded map[ulid.ULID]struct{}, synced *extprom.TxGaugeVec) error
+}
+
+// ShardingStrategyWithSyncedBlocks is a ShardingStrategy which is notified once the blocks of a tenant
+// have been synced.
+type ShardingStrategyWithSyncedBlocks interface {
+	ShardingStrategy
+
+	// BlocksSynced is called after the blocks of the tenant have been successfully synced. The provided
+	// function returns whether a block is loaded in the store-gateway.
+	BlocksSynced(userID string, isLoaded func(ulid.ULID) bool)
+}
+
 // ShardingLimits is the interface that should be implemented by the limits provider,
 // limiting the scope of the limits to the ones required by sharding strategies.
 type ShardingLimits interface {
@@ -208,13 +228,32 @@ func (a *shardingMetadataFilterAdapter) Filter(ctx context.Context, metas map[ul
 		return err
 	}
 
-	// Keep track of the last filtered blocks.
+	a.trackLastBlocks(metas)
+	return nil
+}
+
+// FilterWithBucketIndex implements MetadataFilterWithBucketIndex.
+// This function is NOT safe for use by multiple goroutines concurrently.
+func (a *shardingMetadataFilterAdapter) FilterWithBucketIndex(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, idx *bucketindex.Index, synced *extprom.TxGaugeVec) error {
+	strategy, ok := a.strategy.(ShardingStrategyWithBucketIndex)
+	if !ok {
+		return a.Filter(ctx, metas, synced)
+	}
+
+	if err := strategy.FilterBlocksWithBucketIndex(ctx, a.userID, metas, idx, a.lastBlocks, synced); err != nil {
+		return err
+	}
+
+	a.trackLastBlocks(metas)
+	return nil
+}
+
+// trackLastBlocks keeps track of the last filtered blocks.
+func (a *shardingMetadataFilterAdapter) trackLastBlocks(metas map[ulid.ULID]*metadata.Meta) {
 	a.lastBlocks = make(map[ulid.ULID]struct{}, len(metas))
 	for blockID := range metas {
 		a.lastBlocks[blockID] = struct{}{}
 	}
-
-	return nil
 }
 
 type shardingBucketReaderAdapter struct {
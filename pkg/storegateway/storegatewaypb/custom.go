@@ -0,0 +1,28 @@
+package storegatewaypb
+
+import (
+	"github.com/gogo/protobuf/types"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+)
+
+// Matches returns whether the series with the given labels belongs to the shard.
+// A nil ShardInfo matches all series.
+func (m *ShardInfo) Matches(lset labels.Labels) bool {
+	if m == nil || m.TotalShards <= 0 {
+		return true
+	}
+	return lset.Hash()%uint64(m.TotalShards) == uint64(m.ShardIndex)
+}
+
+// UnmarshalSeriesRequestHints unmarshals the series request hints, which can either be
+// the Cortex SeriesRequestHints or the Thanos ones (e.g. sent by queriers not sharding
+// the query). The two are wire-compatible, the Thanos ones just never have a shard.
+func UnmarshalSeriesRequestHints(any *types.Any) (*SeriesRequestHints, error) {
+	hints := &SeriesRequestHints{}
+
+	if types.Is(any, &hintspb.SeriesRequestHints{}) {
+		return hints, hints.Unmarshal(any.Value)
+	}
+	return hints, types.UnmarshalAny(any, hints)
+}
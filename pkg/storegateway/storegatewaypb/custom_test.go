@@ -0,0 +1,65 @@
+package storegatewaypb
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+func TestUnmarshalSeriesRequestHints(t *testing.T) {
+	blockMatchers := []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "__block_id", Value: "1|2"}}
+
+	tests := map[string]struct {
+		hints    proto.Message
+		expected *SeriesRequestHints
+	}{
+		"Thanos hints": {
+			hints:    &hintspb.SeriesRequestHints{BlockMatchers: blockMatchers},
+			expected: &SeriesRequestHints{BlockMatchers: blockMatchers},
+		},
+		"Cortex hints with shard": {
+			hints:    &SeriesRequestHints{BlockMatchers: blockMatchers, ShardInfo: &ShardInfo{ShardIndex: 1, TotalShards: 4}},
+			expected: &SeriesRequestHints{BlockMatchers: blockMatchers, ShardInfo: &ShardInfo{ShardIndex: 1, TotalShards: 4}},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			anyHints, err := types.MarshalAny(testData.hints)
+			require.NoError(t, err)
+
+			actual, err := UnmarshalSeriesRequestHints(anyHints)
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+
+	t.Run("unknown hints", func(t *testing.T) {
+		anyHints, err := types.MarshalAny(&hintspb.LabelNamesRequestHints{})
+		require.NoError(t, err)
+
+		_, err = UnmarshalSeriesRequestHints(anyHints)
+		require.Error(t, err)
+	})
+}
+
+func TestShardInfo_Matches(t *testing.T) {
+	series := labels.FromStrings(labels.MetricName, "metric", "pod", "pod-1")
+
+	var nilShard *ShardInfo
+	assert.True(t, nilShard.Matches(series))
+
+	matches := 0
+	for i := int64(0); i < 4; i++ {
+		if (&ShardInfo{ShardIndex: i, TotalShards: 4}).Matches(series) {
+			matches++
+		}
+	}
+	assert.Equal(t, 1, matches)
+}
@@ -0,0 +1,670 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: hints.proto
+
+package storegatewaypb
+
+import (
+	fmt "fmt"
+	_ "github.com/gogo/protobuf/gogoproto"
+	proto "github.com/gogo/protobuf/proto"
+	storepb "github.com/thanos-io/thanos/pkg/store/storepb"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+	reflect "reflect"
+	strings "strings"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+// SeriesRequestHints is wire-compatible with the Thanos hintspb.SeriesRequestHints,
+// extended with the query shard.
+type SeriesRequestHints struct {
+	// block_matchers is a list of label matchers that are evaluated against each single block's
+	// labels to filter which blocks get queried. If the list is empty, no per-block filtering
+	// is applied.
+	BlockMatchers []storepb.LabelMatcher `protobuf:"bytes,1,rep,name=block_matchers,json=blockMatchers,proto3" json:"block_matchers"`
+	// shard_info, if set, selects only the series belonging to the given shard. Series are
+	// filtered by shard before their chunks are loaded.
+	ShardInfo *ShardInfo `protobuf:"bytes,2,opt,name=shard_info,json=shardInfo,proto3" json:"shard_info,omitempty"`
+}
+
+func (m *SeriesRequestHints) Reset()      { *m = SeriesRequestHints{} }
+func (*SeriesRequestHints) ProtoMessage() {}
+func (*SeriesRequestHints) Descriptor() ([]byte, []int) {
+	return fileDescriptor_522be8e0d2634375, []int{0}
+}
+func (m *SeriesRequestHints) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SeriesRequestHints) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SeriesRequestHints.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SeriesRequestHints) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SeriesRequestHints.Merge(m, src)
+}
+func (m *SeriesRequestHints) XXX_Size() int {
+	return m.Size()
+}
+func (m *SeriesRequestHints) XXX_DiscardUnknown() {
+	xxx_messageInfo_SeriesRequestHints.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SeriesRequestHints proto.InternalMessageInfo
+
+func (m *SeriesRequestHints) GetBlockMatchers() []storepb.LabelMatcher {
+	if m != nil {
+		return m.BlockMatchers
+	}
+	return nil
+}
+
+func (m *SeriesRequestHints) GetShardInfo() *ShardInfo {
+	if m != nil {
+		return m.ShardInfo
+	}
+	return nil
+}
+
+type ShardInfo struct {
+	// shard_index is the index of the shard, in the range [0, total_shards).
+	ShardIndex int64 `protobuf:"varint,1,opt,name=shard_index,json=shardIndex,proto3" json:"shard_index,omitempty"`
+	// total_shards is the number of shards the series are split into. Series are assigned
+	// to a shard by the hash of their labels, modulo the number of shards.
+	TotalShards int64 `protobuf:"varint,2,opt,name=total_shards,json=totalShards,proto3" json:"total_shards,omitempty"`
+}
+
+func (m *ShardInfo) Reset()      { *m = ShardInfo{} }
+func (*ShardInfo) ProtoMessage() {}
+func (*ShardInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_522be8e0d2634375, []int{1}
+}
+func (m *ShardInfo) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ShardInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ShardInfo.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ShardInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ShardInfo.Merge(m, src)
+}
+func (m *ShardInfo) XXX_Size() int {
+	return m.Size()
+}
+func (m *ShardInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_ShardInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ShardInfo proto.InternalMessageInfo
+
+func (m *ShardInfo) GetShardIndex() int64 {
+	if m != nil {
+		return m.ShardIndex
+	}
+	return 0
+}
+
+func (m *ShardInfo) GetTotalShards() int64 {
+	if m != nil {
+		return m.TotalShards
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*SeriesRequestHints)(nil), "gatewaypb.SeriesRequestHints")
+	proto.RegisterType((*ShardInfo)(nil), "gatewaypb.ShardInfo")
+}
+
+func init() { proto.RegisterFile("hints.proto", fileDescriptor_522be8e0d2634375) }
+
+var fileDescriptor_522be8e0d2634375 = []byte{
+	// 322 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x3c, 0x90, 0xb1, 0x6e, 0xfa, 0x30,
+	0x10, 0xc6, 0xed, 0x3f, 0x7f, 0x55, 0xc2, 0x69, 0x19, 0x22, 0x06, 0xc4, 0x70, 0x50, 0x26, 0x96,
+	0x26, 0x52, 0x99, 0x3a, 0x16, 0x75, 0x68, 0xa5, 0x56, 0x95, 0xc2, 0xd6, 0x05, 0xd9, 0x60, 0x92,
+	0x08, 0xc8, 0xa5, 0xb1, 0x51, 0x61, 0xeb, 0x03, 0x74, 0xe8, 0x63, 0xf4, 0x51, 0x18, 0x19, 0x99,
+	0xaa, 0x62, 0x96, 0x8e, 0x3c, 0x42, 0x85, 0x13, 0xb2, 0x58, 0x9f, 0x7e, 0x77, 0xf7, 0x9d, 0xef,
+	0x63, 0x4e, 0x14, 0x27, 0x5a, 0x79, 0x69, 0x86, 0x1a, 0xdd, 0x6a, 0xc8, 0xb5, 0x7c, 0xe3, 0xab,
+	0x54, 0x34, 0xeb, 0x21, 0x86, 0x68, 0xa9, 0x7f, 0x54, 0x79, 0x43, 0xf3, 0x26, 0x8c, 0x75, 0xb4,
+	0x10, 0xde, 0x08, 0xe7, 0xbe, 0x8e, 0x78, 0x82, 0xea, 0x2a, 0xc6, 0x42, 0xf9, 0xe9, 0x34, 0xf4,
+	0x95, 0xc6, 0x4c, 0xe6, 0x6f, 0x2a, 0x7c, 0xbd, 0x4a, 0x65, 0xe1, 0xdd, 0xf9, 0xa0, 0xcc, 0x1d,
+	0xc8, 0x2c, 0x96, 0x2a, 0x90, 0xaf, 0x0b, 0xa9, 0xf4, 0xfd, 0x71, 0xb1, 0x7b, 0xcb, 0x6a, 0x62,
+	0x86, 0xa3, 0xe9, 0x70, 0xce, 0xf5, 0x28, 0x92, 0x99, 0x6a, 0xd0, 0x76, 0xa5, 0xeb, 0x5c, 0xd7,
+	0xbd, 0xdc, 0xd5, 0x7b, 0xe4, 0x42, 0xce, 0x9e, 0xf2, 0x62, 0xff, 0xff, 0xfa, 0xbb, 0x45, 0x82,
+	0x0b, 0x3b, 0x51, 0x30, 0xe5, 0xf6, 0x18, 0x53, 0x11, 0xcf, 0xc6, 0xc3, 0x38, 0x99, 0x60, 0xe3,
+	0x5f, 0x9b, 0xda, 0xf1, 0xf2, 0x14, 0x6f, 0x70, 0x2c, 0x3e, 0x24, 0x13, 0x0c, 0xaa, 0xea, 0x24,
+	0x3b, 0xcf, 0xac, 0x5a, 0x72, 0xb7, 0xc5, 0x9c, 0x93, 0xc3, 0x58, 0x2e, 0x1b, 0xb4, 0x4d, 0xbb,
+	0x95, 0x80, 0x15, 0xcd, 0x63, 0xb9, 0x74, 0x2f, 0xd9, 0xb9, 0x46, 0xcd, 0x67, 0x43, 0xcb, 0x94,
+	0x5d, 0x52, 0x09, 0x1c, 0xcb, 0xac, 0x8d, 0xea, 0xdf, 0x6d, 0x76, 0x40, 0xb6, 0x3b, 0x20, 0x87,
+	0x1d, 0xd0, 0x77, 0x03, 0xf4, 0xcb, 0x00, 0x59, 0x1b, 0xa0, 0x1b, 0x03, 0xf4, 0xc7, 0x00, 0xfd,
+	0x35, 0x40, 0x0e, 0x06, 0xe8, 0xe7, 0x1e, 0xc8, 0x66, 0x0f, 0x64, 0xbb, 0x07, 0xf2, 0x52, 0xb3,
+	0x51, 0x95, 0x7f, 0x15, 0x67, 0x36, 0xac, 0xde, 0xdf, 0x00, 0x95, 0x8a, 0xeb, 0x59, 0x97, 0x01,
+	0x00, 0x00,
+}
+
+func (this *SeriesRequestHints) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&storegatewaypb.SeriesRequestHints{")
+	if this.BlockMatchers != nil {
+		vs := make([]storepb.LabelMatcher, len(this.BlockMatchers))
+		for i := range vs {
+			vs[i] = this.BlockMatchers[i]
+		}
+		s = append(s, "BlockMatchers: "+fmt.Sprintf("%#v", vs)+",\n")
+	}
+	if this.ShardInfo != nil {
+		s = append(s, "ShardInfo: "+fmt.Sprintf("%#v", this.ShardInfo)+",\n")
+	}
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *ShardInfo) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&storegatewaypb.ShardInfo{")
+	s = append(s, "ShardIndex: "+fmt.Sprintf("%#v", this.ShardIndex)+",\n")
+	s = append(s, "TotalShards: "+fmt.Sprintf("%#v", this.TotalShards)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func valueToGoStringHints(v interface{}, typ string) string {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return "nil"
+	}
+	pv := reflect.Indirect(rv).Interface()
+	return fmt.Sprintf("func(v %v) *%v { return &v } ( %#v )", typ, typ, pv)
+}
+func (m *SeriesRequestHints) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesRequestHints) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *SeriesRequestHints) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.ShardInfo != nil {
+		{
+			size, err := m.ShardInfo.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintHints(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.BlockMatchers) > 0 {
+		for iNdEx := len(m.BlockMatchers) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.BlockMatchers[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintHints(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ShardInfo) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ShardInfo) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ShardInfo) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.TotalShards != 0 {
+		i = encodeVarintHints(dAtA, i, uint64(m.TotalShards))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.ShardIndex != 0 {
+		i = encodeVarintHints(dAtA, i, uint64(m.ShardIndex))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintHints(dAtA []byte, offset int, v uint64) int {
+	offset -= sovHints(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *SeriesRequestHints) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.BlockMatchers) > 0 {
+		for _, e := range m.BlockMatchers {
+			l = e.Size()
+			n += 1 + l + sovHints(uint64(l))
+		}
+	}
+	if m.ShardInfo != nil {
+		l = m.ShardInfo.Size()
+		n += 1 + l + sovHints(uint64(l))
+	}
+	return n
+}
+
+func (m *ShardInfo) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.ShardIndex != 0 {
+		n += 1 + sovHints(uint64(m.ShardIndex))
+	}
+	if m.TotalShards != 0 {
+		n += 1 + sovHints(uint64(m.TotalShards))
+	}
+	return n
+}
+
+func sovHints(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozHints(x uint64) (n int) {
+	return sovHints(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (this *SeriesRequestHints) String() string {
+	if this == nil {
+		return "nil"
+	}
+	repeatedStringForBlockMatchers := "[]LabelMatcher{"
+	for _, f := range this.BlockMatchers {
+		repeatedStringForBlockMatchers += fmt.Sprintf("%v", f) + ","
+	}
+	repeatedStringForBlockMatchers += "}"
+	s := strings.Join([]string{`&SeriesRequestHints{`,
+		`BlockMatchers:` + repeatedStringForBlockMatchers + `,`,
+		`ShardInfo:` + strings.Replace(this.ShardInfo.String(), "ShardInfo", "ShardInfo", 1) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *ShardInfo) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&ShardInfo{`,
+		`ShardIndex:` + fmt.Sprintf("%v", this.ShardIndex) + `,`,
+		`TotalShards:` + fmt.Sprintf("%v", this.TotalShards) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func valueToStringHints(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return "nil"
+	}
+	pv := reflect.Indirect(rv).Interface()
+	return fmt.Sprintf("*%v", pv)
+}
+func (m *SeriesRequestHints) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowHints
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesRequestHints: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesRequestHints: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field BlockMatchers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthHints
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthHints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.BlockMatchers = append(m.BlockMatchers, storepb.LabelMatcher{})
+			if err := m.BlockMatchers[len(m.BlockMatchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ShardInfo", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthHints
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthHints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ShardInfo == nil {
+				m.ShardInfo = &ShardInfo{}
+			}
+			if err := m.ShardInfo.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipHints(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthHints
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ShardInfo) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowHints
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ShardInfo: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ShardInfo: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ShardIndex", wireType)
+			}
+			m.ShardIndex = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ShardIndex |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TotalShards", wireType)
+			}
+			m.TotalShards = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TotalShards |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipHints(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthHints
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipHints(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	depth := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowHints
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowHints
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+		case 1:
+			iNdEx += 8
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowHints
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthHints
+			}
+			iNdEx += length
+		case 3:
+			depth++
+		case 4:
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupHints
+			}
+			depth--
+		case 5:
+			iNdEx += 4
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthHints
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+var (
+	ErrInvalidLengthHints        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowHints          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupHints = fmt.Errorf("proto: unexpected end of group")
+)
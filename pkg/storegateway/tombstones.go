@@ -0,0 +1,219 @@
+package storegateway
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+// deletedInterval is a time range (milliseconds, both inclusive) of deleted samples.
+type deletedInterval struct {
+	minT, maxT int64
+}
+
+// deletedIntervals returns the time ranges of the samples of the input series
+// deleted by the tombstones.
+func deletedIntervals(tombstones []*cortex_tsdb.Tombstone, lset labels.Labels) []deletedInterval {
+	var intervals []deletedInterval
+
+	for _, t := range tombstones {
+		for _, matchers := range t.Matchers {
+			if matchLabels(matchers, lset) {
+				intervals = append(intervals, deletedInterval{minT: t.StartTime, maxT: t.EndTime})
+				break
+			}
+		}
+	}
+
+	return intervals
+}
+
+func matchLabels(matchers []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDeleted(intervals []deletedInterval, ts int64) bool {
+	for _, i := range intervals {
+		if ts >= i.minT && ts <= i.maxT {
+			return true
+		}
+	}
+	return false
+}
+
+func isFullyDeleted(intervals []deletedInterval, minT, maxT int64) bool {
+	for _, i := range intervals {
+		if i.minT <= minT && maxT <= i.maxT {
+			return true
+		}
+	}
+	return false
+}
+
+func overlapsDeleted(intervals []deletedInterval, minT, maxT int64) bool {
+	for _, i := range intervals {
+		if i.minT <= maxT && minT <= i.maxT {
+			return true
+		}
+	}
+	return false
+}
+
+// tombstonesSeriesServer filters out the samples deleted by the tenant's tombstones
+// from the series sent to the querier. Series whose samples have all been deleted
+// are not sent at all.
+type tombstonesSeriesServer struct {
+	storepb.Store_SeriesServer
+
+	tombstones []*cortex_tsdb.Tombstone
+
+	// Time range of the request, used to filter series when chunks are skipped.
+	minT, maxT int64
+}
+
+func newTombstonesSeriesServer(srv storepb.Store_SeriesServer, tombstones []*cortex_tsdb.Tombstone, minT, maxT int64) *tombstonesSeriesServer {
+	return &tombstonesSeriesServer{
+		Store_SeriesServer: srv,
+		tombstones:         tombstones,
+		minT:               minT,
+		maxT:               maxT,
+	}
+}
+
+func (s *tombstonesSeriesServer) Send(resp *storepb.SeriesResponse) error {
+	series := resp.GetSeries()
+	if series == nil {
+		return s.Store_SeriesServer.Send(resp)
+	}
+
+	intervals := deletedIntervals(s.tombstones, labelpb.ZLabelsToPromLabels(series.Labels))
+	if len(intervals) == 0 {
+		return s.Store_SeriesServer.Send(resp)
+	}
+
+	// When chunks are skipped, we can only tell whether the series has been
+	// deleted within the whole queried time range.
+	if len(series.Chunks) == 0 {
+		if isFullyDeleted(intervals, s.minT, s.maxT) {
+			return nil
+		}
+		return s.Store_SeriesServer.Send(resp)
+	}
+
+	chunks := make([]storepb.AggrChunk, 0, len(series.Chunks))
+	for _, chk := range series.Chunks {
+		if !overlapsDeleted(intervals, chk.MinTime, chk.MaxTime) {
+			chunks = append(chunks, chk)
+			continue
+		}
+		if isFullyDeleted(intervals, chk.MinTime, chk.MaxTime) {
+			continue
+		}
+
+		filtered, ok, err := filterAggrChunk(chk, intervals)
+		if err != nil {
+			return err
+		}
+		if ok {
+			chunks = append(chunks, filtered)
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	series.Chunks = chunks
+	return s.Store_SeriesServer.Send(resp)
+}
+
+// filterAggrChunk re-encodes each chunk of the input aggregated chunk without the
+// deleted samples. Returns false if all the samples have been deleted.
+func filterAggrChunk(chk storepb.AggrChunk, intervals []deletedInterval) (storepb.AggrChunk, bool, error) {
+	out := storepb.AggrChunk{MinTime: chk.MinTime, MaxTime: chk.MaxTime}
+	found := false
+
+	for _, c := range []struct {
+		in  *storepb.Chunk
+		out **storepb.Chunk
+	}{
+		{in: chk.Raw, out: &out.Raw},
+		{in: chk.Count, out: &out.Count},
+		{in: chk.Sum, out: &out.Sum},
+		{in: chk.Min, out: &out.Min},
+		{in: chk.Max, out: &out.Max},
+		{in: chk.Counter, out: &out.Counter},
+	} {
+		if c.in == nil {
+			continue
+		}
+
+		filtered, minT, maxT, err := filterChunk(c.in, intervals)
+		if err != nil {
+			return storepb.AggrChunk{}, false, err
+		}
+		if filtered == nil {
+			continue
+		}
+
+		*c.out = filtered
+		if !found {
+			out.MinTime, out.MaxTime = minT, maxT
+			found = true
+		}
+	}
+
+	return out, found, nil
+}
+
+// filterChunk re-encodes the input XOR chunk without the deleted samples. Returns
+// a nil chunk if all the samples have been deleted.
+func filterChunk(chk *storepb.Chunk, intervals []deletedInterval) (*storepb.Chunk, int64, int64, error) {
+	if chk.Type != storepb.Chunk_XOR {
+		return nil, 0, 0, errors.Errorf("unsupported chunk encoding %s", chk.Type.String())
+	}
+
+	in, err := chunkenc.FromData(chunkenc.EncXOR, chk.Data)
+	if err != nil {
+		return nil, 0, 0, errors.Wrap(err, "decode chunk")
+	}
+
+	out := chunkenc.NewXORChunk()
+	app, err := out.Appender()
+	if err != nil {
+		return nil, 0, 0, errors.Wrap(err, "create chunk appender")
+	}
+
+	var minT, maxT int64
+	it := in.Iterator(nil)
+	for it.Next() {
+		ts, v := it.At()
+		if isDeleted(intervals, ts) {
+			continue
+		}
+
+		if out.NumSamples() == 0 {
+			minT = ts
+		}
+		maxT = ts
+		app.Append(ts, v)
+	}
+	if err := it.Err(); err != nil {
+		return nil, 0, 0, errors.Wrap(err, "iterate chunk")
+	}
+
+	if out.NumSamples() == 0 {
+		return nil, 0, 0, nil
+	}
+
+	return &storepb.Chunk{Type: storepb.Chunk_XOR, Data: out.Bytes()}, minT, maxT, nil
+}
@@ -0,0 +1,91 @@
+package storegateway
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket/filesystem"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+func TestBucketStores_Series_ShouldFilterOutDeletedSeries(t *testing.T) {
+	const userID = "user-1"
+
+	ctx := context.Background()
+	cfg, cleanup := prepareStorageConfig(t)
+	cfg.BucketStore.SeriesDeletionEnabled = true
+	defer cleanup()
+
+	storageDir, err := ioutil.TempDir(os.TempDir(), "storage-*")
+	require.NoError(t, err)
+
+	generateStorageBlock(t, storageDir, userID, "series_1", 0, 1000, 1)
+	generateStorageBlock(t, storageDir, userID, "series_2", 0, 1000, 1)
+	generateStorageBlock(t, storageDir, userID, "series_3", 0, 1000, 1)
+
+	bucket, err := filesystem.NewBucketClient(filesystem.Config{Directory: storageDir})
+	require.NoError(t, err)
+
+	// Partially delete series_1, fully delete series_2 and cancel the deletion of series_3.
+	for _, tombstone := range []struct {
+		requestID  string
+		minT, maxT int64
+		selector   string
+		state      cortex_tsdb.TombstoneState
+	}{
+		{requestID: "partial", minT: 100, maxT: 199, selector: `series_1`, state: cortex_tsdb.TombstonePending},
+		{requestID: "full", minT: 0, maxT: 1000, selector: `{__name__="series_2"}`, state: cortex_tsdb.TombstoneProcessed},
+		{requestID: "cancelled", minT: 0, maxT: 1000, selector: `series_3`, state: cortex_tsdb.TombstoneCancelled},
+	} {
+		ts, err := cortex_tsdb.NewTombstone(tombstone.requestID, 0, tombstone.minT, tombstone.maxT, []string{tombstone.selector})
+		require.NoError(t, err)
+		ts.State = tombstone.state
+		require.NoError(t, cortex_tsdb.WriteTombstone(ctx, bucket, userID, nil, ts))
+	}
+
+	stores, err := NewBucketStores(cfg, NewNoShardingStrategy(), bucket, defaultLimitsOverrides(t), mockLoggingLevel(), log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+	require.NoError(t, stores.InitialSync(ctx))
+
+	// The partially deleted series should be returned without the deleted samples.
+	seriesSet, _, err := querySeries(stores, userID, "series_1", math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	require.Len(t, seriesSet, 1)
+
+	samples, err := readSamplesFromChunks(seriesSet[0].Chunks)
+	require.NoError(t, err)
+	assert.Len(t, samples, 900)
+	for _, s := range samples {
+		assert.False(t, s.ts >= 100 && s.ts <= 199, "unexpected deleted sample at %d", s.ts)
+	}
+
+	// The fully deleted series should not be returned at all.
+	seriesSet, _, err = querySeries(stores, userID, "series_2", math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Empty(t, seriesSet)
+
+	// Cancelled tombstones should be ignored.
+	seriesSet, _, err = querySeries(stores, userID, "series_3", math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	require.Len(t, seriesSet, 1)
+
+	samples, err = readSamplesFromChunks(seriesSet[0].Chunks)
+	require.NoError(t, err)
+	assert.Len(t, samples, 1000)
+
+	// Once a tombstone is deleted, the next sync should stop filtering the series.
+	require.NoError(t, cortex_tsdb.DeleteTombstone(ctx, bucket, userID, nil, "full"))
+	require.NoError(t, stores.SyncBlocks(ctx))
+
+	seriesSet, _, err = querySeries(stores, userID, "series_2", math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Len(t, seriesSet, 1)
+}
@@ -0,0 +1,142 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// CostAttributionMissingValue is the attribution value of the series without the cost attribution label.
+const CostAttributionMissingValue = "__missing__"
+
+// CostAttributionOverflowValue is the attribution value of the series whose cost attribution
+// label value can't be tracked because the tenant reached the max number of tracked values.
+const CostAttributionOverflowValue = "__overflow__"
+
+// CostAttributionLimits provides the per-tenant cost attribution settings.
+type CostAttributionLimits interface {
+	CostAttributionLabel(userID string) string
+	MaxCostAttributionPerUser(userID string) int
+}
+
+// CostAttribution keeps track of the values of the per-tenant cost attribution label, capping
+// the number of distinct values tracked for each tenant, and allows purging the values which
+// are no longer in use.
+type CostAttribution struct {
+	limits CostAttributionLimits
+
+	mu    sync.Mutex
+	users map[string]*userCostAttribution
+
+	// Values no longer tracked because the tenant's cost attribution label changed,
+	// which are returned by the next PurgeInactive().
+	evicted map[string][]string
+}
+
+type userCostAttribution struct {
+	label  string
+	values map[string]*costAttributionValue
+}
+
+type costAttributionValue struct {
+	// Copy of the value, safe to be retained, because the value passed to Attribute()
+	// may be backed by a buffer which is reused once the request has been processed.
+	value    string
+	lastSeen int64 // Unix timestamp in nanoseconds.
+}
+
+func NewCostAttribution(limits CostAttributionLimits) *CostAttribution {
+	return &CostAttribution{
+		limits:  limits,
+		users:   map[string]*userCostAttribution{},
+		evicted: map[string][]string{},
+	}
+}
+
+// Label returns the tenant's cost attribution label, or an empty string if cost attribution
+// is disabled for the tenant.
+func (c *CostAttribution) Label(userID string) string {
+	return c.limits.CostAttributionLabel(userID)
+}
+
+// Attribute returns the attribution value for a series of the tenant having the given value
+// of the tenant's cost attribution label, or an empty value if the series doesn't have the label.
+// CostAttributionOverflowValue is returned if the value is not tracked yet and the tenant already
+// reached the max number of tracked values.
+func (c *CostAttribution) Attribute(userID, value string, now time.Time) string {
+	label := c.limits.CostAttributionLabel(userID)
+	if value == "" {
+		value = CostAttributionMissingValue
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u := c.users[userID]
+	if u == nil || u.label != label {
+		if u != nil {
+			c.evicted[userID] = append(c.evicted[userID], u.attributions()...)
+		}
+
+		u = &userCostAttribution{label: label, values: map[string]*costAttributionValue{}}
+		c.users[userID] = u
+	}
+
+	v := u.values[value]
+	if v == nil {
+		if limit := c.limits.MaxCostAttributionPerUser(userID); limit > 0 && len(u.values) >= limit {
+			value = CostAttributionOverflowValue
+		}
+
+		if v = u.values[value]; v == nil {
+			v = &costAttributionValue{value: string(append([]byte(nil), value...))}
+			u.values[v.value] = v
+		}
+	}
+
+	v.lastSeen = now.UnixNano()
+	return v.value
+}
+
+// PurgeInactive removes the values last seen before the given deadline, and returns the removed
+// attribution values by tenant.
+func (c *CostAttribution) PurgeInactive(deadline time.Time) map[string][]string {
+	deadlineNanos := deadline.UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := c.evicted
+	c.evicted = map[string][]string{}
+
+	for userID, u := range c.users {
+		for value, v := range u.values {
+			if v.lastSeen < deadlineNanos {
+				delete(u.values, value)
+				removed[userID] = append(removed[userID], value)
+			}
+		}
+
+		if len(u.values) == 0 {
+			delete(c.users, userID)
+		}
+	}
+
+	return removed
+}
+
+// DeleteUser removes all the tracked values of the tenant.
+func (c *CostAttribution) DeleteUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.users, userID)
+	delete(c.evicted, userID)
+}
+
+func (u *userCostAttribution) attributions() []string {
+	out := make([]string, 0, len(u.values))
+	for value := range u.values {
+		out = append(out, value)
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type costAttributionLimitsMock struct {
+	label     string
+	maxValues int
+}
+
+func (m *costAttributionLimitsMock) CostAttributionLabel(_ string) string {
+	return m.label
+}
+
+func (m *costAttributionLimitsMock) MaxCostAttributionPerUser(_ string) int {
+	return m.maxValues
+}
+
+func TestCostAttribution_Attribute(t *testing.T) {
+	now := time.Now()
+	c := NewCostAttribution(&costAttributionLimitsMock{label: "team", maxValues: 3})
+
+	assert.Equal(t, "a", c.Attribute("user-1", "a", now))
+	assert.Equal(t, CostAttributionMissingValue, c.Attribute("user-1", "", now))
+	assert.Equal(t, "b", c.Attribute("user-1", "b", now))
+
+	// The max number of values has been reached.
+	assert.Equal(t, CostAttributionOverflowValue, c.Attribute("user-1", "c", now))
+	assert.Equal(t, CostAttributionOverflowValue, c.Attribute("user-1", "d", now))
+	assert.Equal(t, "a", c.Attribute("user-1", "a", now))
+
+	// The limit is applied per tenant.
+	assert.Equal(t, "c", c.Attribute("user-2", "c", now))
+}
+
+func TestCostAttribution_PurgeInactive(t *testing.T) {
+	now := time.Now()
+	limits := &costAttributionLimitsMock{label: "team", maxValues: 2}
+	c := NewCostAttribution(limits)
+
+	c.Attribute("user-1", "a", now.Add(-time.Minute))
+	c.Attribute("user-1", "b", now)
+	c.Attribute("user-2", "a", now.Add(-time.Minute))
+
+	assert.Equal(t, map[string][]string{"user-1": {"a"}, "user-2": {"a"}}, c.PurgeInactive(now.Add(-time.Second)))
+	assert.Empty(t, c.PurgeInactive(now.Add(-time.Second)))
+
+	// A purged value releases a slot for a new value.
+	assert.Equal(t, "c", c.Attribute("user-1", "c", now))
+
+	// Changing the label evicts all the tenant's values.
+	limits.label = "service"
+	assert.Equal(t, "x", c.Attribute("user-1", "x", now))
+
+	removed := c.PurgeInactive(now.Add(-time.Second))
+	sort.Strings(removed["user-1"])
+	assert.Equal(t, map[string][]string{"user-1": {"b", "c"}}, removed)
+}
@@ -12,6 +12,9 @@ import (
 	"google.golang.org/grpc"
 )
 
+// TenantID is the tenant injected by the middlewares when auth is disabled.
+const TenantID = "fake"
+
 // SetupAuthMiddleware for the given server config.
 func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []string) middleware.Interface {
 	if enabled {
@@ -50,18 +53,18 @@ func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []str
 
 var fakeHTTPAuthMiddleware = middleware.Func(func(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := user.InjectOrgID(r.Context(), "fake")
+		ctx := user.InjectOrgID(r.Context(), TenantID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 })
 
 var fakeGRPCAuthUniaryMiddleware = func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	ctx = user.InjectOrgID(ctx, "fake")
+	ctx = user.InjectOrgID(ctx, TenantID)
 	return handler(ctx, req)
 }
 
 var fakeGRPCAuthStreamMiddleware = func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	ctx := user.InjectOrgID(ss.Context(), "fake")
+	ctx := user.InjectOrgID(ss.Context(), TenantID)
 	return handler(srv, serverStream{
 		ctx:          ctx,
 		ServerStream: ss,
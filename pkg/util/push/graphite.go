@@ -0,0 +1,297 @@
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/middleware"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+const (
+	pickleContentType = "application/python-pickle"
+
+	// Template parts used to build the metric name.
+	graphiteMeasurement         = "measurement"
+	graphiteMeasurementGreedy   = "measurement*"
+	graphiteMetricNameSeparator = "_"
+)
+
+// GraphiteTemplate maps the nodes of the Graphite metric paths matching the filter
+// to the metric name and labels.
+type GraphiteTemplate struct {
+	filter []string
+	parts  []string
+}
+
+// ParseGraphiteTemplates parses a list of templates in the "[filter] template" format. The filter
+// is a dot separated list of glob patterns, matched against the leading nodes of the metric path.
+// The template is a dot separated list of label names to assign the corresponding path nodes to,
+// where "measurement" nodes are joined together to build the metric name, "measurement*" joins all
+// the remaining nodes to the metric name and empty parts skip a node. Templates without a filter
+// match any path.
+func ParseGraphiteTemplates(templates []string) ([]GraphiteTemplate, error) {
+	result := make([]GraphiteTemplate, 0, len(templates))
+
+	for _, t := range templates {
+		fields := strings.Fields(t)
+
+		var tmpl GraphiteTemplate
+		switch len(fields) {
+		case 1:
+			tmpl.parts = strings.Split(fields[0], ".")
+		case 2:
+			tmpl.filter = strings.Split(fields[0], ".")
+			tmpl.parts = strings.Split(fields[1], ".")
+		default:
+			return nil, fmt.Errorf("invalid graphite template %q: expected \"[filter] template\"", t)
+		}
+
+		for _, pattern := range tmpl.filter {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid graphite template %q: invalid filter %q", t, pattern)
+			}
+		}
+
+		hasMeasurement := false
+		for i, part := range tmpl.parts {
+			switch {
+			case part == graphiteMeasurement:
+				hasMeasurement = true
+			case part == graphiteMeasurementGreedy:
+				if i != len(tmpl.parts)-1 {
+					return nil, fmt.Errorf("invalid graphite template %q: %q must be the last part", t, graphiteMeasurementGreedy)
+				}
+				hasMeasurement = true
+			case part != "" && sanitizeLabelName(part) != part:
+				return nil, fmt.Errorf("invalid graphite template %q: invalid label name %q", t, part)
+			}
+		}
+		if !hasMeasurement {
+			return nil, fmt.Errorf("invalid graphite template %q: no measurement", t)
+		}
+
+		result = append(result, tmpl)
+	}
+
+	return result, nil
+}
+
+func (t GraphiteTemplate) matches(nodes []string) bool {
+	if len(nodes) < len(t.filter) {
+		return false
+	}
+	for i, pattern := range t.filter {
+		if ok, _ := path.Match(pattern, nodes[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the metric name and labels for the input path nodes.
+func (t GraphiteTemplate) apply(nodes []string, b *labels.Builder) string {
+	var name []string
+	for i, part := range t.parts {
+		if i >= len(nodes) {
+			break
+		}
+
+		switch part {
+		case "":
+		case graphiteMeasurement:
+			name = append(name, nodes[i])
+		case graphiteMeasurementGreedy:
+			name = append(name, nodes[i:]...)
+		default:
+			b.Set(part, nodes[i])
+		}
+	}
+	return strings.Join(name, graphiteMetricNameSeparator)
+}
+
+// GraphiteHandler is a http.Handler which accepts Graphite metrics, either in the plaintext
+// protocol or pickle serialized when the request content type is "application/python-pickle",
+// and pushes them as WriteRequests. Metric paths are mapped to the metric name and labels with
+// the first matching template. Paths not matching any template are converted to a metric name
+// by replacing dots with underscores.
+func GraphiteHandler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, templates []GraphiteTemplate, push Func) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, logger := contextWithSourceIPs(r, sourceIPs)
+
+		req, err := parseGraphiteRequest(r, maxRecvMsgSize, templates, time.Now())
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := push(ctx, req); err != nil {
+			writePushError(w, logger, err)
+		}
+	})
+}
+
+func parseGraphiteRequest(r *http.Request, maxRecvMsgSize int, templates []GraphiteTemplate, now time.Time) (*cortexpb.WriteRequest, error) {
+	body, err := readRequestBody(r, maxRecvMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []graphitePoint
+	if strings.HasPrefix(r.Header.Get("Content-Type"), pickleContentType) {
+		points, err = parseGraphitePickle(body, now)
+	} else {
+		points, err = parseGraphitePlaintext(body, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]labels.Labels, 0, len(points))
+	samples := make([]cortexpb.Sample, 0, len(points))
+	for _, p := range points {
+		lbls, err := graphiteLabels(p.path, templates)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, lbls)
+		samples = append(samples, cortexpb.Sample{TimestampMs: p.timestampMs, Value: p.value})
+	}
+
+	return cortexpb.ToWriteRequest(series, samples, nil, cortexpb.API), nil
+}
+
+type graphitePoint struct {
+	path        string
+	value       float64
+	timestampMs int64
+}
+
+// parseGraphitePlaintext parses metrics in the "<path> <value> <timestamp>" format, one per line.
+// The timestamp is in seconds and points without a timestamp, or with a negative one, get the
+// input now time.
+func parseGraphitePlaintext(body []byte, now time.Time) ([]graphitePoint, error) {
+	var points []graphitePoint
+
+	for num, line := range bytes.Split(body, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("unable to parse line %d: expected \"<path> <value> [timestamp]\"", num+1)
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse line %d: invalid value %q", num+1, fields[1])
+		}
+
+		timestamp := float64(-1)
+		if len(fields) == 3 {
+			if timestamp, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return nil, fmt.Errorf("unable to parse line %d: invalid timestamp %q", num+1, fields[2])
+			}
+		}
+
+		points = append(points, graphitePoint{path: fields[0], value: value, timestampMs: graphiteTimestampMs(timestamp, now)})
+	}
+
+	return points, nil
+}
+
+// parseGraphitePickle parses metrics serialized as a pickled list of (path, (timestamp, value)) tuples.
+func parseGraphitePickle(body []byte, now time.Time) ([]graphitePoint, error) {
+	decoded, err := unpickle(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode pickle")
+	}
+
+	list, ok := decoded.(*pickleList)
+	if !ok {
+		return nil, errors.New("decode pickle: expected a list of metrics")
+	}
+
+	points := make([]graphitePoint, 0, len(list.items))
+	for i, item := range list.items {
+		metric, ok := pickleSequence(item)
+		if !ok || len(metric) != 2 {
+			return nil, fmt.Errorf("decode pickle: metric %d is not a (path, (timestamp, value)) tuple", i)
+		}
+		datapoint, ok := pickleSequence(metric[1])
+		if !ok || len(datapoint) != 2 {
+			return nil, fmt.Errorf("decode pickle: metric %d is not a (path, (timestamp, value)) tuple", i)
+		}
+
+		path, ok := metric[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("decode pickle: metric %d has an invalid path", i)
+		}
+		timestamp, ok := pickleNumber(datapoint[0])
+		if !ok {
+			return nil, fmt.Errorf("decode pickle: metric %d has an invalid timestamp", i)
+		}
+		value, ok := pickleNumber(datapoint[1])
+		if !ok {
+			return nil, fmt.Errorf("decode pickle: metric %d has an invalid value", i)
+		}
+
+		points = append(points, graphitePoint{path: path, value: value, timestampMs: graphiteTimestampMs(timestamp, now)})
+	}
+
+	return points, nil
+}
+
+func graphiteTimestampMs(seconds float64, now time.Time) int64 {
+	if seconds < 0 || math.IsNaN(seconds) {
+		return now.UnixNano() / int64(time.Millisecond)
+	}
+	return int64(seconds * 1000)
+}
+
+// graphiteLabels returns the labels for the input metric path, which may include tags
+// in the "path;tag1=value1;tag2=value2" format. Tags take precedence over the labels
+// assigned by the template.
+func graphiteLabels(metricPath string, templates []GraphiteTemplate) (labels.Labels, error) {
+	tags := strings.Split(metricPath, ";")
+	nodes := strings.Split(tags[0], ".")
+
+	b := labels.NewBuilder(nil)
+
+	name := ""
+	for _, t := range templates {
+		if t.matches(nodes) {
+			name = t.apply(nodes, b)
+			break
+		}
+	}
+	if name == "" {
+		name = strings.Join(nodes, graphiteMetricNameSeparator)
+	}
+
+	for _, tag := range tags[1:] {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag %q in metric %q", tag, metricPath)
+		}
+		b.Set(sanitizeLabelName(parts[0]), parts[1])
+	}
+
+	if name = sanitizeMetricName(name); name == "" {
+		return nil, fmt.Errorf("invalid metric %q: empty name", metricPath)
+	}
+	b.Set(labels.MetricName, name)
+
+	return b.Labels(), nil
+}
@@ -0,0 +1,407 @@
+package push
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/services"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/log"
+)
+
+const (
+	graphiteProtocolPlaintextTCP = "plaintext-tcp"
+	graphiteProtocolPlaintextUDP = "plaintext-udp"
+	graphiteProtocolPickle       = "pickle"
+
+	graphiteReasonInvalid    = "invalid"
+	graphiteReasonPushFailed = "push-failed"
+
+	// Max number of points received over a plaintext TCP connection pushed at once.
+	graphiteListenerBatchSize = 1000
+
+	// Max length of a line received over a plaintext TCP connection.
+	graphiteMaxLineSize = 64 * 1024
+
+	// Max size of a UDP datagram.
+	graphiteMaxDatagramSize = 64 * 1024
+)
+
+// GraphiteListenerConfig configures the GraphiteListener.
+type GraphiteListenerConfig struct {
+	// Address to listen on for the plaintext protocol, both over TCP and UDP. Disabled if empty.
+	PlaintextListenAddress string
+	// Address to listen on for the pickle protocol over TCP. Disabled if empty.
+	PickleListenAddress string
+	// Tenant the metrics are pushed to. If empty, the tenant is the first node of each metric path.
+	TenantID string
+
+	MaxRecvMsgSize int
+	Templates      []GraphiteTemplate
+}
+
+// GraphiteListener accepts Graphite metrics over raw TCP and UDP connections, in the plaintext
+// protocol on the plaintext address (both TCP and UDP) and in the pickle protocol on the pickle
+// address (TCP only), and pushes them as WriteRequests like the GraphiteHandler does. These
+// protocols can't carry the tenant ID, so the metrics are pushed to the configured tenant or,
+// if none is configured, to the tenant found in the first node of each metric path, which is
+// removed from the path before mapping it to labels.
+type GraphiteListener struct {
+	services.Service
+
+	cfg    GraphiteListenerConfig
+	push   Func
+	logger kitlog.Logger
+
+	plaintextTCP net.Listener
+	plaintextUDP net.PacketConn
+	pickleTCP    net.Listener
+
+	// Open connections, closed on stop.
+	connsMtx sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+
+	receivedPoints  *prometheus.CounterVec
+	discardedPoints *prometheus.CounterVec
+}
+
+// NewGraphiteListener makes a new GraphiteListener.
+func NewGraphiteListener(cfg GraphiteListenerConfig, push Func, logger kitlog.Logger, reg prometheus.Registerer) *GraphiteListener {
+	l := &GraphiteListener{
+		cfg:    cfg,
+		push:   push,
+		logger: logger,
+		conns:  map[net.Conn]struct{}{},
+		receivedPoints: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_distributor_graphite_received_points_total",
+			Help: "The total number of Graphite points received over TCP and UDP connections.",
+		}, []string{"protocol"}),
+		discardedPoints: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_distributor_graphite_discarded_points_total",
+			Help: "The total number of Graphite points received over TCP and UDP connections which have been discarded. Invalid lines and messages whose number of points is unknown count as one point.",
+		}, []string{"protocol", "reason"}),
+	}
+
+	l.Service = services.NewBasicService(l.starting, l.running, l.stopping)
+	return l
+}
+
+func (l *GraphiteListener) starting(_ context.Context) (err error) {
+	defer func() {
+		if err != nil {
+			l.closeListeners()
+		}
+	}()
+
+	if l.cfg.PlaintextListenAddress != "" {
+		if l.plaintextTCP, err = net.Listen("tcp", l.cfg.PlaintextListenAddress); err != nil {
+			return errors.Wrap(err, "listen for Graphite plaintext metrics over TCP")
+		}
+		if l.plaintextUDP, err = net.ListenPacket("udp", l.cfg.PlaintextListenAddress); err != nil {
+			return errors.Wrap(err, "listen for Graphite plaintext metrics over UDP")
+		}
+	}
+
+	if l.cfg.PickleListenAddress != "" {
+		if l.pickleTCP, err = net.Listen("tcp", l.cfg.PickleListenAddress); err != nil {
+			return errors.Wrap(err, "listen for Graphite pickle metrics over TCP")
+		}
+	}
+
+	return nil
+}
+
+func (l *GraphiteListener) running(ctx context.Context) error {
+	if l.plaintextTCP != nil {
+		l.wg.Add(2)
+		go l.accept(ctx, l.plaintextTCP, l.handlePlaintextConn)
+		go l.readPlaintextUDP(ctx)
+	}
+	if l.pickleTCP != nil {
+		l.wg.Add(1)
+		go l.accept(ctx, l.pickleTCP, l.handlePickleConn)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func (l *GraphiteListener) stopping(_ error) error {
+	l.closeListeners()
+
+	l.connsMtx.Lock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.connsMtx.Unlock()
+
+	l.wg.Wait()
+	return nil
+}
+
+func (l *GraphiteListener) closeListeners() {
+	for _, c := range []io.Closer{l.plaintextTCP, l.plaintextUDP, l.pickleTCP} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// PlaintextAddr returns the address the plaintext protocol is listened on over TCP, or nil if disabled.
+func (l *GraphiteListener) PlaintextAddr() net.Addr {
+	if l.plaintextTCP == nil {
+		return nil
+	}
+	return l.plaintextTCP.Addr()
+}
+
+// PlaintextUDPAddr returns the address the plaintext protocol is listened on over UDP, or nil if disabled.
+func (l *GraphiteListener) PlaintextUDPAddr() net.Addr {
+	if l.plaintextUDP == nil {
+		return nil
+	}
+	return l.plaintextUDP.LocalAddr()
+}
+
+// PickleAddr returns the address the pickle protocol is listened on, or nil if disabled.
+func (l *GraphiteListener) PickleAddr() net.Addr {
+	if l.pickleTCP == nil {
+		return nil
+	}
+	return l.pickleTCP.Addr()
+}
+
+func (l *GraphiteListener) accept(ctx context.Context, lis net.Listener, handle func(context.Context, net.Conn)) {
+	defer l.wg.Done()
+
+	for {
+		conn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			level.Warn(l.logger).Log("msg", "failed to accept Graphite connection", "err", err)
+			continue
+		}
+
+		// The context is canceled before stopping, so connections accepted while stopping are not missed.
+		l.connsMtx.Lock()
+		if ctx.Err() != nil {
+			l.connsMtx.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.connsMtx.Unlock()
+
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			defer func() {
+				l.connsMtx.Lock()
+				delete(l.conns, conn)
+				l.connsMtx.Unlock()
+				conn.Close()
+			}()
+
+			handle(ctx, conn)
+		}()
+	}
+}
+
+// handlePlaintextConn reads the lines of the plaintext protocol from the connection, and pushes them in
+// batches of up to graphiteListenerBatchSize points, or earlier if no more data has been received yet.
+func (l *GraphiteListener) handlePlaintextConn(ctx context.Context, conn net.Conn) {
+	ctx, logger := l.contextWithSourceIP(ctx, conn.RemoteAddr())
+	reader := bufio.NewReaderSize(conn, graphiteMaxLineSize)
+
+	var points []graphitePoint
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			level.Warn(logger).Log("msg", "closing Graphite connection", "err", fmt.Errorf("line longer than max (%d bytes)", graphiteMaxLineSize))
+			l.discardedPoints.WithLabelValues(graphiteProtocolPlaintextTCP, graphiteReasonInvalid).Inc()
+			break
+		}
+
+		points = l.appendPlaintextLine(points, line, graphiteProtocolPlaintextTCP, logger)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				level.Warn(logger).Log("msg", "failed to read from Graphite connection", "err", err)
+			}
+			break
+		}
+
+		if len(points) >= graphiteListenerBatchSize || reader.Buffered() == 0 {
+			l.pushPoints(ctx, logger, graphiteProtocolPlaintextTCP, points)
+			points = points[:0]
+		}
+	}
+
+	l.pushPoints(ctx, logger, graphiteProtocolPlaintextTCP, points)
+}
+
+// readPlaintextUDP reads the lines of the plaintext protocol from the UDP datagrams, and pushes the points
+// of each datagram.
+func (l *GraphiteListener) readPlaintextUDP(ctx context.Context) {
+	defer l.wg.Done()
+
+	buf := make([]byte, graphiteMaxDatagramSize)
+	for {
+		n, addr, err := l.plaintextUDP.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			level.Warn(l.logger).Log("msg", "failed to read Graphite datagram", "err", err)
+			continue
+		}
+
+		ctx, logger := l.contextWithSourceIP(ctx, addr)
+
+		var points []graphitePoint
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			points = l.appendPlaintextLine(points, line, graphiteProtocolPlaintextUDP, logger)
+		}
+		l.pushPoints(ctx, logger, graphiteProtocolPlaintextUDP, points)
+	}
+}
+
+// appendPlaintextLine parses a line of the plaintext protocol and appends its point to the input ones.
+// Invalid lines are discarded, without affecting the other lines.
+func (l *GraphiteListener) appendPlaintextLine(points []graphitePoint, line []byte, protocol string, logger kitlog.Logger) []graphitePoint {
+	parsed, err := parseGraphitePlaintext(line, time.Now())
+	if err != nil {
+		level.Warn(logger).Log("msg", "discarded invalid Graphite line", "line", string(bytes.TrimSpace(line)), "err", err)
+		l.discardedPoints.WithLabelValues(protocol, graphiteReasonInvalid).Inc()
+		return points
+	}
+	return append(points, parsed...)
+}
+
+// handlePickleConn reads the messages of the pickle protocol from the connection, each one prefixed by
+// its size as a 4 bytes big endian unsigned integer, and pushes the points of each message.
+func (l *GraphiteListener) handlePickleConn(ctx context.Context, conn net.Conn) {
+	ctx, logger := l.contextWithSourceIP(ctx, conn.RemoteAddr())
+	reader := bufio.NewReader(conn)
+
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				level.Warn(logger).Log("msg", "failed to read from Graphite connection", "err", err)
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header[:])
+		if uint64(size) > uint64(l.cfg.MaxRecvMsgSize) {
+			level.Warn(logger).Log("msg", "closing Graphite connection", "err", fmt.Errorf("received message larger than max (%d vs %d)", size, l.cfg.MaxRecvMsgSize))
+			l.discardedPoints.WithLabelValues(graphiteProtocolPickle, graphiteReasonInvalid).Inc()
+			return
+		}
+
+		body := make([]byte, size)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			level.Warn(logger).Log("msg", "failed to read from Graphite connection", "err", err)
+			return
+		}
+
+		points, err := parseGraphitePickle(body, time.Now())
+		if err != nil {
+			// The message size is known, so the next messages can still be read.
+			level.Warn(logger).Log("msg", "discarded invalid Graphite pickle message", "err", err)
+			l.discardedPoints.WithLabelValues(graphiteProtocolPickle, graphiteReasonInvalid).Inc()
+			continue
+		}
+
+		l.pushPoints(ctx, logger, graphiteProtocolPickle, points)
+	}
+}
+
+// pushPoints pushes the points, grouped by tenant. The points with an invalid path or without a tenant are discarded.
+func (l *GraphiteListener) pushPoints(ctx context.Context, logger kitlog.Logger, protocol string, points []graphitePoint) {
+	if len(points) == 0 {
+		return
+	}
+	l.receivedPoints.WithLabelValues(protocol).Add(float64(len(points)))
+
+	type tenantPoints struct {
+		series  []labels.Labels
+		samples []cortexpb.Sample
+	}
+	byTenant := map[string]*tenantPoints{}
+
+	for _, p := range points {
+		tenantID, path, err := l.tenantID(p.path)
+		if err == nil {
+			var lbls labels.Labels
+			if lbls, err = graphiteLabels(path, l.cfg.Templates); err == nil {
+				t := byTenant[tenantID]
+				if t == nil {
+					t = &tenantPoints{}
+					byTenant[tenantID] = t
+				}
+				t.series = append(t.series, lbls)
+				t.samples = append(t.samples, cortexpb.Sample{TimestampMs: p.timestampMs, Value: p.value})
+				continue
+			}
+		}
+
+		level.Warn(logger).Log("msg", "discarded invalid Graphite point", "path", p.path, "err", err)
+		l.discardedPoints.WithLabelValues(protocol, graphiteReasonInvalid).Inc()
+	}
+
+	for tenantID, t := range byTenant {
+		req := cortexpb.ToWriteRequest(t.series, t.samples, nil, cortexpb.API)
+		if _, err := l.push(user.InjectOrgID(ctx, tenantID), req); err != nil {
+			level.Warn(logger).Log("msg", "failed to push Graphite points", "user", tenantID, "err", err)
+			l.discardedPoints.WithLabelValues(protocol, graphiteReasonPushFailed).Add(float64(len(t.samples)))
+		}
+	}
+}
+
+// tenantID returns the tenant of the metric path and the path to map to labels, which is the input
+// one without its first node if the tenant is taken from it.
+func (l *GraphiteListener) tenantID(metricPath string) (string, string, error) {
+	if l.cfg.TenantID != "" {
+		return l.cfg.TenantID, metricPath, nil
+	}
+
+	parts := strings.SplitN(metricPath, ".", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.New("no tenant ID in the first node of the metric path")
+	}
+	if err := tenant.ValidTenantID(parts[0]); err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+// contextWithSourceIP returns the input context and a logger, enriched with the IP of the remote address.
+func (l *GraphiteListener) contextWithSourceIP(ctx context.Context, addr net.Addr) (context.Context, kitlog.Logger) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ctx, l.logger
+	}
+	return util.AddSourceIPsToOutgoingContext(ctx, host), log.WithSourceIPs(host, l.logger)
+}
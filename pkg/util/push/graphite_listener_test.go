@@ -0,0 +1,275 @@
+package push
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestGraphiteListener_Plaintext(t *testing.T) {
+	tests := map[string]struct {
+		tenantID string
+		lines    string
+		expected []string
+	}{
+		"configured tenant": {
+			tenantID: "user-1",
+			lines:    "servers.host1.cpu 1.5 1600000000\nservers.host1.mem 2 1600000000\n",
+			expected: []string{
+				`user-1 {__name__="cpu", host="host1"} 1.5 1600000000000`,
+				`user-1 {__name__="mem", host="host1"} 2 1600000000000`,
+			},
+		},
+		"tenant from the metric path": {
+			lines: "user-1.servers.host1.cpu 1.5 1600000000\nuser-2.servers.host2.cpu 2 1600000000\n",
+			expected: []string{
+				`user-1 {__name__="cpu", host="host1"} 1.5 1600000000000`,
+				`user-2 {__name__="cpu", host="host2"} 2 1600000000000`,
+			},
+		},
+		"invalid lines are discarded": {
+			tenantID: "user-1",
+			lines:    "servers.host1.cpu\nservers.host1.cpu 1.5 1600000000\nservers.host1.mem abc\n",
+			expected: []string{
+				`user-1 {__name__="cpu", host="host1"} 1.5 1600000000000`,
+			},
+		},
+		"points without a valid tenant in the metric path are discarded": {
+			lines: "cpu 1 1600000000\nuser/1.servers.host1.cpu 1 1600000000\nuser-1.servers.host1.cpu 1.5 1600000000\n",
+			expected: []string{
+				`user-1 {__name__="cpu", host="host1"} 1.5 1600000000000`,
+			},
+		},
+		"last line without newline": {
+			tenantID: "user-1",
+			lines:    "servers.host1.cpu 1.5 1600000000",
+			expected: []string{
+				`user-1 {__name__="cpu", host="host1"} 1.5 1600000000000`,
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		for _, network := range []string{"tcp", "udp"} {
+			t.Run(fmt.Sprintf("%s over %s", testName, network), func(t *testing.T) {
+				l, pushed := startGraphiteListener(t, testData.tenantID, nil)
+
+				addr := l.PlaintextAddr()
+				if network == "udp" {
+					addr = l.PlaintextUDPAddr()
+				}
+
+				conn, err := net.Dial(network, addr.String())
+				require.NoError(t, err)
+				_, err = conn.Write([]byte(testData.lines))
+				require.NoError(t, err)
+				require.NoError(t, conn.Close())
+
+				test.Poll(t, 5*time.Second, testData.expected, func() interface{} {
+					return pushed.samples()
+				})
+			})
+		}
+	}
+}
+
+func TestGraphiteListener_Pickle(t *testing.T) {
+	// Generated with pickle.dumps([('servers.host1.cpu', (1600000000, 1.5))], protocol=2).
+	pickled, err := hex.DecodeString("80025d71005811000000736572766572732e686f7374312e63707571014a00105e5f473ff8000000000000867102867103612e")
+	require.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	l, pushed := startGraphiteListener(t, "user-1", reg)
+
+	conn, err := net.Dial("tcp", l.PickleAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// An invalid message is discarded, without affecting the next ones.
+	for _, message := range [][]byte{[]byte("invalid"), pickled} {
+		_, err = conn.Write(pickleMessage(message))
+		require.NoError(t, err)
+	}
+
+	test.Poll(t, 5*time.Second, []string{`user-1 {__name__="cpu", host="host1"} 1.5 1600000000000`}, func() interface{} {
+		return pushed.samples()
+	})
+
+	// A message larger than the max closes the connection.
+	_, err = conn.Write(pickleMessage(make([]byte, 100001)))
+	require.NoError(t, err)
+
+	test.Poll(t, 5*time.Second, map[string]float64{
+		"pickle/invalid": 2,
+	}, func() interface{} {
+		return discardedPoints(t, reg)
+	})
+}
+
+func TestGraphiteListener_ShouldCountTheLinesLongerThanMax(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	l, _ := startGraphiteListener(t, "user-1", reg)
+
+	conn, err := net.Dial("tcp", l.PlaintextAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("servers.host1." + strings.Repeat("a", graphiteMaxLineSize) + " 1 1600000000\n"))
+	require.NoError(t, err)
+
+	test.Poll(t, 5*time.Second, map[string]float64{
+		"plaintext-tcp/invalid": 1,
+	}, func() interface{} {
+		return discardedPoints(t, reg)
+	})
+}
+
+func pickleMessage(body []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(body)))
+	return append(header, body...)
+}
+
+// discardedPoints returns the discarded points, by "protocol/reason".
+func discardedPoints(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	out := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "cortex_distributor_graphite_discarded_points_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			key := ""
+			for _, lbl := range m.GetLabel() {
+				if key != "" {
+					key += "/"
+				}
+				key += lbl.GetValue()
+			}
+			out[key] = m.GetCounter().GetValue()
+		}
+	}
+	return out
+}
+
+func TestGraphiteListener_Metrics(t *testing.T) {
+	templates, err := ParseGraphiteTemplates([]string{"servers.* .host.measurement*"})
+	require.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	l := NewGraphiteListener(GraphiteListenerConfig{Templates: templates}, func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		if userID, _ := user.ExtractOrgID(ctx); userID == "user-2" {
+			return nil, fmt.Errorf("push failed")
+		}
+		return &cortexpb.WriteResponse{}, nil
+	}, log.NewNopLogger(), reg)
+
+	points, err := parseGraphitePlaintext([]byte("user-1.servers.host1.cpu 1\nuser-2.servers.host1.cpu 2\nuser-2.servers.host2.cpu 3\ncpu 4\n"), time.Now())
+	require.NoError(t, err)
+	l.pushPoints(context.Background(), log.NewNopLogger(), graphiteProtocolPlaintextTCP, points)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_distributor_graphite_discarded_points_total The total number of Graphite points received over TCP and UDP connections which have been discarded. Invalid lines and messages whose number of points is unknown count as one point.
+		# TYPE cortex_distributor_graphite_discarded_points_total counter
+		cortex_distributor_graphite_discarded_points_total{protocol="plaintext-tcp",reason="invalid"} 1
+		cortex_distributor_graphite_discarded_points_total{protocol="plaintext-tcp",reason="push-failed"} 2
+
+		# HELP cortex_distributor_graphite_received_points_total The total number of Graphite points received over TCP and UDP connections.
+		# TYPE cortex_distributor_graphite_received_points_total counter
+		cortex_distributor_graphite_received_points_total{protocol="plaintext-tcp"} 4
+	`)))
+}
+
+func TestGraphiteListener_ShouldCloseOpenConnectionsOnStop(t *testing.T) {
+	l, _ := startGraphiteListener(t, "user-1", nil)
+
+	conn, err := net.Dial("tcp", l.PlaintextAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), l))
+
+	// The connection has been closed by the listener.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	assert.False(t, isTimeout(err))
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+type pushRecorder struct {
+	mtx    sync.Mutex
+	pushed []string
+}
+
+func (r *pushRecorder) push(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, ts := range req.Timeseries {
+		for _, s := range ts.Samples {
+			r.pushed = append(r.pushed, fmt.Sprintf("%s %s %v %d", userID, cortexpb.FromLabelAdaptersToLabels(ts.Labels), s.Value, s.TimestampMs))
+		}
+	}
+	return &cortexpb.WriteResponse{}, nil
+}
+
+// samples returns the pushed samples, sorted.
+func (r *pushRecorder) samples() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	out := append([]string(nil), r.pushed...)
+	sort.Strings(out)
+	return out
+}
+
+func startGraphiteListener(t *testing.T, tenantID string, reg prometheus.Registerer) (*GraphiteListener, *pushRecorder) {
+	templates, err := ParseGraphiteTemplates([]string{"servers.* .host.measurement*"})
+	require.NoError(t, err)
+
+	pushed := &pushRecorder{}
+	l := NewGraphiteListener(GraphiteListenerConfig{
+		PlaintextListenAddress: "localhost:0",
+		PickleListenAddress:    "localhost:0",
+		TenantID:               tenantID,
+		MaxRecvMsgSize:         100000,
+		Templates:              templates,
+	}, pushed.push, log.NewNopLogger(), reg)
+
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l))
+	t.Cleanup(func() {
+		_ = services.StopAndAwaitTerminated(context.Background(), l)
+	})
+
+	return l, pushed
+}
@@ -0,0 +1,176 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestGraphiteHandler(t *testing.T) {
+	// Generated with pickle.dumps([('servers.host1.cpu', (1600000000, 1.5))], protocol=2).
+	pickled, err := hex.DecodeString("80025d71005811000000736572766572732e686f7374312e63707571014a00105e5f473ff8000000000000867102867103612e")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		contentType  string
+		body         []byte
+		expectedCode int
+	}{
+		"plaintext": {
+			body:         []byte("servers.host1.cpu 1.5 1600000000\n"),
+			expectedCode: http.StatusOK,
+		},
+		"pickle": {
+			contentType:  pickleContentType,
+			body:         pickled,
+			expectedCode: http.StatusOK,
+		},
+		"invalid plaintext": {
+			body:         []byte("servers.host1.cpu\n"),
+			expectedCode: http.StatusBadRequest,
+		},
+		"invalid pickle": {
+			contentType:  pickleContentType,
+			body:         []byte("servers.host1.cpu 1.5 1600000000\n"),
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	templates, err := ParseGraphiteTemplates([]string{"servers.* .host.measurement*"})
+	require.NoError(t, err)
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://localhost/api/v1/push/graphite", bytes.NewReader(testData.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", testData.contentType)
+
+			pushed := false
+			handler := GraphiteHandler(100000, nil, templates, func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				pushed = true
+				require.Len(t, req.Timeseries, 1)
+				assert.Equal(t, []cortexpb.LabelAdapter{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "host1"}}, req.Timeseries[0].Labels)
+				assert.Equal(t, []cortexpb.Sample{{TimestampMs: 1600000000000, Value: 1.5}}, req.Timeseries[0].Samples)
+				assert.Equal(t, cortexpb.API, req.Source)
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, testData.expectedCode, resp.Code)
+			assert.Equal(t, testData.expectedCode == http.StatusOK, pushed)
+		})
+	}
+}
+
+func TestParseGraphiteTemplates(t *testing.T) {
+	tests := map[string]struct {
+		templates   []string
+		expectedErr bool
+	}{
+		"valid templates": {
+			templates: []string{"servers.* .host.measurement*", "measurement.measurement.region", "stats.*.count env..measurement"},
+		},
+		"too many fields": {
+			templates:   []string{"servers.* .host.measurement* extra"},
+			expectedErr: true,
+		},
+		"no measurement": {
+			templates:   []string{"servers.* .host.region"},
+			expectedErr: true,
+		},
+		"greedy measurement not last": {
+			templates:   []string{"measurement*.host"},
+			expectedErr: true,
+		},
+		"invalid label name": {
+			templates:   []string{"measurement.host-name"},
+			expectedErr: true,
+		},
+		"invalid filter": {
+			templates:   []string{"servers.[ measurement"},
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := ParseGraphiteTemplates(testData.templates)
+			assert.Equal(t, testData.expectedErr, err != nil)
+		})
+	}
+}
+
+func TestGraphiteLabels(t *testing.T) {
+	templates, err := ParseGraphiteTemplates([]string{
+		"servers.* .host.measurement*",
+		"stats.*.*.count .env.measurement.measurement",
+	})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		path        string
+		expected    labels.Labels
+		expectedErr bool
+	}{
+		"greedy measurement": {
+			path:     "servers.host1.cpu.load",
+			expected: labels.FromStrings(labels.MetricName, "cpu_load", "host", "host1"),
+		},
+		"skipped node": {
+			path:     "stats.prod.api.count",
+			expected: labels.FromStrings(labels.MetricName, "api_count", "env", "prod"),
+		},
+		"no matching template": {
+			path:     "collectd.host-1.memory.used",
+			expected: labels.FromStrings(labels.MetricName, "collectd_host_1_memory_used"),
+		},
+		"tagged metric": {
+			path:     "servers.host1.cpu;host=override;data-center=eu",
+			expected: labels.FromStrings(labels.MetricName, "cpu", "host", "override", "data_center", "eu"),
+		},
+		"invalid tag": {
+			path:        "servers.host1.cpu;dc",
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			actual, err := graphiteLabels(testData.path, templates)
+			if testData.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+}
+
+func TestParseGraphitePlaintext(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	points, err := parseGraphitePlaintext([]byte("a.b 1 1600000010\n\na.c 2.5 1600000020.5\na.d 3\na.e 4 -1\n"), now)
+	require.NoError(t, err)
+	assert.Equal(t, []graphitePoint{
+		{path: "a.b", value: 1, timestampMs: 1600000010000},
+		{path: "a.c", value: 2.5, timestampMs: 1600000020500},
+		{path: "a.d", value: 3, timestampMs: 1600000000000},
+		{path: "a.e", value: 4, timestampMs: 1600000000000},
+	}, points)
+
+	_, err = parseGraphitePlaintext([]byte("a.b abc 1600000010\n"), now)
+	require.Error(t, err)
+}
@@ -0,0 +1,257 @@
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/middleware"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+// Field name which doesn't get appended to the measurement name when building the
+// metric name, following the convention used by Telegraf for single-value metrics.
+const influxValueField = "value"
+
+var influxPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"µ":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// InfluxHandler is a http.Handler which accepts InfluxDB line protocol write requests,
+// optionally gzip compressed, and pushes them as WriteRequests. The timestamps precision
+// can be set through the "precision" query parameter and defaults to nanoseconds.
+func InfluxHandler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push Func) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, logger := contextWithSourceIPs(r, sourceIPs)
+
+		req, err := parseInfluxRequest(r, maxRecvMsgSize, time.Now())
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := push(ctx, req); err != nil {
+			writePushError(w, logger, err)
+			return
+		}
+
+		// InfluxDB replies with no content on success, and some clients rely on it.
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func parseInfluxRequest(r *http.Request, maxRecvMsgSize int, now time.Time) (*cortexpb.WriteRequest, error) {
+	precision, ok := influxPrecisions[r.URL.Query().Get("precision")]
+	if !ok {
+		return nil, fmt.Errorf("invalid precision %q", r.URL.Query().Get("precision"))
+	}
+
+	body, err := readRequestBody(r, maxRecvMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	series, samples, err := parseInfluxLines(body, precision, now)
+	if err != nil {
+		return nil, err
+	}
+	return cortexpb.ToWriteRequest(series, samples, nil, cortexpb.API), nil
+}
+
+// parseInfluxLines parses InfluxDB line protocol points. Each numeric or boolean field of a point
+// is converted to a series named "<measurement>_<field>", or just "<measurement>" if the field is
+// called "value", labelled with the point tags. String fields are skipped. Points without a
+// timestamp get the input now time.
+func parseInfluxLines(body []byte, precision time.Duration, now time.Time) ([]labels.Labels, []cortexpb.Sample, error) {
+	var (
+		series  []labels.Labels
+		samples []cortexpb.Sample
+	)
+
+	for num, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		lineSeries, lineSamples, err := parseInfluxLine(string(line), precision, now)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to parse line %d", num+1)
+		}
+		series = append(series, lineSeries...)
+		samples = append(samples, lineSamples...)
+	}
+
+	return series, samples, nil
+}
+
+func parseInfluxLine(line string, precision time.Duration, now time.Time) ([]labels.Labels, []cortexpb.Sample, error) {
+	// Double quotes are only special within field values, so the key is split out first.
+	key := splitInfluxUnescaped(line, ' ', false)[0]
+	sections := append([]string{key}, splitInfluxUnescaped(strings.TrimPrefix(line[len(key):], " "), ' ', true)...)
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, nil, errors.New("expected measurement, fields and optional timestamp separated by spaces")
+	}
+
+	ts := now.UnixNano() / int64(time.Millisecond)
+	if len(sections) == 3 {
+		v, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		ts = v * int64(precision) / int64(time.Millisecond)
+	}
+
+	// The key is made of the measurement followed by the tags.
+	keyParts := splitInfluxUnescaped(key, ',', false)
+	measurement := unescapeInflux(keyParts[0])
+	if measurement == "" {
+		return nil, nil, errors.New("missing measurement")
+	}
+
+	b := labels.NewBuilder(nil)
+	for _, tag := range keyParts[1:] {
+		name, value, err := splitInfluxKeyValue(tag)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid tag")
+		}
+		b.Set(sanitizeLabelName(name), value)
+	}
+
+	var (
+		series  []labels.Labels
+		samples []cortexpb.Sample
+	)
+
+	for _, field := range splitInfluxUnescaped(sections[1], ',', true) {
+		name, raw, err := splitInfluxKeyValue(field)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid field")
+		}
+
+		v, ok, err := parseInfluxFieldValue(raw)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid value for field %q", name)
+		}
+		if !ok {
+			continue
+		}
+
+		metricName := measurement
+		if name != influxValueField {
+			metricName = measurement + "_" + name
+		}
+		b.Set(labels.MetricName, sanitizeMetricName(metricName))
+
+		series = append(series, b.Labels())
+		samples = append(samples, cortexpb.Sample{TimestampMs: ts, Value: v})
+	}
+
+	return series, samples, nil
+}
+
+// parseInfluxFieldValue parses a field value, returning false if it's a string
+// and can't be converted to a sample value.
+func parseInfluxFieldValue(raw string) (float64, bool, error) {
+	if raw == "" {
+		return 0, false, errors.New("missing value")
+	}
+
+	switch {
+	case raw[0] == '"':
+		return 0, false, nil
+	case raw[len(raw)-1] == 'i':
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		return float64(v), err == nil, err
+	case raw[len(raw)-1] == 'u':
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		return float64(v), err == nil, err
+	}
+
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	return v, err == nil, err
+}
+
+// splitInfluxKeyValue splits a "key=value" tag or field at the first unescaped equal sign,
+// and returns the unescaped key and the value. Tag values are unescaped too, while field
+// values are returned as is.
+func splitInfluxKeyValue(s string) (string, string, error) {
+	parts := splitInfluxUnescaped(s, '=', false)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("expected key=value, got %q", s)
+	}
+
+	value := s[len(parts[0])+1:]
+	if !strings.HasPrefix(value, `"`) {
+		value = unescapeInflux(value)
+	}
+	return unescapeInflux(parts[0]), value, nil
+}
+
+// splitInfluxUnescaped splits s on sep, ignoring the separators escaped with a
+// backslash and, if quotes is true, the ones within double quoted strings.
+func splitInfluxUnescaped(s string, sep byte, quotes bool) []string {
+	var (
+		parts   []string
+		start   = 0
+		escaped = false
+		quoted  = false
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case s[i] == '"' && quotes:
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', '=', ' ', '"', '\\':
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestInfluxHandler(t *testing.T) {
+	tests := map[string]struct {
+		body         string
+		precision    string
+		expectedCode int
+		expectedTs   int64
+	}{
+		"default precision": {
+			body:         "cpu,host=a value=1.5 1600000000000000000",
+			expectedCode: http.StatusNoContent,
+			expectedTs:   1600000000000,
+		},
+		"seconds precision": {
+			body:         "cpu,host=a value=1.5 1600000000",
+			precision:    "s",
+			expectedCode: http.StatusNoContent,
+			expectedTs:   1600000000000,
+		},
+		"invalid precision": {
+			body:         "cpu,host=a value=1.5 1600000000",
+			precision:    "d",
+			expectedCode: http.StatusBadRequest,
+		},
+		"invalid line": {
+			body:         "cpu,host=a",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://localhost/api/v1/push/influx/write?precision="+testData.precision, bytes.NewReader([]byte(testData.body)))
+			require.NoError(t, err)
+
+			pushed := false
+			handler := InfluxHandler(100000, nil, func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				pushed = true
+				require.Len(t, req.Timeseries, 1)
+				assert.Equal(t, []cortexpb.LabelAdapter{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}}, req.Timeseries[0].Labels)
+				assert.Equal(t, []cortexpb.Sample{{TimestampMs: testData.expectedTs, Value: 1.5}}, req.Timeseries[0].Samples)
+				assert.Equal(t, cortexpb.API, req.Source)
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, testData.expectedCode, resp.Code)
+			assert.Equal(t, testData.expectedCode == http.StatusNoContent, pushed)
+		})
+	}
+}
+
+func TestParseInfluxLines(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	nowMs := int64(1600000000000)
+
+	tests := map[string]struct {
+		input           string
+		expectedSeries  []labels.Labels
+		expectedSamples []cortexpb.Sample
+		expectedErr     bool
+	}{
+		"multiple fields and types": {
+			input: `weather,location=us-midwest temperature=82i,humidity=71.5,raining=true,season="summer" 1465839830100400200`,
+			expectedSeries: []labels.Labels{
+				labels.FromStrings(labels.MetricName, "weather_temperature", "location", "us-midwest"),
+				labels.FromStrings(labels.MetricName, "weather_humidity", "location", "us-midwest"),
+				labels.FromStrings(labels.MetricName, "weather_raining", "location", "us-midwest"),
+			},
+			expectedSamples: []cortexpb.Sample{
+				{TimestampMs: 1465839830100, Value: 82},
+				{TimestampMs: 1465839830100, Value: 71.5},
+				{TimestampMs: 1465839830100, Value: 1},
+			},
+		},
+		"no timestamp": {
+			input:           "disk.io,dev=sda value=3u",
+			expectedSeries:  []labels.Labels{labels.FromStrings(labels.MetricName, "disk_io", "dev", "sda")},
+			expectedSamples: []cortexpb.Sample{{TimestampMs: nowMs, Value: 3}},
+		},
+		"escaped characters": {
+			input:           `my\ measurement,tag\,key=tag\ value,host-name=a\=b field\=key="with \" quote and space",count=1 1600000000000000000`,
+			expectedSeries:  []labels.Labels{labels.FromStrings(labels.MetricName, "my_measurement_count", "tag_key", "tag value", "host_name", "a=b")},
+			expectedSamples: []cortexpb.Sample{{TimestampMs: 1600000000000, Value: 1}},
+		},
+		"comments and empty lines": {
+			input:           "# comment\n\ncpu value=1 1600000000000000000\n",
+			expectedSeries:  []labels.Labels{labels.FromStrings(labels.MetricName, "cpu")},
+			expectedSamples: []cortexpb.Sample{{TimestampMs: 1600000000000, Value: 1}},
+		},
+		"missing fields": {
+			input:       "cpu,host=a",
+			expectedErr: true,
+		},
+		"invalid tag": {
+			input:       "cpu,host value=1",
+			expectedErr: true,
+		},
+		"invalid field value": {
+			input:       "cpu value=abc",
+			expectedErr: true,
+		},
+		"invalid timestamp": {
+			input:       "cpu value=1 abc",
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			series, samples, err := parseInfluxLines([]byte(testData.input), time.Nanosecond, now)
+			if testData.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedSeries, series)
+			assert.Equal(t, testData.expectedSamples, samples)
+		})
+	}
+}
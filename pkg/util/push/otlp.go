@@ -0,0 +1,292 @@
+package push
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/weaveworks/common/middleware"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	util_math "github.com/cortexproject/cortex/pkg/util/math"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const (
+	jsonContentType     = "application/json"
+	protobufContentType = "application/x-protobuf"
+
+	// Resource attributes mapped to the job and instance labels, following the
+	// OpenTelemetry conventions for Prometheus compatibility.
+	serviceNameAttr       = "service.name"
+	serviceNamespaceAttr  = "service.namespace"
+	serviceInstanceIDAttr = "service.instance.id"
+
+	// Reason used to track the samples of delta sums and histograms in the discarded samples metric.
+	deltaTemporality = "otlp_delta_temporality"
+)
+
+// OTLPHandler is a http.Handler which accepts OTLP metrics export requests, encoded either as
+// protobuf or JSON and optionally gzip compressed, and pushes them as WriteRequests.
+func OTLPHandler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push Func) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, logger := contextWithSourceIPs(r, sourceIPs)
+
+		resourceMetrics, err := parseOTLPRequest(r, maxRecvMsgSize)
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req, discarded := otlpToWriteRequest(resourceMetrics)
+		if discarded > 0 {
+			if userID, err := tenant.TenantID(ctx); err == nil {
+				validation.DiscardedSamples.WithLabelValues(deltaTemporality, userID).Add(float64(discarded))
+			}
+		}
+
+		if _, err := push(ctx, req); err != nil {
+			writePushError(w, logger, err)
+			return
+		}
+
+		writeOTLPResponse(w, r)
+	})
+}
+
+// writeOTLPResponse replies with an ExportMetricsServiceResponse, encoded with the content type
+// of the request. The response has no fields, so it's encoded as an empty JSON object or as an
+// empty protobuf message.
+func writeOTLPResponse(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), jsonContentType) {
+		w.Header().Set("Content-Type", jsonContentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+
+	w.Header().Set("Content-Type", protobufContentType)
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseOTLPRequest decodes the resource metrics of an OTLP metrics export request.
+func parseOTLPRequest(r *http.Request, maxRecvMsgSize int) ([]*metricspb.ResourceMetrics, error) {
+	body, err := readRequestBody(r, maxRecvMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// MetricsData has the same fields as ExportMetricsServiceRequest, so both the protobuf and
+	// the JSON encodings of the request can be decoded without the collector service packages.
+	req := &metricspb.MetricsData{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), jsonContentType) {
+		err = protojson.Unmarshal(body, req)
+	} else {
+		err = proto.Unmarshal(body, req)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "decode OTLP request")
+	}
+
+	return req.ResourceMetrics, nil
+}
+
+// otlpToWriteRequest converts OTLP resource metrics to a WriteRequest. Gauges, sums, histograms
+// and summaries are supported, while delta sums and histograms are skipped because they can't
+// be represented as Prometheus series: the number of samples they would have been converted
+// to is returned. Resource attributes are added as labels to all series.
+func otlpToWriteRequest(resourceMetrics []*metricspb.ResourceMetrics) (*cortexpb.WriteRequest, int) {
+	var (
+		series    []labels.Labels
+		samples   []cortexpb.Sample
+		metadata  []*cortexpb.MetricMetadata
+		discarded int
+	)
+
+	add := func(lbls labels.Labels, timeUnixNano uint64, v float64) {
+		series = append(series, lbls)
+		samples = append(samples, cortexpb.Sample{TimestampMs: int64(timeUnixNano / 1e6), Value: v})
+	}
+
+	for _, rm := range resourceMetrics {
+		resourceLabels := otlpResourceLabels(rm.GetResource().GetAttributes())
+
+		for _, ilm := range rm.GetInstrumentationLibraryMetrics() {
+			for _, m := range ilm.GetMetrics() {
+				name := sanitizeMetricName(m.GetName())
+				if name == "" {
+					continue
+				}
+
+				var metricType cortexpb.MetricMetadata_MetricType
+
+				switch {
+				case m.GetGauge() != nil:
+					metricType = cortexpb.GAUGE
+					for _, p := range m.GetGauge().GetDataPoints() {
+						add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name), p.GetTimeUnixNano(), numberDataPointValue(p))
+					}
+
+				case m.GetSum() != nil:
+					sum := m.GetSum()
+					if sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+						discarded += len(sum.GetDataPoints())
+						continue
+					}
+
+					metricType = cortexpb.GAUGE
+					if sum.GetIsMonotonic() {
+						metricType = cortexpb.COUNTER
+					}
+					for _, p := range sum.GetDataPoints() {
+						add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name), p.GetTimeUnixNano(), numberDataPointValue(p))
+					}
+
+				case m.GetHistogram() != nil:
+					histogram := m.GetHistogram()
+					if histogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+						// Each data point would have been converted to the buckets, count and sum series.
+						for _, p := range histogram.GetDataPoints() {
+							discarded += util_math.Max(len(p.GetBucketCounts()), 1) + 2
+						}
+						continue
+					}
+
+					metricType = cortexpb.HISTOGRAM
+					for _, p := range histogram.GetDataPoints() {
+						ts := p.GetTimeUnixNano()
+
+						cumulative := uint64(0)
+						bounds := p.GetExplicitBounds()
+						for i, count := range p.GetBucketCounts() {
+							cumulative += count
+
+							// The last bucket is the +Inf one, which isn't listed in the explicit bounds.
+							le := math.Inf(+1)
+							if i < len(bounds) {
+								le = bounds[i]
+							}
+							add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name+"_bucket", labels.BucketLabel, formatFloat(le)), ts, float64(cumulative))
+						}
+						if len(p.GetBucketCounts()) == 0 {
+							add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name+"_bucket", labels.BucketLabel, formatFloat(math.Inf(+1))), ts, float64(p.GetCount()))
+						}
+
+						add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name+"_count"), ts, float64(p.GetCount()))
+						add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name+"_sum"), ts, p.GetSum())
+					}
+
+				case m.GetSummary() != nil:
+					metricType = cortexpb.SUMMARY
+					for _, p := range m.GetSummary().GetDataPoints() {
+						ts := p.GetTimeUnixNano()
+
+						for _, q := range p.GetQuantileValues() {
+							add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name, "quantile", formatFloat(q.GetQuantile())), ts, q.GetValue())
+						}
+						add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name+"_count"), ts, float64(p.GetCount()))
+						add(otlpSeriesLabels(resourceLabels, p.GetAttributes(), name+"_sum"), ts, p.GetSum())
+					}
+
+				default:
+					// Deprecated integer metric types are not supported.
+					continue
+				}
+
+				metadata = append(metadata, &cortexpb.MetricMetadata{
+					Type:             metricType,
+					MetricFamilyName: name,
+					Help:             m.GetDescription(),
+					Unit:             m.GetUnit(),
+				})
+			}
+		}
+	}
+
+	return cortexpb.ToWriteRequest(series, samples, metadata, cortexpb.API), discarded
+}
+
+// otlpResourceLabels returns the labels built from the resource attributes.
+func otlpResourceLabels(attrs []*commonpb.KeyValue) labels.Labels {
+	var (
+		b      = labels.NewBuilder(nil)
+		values = map[string]string{}
+	)
+
+	for _, attr := range attrs {
+		v := anyValueToString(attr.GetValue())
+		values[attr.GetKey()] = v
+		b.Set(sanitizeLabelName(attr.GetKey()), v)
+	}
+
+	if name := values[serviceNameAttr]; name != "" {
+		if namespace := values[serviceNamespaceAttr]; namespace != "" {
+			name = namespace + "/" + name
+		}
+		b.Set(model.JobLabel, name)
+	}
+	if instance := values[serviceInstanceIDAttr]; instance != "" {
+		b.Set(model.InstanceLabel, instance)
+	}
+
+	return b.Labels()
+}
+
+// otlpSeriesLabels returns the labels of a series, built from the resource labels, the data point
+// attributes, the metric name and the extra label name/value pairs. Data point attributes take
+// precedence over resource attributes.
+func otlpSeriesLabels(resourceLabels labels.Labels, attrs []*commonpb.KeyValue, name string, extra ...string) labels.Labels {
+	b := labels.NewBuilder(resourceLabels)
+	for _, attr := range attrs {
+		b.Set(sanitizeLabelName(attr.GetKey()), anyValueToString(attr.GetValue()))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		b.Set(extra[i], extra[i+1])
+	}
+	b.Set(labels.MetricName, name)
+
+	return b.Labels()
+}
+
+func numberDataPointValue(p *metricspb.NumberDataPoint) float64 {
+	switch p.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(p.GetAsInt())
+	case *metricspb.NumberDataPoint_AsDouble:
+		return p.GetAsDouble()
+	default:
+		// Data points without a value are ingested as staleness markers.
+		return math.Float64frombits(value.StaleNaN)
+	}
+}
+
+func anyValueToString(v *commonpb.AnyValue) string {
+	switch v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.GetStringValue()
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.GetBoolValue())
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.GetIntValue(), 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return formatFloat(v.GetDoubleValue())
+	default:
+		return ""
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
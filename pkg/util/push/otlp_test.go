@@ -0,0 +1,275 @@
+package push
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+const testTimeUnixNano = uint64(1600000000000 * 1e6)
+
+func TestOTLPHandler(t *testing.T) {
+	tests := map[string]struct {
+		contentType    string
+		gzip           bool
+		maxRecvMsgSize int
+		expectedCode   int
+	}{
+		"protobuf": {
+			contentType:    "application/x-protobuf",
+			maxRecvMsgSize: 100000,
+			expectedCode:   http.StatusOK,
+		},
+		"protobuf with gzip": {
+			contentType:    "application/x-protobuf",
+			gzip:           true,
+			maxRecvMsgSize: 100000,
+			expectedCode:   http.StatusOK,
+		},
+		"json": {
+			contentType:    "application/json",
+			maxRecvMsgSize: 100000,
+			expectedCode:   http.StatusOK,
+		},
+		"json with gzip": {
+			contentType:    "application/json",
+			gzip:           true,
+			maxRecvMsgSize: 100000,
+			expectedCode:   http.StatusOK,
+		},
+		"request too large": {
+			contentType:    "application/x-protobuf",
+			maxRecvMsgSize: 10,
+			expectedCode:   http.StatusBadRequest,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			rm := &metricspb.ResourceMetrics{
+				Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringKeyValue("service.name", "api")}},
+				InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{{
+					Metrics: []*metricspb.Metric{{
+						Name: "foo",
+						Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+							{TimeUnixNano: testTimeUnixNano, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1.5}},
+						}}},
+					}},
+				}},
+			}
+
+			var body []byte
+			if testData.contentType == "application/json" {
+				body = createOTLPJSONRequest(t, rm)
+			} else {
+				body = createOTLPProtobufRequest(t, rm)
+			}
+
+			if testData.gzip {
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				_, err := w.Write(body)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				body = buf.Bytes()
+			}
+
+			req, err := http.NewRequest("POST", "http://localhost/otlp/v1/metrics", bytes.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", testData.contentType)
+			if testData.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			pushed := false
+			handler := OTLPHandler(testData.maxRecvMsgSize, nil, func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				pushed = true
+				require.Len(t, req.Timeseries, 1)
+				assert.Equal(t, []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}, {Name: "job", Value: "api"}, {Name: "service_name", Value: "api"}}, req.Timeseries[0].Labels)
+				assert.Equal(t, []cortexpb.Sample{{TimestampMs: 1600000000000, Value: 1.5}}, req.Timeseries[0].Samples)
+				assert.Equal(t, cortexpb.API, req.Source)
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, testData.expectedCode, resp.Code)
+			assert.Equal(t, testData.expectedCode == http.StatusOK, pushed)
+
+			if testData.expectedCode == http.StatusOK {
+				assert.Equal(t, testData.contentType, resp.Header().Get("Content-Type"))
+				if testData.contentType == "application/json" {
+					assert.Equal(t, "{}", resp.Body.String())
+				} else {
+					assert.Empty(t, resp.Body.Bytes())
+				}
+			}
+		})
+	}
+}
+
+func TestOTLPToWriteRequest(t *testing.T) {
+	attrs := []*commonpb.KeyValue{stringKeyValue("method", "GET")}
+
+	rm := &metricspb.ResourceMetrics{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			stringKeyValue("service.namespace", "prod"),
+			stringKeyValue("service.name", "api"),
+			stringKeyValue("service.instance.id", "api-1"),
+		}},
+		InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{{
+			Metrics: []*metricspb.Metric{
+				{
+					Name:        "memory.usage",
+					Description: "Memory usage.",
+					Unit:        "By",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+						{Attributes: attrs, TimeUnixNano: testTimeUnixNano, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 10}},
+					}}},
+				}, {
+					Name: "requests_total",
+					Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						IsMonotonic:            true,
+						DataPoints: []*metricspb.NumberDataPoint{
+							{Attributes: attrs, TimeUnixNano: testTimeUnixNano, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 5}},
+						},
+					}},
+				}, {
+					Name: "delta_requests",
+					Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+						DataPoints: []*metricspb.NumberDataPoint{
+							{TimeUnixNano: testTimeUnixNano, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 5}},
+						},
+					}},
+				}, {
+					Name: "latency",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						DataPoints: []*metricspb.HistogramDataPoint{{
+							TimeUnixNano:   testTimeUnixNano,
+							Count:          6,
+							Sum:            12.5,
+							ExplicitBounds: []float64{0.5, 1},
+							BucketCounts:   []uint64{1, 2, 3},
+						}},
+					}},
+				}, {
+					Name: "delta_latency",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+						DataPoints: []*metricspb.HistogramDataPoint{{
+							TimeUnixNano:   testTimeUnixNano,
+							Count:          3,
+							ExplicitBounds: []float64{1},
+							BucketCounts:   []uint64{1, 2},
+						}},
+					}},
+				}, {
+					Name: "quantiles",
+					Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{
+						TimeUnixNano:   testTimeUnixNano,
+						Count:          2,
+						Sum:            3,
+						QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.99, Value: 2.5}},
+					}}}},
+				},
+			},
+		}},
+	}
+
+	req, discarded := otlpToWriteRequest([]*metricspb.ResourceMetrics{rm})
+
+	// The delta sum and histogram are discarded: 1 sample for the sum, 2 buckets, count and sum for the histogram.
+	assert.Equal(t, 5, discarded)
+
+	actual := map[string]float64{}
+	for _, ts := range req.Timeseries {
+		require.Len(t, ts.Samples, 1)
+		assert.Equal(t, int64(1600000000000), ts.Samples[0].TimestampMs)
+		actual[cortexpb.FromLabelAdaptersToLabels(ts.Labels).String()] = ts.Samples[0].Value
+	}
+
+	series := func(name string, extra ...string) string {
+		lbls := append([]string{
+			"__name__", name,
+			"instance", "api-1",
+			"job", "prod/api",
+			"service_instance_id", "api-1",
+			"service_name", "api",
+			"service_namespace", "prod",
+		}, extra...)
+		return labels.FromStrings(lbls...).String()
+	}
+
+	assert.Equal(t, map[string]float64{
+		series("memory_usage", "method", "GET"):   10,
+		series("requests_total", "method", "GET"): 5,
+		series("latency_bucket", "le", "0.5"):     1,
+		series("latency_bucket", "le", "1"):       3,
+		series("latency_bucket", "le", "+Inf"):    6,
+		series("latency_count"):                   6,
+		series("latency_sum"):                     12.5,
+		series("quantiles", "quantile", "0.99"):   2.5,
+		series("quantiles_count"):                 2,
+		series("quantiles_sum"):                   3,
+	}, actual)
+
+	assert.Equal(t, []*cortexpb.MetricMetadata{
+		{Type: cortexpb.GAUGE, MetricFamilyName: "memory_usage", Help: "Memory usage.", Unit: "By"},
+		{Type: cortexpb.COUNTER, MetricFamilyName: "requests_total"},
+		{Type: cortexpb.HISTOGRAM, MetricFamilyName: "latency"},
+		{Type: cortexpb.SUMMARY, MetricFamilyName: "quantiles"},
+	}, req.Metadata)
+}
+
+func TestParseOTLPRequest_ShouldFailOnInvalidRequest(t *testing.T) {
+	for contentType, body := range map[string]string{
+		"application/x-protobuf": "\x0a\xff",
+		"application/json":       `{"resourceMetrics":{}}`,
+	} {
+		t.Run(contentType, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/otlp/v1/metrics", strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+
+			_, err := parseOTLPRequest(req, 100000)
+			require.Error(t, err)
+		})
+	}
+}
+
+func stringKeyValue(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func createOTLPProtobufRequest(t *testing.T, rms ...*metricspb.ResourceMetrics) []byte {
+	t.Helper()
+
+	body, err := proto.Marshal(&metricspb.MetricsData{ResourceMetrics: rms})
+	require.NoError(t, err)
+	return body
+}
+
+func createOTLPJSONRequest(t *testing.T, rms ...*metricspb.ResourceMetrics) []byte {
+	t.Helper()
+
+	body, err := protojson.Marshal(&metricspb.MetricsData{ResourceMetrics: rms})
+	require.NoError(t, err)
+	return body
+}
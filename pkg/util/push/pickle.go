@@ -0,0 +1,465 @@
+package push
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pickle opcodes supported by unpickle, which are the ones needed to decode
+// the lists of tuples of strings and numbers sent by Graphite clients.
+const (
+	pickleOpMark           = '('
+	pickleOpStop           = '.'
+	pickleOpInt            = 'I'
+	pickleOpBinInt         = 'J'
+	pickleOpBinInt1        = 'K'
+	pickleOpBinInt2        = 'M'
+	pickleOpLong           = 'L'
+	pickleOpNone           = 'N'
+	pickleOpString         = 'S'
+	pickleOpBinString      = 'T'
+	pickleOpShortBinString = 'U'
+	pickleOpUnicode        = 'V'
+	pickleOpBinUnicode     = 'X'
+	pickleOpAppend         = 'a'
+	pickleOpAppends        = 'e'
+	pickleOpFloat          = 'F'
+	pickleOpBinFloat       = 'G'
+	pickleOpGet            = 'g'
+	pickleOpBinGet         = 'h'
+	pickleOpLongBinGet     = 'j'
+	pickleOpList           = 'l'
+	pickleOpEmptyList      = ']'
+	pickleOpPut            = 'p'
+	pickleOpBinPut         = 'q'
+	pickleOpLongBinPut     = 'r'
+	pickleOpTuple          = 't'
+	pickleOpEmptyTuple     = ')'
+	pickleOpBinBytes       = 'B'
+	pickleOpShortBinBytes  = 'C'
+
+	// Protocol 2.
+	pickleOpProto    = 0x80
+	pickleOpTuple1   = 0x85
+	pickleOpTuple2   = 0x86
+	pickleOpTuple3   = 0x87
+	pickleOpNewTrue  = 0x88
+	pickleOpNewFalse = 0x89
+	pickleOpLong1    = 0x8a
+
+	// Protocol 4.
+	pickleOpShortBinUnicode = 0x8c
+	pickleOpBinUnicode8     = 0x8d
+	pickleOpMemoize         = 0x94
+	pickleOpFrame           = 0x95
+)
+
+// pickleList is a decoded list. It's a pointer type because lists are built by appending
+// to them after they've been pushed to the stack, and possibly to the memo.
+type pickleList struct {
+	items []interface{}
+}
+
+// pickleMarker is pushed to the stack by the MARK opcode.
+type pickleMarker struct{}
+
+// unpickle decodes a pickled object. Only lists, tuples, strings, numbers, booleans
+// and None are supported. Strings and bytes are decoded as string, integers as int64,
+// floats as float64, tuples as []interface{} and lists as *pickleList.
+func unpickle(data []byte) (interface{}, error) {
+	var (
+		r     = bytes.NewReader(data)
+		stack []interface{}
+		memo  = map[int]interface{}{}
+	)
+
+	pop := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+
+	popMark := func() ([]interface{}, error) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if _, ok := stack[i].(pickleMarker); ok {
+				items := append([]interface{}{}, stack[i+1:]...)
+				stack = stack[:i]
+				return items, nil
+			}
+		}
+		return nil, errors.New("mark not found")
+	}
+
+	top := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("stack underflow")
+		}
+		return stack[len(stack)-1], nil
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("unexpected end of data")
+		}
+
+		switch op {
+		case pickleOpStop:
+			return pop()
+
+		case pickleOpProto:
+			if _, err := readPickleBytes(r, 1); err != nil {
+				return nil, err
+			}
+		case pickleOpFrame:
+			if _, err := readPickleBytes(r, 8); err != nil {
+				return nil, err
+			}
+
+		case pickleOpMark:
+			stack = append(stack, pickleMarker{})
+		case pickleOpNone:
+			stack = append(stack, nil)
+		case pickleOpNewTrue:
+			stack = append(stack, true)
+		case pickleOpNewFalse:
+			stack = append(stack, false)
+
+		case pickleOpInt:
+			line, err := readPickleLine(r)
+			if err != nil {
+				return nil, err
+			}
+			// Protocol 0 encodes booleans as "01" and "00".
+			switch line {
+			case "01":
+				stack = append(stack, true)
+				continue
+			case "00":
+				stack = append(stack, false)
+				continue
+			}
+			v, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid int")
+			}
+			stack = append(stack, v)
+		case pickleOpLong:
+			line, err := readPickleLine(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid long")
+			}
+			stack = append(stack, v)
+		case pickleOpBinInt:
+			b, err := readPickleBytes(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(int32(binary.LittleEndian.Uint32(b))))
+		case pickleOpBinInt1:
+			b, err := readPickleBytes(r, 1)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(b[0]))
+		case pickleOpBinInt2:
+			b, err := readPickleBytes(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(binary.LittleEndian.Uint16(b)))
+		case pickleOpLong1:
+			n, err := readPickleLength(r, 1)
+			if err != nil {
+				return nil, err
+			}
+			b, err := readPickleBytes(r, n)
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodePickleLong(b)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, v)
+
+		case pickleOpFloat:
+			line, err := readPickleLine(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid float")
+			}
+			stack = append(stack, v)
+		case pickleOpBinFloat:
+			b, err := readPickleBytes(r, 8)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(b)))
+
+		case pickleOpString:
+			line, err := readPickleLine(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.Unquote(line)
+			if err != nil && len(line) >= 2 && line[0] == '\'' && line[len(line)-1] == '\'' {
+				// Python quotes strings with single quotes, which Go only allows for runes.
+				v, err = strconv.Unquote(`"` + line[1:len(line)-1] + `"`)
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid string")
+			}
+			stack = append(stack, v)
+		case pickleOpUnicode:
+			line, err := readPickleLine(r)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, line)
+		case pickleOpShortBinString, pickleOpShortBinBytes, pickleOpShortBinUnicode:
+			if err := pushPickleString(r, 1, &stack); err != nil {
+				return nil, err
+			}
+		case pickleOpBinString, pickleOpBinBytes, pickleOpBinUnicode:
+			if err := pushPickleString(r, 4, &stack); err != nil {
+				return nil, err
+			}
+		case pickleOpBinUnicode8:
+			if err := pushPickleString(r, 8, &stack); err != nil {
+				return nil, err
+			}
+
+		case pickleOpEmptyList:
+			stack = append(stack, &pickleList{})
+		case pickleOpList:
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, &pickleList{items: items})
+		case pickleOpAppend:
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendToPickleList(stack, v); err != nil {
+				return nil, err
+			}
+		case pickleOpAppends:
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendToPickleList(stack, items...); err != nil {
+				return nil, err
+			}
+
+		case pickleOpEmptyTuple:
+			stack = append(stack, []interface{}{})
+		case pickleOpTuple:
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, items)
+		case pickleOpTuple1, pickleOpTuple2, pickleOpTuple3:
+			n := int(op-pickleOpTuple1) + 1
+			if len(stack) < n {
+				return nil, errors.New("stack underflow")
+			}
+			items := append([]interface{}{}, stack[len(stack)-n:]...)
+			stack = append(stack[:len(stack)-n], items)
+
+		case pickleOpPut, pickleOpBinPut, pickleOpLongBinPut, pickleOpMemoize:
+			idx := len(memo)
+			switch op {
+			case pickleOpPut:
+				line, err := readPickleLine(r)
+				if err != nil {
+					return nil, err
+				}
+				if idx, err = strconv.Atoi(line); err != nil {
+					return nil, errors.Wrap(err, "invalid memo index")
+				}
+			case pickleOpBinPut:
+				if idx, err = readPickleLength(r, 1); err != nil {
+					return nil, err
+				}
+			case pickleOpLongBinPut:
+				if idx, err = readPickleLength(r, 4); err != nil {
+					return nil, err
+				}
+			}
+			v, err := top()
+			if err != nil {
+				return nil, err
+			}
+			memo[idx] = v
+		case pickleOpGet, pickleOpBinGet, pickleOpLongBinGet:
+			var idx int
+			switch op {
+			case pickleOpGet:
+				line, err := readPickleLine(r)
+				if err != nil {
+					return nil, err
+				}
+				if idx, err = strconv.Atoi(line); err != nil {
+					return nil, errors.Wrap(err, "invalid memo index")
+				}
+			case pickleOpBinGet:
+				if idx, err = readPickleLength(r, 1); err != nil {
+					return nil, err
+				}
+			case pickleOpLongBinGet:
+				if idx, err = readPickleLength(r, 4); err != nil {
+					return nil, err
+				}
+			}
+			v, ok := memo[idx]
+			if !ok {
+				return nil, fmt.Errorf("memo index %d not found", idx)
+			}
+			stack = append(stack, v)
+
+		default:
+			return nil, fmt.Errorf("unsupported opcode 0x%x", op)
+		}
+	}
+}
+
+// pickleSequence returns the items of a decoded list or tuple.
+func pickleSequence(v interface{}) ([]interface{}, bool) {
+	switch s := v.(type) {
+	case []interface{}:
+		return s, true
+	case *pickleList:
+		return s.items, true
+	default:
+		return nil, false
+	}
+}
+
+// pickleNumber returns the value of a decoded number, which may also be sent as string.
+func pickleNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func appendToPickleList(stack []interface{}, items ...interface{}) error {
+	if len(stack) == 0 {
+		return errors.New("stack underflow")
+	}
+	list, ok := stack[len(stack)-1].(*pickleList)
+	if !ok {
+		return errors.New("append to a non-list object")
+	}
+	list.items = append(list.items, items...)
+	return nil
+}
+
+func pushPickleString(r *bytes.Reader, lengthSize int, stack *[]interface{}) error {
+	n, err := readPickleLength(r, lengthSize)
+	if err != nil {
+		return err
+	}
+	b, err := readPickleBytes(r, n)
+	if err != nil {
+		return err
+	}
+	*stack = append(*stack, string(b))
+	return nil
+}
+
+// readPickleLength reads an unsigned little-endian integer of the input size in bytes.
+func readPickleLength(r *bytes.Reader, size int) (int, error) {
+	b, err := readPickleBytes(r, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	if v > math.MaxInt32 {
+		return 0, errors.New("length out of range")
+	}
+	return int(v), nil
+}
+
+func readPickleBytes(r *bytes.Reader, n int) ([]byte, error) {
+	if n < 0 || n > r.Len() {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := make([]byte, n)
+	_, _ = r.Read(b)
+	return b, nil
+}
+
+func readPickleLine(r *bytes.Reader) (string, error) {
+	var b []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", errors.New("unexpected end of data")
+		}
+		if c == '\n' {
+			return string(b), nil
+		}
+		b = append(b, c)
+	}
+}
+
+// decodePickleLong decodes a little-endian two's complement integer.
+func decodePickleLong(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	// Convert to big-endian.
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+
+	v := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	if !v.IsInt64() {
+		return 0, errors.New("long out of range")
+	}
+	return v.Int64(), nil
+}
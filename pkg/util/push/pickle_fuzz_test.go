@@ -0,0 +1,37 @@
+//go:build go1.18
+// +build go1.18
+
+package push
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func FuzzUnpickle(f *testing.F) {
+	// Seed the corpus with the pickle encodings of the Graphite payloads used by TestUnpickle,
+	// and some invalid ones.
+	for _, seed := range []string{
+		"286c70300a2856736572766572732e686f7374312e6370750a70310a2849313630303030303030300a46312e350a7470320a7470330a612856736572766572732e686f7374322e6370753b64633d65750a70340a2846313630303030303030312e350a49320a7470350a7470360a612e",
+		"80025d7100285811000000736572766572732e686f7374312e63707571014a00105e5f473ff80000000000008671028671035817000000736572766572732e686f7374322e6370753b64633d657571044741d7d784006000004b02867105867106652e",
+		"80049554000000000000005d94288c11736572766572732e686f7374312e637075944a00105e5f473ff8000000000000869486948c17736572766572732e686f7374322e6370753b64633d6575944741d7d784006000004b0286948694652e",
+		hex.EncodeToString([]byte("(lp0\n(S'servers.host1.cpu'\np1\n(L1600000000L\nF1.5\ntp2\ntp3\na.")),
+		hex.EncodeToString([]byte("c__builtin__\neval\n.")),
+		"80025803ffffff",
+	} {
+		data, err := hex.DecodeString(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	now := time.Unix(1600000000, 0)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Any input must be either decoded or rejected with an error, without panicking.
+		_, _ = unpickle(data)
+		_, _ = parseGraphitePickle(data, now)
+	})
+}
@@ -0,0 +1,52 @@
+package push
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnpickle(t *testing.T) {
+	// Generated with pickle.dumps([('servers.host1.cpu', (1600000000, 1.5)), ('servers.host2.cpu;dc=eu', (1600000001.5, 2))], protocol=N).
+	tests := map[string]string{
+		"protocol 0": "286c70300a2856736572766572732e686f7374312e6370750a70310a2849313630303030303030300a46312e350a7470320a7470330a612856736572766572732e686f7374322e6370753b64633d65750a70340a2846313630303030303030312e350a49320a7470350a7470360a612e",
+		"protocol 2": "80025d7100285811000000736572766572732e686f7374312e63707571014a00105e5f473ff80000000000008671028671035817000000736572766572732e686f7374322e6370753b64633d657571044741d7d784006000004b02867105867106652e",
+		"protocol 4": "80049554000000000000005d94288c11736572766572732e686f7374312e637075944a00105e5f473ff8000000000000869486948c17736572766572732e686f7374322e6370753b64633d6575944741d7d784006000004b0286948694652e",
+		"python 2":   hex.EncodeToString([]byte("(lp0\n(S'servers.host1.cpu'\np1\n(L1600000000L\nF1.5\ntp2\ntp3\na(S'servers.host2.cpu;dc=eu'\np4\n(F1600000001.5\nI2\ntp5\ntp6\na.")),
+	}
+
+	expected := &pickleList{items: []interface{}{
+		[]interface{}{"servers.host1.cpu", []interface{}{int64(1600000000), 1.5}},
+		[]interface{}{"servers.host2.cpu;dc=eu", []interface{}{1600000001.5, int64(2)}},
+	}}
+
+	for testName, data := range tests {
+		t.Run(testName, func(t *testing.T) {
+			b, err := hex.DecodeString(data)
+			require.NoError(t, err)
+
+			actual, err := unpickle(b)
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestUnpickle_ShouldFailOnInvalidData(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":              nil,
+		"missing stop":       []byte("(lp0\n"),
+		"unsupported opcode": []byte("c__builtin__\neval\n."),
+		"truncated string":   {0x80, 0x02, 0x58, 0xff, 0x00, 0x00, 0x00, 'a', '.'},
+		"stack underflow":    []byte("a."),
+	}
+
+	for testName, data := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := unpickle(data)
+			assert.Error(t, err)
+		})
+	}
+}
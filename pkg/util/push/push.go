@@ -1,10 +1,18 @@
 package push
 
 import (
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"unicode"
 
+	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/middleware"
 
@@ -19,15 +27,8 @@ type Func func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse
 // Handler is a http.Handler which accepts WriteRequests.
 func Handler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push Func) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		logger := log.WithContext(ctx, log.Logger)
-		if sourceIPs != nil {
-			source := sourceIPs.Get(r)
-			if source != "" {
-				ctx = util.AddSourceIPsToOutgoingContext(ctx, source)
-				logger = log.WithSourceIPs(source, logger)
-			}
-		}
+		ctx, logger := contextWithSourceIPs(r, sourceIPs)
+
 		var req cortexpb.PreallocWriteRequest
 		err := util.ParseProtoReader(ctx, r.Body, int(r.ContentLength), maxRecvMsgSize, &req, util.RawSnappy)
 		if err != nil {
@@ -42,15 +43,86 @@ func Handler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push F
 		}
 
 		if _, err := push(ctx, &req.WriteRequest); err != nil {
-			resp, ok := httpgrpc.HTTPResponseFromError(err)
-			if !ok {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if resp.GetCode() != 202 {
-				level.Error(logger).Log("msg", "push error", "err", err)
-			}
-			http.Error(w, string(resp.Body), int(resp.Code))
+			writePushError(w, logger, err)
 		}
 	})
 }
+
+// contextWithSourceIPs returns the request context and logger, enriched with the request source IPs if any.
+func contextWithSourceIPs(r *http.Request, sourceIPs *middleware.SourceIPExtractor) (context.Context, kitlog.Logger) {
+	ctx := r.Context()
+	logger := log.WithContext(ctx, log.Logger)
+	if sourceIPs != nil {
+		source := sourceIPs.Get(r)
+		if source != "" {
+			ctx = util.AddSourceIPsToOutgoingContext(ctx, source)
+			logger = log.WithSourceIPs(source, logger)
+		}
+	}
+	return ctx, logger
+}
+
+// writePushError writes the error returned by the push function to the HTTP response.
+func writePushError(w http.ResponseWriter, logger kitlog.Logger, err error) {
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if resp.GetCode() != 202 {
+		level.Error(logger).Log("msg", "push error", "err", err)
+	}
+	http.Error(w, string(resp.Body), int(resp.Code))
+}
+
+// readRequestBody reads the request body, decompressing it if gzip encoded, and fails
+// if the (decompressed) body is larger than maxRecvMsgSize.
+func readRequestBody(r *http.Request, maxRecvMsgSize int) ([]byte, error) {
+	reader := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "create gzip reader")
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	// Read up to max+1 bytes, to detect whether the request is over the limit.
+	body, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxRecvMsgSize)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read request body")
+	}
+	if len(body) > maxRecvMsgSize {
+		return nil, fmt.Errorf("received message larger than max (%d vs %d)", len(body), maxRecvMsgSize)
+	}
+	return body, nil
+}
+
+// sanitizeLabelName replaces the characters not allowed in a Prometheus label name with underscores.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+// sanitizeMetricName replaces the characters not allowed in a Prometheus metric name with underscores.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+func sanitizeName(name string, allowColons bool) string {
+	if name == "" {
+		return ""
+	}
+
+	sanitized := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || (allowColons && r == ':')) {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "key_" + sanitized
+	}
+	return sanitized
+}
@@ -108,3 +108,11 @@ func createCortexWriteRequestProtobuf(t *testing.T, skipLabelNameValidation bool
 	require.NoError(t, err)
 	return inoutBytes
 }
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "http_server_duration", sanitizeMetricName("http.server.duration"))
+	assert.Equal(t, "job:up:sum", sanitizeMetricName("job:up:sum"))
+	assert.Equal(t, "job_up_sum", sanitizeLabelName("job:up:sum"))
+	assert.Equal(t, "key_0_label", sanitizeLabelName("0-label"))
+	assert.Equal(t, "", sanitizeLabelName(""))
+}
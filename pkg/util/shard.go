@@ -10,6 +10,9 @@ const (
 	// Sharding strategies.
 	ShardingStrategyDefault = "default"
 	ShardingStrategyShuffle = "shuffle-sharding"
+
+	// ShardingStrategyBalanced is supported by the store-gateway only.
+	ShardingStrategyBalanced = "balanced"
 )
 
 var (
@@ -48,6 +48,20 @@ func ParseTime(s string) (int64, error) {
 	return 0, httpgrpc.Errorf(http.StatusBadRequest, "cannot parse %q to a valid timestamp", s)
 }
 
+// ParseTimeParam parses the time of a request parameter, defaulting to now if missing or invalid.
+func ParseTimeParam(value string, now time.Time) time.Time {
+	if value == "" {
+		return now
+	}
+
+	t, err := ParseTime(value)
+	if err != nil {
+		return now
+	}
+
+	return TimeFromMillis(t)
+}
+
 // DurationWithJitter returns random duration from "input - input*variance" to "input + input*variance" interval.
 func DurationWithJitter(input time.Duration, variancePerc float64) time.Duration {
 	// No duration? No jitter.
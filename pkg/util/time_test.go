@@ -104,6 +104,15 @@ func TestParseTime(t *testing.T) {
 	}
 }
 
+func TestParseTimeParam(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	assert.Equal(t, now, ParseTimeParam("", now))
+	assert.Equal(t, now, ParseTimeParam("abc", now))
+	assert.Equal(t, time.Unix(123, 0), ParseTimeParam("123", now))
+	assert.Equal(t, time.Unix(1433337718, 555*time.Millisecond.Nanoseconds()), ParseTimeParam("2015-06-03T13:21:58.555Z", now))
+}
+
 func TestNewDisableableTicker_Enabled(t *testing.T) {
 	stop, ch := NewDisableableTicker(10 * time.Millisecond)
 	defer stop()
@@ -0,0 +1,60 @@
+package validation
+
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BlockedQuery blocks the queries whose expression matches the pattern and whose
+// time range is within the time window, if set.
+type BlockedQuery struct {
+	Pattern    string          `yaml:"pattern" json:"pattern"`
+	TimeWindow QueryTimeWindow `yaml:"time_window" json:"time_window"`
+
+	compiledPattern *regexp.Regexp
+}
+
+// Validate returns an error if the blocked query is invalid.
+func (b *BlockedQuery) Validate() (err error) {
+	if b.Pattern == "" {
+		return errors.New("invalid blocked query: the pattern is required")
+	}
+
+	if b.compiledPattern, err = regexp.Compile("^(?:" + b.Pattern + ")$"); err != nil {
+		return errors.Wrapf(err, "invalid blocked query pattern %q", b.Pattern)
+	}
+
+	return nil
+}
+
+// Matches returns whether the query is blocked.
+func (b BlockedQuery) Matches(query string, start, end, now time.Time) bool {
+	if b.compiledPattern == nil || !b.compiledPattern.MatchString(query) {
+		return false
+	}
+
+	return b.TimeWindow.contains(start, end, now)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (b *BlockedQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain BlockedQuery
+	if err := unmarshal((*plain)(b)); err != nil {
+		return err
+	}
+
+	return b.Validate()
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *BlockedQuery) UnmarshalJSON(data []byte) error {
+	type plain BlockedQuery
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+
+	return b.Validate()
+}
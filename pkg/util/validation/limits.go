@@ -41,6 +41,7 @@ type Limits struct {
 	HAClusterLabel            string              `yaml:"ha_cluster_label" json:"ha_cluster_label"`
 	HAReplicaLabel            string              `yaml:"ha_replica_label" json:"ha_replica_label"`
 	HAMaxClusters             int                 `yaml:"ha_max_clusters" json:"ha_max_clusters"`
+	HADedupPerSeries          bool                `yaml:"ha_dedup_per_series" json:"ha_dedup_per_series"`
 	DropLabels                flagext.StringSlice `yaml:"drop_labels" json:"drop_labels"`
 	MaxLabelNameLength        int                 `yaml:"max_label_name_length" json:"max_label_name_length"`
 	MaxLabelValueLength       int                 `yaml:"max_label_value_length" json:"max_label_value_length"`
@@ -52,6 +53,8 @@ type Limits struct {
 	EnforceMetadataMetricName bool                `yaml:"enforce_metadata_metric_name" json:"enforce_metadata_metric_name"`
 	EnforceMetricName         bool                `yaml:"enforce_metric_name" json:"enforce_metric_name"`
 	IngestionTenantShardSize  int                 `yaml:"ingestion_tenant_shard_size" json:"ingestion_tenant_shard_size"`
+	CostAttributionLabel      string              `yaml:"cost_attribution_label" json:"cost_attribution_label"`
+	MaxCostAttributionPerUser int                 `yaml:"max_cost_attribution_per_user" json:"max_cost_attribution_per_user"`
 	MetricRelabelConfigs      []*relabel.Config   `yaml:"metric_relabel_configs,omitempty" json:"metric_relabel_configs,omitempty" doc:"nocli|description=List of metric relabel configurations. Note that in most situations, it is more effective to use metrics relabeling directly in the Prometheus server, e.g. remote_write.write_relabel_configs."`
 
 	// Ingester enforced limits.
@@ -63,6 +66,8 @@ type Limits struct {
 	MaxGlobalSeriesPerUser   int `yaml:"max_global_series_per_user" json:"max_global_series_per_user"`
 	MaxGlobalSeriesPerMetric int `yaml:"max_global_series_per_metric" json:"max_global_series_per_metric"`
 	MinChunkLength           int `yaml:"min_chunk_length" json:"min_chunk_length"`
+	// Out-of-order samples
+	OutOfOrderTimeWindow model.Duration `yaml:"out_of_order_time_window" json:"out_of_order_time_window"`
 	// Metadata
 	MaxLocalMetricsWithMetadataPerUser  int `yaml:"max_metadata_per_user" json:"max_metadata_per_user"`
 	MaxLocalMetadataPerMetric           int `yaml:"max_metadata_per_metric" json:"max_metadata_per_metric"`
@@ -79,19 +84,31 @@ type Limits struct {
 	MaxQueryParallelism          int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
 	CardinalityLimit             int            `yaml:"cardinality_limit" json:"cardinality_limit"`
 	MaxCacheFreshness            model.Duration `yaml:"max_cache_freshness" json:"max_cache_freshness"`
+	ResultsCacheTTLInstantQuery  model.Duration `yaml:"results_cache_ttl_for_instant_query" json:"results_cache_ttl_for_instant_query"`
+	ResultsCacheTTLLabelsQuery   model.Duration `yaml:"results_cache_ttl_for_labels_query" json:"results_cache_ttl_for_labels_query"`
 	MaxQueriersPerTenant         int            `yaml:"max_queriers_per_tenant" json:"max_queriers_per_tenant"`
 
+	// Query-frontend and query-scheduler.
+	QueryPriorities []QueryPriority `yaml:"query_priorities" json:"query_priorities" doc:"nocli|description=List of query priorities, each one made of a priority, the number of queriers reserved to the queries with at least this priority (a value lower than 1 is a fraction of the tenant's queriers) and a list of query attributes. A query matches an attribute if it matches all of its non-empty fields: the regex over the query expression, the HTTP header (and the regex over its value) and the time window relative to now within which the query time range must fall. The query-frontend and query-scheduler dequeue the queries of a tenant with higher priority first. Queries matching no priority have priority 0. Priorities of multi-tenant queries are taken from the default limits."`
+	BlockedQueries  []BlockedQuery  `yaml:"blocked_queries" json:"blocked_queries" doc:"nocli|description=List of blocked queries, each one made of a regex matched against the whole query expression and an optional time window relative to now within which the query time range must fall. The query-frontend rejects the range and instant queries matching any of them with HTTP status code 422 before they are queued."`
+
 	// Ruler defaults and limits.
-	RulerEvaluationDelay        model.Duration `yaml:"ruler_evaluation_delay_duration" json:"ruler_evaluation_delay_duration"`
-	RulerTenantShardSize        int            `yaml:"ruler_tenant_shard_size" json:"ruler_tenant_shard_size"`
-	RulerMaxRulesPerRuleGroup   int            `yaml:"ruler_max_rules_per_rule_group" json:"ruler_max_rules_per_rule_group"`
-	RulerMaxRuleGroupsPerTenant int            `yaml:"ruler_max_rule_groups_per_tenant" json:"ruler_max_rule_groups_per_tenant"`
+	RulerEvaluationDelay        model.Duration         `yaml:"ruler_evaluation_delay_duration" json:"ruler_evaluation_delay_duration"`
+	RulerTenantShardSize        int                    `yaml:"ruler_tenant_shard_size" json:"ruler_tenant_shard_size"`
+	RulerMaxRulesPerRuleGroup   int                    `yaml:"ruler_max_rules_per_rule_group" json:"ruler_max_rules_per_rule_group"`
+	RulerMaxRuleGroupsPerTenant int                    `yaml:"ruler_max_rule_groups_per_tenant" json:"ruler_max_rule_groups_per_tenant"`
+	RulerTenantFederation       bool                   `yaml:"ruler_tenant_federation_enabled" json:"ruler_tenant_federation_enabled"`
+	RulerAllowedSourceTenants   flagext.StringSliceCSV `yaml:"ruler_allowed_source_tenants" json:"ruler_allowed_source_tenants"`
 
 	// Store-gateway.
 	StoreGatewayTenantShardSize int `yaml:"store_gateway_tenant_shard_size" json:"store_gateway_tenant_shard_size"`
 
 	// Compactor.
-	CompactorBlocksRetentionPeriod model.Duration `yaml:"compactor_blocks_retention_period" json:"compactor_blocks_retention_period"`
+	CompactorBlocksRetentionPeriod model.Duration  `yaml:"compactor_blocks_retention_period" json:"compactor_blocks_retention_period"`
+	CompactorTenantShardSize       int             `yaml:"compactor_tenant_shard_size" json:"compactor_tenant_shard_size"`
+	CompactorSplitShards           int             `yaml:"compactor_split_shards" json:"compactor_split_shards"`
+	CompactorDownsamplingEnabled   bool            `yaml:"compactor_downsampling_enabled" json:"compactor_downsampling_enabled"`
+	CompactorRetentionRules        []RetentionRule `yaml:"compactor_retention_rules" json:"compactor_retention_rules" doc:"nocli|description=List of retention rules, each one made of a series selector and a retention period. The samples of the series matching a rule's selector which are older than the rule's period are hidden by queriers and deleted by the compactor. If a series matches multiple rules, the shortest period applies. Rules can only shorten the retention of the matching series, whose blocks are still deleted after the compactor_blocks_retention_period."`
 
 	// This config doesn't have a CLI flag registered here because they're registered in
 	// their own original config struct.
@@ -124,6 +141,7 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&l.HAClusterLabel, "distributor.ha-tracker.cluster", "cluster", "Prometheus label to look for in samples to identify a Prometheus HA cluster.")
 	f.StringVar(&l.HAReplicaLabel, "distributor.ha-tracker.replica", "__replica__", "Prometheus label to look for in samples to identify a Prometheus HA replica.")
 	f.IntVar(&l.HAMaxClusters, "distributor.ha-tracker.max-clusters", 0, "Maximum number of clusters that HA tracker will keep track of for single user. 0 to disable the limit.")
+	f.BoolVar(&l.HADedupPerSeries, "distributor.ha-tracker.dedup-per-series", false, "Evaluate the HA cluster and replica labels of each series in a write request, instead of only the first series. When enabled, a single write request can contain series of different HA clusters: the series received from the elected replica of their cluster are accepted, while the others are deduplicated.")
 	f.Var(&l.DropLabels, "distributor.drop-label", "This flag can be used to specify label names that to drop during sample ingestion within the distributor and can be repeated in order to drop multiple labels.")
 	f.IntVar(&l.MaxLabelNameLength, "validation.max-length-label-name", 1024, "Maximum length accepted for label names")
 	f.IntVar(&l.MaxLabelValueLength, "validation.max-length-label-value", 2048, "Maximum length accepted for label value. This setting also applies to the metric name")
@@ -136,6 +154,8 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.Var(&l.CreationGracePeriod, "validation.create-grace-period", "Duration which table will be created/deleted before/after it's needed; we won't accept sample from before this time.")
 	f.BoolVar(&l.EnforceMetricName, "validation.enforce-metric-name", true, "Enforce every sample has a metric name.")
 	f.BoolVar(&l.EnforceMetadataMetricName, "validation.enforce-metadata-metric-name", true, "Enforce every metadata has a metric name.")
+	f.StringVar(&l.CostAttributionLabel, "validation.cost-attribution-label", "", "Label used to attribute the tenant's active series, ingested samples and discarded samples to different cost centers, e.g. team. Ingesters and distributors export per-tenant metrics broken down by the values of this label. Series without the label are attributed to the \"__missing__\" value. Empty to disable cost attribution.")
+	f.IntVar(&l.MaxCostAttributionPerUser, "validation.max-cost-attribution-per-user", 100, "Maximum number of cost attribution label values tracked per-tenant, per instance. Series with further values are attributed to the \"__overflow__\" value. 0 to disable the limit.")
 
 	f.IntVar(&l.MaxSeriesPerQuery, "ingester.max-series-per-query", 100000, "The maximum number of series for which a query can fetch samples from each ingester. This limit is enforced only in the ingesters (when querying samples not flushed to the storage yet) and it's a per-instance limit. This limit is ignored when running the Cortex blocks storage. When running Cortex with blocks storage use -querier.max-fetched-series-per-query limit instead.")
 	f.IntVar(&l.MaxSamplesPerQuery, "ingester.max-samples-per-query", 1000000, "The maximum number of samples that a query can return. This limit only applies when running the Cortex chunks storage with -querier.ingester-streaming=false.")
@@ -143,6 +163,7 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.MaxLocalSeriesPerMetric, "ingester.max-series-per-metric", 50000, "The maximum number of active series per metric name, per ingester. 0 to disable.")
 	f.IntVar(&l.MaxGlobalSeriesPerUser, "ingester.max-global-series-per-user", 0, "The maximum number of active series per user, across the cluster before replication. 0 to disable. Supported only if -distributor.shard-by-all-labels is true.")
 	f.IntVar(&l.MaxGlobalSeriesPerMetric, "ingester.max-global-series-per-metric", 0, "The maximum number of active series per metric name, across the cluster before replication. 0 to disable.")
+	f.Var(&l.OutOfOrderTimeWindow, "ingester.out-of-order-time-window", "How far back in time, from the most recent sample of the tenant, out-of-order and out-of-bounds samples are accepted by the ingester (experimental). Out-of-order samples are kept in memory, written to a dedicated WAL and periodically compacted into blocks which overlap the other ones. Overlapping blocks are allowed only for the tenants whose window is enabled when their TSDB is opened, so enabling the window takes effect once the tenant's TSDB is opened again. Series with only out-of-order samples count towards the per-tenant series limits. This option is only supported when running the Cortex blocks storage. 0 to disable.")
 	f.IntVar(&l.MinChunkLength, "ingester.min-chunk-length", 0, "Minimum number of samples in an idle chunk to flush it to the store. Use with care, if chunks are less than this size they will be discarded. This option is ignored when running the Cortex blocks storage. 0 to disable.")
 
 	f.IntVar(&l.MaxLocalMetricsWithMetadataPerUser, "ingester.max-metadata-per-user", 8000, "The maximum number of active metrics with metadata per user, per ingester. 0 to disable.")
@@ -159,14 +180,21 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.CardinalityLimit, "store.cardinality-limit", 1e5, "Cardinality limit for index queries. This limit is ignored when running the Cortex blocks storage. 0 to disable.")
 	_ = l.MaxCacheFreshness.Set("1m")
 	f.Var(&l.MaxCacheFreshness, "frontend.max-cache-freshness", "Most recent allowed cacheable result per-tenant, to prevent caching very recent results that might still be in flux.")
+	f.Var(&l.ResultsCacheTTLInstantQuery, "frontend.results-cache-ttl-for-instant-query", "Time to live of the cached instant query results per-tenant. Requires -querier.cache-results. 0 to disable caching of instant query results.")
+	f.Var(&l.ResultsCacheTTLLabelsQuery, "frontend.results-cache-ttl-for-labels-query", "Time to live of the cached label names, label values and series API results per-tenant. Requires -querier.cache-results. 0 to disable caching of labels query results.")
 	f.IntVar(&l.MaxQueriersPerTenant, "frontend.max-queriers-per-tenant", 0, "Maximum number of queriers that can handle requests for a single tenant. If set to 0 or value higher than number of available queriers, *all* queriers will handle requests for the tenant. Each frontend (or query-scheduler, if used) will select the same set of queriers for the same tenant (given that all queriers are connected to all frontends / query-schedulers). This option only works with queriers connecting to the query-frontend / query-scheduler, not when using downstream URL.")
 
 	f.Var(&l.RulerEvaluationDelay, "ruler.evaluation-delay-duration", "Duration to delay the evaluation of rules to ensure the underlying metrics have been pushed to Cortex.")
 	f.IntVar(&l.RulerTenantShardSize, "ruler.tenant-shard-size", 0, "The default tenant's shard size when the shuffle-sharding strategy is used by ruler. When this setting is specified in the per-tenant overrides, a value of 0 disables shuffle sharding for the tenant.")
 	f.IntVar(&l.RulerMaxRulesPerRuleGroup, "ruler.max-rules-per-rule-group", 0, "Maximum number of rules per rule group per-tenant. 0 to disable.")
 	f.IntVar(&l.RulerMaxRuleGroupsPerTenant, "ruler.max-rule-groups-per-tenant", 0, "Maximum number of rule groups per-tenant. 0 to disable.")
+	f.BoolVar(&l.RulerTenantFederation, "ruler.tenant-federation-enabled", false, "Enable federated rule groups, whose queries are evaluated over the source tenants declared in the rule group, for the tenant. Requires -tenant-federation.enabled=true (experimental).")
+	f.Var(&l.RulerAllowedSourceTenants, "ruler.allowed-source-tenants", "Comma separated list of tenants whose series can be queried by the federated rule groups of the tenant, in addition to the tenant itself (experimental).")
 
 	f.Var(&l.CompactorBlocksRetentionPeriod, "compactor.blocks-retention-period", "Delete blocks containing samples older than the specified retention period. 0 to disable.")
+	f.BoolVar(&l.CompactorDownsamplingEnabled, "compactor.downsampling-enabled", false, "If enabled, the compactor downsamples the tenant's blocks to 5m resolution once they span at least 40h (or the largest compaction range, if shorter), and the 5m blocks to 1h resolution once they span at least 10d (or the largest compaction range, if shorter). Raw blocks are kept.")
+	f.IntVar(&l.CompactorTenantShardSize, "compactor.tenant-shard-size", 0, "The default tenant's shard size when the shuffle-sharding strategy is used by the compactor. When this setting is specified in the per-tenant overrides, a value of 0 disables shuffle sharding for the tenant, whose compaction jobs are sharded across all compactors.")
+	f.IntVar(&l.CompactorSplitShards, "compactor.split-shards", 0, "The number of shards each block time range is split into, by series hash, when the split-and-merge compaction strategy is used. 0 to disable splitting.")
 
 	// Store-gateway.
 	f.IntVar(&l.StoreGatewayTenantShardSize, "store-gateway.tenant-shard-size", 0, "The default tenant's shard size when the shuffle-sharding strategy is used. Must be set when the store-gateway sharding is enabled with the shuffle-sharding strategy. When this setting is specified in the per-tenant overrides, a value of 0 disables shuffle sharding for the tenant.")
@@ -365,6 +393,16 @@ func (o *Overrides) MaxSamplesPerQuery(userID string) int {
 	return o.getOverridesForUser(userID).MaxSamplesPerQuery
 }
 
+// CostAttributionLabel returns the label used to attribute the tenant's series to cost centers.
+func (o *Overrides) CostAttributionLabel(userID string) string {
+	return o.getOverridesForUser(userID).CostAttributionLabel
+}
+
+// MaxCostAttributionPerUser returns the maximum number of cost attribution label values tracked per tenant.
+func (o *Overrides) MaxCostAttributionPerUser(userID string) int {
+	return o.getOverridesForUser(userID).MaxCostAttributionPerUser
+}
+
 // MaxLocalSeriesPerUser returns the maximum number of series a user is allowed to store in a single ingester.
 func (o *Overrides) MaxLocalSeriesPerUser(userID string) int {
 	return o.getOverridesForUser(userID).MaxLocalSeriesPerUser
@@ -385,6 +423,12 @@ func (o *Overrides) MaxGlobalSeriesPerMetric(userID string) int {
 	return o.getOverridesForUser(userID).MaxGlobalSeriesPerMetric
 }
 
+// OutOfOrderTimeWindow returns how far back in time, from the most recent sample of the tenant,
+// out-of-order samples are accepted by the ingester.
+func (o *Overrides) OutOfOrderTimeWindow(userID string) time.Duration {
+	return time.Duration(o.getOverridesForUser(userID).OutOfOrderTimeWindow)
+}
+
 // MaxChunksPerQueryFromStore returns the maximum number of chunks allowed per query when fetching
 // chunks from the long-term storage.
 func (o *Overrides) MaxChunksPerQueryFromStore(userID string) int {
@@ -429,11 +473,32 @@ func (o *Overrides) MaxCacheFreshness(userID string) time.Duration {
 	return time.Duration(o.getOverridesForUser(userID).MaxCacheFreshness)
 }
 
+// ResultsCacheTTLForInstantQuery returns the time to live of the cached instant query results.
+func (o *Overrides) ResultsCacheTTLForInstantQuery(userID string) time.Duration {
+	return time.Duration(o.getOverridesForUser(userID).ResultsCacheTTLInstantQuery)
+}
+
+// ResultsCacheTTLForLabelsQuery returns the time to live of the cached label names,
+// label values and series API results.
+func (o *Overrides) ResultsCacheTTLForLabelsQuery(userID string) time.Duration {
+	return time.Duration(o.getOverridesForUser(userID).ResultsCacheTTLLabelsQuery)
+}
+
 // MaxQueriersPerUser returns the maximum number of queriers that can handle requests for this user.
 func (o *Overrides) MaxQueriersPerUser(userID string) int {
 	return o.getOverridesForUser(userID).MaxQueriersPerTenant
 }
 
+// QueryPriorities returns the query priorities for a given user.
+func (o *Overrides) QueryPriorities(userID string) []QueryPriority {
+	return o.getOverridesForUser(userID).QueryPriorities
+}
+
+// BlockedQueries returns the blocked queries for a given user.
+func (o *Overrides) BlockedQueries(userID string) []BlockedQuery {
+	return o.getOverridesForUser(userID).BlockedQueries
+}
+
 // MaxQueryParallelism returns the limit to the number of split queries the
 // frontend will process in parallel.
 func (o *Overrides) MaxQueryParallelism(userID string) int {
@@ -495,6 +560,26 @@ func (o *Overrides) CompactorBlocksRetentionPeriod(userID string) time.Duration
 	return time.Duration(o.getOverridesForUser(userID).CompactorBlocksRetentionPeriod)
 }
 
+// CompactorTenantShardSize returns the shard size (number of compactors) used by the shuffle-sharding strategy for a given user.
+func (o *Overrides) CompactorTenantShardSize(userID string) int {
+	return o.getOverridesForUser(userID).CompactorTenantShardSize
+}
+
+// CompactorSplitShards returns the number of shards blocks are split into by the split-and-merge compactor for a given user.
+func (o *Overrides) CompactorSplitShards(userID string) int {
+	return o.getOverridesForUser(userID).CompactorSplitShards
+}
+
+// CompactorDownsamplingEnabled returns whether the compactor should downsample the blocks of a given user.
+func (o *Overrides) CompactorDownsamplingEnabled(userID string) bool {
+	return o.getOverridesForUser(userID).CompactorDownsamplingEnabled
+}
+
+// CompactorRetentionRules returns the retention rules for a given user.
+func (o *Overrides) CompactorRetentionRules(userID string) []RetentionRule {
+	return o.getOverridesForUser(userID).CompactorRetentionRules
+}
+
 // MetricRelabelConfigs returns the metric relabel configs for a given user.
 func (o *Overrides) MetricRelabelConfigs(userID string) []*relabel.Config {
 	return o.getOverridesForUser(userID).MetricRelabelConfigs
@@ -515,11 +600,27 @@ func (o *Overrides) RulerMaxRuleGroupsPerTenant(userID string) int {
 	return o.getOverridesForUser(userID).RulerMaxRuleGroupsPerTenant
 }
 
+// RulerTenantFederationEnabled returns whether federated rule groups are enabled for a given user.
+func (o *Overrides) RulerTenantFederationEnabled(userID string) bool {
+	return o.getOverridesForUser(userID).RulerTenantFederation
+}
+
+// RulerAllowedSourceTenants returns the tenants which can be queried by the federated rule groups of a given user,
+// in addition to the user itself.
+func (o *Overrides) RulerAllowedSourceTenants(userID string) []string {
+	return o.getOverridesForUser(userID).RulerAllowedSourceTenants
+}
+
 // StoreGatewayTenantShardSize returns the store-gateway shard size for a given user.
 func (o *Overrides) StoreGatewayTenantShardSize(userID string) int {
 	return o.getOverridesForUser(userID).StoreGatewayTenantShardSize
 }
 
+// HADedupPerSeries returns whether the HA deduplication should be evaluated for each series of a write request.
+func (o *Overrides) HADedupPerSeries(userID string) bool {
+	return o.getOverridesForUser(userID).HADedupPerSeries
+}
+
 // MaxHAClusters returns maximum number of clusters that HA tracker will track for a user.
 func (o *Overrides) MaxHAClusters(user string) int {
 	return o.getOverridesForUser(user).HAMaxClusters
@@ -254,6 +254,136 @@ metric_relabel_configs:
 	assert.Equal(t, []*relabel.Config{&exp}, l.MetricRelabelConfigs)
 }
 
+func TestCompactorRetentionRulesLoading(t *testing.T) {
+	SetDefaultLimitsForYAMLUnmarshalling(Limits{})
+
+	expected := []RetentionRule{
+		{Selector: `{__name__=~"debug_.+"}`, Period: model.Duration(7 * 24 * time.Hour)},
+		{Selector: `{slo="true"}`, Period: model.Duration(395 * 24 * time.Hour)},
+	}
+
+	inputYAML := `
+compactor_retention_rules:
+- selector: '{__name__=~"debug_.+"}'
+  period: 7d
+- selector: '{slo="true"}'
+  period: 395d
+`
+	inputJSON := `{"compactor_retention_rules": [{"selector": "{__name__=~\"debug_.+\"}", "period": "7d"}, {"selector": "{slo=\"true\"}", "period": "395d"}]}`
+
+	limitsYAML := Limits{}
+	require.NoError(t, yaml.UnmarshalStrict([]byte(inputYAML), &limitsYAML))
+	assert.Equal(t, expected, limitsYAML.CompactorRetentionRules)
+
+	limitsJSON := Limits{}
+	require.NoError(t, json.Unmarshal([]byte(inputJSON), &limitsJSON))
+	assert.Equal(t, expected, limitsJSON.CompactorRetentionRules)
+
+	// Invalid rules.
+	for _, input := range []string{
+		"compactor_retention_rules: [{selector: '{job=', period: 7d}]",
+		"compactor_retention_rules: [{selector: '{job=\"test\"}', period: 0s}]",
+	} {
+		assert.Error(t, yaml.UnmarshalStrict([]byte(input), &Limits{}), input)
+	}
+}
+
+func TestQueryPrioritiesLoading(t *testing.T) {
+	SetDefaultLimitsForYAMLUnmarshalling(Limits{})
+
+	inputYAML := `
+query_priorities:
+- priority: 2
+  reserved_queriers: 0.5
+  query_attributes:
+  - regex: '.*ALERTS.*'
+  - header: X-Dashboard
+    header_regex: 'critical-.*'
+- priority: 1
+  query_attributes:
+  - time_window:
+      start: 1h
+`
+	inputJSON := `{"query_priorities": [{"priority": 2, "reserved_queriers": 0.5, "query_attributes": [{"regex": ".*ALERTS.*"}, {"header": "X-Dashboard", "header_regex": "critical-.*"}]}, {"priority": 1, "query_attributes": [{"time_window": {"start": "1h"}}]}]}`
+
+	now := time.Now()
+	check := func(t *testing.T, priorities []QueryPriority) {
+		require.Len(t, priorities, 2)
+
+		assert.Equal(t, int64(2), priorities[0].Priority)
+		assert.Equal(t, 2, priorities[0].ReservedQueriersCount(4))
+		assert.True(t, priorities[0].Matches("count(ALERTS)", nil, now, now, now))
+		assert.False(t, priorities[0].Matches("up", nil, now, now, now))
+		assert.True(t, priorities[0].Matches("up", map[string][]string{"X-Dashboard": {"critical-slo"}}, now, now, now))
+		assert.False(t, priorities[0].Matches("up", map[string][]string{"X-Dashboard": {"ad-hoc"}}, now, now, now))
+
+		assert.Equal(t, int64(1), priorities[1].Priority)
+		assert.Equal(t, 0, priorities[1].ReservedQueriersCount(4))
+		assert.True(t, priorities[1].Matches("up", nil, now.Add(-30*time.Minute), now, now))
+		assert.False(t, priorities[1].Matches("up", nil, now.Add(-2*time.Hour), now, now))
+	}
+
+	limitsYAML := Limits{}
+	require.NoError(t, yaml.UnmarshalStrict([]byte(inputYAML), &limitsYAML))
+	check(t, limitsYAML.QueryPriorities)
+
+	limitsJSON := Limits{}
+	require.NoError(t, json.Unmarshal([]byte(inputJSON), &limitsJSON))
+	check(t, limitsJSON.QueryPriorities)
+
+	// Invalid priorities.
+	for _, input := range []string{
+		"query_priorities: [{priority: 1}]",
+		"query_priorities: [{priority: 1, reserved_queriers: -1, query_attributes: [{regex: up}]}]",
+		"query_priorities: [{priority: 1, query_attributes: [{regex: '('}]}]",
+		"query_priorities: [{priority: 1, query_attributes: [{header_regex: '.*'}]}]",
+	} {
+		assert.Error(t, yaml.UnmarshalStrict([]byte(input), &Limits{}), input)
+	}
+}
+
+func TestBlockedQueriesLoading(t *testing.T) {
+	SetDefaultLimitsForYAMLUnmarshalling(Limits{})
+
+	inputYAML := `
+blocked_queries:
+- pattern: '.*container_.*'
+- pattern: 'expensive_metric'
+  time_window:
+    end: 1d
+`
+	inputJSON := `{"blocked_queries": [{"pattern": ".*container_.*"}, {"pattern": "expensive_metric", "time_window": {"end": "1d"}}]}`
+
+	now := time.Now()
+	check := func(t *testing.T, blocked []BlockedQuery) {
+		require.Len(t, blocked, 2)
+
+		assert.True(t, blocked[0].Matches("sum(container_memory_rss)", now, now, now))
+		assert.False(t, blocked[0].Matches("up", now, now, now))
+
+		assert.Equal(t, model.Duration(24*time.Hour), blocked[1].TimeWindow.End)
+		assert.True(t, blocked[1].Matches("expensive_metric", now.Add(-72*time.Hour), now.Add(-48*time.Hour), now))
+		assert.False(t, blocked[1].Matches("expensive_metric", now.Add(-time.Hour), now, now))
+		assert.False(t, blocked[1].Matches("sum(expensive_metric)", now.Add(-72*time.Hour), now.Add(-48*time.Hour), now))
+	}
+
+	limitsYAML := Limits{}
+	require.NoError(t, yaml.UnmarshalStrict([]byte(inputYAML), &limitsYAML))
+	check(t, limitsYAML.BlockedQueries)
+
+	limitsJSON := Limits{}
+	require.NoError(t, json.Unmarshal([]byte(inputJSON), &limitsJSON))
+	check(t, limitsJSON.BlockedQueries)
+
+	// Invalid blocked queries.
+	for _, input := range []string{
+		"blocked_queries: [{time_window: {start: 1h}}]",
+		"blocked_queries: [{pattern: '('}]",
+	} {
+		assert.Error(t, yaml.UnmarshalStrict([]byte(input), &Limits{}), input)
+	}
+}
+
 func TestSmallestPositiveIntPerTenant(t *testing.T) {
 	tenantLimits := map[string]*Limits{
 		"tenant-a": {
@@ -0,0 +1,158 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// QueryPriority configures the priority of the queries matching any of its attributes. When dequeuing
+// the queries of a tenant, queries with higher priority are dequeued first. Queries not matching any
+// priority have priority 0.
+type QueryPriority struct {
+	Priority         int64            `yaml:"priority" json:"priority"`
+	ReservedQueriers float64          `yaml:"reserved_queriers" json:"reserved_queriers"`
+	QueryAttributes  []QueryAttribute `yaml:"query_attributes" json:"query_attributes"`
+}
+
+// QueryAttribute matches the queries matching all its non-empty fields.
+type QueryAttribute struct {
+	Regex       string          `yaml:"regex" json:"regex"`
+	Header      string          `yaml:"header" json:"header"`
+	HeaderRegex string          `yaml:"header_regex" json:"header_regex"`
+	TimeWindow  QueryTimeWindow `yaml:"time_window" json:"time_window"`
+
+	compiledRegex       *regexp.Regexp
+	compiledHeaderRegex *regexp.Regexp
+}
+
+// QueryTimeWindow matches the queries whose time range is within the window, relative to now.
+type QueryTimeWindow struct {
+	// The query start must be at most this duration ago.
+	Start model.Duration `yaml:"start" json:"start"`
+	// The query end must be at least this duration ago.
+	End model.Duration `yaml:"end" json:"end"`
+}
+
+// Validate returns an error if the priority is invalid.
+func (p *QueryPriority) Validate() error {
+	if p.ReservedQueriers < 0 {
+		return errors.Errorf("invalid reserved queriers for query priority %d: the value must not be negative", p.Priority)
+	}
+
+	if len(p.QueryAttributes) == 0 {
+		return errors.Errorf("invalid query priority %d: at least one query attribute is required", p.Priority)
+	}
+
+	for i := range p.QueryAttributes {
+		if err := p.QueryAttributes[i].compile(); err != nil {
+			return errors.Wrapf(err, "invalid query attribute for query priority %d", p.Priority)
+		}
+	}
+
+	return nil
+}
+
+// ReservedQueriersCount returns the number of queriers reserved to this priority out of the
+// input number of queriers. A value lower than 1 is a fraction of the queriers.
+func (p QueryPriority) ReservedQueriersCount(queriers int) int {
+	if p.ReservedQueriers < 1 {
+		return int(p.ReservedQueriers*float64(queriers) + 0.5)
+	}
+	return int(p.ReservedQueriers)
+}
+
+// Matches returns whether the query matches any of the priority's attributes.
+func (p QueryPriority) Matches(query string, header http.Header, start, end, now time.Time) bool {
+	for _, attr := range p.QueryAttributes {
+		if attr.matches(query, header, start, end, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *QueryAttribute) compile() (err error) {
+	if a.Regex != "" {
+		if a.compiledRegex, err = regexp.Compile("^(?:" + a.Regex + ")$"); err != nil {
+			return errors.Wrapf(err, "invalid regex %q", a.Regex)
+		}
+	}
+
+	if a.HeaderRegex != "" {
+		if a.Header == "" {
+			return errors.New("the header regex requires the header name")
+		}
+		if a.compiledHeaderRegex, err = regexp.Compile("^(?:" + a.HeaderRegex + ")$"); err != nil {
+			return errors.Wrapf(err, "invalid header regex %q", a.HeaderRegex)
+		}
+	}
+
+	return nil
+}
+
+func (a QueryAttribute) matches(query string, header http.Header, start, end, now time.Time) bool {
+	if a.Regex != "" && (a.compiledRegex == nil || !a.compiledRegex.MatchString(query)) {
+		return false
+	}
+
+	if a.Header != "" {
+		values, ok := header[http.CanonicalHeaderKey(a.Header)]
+		if !ok {
+			return false
+		}
+
+		if a.HeaderRegex != "" {
+			matched := false
+			for _, v := range values {
+				if a.compiledHeaderRegex != nil && a.compiledHeaderRegex.MatchString(v) {
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return false
+			}
+		}
+	}
+
+	return a.TimeWindow.contains(start, end, now)
+}
+
+// contains returns whether the time range is within the window. A zero window contains any time range.
+func (w QueryTimeWindow) contains(start, end, now time.Time) bool {
+	if w.Start != 0 && start.Before(now.Add(-time.Duration(w.Start))) {
+		return false
+	}
+
+	if w.End != 0 && end.After(now.Add(-time.Duration(w.End))) {
+		return false
+	}
+
+	return true
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *QueryPriority) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain QueryPriority
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+
+	return p.Validate()
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *QueryPriority) UnmarshalJSON(data []byte) error {
+	type plain QueryPriority
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+
+	return p.Validate()
+}
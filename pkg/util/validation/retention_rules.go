@@ -0,0 +1,54 @@
+package validation
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// RetentionRule configures the retention period of the series matching a selector.
+type RetentionRule struct {
+	Selector string         `yaml:"selector" json:"selector"`
+	Period   model.Duration `yaml:"period" json:"period"`
+}
+
+// Matchers returns the label matchers of the rule's selector.
+func (r RetentionRule) Matchers() ([]*labels.Matcher, error) {
+	return parser.ParseMetricSelector(r.Selector)
+}
+
+// Validate returns an error if the rule is invalid.
+func (r RetentionRule) Validate() error {
+	if _, err := r.Matchers(); err != nil {
+		return errors.Wrapf(err, "invalid retention rule selector %q", r.Selector)
+	}
+
+	if r.Period <= 0 {
+		return errors.Errorf("invalid retention rule period for selector %q: the period must be greater than 0", r.Selector)
+	}
+
+	return nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (r *RetentionRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RetentionRule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+
+	return r.Validate()
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *RetentionRule) UnmarshalJSON(data []byte) error {
+	type plain RetentionRule
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	return r.Validate()
+}
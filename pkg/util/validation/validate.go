@@ -34,6 +34,9 @@ const (
 	// ErrQueryTooLong is used in chunk store, querier and query frontend.
 	ErrQueryTooLong = "the query time range exceeds the limit (query length: %s, limit: %s)"
 
+	// ErrQueryBlocked is used in query frontend.
+	ErrQueryBlocked = "the query is blocked by the blocked queries limit (pattern: %s)"
+
 	missingMetricName       = "missing_metric_name"
 	invalidMetricName       = "metric_name_invalid"
 	greaterThanMaxSampleAge = "greater_than_max_sample_age"
@@ -256,6 +256,12 @@ func getFieldType(t reflect.Type) (string, error) {
 		return "string", nil
 	case "[]*relabel.Config":
 		return "relabel_config...", nil
+	case "[]validation.RetentionRule":
+		return "list of retention rules", nil
+	case "[]validation.QueryPriority":
+		return "list of query priorities", nil
+	case "[]validation.BlockedQuery":
+		return "list of blocked queries", nil
 	}
 
 	// Fallback to auto-detection of built-in data types